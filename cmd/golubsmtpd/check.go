@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/pawciobiel/golubsmtpd/internal/aliases"
+	"github.com/pawciobiel/golubsmtpd/internal/auth"
+	"github.com/pawciobiel/golubsmtpd/internal/config"
+	"github.com/pawciobiel/golubsmtpd/internal/delivery"
+	"github.com/pawciobiel/golubsmtpd/internal/queue"
+	"github.com/pawciobiel/golubsmtpd/internal/security"
+	"github.com/pawciobiel/golubsmtpd/internal/server"
+)
+
+// checkStep is a single validation performed by -check
+type checkStep struct {
+	name string
+	run  func(ctx context.Context, cfg *config.Config) error
+}
+
+var checkSteps = []checkStep{
+	{"spool directories", checkSpool},
+	{"authenticator", checkAuthenticator},
+	{"local aliases maps", checkAliases},
+	{"canonical maps", checkCanonicalMaps},
+	{"sender access map", checkSenderAccess},
+	{"recipient access map", checkRecipientAccess},
+	{"content checks", checkContentChecks},
+	{"TLS configuration", checkTLS},
+	{"DKIM signing key", checkDKIM},
+	{"listeners", checkListeners},
+}
+
+// runCheck validates everything the server needs at startup without serving
+// mail. Every step runs and reports its status to out; the returned error is
+// non-nil if any step failed.
+func runCheck(ctx context.Context, cfg *config.Config, out io.Writer) error {
+	failed := 0
+	for _, step := range checkSteps {
+		fmt.Fprintf(out, "Checking %s... ", step.name)
+		if err := step.run(ctx, cfg); err != nil {
+			fmt.Fprintf(out, "FAILED: %v\n", err)
+			failed++
+			continue
+		}
+		fmt.Fprintln(out, "OK")
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(checkSteps))
+	}
+	return nil
+}
+
+// checkSpool creates the spool directories and verifies the server can write to them
+func checkSpool(ctx context.Context, cfg *config.Config) error {
+	if err := queue.InitializeSpoolDirectories(cfg.Server.SpoolDir); err != nil {
+		return err
+	}
+	for _, state := range queue.GetRequiredSpoolDirectories() {
+		f, err := os.CreateTemp(filepath.Join(cfg.Server.SpoolDir, string(state)), ".check-*")
+		if err != nil {
+			return fmt.Errorf("spool directory not writable: %w", err)
+		}
+		f.Close()
+		os.Remove(f.Name())
+	}
+	return nil
+}
+
+func checkAuthenticator(ctx context.Context, cfg *config.Config) error {
+	authenticator, err := auth.CreateAuthenticator(ctx, &cfg.Auth)
+	if err != nil {
+		return err
+	}
+	authenticator.Close()
+	return nil
+}
+
+func checkAliases(ctx context.Context, cfg *config.Config) error {
+	return aliases.NewLocalAliasesMaps(cfg).LoadAliasesMaps(ctx)
+}
+
+func checkCanonicalMaps(ctx context.Context, cfg *config.Config) error {
+	return aliases.NewCanonicalMaps(cfg).LoadCanonicalMaps(ctx)
+}
+
+func checkSenderAccess(ctx context.Context, cfg *config.Config) error {
+	return aliases.NewAccessMaps("sender_access", cfg.Server.SenderAccessFilePath).LoadAccessMaps(ctx)
+}
+
+func checkRecipientAccess(ctx context.Context, cfg *config.Config) error {
+	return aliases.NewAccessMaps("recipient_access", cfg.Server.RecipientAccessFilePath).LoadAccessMaps(ctx)
+}
+
+func checkContentChecks(ctx context.Context, cfg *config.Config) error {
+	_, err := security.NewContentChecker(&cfg.Security.ContentChecks)
+	return err
+}
+
+// checkTLS loads the certificates and client CA file as the server does at startup
+func checkTLS(ctx context.Context, cfg *config.Config) error {
+	if !cfg.TLS.Enabled {
+		return nil
+	}
+	return server.CheckTLSConfig(&cfg.TLS)
+}
+
+// checkDKIM loads the DKIM private key as the queue does at startup
+func checkDKIM(ctx context.Context, cfg *config.Config) error {
+	if !cfg.Delivery.Outbound.DKIM.Enabled {
+		return nil
+	}
+	_, err := delivery.NewDKIMSigner(&cfg.Delivery.Outbound.DKIM)
+	return err
+}
+
+// checkListeners binds every configured listener and releases it immediately
+func checkListeners(ctx context.Context, cfg *config.Config) error {
+	for _, lcfg := range cfg.Server.Listeners {
+		addr := cfg.Server.ListenAddress(lcfg)
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			return fmt.Errorf("failed to listen on %s: %w", addr, err)
+		}
+		ln.Close()
+	}
+	return nil
+}
@@ -22,7 +22,9 @@ import (
 func main() {
 	var startupWG sync.WaitGroup
 	var configPath string
+	var checkOnly bool
 	flag.StringVar(&configPath, "config", "", "Path to configuration file")
+	flag.BoolVar(&checkOnly, "check", false, "Validate configuration and startup resources, then exit")
 	flag.Parse()
 
 	// Load configuration
@@ -31,6 +33,17 @@ func main() {
 		log.Fatal("Failed to load configuration:", err)
 	}
 
+	// Validate-only mode: exercise startup without serving
+	if checkOnly {
+		logging.InitLogging(&cfg.Logging)
+		if err := runCheck(context.Background(), cfg, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println("Configuration OK")
+		return
+	}
+
 	// Initialize spool directories
 	if err := queue.InitializeSpoolDirectories(cfg.Server.SpoolDir); err != nil {
 		log.Fatal("Failed to initialize spool directories:", err)
@@ -67,6 +80,14 @@ func main() {
 		}
 	})
 
+	var canonicalMaps *aliases.CanonicalMaps
+	var canonicalLoadError error
+
+	startupWG.Go(func() {
+		canonicalMaps = aliases.NewCanonicalMaps(cfg)
+		canonicalLoadError = canonicalMaps.LoadCanonicalMaps(ctx)
+	})
+
 	// Wait for all startup tasks to complete
 	startupWG.Wait()
 
@@ -75,20 +96,42 @@ func main() {
 		logger.Warn("Server starting without local aliases support", "error", aliasesLoadError)
 	}
 
+	if canonicalLoadError != nil {
+		logger.Warn("Server starting without address rewriting", "error", canonicalLoadError)
+		canonicalMaps = nil
+	}
+
 	// Create server
-	srv := server.New(cfg, authenticator, localAliasesMaps)
+	srv := server.New(cfg, authenticator, localAliasesMaps, canonicalMaps)
 
 	// Start server
 	if err := srv.Start(ctx); err != nil {
 		log.Fatal("Failed to start server:", err)
 	}
 
-	// Wait for shutdown signal
+	// Wait for shutdown signal or a SHUTDOWN control command; SIGHUP reloads
+	// the access maps, preloaded auth users and the TLS certificate
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	<-sigChan
-
-	logger.Info("Shutdown signal received")
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+wait:
+	for {
+		select {
+		case <-hupChan:
+			srv.ReloadAccessMaps(ctx) //nolint:errcheck // failure is logged and the old entries kept
+			srv.ReloadAuth(ctx)       //nolint:errcheck // failure is logged and the old users kept
+			if cfg.TLS.Enabled {
+				srv.ReloadTLS() //nolint:errcheck // failure is logged and the old certificate kept
+			}
+		case <-sigChan:
+			logger.Info("Shutdown signal received")
+			break wait
+		case <-srv.ShutdownRequested():
+			logger.Info("Shutdown requested")
+			break wait
+		}
+	}
 
 	// Graceful shutdown with timeout
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -1,14 +1,24 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/pawciobiel/golubsmtpd/internal/config"
+	"github.com/pawciobiel/golubsmtpd/internal/logging"
 	"github.com/pawciobiel/golubsmtpd/internal/queue"
 )
 
+func TestMain(m *testing.M) {
+	logging.InitTestLogging()
+	code := m.Run()
+	os.Exit(code)
+}
+
 func TestInitializeSpoolDirectories(t *testing.T) {
 	tempDir := t.TempDir()
 
@@ -128,3 +138,86 @@ func TestInitializeSpoolDirectoriesInvalidPath(t *testing.T) {
 		t.Fatal("Expected error for invalid path, got nil")
 	}
 }
+
+func newCheckConfig(t *testing.T) *config.Config {
+	t.Helper()
+
+	cfg := config.DefaultConfig()
+	cfg.Server.SpoolDir = t.TempDir()
+	cfg.Server.LocalAliasesFilePath = ""
+	cfg.Server.Bind = "127.0.0.1"
+	cfg.Server.Listeners = []config.ListenerConfig{{Port: 0, Mode: config.ListenerModePlain}}
+	cfg.Auth = config.AuthConfig{
+		PluginChain: []string{"memory"},
+		Plugins: map[string]map[string]interface{}{
+			"memory": {"users": []interface{}{
+				map[string]interface{}{"username": "user1", "password": "pass1"},
+			}},
+		},
+	}
+	return cfg
+}
+
+func TestRunCheck_OK(t *testing.T) {
+	cfg := newCheckConfig(t)
+
+	var out bytes.Buffer
+	if err := runCheck(context.Background(), cfg, &out); err != nil {
+		t.Fatalf("runCheck failed: %v\n%s", err, out.String())
+	}
+	if strings.Contains(out.String(), "FAILED") {
+		t.Errorf("Unexpected FAILED in output:\n%s", out.String())
+	}
+}
+
+func TestRunCheck_BadAuthConfig(t *testing.T) {
+	cfg := newCheckConfig(t)
+	cfg.Auth = config.AuthConfig{
+		PluginChain: []string{"unknown"},
+		Plugins:     map[string]map[string]interface{}{"unknown": {}},
+	}
+
+	var out bytes.Buffer
+	if err := runCheck(context.Background(), cfg, &out); err == nil {
+		t.Fatalf("Expected runCheck to fail with bad auth config, output:\n%s", out.String())
+	}
+	if !strings.Contains(out.String(), "Checking authenticator... FAILED") {
+		t.Errorf("Expected authenticator failure in output:\n%s", out.String())
+	}
+	// Remaining steps still run and report
+	if !strings.Contains(out.String(), "Checking listeners... OK") {
+		t.Errorf("Expected listeners check to run after auth failure:\n%s", out.String())
+	}
+}
+
+func TestRunCheck_BadDKIMKey(t *testing.T) {
+	cfg := newCheckConfig(t)
+	keyFile := filepath.Join(t.TempDir(), "dkim.key")
+	if err := os.WriteFile(keyFile, []byte("not a key"), 0o600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+	cfg.Delivery.Outbound.DKIM = config.DKIMConfig{Enabled: true, PrivateKeyFile: keyFile}
+
+	var out bytes.Buffer
+	if err := runCheck(context.Background(), cfg, &out); err == nil {
+		t.Fatalf("Expected runCheck to fail with a bad DKIM key, output:\n%s", out.String())
+	}
+	if !strings.Contains(out.String(), "Checking DKIM signing key... FAILED") {
+		t.Errorf("Expected DKIM failure in output:\n%s", out.String())
+	}
+}
+
+func TestCheckListeners_ListenerAddress(t *testing.T) {
+	cfg := newCheckConfig(t)
+	cfg.Server.Bind = "192.0.2.1" // TEST-NET, not assigned to this host
+	cfg.Server.Listeners = []config.ListenerConfig{{Address: "127.0.0.1", Port: 0, Mode: config.ListenerModePlain}}
+
+	if err := checkListeners(context.Background(), cfg); err != nil {
+		t.Errorf("checkListeners should bind the listener's own address: %v", err)
+	}
+
+	cfg.Server.Listeners[0].Address = ""
+	if err := checkListeners(context.Background(), cfg); err == nil {
+		t.Error("checkListeners should fail binding server.bind 192.0.2.1")
+	}
+}
@@ -10,14 +10,18 @@ import (
 	"os"
 	"strings"
 	"time"
+
+	"github.com/pawciobiel/golubsmtpd/internal/config"
 )
 
 const (
 	defaultSocketPath = "/var/run/golubsmtpd/golubsmtpd.sock"
+	defaultConfigPath = "/etc/golubsmtpd/golubsmtpd.yaml"
 )
 
 // SendmailArgs represents parsed command line arguments
 type SendmailArgs struct {
+	ConfigPath string
 	SocketPath string
 	From       string
 	To         []string
@@ -74,6 +78,7 @@ func parseArgs() (*SendmailArgs, error) {
 	}
 
 	// Define flags
+	flag.StringVar(&args.ConfigPath, "C", defaultConfigPath, "Path to golubsmtpd config file")
 	flag.StringVar(&args.SocketPath, "socket", defaultSocketPath, "Path to golubsmtpd socket")
 	flag.StringVar(&args.From, "f", "", "Set sender address")
 	flag.StringVar(&args.From, "from", "", "Set sender address (alias for -f)")
@@ -92,6 +97,26 @@ func parseArgs() (*SendmailArgs, error) {
 
 	flag.Parse()
 
+	// Explicit -socket always wins over the config file
+	socketSet := false
+	configSet := false
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "socket":
+			socketSet = true
+		case "C":
+			configSet = true
+		}
+	})
+
+	if !socketSet {
+		socketPath, err := resolveSocketPath(args.ConfigPath, configSet)
+		if err != nil {
+			return nil, err
+		}
+		args.SocketPath = socketPath
+	}
+
 	// Remaining arguments are recipients
 	args.To = append(args.To, flag.Args()...)
 
@@ -110,6 +135,30 @@ func parseArgs() (*SendmailArgs, error) {
 	return args, nil
 }
 
+// resolveSocketPath returns the socket path from the server config file.
+// The config at the default location is usually readable by root only, as it
+// holds passwords, so any failure to load it falls back to defaultSocketPath;
+// a config given explicitly with -C must load successfully.
+func resolveSocketPath(configPath string, required bool) (string, error) {
+	if configPath == "" {
+		return defaultSocketPath, nil
+	}
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		if !required {
+			return defaultSocketPath, nil
+		}
+		return "", fmt.Errorf("failed to load config %s: %w", configPath, err)
+	}
+
+	if cfg.Server.SocketPath == "" {
+		return "", fmt.Errorf("unix socket disabled in config %s (empty socket_path)", configPath)
+	}
+
+	return cfg.Server.SocketPath, nil
+}
+
 // readMessage reads the entire message from stdin
 func readMessage(reader io.Reader) (string, error) {
 	var builder strings.Builder
@@ -127,36 +176,64 @@ func readMessage(reader io.Reader) (string, error) {
 	return builder.String(), nil
 }
 
-// extractRecipients parses To:, Cc:, Bcc: headers from message and returns recipients and cleaned message
+// recipientHeaders are the header fields read by -t; the blind ones are
+// removed from the message
+var recipientHeaders = map[string]bool{
+	"to":         false,
+	"cc":         false,
+	"bcc":        true,
+	"resent-to":  false,
+	"resent-cc":  false,
+	"resent-bcc": true,
+}
+
+// extractRecipients parses To:, Cc:, Bcc: and their Resent- variants from the
+// message headers, unfolding continuation lines, and returns the recipients and
+// the message with Bcc headers removed. A message carrying any Resent-
+// recipient header is being re-sent, so only those are used, as sendmail does.
 func extractRecipients(message string) ([]string, string) {
 	lines := strings.Split(message, "\r\n")
 	recipients := make([]string, 0)
+	resentRecipients := make([]string, 0)
+	resent := false
 	cleanLines := make([]string, 0)
-	inHeaders := true
 
-	for _, line := range lines {
+	i := 0
+	for i < len(lines) {
 		// Empty line indicates end of headers
-		if inHeaders && line == "" {
-			inHeaders = false
-			cleanLines = append(cleanLines, line)
-			continue
+		if lines[i] == "" {
+			break
 		}
 
-		if inHeaders {
-			// Parse recipient headers
-			if strings.HasPrefix(strings.ToLower(line), "to:") {
-				recipients = append(recipients, parseAddressLine(line[3:])...)
-			} else if strings.HasPrefix(strings.ToLower(line), "cc:") {
-				recipients = append(recipients, parseAddressLine(line[3:])...)
-			} else if strings.HasPrefix(strings.ToLower(line), "bcc:") {
-				recipients = append(recipients, parseAddressLine(line[4:])...)
+		// A field runs until the next line not starting with whitespace
+		end := i + 1
+		for end < len(lines) && lines[end] != "" && (lines[end][0] == ' ' || lines[end][0] == '\t') {
+			end++
+		}
+		field := lines[i:end]
+		i = end
+
+		name, value, found := strings.Cut(strings.Join(field, ""), ":")
+		name = strings.ToLower(strings.TrimSpace(name))
+		blind, isRecipient := recipientHeaders[name]
+		if found && isRecipient {
+			if strings.HasPrefix(name, "resent-") {
+				resent = true
+				resentRecipients = append(resentRecipients, parseAddressLine(value)...)
+			} else {
+				recipients = append(recipients, parseAddressLine(value)...)
+			}
+			if blind {
 				continue // Remove Bcc: header from message
 			}
 		}
-
-		cleanLines = append(cleanLines, line)
+		cleanLines = append(cleanLines, field...)
 	}
+	cleanLines = append(cleanLines, lines[i:]...)
 
+	if resent {
+		recipients = resentRecipients
+	}
 	return recipients, strings.Join(cleanLines, "\r\n")
 }
 
@@ -255,13 +332,9 @@ func sendMessage(args *SendmailArgs, message string) error {
 		fmt.Fprintf(os.Stderr, "sendmail: sending message data (%d bytes)\n", len(message))
 	}
 
-	// Send message followed by termination sequence
-	if err := textConn.PrintfLine("%s", message); err != nil {
-		return fmt.Errorf("failed to send message data: %w", err)
-	}
-
-	if err := textConn.PrintfLine("."); err != nil {
-		return fmt.Errorf("failed to send message termination: %w", err)
+	// Send dot-stuffed message followed by termination sequence
+	if err := writeMessageData(&textConn.Writer, message); err != nil {
+		return err
 	}
 
 	// Read final response
@@ -280,6 +353,27 @@ func sendMessage(args *SendmailArgs, message string) error {
 	return nil
 }
 
+// writeMessageData sends the message line by line, doubling any leading dot
+// (RFC 5321 §4.5.2), and terminates DATA with a lone "."
+func writeMessageData(w *textproto.Writer, message string) error {
+	message = strings.TrimSuffix(message, "\r\n")
+
+	for _, line := range strings.Split(message, "\r\n") {
+		if strings.HasPrefix(line, ".") {
+			line = "." + line
+		}
+		if err := w.PrintfLine("%s", line); err != nil {
+			return fmt.Errorf("failed to send message data: %w", err)
+		}
+	}
+
+	if err := w.PrintfLine("."); err != nil {
+		return fmt.Errorf("failed to send message termination: %w", err)
+	}
+
+	return nil
+}
+
 // readResponse reads and validates SMTP response
 func readResponse(conn *textproto.Conn, verbose bool) (string, error) {
 	response, err := conn.ReadLine()
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveSocketPathFromConfig(t *testing.T) {
+	tempDir := t.TempDir()
+	configPath := filepath.Join(tempDir, "golubsmtpd.yaml")
+	customSocket := filepath.Join(tempDir, "custom.sock")
+
+	content := "server:\n  socket_path: \"" + customSocket + "\"\n"
+	if err := os.WriteFile(configPath, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	socketPath, err := resolveSocketPath(configPath, true)
+	if err != nil {
+		t.Fatalf("resolveSocketPath failed: %v", err)
+	}
+	if socketPath != customSocket {
+		t.Errorf("socket path mismatch: want %q, got %q", customSocket, socketPath)
+	}
+}
+
+func TestResolveSocketPathMissingDefaultConfig(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing.yaml")
+
+	socketPath, err := resolveSocketPath(missing, false)
+	if err != nil {
+		t.Fatalf("missing default config should not fail: %v", err)
+	}
+	if socketPath != defaultSocketPath {
+		t.Errorf("socket path mismatch: want %q, got %q", defaultSocketPath, socketPath)
+	}
+}
+
+func TestResolveSocketPathUnreadableDefaultConfig(t *testing.T) {
+	// A directory stands in for a root-only config: it stats but cannot be loaded
+	unreadable := t.TempDir()
+
+	socketPath, err := resolveSocketPath(unreadable, false)
+	if err != nil {
+		t.Fatalf("unreadable default config should not fail: %v", err)
+	}
+	if socketPath != defaultSocketPath {
+		t.Errorf("socket path mismatch: want %q, got %q", defaultSocketPath, socketPath)
+	}
+}
+
+func TestResolveSocketPathMissingExplicitConfig(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing.yaml")
+
+	if _, err := resolveSocketPath(missing, true); err == nil {
+		t.Fatal("Expected error for missing explicit config, got nil")
+	}
+}
+
+func TestWriteMessageDataDotStuffing(t *testing.T) {
+	var buf bytes.Buffer
+	bw := bufio.NewWriter(&buf)
+
+	message := "Subject: test\r\n\r\nHello\r\n.signature\r\n.\r\nbye\r\n"
+	if err := writeMessageData(textproto.NewWriter(bw), message); err != nil {
+		t.Fatalf("writeMessageData failed: %v", err)
+	}
+
+	want := "Subject: test\r\n\r\nHello\r\n..signature\r\n..\r\nbye\r\n.\r\n"
+	if got := buf.String(); got != want {
+		t.Errorf("transmitted data mismatch:\nwant: %q\ngot:  %q", want, got)
+	}
+	if !strings.Contains(buf.String(), "\r\n..signature\r\n") {
+		t.Errorf("expected dot-stuffed ..signature line, got %q", buf.String())
+	}
+}
+
+func TestExtractRecipients(t *testing.T) {
+	tests := []struct {
+		name           string
+		message        string
+		wantRecipients []string
+		wantMessage    string
+	}{
+		{
+			name:           "folded To header",
+			message:        "To: alice@example.com,\r\n bob@example.com,\r\n\t\"Carol\" <carol@example.com>\r\nSubject: hi\r\n\r\nbody\r\n",
+			wantRecipients: []string{"alice@example.com", "bob@example.com", "carol@example.com"},
+			wantMessage:    "To: alice@example.com,\r\n bob@example.com,\r\n\t\"Carol\" <carol@example.com>\r\nSubject: hi\r\n\r\nbody\r\n",
+		},
+		{
+			name:           "Resent-To and Resent-Cc",
+			message:        "Resent-To: dave@example.com\r\nResent-Cc: erin@example.com\r\nSubject: fwd\r\n\r\nbody\r\n",
+			wantRecipients: []string{"dave@example.com", "erin@example.com"},
+			wantMessage:    "Resent-To: dave@example.com\r\nResent-Cc: erin@example.com\r\nSubject: fwd\r\n\r\nbody\r\n",
+		},
+		{
+			name:           "Resent headers replace the original recipients",
+			message:        "To: alice@example.com\r\nCc: bob@example.com\r\nResent-To: dave@example.com\r\nResent-Bcc: frank@example.com\r\nSubject: fwd\r\n\r\nbody\r\n",
+			wantRecipients: []string{"dave@example.com", "frank@example.com"},
+			wantMessage:    "To: alice@example.com\r\nCc: bob@example.com\r\nResent-To: dave@example.com\r\nSubject: fwd\r\n\r\nbody\r\n",
+		},
+		{
+			name:           "folded Bcc removed entirely",
+			message:        "To: alice@example.com\r\nBcc: secret@example.com,\r\n  hidden@example.com\r\nSubject: hi\r\n\r\nBcc: not a header\r\n",
+			wantRecipients: []string{"alice@example.com", "secret@example.com", "hidden@example.com"},
+			wantMessage:    "To: alice@example.com\r\nSubject: hi\r\n\r\nBcc: not a header\r\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recipients, message := extractRecipients(tt.message)
+			if strings.Join(recipients, " ") != strings.Join(tt.wantRecipients, " ") {
+				t.Errorf("recipients = %q, want %q", recipients, tt.wantRecipients)
+			}
+			if message != tt.wantMessage {
+				t.Errorf("message mismatch:\nwant: %q\ngot:  %q", tt.wantMessage, message)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package aliases
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// AccessVerdict is the outcome of an access map lookup
+type AccessVerdict int
+
+const (
+	AccessNone   AccessVerdict = iota // no entry matched
+	AccessOK                          // explicitly allowed
+	AccessReject                      // explicitly denied
+)
+
+// AccessMaps holds an access map file (sender_access, recipient_access).
+//
+// Each line maps an address or domain to an action, separated by whitespace:
+//
+//	spammer@example.com   reject   # exact address
+//	example.com           reject   # whole domain
+//	@example.net          reject   # whole domain, alternative form
+//	boss@example.com      ok       # allowed despite the domain entry
+//
+// Exact address entries take precedence over domain entries.
+type AccessMaps struct {
+	name     string // map name for logs, e.g. "sender_access"
+	filePath string
+	entries  map[string]AccessVerdict // lowercased address or "@domain" -> verdict
+	mu       sync.RWMutex
+}
+
+// NewAccessMaps creates an access map read from filePath; an empty path
+// disables it
+func NewAccessMaps(name, filePath string) *AccessMaps {
+	return &AccessMaps{
+		name:     name,
+		filePath: filePath,
+		entries:  make(map[string]AccessVerdict),
+	}
+}
+
+// LoadAccessMaps (re)loads the entries from the file. On error the current
+// entries are kept, so a broken edit does not drop a working map.
+func (am *AccessMaps) LoadAccessMaps(ctx context.Context) error {
+	if am.filePath == "" {
+		log().Info("No access map file configured", "map", am.name)
+		return nil
+	}
+
+	entries, err := parseAccessFile(ctx, am.filePath)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s file: %w", am.name, err)
+	}
+
+	am.mu.Lock()
+	am.entries = entries
+	am.mu.Unlock()
+
+	log().Info("Access map loaded successfully",
+		"map", am.name,
+		"file", am.filePath,
+		"entries", len(entries))
+	return nil
+}
+
+// parseAccessFile parses "key action" lines, skipping comments and malformed entries
+func parseAccessFile(ctx context.Context, filePath string) (map[string]AccessVerdict, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open access map file: %w", err)
+	}
+	defer file.Close()
+
+	entries := make(map[string]AccessVerdict)
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+
+	for scanner.Scan() {
+		lineNum++
+
+		if lineNum%10 == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i != -1 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		var verdict AccessVerdict
+		if len(fields) == 2 {
+			switch strings.ToLower(fields[1]) {
+			case "ok":
+				verdict = AccessOK
+			case "reject":
+				verdict = AccessReject
+			}
+		}
+		if verdict == AccessNone {
+			log().Debug("Invalid access map line format, skipping",
+				"file", filePath,
+				"line", lineNum,
+				"content", line)
+			continue
+		}
+
+		key := strings.ToLower(fields[0])
+		if !strings.Contains(key, "@") {
+			key = "@" + key
+		}
+		entries[key] = verdict
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading access map file at line %d: %w", lineNum, err)
+	}
+
+	return entries, nil
+}
+
+// Lookup returns the verdict for address, trying the exact address before its
+// domain. A nil AccessMaps never matches.
+func (am *AccessMaps) Lookup(address string) AccessVerdict {
+	if am == nil {
+		return AccessNone
+	}
+
+	at := strings.LastIndex(address, "@")
+	if at == -1 {
+		return AccessNone
+	}
+
+	am.mu.RLock()
+	defer am.mu.RUnlock()
+
+	if verdict, ok := am.entries[strings.ToLower(address)]; ok {
+		return verdict
+	}
+	return am.entries[strings.ToLower(address[at:])]
+}
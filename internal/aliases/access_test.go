@@ -0,0 +1,75 @@
+package aliases
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAccessMaps_Lookup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access")
+	content := `# sender access
+spammer@example.com   REJECT
+example.com           reject
+@example.net          reject   # alternative domain form
+boss@example.com      ok
+not-an-entry
+bad@example.org       maybe
+`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to write access map file: %v", err)
+	}
+	am := NewAccessMaps("sender_access", path)
+	if err := am.LoadAccessMaps(context.Background()); err != nil {
+		t.Fatalf("LoadAccessMaps failed: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		address string
+		want    AccessVerdict
+	}{
+		{"exact address", "spammer@example.com", AccessReject},
+		{"domain", "anyone@Example.COM", AccessReject},
+		{"at-domain form", "anyone@example.net", AccessReject},
+		{"exact ok wins over domain", "boss@example.com", AccessOK},
+		{"unknown action ignored", "bad@example.org", AccessNone},
+		{"no match", "alice@example.org", AccessNone},
+		{"null sender", "", AccessNone},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := am.Lookup(tt.address); got != tt.want {
+				t.Errorf("Lookup(%q) = %v, want %v", tt.address, got, tt.want)
+			}
+		})
+	}
+
+	var nilMaps *AccessMaps
+	if got := nilMaps.Lookup("spammer@example.com"); got != AccessNone {
+		t.Errorf("Lookup on nil maps: got %v", got)
+	}
+}
+
+func TestAccessMaps_ReloadKeepsEntriesOnError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access")
+	if err := os.WriteFile(path, []byte("example.com reject\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write access map file: %v", err)
+	}
+	am := NewAccessMaps("sender_access", path)
+	if err := am.LoadAccessMaps(context.Background()); err != nil {
+		t.Fatalf("LoadAccessMaps failed: %v", err)
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("Failed to remove access map file: %v", err)
+	}
+	if err := am.LoadAccessMaps(context.Background()); err == nil {
+		t.Fatal("Expected error reloading a missing access map file")
+	}
+	if got := am.Lookup("anyone@example.com"); got != AccessReject {
+		t.Errorf("Lookup after failed reload = %v, want the previous entry", got)
+	}
+}
@@ -0,0 +1,140 @@
+package aliases
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/pawciobiel/golubsmtpd/internal/config"
+)
+
+// CanonicalMaps rewrites addresses from a canonical_maps file.
+//
+// Each line maps a source to a target, separated by whitespace:
+//
+//	alice@localhost      alice@public.example.com   # exact address
+//	@internal            @public.example.com        # whole domain, local part kept
+//	@legacy.example.com  postmaster@example.com     # whole domain to one address
+//
+// Exact address entries take precedence over domain entries.
+type CanonicalMaps struct {
+	config  *config.Config
+	entries map[string]string // lowercased source -> target
+	mu      sync.RWMutex
+}
+
+// NewCanonicalMaps creates a new canonical maps manager
+func NewCanonicalMaps(cfg *config.Config) *CanonicalMaps {
+	return &CanonicalMaps{
+		config:  cfg,
+		entries: make(map[string]string),
+	}
+}
+
+// LoadCanonicalMaps loads rewrite entries from the configured file at startup
+func (cm *CanonicalMaps) LoadCanonicalMaps(ctx context.Context) error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	filePath := cm.config.Server.CanonicalMapsFilePath
+
+	// Empty file path means address rewriting is disabled
+	if filePath == "" {
+		cm.entries = make(map[string]string)
+		log().Info("No canonical maps file configured")
+		return nil
+	}
+
+	entries, err := parseCanonicalFile(ctx, filePath)
+	if err != nil {
+		return fmt.Errorf("failed to parse canonical maps file: %w", err)
+	}
+
+	cm.entries = entries
+	log().Info("Canonical maps loaded successfully",
+		"file", filePath,
+		"entries", len(entries))
+
+	return nil
+}
+
+// parseCanonicalFile parses "source target" lines, skipping comments and malformed entries
+func parseCanonicalFile(ctx context.Context, filePath string) (map[string]string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open canonical maps file: %w", err)
+	}
+	defer file.Close()
+
+	entries := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+
+	for scanner.Scan() {
+		lineNum++
+
+		if lineNum%10 == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i != -1 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		if len(fields) != 2 || !strings.Contains(fields[0], "@") || !strings.Contains(fields[1], "@") {
+			log().Debug("Invalid canonical maps line format, skipping",
+				"file", filePath,
+				"line", lineNum,
+				"content", line)
+			continue
+		}
+
+		entries[strings.ToLower(fields[0])] = fields[1]
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading canonical maps file at line %d: %w", lineNum, err)
+	}
+
+	return entries, nil
+}
+
+// Rewrite returns the canonical form of address, or address unchanged when no
+// entry matches. A nil CanonicalMaps never rewrites.
+func (cm *CanonicalMaps) Rewrite(address string) string {
+	if cm == nil {
+		return address
+	}
+
+	at := strings.LastIndex(address, "@")
+	if at == -1 {
+		return address
+	}
+
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	target, ok := cm.entries[strings.ToLower(address)]
+	if !ok {
+		target, ok = cm.entries[strings.ToLower(address[at:])]
+	}
+	if !ok {
+		return address
+	}
+
+	// "@domain" targets keep the original local part
+	if strings.HasPrefix(target, "@") {
+		return address[:at] + target
+	}
+	return target
+}
@@ -0,0 +1,79 @@
+package aliases
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pawciobiel/golubsmtpd/internal/config"
+)
+
+func newTestCanonicalMaps(t *testing.T, content string) *CanonicalMaps {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "canonical")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to write canonical maps file: %v", err)
+	}
+
+	cm := NewCanonicalMaps(&config.Config{Server: config.ServerConfig{CanonicalMapsFilePath: path}})
+	if err := cm.LoadCanonicalMaps(context.Background()); err != nil {
+		t.Fatalf("LoadCanonicalMaps failed: %v", err)
+	}
+	return cm
+}
+
+func TestCanonicalMaps_Rewrite(t *testing.T) {
+	cm := newTestCanonicalMaps(t, `# sender rewriting
+alice@localhost        alice.smith@public.example.com
+@internal              @public.example.com
+@legacy.example.com    postmaster@example.com   # catch-all
+bob@internal           robert@public.example.com
+not-an-entry
+`)
+
+	tests := []struct {
+		name    string
+		address string
+		want    string
+	}{
+		{"exact address", "alice@localhost", "alice.smith@public.example.com"},
+		{"exact address case-insensitive", "Alice@LOCALHOST", "alice.smith@public.example.com"},
+		{"domain keeps local part", "carol@internal", "carol@public.example.com"},
+		{"domain to single address", "anyone@legacy.example.com", "postmaster@example.com"},
+		{"exact wins over domain", "bob@internal", "robert@public.example.com"},
+		{"no match unchanged", "dave@example.org", "dave@example.org"},
+		{"null sender unchanged", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cm.Rewrite(tt.address); got != tt.want {
+				t.Errorf("Rewrite(%q) = %q, want %q", tt.address, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCanonicalMaps_Disabled(t *testing.T) {
+	cm := NewCanonicalMaps(&config.Config{})
+	if err := cm.LoadCanonicalMaps(context.Background()); err != nil {
+		t.Fatalf("LoadCanonicalMaps with no file should succeed, got %v", err)
+	}
+	if got := cm.Rewrite("alice@localhost"); got != "alice@localhost" {
+		t.Errorf("Rewrite with no file: got %q", got)
+	}
+
+	var nilMaps *CanonicalMaps
+	if got := nilMaps.Rewrite("alice@localhost"); got != "alice@localhost" {
+		t.Errorf("Rewrite on nil maps: got %q", got)
+	}
+}
+
+func TestLoadCanonicalMaps_MissingFile(t *testing.T) {
+	cm := NewCanonicalMaps(&config.Config{Server: config.ServerConfig{CanonicalMapsFilePath: "/nonexistent/canonical"}})
+	if err := cm.LoadCanonicalMaps(context.Background()); err == nil {
+		t.Error("Expected error for missing canonical maps file")
+	}
+}
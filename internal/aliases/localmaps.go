@@ -9,7 +9,6 @@ import (
 	"sort"
 	"strings"
 	"sync"
-	"time"
 
 	"github.com/pawciobiel/golubsmtpd/internal/auth"
 	"github.com/pawciobiel/golubsmtpd/internal/config"
@@ -18,6 +17,13 @@ import (
 
 var log = logging.GetLogger
 
+// defaultLookupWorkers is the number of concurrent user lookups used when
+// server.local_aliases_lookup_workers is not set
+const defaultLookupWorkers = 8
+
+// lookupUser resolves a system user; replaced in tests
+var lookupUser = user.Lookup
+
 // LocalAliasesMaps manages local domain aliases mapping from configured file
 type LocalAliasesMaps struct {
 	config  *config.Config
@@ -56,36 +62,49 @@ func (lam *LocalAliasesMaps) LoadAliasesMaps(ctx context.Context) error {
 		return fmt.Errorf("failed to stat aliases file %s: %w", filePath, err)
 	}
 
-	// Add 3-second timeout for parsing
-	parseCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
-	defer cancel()
+	// Parsing and validation share one time limit; the current aliases are
+	// kept when it runs out
+	loadCtx := ctx
+	if timeout := lam.config.Server.LocalAliasesLoadTimeout; timeout > 0 {
+		var cancel context.CancelFunc
+		loadCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
 
-	// Parse the file with timeout
-	rawAliases, err := lam.parseAliasesFile(parseCtx, filePath)
+	rawAliases, err := lam.parseAliasesFile(loadCtx, filePath)
 	if err != nil {
-		if parseCtx.Err() == context.DeadlineExceeded {
-			panic(fmt.Sprintf("CRITICAL: Failed to parse aliases file %s within 3 seconds - file too large or I/O issues", filePath))
+		if loadCtx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("aliases file %s not parsed within %s: %w", filePath, lam.config.Server.LocalAliasesLoadTimeout, err)
 		}
 		return fmt.Errorf("failed to parse aliases file: %w", err)
 	}
 
-	// Validate all aliases and their destinations
+	workers := lam.config.Server.LocalAliasesLookupWorkers
+	if workers <= 0 {
+		workers = defaultLookupWorkers
+	}
+	validDests, err := validateDestinations(loadCtx, rawAliases, workers)
+	if err != nil {
+		if loadCtx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("aliases file %s not validated within %s: %w", filePath, lam.config.Server.LocalAliasesLoadTimeout, err)
+		}
+		return fmt.Errorf("failed to validate aliases: %w", err)
+	}
+
+	// Keep the destinations whose user exists
 	validatedAliases := make(map[string][]string)
 	for alias, destinations := range rawAliases {
 		validDestinations := make([]string, 0, len(destinations))
 
 		for _, dest := range destinations {
-			username := auth.ExtractUsername(dest)
-
-			// Validate destination user exists
-			if _, err := user.Lookup(username); err == nil {
+			if validDests[dest] {
 				validDestinations = append(validDestinations, dest)
 			} else {
 				// Log invalid destination but continue processing other destinations
 				log().Warn("Invalid alias destination - user not found",
 					"alias", alias,
 					"destination", dest,
-					"username", username)
+					"username", auth.ExtractUsername(dest))
 			}
 		}
 
@@ -117,6 +136,82 @@ func (lam *LocalAliasesMaps) LoadAliasesMaps(ctx context.Context) error {
 	return nil
 }
 
+// NormalizeDestination turns a bare username into a localhost address
+func NormalizeDestination(dest string) string {
+	if !strings.Contains(dest, "@") {
+		return dest + "@localhost"
+	}
+	return dest
+}
+
+// validateDestinations looks up the user of every distinct alias destination,
+// running up to workers lookups at once, and returns which exist. A lookup
+// cannot be interrupted, so on ctx expiry the lookups still running are left
+// to finish in the background.
+func validateDestinations(ctx context.Context, rawAliases map[string][]string, workers int) (map[string]bool, error) {
+	var dests []string
+	seen := make(map[string]bool)
+	for _, destinations := range rawAliases {
+		for _, dest := range destinations {
+			if !seen[dest] {
+				seen[dest] = true
+				dests = append(dests, dest)
+			}
+		}
+	}
+
+	valid := make([]bool, len(dests))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range min(workers, len(dests)) {
+		wg.Go(func() {
+			for i := range jobs {
+				valid[i] = ValidateLocalDestination(dests[i]) == nil
+			}
+		})
+	}
+
+	go func() {
+		defer close(jobs)
+		for i := range dests {
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]bool, len(dests))
+	for i, dest := range dests {
+		result[dest] = valid[i]
+	}
+	return result, nil
+}
+
+// ValidateLocalDestination checks that a local destination maps to an existing system user
+func ValidateLocalDestination(dest string) error {
+	username := auth.ExtractUsername(dest)
+	if _, err := lookupUser(username); err != nil {
+		return fmt.Errorf("user %q not found: %w", username, err)
+	}
+	return nil
+}
+
 // parseAliasesFile parses /etc/aliases format file with timeout protection
 func (lam *LocalAliasesMaps) parseAliasesFile(ctx context.Context, filePath string) (map[string][]string, error) {
 	file, err := os.Open(filePath)
@@ -128,6 +223,7 @@ func (lam *LocalAliasesMaps) parseAliasesFile(ctx context.Context, filePath stri
 	aliases := make(map[string][]string)
 	scanner := bufio.NewScanner(file)
 	lineNum := 0
+	lastAlias := "" // alias that continuation lines extend
 
 	for scanner.Scan() {
 		lineNum++
@@ -144,13 +240,28 @@ func (lam *LocalAliasesMaps) parseAliasesFile(ctx context.Context, filePath stri
 			}
 		}
 
-		line := strings.TrimSpace(scanner.Text())
+		rawLine := scanner.Text()
+		line := strings.TrimSpace(rawLine)
 
-		// Skip empty lines and comments
+		// Skip empty lines and comments; they do not end a continued alias
 		if line == "" || strings.HasPrefix(line, "#") {
 			continue
 		}
 
+		// A line starting with whitespace continues the previous alias's recipient list
+		if rawLine[0] == ' ' || rawLine[0] == '\t' {
+			if lastAlias == "" {
+				log().Debug("Continuation line without alias, skipping",
+					"file", filePath,
+					"line", lineNum,
+					"content", line)
+				continue
+			}
+			aliases[lastAlias] = append(aliases[lastAlias], parseAliasRecipients(line)...)
+			continue
+		}
+		lastAlias = ""
+
 		// Parse alias line: alias: user1,user2,user3
 		colonIndex := strings.Index(line, ":")
 		if colonIndex == -1 {
@@ -169,44 +280,41 @@ func (lam *LocalAliasesMaps) parseAliasesFile(ctx context.Context, filePath stri
 			continue
 		}
 
-		// Parse recipients (comma or space separated)
-		recipientsPart := strings.TrimSpace(line[colonIndex+1:])
-		if recipientsPart == "" {
+		// Recipients may be empty here and follow on continuation lines
+		aliases[alias] = parseAliasRecipients(line[colonIndex+1:])
+		lastAlias = alias
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading aliases file at line %d: %w", lineNum, err)
+	}
+
+	for alias, recipients := range aliases {
+		if len(recipients) == 0 {
 			log().Debug("Empty alias recipients, skipping",
 				"file", filePath,
-				"line", lineNum,
 				"alias", alias)
+			delete(aliases, alias)
 			continue
 		}
+		log().Debug("Parsed alias",
+			"alias", alias,
+			"recipients", recipients)
+	}
 
-		// Split by comma first, then by spaces
-		var recipients []string
-		for _, part := range strings.Split(recipientsPart, ",") {
-			for _, recipient := range strings.Fields(strings.TrimSpace(part)) {
-				recipient = strings.TrimSpace(recipient)
-				if recipient != "" {
-					// Ensure recipient is properly formatted as email
-					if !strings.Contains(recipient, "@") {
-						recipient = recipient + "@localhost"
-					}
-					recipients = append(recipients, recipient)
-				}
-			}
-		}
+	return aliases, nil
+}
 
-		if len(recipients) > 0 {
-			aliases[alias] = recipients
-			log().Debug("Parsed alias",
-				"alias", alias,
-				"recipients", recipients)
+// parseAliasRecipients splits a comma or space separated recipient list,
+// normalizing bare usernames to local addresses
+func parseAliasRecipients(list string) []string {
+	var recipients []string
+	for _, part := range strings.Split(list, ",") {
+		for _, recipient := range strings.Fields(part) {
+			recipients = append(recipients, NormalizeDestination(recipient))
 		}
 	}
-
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("error reading aliases file at line %d: %w", lineNum, err)
-	}
-
-	return aliases, nil
+	return recipients
 }
 
 // ResolveAlias resolves an alias to its pre-validated recipients (fast lookup)
@@ -2,10 +2,13 @@ package aliases
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/user"
 	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -136,6 +139,45 @@ mailer-daemon: %s
 	}
 }
 
+func TestLoadAliasesMaps_ContinuationLines(t *testing.T) {
+	tmpDir := t.TempDir()
+	aliasesFile := filepath.Join(tmpDir, "aliases")
+	currentUser := getCurrentUser(t)
+
+	aliasesContent := fmt.Sprintf(`staff: %s,
+	%s,
+  # comments inside a continued alias are ignored
+	%s
+  %s
+abuse: %s
+`, currentUser, currentUser, currentUser, currentUser, currentUser)
+
+	if err := os.WriteFile(aliasesFile, []byte(aliasesContent), 0644); err != nil {
+		t.Fatalf("Failed to create test aliases file: %v", err)
+	}
+
+	cfg := &config.Config{
+		Server: config.ServerConfig{
+			LocalAliasesFilePath: aliasesFile,
+		},
+	}
+	aliasesMaps := NewLocalAliasesMaps(cfg)
+	if err := aliasesMaps.LoadAliasesMaps(context.Background()); err != nil {
+		t.Fatalf("LoadAliasesMaps failed: %v", err)
+	}
+
+	local := currentUser + "@localhost"
+	expected := []string{local, local, local, local}
+	if diff := cmp.Diff(expected, aliasesMaps.ResolveAlias("staff")); diff != "" {
+		t.Errorf("Continued alias mismatch (-want +got):\n%s", diff)
+	}
+
+	// The next non-indented line starts a new alias
+	if diff := cmp.Diff([]string{local}, aliasesMaps.ResolveAlias("abuse")); diff != "" {
+		t.Errorf("Alias after continuation mismatch (-want +got):\n%s", diff)
+	}
+}
+
 // getCurrentUser returns current username for testing
 func getCurrentUser(t *testing.T) string {
 	t.Helper()
@@ -285,4 +327,108 @@ webmaster: %s
 	if diff := cmp.Diff(expected, aliases); diff != "" {
 		t.Errorf("Case-sensitive lookup should fail for different case (-want +got):\n%s", diff)
 	}
-}
\ No newline at end of file
+}
+// stubLookupUser replaces the system user lookup for the duration of the test
+func stubLookupUser(t *testing.T, lookup func(string) (*user.User, error)) {
+	t.Helper()
+	orig := lookupUser
+	lookupUser = lookup
+	t.Cleanup(func() { lookupUser = orig })
+}
+
+// writeSyntheticAliases writes count aliases, each to its own user
+func writeSyntheticAliases(t *testing.T, count int) string {
+	t.Helper()
+	var content strings.Builder
+	for i := range count {
+		fmt.Fprintf(&content, "alias%d: user%d\n", i, i)
+	}
+	path := filepath.Join(t.TempDir(), "aliases")
+	if err := os.WriteFile(path, []byte(content.String()), 0o644); err != nil {
+		t.Fatalf("Failed to create test aliases file: %v", err)
+	}
+	return path
+}
+
+func TestLoadAliasesMaps_LargeFileParallelLookups(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int32
+	stubLookupUser(t, func(username string) (*user.User, error) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			peak := maxInFlight.Load()
+			if n <= peak || maxInFlight.CompareAndSwap(peak, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		if username == "user13" {
+			return nil, user.UnknownUserError(username)
+		}
+		return &user.User{Username: username}, nil
+	})
+
+	cfg := &config.Config{
+		Server: config.ServerConfig{
+			LocalAliasesFilePath:      writeSyntheticAliases(t, 5000),
+			LocalAliasesLoadTimeout:   30 * time.Second,
+			LocalAliasesLookupWorkers: 16,
+		},
+	}
+	aliasesMaps := NewLocalAliasesMaps(cfg)
+
+	if err := aliasesMaps.LoadAliasesMaps(context.Background()); err != nil {
+		t.Fatalf("LoadAliasesMaps failed: %v", err)
+	}
+
+	if diff := cmp.Diff([]string{"user4999@localhost"}, aliasesMaps.ResolveAlias("alias4999")); diff != "" {
+		t.Errorf("alias4999 mismatch (-want +got):\n%s", diff)
+	}
+	if got := aliasesMaps.ResolveAlias("alias13"); got != nil {
+		t.Errorf("alias13 has no existing user, want nil, got %v", got)
+	}
+	if peak := maxInFlight.Load(); peak < 2 || peak > 16 {
+		t.Errorf("concurrent lookups peaked at %d, want between 2 and 16", peak)
+	}
+}
+
+func TestLoadAliasesMaps_ValidationTimeout(t *testing.T) {
+	currentUser := getCurrentUser(t)
+	cfg := &config.Config{
+		Server: config.ServerConfig{
+			LocalAliasesFilePath:    writeSyntheticAliases(t, 100),
+			LocalAliasesLoadTimeout: 50 * time.Millisecond,
+		},
+	}
+	aliasesMaps := NewLocalAliasesMaps(cfg)
+	aliasesMaps.aliases = map[string][]string{"postmaster": {currentUser + "@localhost"}}
+
+	// Lookups hang, as with an unreachable NSS backend, until the test ends
+	entered := make(chan struct{}, 100)
+	release := make(chan struct{})
+	stubLookupUser(t, func(username string) (*user.User, error) {
+		entered <- struct{}{}
+		<-release
+		return nil, user.UnknownUserError(username)
+	})
+	t.Cleanup(func() {
+		// Wait until every worker is inside the stub before it is restored
+		for range defaultLookupWorkers {
+			<-entered
+		}
+		close(release)
+	})
+
+	err := aliasesMaps.LoadAliasesMaps(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "not validated within 50ms") {
+		t.Fatalf("LoadAliasesMaps: want validation timeout error, got %v", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("LoadAliasesMaps error should wrap context.DeadlineExceeded: %v", err)
+	}
+
+	// The aliases loaded before are kept
+	if diff := cmp.Diff([]string{currentUser + "@localhost"}, aliasesMaps.ResolveAlias("postmaster")); diff != "" {
+		t.Errorf("previous aliases mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -189,6 +189,35 @@ func (c *AuthChain) Name() string {
 	return fmt.Sprintf("chain[%s]", strings.Join(names, ","))
 }
 
+// Capabilities combines the plugins' capabilities: plaintext retrieval only
+// when every plugin can answer it, user validation and remoteness when any
+// plugin has them
+func (c *AuthChain) Capabilities() AuthCapabilities {
+	caps := AuthCapabilities{SupportsPlaintext: len(c.plugins) > 0}
+	for _, plugin := range c.plugins {
+		pc := plugin.Capabilities()
+		caps.SupportsPlaintext = caps.SupportsPlaintext && pc.SupportsPlaintext
+		caps.SupportsUserValidation = caps.SupportsUserValidation || pc.SupportsUserValidation
+		caps.IsRemote = caps.IsRemote || pc.IsRemote
+	}
+	return caps
+}
+
+// Reload refreshes every plugin that implements Reloader, stopping at the
+// first failure
+func (c *AuthChain) Reload(ctx context.Context) error {
+	for _, plugin := range c.plugins {
+		reloader, ok := plugin.(Reloader)
+		if !ok {
+			continue
+		}
+		if err := reloader.Reload(ctx); err != nil {
+			return fmt.Errorf("plugin '%s': %w", plugin.Name(), err)
+		}
+	}
+	return nil
+}
+
 // Close cleans up all plugins in the chain
 func (c *AuthChain) Close() error {
 	for _, plugin := range c.plugins {
@@ -205,3 +234,19 @@ func (c *AuthChain) Close() error {
 func (c *AuthChain) GetStats() (attempts, successes int64) {
 	return atomic.LoadInt64(&c.authCount), atomic.LoadInt64(&c.successCount)
 }
+
+// PerPluginStats returns the counters of each plugin keyed by plugin name,
+// showing which backend authenticates users. Plugins that do not implement
+// StatsProvider are left out.
+func (c *AuthChain) PerPluginStats() map[string]PluginStats {
+	stats := make(map[string]PluginStats, len(c.plugins))
+	for _, plugin := range c.plugins {
+		provider, ok := plugin.(StatsProvider)
+		if !ok {
+			continue
+		}
+		attempts, successes := provider.GetStats()
+		stats[plugin.Name()] = PluginStats{Attempts: attempts, Successes: successes}
+	}
+	return stats
+}
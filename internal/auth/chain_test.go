@@ -2,7 +2,9 @@ package auth
 
 import (
 	"context"
+	"maps"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -126,6 +128,53 @@ func TestAuthChain_MultiplePlugins(t *testing.T) {
 	}
 }
 
+func TestAuthChain_PerPluginStats(t *testing.T) {
+	usersFile := filepath.Join(t.TempDir(), "users.txt")
+	if err := os.WriteFile(usersFile, []byte("fileuser1:filepass1\n"), 0600); err != nil {
+		t.Fatalf("Failed to write users file: %v", err)
+	}
+
+	cfg := &config.AuthConfig{
+		PluginChain: []string{"memory", "file"},
+		Plugins: map[string]map[string]interface{}{
+			"memory": {
+				"users": []interface{}{
+					map[string]interface{}{"username": "memuser1", "password": "mempass1"},
+				},
+			},
+			"file": {
+				"users_file": usersFile,
+			},
+		},
+	}
+
+	chain, err := NewAuthChainFromConfig(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Failed to create auth chain: %v", err)
+	}
+	defer chain.Close()
+
+	ctx := context.Background()
+	for _, creds := range [][2]string{
+		{"memuser1", "mempass1"},   // memory succeeds
+		{"fileuser1", "filepass1"}, // memory fails, file succeeds
+		{"fileuser1", "wrongpass"}, // both fail
+	} {
+		chain.Authenticate(ctx, creds[0], creds[1])
+	}
+
+	want := map[string]PluginStats{
+		"memory": {Attempts: 3, Successes: 1},
+		"file":   {Attempts: 2, Successes: 1},
+	}
+	if got := chain.PerPluginStats(); !maps.Equal(got, want) {
+		t.Errorf("PerPluginStats() = %v, want %v", got, want)
+	}
+	if attempts, successes := chain.GetStats(); attempts != 3 || successes != 2 {
+		t.Errorf("GetStats() = %d/%d, want 3/2", attempts, successes)
+	}
+}
+
 func TestAuthChain_DuplicatePlugins(t *testing.T) {
 	cfg := &config.AuthConfig{
 		PluginChain: []string{"memory", "memory"}, // Duplicate!
@@ -303,3 +352,50 @@ func TestAuthChain_WithTimeout(t *testing.T) {
 		t.Error("Expected authentication to succeed within timeout")
 	}
 }
+
+// remotePlugin is a chain member that validates users remotely without exposing passwords
+type remotePlugin struct{ MemoryAuthenticator }
+
+func (r *remotePlugin) Capabilities() AuthCapabilities {
+	return AuthCapabilities{SupportsUserValidation: true, IsRemote: true}
+}
+
+func TestAuthenticatorCapabilities(t *testing.T) {
+	usersFile := filepath.Join(t.TempDir(), "users.txt")
+	if err := os.WriteFile(usersFile, []byte("fileuser1:filepass1\n"), 0600); err != nil {
+		t.Fatalf("Failed to write users file: %v", err)
+	}
+	memory, err := NewMemoryAuthenticator(context.Background(), []config.UserConfig{{Username: "user1", Password: "pass1"}})
+	if err != nil {
+		t.Fatalf("NewMemoryAuthenticator failed: %v", err)
+	}
+	file, err := NewFileAuthenticator(context.Background(), usersFile)
+	if err != nil {
+		t.Fatalf("NewFileAuthenticator failed: %v", err)
+	}
+	local := AuthCapabilities{SupportsPlaintext: true, SupportsUserValidation: true}
+
+	tests := []struct {
+		name string
+		auth Authenticator
+		want AuthCapabilities
+	}{
+		{"memory", memory, local},
+		{"file", file, local},
+		{"local chain", &AuthChain{plugins: []Authenticator{memory, file}}, local},
+		{
+			name: "chain with remote plugin",
+			auth: &AuthChain{plugins: []Authenticator{memory, &remotePlugin{}}},
+			want: AuthCapabilities{SupportsUserValidation: true, IsRemote: true},
+		},
+		{"empty chain", &AuthChain{}, AuthCapabilities{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.auth.Capabilities(); got != tt.want {
+				t.Errorf("Capabilities() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
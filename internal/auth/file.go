@@ -7,15 +7,22 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
-// FileAuthenticator implements file-based authentication with streaming reads
+// FileAuthenticator implements file-based authentication with streaming reads.
+// In preloaded mode the file is read once into memory instead, and Reload
+// picks up later edits.
 type FileAuthenticator struct {
 	filePath     string
 	authCount    int64 // authentication attempts (atomic)
 	successCount int64 // successful authentications (atomic)
+
+	preload bool
+	users   map[string]string // username -> password, preloaded mode only
+	mu      sync.RWMutex
 }
 
 // NewFileAuthenticator creates a new file-based authenticator
@@ -39,7 +46,93 @@ func NewFileAuthenticator(ctx context.Context, filePath string) (*FileAuthentica
 	return auth, nil
 }
 
-// Authenticate verifies username and password by streaming through the file
+// NewPreloadedFileAuthenticator creates a file authenticator that reads the
+// users file into memory once; call Reload to pick up changes
+func NewPreloadedFileAuthenticator(ctx context.Context, filePath string) (*FileAuthenticator, error) {
+	auth := &FileAuthenticator{
+		filePath: filePath,
+		preload:  true,
+	}
+	if err := auth.Reload(ctx); err != nil {
+		return nil, err
+	}
+	return auth, nil
+}
+
+// Reload re-reads the users file in preloaded mode. On error the current
+// users are kept, so a broken edit does not lock everyone out. Streaming mode
+// always reads the file and has nothing to reload.
+func (f *FileAuthenticator) Reload(ctx context.Context) error {
+	if !f.preload {
+		return nil
+	}
+
+	users, err := parseUsersFile(ctx, f.filePath)
+	if err != nil {
+		return fmt.Errorf("failed to load auth file: %w", err)
+	}
+
+	f.mu.Lock()
+	f.users = users
+	f.mu.Unlock()
+
+	log().Info("Auth file loaded", "file", f.filePath, "users", len(users))
+	return nil
+}
+
+// parseUsersFile reads every username:password line of the file
+func parseUsersFile(ctx context.Context, filePath string) (map[string]string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	users := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		username, password, ok := parseUserLine(scanner.Text())
+		if !ok {
+			continue
+		}
+		// The first entry wins, as it does when streaming through the file
+		if _, exists := users[username]; !exists {
+			users[username] = password
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// parseUserLine parses one "username:password" line, skipping empty lines
+// and comments
+func parseUserLine(line string) (username, password string, ok bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", "", false
+	}
+	username, password, ok = strings.Cut(line, ":")
+	if !ok {
+		return "", "", false
+	}
+	return strings.TrimSpace(username), strings.TrimSpace(password), true
+}
+
+// lookupPreloaded returns the password of a preloaded user
+func (f *FileAuthenticator) lookupPreloaded(username string) (string, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	password, found := f.users[username]
+	return password, found
+}
+
+// Authenticate verifies username and password by streaming through the file,
+// or against the preloaded users
 func (f *FileAuthenticator) Authenticate(ctx context.Context, username, password string) *AuthResult {
 	atomic.AddInt64(&f.authCount, 1)
 
@@ -50,6 +143,21 @@ func (f *FileAuthenticator) Authenticate(ctx context.Context, username, password
 		}
 	}
 
+	if f.preload {
+		filePassword, found := f.lookupPreloaded(username)
+		if !found {
+			log().Debug("Authentication failed: user not found", "username", username)
+			return &AuthResult{Success: false}
+		}
+		if subtle.ConstantTimeCompare([]byte(password), []byte(filePassword)) != 1 {
+			log().Debug("Authentication failed: invalid password", "username", username)
+			return &AuthResult{Success: false}
+		}
+		atomic.AddInt64(&f.successCount, 1)
+		log().Info("Authentication successful", "username", username)
+		return &AuthResult{Success: true, Username: username}
+	}
+
 	file, err := os.Open(f.filePath)
 	if err != nil {
 			log().Error("Failed to open auth file", "error", err)
@@ -76,22 +184,11 @@ func (f *FileAuthenticator) Authenticate(ctx context.Context, username, password
 		default:
 		}
 
-		line := strings.TrimSpace(scanner.Text())
-
-		// Skip empty lines and comments
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-
-		// Parse username:password format
-		parts := strings.SplitN(line, ":", 2)
-		if len(parts) != 2 {
+		fileUsername, filePassword, ok := parseUserLine(scanner.Text())
+		if !ok {
 			continue
 		}
 
-		fileUsername := strings.TrimSpace(parts[0])
-		filePassword := strings.TrimSpace(parts[1])
-
 		// Check if this is the user we're looking for
 		if fileUsername == username {
 			// Constant-time password comparison to prevent timing attacks
@@ -123,6 +220,14 @@ func (f *FileAuthenticator) Authenticate(ctx context.Context, username, password
 
 // findUserInFile is a common method for file parsing logic
 func (f *FileAuthenticator) findUserInFile(ctx context.Context, email string, needPassword bool) (string, bool) {
+	if f.preload {
+		password, found := f.lookupPreloaded(email)
+		if !needPassword {
+			password = ""
+		}
+		return password, found
+	}
+
 	file, err := os.Open(f.filePath)
 	if err != nil {
 			log().Error("Failed to open auth file", "error", err)
@@ -138,22 +243,11 @@ func (f *FileAuthenticator) findUserInFile(ctx context.Context, email string, ne
 		default:
 		}
 
-		line := strings.TrimSpace(scanner.Text())
-
-		// Skip empty lines and comments
-		if line == "" || strings.HasPrefix(line, "#") {
+		fileUsername, filePassword, ok := parseUserLine(scanner.Text())
+		if !ok {
 			continue
 		}
 
-		// Parse username:password format
-		parts := strings.SplitN(line, ":", 2)
-		if len(parts) != 2 {
-			continue
-		}
-
-		fileUsername := strings.TrimSpace(parts[0])
-		filePassword := strings.TrimSpace(parts[1])
-
 		// Check if this is the user we're looking for
 		if fileUsername == email {
 			if needPassword {
@@ -205,6 +299,11 @@ func (f *FileAuthenticator) Name() string {
 	return "file"
 }
 
+// Capabilities reports that the local users file stores plaintext passwords
+func (f *FileAuthenticator) Capabilities() AuthCapabilities {
+	return AuthCapabilities{SupportsPlaintext: true, SupportsUserValidation: true}
+}
+
 // Close cleans up resources
 func (f *FileAuthenticator) Close() error {
 	return nil
@@ -227,5 +326,8 @@ func NewFileAuthenticatorFromConfig(ctx context.Context, config map[string]inter
 		return nil, fmt.Errorf("file plugin 'users_file' must be a string")
 	}
 
+	if preload, _ := config["preload"].(bool); preload {
+		return NewPreloadedFileAuthenticator(ctx, path)
+	}
 	return NewFileAuthenticator(ctx, path)
 }
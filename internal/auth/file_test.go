@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileAuthenticator_PreloadedReload(t *testing.T) {
+	usersFile := filepath.Join(t.TempDir(), "users.txt")
+	if err := os.WriteFile(usersFile, []byte("alice:secret\n"), 0600); err != nil {
+		t.Fatalf("Failed to write users file: %v", err)
+	}
+	ctx := context.Background()
+
+	authenticator, err := NewFileAuthenticatorFromConfig(ctx, map[string]interface{}{
+		"users_file": usersFile,
+		"preload":    true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create preloaded file authenticator: %v", err)
+	}
+	fa := authenticator.(*FileAuthenticator)
+
+	if !fa.Authenticate(ctx, "alice", "secret").Success {
+		t.Error("Expected preloaded authentication to succeed")
+	}
+
+	// A new user is not seen until the file is reloaded
+	if err := os.WriteFile(usersFile, []byte("alice:secret\nbob:hunter2\n"), 0600); err != nil {
+		t.Fatalf("Failed to rewrite users file: %v", err)
+	}
+	if fa.Authenticate(ctx, "bob", "hunter2").Success {
+		t.Error("Expected bob to be unknown before reload")
+	}
+	if err := fa.Reload(ctx); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if !fa.Authenticate(ctx, "bob", "hunter2").Success {
+		t.Error("Expected bob to authenticate after reload")
+	}
+	if !fa.ValidateUser(ctx, "bob") {
+		t.Error("Expected bob to validate after reload")
+	}
+
+	// A failed reload keeps the loaded users
+	if err := os.Remove(usersFile); err != nil {
+		t.Fatalf("Failed to remove users file: %v", err)
+	}
+	if err := fa.Reload(ctx); err == nil {
+		t.Error("Expected reload of a missing file to fail")
+	}
+	if !fa.Authenticate(ctx, "alice", "secret").Success {
+		t.Error("Expected users to survive a failed reload")
+	}
+}
+
+func TestFileAuthenticator_StreamingSeesEdits(t *testing.T) {
+	usersFile := filepath.Join(t.TempDir(), "users.txt")
+	if err := os.WriteFile(usersFile, []byte("alice:secret\n"), 0600); err != nil {
+		t.Fatalf("Failed to write users file: %v", err)
+	}
+	ctx := context.Background()
+
+	fa, err := NewFileAuthenticator(ctx, usersFile)
+	if err != nil {
+		t.Fatalf("Failed to create file authenticator: %v", err)
+	}
+	if err := os.WriteFile(usersFile, []byte("alice:secret\nbob:hunter2\n"), 0600); err != nil {
+		t.Fatalf("Failed to rewrite users file: %v", err)
+	}
+	if !fa.Authenticate(ctx, "bob", "hunter2").Success {
+		t.Error("Expected streaming mode to see a new user without reload")
+	}
+}
+
+func TestFileAuthenticator_DuplicateUserFirstEntryWins(t *testing.T) {
+	usersFile := filepath.Join(t.TempDir(), "users.txt")
+	if err := os.WriteFile(usersFile, []byte("alice:first\nalice:second\n"), 0600); err != nil {
+		t.Fatalf("Failed to write users file: %v", err)
+	}
+	ctx := context.Background()
+
+	streaming, err := NewFileAuthenticator(ctx, usersFile)
+	if err != nil {
+		t.Fatalf("Failed to create file authenticator: %v", err)
+	}
+	preloaded, err := NewPreloadedFileAuthenticator(ctx, usersFile)
+	if err != nil {
+		t.Fatalf("Failed to create preloaded file authenticator: %v", err)
+	}
+
+	for name, fa := range map[string]*FileAuthenticator{"streaming": streaming, "preloaded": preloaded} {
+		if !fa.Authenticate(ctx, "alice", "first").Success {
+			t.Errorf("%s: expected the first entry's password to authenticate", name)
+		}
+		if fa.Authenticate(ctx, "alice", "second").Success {
+			t.Errorf("%s: expected the duplicate entry's password to be ignored", name)
+		}
+	}
+}
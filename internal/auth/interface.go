@@ -24,10 +24,38 @@ type Authenticator interface {
 	// Name returns the plugin name
 	Name() string
 
+	// Capabilities reports what the plugin can do, so callers can decide on
+	// mechanism advertisement and caching
+	Capabilities() AuthCapabilities
+
 	// Close cleans up resources
 	Close() error
 }
 
+// AuthCapabilities describes the features of an authentication plugin
+type AuthCapabilities struct {
+	SupportsPlaintext      bool // can retrieve the plaintext password, as CRAM-MD5 needs
+	SupportsUserValidation bool // ValidateUser answers authoritatively for RCPT TO
+	IsRemote               bool // lookups leave the process and are worth caching
+}
+
+// Reloader is implemented by plugins that cache their user data and can
+// refresh it at runtime
+type Reloader interface {
+	Reload(ctx context.Context) error
+}
+
+// StatsProvider is implemented by plugins that count their own authentications
+type StatsProvider interface {
+	GetStats() (attempts, successes int64)
+}
+
+// PluginStats holds the authentication counters of one plugin
+type PluginStats struct {
+	Attempts  int64
+	Successes int64
+}
+
 // Registry manages authentication plugins using generics for type safety
 type Registry[T Authenticator] struct {
 	plugins map[string]T
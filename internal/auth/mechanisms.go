@@ -65,6 +65,45 @@ func DecodePlain(encoded string) (username, password string, err error) {
 	return username, password, nil
 }
 
+// MaxXOAuth2DataSize limits the raw base64 XOAUTH2 payload; bearer tokens
+// (often JWTs) are much larger than PLAIN credentials
+const MaxXOAuth2DataSize = 8192
+
+// DecodeXOAuth2 decodes XOAUTH2 SASL mechanism data
+// Format: "user=" user ^A "auth=Bearer " token ^A ^A
+func DecodeXOAuth2(encoded string) (username, token string, err error) {
+	if len(encoded) > MaxXOAuth2DataSize {
+		return "", "", fmt.Errorf("authentication data too large: %d bytes (max %d)",
+			len(encoded), MaxXOAuth2DataSize)
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid base64 encoding: %w", err)
+	}
+
+	for _, field := range strings.Split(string(decoded), "\x01") {
+		if value, ok := strings.CutPrefix(field, "user="); ok {
+			username = value
+		} else if value, ok := strings.CutPrefix(field, "auth="); ok {
+			scheme, credentials, _ := strings.Cut(value, " ")
+			if !strings.EqualFold(scheme, "Bearer") {
+				return "", "", fmt.Errorf("unsupported XOAUTH2 auth scheme: %q", scheme)
+			}
+			token = strings.TrimSpace(credentials)
+		}
+	}
+
+	if username == "" {
+		return "", "", fmt.Errorf("username cannot be empty")
+	}
+	if token == "" {
+		return "", "", fmt.Errorf("bearer token cannot be empty")
+	}
+
+	return username, token, nil
+}
+
 // EncodeBase64 encodes a string in base64 for AUTH responses
 func EncodeBase64(s string) string {
 	return base64.StdEncoding.EncodeToString([]byte(s))
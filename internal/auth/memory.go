@@ -124,6 +124,11 @@ func (m *MemoryAuthenticator) Name() string {
 	return "memory"
 }
 
+// Capabilities reports that users and plaintext passwords are held in memory
+func (m *MemoryAuthenticator) Capabilities() AuthCapabilities {
+	return AuthCapabilities{SupportsPlaintext: true, SupportsUserValidation: true}
+}
+
 // Close cleans up resources
 func (m *MemoryAuthenticator) Close() error {
 	return nil
@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pawciobiel/golubsmtpd/internal/config"
+)
+
+// ErrInvalidToken is returned when a bearer token is unknown, revoked or expired
+var ErrInvalidToken = errors.New("invalid bearer token")
+
+// TokenValidator validates OAuth2 bearer tokens for AUTH XOAUTH2
+type TokenValidator interface {
+	// ValidateToken returns the username the token was issued to. It returns an
+	// error wrapping ErrInvalidToken when the token must be rejected; other
+	// errors indicate the validator itself failed.
+	ValidateToken(ctx context.Context, token string) (string, error)
+}
+
+// IntrospectionValidator validates tokens against an RFC 7662 introspection endpoint
+type IntrospectionValidator struct {
+	config *config.OAuth2Config
+	client *http.Client
+}
+
+// NewIntrospectionValidator creates a validator for the configured endpoint
+func NewIntrospectionValidator(cfg *config.OAuth2Config) *IntrospectionValidator {
+	return &IntrospectionValidator{
+		config: cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+	}
+}
+
+// introspectionResponse holds the RFC 7662 §2.2 fields we rely on
+type introspectionResponse struct {
+	Active   bool   `json:"active"`
+	Username string `json:"username"`
+	Subject  string `json:"sub"`
+	Expiry   int64  `json:"exp"`
+}
+
+func (v *IntrospectionValidator) ValidateToken(ctx context.Context, token string) (string, error) {
+	form := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, v.config.IntrospectionURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("failed to build introspection request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if v.config.ClientID != "" {
+		req.SetBasicAuth(v.config.ClientID, v.config.ClientSecret)
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("introspection request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("introspection endpoint returned %s", resp.Status)
+	}
+
+	var result introspectionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("invalid introspection response: %w", err)
+	}
+
+	if !result.Active {
+		return "", fmt.Errorf("%w: token is not active", ErrInvalidToken)
+	}
+	if result.Expiry > 0 && time.Now().Unix() >= result.Expiry {
+		return "", fmt.Errorf("%w: token expired", ErrInvalidToken)
+	}
+
+	username := result.Username
+	if username == "" {
+		username = result.Subject
+	}
+	if username == "" {
+		return "", fmt.Errorf("%w: token has no username or subject", ErrInvalidToken)
+	}
+	return username, nil
+}
@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pawciobiel/golubsmtpd/internal/config"
+)
+
+func TestDecodeXOAuth2(t *testing.T) {
+	tests := []struct {
+		name      string
+		raw       string
+		wantUser  string
+		wantToken string
+		wantErr   bool
+	}{
+		{"valid", "user=alice@example.com\x01auth=Bearer abc.def\x01\x01", "alice@example.com", "abc.def", false},
+		{"scheme case-insensitive", "user=alice\x01auth=bearer tok\x01\x01", "alice", "tok", false},
+		{"missing user", "auth=Bearer tok\x01\x01", "", "", true},
+		{"missing token", "user=alice\x01\x01", "", "", true},
+		{"basic scheme", "user=alice\x01auth=Basic dXNlcjpwYXNz\x01\x01", "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, token, err := DecodeXOAuth2(EncodeBase64(tt.raw))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DecodeXOAuth2 error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if user != tt.wantUser || token != tt.wantToken {
+				t.Errorf("DecodeXOAuth2 = (%q, %q), want (%q, %q)", user, token, tt.wantUser, tt.wantToken)
+			}
+		})
+	}
+
+	if _, _, err := DecodeXOAuth2("not base64!"); err == nil {
+		t.Error("Expected error for invalid base64")
+	}
+}
+
+func TestIntrospectionValidator(t *testing.T) {
+	now := time.Now().Unix()
+	responses := map[string]map[string]any{
+		"active":   {"active": true, "username": "alice@example.com", "exp": now + 3600},
+		"sub-only": {"active": true, "sub": "bob@example.com"},
+		"inactive": {"active": false},
+		"expired":  {"active": true, "username": "alice@example.com", "exp": now - 60},
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, ok := r.BasicAuth(); !ok || id != "smtpd" || secret != "s3cret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(responses[r.PostFormValue("token")])
+	}))
+	defer srv.Close()
+
+	validator := NewIntrospectionValidator(&config.OAuth2Config{
+		IntrospectionURL: srv.URL,
+		ClientID:         "smtpd",
+		ClientSecret:     "s3cret",
+		Timeout:          5 * time.Second,
+	})
+
+	tests := []struct {
+		token       string
+		wantUser    string
+		wantInvalid bool
+	}{
+		{"active", "alice@example.com", false},
+		{"sub-only", "bob@example.com", false},
+		{"inactive", "", true},
+		{"expired", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.token, func(t *testing.T) {
+			user, err := validator.ValidateToken(context.Background(), tt.token)
+			if tt.wantInvalid {
+				if !errors.Is(err, ErrInvalidToken) {
+					t.Fatalf("Expected ErrInvalidToken, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ValidateToken failed: %v", err)
+			}
+			if user != tt.wantUser {
+				t.Errorf("ValidateToken = %q, want %q", user, tt.wantUser)
+			}
+		})
+	}
+}
+
+func TestIntrospectionValidator_EndpointError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	validator := NewIntrospectionValidator(&config.OAuth2Config{IntrospectionURL: srv.URL, Timeout: 5 * time.Second})
+	_, err := validator.ValidateToken(context.Background(), "any")
+	if err == nil {
+		t.Fatal("Expected error from failing endpoint")
+	}
+	if errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Endpoint failure must not be reported as an invalid token: %v", err)
+	}
+}
@@ -14,6 +14,19 @@ func ExtractUsername(email string) string {
 	return "" // Invalid email format
 }
 
+// StripAddressExtension removes a detail suffix from a local part: everything
+// from the first of the delimiter characters on ("alice+lists" -> "alice").
+// An empty delimiter disables stripping; a leading delimiter is kept.
+func StripAddressExtension(local, delimiters string) string {
+	if delimiters == "" {
+		return local
+	}
+	if i := strings.IndexAny(local, delimiters); i > 0 {
+		return local[:i]
+	}
+	return local
+}
+
 // ExtractUsernameAndDomain extracts both parts from an email address
 // Example: user@domain.com -> user, domain.com
 func ExtractUsernameAndDomain(email string) (username, domain string) {
@@ -1,6 +1,13 @@
 package config
 
-import "time"
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
 
 type Config struct {
 	Server   ServerConfig   `yaml:"server"`
@@ -22,12 +29,52 @@ const (
 	ListenerModePlain    ListenerMode = "plain"     // no TLS (port 25)
 	ListenerModeSTARTTLS ListenerMode = "starttls"  // plain + STARTTLS upgrade (port 587)
 	ListenerModeTLS      ListenerMode = "tls"       // implicit TLS (port 465)
+	ListenerModeLMTP     ListenerMode = "lmtp"      // plain LMTP (RFC 2033) for LDA interop, LHLO instead of HELO/EHLO
+)
+
+// ListenerRole defines which policy applies to sessions on a listener
+type ListenerRole string
+
+const (
+	ListenerRoleRelay      ListenerRole = "relay"      // MTA-to-MTA: any sender, RCPT TO enforces relay policy
+	ListenerRoleSubmission ListenerRole = "submission" // MUA submission: AUTH required
+)
+
+// SocketHelo defines whether Unix socket clients must introduce themselves
+type SocketHelo string
+
+const (
+	SocketHeloSkip       SocketHelo = "skip"       // sessions start greeted, without a HELO name
+	SocketHeloRequire    SocketHelo = "require"    // MAIL needs a HELO/EHLO first, as on TCP
+	SocketHeloSynthesize SocketHelo = "synthesize" // sessions start greeted as localhost; Received names the client process
+)
+
+// HeadersOnly defines what happens to a message that ends without a body
+type HeadersOnly string
+
+const (
+	HeadersOnlyAccept HeadersOnly = "accept" // spool it as sent
+	HeadersOnlyReject HeadersOnly = "reject" // refuse it with 554
 )
 
+// DefaultListenerRole infers the role from IANA port semantics: 587 and 465 are
+// submission ports, everything else is treated as relay
+func DefaultListenerRole(port int) ListenerRole {
+	switch port {
+	case 587, 465:
+		return ListenerRoleSubmission
+	default:
+		return ListenerRoleRelay
+	}
+}
+
 // ListenerConfig defines a single TCP listener
 type ListenerConfig struct {
-	Port int          `yaml:"port"`
-	Mode ListenerMode `yaml:"mode"`
+	Address        string       `yaml:"address"` // overrides server.bind for this listener
+	Port           int          `yaml:"port"`
+	Mode           ListenerMode `yaml:"mode"`
+	Role           ListenerRole `yaml:"role"`            // empty = inferred from port
+	AuthMechanisms []string     `yaml:"auth_mechanisms"` // SASL mechanisms offered on this listener, within auth.mechanisms; empty = all of them
 }
 
 type ServerConfig struct {
@@ -35,24 +82,88 @@ type ServerConfig struct {
 	Port                int              `yaml:"port"`      // legacy single-port (used if Listeners is empty)
 	Listeners           []ListenerConfig `yaml:"listeners"` // multi-port listeners
 	Hostname            string           `yaml:"hostname"`
+	PublicHostname      string           `yaml:"public_hostname"` // name shown to clients (banner, EHLO, Received); empty = hostname
 	MaxConnections      int           `yaml:"max_connections"`
 	MaxConnectionsPerIP int           `yaml:"max_connections_per_ip"`
+	MaxSessionsPerUser  int           `yaml:"max_sessions_per_user"` // concurrent authenticated sessions per username (0 = unlimited)
+	MaxTransactionsPerConnection int  `yaml:"max_transactions_per_connection"` // messages accepted per connection before MAIL gets 421 (0 = unlimited)
 	MaxRecipients       int           `yaml:"max_recipients"`
+	MaxLineLength       int           `yaml:"max_line_length"` // bytes per command line excluding CRLF; longer lines get 500 (0 = unlimited)
 	MaxMessageSize      int           `yaml:"max_message_size"`
-	ReadTimeout         time.Duration `yaml:"read_timeout"`
-	WriteTimeout        time.Duration `yaml:"write_timeout"`
-	EmailValidation     []string      `yaml:"email_validation"`
+	DataBufferSize      int           `yaml:"data_buffer_size"` // bytes read per chunk while receiving DATA (0 = 32 KiB)
+	HeadersOnly         HeadersOnly   `yaml:"headers_only"`     // accept or reject messages with no body after the header block
+	ReadTimeout         time.Duration `yaml:"read_timeout"`      // deprecated: superseded by command_timeout/data_timeout
+	WriteTimeout        time.Duration `yaml:"write_timeout"`     // refreshed before each response write
+	CommandTimeout      time.Duration `yaml:"command_timeout"`   // idle time allowed between commands, refreshed per read
+	DataTimeout         time.Duration `yaml:"data_timeout"`      // idle time allowed between DATA reads, refreshed per read
+	MaxDataDuration     time.Duration `yaml:"max_data_duration"` // absolute limit on the DATA phase regardless of activity
+	EmailValidation     []string      `yaml:"email_validation"` // basic (required), extended, dns_mx, dns_a
 	LocalDomains        []string      `yaml:"local_domains"`
 	VirtualDomains      []string      `yaml:"virtual_domains"`
 	RelayDomains        []string      `yaml:"relay_domains"`
 	SpoolDir            string        `yaml:"spool_dir"`
+	SpoolSharding       bool          `yaml:"spool_sharding"` // store messages in subdirectories named after the first two ID characters
+	SpoolSyncDirs       bool          `yaml:"spool_sync_dirs"` // fsync spool directories after renames so accepted mail survives a crash
 	SocketPath          string        `yaml:"socket_path"`
+	SocketHelo          SocketHelo    `yaml:"socket_helo"` // skip, require or synthesize a HELO on socket sessions
+	ControlSocketPath   string        `yaml:"control_socket_path"` // admin control socket (STATS, LIST, FLUSH, SHUTDOWN); empty disables
 	LocalAliasesFilePath string       `yaml:"local_aliases_file_path"`
+	LocalAliasesLoadTimeout   time.Duration `yaml:"local_aliases_load_timeout"`   // limit on parsing and validating the aliases file (0 = no limit)
+	LocalAliasesLookupWorkers int           `yaml:"local_aliases_lookup_workers"` // concurrent user lookups validating alias destinations (0 = 8)
+	CanonicalMapsFilePath string      `yaml:"canonical_maps_file_path"` // sender rewriting; empty disables
+	CanonicalRecipients   bool        `yaml:"canonical_recipients"`     // also rewrite RCPT TO addresses
+	SenderAccessFilePath  string      `yaml:"sender_access_file_path"`  // MAIL FROM address/domain -> reject/ok; empty disables
+	RecipientAccessFilePath string    `yaml:"recipient_access_file_path"` // RCPT TO address/domain -> reject/ok; empty disables
+	AppendDefaultDomain   string      `yaml:"append_default_domain"`    // qualify bare MAIL/RCPT usernames with this domain; empty disables
+	AcceptPostmaster      bool        `yaml:"accept_postmaster"`        // RFC 5321 §4.5.1: always accept postmaster@ local/virtual domains
+	AcceptAbuse           bool        `yaml:"accept_abuse"`             // also always accept abuse@ local/virtual domains
+	PostmasterMailbox     string      `yaml:"postmaster_mailbox"`       // receives postmaster/abuse mail no user or alias claims
 	TrustedUsers        []string      `yaml:"trusted_users"`
+	EnabledCommands     []string      `yaml:"enabled_commands"`      // SMTP commands to accept; empty = all supported
+	DisconnectOnUnknown int           `yaml:"disconnect_on_unknown"` // close with 421 after this many unknown commands (0 = never)
+	EhloExtensions      map[string]bool `yaml:"ehlo_extensions"`     // force an EHLO keyword on (true) or off (false) regardless of conditions
+	ResponseMessages    map[int]string  `yaml:"response_messages"`   // reply code -> text used for every reply with that code
+	EnableExpn          bool          `yaml:"enable_expn"`           // allow EXPN of local aliases on trusted connections
+	ExpnNetworks        []string      `yaml:"expn_networks"`         // CIDRs whose TCP clients may use EXPN (socket clients always may)
+	StripBccHeaders     bool          `yaml:"strip_bcc_headers"`     // remove Bcc/Resent-Bcc from messages injected by authenticated users
+	AddMessageID        bool          `yaml:"add_message_id"`        // give messages from authenticated users a Message-ID when they lack one
+}
+
+// AdvertisedHostname returns the name presented to clients and recipients:
+// PublicHostname when masquerading, otherwise Hostname
+func (c *ServerConfig) AdvertisedHostname() string {
+	if c.PublicHostname != "" {
+		return c.PublicHostname
+	}
+	return c.Hostname
+}
+
+// ListenAddress returns the host:port listener l binds: its own address, or
+// server.bind when it has none
+func (c *ServerConfig) ListenAddress(l ListenerConfig) string {
+	host := c.Bind
+	if l.Address != "" {
+		host = l.Address
+	}
+	return net.JoinHostPort(host, strconv.Itoa(l.Port))
+}
+
+// CommandEnabled reports whether the named SMTP command may be used
+func (c *ServerConfig) CommandEnabled(name string) bool {
+	if len(c.EnabledCommands) == 0 {
+		return true
+	}
+	for _, cmd := range c.EnabledCommands {
+		if strings.EqualFold(cmd, name) {
+			return true
+		}
+	}
+	return false
 }
 
 // RelayConfig controls inbound MTA-to-MTA relay behaviour on port 25.
-// TODO: add Networks ([]string, trusted CIDRs) and migrate RelayDomains here.
+// Trusted client networks live in SecurityConfig.TrustedNetworks.
+// TODO: migrate RelayDomains here.
 type RelayConfig struct {
 	Enabled bool `yaml:"enabled"` // false = reject all relay-domain recipients (deny-by-default)
 }
@@ -61,6 +172,57 @@ type TLSConfig struct {
 	Enabled  bool   `yaml:"enabled"`
 	CertFile string `yaml:"cert_file"`
 	KeyFile  string `yaml:"key_file"`
+
+	// Client certificates (AUTH EXTERNAL). Certificates are requested but not
+	// required; a verified one whose subject CN or SAN appears in ClientCertUsers
+	// lets the client authenticate as the mapped username.
+	ClientCAFile    string            `yaml:"client_ca_file"`
+	ClientCertUsers map[string]string `yaml:"client_cert_users"` // CN/SAN -> username
+
+	MinVersion   string   `yaml:"min_version"`   // "1.2" or "1.3"; empty = 1.2
+	CipherSuites []string `yaml:"cipher_suites"` // TLS 1.2 suites by Go name (e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256); empty = Go defaults
+
+	OCSPStapleFile string `yaml:"ocsp_staple_file"` // DER OCSP response stapled to handshakes, re-read with the certificate; empty disables
+}
+
+// tlsVersions maps min_version values to crypto/tls constants
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// TLSMinVersion returns the crypto/tls constant for MinVersion
+func (c *TLSConfig) TLSMinVersion() (uint16, error) {
+	if c.MinVersion == "" {
+		return tls.VersionTLS12, nil
+	}
+	v, ok := tlsVersions[c.MinVersion]
+	if !ok {
+		return 0, fmt.Errorf("invalid tls min_version %q: must be 1.2 or 1.3", c.MinVersion)
+	}
+	return v, nil
+}
+
+// CipherSuiteIDs resolves CipherSuites to crypto/tls IDs. Only suites Go
+// considers secure are accepted; nil means the Go defaults. TLS 1.3 suites are
+// not configurable and unaffected.
+func (c *TLSConfig) CipherSuiteIDs() ([]uint16, error) {
+	if len(c.CipherSuites) == 0 {
+		return nil, nil
+	}
+	known := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+	ids := make([]uint16, 0, len(c.CipherSuites))
+	for _, name := range c.CipherSuites {
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure tls cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
 }
 
 type MaildirConfig struct {
@@ -70,11 +232,113 @@ type MaildirConfig struct {
 type AuthConfig struct {
 	PluginChain []string                          `yaml:"plugin_chain"` // Ordered plugin chain
 	Plugins     map[string]map[string]interface{} `yaml:"plugins"`
+	Mechanisms  []string                          `yaml:"mechanisms"` // SASL mechanisms to offer; empty = all supported
+	OAuth2      OAuth2Config                      `yaml:"oauth2"`
+}
+
+// OAuth2Config configures bearer token validation for AUTH XOAUTH2 via an
+// RFC 7662 token introspection endpoint. An empty IntrospectionURL disables XOAUTH2.
+type OAuth2Config struct {
+	IntrospectionURL string        `yaml:"introspection_url"`
+	ClientID         string        `yaml:"client_id"` // HTTP Basic credentials for the endpoint
+	ClientSecret     string        `yaml:"client_secret"`
+	Timeout          time.Duration `yaml:"timeout"`
+}
+
+// MechanismEnabled reports whether the named SASL mechanism may be offered
+func (c *AuthConfig) MechanismEnabled(name string) bool {
+	if len(c.Mechanisms) == 0 {
+		return true
+	}
+	for _, m := range c.Mechanisms {
+		if strings.EqualFold(m, name) {
+			return true
+		}
+	}
+	return false
 }
 
 type SecurityConfig struct {
 	ReverseDNS ReverseDNSConfig `yaml:"reverse_dns"`
 	DNSBL      DNSBLConfig      `yaml:"dnsbl"`
+	Allowlist  []string         `yaml:"allowlist"` // CIDRs (or IPs) that skip rDNS and DNSBL checks
+	Blocklist  []string         `yaml:"blocklist"` // CIDRs (or IPs) rejected before any other work
+
+	ConnectionRejects ConnectionRejectsConfig `yaml:"connection_rejects"` // replies to TCP clients refused before the SMTP session
+
+	TrustedNetworks []string `yaml:"trusted_networks"` // CIDRs (or IPs) whose TCP clients may relay to any domain, like Postfix mynetworks
+
+	GreetingDelay time.Duration `yaml:"greeting_delay"` // hold the 220 banner back; clients talking first get 554 (0 = disabled)
+
+	SubmissionRateLimit SubmissionRateLimitConfig `yaml:"submission_rate_limit"`
+	InvalidRecipients   InvalidRecipientsConfig   `yaml:"invalid_recipients"`
+	ContentChecks       ContentChecksConfig       `yaml:"content_checks"`
+	SpamScore           SpamScoreConfig           `yaml:"spam_score"`
+	RejectScore         RejectScoreConfig         `yaml:"reject_score"`
+}
+
+// RejectReply is the reply written to a TCP client refused before its SMTP
+// session, just before the connection is closed
+type RejectReply struct {
+	Code    int    `yaml:"code"` // 421 asks the client to come back later, 554 refuses it
+	Message string `yaml:"message"`
+}
+
+// ConnectionRejectsConfig holds the reply for each reason a TCP connection is
+// refused. Implicit TLS and Unix socket clients are closed without a reply.
+type ConnectionRejectsConfig struct {
+	MaxConnections RejectReply `yaml:"max_connections"` // server-wide max_connections reached
+	PerIPLimit     RejectReply `yaml:"per_ip_limit"`    // max_connections_per_ip reached
+	ReverseDNS     RejectReply `yaml:"reverse_dns"`     // reverse DNS check failed
+	DNSBL          RejectReply `yaml:"dnsbl"`           // client IP listed with dnsbl action reject
+	Blocklist      RejectReply `yaml:"blocklist"`       // client IP in the blocklist
+}
+
+// SpamScoreConfig adds headers scoring weak signals about the client for
+// downstream filters, instead of rejecting on them. Each weight is added to the
+// score when its signal fires; a weight of 0 ignores that signal. The weights
+// also apply to reject_score, even when the headers are disabled.
+type SpamScoreConfig struct {
+	Enabled            bool    `yaml:"enabled"`
+	DNSBLWeight        float64 `yaml:"dnsbl_weight"`         // per DNSBL provider listing the client IP or sender domain
+	NoRDNSWeight       float64 `yaml:"no_rdns_weight"`       // client IP has no reverse DNS name (only when reverse_dns is enabled)
+	HeloMismatchWeight float64 `yaml:"helo_mismatch_weight"` // HELO/EHLO name differs from the client's reverse DNS name
+	HeloNonFQDNWeight  float64 `yaml:"helo_non_fqdn_weight"` // HELO/EHLO name has no dot, e.g. "desktop"
+	ScoreHeader        string  `yaml:"score_header"`
+	ReportHeader       string  `yaml:"report_header"`
+}
+
+// RejectScoreConfig refuses a transaction at MAIL FROM with 554 when the
+// spam_score weights of the weak signals about an unauthenticated TCP client
+// add up to the threshold. Unlike spam_score it acts instead of reporting.
+type RejectScoreConfig struct {
+	Enabled   bool    `yaml:"enabled"`
+	Threshold float64 `yaml:"threshold"` // reject when the score reaches this
+}
+
+// ContentChecksConfig names regex rule files applied to received messages
+// before they are queued. Each line is "/regex/[i] REJECT|DISCARD|WARN [text]".
+type ContentChecksConfig struct {
+	HeaderChecksFile string `yaml:"header_checks_file"` // matched against each unfolded header field
+	BodyChecksFile   string `yaml:"body_checks_file"`   // matched against each body line
+}
+
+// InvalidRecipientsConfig slows down and drops clients harvesting addresses.
+// Thresholds count consecutive "User unknown" replies; a valid RCPT resets the
+// count. A threshold of 0 disables that step.
+type InvalidRecipientsConfig struct {
+	TarpitAfter     int           `yaml:"tarpit_after"`     // delay each further rejection by tarpit_delay
+	TarpitDelay     time.Duration `yaml:"tarpit_delay"`
+	DisconnectAfter int           `yaml:"disconnect_after"` // reject with 550 and close the connection
+}
+
+// SubmissionRateLimitConfig caps accepted messages within a sliding window.
+// A limit of 0 disables that cap.
+type SubmissionRateLimitConfig struct {
+	PerIP   int           `yaml:"per_ip"`
+	PerUser int           `yaml:"per_user"` // authenticated users; replaces per_ip for them when set
+	Global  int           `yaml:"global"`
+	Window  time.Duration `yaml:"window"`
 }
 
 type ReverseDNSConfig struct {
@@ -87,7 +351,8 @@ type DNSBLConfig struct {
 	CheckIP           bool     `yaml:"check_ip"`
 	CheckSenderDomain bool     `yaml:"check_sender_domain"`
 	Providers         []string `yaml:"providers"`
-	Action            string   `yaml:"action"` // "reject" or "log"
+	Action            string   `yaml:"action"` // "reject", "log", "discard" (accept, then drop) or "tag" (add an X-DNSBL header)
+	StopOnFirstHit    bool     `yaml:"stop_on_first_hit"` // with action reject, cancel the other lookups once a provider lists the client
 }
 
 type LoggingConfig struct {
@@ -101,12 +366,25 @@ type QueueConfig struct {
 	PublishTimeout time.Duration `yaml:"publish_timeout"`
 	RetryDelay     time.Duration `yaml:"retry_delay"`
 	MaxRetryDelay  time.Duration `yaml:"max_retry_delay"`
+	StatsInterval  time.Duration `yaml:"stats_interval"` // how often queue stats are logged (0 = disabled)
+
+	// Spool janitor: retention of 0 keeps messages forever
+	JanitorInterval    time.Duration `yaml:"janitor_interval"` // how often expired spool files are reaped (0 = disabled)
+	DeliveredRetention time.Duration `yaml:"delivered_retention"`
+	FailedRetention    time.Duration `yaml:"failed_retention"`
+
+	HoldFailed bool `yaml:"hold_failed"` // quarantine undeliverable messages in hold/ for review and Requeue instead of failed/
 }
 
 type DeliveryConfig struct {
 	Local    LocalDeliveryConfig    `yaml:"local"`
 	Virtual  VirtualDeliveryConfig  `yaml:"virtual"`
 	Outbound OutboundDeliveryConfig `yaml:"outbound"`
+
+	// TransportMaps routes a recipient address or domain to a delivery method
+	// other than its default: "local", "virtual[:<basepath>]",
+	// "relay:<host[:port]>" or "command:<prog>". Exact addresses win over domains.
+	TransportMaps map[string]string `yaml:"transport_maps"`
 }
 
 type OutboundDeliveryConfig struct {
@@ -116,6 +394,17 @@ type OutboundDeliveryConfig struct {
 	Timeouts      OutboundTimeouts     `yaml:"timeouts"`
 	TLS           OutboundTLSConfig    `yaml:"tls"`
 	DKIM          DKIMConfig           `yaml:"dkim"`
+	Smarthost     SmarthostConfig      `yaml:"smarthost"`
+}
+
+// SmarthostConfig routes all relay and external mail through an upstream
+// server instead of direct MX delivery
+type SmarthostConfig struct {
+	Host     string `yaml:"host"`     // empty delivers direct to MX
+	Port     int    `yaml:"port"`     // 587 by default
+	Username string `yaml:"username"` // empty skips AUTH
+	Password string `yaml:"password"`
+	TLS      string `yaml:"tls"`      // "starttls" (required) | "implicit" | "none"
 }
 
 type DKIMConfig struct {
@@ -140,23 +429,65 @@ type OutboundTLSConfig struct {
 }
 
 type LocalDeliveryConfig struct {
-	BaseDirPath string `yaml:"base_dir_path"`
-	MaxWorkers  int    `yaml:"max_workers"`
+	BaseDirPath       string `yaml:"base_dir_path"`
+	MaxWorkers        int    `yaml:"max_workers"`
+	ExtendedFilenames bool   `yaml:"extended_filenames"` // Dovecot/Courier ",S=<size>:2," Maildir filenames
+	ChownMaildir      bool   `yaml:"chown_maildir"`      // give Maildir dirs and files to the recipient when running as root
+
+	// RecipientDelimiter enables plus-addressing like Postfix recipient_delimiter:
+	// "alice+lists@" is delivered to user alice. Any of the characters may start
+	// the extension; empty disables.
+	RecipientDelimiter string `yaml:"recipient_delimiter"`
+
+	// Mailbox command: pipe the message to a program (e.g. procmail) instead of
+	// Maildir delivery. Run by /bin/sh -c with RECIPIENT, SENDER and MESSAGE_ID set.
+	MailboxCommand        string            `yaml:"mailbox_command"`         // for every local user; empty = Maildir
+	MailboxCommands       map[string]string `yaml:"mailbox_commands"`        // username -> command, overrides mailbox_command
+	MailboxCommandTimeout time.Duration     `yaml:"mailbox_command_timeout"` // kill the command after this long (0 = no limit)
+}
+
+// MailboxCommandFor returns the mailbox command for a local user, or "" for Maildir delivery
+func (c *LocalDeliveryConfig) MailboxCommandFor(username string) string {
+	if command, ok := c.MailboxCommands[username]; ok {
+		return command
+	}
+	return c.MailboxCommand
 }
 
 type VirtualDeliveryConfig struct {
-	BaseDirPath string `yaml:"base_dir_path"`
-	MaxWorkers  int    `yaml:"max_workers"`
+	BaseDirPath         string `yaml:"base_dir_path"`
+	MaxWorkers          int    `yaml:"max_workers"`            // per-domain limit when max_workers_per_domain is unset
+	MaxWorkersPerDomain int    `yaml:"max_workers_per_domain"` // each domain gets its own pool of this size
+	ExtendedFilenames   bool   `yaml:"extended_filenames"`     // Dovecot/Courier ",S=<size>:2," Maildir filenames
+}
+
+// WorkersPerDomain returns the worker pool size for each virtual domain group
+func (c *VirtualDeliveryConfig) WorkersPerDomain() int {
+	if c.MaxWorkersPerDomain > 0 {
+		return c.MaxWorkersPerDomain
+	}
+	return c.MaxWorkers
 }
 
 type CacheConfig struct {
 	SystemUsers  UserCacheConfig `yaml:"system_users"`
 	VirtualUsers UserCacheConfig `yaml:"virtual_users"`
+	DNS          DNSCacheConfig  `yaml:"dns"` // dns_mx and dns_a email validation results
+}
+
+// DNSCacheConfig sizes the cache of MX and A/AAAA validation lookups. The Go
+// resolver does not expose record TTLs, so ttl applies to every positive answer.
+type DNSCacheConfig struct {
+	Capacity    int           `yaml:"capacity"`     // 0 disables the cache
+	TTL         time.Duration `yaml:"ttl"`          // how long a domain with records is remembered
+	NegativeTTL time.Duration `yaml:"negative_ttl"` // how long a domain without records is remembered; 0 = ttl
 }
 
 type UserCacheConfig struct {
-	Capacity int           `yaml:"capacity"`
-	TTL      time.Duration `yaml:"ttl"`
+	Capacity    int           `yaml:"capacity"`
+	TTL         time.Duration `yaml:"ttl"`          // how long an existing user is remembered
+	NegativeTTL time.Duration `yaml:"negative_ttl"` // how long an unknown user is remembered; 0 = ttl
+	Warm        []string      `yaml:"warm"`         // addresses looked up at startup (system users only)
 }
 
 type UserConfig struct {
@@ -171,22 +502,35 @@ func DefaultConfig() *Config {
 			Bind: "127.0.0.1",
 			Port: 2525, // legacy fallback
 			Listeners: []ListenerConfig{
-				{Port: 2525, Mode: ListenerModePlain},
+				{Port: 2525, Mode: ListenerModePlain, Role: ListenerRoleRelay},
 			},
 			Hostname:            "localhost",
 			MaxConnections:      10000,
 			MaxConnectionsPerIP: 1000,
 			MaxRecipients:       1000,             // RFC 5321 recommends 1000+ for production
+			MaxLineLength:       4096,             // RFC 5321 §4.5.3.1.4 allows 510, raised for AUTH tokens and MAIL parameters
 			MaxMessageSize:      10 * 1024 * 1024, // 10MB
+			DataBufferSize:      32 * 1024,
+			HeadersOnly:         HeadersOnlyAccept,
 			ReadTimeout:         30 * time.Second,
 			WriteTimeout:        30 * time.Second,
+			CommandTimeout:      5 * time.Minute, // RFC 5321 §4.5.3.2.7
+			DataTimeout:         3 * time.Minute, // RFC 5321 §4.5.3.2.5
+			MaxDataDuration:     10 * time.Minute, // RFC 5321 §4.5.3.2.6
 			EmailValidation:     []string{"basic"},
 			LocalDomains:        []string{"localhost"},      // System users
 			VirtualDomains:      []string{"mail.localhost"}, // Virtual users
 			RelayDomains:        []string{},                 // No relay by default
 			SpoolDir:            "/var/spool/golubsmtpd",
+			SpoolSyncDirs:       true,
 			SocketPath:          "/var/run/golubsmtpd/golubsmtpd.sock",
+			SocketHelo:          SocketHeloSkip,
 			LocalAliasesFilePath: "/etc/aliases",
+			LocalAliasesLoadTimeout:   30 * time.Second,
+			LocalAliasesLookupWorkers: 8,
+			AcceptPostmaster:     true,
+			AddMessageID:         true,
+			PostmasterMailbox:    "root@localhost",
 			TrustedUsers:        []string{"root", "mail", "daemon"},
 		},
 		TLS: TLSConfig{
@@ -201,6 +545,9 @@ func DefaultConfig() *Config {
 		Auth: AuthConfig{
 			PluginChain: []string{"memory"}, // Default single plugin
 			Plugins:     make(map[string]map[string]interface{}),
+			OAuth2: OAuth2Config{
+				Timeout: 5 * time.Second,
+			},
 		},
 		Security: SecurityConfig{
 			ReverseDNS: ReverseDNSConfig{
@@ -218,18 +565,49 @@ func DefaultConfig() *Config {
 				},
 				Action: "log",
 			},
+			ConnectionRejects: ConnectionRejectsConfig{
+				MaxConnections: RejectReply{Code: 421, Message: "Too many connections, try again later"},
+				PerIPLimit:     RejectReply{Code: 421, Message: "Too many connections from your address, try again later"},
+				ReverseDNS:     RejectReply{Code: 554, Message: "Reverse DNS lookup failed"},
+				DNSBL:          RejectReply{Code: 554, Message: "Client host blocked using DNSBL"},
+				Blocklist:      RejectReply{Code: 554, Message: "Access denied"},
+			},
+			SubmissionRateLimit: SubmissionRateLimitConfig{
+				Window: time.Minute,
+			},
+			InvalidRecipients: InvalidRecipientsConfig{
+				TarpitDelay: time.Second,
+			},
+			SpamScore: SpamScoreConfig{
+				DNSBLWeight:        2.0,
+				NoRDNSWeight:       1.0,
+				HeloMismatchWeight: 1.0,
+				HeloNonFQDNWeight:  1.5,
+				ScoreHeader:        "X-GolubSMTPd-Score",
+				ReportHeader:       "X-GolubSMTPd-Report",
+			},
+			RejectScore: RejectScoreConfig{
+				Threshold: 5.0,
+			},
 		},
 		Logging: LoggingConfig{
 			Level:  "info",
 			Format: "text",
 		},
 		Queue: QueueConfig{
-			BufferSize:   1000,
-			MaxConsumers: 10,
+			BufferSize:    1000,
+			MaxConsumers:  10,
+			StatsInterval: time.Minute,
+
+			JanitorInterval:    time.Hour,
+			DeliveredRetention: 7 * 24 * time.Hour,
+			FailedRetention:    0, // failed mail is only deleted once an operator opts in
 		},
 		Delivery: DeliveryConfig{
 			Local: LocalDeliveryConfig{
-				MaxWorkers: 10,
+				MaxWorkers:            10,
+				ChownMaildir:          true,
+				MailboxCommandTimeout: 5 * time.Minute,
 			},
 			Outbound: OutboundDeliveryConfig{
 				MaxWorkers:    10,
@@ -247,6 +625,10 @@ func DefaultConfig() *Config {
 					MinVersion: "tls12",
 					SkipVerify: false,
 				},
+				Smarthost: SmarthostConfig{
+					Port: 587,
+					TLS:  "starttls",
+				},
 			},
 			Virtual: VirtualDeliveryConfig{
 				BaseDirPath: "/var/mail/virtual",
@@ -255,12 +637,19 @@ func DefaultConfig() *Config {
 		},
 		Cache: CacheConfig{
 			SystemUsers: UserCacheConfig{
-				Capacity: 100,
-				TTL:      2 * time.Minute,
+				Capacity:    100,
+				TTL:         2 * time.Minute,
+				NegativeTTL: 30 * time.Second,
 			},
 			VirtualUsers: UserCacheConfig{
-				Capacity: 10000,
-				TTL:      2 * time.Minute,
+				Capacity:    10000,
+				TTL:         2 * time.Minute,
+				NegativeTTL: 30 * time.Second,
+			},
+			DNS: DNSCacheConfig{
+				Capacity:    10000,
+				TTL:         5 * time.Minute,
+				NegativeTTL: time.Minute,
 			},
 		},
 	}
@@ -0,0 +1,14 @@
+package config
+
+import "strings"
+
+// DomainMatches reports whether domain matches a configured domain entry.
+// Plain entries match exactly (case-insensitive); entries with a leading dot,
+// such as ".example.com", match example.com and all of its subdomains.
+func DomainMatches(entry, domain string) bool {
+	if suffix, ok := strings.CutPrefix(entry, "."); ok {
+		return strings.EqualFold(domain, suffix) ||
+			(len(domain) > len(entry) && strings.EqualFold(domain[len(domain)-len(entry):], entry))
+	}
+	return strings.EqualFold(entry, domain)
+}
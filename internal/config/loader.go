@@ -3,6 +3,8 @@ package config
 import (
 	"fmt"
 	"log/slog"
+	"net"
+	"net/mail"
 	"os"
 	"regexp"
 	"strings"
@@ -13,6 +15,12 @@ import (
 // dkimLabelRe matches a single DNS label: alphanumeric and hyphens, not starting/ending with hyphen.
 var dkimLabelRe = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9\-]*[A-Za-z0-9])?$|^[A-Za-z0-9]$`)
 
+// ehloKeywordRe matches an RFC 5321 §4.1.1.1 ehlo-keyword
+var ehloKeywordRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9\-]*$`)
+
+// headerNameRe matches an RFC 5322 §3.6.8 field-name: printable ASCII except colon
+var headerNameRe = regexp.MustCompile(`^[!-9;-~]+$`)
+
 // isValidDKIMDomain returns true if s looks like a valid domain name (dot-separated labels).
 func isValidDKIMDomain(s string) bool {
 	if s == "" {
@@ -64,17 +72,63 @@ func validateConfig(config *Config) error {
 		ListenerModePlain:    true,
 		ListenerModeSTARTTLS: true,
 		ListenerModeTLS:      true,
+		ListenerModeLMTP:     true,
 	}
-	for _, l := range config.Server.Listeners {
+	for i := range config.Server.Listeners {
+		l := &config.Server.Listeners[i]
 		if l.Port <= 0 || l.Port > 65535 {
 			return fmt.Errorf("invalid listener port: %d", l.Port)
 		}
+		if l.Role == "" {
+			l.Role = DefaultListenerRole(l.Port)
+		}
+		if l.Role != ListenerRoleRelay && l.Role != ListenerRoleSubmission {
+			return fmt.Errorf("invalid listener role %q for port %d (valid: relay, submission)", l.Role, l.Port)
+		}
 		if !validModes[l.Mode] {
-			return fmt.Errorf("invalid listener mode %q for port %d (valid: plain, starttls, tls)", l.Mode, l.Port)
+			return fmt.Errorf("invalid listener mode %q for port %d (valid: plain, starttls, tls, lmtp)", l.Mode, l.Port)
 		}
 		if (l.Mode == ListenerModeSTARTTLS || l.Mode == ListenerModeTLS) && !config.TLS.Enabled {
 			return fmt.Errorf("listener port %d uses mode %q but tls is not enabled", l.Port, l.Mode)
 		}
+		for _, mechanism := range l.AuthMechanisms {
+			if !config.Auth.MechanismEnabled(mechanism) {
+				return fmt.Errorf("listener port %d offers auth mechanism %q which auth.mechanisms does not enable", l.Port, mechanism)
+			}
+		}
+	}
+
+	hasBasic := false
+	for _, vType := range config.Server.EmailValidation {
+		switch vType {
+		case "basic":
+			hasBasic = true
+		case "extended", "dns_mx", "dns_a":
+		default:
+			return fmt.Errorf("invalid email_validation type %q (valid: basic, extended, dns_mx, dns_a)", vType)
+		}
+	}
+	if !hasBasic {
+		return fmt.Errorf("email_validation must include \"basic\", the other types build on it")
+	}
+
+	switch config.Server.SocketHelo {
+	case SocketHeloSkip, SocketHeloRequire, SocketHeloSynthesize:
+	default:
+		return fmt.Errorf("invalid socket_helo %q (valid: skip, require, synthesize)", config.Server.SocketHelo)
+	}
+
+	switch config.Server.HeadersOnly {
+	case HeadersOnlyAccept, HeadersOnlyReject:
+	default:
+		return fmt.Errorf("invalid headers_only %q (valid: accept, reject)", config.Server.HeadersOnly)
+	}
+
+	if _, err := config.TLS.TLSMinVersion(); err != nil {
+		return err
+	}
+	if _, err := config.TLS.CipherSuiteIDs(); err != nil {
+		return err
 	}
 
 	if config.Server.MaxConnections <= 0 {
@@ -85,10 +139,40 @@ func validateConfig(config *Config) error {
 		return fmt.Errorf("max_connections_per_ip must be positive: %d", config.Server.MaxConnectionsPerIP)
 	}
 
+	if config.Server.MaxSessionsPerUser < 0 {
+		return fmt.Errorf("max_sessions_per_user cannot be negative: %d", config.Server.MaxSessionsPerUser)
+	}
+
+	if config.Server.DataBufferSize < 0 {
+		return fmt.Errorf("data_buffer_size cannot be negative: %d", config.Server.DataBufferSize)
+	}
+
+	if config.Server.LocalAliasesLoadTimeout < 0 {
+		return fmt.Errorf("local_aliases_load_timeout cannot be negative: %s", config.Server.LocalAliasesLoadTimeout)
+	}
+	if config.Server.LocalAliasesLookupWorkers < 0 {
+		return fmt.Errorf("local_aliases_lookup_workers cannot be negative: %d", config.Server.LocalAliasesLookupWorkers)
+	}
+
+	if config.Server.MaxLineLength < 0 {
+		return fmt.Errorf("max_line_length cannot be negative: %d", config.Server.MaxLineLength)
+	}
+	if config.Server.MaxTransactionsPerConnection < 0 {
+		return fmt.Errorf("max_transactions_per_connection cannot be negative: %d", config.Server.MaxTransactionsPerConnection)
+	}
+
 	if config.Server.Hostname == "" {
 		return fmt.Errorf("hostname cannot be empty")
 	}
 
+	if config.Server.AcceptPostmaster || config.Server.AcceptAbuse {
+		mailbox := config.Server.PostmasterMailbox
+		if addr, err := mail.ParseAddress(mailbox); err != nil || addr.Name != "" || addr.Address != mailbox {
+			return fmt.Errorf("postmaster_mailbox must be a bare email address when accept_postmaster or accept_abuse is enabled: %q",
+				mailbox)
+		}
+	}
+
 	if config.Maildir.BasePath == "" {
 		return fmt.Errorf("maildir base_path cannot be empty")
 	}
@@ -113,10 +197,68 @@ func validateConfig(config *Config) error {
 
 	// Validate security settings
 	validDNSBLActions := map[string]bool{
-		"log": true, "reject": true,
+		"log": true, "reject": true, "discard": true, "tag": true,
 	}
 	if config.Security.DNSBL.Enabled && !validDNSBLActions[config.Security.DNSBL.Action] {
-		return fmt.Errorf("invalid dnsbl action: %s", config.Security.DNSBL.Action)
+		return fmt.Errorf("invalid dnsbl action: %s (valid: reject, log, discard, tag)", config.Security.DNSBL.Action)
+	}
+
+	if err := validateCIDRList("allowlist", config.Security.Allowlist); err != nil {
+		return err
+	}
+	if err := validateCIDRList("blocklist", config.Security.Blocklist); err != nil {
+		return err
+	}
+	for keyword := range config.Server.EhloExtensions {
+		if !ehloKeywordRe.MatchString(keyword) {
+			return fmt.Errorf("invalid ehlo_extensions keyword %q", keyword)
+		}
+	}
+	for code, text := range config.Server.ResponseMessages {
+		if code < 200 || code > 599 {
+			return fmt.Errorf("invalid response_messages code %d: must be an SMTP reply code from 200 to 599", code)
+		}
+		if strings.TrimSpace(text) == "" || strings.ContainsAny(text, "\r\n") {
+			return fmt.Errorf("invalid response_messages text for %d: must be a single non-empty line", code)
+		}
+	}
+	rejects := config.Security.ConnectionRejects
+	for name, reply := range map[string]RejectReply{
+		"max_connections": rejects.MaxConnections,
+		"per_ip_limit":    rejects.PerIPLimit,
+		"reverse_dns":     rejects.ReverseDNS,
+		"dnsbl":           rejects.DNSBL,
+		"blocklist":       rejects.Blocklist,
+	} {
+		if reply.Code < 400 || reply.Code > 599 {
+			return fmt.Errorf("invalid connection_rejects.%s code %d: must be 4xx or 5xx", name, reply.Code)
+		}
+		if strings.TrimSpace(reply.Message) == "" || strings.ContainsAny(reply.Message, "\r\n") {
+			return fmt.Errorf("invalid connection_rejects.%s message: must be a single non-empty line", name)
+		}
+	}
+	if err := validateCIDRList("expn_networks", config.Server.ExpnNetworks); err != nil {
+		return err
+	}
+	if err := validateCIDRList("trusted_networks", config.Security.TrustedNetworks); err != nil {
+		return err
+	}
+	if r := config.Security.InvalidRecipients; r.TarpitAfter < 0 || r.DisconnectAfter < 0 || r.TarpitDelay < 0 {
+		return fmt.Errorf("invalid_recipients settings cannot be negative")
+	}
+	if s := config.Security.SpamScore; s.Enabled {
+		if !headerNameRe.MatchString(s.ScoreHeader) || !headerNameRe.MatchString(s.ReportHeader) {
+			return fmt.Errorf("spam_score header names must be valid header field names, got %q and %q", s.ScoreHeader, s.ReportHeader)
+		}
+	}
+	if s := config.Security.SpamScore; s.Enabled || config.Security.RejectScore.Enabled {
+		if s.DNSBLWeight < 0 || s.NoRDNSWeight < 0 || s.HeloMismatchWeight < 0 || s.HeloNonFQDNWeight < 0 {
+			return fmt.Errorf("spam_score weights cannot be negative")
+		}
+	}
+
+	if s := config.Security.RejectScore; s.Enabled && s.Threshold <= 0 {
+		return fmt.Errorf("reject_score threshold must be positive: %.1f", s.Threshold)
 	}
 
 	// Validate outbound delivery TLS and timeout settings
@@ -133,6 +275,31 @@ func validateConfig(config *Config) error {
 	}
 	applyDefaultOutboundTimeouts(&config.Delivery.Outbound.Timeouts)
 
+	for key, spec := range config.Delivery.TransportMaps {
+		if strings.TrimLeft(key, "@") == "" {
+			return fmt.Errorf("invalid transport_maps key %q", key)
+		}
+		if _, err := ParseTransport(spec); err != nil {
+			return fmt.Errorf("invalid transport_maps entry for %q: %w", key, err)
+		}
+	}
+
+	if sh := config.Delivery.Outbound.Smarthost; sh.Host != "" {
+		if sh.Port <= 0 || sh.Port > 65535 {
+			return fmt.Errorf("invalid smarthost port %d", sh.Port)
+		}
+		validSmarthostTLS := map[string]bool{"starttls": true, "implicit": true, "none": true}
+		if !validSmarthostTLS[sh.TLS] {
+			return fmt.Errorf("invalid smarthost tls %q: must be starttls, implicit or none", sh.TLS)
+		}
+		if sh.Username != "" && sh.Password == "" {
+			return fmt.Errorf("smarthost.password is required when smarthost.username is set")
+		}
+		if sh.Username != "" && sh.TLS == "none" {
+			slog.Warn("smarthost credentials will be sent without TLS")
+		}
+	}
+
 	if d := config.Delivery.Outbound.DKIM; d.Enabled {
 		if d.Domain == "" {
 			return fmt.Errorf("dkim.domain is required when dkim is enabled")
@@ -159,6 +326,16 @@ func validateConfig(config *Config) error {
 	return nil
 }
 
+// validateCIDRList checks that every entry of a security list is a CIDR or IP address
+func validateCIDRList(name string, entries []string) error {
+	for _, entry := range entries {
+		if _, _, err := net.ParseCIDR(entry); err != nil && net.ParseIP(entry) == nil {
+			return fmt.Errorf("invalid security %s entry %q: must be a CIDR or IP address", name, entry)
+		}
+	}
+	return nil
+}
+
 // applyDefaultOutboundTimeouts fills zero-value timeout fields with safe defaults.
 // This handles partial YAML config where only some timeouts are overridden.
 func applyDefaultOutboundTimeouts(t *OutboundTimeouts) {
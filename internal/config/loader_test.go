@@ -0,0 +1,264 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeConfigFile writes YAML to a temporary config file and returns its path
+func writeConfigFile(t *testing.T, yaml string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "golubsmtpd.yaml")
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	return path
+}
+
+func TestLoad_TLSPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr string
+	}{
+		{"valid", "tls:\n  min_version: \"1.3\"\n  cipher_suites: [TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256]\n", ""},
+		{"unknown version", "tls:\n  min_version: \"1.1\"\n", "min_version"},
+		{"unknown cipher", "tls:\n  cipher_suites: [TLS_RSA_WITH_RC4_128_SHA]\n", "cipher suite"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(writeConfigFile(t, tt.yaml))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Load: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Load: want error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestLoad_ResponseMessages(t *testing.T) {
+	cfg, err := Load(writeConfigFile(t, "server:\n  response_messages:\n    250: \"Zrobione\"\n"))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if got := cfg.Server.ResponseMessages[250]; got != "Zrobione" {
+		t.Errorf("response_messages[250] = %q, want %q", got, "Zrobione")
+	}
+
+	_, err = Load(writeConfigFile(t, "server:\n  response_messages:\n    250: \"ok\\r\\n550 forged\"\n"))
+	if err == nil || !strings.Contains(err.Error(), "response_messages") {
+		t.Errorf("Load: want response_messages error for multi-line text, got %v", err)
+	}
+
+	_, err = Load(writeConfigFile(t, "server:\n  response_messages:\n    99: \"Made up\"\n"))
+	if err == nil || !strings.Contains(err.Error(), "invalid response_messages code 99") {
+		t.Errorf("Load: want response_messages error for code 99, got %v", err)
+	}
+}
+
+func TestLoad_TransportMaps(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr string
+	}{
+		{"valid", "delivery:\n  transport_maps:\n    example.org: \"virtual:/srv/mail\"\n    ops@example.com: \"command:/usr/local/bin/ticket\"\n    example.net: \"relay:[mx.example.net]:2525\"\n    example.com: local\n", ""},
+		{"unknown method", "delivery:\n  transport_maps:\n    example.org: \"uucp:gateway\"\n", "unknown method"},
+		{"relay without host", "delivery:\n  transport_maps:\n    example.org: \"relay:\"\n", "requires a host"},
+		{"bad relay port", "delivery:\n  transport_maps:\n    example.org: \"relay:mx.example.org:smtp\"\n", "invalid relay port"},
+		{"local with argument", "delivery:\n  transport_maps:\n    example.org: \"local:/tmp\"\n", "no argument"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(writeConfigFile(t, tt.yaml))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Load: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Load: want error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestLoad_EmailValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr string
+	}{
+		{"all types", "server:\n  email_validation: [basic, extended, dns_mx, dns_a]\n", ""},
+		{"typo", "server:\n  email_validation: [basic, externded]\n", "invalid email_validation type \"externded\""},
+		{"missing basic", "server:\n  email_validation: [extended]\n", "must include \"basic\""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(writeConfigFile(t, tt.yaml))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Load: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Load: want error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestLoad_HeadersOnly(t *testing.T) {
+	cfg, err := Load(writeConfigFile(t, "server:\n  hostname: mx.example.com\n"))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Server.HeadersOnly != HeadersOnlyAccept {
+		t.Errorf("headers_only default = %q, want %q", cfg.Server.HeadersOnly, HeadersOnlyAccept)
+	}
+
+	_, err = Load(writeConfigFile(t, "server:\n  headers_only: drop\n"))
+	if err == nil || !strings.Contains(err.Error(), "invalid headers_only \"drop\"") {
+		t.Errorf("Load: want invalid headers_only error, got %v", err)
+	}
+}
+
+func TestLoad_LocalAliasesLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr string
+	}{
+		{"defaults", "server:\n  hostname: mx.example.com\n", ""},
+		{"no time limit", "server:\n  local_aliases_load_timeout: 0s\n  local_aliases_lookup_workers: 32\n", ""},
+		{"negative timeout", "server:\n  local_aliases_load_timeout: -1s\n", "local_aliases_load_timeout cannot be negative"},
+		{"negative workers", "server:\n  local_aliases_lookup_workers: -4\n", "local_aliases_lookup_workers cannot be negative"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(writeConfigFile(t, tt.yaml))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Load: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Load: want error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestLoad_ListenerAuthMechanisms(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr string
+	}{
+		{"subset of all mechanisms", "server:\n  listeners:\n    - {port: 2525, mode: plain, auth_mechanisms: [plain]}\n", ""},
+		{"subset of configured mechanisms", "auth:\n  mechanisms: [PLAIN, LOGIN]\nserver:\n  listeners:\n    - {port: 2525, mode: plain, auth_mechanisms: [LOGIN]}\n", ""},
+		{"mechanism disabled globally", "auth:\n  mechanisms: [PLAIN]\nserver:\n  listeners:\n    - {port: 2525, mode: plain, auth_mechanisms: [LOGIN]}\n",
+			"listener port 2525 offers auth mechanism \"LOGIN\""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(writeConfigFile(t, tt.yaml))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Load: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Load: want error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestLoad_RejectScore(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr string
+	}{
+		{"defaults", "security:\n  reject_score:\n    enabled: true\n", ""},
+		{"zero threshold", "security:\n  reject_score:\n    enabled: true\n    threshold: 0\n", "reject_score threshold must be positive"},
+		{"negative weight", "security:\n  spam_score:\n    no_rdns_weight: -1\n  reject_score:\n    enabled: true\n", "spam_score weights cannot be negative"},
+		{"disabled is not checked", "security:\n  reject_score:\n    threshold: 0\n", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(writeConfigFile(t, tt.yaml))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Load: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Load: want error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestLoad_PostmasterMailbox(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr string
+	}{
+		{"default", "", ""},
+		{"virtual mailbox", "server:\n  postmaster_mailbox: \"admin@example.com\"\n", ""},
+		{"no domain", "server:\n  postmaster_mailbox: \"root@\"\n", "postmaster_mailbox must be a bare email address"},
+		{"display name", "server:\n  postmaster_mailbox: \"Root <root@localhost>\"\n", "postmaster_mailbox must be a bare email address"},
+		{"unused when disabled", "server:\n  accept_postmaster: false\n  postmaster_mailbox: \"\"\n", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(writeConfigFile(t, tt.yaml))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Load: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Load: want error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestLoad_ConnectionRejects(t *testing.T) {
+	cfg, err := Load(writeConfigFile(t, "security:\n  connection_rejects:\n    dnsbl:\n      message: \"Listed, see https://example.org/delist\"\n"))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if got := cfg.Security.ConnectionRejects.DNSBL; got.Code != 554 || got.Message != "Listed, see https://example.org/delist" {
+		t.Errorf("dnsbl reply = %+v, want default code with the configured message", got)
+	}
+
+	_, err = Load(writeConfigFile(t, "security:\n  connection_rejects:\n    blocklist:\n      code: 250\n"))
+	if err == nil || !strings.Contains(err.Error(), "connection_rejects.blocklist") {
+		t.Errorf("Load: want connection_rejects.blocklist error for code 250, got %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// TransportKind names a delivery method in delivery.transport_maps
+type TransportKind string
+
+const (
+	TransportLocal   TransportKind = "local"   // system user Maildir or mailbox command
+	TransportVirtual TransportKind = "virtual" // virtual Maildir, optionally under another base path
+	TransportRelay   TransportKind = "relay"   // SMTP to a fixed host instead of MX
+	TransportCommand TransportKind = "command" // pipe to a program run by /bin/sh
+)
+
+// defaultRelayPort is used by relay transports that name no port
+const defaultRelayPort = 25
+
+// Transport is a parsed transport_maps value
+type Transport struct {
+	Kind TransportKind
+	Arg  string // virtual base path, relay host[:port] or command; empty for local
+}
+
+// ParseTransport parses "local", "virtual[:<basepath>]", "relay:<host[:port]>"
+// or "command:<prog>"
+func ParseTransport(spec string) (Transport, error) {
+	kind, arg, _ := strings.Cut(strings.TrimSpace(spec), ":")
+	t := Transport{Kind: TransportKind(strings.ToLower(kind)), Arg: strings.TrimSpace(arg)}
+
+	switch t.Kind {
+	case TransportLocal:
+		if t.Arg != "" {
+			return Transport{}, fmt.Errorf("transport %q: local takes no argument", spec)
+		}
+	case TransportVirtual:
+	case TransportRelay:
+		if t.Arg == "" {
+			return Transport{}, fmt.Errorf("transport %q: relay requires a host", spec)
+		}
+		if _, _, err := t.RelayHostPort(); err != nil {
+			return Transport{}, fmt.Errorf("transport %q: %w", spec, err)
+		}
+	case TransportCommand:
+		if t.Arg == "" {
+			return Transport{}, fmt.Errorf("transport %q: command requires a program", spec)
+		}
+	default:
+		return Transport{}, fmt.Errorf("transport %q: unknown method (valid: local, virtual, relay, command)", spec)
+	}
+	return t, nil
+}
+
+// RelayHostPort splits a relay transport's host[:port], defaulting to port 25
+func (t Transport) RelayHostPort() (string, int, error) {
+	host, portStr, err := net.SplitHostPort(t.Arg)
+	if err != nil {
+		// No port given
+		return strings.Trim(t.Arg, "[]"), defaultRelayPort, nil
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return "", 0, fmt.Errorf("invalid relay port %q", portStr)
+	}
+	return host, port, nil
+}
@@ -1,28 +1,88 @@
 package delivery
 
 import (
+	"bufio"
+	"bytes"
 	"fmt"
+	"io"
+	"os"
 	"strings"
 	"time"
 
 	"github.com/pawciobiel/golubsmtpd/internal/types"
 )
 
+// DSNAction is the RFC 3464 Action reported for the recipients of a
+// delivery status notification
+type DSNAction string
+
+const (
+	DSNFailed    DSNAction = "failed"
+	DSNDelayed   DSNAction = "delayed"
+	DSNDelivered DSNAction = "delivered"
+	DSNRelayed   DSNAction = "relayed" // handed to a server that sends no notifications itself
+)
+
+// maxReturnedContent caps the original message returned with RET=FULL
+const maxReturnedContent = 1 << 20
+
+// notifyEvent returns the RFC 3461 NOTIFY keyword that asks for a
+func (a DSNAction) notifyEvent() string {
+	switch a {
+	case DSNDelayed:
+		return "DELAY"
+	case DSNDelivered, DSNRelayed:
+		return "SUCCESS"
+	default:
+		return "FAILURE"
+	}
+}
+
+// status returns the RFC 3463 status code reported for a
+func (a DSNAction) status() string {
+	switch a {
+	case DSNDelayed:
+		return "4.0.0"
+	case DSNDelivered, DSNRelayed:
+		return "2.0.0"
+	default:
+		return "5.0.0"
+	}
+}
+
+// summary returns the subject suffix and the sentence introducing the recipients
+func (a DSNAction) summary() (string, string) {
+	switch a {
+	case DSNDelayed:
+		return "Delay", "Delivery to the following recipients has been delayed and will be retried:"
+	case DSNDelivered:
+		return "Success", "Your message was delivered to the following recipients:"
+	case DSNRelayed:
+		return "Relayed", "Your message was relayed to the following recipients, whose servers send no further notifications:"
+	default:
+		return "Failure", "Your message could not be delivered to the following recipients:"
+	}
+}
+
 // GenerateDSN creates an RFC 3464 delivery status notification addressed to the
-// original sender. Returns a Message with RawBody set, ready to be written to spool.
-// The bounce uses a null reverse-path (<>) per RFC 5321 §4.5.5.
-func GenerateDSN(original *types.Message, failedRecipients []string, reason, localHostname string) *types.Message {
+// original sender. Returns a Message with RawBody set and no recipients yet:
+// the caller routes it to the sender by domain before writing it to spool.
+// The bounce uses a null reverse-path (<>) per RFC 5321 §4.5.5. The original
+// message is read from messagePath and returned in full only with RET=FULL
+// (RFC 3461 §4.3), otherwise just its headers.
+func GenerateDSN(original *types.Message, recipients []string, action DSNAction, reason, localHostname, messagePath string) *types.Message {
 	now := time.Now().UTC()
 	msgID := types.GenerateID()
 	timestamp := now.Format("Mon, 02 Jan 2006 15:04:05 -0000")
 	boundary := msgID
+	subject, intro := action.summary()
 
 	var sb strings.Builder
 
 	// RFC 2822 headers
 	fmt.Fprintf(&sb, "From: Mail Delivery Subsystem <mailer-daemon@%s>\r\n", localHostname)
 	fmt.Fprintf(&sb, "To: %s\r\n", original.From)
-	fmt.Fprintf(&sb, "Subject: Delivery Status Notification (Failure)\r\n")
+	fmt.Fprintf(&sb, "Subject: Delivery Status Notification (%s)\r\n", subject)
 	fmt.Fprintf(&sb, "Date: %s\r\n", timestamp)
 	fmt.Fprintf(&sb, "Message-ID: <%s@%s>\r\n", msgID, localHostname)
 	fmt.Fprintf(&sb, "MIME-Version: 1.0\r\n")
@@ -34,40 +94,100 @@ func GenerateDSN(original *types.Message, failedRecipients []string, reason, loc
 	fmt.Fprintf(&sb, "--%s\r\n", boundary)
 	fmt.Fprintf(&sb, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
 	fmt.Fprintf(&sb, "This is the mail delivery agent at %s.\r\n\r\n", localHostname)
-	fmt.Fprintf(&sb, "Your message could not be delivered to the following recipients:\r\n\r\n")
-	for _, r := range failedRecipients {
+	fmt.Fprintf(&sb, "%s\r\n\r\n", intro)
+	for _, r := range recipients {
 		fmt.Fprintf(&sb, "  <%s>\r\n", r)
 	}
-	fmt.Fprintf(&sb, "\r\nReason: %s\r\n\r\n", reason)
+	if reason != "" {
+		fmt.Fprintf(&sb, "\r\nReason: %s\r\n", reason)
+	}
+	fmt.Fprintf(&sb, "\r\n")
 
 	// Part 2: RFC 3464 machine-readable delivery status
 	fmt.Fprintf(&sb, "--%s\r\n", boundary)
 	fmt.Fprintf(&sb, "Content-Type: message/delivery-status\r\n\r\n")
+	if original.DSNEnvID != "" {
+		fmt.Fprintf(&sb, "Original-Envelope-Id: %s\r\n", original.DSNEnvID)
+	}
 	fmt.Fprintf(&sb, "Reporting-MTA: dns; %s\r\n", localHostname)
 	fmt.Fprintf(&sb, "Arrival-Date: %s\r\n\r\n", original.Created.UTC().Format("Mon, 02 Jan 2006 15:04:05 -0000"))
-	for _, r := range failedRecipients {
+	for _, r := range recipients {
+		if orcpt := original.RecipientDSN(r).ORcpt; orcpt != "" {
+			fmt.Fprintf(&sb, "Original-Recipient: %s\r\n", orcpt)
+		}
 		fmt.Fprintf(&sb, "Final-Recipient: rfc822; %s\r\n", r)
-		fmt.Fprintf(&sb, "Action: failed\r\n")
-		fmt.Fprintf(&sb, "Status: 5.0.0\r\n")
-		fmt.Fprintf(&sb, "Diagnostic-Code: smtp; %s\r\n\r\n", reason)
+		fmt.Fprintf(&sb, "Action: %s\r\n", action)
+		fmt.Fprintf(&sb, "Status: %s\r\n", action.status())
+		if reason != "" {
+			fmt.Fprintf(&sb, "Diagnostic-Code: smtp; %s\r\n", reason)
+		}
+		fmt.Fprintf(&sb, "\r\n")
 	}
 
-	// Part 3: original message headers only (not the full body per RFC 3464 §5)
+	// Part 3: the original message, or only its headers (RFC 3462 §3)
 	fmt.Fprintf(&sb, "--%s\r\n", boundary)
-	fmt.Fprintf(&sb, "Content-Type: message/rfc822\r\n\r\n")
-	fmt.Fprintf(&sb, "From: %s\r\n", original.From)
-	fmt.Fprintf(&sb, "Message-ID: <%s@%s>\r\n", original.ID, localHostname)
-	fmt.Fprintf(&sb, "Date: %s\r\n\r\n", original.Created.UTC().Format("Mon, 02 Jan 2006 15:04:05 -0000"))
+	full := original.DSNRet == "FULL"
+	content, err := returnedContent(messagePath, full)
+	if err != nil {
+		// Spool file unreadable: describe the original instead
+		full = false
+		content = fmt.Sprintf("From: %s\r\nMessage-ID: <%s@%s>\r\nDate: %s\r\n",
+			original.From, original.ID, localHostname, original.Created.UTC().Format("Mon, 02 Jan 2006 15:04:05 -0000"))
+	}
+	if full {
+		fmt.Fprintf(&sb, "Content-Type: message/rfc822\r\n\r\n")
+	} else {
+		fmt.Fprintf(&sb, "Content-Type: text/rfc822-headers\r\n\r\n")
+	}
+	sb.WriteString(content)
+	if !strings.HasSuffix(content, "\r\n") {
+		sb.WriteString("\r\n")
+	}
 
 	fmt.Fprintf(&sb, "--%s--\r\n", boundary)
 
+	// The caller adds the original sender to the recipient set matching its domain
 	bounce := &types.Message{
-		ID:      msgID,
-		From:    "", // null reverse-path per RFC 5321 §4.5.5
-		Created: now,
-		// DSN is delivered locally to the original sender
-		LocalRecipients: map[string]struct{}{original.From: {}},
-		RawBody:         sb.String(),
+		ID:                 msgID,
+		From:               "", // null reverse-path per RFC 5321 §4.5.5
+		Created:            now,
+		LocalRecipients:    types.NewRecipientSet(),
+		VirtualRecipients:  types.NewRecipientSet(),
+		RelayRecipients:    types.NewRecipientSet(),
+		ExternalRecipients: types.NewRecipientSet(),
+		RawBody:            sb.String(),
 	}
 	return bounce
 }
+
+// returnedContent reads the spooled message for a DSN: all of it (up to
+// maxReturnedContent) when full, otherwise the header block only
+func returnedContent(messagePath string, full bool) (string, error) {
+	f, err := os.Open(messagePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if full {
+		data, err := io.ReadAll(io.LimitReader(f, maxReturnedContent))
+		return string(data), err
+	}
+
+	var headers bytes.Buffer
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadString('\n')
+		if line == "\r\n" || line == "\n" {
+			break
+		}
+		headers.WriteString(line)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return headers.String(), nil
+}
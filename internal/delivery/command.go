@@ -0,0 +1,142 @@
+package delivery
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pawciobiel/golubsmtpd/internal/types"
+)
+
+// exTempFail is the sysexits.h EX_TEMPFAIL status: the command asks for a retry
+const exTempFail = 75
+
+// maxCommandOutput caps the command output kept for the error message
+const maxCommandOutput = 512
+
+// DeliveryError is a failed delivery classified as transient (worth retrying)
+// or permanent (should bounce)
+type DeliveryError struct {
+	Recipient string
+	Temporary bool
+	Err       error
+}
+
+func (e *DeliveryError) Error() string {
+	kind := "permanent"
+	if e.Temporary {
+		kind = "temporary"
+	}
+	return fmt.Sprintf("%s delivery failure for %s: %v", kind, e.Recipient, e.Err)
+}
+
+func (e *DeliveryError) Unwrap() error { return e.Err }
+
+// IsTemporary reports whether err is a DeliveryError worth retrying
+func IsTemporary(err error) bool {
+	var deliveryErr *DeliveryError
+	return errors.As(err, &deliveryErr) && deliveryErr.Temporary
+}
+
+// deliverToCommand pipes X-Original-To, headers and the message to a mailbox
+// command run by /bin/sh.
+// An empty username runs the command as the server user (transport_maps).
+// Otherwise, when the server has the privilege, it runs as the recipient's
+// user, and a root server refuses to run it when that user cannot be resolved.
+// Exit status 75 (EX_TEMPFAIL), a timeout or a failure to start is temporary;
+// any other non-zero status is permanent.
+func deliverToCommand(ctx context.Context, msg *types.Message, messagePath, headers, recipient, username, command string, timeout time.Duration) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	message, err := os.Open(messagePath)
+	if err != nil {
+		return &DeliveryError{Recipient: recipient, Temporary: true, Err: fmt.Errorf("failed to open message: %w", err)}
+	}
+	defer message.Close()
+
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	cmd.Stdin = io.MultiReader(strings.NewReader(originalToHeader(msg, recipient)+headers), message)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	cmd.Env = []string{
+		"PATH=/usr/local/bin:/usr/bin:/bin",
+		"RECIPIENT=" + recipient,
+		"SENDER=" + msg.From,
+		"MESSAGE_ID=" + msg.ID,
+		"USER=" + username,
+	}
+
+	if username == "" {
+		return runCommand(ctx, cmd, &output, recipient)
+	}
+
+	u, err := user.Lookup(username)
+	if err == nil {
+		cmd.Dir = u.HomeDir
+		cmd.Env = append(cmd.Env, "HOME="+u.HomeDir)
+	}
+	if os.Geteuid() == 0 {
+		// Never run a recipient's command with the server's root privileges
+		if err != nil {
+			return &DeliveryError{Recipient: recipient, Temporary: true, Err: fmt.Errorf("mailbox command user %q: %w", username, err)}
+		}
+		credential, err := userCredential(u)
+		if err != nil {
+			return &DeliveryError{Recipient: recipient, Temporary: true, Err: err}
+		}
+		cmd.SysProcAttr = &syscall.SysProcAttr{Credential: credential}
+	}
+	return runCommand(ctx, cmd, &output, recipient)
+}
+
+// runCommand runs a prepared mailbox command and classifies its failure
+func runCommand(ctx context.Context, cmd *exec.Cmd, output *bytes.Buffer, recipient string) error {
+	err := cmd.Run()
+	if err == nil {
+		return nil
+	}
+
+	detail := strings.TrimSpace(output.String())
+	if len(detail) > maxCommandOutput {
+		detail = detail[:maxCommandOutput]
+	}
+	if ctx.Err() != nil {
+		return &DeliveryError{Recipient: recipient, Temporary: true, Err: fmt.Errorf("mailbox command: %w", ctx.Err())}
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.Exited() {
+		return &DeliveryError{
+			Recipient: recipient,
+			Temporary: exitErr.ExitCode() == exTempFail,
+			Err:       fmt.Errorf("mailbox command exited with status %d: %s", exitErr.ExitCode(), detail),
+		}
+	}
+	return &DeliveryError{Recipient: recipient, Temporary: true, Err: fmt.Errorf("mailbox command: %w", err)}
+}
+
+// userCredential returns the process credential for running as u
+func userCredential(u *user.User) (*syscall.Credential, error) {
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid uid %q for %s: %w", u.Uid, u.Username, err)
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gid %q for %s: %w", u.Gid, u.Username, err)
+	}
+	return &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}, nil
+}
@@ -9,12 +9,20 @@ import (
 type DeliverFunc func(ctx context.Context, recipient string) error
 
 // DeliverWithWorkers orchestrates concurrent delivery using semaphore-limited goroutines
-// This eliminates boilerplate code common to all delivery types
+// This eliminates boilerplate code common to all delivery types.
+// Recipients already recorded in markers are reported successful without being
+// delivered again, and each new success is recorded; markers may be nil.
+// Each outcome is also recorded in status, which is saved once every recipient
+// has reported; status may be nil.
+// Failures that are a temporary DeliveryError are reported as TempFailed so
+// the recipient is deferred for retry; any other failure is Failed.
 func DeliverWithWorkers(
 	ctx context.Context,
 	recipients map[string]struct{},
 	maxWorkers int,
 	recipientType RecipientType,
+	markers *DeliveryMarkers,
+	status *DeliveryStatus,
 	deliverFunc DeliverFunc,
 ) DeliveryResult {
 	result := DeliveryResult{
@@ -42,7 +50,24 @@ func DeliverWithWorkers(
 		go func(recipient string) {
 			defer func() { <-sem }() // Release semaphore
 
+			if markers.Delivered(recipient) {
+				slog.Info("Skipping already delivered recipient",
+					"recipient", recipient,
+					"type", recipientType)
+				resultChan <- DeliveryOutcome{Recipient: recipient, Success: true}
+				return
+			}
+
 			err := deliverFunc(ctx, recipient)
+			if err == nil {
+				if markErr := markers.MarkDelivered(recipient); markErr != nil {
+					// The copy has landed; a missing marker only risks a duplicate on re-processing
+					slog.Error("Failed to record delivery marker",
+						"recipient", recipient,
+						"type", recipientType,
+						"error", markErr)
+				}
+			}
 			resultChan <- DeliveryOutcome{
 				Recipient: recipient,
 				Success:   err == nil,
@@ -54,11 +79,27 @@ func DeliverWithWorkers(
 	// Collect exactly the number of results we expect
 	for i := 0; i < len(recipients); i++ {
 		outcome := <-resultChan
+		temporary := !outcome.Success && IsTemporary(outcome.Error)
+		state := RecipientStateFailed
+		switch {
+		case outcome.Success:
+			state = RecipientStateDelivered
+		case temporary:
+			state = RecipientStateDeferred
+		}
+		status.Record(outcome.Recipient, state, outcome.Error)
+
 		if outcome.Success {
 			result.Successful = append(result.Successful, outcome.Recipient)
 			slog.Debug("Delivery successful",
 				"recipient", outcome.Recipient,
 				"type", recipientType)
+		} else if temporary {
+			result.TempFailed = append(result.TempFailed, outcome.Recipient)
+			slog.Warn("Delivery deferred",
+				"recipient", outcome.Recipient,
+				"type", recipientType,
+				"error", outcome.Error)
 		} else {
 			result.Failed = append(result.Failed, outcome.Recipient)
 			slog.Error("Delivery failed",
@@ -68,6 +109,12 @@ func DeliverWithWorkers(
 		}
 	}
 
+	if err := status.Save(); err != nil {
+		slog.Error("Failed to record delivery status",
+			"type", recipientType,
+			"error", err)
+	}
+
 	return result
 }
 
@@ -85,3 +132,49 @@ func GetMaxWorkers(configuredMax int, recipientCount int) int {
 
 	return maxWorkers
 }
+
+// DeliverByDomainWithWorkers groups recipients by domain and delivers each group
+// concurrently with its own pool of up to maxWorkersPerDomain workers, so a slow
+// mailbox store for one domain cannot hold up deliveries to the others
+func DeliverByDomainWithWorkers(
+	ctx context.Context,
+	recipients map[string]struct{},
+	maxWorkersPerDomain int,
+	recipientType RecipientType,
+	markers *DeliveryMarkers,
+	status *DeliveryStatus,
+	deliverFunc DeliverFunc,
+) DeliveryResult {
+	groups := groupByDomain(recipients)
+
+	resultChan := make(chan DeliveryResult, len(groups))
+	for domain, addrs := range groups {
+		go func() {
+			group := make(map[string]struct{}, len(addrs))
+			for _, addr := range addrs {
+				group[addr] = struct{}{}
+			}
+			maxWorkers := GetMaxWorkers(maxWorkersPerDomain, len(group))
+			slog.Debug("Dispatching domain delivery group",
+				"domain", domain,
+				"type", recipientType,
+				"recipients", len(group),
+				"workers", maxWorkers)
+			resultChan <- DeliverWithWorkers(ctx, group, maxWorkers, recipientType, markers, status, deliverFunc)
+		}()
+	}
+
+	result := DeliveryResult{
+		Type:       recipientType,
+		Successful: make([]string, 0, len(recipients)),
+		Failed:     make([]string, 0),
+	}
+	for range groups {
+		groupResult := <-resultChan
+		result.Successful = append(result.Successful, groupResult.Successful...)
+		result.Failed = append(result.Failed, groupResult.Failed...)
+		result.TempFailed = append(result.TempFailed, groupResult.TempFailed...)
+	}
+
+	return result
+}
@@ -0,0 +1,178 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDeliverByDomainWithWorkers_IsolatesDomains(t *testing.T) {
+	recipients := map[string]struct{}{
+		"alice@slow.example": {},
+		"bob@fast.example":   {},
+		"carol@fast.example": {},
+		"dave@FAST.example":  {},
+	}
+
+	release := make(chan struct{})
+	fastDone := make(chan string, 3)
+	deliverFunc := func(ctx context.Context, recipient string) error {
+		if strings.HasSuffix(recipient, "@slow.example") {
+			<-release // stalled filesystem
+			return nil
+		}
+		fastDone <- recipient
+		return nil
+	}
+
+	resultChan := make(chan DeliveryResult, 1)
+	go func() {
+		// One worker per domain: a shared pool of 1 would let slow.example block everyone
+		resultChan <- DeliverByDomainWithWorkers(context.Background(), recipients, 1, RecipientVirtual, nil, nil, deliverFunc)
+	}()
+
+	for range 3 {
+		select {
+		case <-fastDone:
+		case <-time.After(5 * time.Second):
+			close(release)
+			t.Fatal("fast.example deliveries were blocked by slow.example")
+		}
+	}
+	close(release)
+
+	result := <-resultChan
+	sort.Strings(result.Successful)
+	want := []string{"alice@slow.example", "bob@fast.example", "carol@fast.example", "dave@FAST.example"}
+	sort.Strings(want)
+	if diff := cmp.Diff(want, result.Successful); diff != "" {
+		t.Errorf("Successful mismatch (-want +got):\n%s", diff)
+	}
+	if len(result.Failed) != 0 {
+		t.Errorf("Expected no failures, got %v", result.Failed)
+	}
+	if result.Type != RecipientVirtual {
+		t.Errorf("Type: want %v, got %v", RecipientVirtual, result.Type)
+	}
+}
+
+func TestDeliverByDomainWithWorkers_PerDomainLimit(t *testing.T) {
+	recipients := map[string]struct{}{
+		"a1@a.example": {}, "a2@a.example": {}, "a3@a.example": {},
+		"b1@b.example": {}, "b2@b.example": {}, "b3@b.example": {},
+	}
+
+	var mu sync.Mutex
+	active := map[string]int{}
+	peak := map[string]int{}
+	deliverFunc := func(ctx context.Context, recipient string) error {
+		_, domain, _ := strings.Cut(recipient, "@")
+		mu.Lock()
+		active[domain]++
+		peak[domain] = max(peak[domain], active[domain])
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		active[domain]--
+		mu.Unlock()
+		return nil
+	}
+
+	result := DeliverByDomainWithWorkers(context.Background(), recipients, 2, RecipientVirtual, nil, nil, deliverFunc)
+
+	if len(result.Successful) != len(recipients) {
+		t.Fatalf("Expected %d successful deliveries, got %v", len(recipients), result.Successful)
+	}
+	for domain, n := range peak {
+		if n > 2 {
+			t.Errorf("Domain %s ran %d concurrent deliveries, limit is 2", domain, n)
+		}
+	}
+}
+
+func TestDeliverWithWorkers_SkipsMarkedRecipients(t *testing.T) {
+	spoolDir := t.TempDir()
+	recipients := map[string]struct{}{"alice@localhost": {}, "bob@localhost": {}}
+
+	markers, err := LoadDeliveryMarkers(spoolDir, "msg-1")
+	if err != nil {
+		t.Fatalf("LoadDeliveryMarkers failed: %v", err)
+	}
+	if err := markers.MarkDelivered("alice@localhost"); err != nil {
+		t.Fatalf("MarkDelivered failed: %v", err)
+	}
+
+	var mu sync.Mutex
+	var delivered []string
+	deliverFunc := func(ctx context.Context, recipient string) error {
+		mu.Lock()
+		delivered = append(delivered, recipient)
+		mu.Unlock()
+		return nil
+	}
+
+	result := DeliverWithWorkers(context.Background(), recipients, 2, RecipientLocal, markers, nil, deliverFunc)
+
+	if diff := cmp.Diff([]string{"bob@localhost"}, delivered); diff != "" {
+		t.Errorf("Delivered recipients mismatch (-want +got):\n%s", diff)
+	}
+	sort.Strings(result.Successful)
+	if diff := cmp.Diff([]string{"alice@localhost", "bob@localhost"}, result.Successful); diff != "" {
+		t.Errorf("Successful mismatch (-want +got):\n%s", diff)
+	}
+
+	// Markers survive a restart
+	reloaded, err := LoadDeliveryMarkers(spoolDir, "msg-1")
+	if err != nil {
+		t.Fatalf("LoadDeliveryMarkers (reload) failed: %v", err)
+	}
+	for recipient := range recipients {
+		if !reloaded.Delivered(recipient) {
+			t.Errorf("Expected %s marked delivered after reload", recipient)
+		}
+	}
+}
+
+func TestDeliverWithWorkers_TemporaryFailureDeferred(t *testing.T) {
+	recipients := map[string]struct{}{
+		"ok@localhost":    {},
+		"later@localhost": {},
+		"never@localhost": {},
+	}
+	deliverFunc := func(ctx context.Context, recipient string) error {
+		switch recipient {
+		case "later@localhost":
+			return &DeliveryError{Recipient: recipient, Temporary: true, Err: errors.New("exit 75")}
+		case "never@localhost":
+			return &DeliveryError{Recipient: recipient, Err: errors.New("exit 1")}
+		}
+		return nil
+	}
+
+	status, err := NewDeliveryStatus(t.TempDir(), "msg-1", recipients)
+	if err != nil {
+		t.Fatalf("NewDeliveryStatus failed: %v", err)
+	}
+	result := DeliverWithWorkers(context.Background(), recipients, 2, RecipientLocal, nil, status, deliverFunc)
+
+	if diff := cmp.Diff([]string{"ok@localhost"}, result.Successful); diff != "" {
+		t.Errorf("Successful mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"later@localhost"}, result.TempFailed); diff != "" {
+		t.Errorf("TempFailed mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"never@localhost"}, result.Failed); diff != "" {
+		t.Errorf("Failed mismatch (-want +got):\n%s", diff)
+	}
+	if got := status.Recipients["later@localhost"].State; got != RecipientStateDeferred {
+		t.Errorf("Status of deferred recipient: want %q, got %q", RecipientStateDeferred, got)
+	}
+}
@@ -0,0 +1,109 @@
+package delivery
+
+import (
+	"bufio"
+	"fmt"
+	"net/mail"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+
+	"github.com/pawciobiel/golubsmtpd/internal/aliases"
+)
+
+// DeliveredToHeader marks each local mailbox a message has been delivered to;
+// it is prepended to forwarded copies and used to detect forwarding loops
+const DeliveredToHeader = "Delivered-To"
+
+// OriginalToHeader records the envelope recipient a delivered copy was
+// addressed to before alias expansion (Postfix X-Original-To)
+const OriginalToHeader = "X-Original-To"
+
+// GetForwardFilePath returns the path of a local user's .forward file,
+// or an empty string if the user has no home directory
+var GetForwardFilePath = func(username string) string {
+	u, err := user.Lookup(username)
+	if err != nil || u.HomeDir == "" {
+		return ""
+	}
+	return filepath.Join(u.HomeDir, ".forward")
+}
+
+// readForwardFile parses a .forward file: one destination per line, "#" comments.
+// A "\username" line matching the owner keeps a local copy; bare names are
+// treated as local users and validated like alias destinations.
+// A missing file returns no destinations and keepLocal=true.
+func readForwardFile(path, username string) (destinations []string, keepLocal bool, err error) {
+	if path == "" {
+		return nil, true, nil
+	}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, true, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to open forward file %s: %w", path, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if local, ok := strings.CutPrefix(line, `\`); ok {
+			if local == username {
+				keepLocal = true
+				continue
+			}
+			line = local
+		}
+
+		dest := aliases.NormalizeDestination(line)
+		if _, err := mail.ParseAddress(dest); err != nil {
+			return nil, false, fmt.Errorf("invalid forward destination %q in %s: %w", line, path, err)
+		}
+		if strings.HasSuffix(dest, "@localhost") {
+			if err := aliases.ValidateLocalDestination(dest); err != nil {
+				return nil, false, fmt.Errorf("invalid forward destination %q in %s: %w", line, path, err)
+			}
+		}
+		destinations = append(destinations, dest)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, false, fmt.Errorf("error reading forward file %s: %w", path, err)
+	}
+
+	// A .forward with no usable lines behaves like no .forward at all
+	if len(destinations) == 0 {
+		keepLocal = true
+	}
+	return destinations, keepLocal, nil
+}
+
+// hasDeliveredTo reports whether the message headers already carry a
+// Delivered-To header for recipient, which indicates a forwarding loop
+func hasDeliveredTo(messagePath, recipient string) (bool, error) {
+	file, err := os.Open(messagePath)
+	if err != nil {
+		return false, fmt.Errorf("failed to open message %s: %w", messagePath, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			break // end of headers
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if ok && strings.EqualFold(name, DeliveredToHeader) && strings.EqualFold(strings.TrimSpace(value), recipient) {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
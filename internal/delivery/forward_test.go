@@ -0,0 +1,124 @@
+package delivery
+
+import (
+	"context"
+	"os"
+	"os/user"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/pawciobiel/golubsmtpd/internal/config"
+)
+
+// setupForward points GetForwardFilePath at a temp .forward with content
+// (no file when content is empty)
+func setupForward(t *testing.T, content string) {
+	t.Helper()
+
+	forwardPath := filepath.Join(t.TempDir(), ".forward")
+	if content != "" {
+		if err := os.WriteFile(forwardPath, []byte(content), 0o600); err != nil {
+			t.Fatalf("Failed to write .forward: %v", err)
+		}
+	}
+
+	orig := GetForwardFilePath
+	GetForwardFilePath = func(string) string { return forwardPath }
+	t.Cleanup(func() { GetForwardFilePath = orig })
+}
+
+// countDelivered returns the number of messages in the user's Maildir new/
+func countDelivered(t *testing.T, baseDir, username string) int {
+	t.Helper()
+	files, err := os.ReadDir(filepath.Join(baseDir, username, "Maildir", "new"))
+	if os.IsNotExist(err) {
+		return 0
+	}
+	if err != nil {
+		t.Fatalf("Failed to read new/ directory: %v", err)
+	}
+	return len(files)
+}
+
+func TestDeliverToLocalUser_Forward(t *testing.T) {
+	currentUser, err := user.Current()
+	if err != nil {
+		t.Skip("Cannot get current user for forward test")
+	}
+	username := currentUser.Username
+	recipient := username + "@localhost"
+
+	tests := []struct {
+		name          string
+		forward       func(username string) string
+		looped        bool
+		wantForwards  []string
+		wantDelivered int
+	}{
+		{
+			name:          "forward to external",
+			forward:       func(string) string { return "# forward everything\nsomeone@example.net\n" },
+			wantForwards:  []string{"someone@example.net"},
+			wantDelivered: 0,
+		},
+		{
+			name:          "forward plus keep local copy",
+			forward:       func(username string) string { return "\\" + username + "\nsomeone@example.net\n" },
+			wantForwards:  []string{"someone@example.net"},
+			wantDelivered: 1,
+		},
+		{
+			name:          "missing .forward delivers normally",
+			forward:       func(string) string { return "" },
+			wantForwards:  nil,
+			wantDelivered: 1,
+		},
+		{
+			name:          "forwarding loop delivers locally",
+			forward:       func(string) string { return "someone@example.net\n" },
+			looped:        true,
+			wantForwards:  nil,
+			wantDelivered: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupForward(t, tt.forward(username))
+
+			ts := newTestSetup(t, "test-forward")
+			if tt.looped {
+				content := "Delivered-To: " + recipient + "\r\n" + ts.testContent
+				if err := os.WriteFile(ts.testMessagePath, []byte(content), 0o644); err != nil {
+					t.Fatalf("Failed to rewrite test message: %v", err)
+				}
+			}
+
+			cfg := &config.LocalDeliveryConfig{BaseDirPath: t.TempDir(), MaxWorkers: 1}
+			forwards, err := DeliverToLocalUser(context.Background(), ts.msg, ts.testMessagePath, recipient, cfg)
+			if err != nil {
+				t.Fatalf("DeliverToLocalUser failed: %v", err)
+			}
+
+			if diff := cmp.Diff(tt.wantForwards, forwards); diff != "" {
+				t.Errorf("forwards mismatch (-want +got):\n%s", diff)
+			}
+			if got := countDelivered(t, cfg.BaseDirPath, username); got != tt.wantDelivered {
+				t.Errorf("delivered to local Maildir: want %d, got %d", tt.wantDelivered, got)
+			}
+		})
+	}
+}
+
+func TestReadForwardFile_InvalidDestination(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".forward")
+	if err := os.WriteFile(path, []byte("no-such-user-golubsmtpd\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write .forward: %v", err)
+	}
+
+	if _, _, err := readForwardFile(path, "someone"); err == nil {
+		t.Fatal("Expected error for unknown local forward destination, got nil")
+	}
+}
@@ -6,7 +6,9 @@ import (
 	"io"
 	"log/slog"
 	"os"
+	"os/user"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 
@@ -23,30 +25,132 @@ var GetLocalMaildirPath = func(email string) string {
 
 // DeliverToLocalUser handles delivery to a single local user
 // Note: recipient is already validated by RCPT TO system user validation
-func DeliverToLocalUser(ctx context.Context, msg *types.Message, messagePath, recipient string, cfg *config.LocalDeliveryConfig) error {
-	// Extract username for path calculation
-	username := auth.ExtractUsername(recipient)
+//
+// If the user has a .forward file, its destinations are returned for the caller
+// to re-enqueue and local delivery only happens when the file keeps a local copy.
+// I/O failures are temporary DeliveryErrors, so the recipient is retried.
+func DeliverToLocalUser(ctx context.Context, msg *types.Message, messagePath, recipient string, cfg *config.LocalDeliveryConfig) ([]string, error) {
+	// Extract username for path calculation; the address extension only
+	// survives in the Delivered-To header
+	username := auth.StripAddressExtension(auth.ExtractUsername(recipient), cfg.RecipientDelimiter)
+	headers := fmt.Sprintf("%s: %s\r\n", DeliveredToHeader, recipient)
+
+	forwards, keepLocal, err := readForwardFile(GetForwardFilePath(username), username)
+	if err != nil {
+		return nil, temporaryFailure(recipient, err)
+	}
+	if len(forwards) > 0 {
+		loop, err := hasDeliveredTo(messagePath, recipient)
+		if err != nil {
+			return nil, temporaryFailure(recipient, err)
+		}
+		if loop {
+			// Deliver locally rather than lose the message
+			slog.Warn("Forwarding loop detected, delivering locally",
+				"recipient", recipient, "message_id", msg.ID)
+			forwards, keepLocal = nil, true
+		}
+	}
+	if !keepLocal {
+		slog.Info("Local recipient forwarded",
+			"recipient", recipient, "forwards", forwards, "message_id", msg.ID)
+		return forwards, nil
+	}
+
+	if command := cfg.MailboxCommandFor(username); command != "" {
+		if err := deliverToCommand(ctx, msg, messagePath, headers, recipient, username, command, cfg.MailboxCommandTimeout); err != nil {
+			return nil, err
+		}
+		slog.Info("Local delivery to mailbox command successful",
+			"recipient", recipient,
+			"username", username,
+			"message_id", msg.ID)
+		return forwards, nil
+	}
 
 	// Calculate Maildir base path for local user using centralized directory
 	// This avoids permission issues by writing to controlled directory
 	// Future: cfg could contain maildir format preference (Maildir vs mdir, etc.)
 	maildirBase := filepath.Join(cfg.BaseDirPath, username, "Maildir")
 
+	owner, err := localMaildirOwner(username, cfg)
+	if err != nil {
+		return nil, &DeliveryError{Recipient: recipient, Temporary: true, Err: err}
+	}
+	if owner != nil {
+		// The user's directory above Maildir must be theirs too or IMAP cannot reach it
+		userDir := filepath.Dir(maildirBase)
+		if err := os.MkdirAll(userDir, 0o700); err != nil {
+			return nil, temporaryFailure(recipient, fmt.Errorf("failed to create directory %s: %w", userDir, err))
+		}
+		if err := owner.chown(userDir); err != nil {
+			return nil, temporaryFailure(recipient, err)
+		}
+	}
+
 	// Perform the actual delivery
-	if err := deliverToMaildir(ctx, msg, messagePath, maildirBase, recipient); err != nil {
-		return err
+	if err := deliverToMaildir(ctx, msg, messagePath, maildirBase, recipient, headers, cfg.ExtendedFilenames, owner); err != nil {
+		return nil, temporaryFailure(recipient, err)
 	}
 
 	slog.Info("Local delivery successful",
 		"recipient", recipient,
 		"username", username,
+		"forwards", len(forwards),
 		"message_id", msg.ID)
 
+	return forwards, nil
+}
+
+// temporaryFailure marks a local I/O failure (disk full, unreadable spool
+// file, missing permissions) as temporary: it says nothing about the
+// recipient, so delivery is retried rather than given up
+func temporaryFailure(recipient string, err error) error {
+	return &DeliveryError{Recipient: recipient, Temporary: true, Err: err}
+}
+
+// fileOwner is the user delivered Maildir directories and files are handed to
+type fileOwner struct {
+	uid, gid int
+}
+
+// chownPath is os.Lchown, replaceable in tests
+var chownPath = os.Lchown
+
+// chown hands paths to the owner; a nil owner leaves them as they are
+func (o *fileOwner) chown(paths ...string) error {
+	if o == nil {
+		return nil
+	}
+	for _, path := range paths {
+		if err := chownPath(path, o.uid, o.gid); err != nil {
+			return fmt.Errorf("failed to chown %s to %d:%d: %w", path, o.uid, o.gid, err)
+		}
+	}
 	return nil
 }
 
-// createMaildirStructure creates the standard Maildir directory structure (new, cur, tmp)
-func createMaildirStructure(maildirPath string) error {
+// localMaildirOwner looks up the user a local Maildir delivery is chowned to.
+// Returns nil when chown_maildir is off or the server lacks the privilege to
+// give files away.
+func localMaildirOwner(username string, cfg *config.LocalDeliveryConfig) (*fileOwner, error) {
+	if !cfg.ChownMaildir || os.Geteuid() != 0 {
+		return nil, nil
+	}
+	u, err := user.Lookup(username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up owner for Maildir of %s: %w", username, err)
+	}
+	credential, err := userCredential(u)
+	if err != nil {
+		return nil, err
+	}
+	return &fileOwner{uid: int(credential.Uid), gid: int(credential.Gid)}, nil
+}
+
+// createMaildirStructure creates the standard Maildir directory structure (new,
+// cur, tmp), handing it to owner when not nil
+func createMaildirStructure(maildirPath string, owner *fileOwner) error {
 	dirs := []string{
 		filepath.Join(maildirPath, "new"),
 		filepath.Join(maildirPath, "cur"),
@@ -63,11 +167,12 @@ func createMaildirStructure(maildirPath string) error {
 		}
 	}
 
-	return nil
+	return owner.chown(append([]string{maildirPath}, dirs...)...)
 }
 
-// streamMessageToFile copies a message from source to destination with streaming
-func streamMessageToFile(ctx context.Context, sourcePath, destPath string) error {
+// streamMessageToFile copies a message from source to destination with streaming,
+// writing headers (may be empty) before it
+func streamMessageToFile(ctx context.Context, sourcePath, destPath, headers string) error {
 	// Check for context cancellation
 	select {
 	case <-ctx.Done():
@@ -94,7 +199,7 @@ func streamMessageToFile(ctx context.Context, sourcePath, destPath string) error
 	defer dstFile.Close()
 
 	// Stream copy
-	_, err = io.Copy(dstFile, srcFile)
+	_, err = io.Copy(dstFile, io.MultiReader(strings.NewReader(headers), srcFile))
 	if err != nil {
 		return fmt.Errorf("failed to copy message content: %w", err)
 	}
@@ -107,33 +212,60 @@ func streamMessageToFile(ctx context.Context, sourcePath, destPath string) error
 	return nil
 }
 
-// deliverToMaildir handles the common Maildir delivery logic
-func deliverToMaildir(ctx context.Context, msg *types.Message, messagePath, maildirBase, recipient string) error {
+// deliverToMaildir handles the common Maildir delivery logic. The copy starts
+// with an X-Original-To header and then headers; extendedFilename adds the
+// Dovecot/Courier size hint and info suffix to the filename. A non-nil owner
+// is given the Maildir directories and the delivered file.
+func deliverToMaildir(ctx context.Context, msg *types.Message, messagePath, maildirBase, recipient, headers string, extendedFilename bool, owner *fileOwner) error {
 	// Check for context cancellation
 	if err := ctx.Err(); err != nil {
 		return err
 	}
+	headers = originalToHeader(msg, recipient) + headers
 
 	// Create Maildir directory structure if it doesn't exist
-	if err := createMaildirStructure(maildirBase); err != nil {
+	if err := createMaildirStructure(maildirBase, owner); err != nil {
 		return fmt.Errorf("failed to create Maildir structure for %s: %w", recipient, err)
 	}
 
 	// Generate unique filename
 	uniqueFilename := generateUniqueFilename(msg.ID)
+	if extendedFilename {
+		info, err := os.Stat(messagePath)
+		if err != nil {
+			return fmt.Errorf("failed to stat message %s: %w", msg.ID, err)
+		}
+		uniqueFilename = extendMaildirFilename(uniqueFilename, info.Size()+int64(len(headers)))
+	}
 
-	// Write to new/ directory
-	maildirNew := filepath.Join(maildirBase, "new")
-	finalFile := filepath.Join(maildirNew, uniqueFilename)
+	// Write to tmp/ first so readers of new/ never see a partial file
+	tmpFile := filepath.Join(maildirBase, "tmp", uniqueFilename)
+	finalFile := filepath.Join(maildirBase, "new", uniqueFilename)
 
 	// Stream message from spool to Maildir
-	if err := streamMessageToFile(ctx, messagePath, finalFile); err != nil {
+	if err := streamMessageToFile(ctx, messagePath, tmpFile, headers); err != nil {
+		os.Remove(tmpFile)
+		return fmt.Errorf("failed to deliver message %s to %s: %w", msg.ID, recipient, err)
+	}
+	if err := owner.chown(tmpFile); err != nil {
+		os.Remove(tmpFile)
 		return fmt.Errorf("failed to deliver message %s to %s: %w", msg.ID, recipient, err)
 	}
 
+	// Atomic rename into new/ completes the delivery
+	if err := os.Rename(tmpFile, finalFile); err != nil {
+		os.Remove(tmpFile)
+		return fmt.Errorf("failed to move message %s into new/ for %s: %w", msg.ID, recipient, err)
+	}
+
 	return nil
 }
 
+// originalToHeader returns the X-Original-To header line for recipient's copy
+func originalToHeader(msg *types.Message, recipient string) string {
+	return fmt.Sprintf("%s: %s\r\n", OriginalToHeader, msg.OriginalRecipient(recipient))
+}
+
 // generateUniqueFilename creates a unique filename for Maildir delivery
 func generateUniqueFilename(messageID string) string {
 	timestamp := time.Now().Format("20060102T150405Z")
@@ -141,6 +273,12 @@ func generateUniqueFilename(messageID string) string {
 	return fmt.Sprintf("%s.%d.%s.%s", timestamp, pid, messageID, "golubsmtpd")
 }
 
+// extendMaildirFilename appends the ",S=<size>" hint and empty ":2," flags that
+// Dovecot and Courier use to avoid stat calls and to parse flags
+func extendMaildirFilename(filename string, size int64) string {
+	return fmt.Sprintf("%s,S=%d:2,", filename, size)
+}
+
 // isPermissionError checks if an error is related to insufficient permissions
 func isPermissionError(err error) bool {
 	if err == nil {
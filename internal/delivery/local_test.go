@@ -2,6 +2,8 @@ package delivery
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"os"
 	"os/user"
 	"path/filepath"
@@ -41,13 +43,14 @@ func TestDeliverToLocalUser(t *testing.T) {
 	if files, err := os.ReadDir(newDir); err == nil {
 		beforeCount = len(files)
 	}
-	err = DeliverToLocalUser(context.Background(), ts.msg, ts.testMessagePath, recipient, testConfig)
+	_, err = DeliverToLocalUser(context.Background(), ts.msg, ts.testMessagePath, recipient, testConfig)
 	if err != nil {
 		t.Fatalf("DeliverToLocalUser failed: %v", err)
 	}
 
-	// Verify Maildir structure was created
+	// Verify Maildir structure was created and tmp/ was drained
 	verifyMaildirStructure(t, maildirBase)
+	verifyMaildirTmpEmpty(t, maildirBase)
 
 	// Verify exactly one new file was added
 	files, err := os.ReadDir(newDir)
@@ -92,7 +95,7 @@ func TestDeliverToLocalUser_NonExistentUser(t *testing.T) {
 		BaseDirPath: restrictedDir,
 		MaxWorkers:  1,
 	}
-	err := DeliverToLocalUser(context.Background(), msg, ts.testMessagePath, "nonexistent@localhost", testConfig)
+	_, err := DeliverToLocalUser(context.Background(), msg, ts.testMessagePath, "nonexistent@localhost", testConfig)
 	if err == nil {
 		t.Fatal("Expected error for delivery to restricted directory")
 	}
@@ -119,13 +122,118 @@ func TestDeliverToLocalUser_CancelledContext(t *testing.T) {
 		BaseDirPath: filepath.Join(os.TempDir(), "golub-cancel-test"),
 		MaxWorkers:  1,
 	}
-	err = DeliverToLocalUser(ctx, msg, ts.testMessagePath, currentUser.Username+"@localhost", testConfig)
+	_, err = DeliverToLocalUser(ctx, msg, ts.testMessagePath, currentUser.Username+"@localhost", testConfig)
 
-	if err != context.Canceled {
-		t.Errorf("Expected context.Canceled, got: %v", err)
+	if !errors.Is(err, context.Canceled) || !IsTemporary(err) {
+		t.Errorf("Expected temporary context.Canceled, got: %v", err)
 	}
 }
 
+func TestDeliverToLocalUser_MailboxCommand(t *testing.T) {
+	currentUser, err := user.Current()
+	if err != nil {
+		t.Skip("Cannot get current user")
+	}
+
+	tests := []struct {
+		name          string
+		exitStatus    int
+		wantErr       bool
+		wantTemporary bool
+	}{
+		{"success", 0, false, false},
+		{"tempfail", 75, true, true},
+		{"permanent failure", 1, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestSetup(t, "test-mailbox-command")
+			outFile := filepath.Join(ts.tempDir, "delivered")
+			script := filepath.Join(ts.tempDir, "deliver.sh")
+			body := fmt.Sprintf("#!/bin/sh\n{ echo \"$RECIPIENT $SENDER $MESSAGE_ID\"; cat; } > %s\nexit %d\n", outFile, tt.exitStatus)
+			if err := os.WriteFile(script, []byte(body), 0o755); err != nil {
+				t.Fatalf("Failed to write script: %v", err)
+			}
+			// The command may run as the recipient, who must reach the script
+			os.Chmod(ts.tempDir, 0o777) //nolint:errcheck
+
+			recipient := currentUser.Username + "@localhost"
+			cfg := &config.LocalDeliveryConfig{
+				BaseDirPath:     filepath.Join(ts.tempDir, "maildir"),
+				MailboxCommands: map[string]string{currentUser.Username: script},
+			}
+			_, err := DeliverToLocalUser(context.Background(), ts.msg, ts.testMessagePath, recipient, cfg)
+
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatalf("DeliverToLocalUser failed: %v", err)
+				}
+				got, err := os.ReadFile(outFile)
+				if err != nil {
+					t.Fatalf("Command did not run: %v", err)
+				}
+				want := recipient + " " + ts.msg.From + " " + ts.msg.ID + "\nX-Original-To: " + recipient + "\r\nDelivered-To: " + recipient + "\r\n" + ts.testContent
+				if string(got) != want {
+					t.Errorf("Command input mismatch:\nwant: %q\ngot:  %q", want, string(got))
+				}
+				if _, err := os.Stat(cfg.BaseDirPath); !os.IsNotExist(err) {
+					t.Error("Maildir should not be used when a mailbox command is configured")
+				}
+				return
+			}
+
+			var deliveryErr *DeliveryError
+			if !errors.As(err, &deliveryErr) {
+				t.Fatalf("Expected DeliveryError, got %T: %v", err, err)
+			}
+			if deliveryErr.Temporary != tt.wantTemporary {
+				t.Errorf("Temporary: want %v, got %v (%v)", tt.wantTemporary, deliveryErr.Temporary, err)
+			}
+		})
+	}
+}
+
+func TestDeliverToCommand_RootWithoutUser(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("only a root server switches to the recipient's user")
+	}
+	ts := newTestSetup(t, "test-command-nouser")
+	marker := filepath.Join(ts.tempDir, "ran")
+
+	err := deliverToCommand(context.Background(), ts.msg, ts.testMessagePath, "", "ghost@localhost", "nonexistentuser12345", "touch "+marker, 0)
+
+	var deliveryErr *DeliveryError
+	if !errors.As(err, &deliveryErr) || !deliveryErr.Temporary {
+		t.Fatalf("Expected a temporary DeliveryError, got %v", err)
+	}
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Error("Command must not run as root when the user cannot be resolved")
+	}
+}
+
+func TestDeliverToLocalUser_RecipientDelimiter(t *testing.T) {
+	currentUser, err := user.Current()
+	if err != nil {
+		t.Skip("Cannot get current user")
+	}
+
+	ts := newTestSetup(t, "test-recipient-delimiter")
+	recipient := currentUser.Username + "+foo@localhost"
+	cfg := &config.LocalDeliveryConfig{
+		BaseDirPath:        filepath.Join(ts.tempDir, "maildir"),
+		RecipientDelimiter: "+",
+	}
+
+	if _, err := DeliverToLocalUser(context.Background(), ts.msg, ts.testMessagePath, recipient, cfg); err != nil {
+		t.Fatalf("DeliverToLocalUser failed: %v", err)
+	}
+
+	// Delivered to the bare user's Maildir, the tag kept in Delivered-To
+	newDir := filepath.Join(cfg.BaseDirPath, currentUser.Username, "Maildir", "new")
+	verifyDeliveredMessage(t, newDir, "X-Original-To: "+recipient+"\r\nDelivered-To: "+recipient+"\r\n"+ts.testContent, ts.msg.ID)
+}
+
 func TestGenerateUniqueFilename(t *testing.T) {
 	messageID := "test-msg-456"
 
@@ -158,6 +266,43 @@ func TestGenerateUniqueFilename_DifferentMessages(t *testing.T) {
 	}
 }
 
+func TestExtendMaildirFilename(t *testing.T) {
+	messageID := "test-msg-789"
+	base := generateUniqueFilename(messageID)
+
+	filename := extendMaildirFilename(base, 1234)
+
+	if want := base + ",S=1234:2,"; filename != want {
+		t.Errorf("extendMaildirFilename: want %q, got %q", want, filename)
+	}
+	if err := validateMaildirFilename(filename, messageID); err != nil {
+		t.Errorf("Extended filename rejected: %v", err)
+	}
+}
+
+func TestValidateMaildirFilename_Forms(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		wantErr  bool
+	}{
+		{"plain", "20250101T120000Z.42.msg-1.golubsmtpd", false},
+		{"size and info", "20250101T120000Z.42.msg-1.golubsmtpd,S=512:2,", false},
+		{"size and flags", "20250101T120000Z.42.msg-1.golubsmtpd,S=512:2,S", false},
+		{"bad size", "20250101T120000Z.42.msg-1.golubsmtpd,S=big:2,", true},
+		{"bad info version", "20250101T120000Z.42.msg-1.golubsmtpd,S=512:1,", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateMaildirFilename(tt.filename, "msg-1")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateMaildirFilename(%q) error = %v, wantErr %v", tt.filename, err, tt.wantErr)
+			}
+		})
+	}
+}
+
 func TestIsPermissionError(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -210,3 +355,66 @@ func TestIsPermissionError(t *testing.T) {
 		})
 	}
 }
+
+func TestDeliverToLocalUser_ChownMaildir(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("chown_maildir needs root")
+	}
+	currentUser, err := user.Current()
+	if err != nil {
+		t.Skip("Cannot get current user")
+	}
+	wantUID, _ := strconv.Atoi(currentUser.Uid)
+	wantGID, _ := strconv.Atoi(currentUser.Gid)
+
+	type chownCall struct {
+		path     string
+		uid, gid int
+	}
+	var calls []chownCall
+	orig := chownPath
+	chownPath = func(path string, uid, gid int) error {
+		calls = append(calls, chownCall{path, uid, gid})
+		return nil
+	}
+	t.Cleanup(func() { chownPath = orig })
+
+	ts := newTestSetup(t, "test-chown-"+strconv.FormatInt(time.Now().UnixNano(), 10))
+	cfg := &config.LocalDeliveryConfig{BaseDirPath: t.TempDir(), ChownMaildir: true}
+	if _, err := DeliverToLocalUser(context.Background(), ts.msg, ts.testMessagePath, currentUser.Username+"@localhost", cfg); err != nil {
+		t.Fatalf("DeliverToLocalUser failed: %v", err)
+	}
+
+	maildirBase := filepath.Join(cfg.BaseDirPath, currentUser.Username, "Maildir")
+	chowned := make(map[string]bool)
+	for _, call := range calls {
+		if call.uid != wantUID || call.gid != wantGID {
+			t.Errorf("chown %s to %d:%d, want %d:%d", call.path, call.uid, call.gid, wantUID, wantGID)
+		}
+		chowned[call.path] = true
+	}
+	for _, dir := range []string{filepath.Dir(maildirBase), maildirBase, filepath.Join(maildirBase, "new"), filepath.Join(maildirBase, "cur"), filepath.Join(maildirBase, "tmp")} {
+		if !chowned[dir] {
+			t.Errorf("Expected %s to be chowned", dir)
+		}
+	}
+	var fileChowned bool
+	for path := range chowned {
+		if strings.Contains(filepath.Base(path), ts.msg.ID) {
+			fileChowned = true
+		}
+	}
+	if !fileChowned {
+		t.Error("Expected the delivered message file to be chowned")
+	}
+
+	// Turned off, nothing is handed over
+	calls = nil
+	cfg.ChownMaildir = false
+	if _, err := DeliverToLocalUser(context.Background(), ts.msg, ts.testMessagePath, currentUser.Username+"@localhost", cfg); err != nil {
+		t.Fatalf("DeliverToLocalUser failed: %v", err)
+	}
+	if len(calls) != 0 {
+		t.Errorf("Expected no chown with chown_maildir off, got %v", calls)
+	}
+}
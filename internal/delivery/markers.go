@@ -0,0 +1,113 @@
+package delivery
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const markersDirName = "markers"
+
+// DeliveryMarkers records which recipients of a message have already been
+// delivered, so re-processing a partially delivered message (e.g. after a
+// crash mid-delivery) does not hand out duplicate copies. Markers are
+// appended to a sidecar file, one recipient per line, as each delivery succeeds.
+// A nil *DeliveryMarkers records nothing and reports nothing delivered.
+type DeliveryMarkers struct {
+	path      string
+	mu        sync.Mutex
+	delivered map[string]struct{}
+}
+
+// DeliveryMarkersDir returns the directory holding completion marker files.
+func DeliveryMarkersDir(spoolDir string) string {
+	return filepath.Join(spoolDir, markersDirName)
+}
+
+// DeliveryMarkersPath returns the path to the completion marker file for a message.
+func DeliveryMarkersPath(spoolDir, messageID string) string {
+	return filepath.Join(DeliveryMarkersDir(spoolDir), messageID+".delivered")
+}
+
+// LoadDeliveryMarkers reads existing markers for a message; a missing file means
+// no recipient has been delivered yet.
+func LoadDeliveryMarkers(spoolDir, messageID string) (*DeliveryMarkers, error) {
+	if err := os.MkdirAll(DeliveryMarkersDir(spoolDir), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create markers dir: %w", err)
+	}
+
+	m := &DeliveryMarkers{
+		path:      DeliveryMarkersPath(spoolDir, messageID),
+		delivered: make(map[string]struct{}),
+	}
+
+	file, err := os.Open(m.path)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open delivery markers for %s: %w", messageID, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// A torn final line from a crash is harmless: it never matches a recipient
+		if recipient := scanner.Text(); recipient != "" {
+			m.delivered[recipient] = struct{}{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read delivery markers for %s: %w", messageID, err)
+	}
+	return m, nil
+}
+
+// Delivered reports whether recipient already has a completion marker.
+func (m *DeliveryMarkers) Delivered(recipient string) bool {
+	if m == nil {
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.delivered[recipient]
+	return ok
+}
+
+// MarkDelivered durably records a successful delivery to recipient.
+func (m *DeliveryMarkers) MarkDelivered(recipient string) error {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	file, err := os.OpenFile(m.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open delivery markers: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.WriteString(recipient + "\n"); err != nil {
+		return fmt.Errorf("failed to write delivery marker: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync delivery markers: %w", err)
+	}
+	m.delivered[recipient] = struct{}{}
+	return nil
+}
+
+// Remove deletes the marker file once the message no longer needs it.
+func (m *DeliveryMarkers) Remove() error {
+	if m == nil {
+		return nil
+	}
+	err := os.Remove(m.path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete delivery markers: %w", err)
+	}
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 	"crypto/tls"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"net"
 	"net/textproto"
@@ -42,9 +43,11 @@ type domainResult struct {
 	successful []string
 	tempFailed []string
 	permFailed []string
+	dsnPassed  []string // successful recipients sent to a server advertising DSN
 }
 
-// DeliverOutboundWithWorkers delivers msg to all outbound recipients via direct MX.
+// DeliverOutboundWithWorkers delivers msg to all outbound recipients via direct MX,
+// or through the smarthost when one is configured.
 // Recipients are grouped by domain; maxWorkers limits concurrent domain connections.
 // signer may be nil when DKIM signing is disabled.
 func DeliverOutboundWithWorkers(
@@ -67,6 +70,15 @@ func DeliverOutboundWithWorkers(
 		return result
 	}
 
+	if cfg.Smarthost.Host != "" {
+		dr := deliverToSmarthost(ctx, msg, messagePath, sortedRecipients(recipients), cfg, signer)
+		result.Successful = append(result.Successful, dr.successful...)
+		result.TempFailed = append(result.TempFailed, dr.tempFailed...)
+		result.PermFailed = append(result.PermFailed, dr.permFailed...)
+		result.DSNPassed = append(result.DSNPassed, dr.dsnPassed...)
+		return result
+	}
+
 	byDomain := groupByDomain(recipients)
 
 	if maxWorkers <= 0 {
@@ -93,11 +105,30 @@ func DeliverOutboundWithWorkers(
 		result.Successful = append(result.Successful, dr.successful...)
 		result.TempFailed = append(result.TempFailed, dr.tempFailed...)
 		result.PermFailed = append(result.PermFailed, dr.permFailed...)
+		result.DSNPassed = append(result.DSNPassed, dr.dsnPassed...)
 	}
 
 	return result
 }
 
+// addOutcomes sorts per-recipient SMTP outcomes into the result lists;
+// peerDSN tells whether the server advertised DSN and so got the DSN parameters.
+func (dr *domainResult) addOutcomes(outcomes []recipientOutcome, peerDSN bool) {
+	for _, o := range outcomes {
+		switch o.category {
+		case smtpSuccess:
+			dr.successful = append(dr.successful, o.recipient)
+			if peerDSN {
+				dr.dsnPassed = append(dr.dsnPassed, o.recipient)
+			}
+		case smtpTempFail:
+			dr.tempFailed = append(dr.tempFailed, o.recipient)
+		case smtpPermFail:
+			dr.permFailed = append(dr.permFailed, o.recipient)
+		}
+	}
+}
+
 // groupByDomain groups email addresses by their domain part.
 func groupByDomain(recipients map[string]struct{}) map[string][]string {
 	byDomain := make(map[string][]string)
@@ -124,25 +155,16 @@ func deliverToDomain(ctx context.Context, msg *types.Message, messagePath, domai
 	}
 
 	for _, mx := range mxHosts {
-		conn, r, _, err := dialMX(ctx, mx, cfg)
+		conn, r, _, ehloLines, err := dialMX(ctx, mx, cfg)
 		if err != nil {
 			slog.Debug("outbound connect failed", "host", mx, "error", err)
 			continue
 		}
 
-		outcomes := sendViaSMTP(ctx, conn, r, mx, msg, messagePath, recipients, cfg, signer)
+		outcomes := sendViaSMTP(ctx, conn, r, ehloLines, mx, msg, messagePath, recipients, cfg, signer)
 		conn.Close()
 
-		for _, o := range outcomes {
-			switch o.category {
-			case smtpSuccess:
-				result.successful = append(result.successful, o.recipient)
-			case smtpTempFail:
-				result.tempFailed = append(result.tempFailed, o.recipient)
-			case smtpPermFail:
-				result.permFailed = append(result.permFailed, o.recipient)
-			}
-		}
+		result.addOutcomes(outcomes, ehloAdvertises(ehloLines, "DSN"))
 		return result
 	}
 
@@ -173,18 +195,36 @@ func lookupMX(ctx context.Context, domain string) ([]string, error) {
 
 // dialMX connects to host:25, reads the greeting, sends EHLO, and performs
 // STARTTLS according to cfg.TLS.Policy. Returns conn, a bounded reader
-// positioned after the post-EHLO exchange, and whether TLS is active.
+// positioned after the post-EHLO exchange, whether TLS is active, and the
+// final EHLO lines.
+func dialMX(ctx context.Context, host string, cfg *config.OutboundDeliveryConfig) (net.Conn, *bufio.Reader, bool, []string, error) {
+	return dialSMTP(ctx, host, outboundSMTPPort, cfg.TLS.Policy, cfg)
+}
+
+// dialSMTP connects to host:port, reads the greeting, sends EHLO, and secures
+// the session according to policy: "opportunistic" and "required" follow the
+// outbound STARTTLS rules, "implicit" handshakes TLS before the greeting, and
+// "none" never starts TLS. Returns conn, a bounded reader positioned after the
+// final EHLO exchange, whether TLS is active, and the final EHLO lines.
 //
 // All network operations use per-operation deadlines to defend against slow/rogue MTAs.
-func dialMX(ctx context.Context, host string, cfg *config.OutboundDeliveryConfig) (net.Conn, *bufio.Reader, bool, error) {
-	slog.Debug("outbound connect attempt", "host", host, "port", outboundSMTPPort)
+func dialSMTP(ctx context.Context, host, port, policy string, cfg *config.OutboundDeliveryConfig) (net.Conn, *bufio.Reader, bool, []string, error) {
+	slog.Debug("outbound connect attempt", "host", host, "port", port)
 
 	dialCtx, cancel := context.WithTimeout(ctx, cfg.Timeouts.Dial)
 	defer cancel()
 
-	conn, err := (&net.Dialer{}).DialContext(dialCtx, "tcp", net.JoinHostPort(host, outboundSMTPPort))
+	conn, err := (&net.Dialer{}).DialContext(dialCtx, "tcp", net.JoinHostPort(host, port))
 	if err != nil {
-		return nil, nil, false, err
+		return nil, nil, false, nil, err
+	}
+
+	if policy == "implicit" {
+		tlsConn, err := clientTLSHandshake(conn, host, cfg)
+		if err != nil {
+			return nil, nil, false, nil, err
+		}
+		conn = tlsConn
 	}
 
 	r := bufio.NewReaderSize(conn, maxResponseLineBytes+2)
@@ -192,64 +232,101 @@ func dialMX(ctx context.Context, host string, cfg *config.OutboundDeliveryConfig
 	// Read greeting (220)
 	if err := conn.SetDeadline(time.Now().Add(cfg.Timeouts.Greeting)); err != nil {
 		conn.Close()
-		return nil, nil, false, err
+		return nil, nil, false, nil, err
 	}
 	if _, _, err := readSMTPResponse(r, maxResponseContinuations); err != nil {
 		conn.Close()
-		return nil, nil, false, fmt.Errorf("greeting read failed: %w", err)
+		return nil, nil, false, nil, fmt.Errorf("greeting read failed: %w", err)
 	}
 	conn.SetDeadline(time.Time{}) //nolint:errcheck
 
 	// Send EHLO, read capabilities
-	if err := conn.SetDeadline(time.Now().Add(cfg.Timeouts.Command)); err != nil {
+	ehloLines, err := sendEHLO(conn, r, cfg)
+	if err != nil {
 		conn.Close()
-		return nil, nil, false, err
+		return nil, nil, false, nil, fmt.Errorf("EHLO failed: %w", err)
 	}
-	if _, err := fmt.Fprintf(conn, "EHLO golubsmtpd\r\n"); err != nil {
-		conn.Close()
-		return nil, nil, false, fmt.Errorf("EHLO write failed: %w", err)
+
+	if policy == "implicit" {
+		return conn, r, true, ehloLines, nil
 	}
-	_, ehloLines, err := readSMTPResponse(r, maxResponseContinuations)
-	if err != nil {
-		conn.Close()
-		return nil, nil, false, fmt.Errorf("EHLO response failed: %w", err)
+	if policy == "none" {
+		return conn, r, false, ehloLines, nil
 	}
-	conn.SetDeadline(time.Time{}) //nolint:errcheck
 
 	starttlsAdvertised := ehloAdvertisesSTARTTLS(ehloLines)
 
 	if !starttlsAdvertised {
-		if cfg.TLS.Policy == "required" {
+		if policy == "required" {
 			conn.Close()
-			return nil, nil, false, errSTARTTLSRequired
+			return nil, nil, false, nil, errSTARTTLSRequired
 		}
 		slog.Info("STARTTLS not advertised, proceeding plain", "host", host)
-		return conn, r, false, nil
+		return conn, r, false, ehloLines, nil
 	}
 
 	// STARTTLS advertised — negotiate it regardless of policy.
 	// Advertised-then-rejected is always an error (STARTTLS stripping signal).
 	if err := conn.SetDeadline(time.Now().Add(cfg.Timeouts.Command)); err != nil {
 		conn.Close()
-		return nil, nil, false, err
+		return nil, nil, false, nil, err
 	}
 	if _, err := fmt.Fprintf(conn, "STARTTLS\r\n"); err != nil {
 		conn.Close()
-		return nil, nil, false, fmt.Errorf("STARTTLS write failed: %w", err)
+		return nil, nil, false, nil, fmt.Errorf("STARTTLS write failed: %w", err)
 	}
 	code, _, err := readSMTPResponse(r, maxResponseContinuations)
 	if err != nil {
 		conn.Close()
-		return nil, nil, false, fmt.Errorf("STARTTLS response failed: %w", err)
+		return nil, nil, false, nil, fmt.Errorf("STARTTLS response failed: %w", err)
 	}
 	conn.SetDeadline(time.Time{}) //nolint:errcheck
 
 	if code != 220 {
 		conn.Close()
-		return nil, nil, false, errSTARTTLSFailed
+		return nil, nil, false, nil, errSTARTTLSFailed
+	}
+
+	tlsConn, err := clientTLSHandshake(conn, host, cfg)
+	if err != nil {
+		return nil, nil, false, nil, err
+	}
+
+	// Re-wrap TLS conn with fresh bounded reader (RFC 3207 §4: re-EHLO required)
+	tlsR := bufio.NewReaderSize(tlsConn, maxResponseLineBytes+2)
+
+	ehloLines, err = sendEHLO(tlsConn, tlsR, cfg)
+	if err != nil {
+		tlsConn.Close()
+		return nil, nil, false, nil, fmt.Errorf("post-TLS EHLO failed: %w", err)
 	}
 
-	// TLS handshake with dedicated deadline
+	return tlsConn, tlsR, true, ehloLines, nil
+}
+
+// sendEHLO sends EHLO on conn and returns the advertised extension lines.
+func sendEHLO(conn net.Conn, r *bufio.Reader, cfg *config.OutboundDeliveryConfig) ([]string, error) {
+	if err := conn.SetDeadline(time.Now().Add(cfg.Timeouts.Command)); err != nil {
+		return nil, err
+	}
+	defer conn.SetDeadline(time.Time{}) //nolint:errcheck
+
+	if _, err := fmt.Fprintf(conn, "EHLO golubsmtpd\r\n"); err != nil {
+		return nil, fmt.Errorf("write failed: %w", err)
+	}
+	code, lines, err := readSMTPResponse(r, maxResponseContinuations)
+	if err != nil {
+		return nil, fmt.Errorf("response failed: %w", err)
+	}
+	if code != 250 {
+		return nil, fmt.Errorf("rejected with code %d", code)
+	}
+	return lines, nil
+}
+
+// clientTLSHandshake runs the client side of a TLS handshake on conn with a
+// dedicated deadline. conn is closed on failure.
+func clientTLSHandshake(conn net.Conn, host string, cfg *config.OutboundDeliveryConfig) (*tls.Conn, error) {
 	tlsCfg := &tls.Config{
 		ServerName:         host,
 		MinVersion:         resolveMinTLSVersion(cfg.TLS.MinVersion),
@@ -259,11 +336,11 @@ func dialMX(ctx context.Context, host string, cfg *config.OutboundDeliveryConfig
 
 	if err := tlsConn.SetDeadline(time.Now().Add(cfg.Timeouts.TLSHandshake)); err != nil {
 		tlsConn.Close()
-		return nil, nil, false, err
+		return nil, err
 	}
 	if err := tlsConn.Handshake(); err != nil {
 		tlsConn.Close()
-		return nil, nil, false, fmt.Errorf("TLS handshake failed: %w", err)
+		return nil, fmt.Errorf("TLS handshake failed: %w", err)
 	}
 	tlsConn.SetDeadline(time.Time{}) //nolint:errcheck
 
@@ -274,35 +351,75 @@ func dialMX(ctx context.Context, host string, cfg *config.OutboundDeliveryConfig
 		"cipher_suite", tls.CipherSuiteName(state.CipherSuite),
 		"verified", !cfg.TLS.SkipVerify,
 	)
+	return tlsConn, nil
+}
 
-	// Re-wrap TLS conn with fresh bounded reader (RFC 3207 §4: re-EHLO required)
-	tlsR := bufio.NewReaderSize(tlsConn, maxResponseLineBytes+2)
+// ehloAdvertisesSTARTTLS checks EHLO response lines for the STARTTLS extension.
+func ehloAdvertisesSTARTTLS(lines []string) bool {
+	return ehloAdvertises(lines, "STARTTLS")
+}
 
-	if err := tlsConn.SetDeadline(time.Now().Add(cfg.Timeouts.Command)); err != nil {
-		tlsConn.Close()
-		return nil, nil, false, err
+// ehloAdvertises checks EHLO response lines for an extension keyword,
+// ignoring its parameters.
+func ehloAdvertises(lines []string, keyword string) bool {
+	for _, line := range lines {
+		if fields := strings.Fields(line); len(fields) > 0 && strings.EqualFold(fields[0], keyword) {
+			return true
+		}
 	}
-	if _, err := fmt.Fprintf(tlsConn, "EHLO golubsmtpd\r\n"); err != nil {
-		tlsConn.Close()
-		return nil, nil, false, fmt.Errorf("post-TLS EHLO write failed: %w", err)
+	return false
+}
+
+// rcptDSNParams formats the NOTIFY= and ORCPT= parameters given with the
+// RCPT TO that produced a recipient, each with a leading space
+func rcptDSNParams(dsn types.DSNParams) string {
+	var params strings.Builder
+	if len(dsn.Notify) > 0 {
+		params.WriteString(" NOTIFY=" + strings.Join(dsn.Notify, ","))
 	}
-	if _, _, err := readSMTPResponse(tlsR, maxResponseContinuations); err != nil {
-		tlsConn.Close()
-		return nil, nil, false, fmt.Errorf("post-TLS EHLO response failed: %w", err)
+	if addrType, address, ok := strings.Cut(dsn.ORcpt, ";"); ok {
+		params.WriteString(" ORCPT=" + addrType + ";" + encodeXtext(address))
 	}
-	tlsConn.SetDeadline(time.Time{}) //nolint:errcheck
+	return params.String()
+}
 
-	return tlsConn, tlsR, true, nil
+// encodeXtext encodes s as RFC 3461 §4 xtext: "+", "=" and bytes outside
+// printable ASCII become "+" and two upper-case hex digits
+func encodeXtext(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < '!' || c > '~' || c == '+' || c == '=' {
+			fmt.Fprintf(&sb, "+%02X", c)
+		} else {
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
 }
 
-// ehloAdvertisesSTARTTLS checks EHLO response lines for the STARTTLS extension.
-func ehloAdvertisesSTARTTLS(lines []string) bool {
-	for _, line := range lines {
-		if strings.EqualFold(strings.TrimSpace(line), "STARTTLS") {
-			return true
+// has8BitContent reports whether the spooled message holds any byte outside
+// 7-bit ASCII.
+func has8BitContent(messagePath string) (bool, error) {
+	f, err := os.Open(messagePath)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for {
+		b, err := r.ReadByte()
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if b >= 0x80 {
+			return true, nil
 		}
 	}
-	return false
 }
 
 // resolveMinTLSVersion maps config string to crypto/tls constant.
@@ -399,11 +516,13 @@ type recipientOutcome struct {
 }
 
 // sendViaSMTP executes the SMTP envelope exchange on conn using the bounded reader r.
-// conn and r must already be positioned after the post-EHLO exchange (dialMX handles this).
+// conn and r must already be positioned after the post-EHLO exchange (dialMX handles this),
+// which advertised ehloLines.
 func sendViaSMTP(
 	ctx context.Context,
 	conn net.Conn,
 	r *bufio.Reader,
+	ehloLines []string,
 	host string,
 	msg *types.Message,
 	messagePath string,
@@ -433,8 +552,31 @@ func sendViaSMTP(
 		return code, lines, err
 	}
 
-	// MAIL FROM
+	// MAIL FROM, declaring an 8-bit body only to a peer that supports it
+	// (RFC 6152 §3); we do not convert, so an 8-bit body the peer cannot take
+	// is returned to the sender
 	mailCmd := fmt.Sprintf("MAIL FROM:<%s>", msg.From)
+	if msg.BodyType == "8BITMIME" {
+		if ehloAdvertises(ehloLines, "8BITMIME") {
+			mailCmd += " BODY=8BITMIME"
+		} else if eightBit, err := has8BitContent(messagePath); err != nil {
+			slog.Error("outbound failed to read message", "path", messagePath, "error", err)
+			return failAll(smtpTempFail)
+		} else if eightBit {
+			slog.Warn("outbound peer does not support 8BITMIME for an 8-bit message", "host", host, "message_id", msg.ID)
+			return failAll(smtpPermFail)
+		}
+	}
+	// RFC 3461 §4: a server advertising DSN takes over the notifications
+	peerDSN := ehloAdvertises(ehloLines, "DSN")
+	if peerDSN {
+		if msg.DSNRet != "" {
+			mailCmd += " RET=" + msg.DSNRet
+		}
+		if msg.DSNEnvID != "" {
+			mailCmd += " ENVID=" + encodeXtext(msg.DSNEnvID)
+		}
+	}
 	code, _, err := smtpCmd(mailCmd)
 	if err != nil || code/100 != 2 {
 		slog.Warn("outbound MAIL FROM rejected", "host", host, "code", code, "error", err)
@@ -446,6 +588,9 @@ func sendViaSMTP(
 	var accepted []string
 	for _, rec := range recipients {
 		rcptCmd := fmt.Sprintf("RCPT TO:<%s>", rec)
+		if peerDSN {
+			rcptCmd += rcptDSNParams(msg.RecipientDSN(rec))
+		}
 		code, _, err := smtpCmd(rcptCmd)
 		if err != nil || code/100 != 2 {
 			cat := smtpTempFail
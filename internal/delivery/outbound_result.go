@@ -7,14 +7,17 @@ import (
 	"github.com/pawciobiel/golubsmtpd/internal/types"
 )
 
-// OutboundResultHandler processes a DeliveryResult for outbound recipients:
-// persists retry state for tempfails and returns DSN messages to inject for
-// permfails and retry-exhausted recipients. The caller is responsible for
+// HandleOutboundResult processes a DeliveryResult for outbound recipients and
+// for local ones deferred by a temporary failure: persists retry state for
+// tempfails and returns DSN messages to inject for permfails, retry-exhausted
+// recipients and, on the first deferral, delayed ones. messagePath is the
+// spooled message returned in the DSNs. The caller is responsible for
 // publishing returned bounce messages to the queue.
 func HandleOutboundResult(
 	result DeliveryResult,
 	msg *types.Message,
-	spoolDir string,
+	spool types.Spool,
+	messagePath string,
 	localHostname string,
 	retryInterval time.Duration,
 	retryMaxAge time.Duration,
@@ -25,7 +28,7 @@ func HandleOutboundResult(
 	if len(result.PermFailed) > 0 {
 		slog.Warn("Outbound permanent failure — generating DSN",
 			"message_id", msg.ID, "recipients", result.PermFailed)
-		bounces = append(bounces, GenerateDSN(msg, result.PermFailed, "recipient rejected by remote server", localHostname))
+		bounces = appendDSN(bounces, msg, result.PermFailed, DSNFailed, "recipient rejected by remote server", localHostname, messagePath)
 	}
 
 	if len(result.TempFailed) == 0 {
@@ -33,14 +36,16 @@ func HandleOutboundResult(
 	}
 
 	// Load or create retry state for tempfailed recipients
-	state, err := LoadRetryState(spoolDir, msg.ID)
+	state, err := LoadRetryState(spool, msg.ID)
 	if err != nil {
 		slog.Error("Failed to load retry state — dropping tempfailed recipients",
 			"message_id", msg.ID, "error", err)
 		return bounces
 	}
-	if state == nil {
+	firstDeferral := state == nil
+	if firstDeferral {
 		state = NewRetryState(msg.ID, msg.From, retryInterval, result.TempFailed)
+		state.keepEnvelope(msg, result.TempFailed)
 	}
 
 	shouldRetry := state.RecordAttempt(result, retryInterval, retryMaxAge)
@@ -49,21 +54,65 @@ func HandleOutboundResult(
 	if expired := state.BounceRecipients(); len(expired) > 0 {
 		slog.Warn("Outbound retry exhausted — generating DSN",
 			"message_id", msg.ID, "recipients", expired)
-		bounces = append(bounces, GenerateDSN(msg, expired, "maximum retry time exceeded", localHostname))
-		if err := DeleteRetryState(spoolDir, msg.ID); err != nil {
+		bounces = appendDSN(bounces, msg, expired, DSNFailed, "maximum retry time exceeded", localHostname, messagePath)
+		if err := DeleteRetryState(spool, msg.ID); err != nil {
 			slog.Error("Failed to delete exhausted retry state", "message_id", msg.ID, "error", err)
 		}
 		return bounces
 	}
 
 	if shouldRetry {
-		if err := SaveRetryState(spoolDir, state); err != nil {
+		if err := SaveRetryState(spool, state); err != nil {
 			slog.Error("Failed to save retry state", "message_id", msg.ID, "error", err)
 		} else {
 			slog.Info("Outbound message scheduled for retry",
 				"message_id", msg.ID, "next_retry", state.NextRetry, "attempts", state.Attempts)
 		}
+		// Senders asking for NOTIFY=DELAY hear once, when delivery is first deferred
+		if firstDeferral {
+			bounces = appendDSN(bounces, msg, result.TempFailed, DSNDelayed, "delivery temporarily failed", localHostname, messagePath)
+		}
 	}
 
 	return bounces
 }
+
+// SuccessNotifications returns the DSNs for recipients whose RCPT TO asked
+// for NOTIFY=SUCCESS: delivered ones were stored here, relayed ones were
+// handed to a server that was not asked to notify in our place (RFC 3461 §6.2.4)
+func SuccessNotifications(msg *types.Message, delivered, relayed []string, localHostname, messagePath string) []*types.Message {
+	var notices []*types.Message
+	notices = appendDSN(notices, msg, delivered, DSNDelivered, "", localHostname, messagePath)
+	notices = appendDSN(notices, msg, relayed, DSNRelayed, "", localHostname, messagePath)
+	return notices
+}
+
+// appendDSN adds a notification for recipients unless the message itself has
+// a null reverse-path: bounces are never bounced (RFC 5321 §4.5.5). Recipients
+// whose RCPT TO NOTIFY does not ask for action are left out (RFC 3461 §4.1).
+func appendDSN(bounces []*types.Message, msg *types.Message, recipients []string, action DSNAction, reason, localHostname, messagePath string) []*types.Message {
+	if len(recipients) == 0 {
+		return bounces
+	}
+
+	event := action.notifyEvent()
+	notify := make([]string, 0, len(recipients))
+	for _, r := range recipients {
+		if msg.RecipientDSN(r).NotifyOn(event) {
+			notify = append(notify, r)
+		}
+	}
+	// Only a suppressed failure is worth logging; other notifications are opt-in
+	if len(notify) < len(recipients) && action == DSNFailed {
+		slog.Info("DSN suppressed by NOTIFY", "message_id", msg.ID,
+			"recipients", len(recipients)-len(notify))
+	}
+	if len(notify) == 0 {
+		return bounces
+	}
+	if msg.From == "" {
+		slog.Info("Null sender — discarding DSN", "message_id", msg.ID, "action", action, "recipients", notify)
+		return bounces
+	}
+	return append(bounces, GenerateDSN(msg, notify, action, reason, localHostname, messagePath))
+}
@@ -4,12 +4,15 @@ import (
 	"bufio"
 	"fmt"
 	"net"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/pawciobiel/golubsmtpd/internal/config"
+	"github.com/pawciobiel/golubsmtpd/internal/types"
 )
 
 // defaultTestCfg returns an OutboundDeliveryConfig with short timeouts for tests.
@@ -412,3 +415,186 @@ func TestReadSMTPResponse_MismatchedCodes(t *testing.T) {
 		t.Error("expected error for mismatched codes in multi-line response, got nil")
 	}
 }
+
+// --- HandleOutboundResult ---
+
+func TestHandleOutboundResult_NullSenderNotBounced(t *testing.T) {
+	result := DeliveryResult{Type: RecipientExternal, PermFailed: []string{"bob@remote.example"}}
+
+	tests := []struct {
+		name        string
+		from        string
+		wantBounces int
+	}{
+		{"regular sender", "alice@example.com", 1},
+		{"null sender", "", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := &types.Message{ID: "msg-1", From: tt.from, Created: time.Now()}
+			bounces := HandleOutboundResult(result, msg, types.Spool{Dir: t.TempDir()}, "", "mx.example.com", time.Minute, time.Hour)
+			if len(bounces) != tt.wantBounces {
+				t.Errorf("bounces: got %d, want %d", len(bounces), tt.wantBounces)
+			}
+		})
+	}
+}
+
+func TestHandleOutboundResult_HonorsNotify(t *testing.T) {
+	result := DeliveryResult{Type: RecipientExternal, PermFailed: []string{"bob@remote.example", "carol@remote.example"}}
+
+	tests := []struct {
+		name       string
+		dsn        map[string]types.DSNParams
+		wantBounce bool
+		wantRcpts  []string
+	}{
+		{"default notifies failures", nil, true, []string{"bob@remote.example", "carol@remote.example"}},
+		{"never for one recipient", map[string]types.DSNParams{
+			"bob@remote.example": {Notify: []string{"NEVER"}},
+		}, true, []string{"carol@remote.example"}},
+		{"success only for all", map[string]types.DSNParams{
+			"bob@remote.example":   {Notify: []string{"SUCCESS"}},
+			"carol@remote.example": {Notify: []string{"NEVER"}},
+		}, false, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := &types.Message{ID: "msg-1", From: "alice@example.com", Created: time.Now(),
+				ExternalRecipients: types.NewRecipientSet("bob@remote.example", "carol@remote.example")}
+			for recipient, dsn := range tt.dsn {
+				msg.ExternalRecipients[recipient].DSN = dsn
+			}
+			bounces := HandleOutboundResult(result, msg, types.Spool{Dir: t.TempDir()}, "", "mx.example.com", time.Minute, time.Hour)
+			if (len(bounces) == 1) != tt.wantBounce {
+				t.Fatalf("bounces: got %d, want bounce=%v", len(bounces), tt.wantBounce)
+			}
+			if !tt.wantBounce {
+				return
+			}
+			for _, r := range []string{"bob@remote.example", "carol@remote.example"} {
+				listed := strings.Contains(bounces[0].RawBody, "Final-Recipient: rfc822; "+r)
+				want := false
+				for _, w := range tt.wantRcpts {
+					want = want || w == r
+				}
+				if listed != want {
+					t.Errorf("%s listed in DSN = %v, want %v", r, listed, want)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateDSN_EnvIDAndORcpt(t *testing.T) {
+	msg := &types.Message{
+		ID: "msg-1", From: "alice@example.com", Created: time.Now(),
+		DSNEnvID:           "QQ314159",
+		ExternalRecipients: types.NewRecipientSet("bob@remote.example"),
+	}
+	msg.ExternalRecipients["bob@remote.example"].DSN = types.DSNParams{ORcpt: "rfc822;Bob@Remote.example"}
+
+	body := GenerateDSN(msg, []string{"bob@remote.example"}, DSNFailed, "rejected", "mx.example.com", "").RawBody
+
+	for _, want := range []string{
+		"Original-Envelope-Id: QQ314159\r\nReporting-MTA: dns; mx.example.com\r\n",
+		"Original-Recipient: rfc822;Bob@Remote.example\r\nFinal-Recipient: rfc822; bob@remote.example\r\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("DSN missing %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestGenerateDSN_ReturnedContent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "msg")
+	if err := os.WriteFile(path, []byte("Subject: hello\r\nX-Tag: 1\r\n\r\nsecret body\r\n"), 0600); err != nil {
+		t.Fatalf("write message: %v", err)
+	}
+
+	tests := []struct {
+		ret      string
+		wantPart string
+		wantBody bool
+	}{
+		{"", "Content-Type: text/rfc822-headers\r\n\r\nSubject: hello\r\nX-Tag: 1\r\n", false},
+		{"HDRS", "Content-Type: text/rfc822-headers\r\n\r\nSubject: hello\r\nX-Tag: 1\r\n", false},
+		{"FULL", "Content-Type: message/rfc822\r\n\r\nSubject: hello\r\nX-Tag: 1\r\n\r\nsecret body\r\n", true},
+	}
+
+	for _, tt := range tests {
+		t.Run("RET="+tt.ret, func(t *testing.T) {
+			msg := &types.Message{ID: "msg-1", From: "alice@example.com", DSNRet: tt.ret, Created: time.Now()}
+			body := GenerateDSN(msg, []string{"bob@remote.example"}, DSNFailed, "rejected", "mx.example.com", path).RawBody
+			if !strings.Contains(body, tt.wantPart) {
+				t.Errorf("DSN missing %q:\n%s", tt.wantPart, body)
+			}
+			if got := strings.Contains(body, "secret body"); got != tt.wantBody {
+				t.Errorf("body returned = %v, want %v", got, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestHandleOutboundResult_DelayNotifiedOnce(t *testing.T) {
+	spool := types.Spool{Dir: t.TempDir()}
+	result := DeliveryResult{Type: RecipientExternal, TempFailed: []string{"bob@remote.example", "carol@remote.example"}}
+	msg := &types.Message{ID: "msg-1", From: "alice@example.com", DSNEnvID: "QQ314159", Created: time.Now(),
+		ExternalRecipients: types.NewRecipientSet("bob@remote.example", "carol@remote.example")}
+	msg.ExternalRecipients["bob@remote.example"].DSN = types.DSNParams{Notify: []string{"DELAY", "FAILURE"}}
+
+	bounces := HandleOutboundResult(result, msg, spool, "", "mx.example.com", time.Minute, time.Hour)
+	if len(bounces) != 1 {
+		t.Fatalf("first deferral: got %d notifications, want 1", len(bounces))
+	}
+	body := bounces[0].RawBody
+	for _, want := range []string{"Subject: Delivery Status Notification (Delay)\r\n",
+		"Final-Recipient: rfc822; bob@remote.example\r\nAction: delayed\r\nStatus: 4.0.0\r\n"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("delay DSN missing %q:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "carol@remote.example") {
+		t.Errorf("recipient without NOTIFY=DELAY listed:\n%s", body)
+	}
+
+	// The retry state keeps the DSN parameters for re-injected attempts
+	state, err := LoadRetryState(spool, msg.ID)
+	if err != nil || state == nil {
+		t.Fatalf("LoadRetryState: %v, %v", state, err)
+	}
+	if state.DSNEnvID != "QQ314159" || !state.RecipientDSN["bob@remote.example"].NotifyOn("DELAY") {
+		t.Errorf("retry state lost DSN parameters: %+v", state)
+	}
+
+	if bounces := HandleOutboundResult(result, msg, spool, "", "mx.example.com", time.Minute, time.Hour); len(bounces) != 0 {
+		t.Errorf("later deferral: got %d notifications, want none", len(bounces))
+	}
+}
+
+func TestSuccessNotifications(t *testing.T) {
+	msg := &types.Message{ID: "msg-1", From: "alice@example.com", Created: time.Now(),
+		LocalRecipients:    types.NewRecipientSet("root@localhost", "bob@localhost"),
+		ExternalRecipients: types.NewRecipientSet("carol@remote.example")}
+	msg.LocalRecipients["root@localhost"].DSN = types.DSNParams{Notify: []string{"SUCCESS"}}
+	msg.ExternalRecipients["carol@remote.example"].DSN = types.DSNParams{Notify: []string{"SUCCESS", "FAILURE"}}
+
+	notices := SuccessNotifications(msg, []string{"root@localhost", "bob@localhost"}, []string{"carol@remote.example"}, "mx.example.com", "")
+	if len(notices) != 2 {
+		t.Fatalf("got %d notifications, want 2", len(notices))
+	}
+	if body := notices[0].RawBody; !strings.Contains(body, "Final-Recipient: rfc822; root@localhost\r\nAction: delivered\r\nStatus: 2.0.0\r\n") ||
+		strings.Contains(body, "bob@localhost") {
+		t.Errorf("delivered DSN mismatch:\n%s", body)
+	}
+	if body := notices[1].RawBody; !strings.Contains(body, "Final-Recipient: rfc822; carol@remote.example\r\nAction: relayed\r\n") {
+		t.Errorf("relayed DSN mismatch:\n%s", body)
+	}
+
+	msg.From = ""
+	if notices := SuccessNotifications(msg, []string{"root@localhost"}, nil, "mx.example.com", ""); len(notices) != 0 {
+		t.Errorf("null sender got %d notifications", len(notices))
+	}
+}
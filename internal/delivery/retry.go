@@ -6,9 +6,9 @@ import (
 	"os"
 	"path/filepath"
 	"time"
-)
 
-const retryDirName = "retry"
+	"github.com/pawciobiel/golubsmtpd/internal/types"
+)
 
 // RetryState tracks retry metadata for a single outbound message.
 type RetryState struct {
@@ -18,16 +18,23 @@ type RetryState struct {
 	NextRetry  time.Time         `json:"next_retry"`
 	Attempts   int               `json:"attempts"`
 	Recipients map[string]string `json:"recipients"` // addr -> "pending"|"ok"|"tempfail"|"permfail"|"expired"
+
+	// Envelope parameters kept for later attempts, which re-inject the message
+	// from its spool file alone
+	BodyType     string                     `json:"body_type,omitempty"` // RFC 6152 BODY=
+	DSNRet       string                     `json:"dsn_ret,omitempty"`   // RFC 3461 RET=
+	DSNEnvID     string                     `json:"dsn_envid,omitempty"` // RFC 3461 ENVID=
+	RecipientDSN map[string]types.DSNParams `json:"recipient_dsn,omitempty"`
 }
 
 // RetryStatePath returns the path to the retry metadata file for a message.
-func RetryStatePath(spoolDir, messageID string) string {
-	return filepath.Join(spoolDir, retryDirName, messageID+".json")
+func RetryStatePath(spool types.Spool, messageID string) string {
+	return filepath.Join(spool.MessageDir(types.MessageStateRetry, messageID), messageID+".json")
 }
 
 // LoadRetryState reads retry state from disk. Returns nil, nil if not found.
-func LoadRetryState(spoolDir, messageID string) (*RetryState, error) {
-	data, err := os.ReadFile(RetryStatePath(spoolDir, messageID))
+func LoadRetryState(spool types.Spool, messageID string) (*RetryState, error) {
+	data, err := os.ReadFile(RetryStatePath(spool, messageID))
 	if os.IsNotExist(err) {
 		return nil, nil
 	}
@@ -42,16 +49,15 @@ func LoadRetryState(spoolDir, messageID string) (*RetryState, error) {
 }
 
 // SaveRetryState writes retry state atomically to disk.
-func SaveRetryState(spoolDir string, state *RetryState) error {
-	dir := filepath.Join(spoolDir, retryDirName)
-	if err := os.MkdirAll(dir, 0o700); err != nil {
+func SaveRetryState(spool types.Spool, state *RetryState) error {
+	path := RetryStatePath(spool, state.MessageID)
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
 		return fmt.Errorf("failed to create retry dir: %w", err)
 	}
 	data, err := json.Marshal(state)
 	if err != nil {
 		return fmt.Errorf("failed to marshal retry state: %w", err)
 	}
-	path := RetryStatePath(spoolDir, state.MessageID)
 	tmp := path + ".tmp"
 	if err := os.WriteFile(tmp, data, 0o600); err != nil {
 		return fmt.Errorf("failed to write retry state: %w", err)
@@ -64,8 +70,8 @@ func SaveRetryState(spoolDir string, state *RetryState) error {
 }
 
 // DeleteRetryState removes the retry metadata file for a message.
-func DeleteRetryState(spoolDir, messageID string) error {
-	err := os.Remove(RetryStatePath(spoolDir, messageID))
+func DeleteRetryState(spool types.Spool, messageID string) error {
+	err := os.Remove(RetryStatePath(spool, messageID))
 	if err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf("failed to delete retry state for %s: %w", messageID, err)
 	}
@@ -89,6 +95,24 @@ func NewRetryState(messageID, from string, retryInterval time.Duration, recipien
 	}
 }
 
+// keepEnvelope records the envelope parameters of msg and the DSN parameters
+// of recipients
+func (s *RetryState) keepEnvelope(msg *types.Message, recipients []string) {
+	s.BodyType = msg.BodyType
+	s.DSNRet = msg.DSNRet
+	s.DSNEnvID = msg.DSNEnvID
+	for _, r := range recipients {
+		dsn := msg.RecipientDSN(r)
+		if len(dsn.Notify) == 0 && dsn.ORcpt == "" {
+			continue
+		}
+		if s.RecipientDSN == nil {
+			s.RecipientDSN = make(map[string]types.DSNParams)
+		}
+		s.RecipientDSN[r] = dsn
+	}
+}
+
 // RecordAttempt updates state from a DeliveryResult and returns whether any recipients
 // still need retrying. Marks expired recipients when max age is exceeded.
 func (s *RetryState) RecordAttempt(result DeliveryResult, retryInterval, maxAge time.Duration) (shouldRetry bool) {
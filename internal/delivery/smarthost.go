@@ -0,0 +1,132 @@
+package delivery
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pawciobiel/golubsmtpd/internal/config"
+	"github.com/pawciobiel/golubsmtpd/internal/types"
+)
+
+var errSmarthostAuthUnsupported = errors.New("smarthost advertises neither AUTH PLAIN nor AUTH LOGIN")
+
+// deliverToSmarthost hands every recipient to the configured smarthost in a
+// single transaction, authenticating first when credentials are configured.
+// Failures to connect or authenticate tempfail all recipients so the message
+// is retried rather than bounced.
+func deliverToSmarthost(ctx context.Context, msg *types.Message, messagePath string, recipients []string, cfg *config.OutboundDeliveryConfig, signer *DKIMSigner) domainResult {
+	sh := cfg.Smarthost
+	result := domainResult{domain: sh.Host}
+
+	// The smarthost is trusted with credentials, so "starttls" never falls back to plain
+	policy := sh.TLS
+	if policy == "starttls" {
+		policy = "required"
+	}
+
+	conn, r, _, ehloLines, err := dialSMTP(ctx, sh.Host, strconv.Itoa(sh.Port), policy, cfg)
+	if err != nil {
+		slog.Warn("smarthost connect failed", "host", sh.Host, "port", sh.Port, "error", err)
+		result.tempFailed = append(result.tempFailed, recipients...)
+		return result
+	}
+	defer conn.Close()
+
+	if sh.Username != "" {
+		if err := smtpAuth(conn, r, ehloLines, sh.Username, sh.Password, cfg); err != nil {
+			slog.Warn("smarthost authentication failed", "host", sh.Host, "username", sh.Username, "error", err)
+			result.tempFailed = append(result.tempFailed, recipients...)
+			return result
+		}
+	}
+
+	result.addOutcomes(sendViaSMTP(ctx, conn, r, ehloLines, sh.Host, msg, messagePath, recipients, cfg, signer),
+		ehloAdvertises(ehloLines, "DSN"))
+	return result
+}
+
+// sortedRecipients returns the recipient set as a sorted slice
+func sortedRecipients(recipients map[string]struct{}) []string {
+	list := make([]string, 0, len(recipients))
+	for addr := range recipients {
+		list = append(list, addr)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// smtpAuth authenticates with AUTH PLAIN, or AUTH LOGIN when PLAIN is not
+// advertised in ehloLines.
+func smtpAuth(conn net.Conn, r *bufio.Reader, ehloLines []string, username, password string, cfg *config.OutboundDeliveryConfig) error {
+	mechanisms := authMechanisms(ehloLines)
+
+	switch {
+	case mechanisms["PLAIN"]:
+		resp := base64.StdEncoding.EncodeToString([]byte("\x00" + username + "\x00" + password))
+		return expectCode(conn, r, "AUTH PLAIN "+resp, 235, cfg)
+	case mechanisms["LOGIN"]:
+		if err := expectCode(conn, r, "AUTH LOGIN", 334, cfg); err != nil {
+			return err
+		}
+		if err := expectCode(conn, r, base64.StdEncoding.EncodeToString([]byte(username)), 334, cfg); err != nil {
+			return err
+		}
+		return expectCode(conn, r, base64.StdEncoding.EncodeToString([]byte(password)), 235, cfg)
+	default:
+		return errSmarthostAuthUnsupported
+	}
+}
+
+// authMechanisms collects the SASL mechanisms from "AUTH ..." EHLO lines,
+// including the obsolete "AUTH=..." form
+func authMechanisms(ehloLines []string) map[string]bool {
+	mechanisms := make(map[string]bool)
+	for _, line := range ehloLines {
+		fields := strings.Fields(strings.ToUpper(line))
+		if len(fields) == 0 {
+			continue
+		}
+		switch {
+		case fields[0] == "AUTH":
+			fields = fields[1:]
+		case strings.HasPrefix(fields[0], "AUTH="):
+			fields[0] = strings.TrimPrefix(fields[0], "AUTH=")
+		default:
+			continue
+		}
+		for _, mech := range fields {
+			mechanisms[mech] = true
+		}
+	}
+	return mechanisms
+}
+
+// expectCode sends cmd and fails unless the reply carries the wanted code.
+// The command itself is left out of errors since it may hold credentials.
+func expectCode(conn net.Conn, r *bufio.Reader, cmd string, want int, cfg *config.OutboundDeliveryConfig) error {
+	if err := conn.SetDeadline(time.Now().Add(cfg.Timeouts.Command)); err != nil {
+		return err
+	}
+	defer conn.SetDeadline(time.Time{}) //nolint:errcheck
+
+	if _, err := fmt.Fprintf(conn, "%s\r\n", cmd); err != nil {
+		return fmt.Errorf("write failed: %w", err)
+	}
+	code, lines, err := readSMTPResponse(r, maxResponseContinuations)
+	if err != nil {
+		return err
+	}
+	if code != want {
+		return fmt.Errorf("unexpected reply %d %s", code, strings.Join(lines, " "))
+	}
+	return nil
+}
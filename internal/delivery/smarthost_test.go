@@ -0,0 +1,368 @@
+package delivery
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pawciobiel/golubsmtpd/internal/config"
+	"github.com/pawciobiel/golubsmtpd/internal/types"
+)
+
+// mockSmarthost is a minimal SMTP server that records the commands and
+// message data it receives
+type mockSmarthost struct {
+	listener net.Listener
+	ehlo     []string // extension lines after the hostname line
+	authOK   bool
+
+	mu       sync.Mutex
+	commands []string
+	data     string
+}
+
+func newMockSmarthost(t *testing.T, authOK bool, ehlo ...string) *mockSmarthost {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	m := &mockSmarthost{listener: ln, ehlo: ehlo, authOK: authOK}
+	t.Cleanup(func() { ln.Close() })
+	go m.serve()
+	return m
+}
+
+func (m *mockSmarthost) serve() {
+	conn, err := m.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second)) //nolint:errcheck
+
+	r := bufio.NewReader(conn)
+	reply := func(lines ...string) {
+		for _, l := range lines {
+			conn.Write([]byte(l + "\r\n")) //nolint:errcheck
+		}
+	}
+	readCmd := func() (string, bool) {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return "", false
+		}
+		line = strings.TrimRight(line, "\r\n")
+		m.mu.Lock()
+		m.commands = append(m.commands, line)
+		m.mu.Unlock()
+		return line, true
+	}
+
+	reply("220 smarthost.example ESMTP")
+	for {
+		cmd, ok := readCmd()
+		if !ok {
+			return
+		}
+		verb := strings.ToUpper(strings.Fields(cmd + " ")[0])
+		switch verb {
+		case "EHLO":
+			lines := []string{"250-smarthost.example"}
+			for _, ext := range m.ehlo {
+				lines = append(lines, "250-"+ext)
+			}
+			reply(append(lines, "250 OK")...)
+		case "AUTH":
+			if strings.HasPrefix(strings.ToUpper(cmd), "AUTH LOGIN") {
+				reply("334 VXNlcm5hbWU6")
+				readCmd()
+				reply("334 UGFzc3dvcmQ6")
+				readCmd()
+			}
+			if m.authOK {
+				reply("235 Authentication successful")
+			} else {
+				reply("535 Authentication failed")
+			}
+		case "DATA":
+			reply("354 Go ahead")
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(line)
+			}
+			m.mu.Lock()
+			m.data = data.String()
+			m.mu.Unlock()
+			reply("250 Queued")
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+// Commands returns the commands received so far
+func (m *mockSmarthost) Commands() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.commands...)
+}
+
+// Data returns the message data received so far
+func (m *mockSmarthost) Data() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.data
+}
+
+func smarthostTestCfg(m *mockSmarthost, username, password string) *config.OutboundDeliveryConfig {
+	cfg := defaultTestCfg()
+	addr := m.listener.Addr().(*net.TCPAddr)
+	cfg.Smarthost = config.SmarthostConfig{
+		Host:     addr.IP.String(),
+		Port:     addr.Port,
+		Username: username,
+		Password: password,
+		TLS:      "none",
+	}
+	return cfg
+}
+
+func deliverViaSmarthostForTest(t *testing.T, cfg *config.OutboundDeliveryConfig, recipients ...string) DeliveryResult {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "msg")
+	if err := os.WriteFile(path, []byte("Subject: hello\r\n\r\nbody\r\n"), 0600); err != nil {
+		t.Fatalf("write message: %v", err)
+	}
+	set := make(map[string]struct{})
+	for _, rcpt := range recipients {
+		set[rcpt] = struct{}{}
+	}
+	msg := &types.Message{ID: "msg-1", From: "alice@example.com", Created: time.Now()}
+	return DeliverOutboundWithWorkers(context.Background(), set, 4, msg, path, cfg, nil)
+}
+
+// waitCommands waits for the mock to see a command starting with prefix,
+// since the best-effort QUIT races with the test reading the log
+func waitCommands(m *mockSmarthost, prefix string) []string {
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		cmds := m.Commands()
+		if len(cmds) > 0 && strings.HasPrefix(cmds[len(cmds)-1], prefix) || time.Now().After(deadline) {
+			return cmds
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDeliverOutbound_SmarthostAuthPlain(t *testing.T) {
+	m := newMockSmarthost(t, true, "AUTH LOGIN PLAIN")
+	cfg := smarthostTestCfg(m, "relayuser", "s3cret")
+
+	result := deliverViaSmarthostForTest(t, cfg, "bob@remote.example", "carol@other.example")
+
+	if diff := cmp.Diff([]string{"bob@remote.example", "carol@other.example"}, result.Successful); diff != "" {
+		t.Errorf("successful recipients mismatch (-want +got):\n%s", diff)
+	}
+
+	plain := base64.StdEncoding.EncodeToString([]byte("\x00relayuser\x00s3cret"))
+	want := []string{
+		"EHLO golubsmtpd",
+		"AUTH PLAIN " + plain,
+		"MAIL FROM:<alice@example.com>",
+		"RCPT TO:<bob@remote.example>",
+		"RCPT TO:<carol@other.example>",
+		"DATA",
+		"QUIT",
+	}
+	if diff := cmp.Diff(want, waitCommands(m, "QUIT")); diff != "" {
+		t.Errorf("smarthost commands mismatch (-want +got):\n%s", diff)
+	}
+	if got := m.Data(); got != "Subject: hello\r\n\r\nbody\r\n" {
+		t.Errorf("smarthost data = %q", got)
+	}
+}
+
+func TestDeliverOutbound_SmarthostAuthLogin(t *testing.T) {
+	m := newMockSmarthost(t, true, "AUTH=LOGIN")
+	cfg := smarthostTestCfg(m, "relayuser", "s3cret")
+
+	result := deliverViaSmarthostForTest(t, cfg, "bob@remote.example")
+
+	if len(result.Successful) != 1 {
+		t.Fatalf("expected delivery to succeed, got %+v", result)
+	}
+	want := []string{
+		"EHLO golubsmtpd",
+		"AUTH LOGIN",
+		base64.StdEncoding.EncodeToString([]byte("relayuser")),
+		base64.StdEncoding.EncodeToString([]byte("s3cret")),
+		"MAIL FROM:<alice@example.com>",
+	}
+	if diff := cmp.Diff(want, waitCommands(m, "QUIT")[:len(want)]); diff != "" {
+		t.Errorf("smarthost commands mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestDeliverOutbound_SmarthostAuthRejected(t *testing.T) {
+	m := newMockSmarthost(t, false, "AUTH PLAIN")
+	cfg := smarthostTestCfg(m, "relayuser", "wrong")
+
+	result := deliverViaSmarthostForTest(t, cfg, "bob@remote.example")
+
+	if diff := cmp.Diff([]string{"bob@remote.example"}, result.TempFailed); diff != "" {
+		t.Errorf("tempfailed recipients mismatch (-want +got):\n%s", diff)
+	}
+	for _, cmd := range m.Commands() {
+		if strings.HasPrefix(cmd, "MAIL") {
+			t.Errorf("MAIL sent after failed AUTH")
+		}
+	}
+}
+
+func TestDeliverOutbound_SmarthostNoAuthAdvertised(t *testing.T) {
+	m := newMockSmarthost(t, true)
+	cfg := smarthostTestCfg(m, "relayuser", "s3cret")
+
+	result := deliverViaSmarthostForTest(t, cfg, "bob@remote.example")
+
+	if len(result.TempFailed) != 1 {
+		t.Errorf("expected tempfail when AUTH is not advertised, got %+v", result)
+	}
+}
+
+func TestDeliverOutbound_SmarthostRequiresSTARTTLS(t *testing.T) {
+	m := newMockSmarthost(t, true, "AUTH PLAIN")
+	cfg := smarthostTestCfg(m, "relayuser", "s3cret")
+	cfg.Smarthost.TLS = "starttls"
+
+	result := deliverViaSmarthostForTest(t, cfg, "bob@remote.example")
+
+	if len(result.TempFailed) != 1 {
+		t.Errorf("expected tempfail without STARTTLS, got %+v", result)
+	}
+	for _, cmd := range m.Commands() {
+		if strings.HasPrefix(cmd, "AUTH") {
+			t.Errorf("credentials sent over a plain connection")
+		}
+	}
+}
+
+func TestAuthMechanisms(t *testing.T) {
+	got := authMechanisms([]string{"smarthost.example", "AUTH LOGIN plain", "AUTH=CRAM-MD5", "SIZE 1000"})
+	want := map[string]bool{"LOGIN": true, "PLAIN": true, "CRAM-MD5": true}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("authMechanisms mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestDeliverOutbound_8BitMIME(t *testing.T) {
+	tests := []struct {
+		name     string
+		ehlo     []string
+		body     string
+		wantMail string // empty when MAIL must not be sent
+		wantPerm bool
+	}{
+		{"peer supports 8BITMIME", []string{"8BITMIME"}, "Subject: caf\xc3\xa9\r\n\r\nbody\r\n", "MAIL FROM:<alice@example.com> BODY=8BITMIME", false},
+		{"7-bit content sent undeclared", nil, "Subject: hello\r\n\r\nbody\r\n", "MAIL FROM:<alice@example.com>", false},
+		{"8-bit content returned", nil, "Subject: caf\xc3\xa9\r\n\r\nbody\r\n", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockSmarthost(t, true, tt.ehlo...)
+			cfg := smarthostTestCfg(m, "", "")
+
+			path := filepath.Join(t.TempDir(), "msg")
+			if err := os.WriteFile(path, []byte(tt.body), 0600); err != nil {
+				t.Fatalf("write message: %v", err)
+			}
+			msg := &types.Message{ID: "msg-1", From: "alice@example.com", BodyType: "8BITMIME", Created: time.Now()}
+			result := DeliverOutboundWithWorkers(context.Background(), map[string]struct{}{"bob@remote.example": {}}, 1, msg, path, cfg, nil)
+
+			if tt.wantPerm {
+				if diff := cmp.Diff([]string{"bob@remote.example"}, result.PermFailed); diff != "" {
+					t.Errorf("permfailed recipients mismatch (-want +got):\n%s", diff)
+				}
+			} else if len(result.Successful) != 1 {
+				t.Fatalf("expected delivery to succeed, got %+v", result)
+			}
+
+			last := "QUIT"
+			if tt.wantPerm {
+				last = "EHLO" // the connection is dropped before MAIL
+			}
+			var mail string
+			for _, cmd := range waitCommands(m, last) {
+				if strings.HasPrefix(cmd, "MAIL") {
+					mail = cmd
+				}
+			}
+			if mail != tt.wantMail {
+				t.Errorf("MAIL command = %q, want %q", mail, tt.wantMail)
+			}
+		})
+	}
+}
+
+func TestDeliverOutbound_RelaysDSNParameters(t *testing.T) {
+	tests := []struct {
+		name       string
+		ehlo       []string
+		wantMail   string
+		wantRcpt   string
+		wantPassed bool
+	}{
+		{"peer supports DSN", []string{"DSN"},
+			"MAIL FROM:<alice@example.com> RET=HDRS ENVID=QQ+2B314159",
+			"RCPT TO:<bob@remote.example> NOTIFY=SUCCESS,FAILURE ORCPT=rfc822;Bob+2BLists@Remote.example", true},
+		{"peer without DSN", nil, "MAIL FROM:<alice@example.com>", "RCPT TO:<bob@remote.example>", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockSmarthost(t, true, tt.ehlo...)
+			cfg := smarthostTestCfg(m, "", "")
+
+			path := filepath.Join(t.TempDir(), "msg")
+			if err := os.WriteFile(path, []byte("Subject: hello\r\n\r\nbody\r\n"), 0600); err != nil {
+				t.Fatalf("write message: %v", err)
+			}
+			msg := &types.Message{ID: "msg-1", From: "alice@example.com", DSNRet: "HDRS", DSNEnvID: "QQ+314159", Created: time.Now(),
+				ExternalRecipients: types.NewRecipientSet("bob@remote.example")}
+			msg.ExternalRecipients["bob@remote.example"].DSN = types.DSNParams{
+				Notify: []string{"SUCCESS", "FAILURE"}, ORcpt: "rfc822;Bob+Lists@Remote.example"}
+			result := DeliverOutboundWithWorkers(context.Background(), msg.ExternalRecipients.Addresses(), 1, msg, path, cfg, nil)
+
+			if len(result.Successful) != 1 {
+				t.Fatalf("expected delivery to succeed, got %+v", result)
+			}
+			if passed := len(result.DSNPassed) == 1; passed != tt.wantPassed {
+				t.Errorf("DSNPassed = %v, want passed %v", result.DSNPassed, tt.wantPassed)
+			}
+			cmds := waitCommands(m, "QUIT")
+			if diff := cmp.Diff([]string{tt.wantMail, tt.wantRcpt}, cmds[1:3]); diff != "" {
+				t.Errorf("envelope commands mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package delivery
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Per-recipient delivery states recorded in a DeliveryStatus
+const (
+	RecipientStatePending   = "pending"
+	RecipientStateDelivered = "delivered"
+	RecipientStateDeferred  = "deferred" // temporary failure, awaiting retry
+	RecipientStateFailed    = "failed"
+)
+
+// RecipientStatus is the latest known delivery state of one recipient.
+type RecipientStatus struct {
+	State   string    `json:"state"`
+	Updated time.Time `json:"updated"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// DeliveryStatus records the state of every recipient of a message in a
+// <messageID>.status JSON file kept beside the spooled message. Outcomes are
+// collected in memory and written atomically once per delivery batch.
+// A nil *DeliveryStatus records nothing.
+type DeliveryStatus struct {
+	MessageID  string                     `json:"message_id"`
+	Recipients map[string]RecipientStatus `json:"recipients"`
+
+	path string
+	mu   sync.Mutex
+}
+
+// DeliveryStatusPath returns the path of the status file for a message in dir.
+func DeliveryStatusPath(dir, messageID string) string {
+	return filepath.Join(dir, messageID+".status")
+}
+
+// NewDeliveryStatus opens the status file for a message in dir, creating it
+// when missing. States already recorded by an earlier attempt are kept; any
+// recipient not yet listed is added as pending.
+func NewDeliveryStatus(dir, messageID string, recipientSets ...map[string]struct{}) (*DeliveryStatus, error) {
+	s, err := LoadDeliveryStatus(dir, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if s == nil {
+		s = &DeliveryStatus{
+			MessageID:  messageID,
+			Recipients: make(map[string]RecipientStatus),
+			path:       DeliveryStatusPath(dir, messageID),
+		}
+	}
+	if s.Recipients == nil {
+		s.Recipients = make(map[string]RecipientStatus)
+	}
+
+	now := time.Now().UTC()
+	for _, recipients := range recipientSets {
+		for recipient := range recipients {
+			if _, ok := s.Recipients[recipient]; !ok {
+				s.Recipients[recipient] = RecipientStatus{State: RecipientStatePending, Updated: now}
+			}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.save(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// MoveDeliveryStatus moves the status file of a message from one directory to
+// another; a message without one is left alone.
+func MoveDeliveryStatus(fromDir, toDir, messageID string) error {
+	err := os.Rename(DeliveryStatusPath(fromDir, messageID), DeliveryStatusPath(toDir, messageID))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to move delivery status for %s: %w", messageID, err)
+	}
+	return nil
+}
+
+// LoadDeliveryStatus reads the status file for a message in dir. Returns nil, nil if not found.
+func LoadDeliveryStatus(dir, messageID string) (*DeliveryStatus, error) {
+	path := DeliveryStatusPath(dir, messageID)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read delivery status for %s: %w", messageID, err)
+	}
+	s := &DeliveryStatus{path: path}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("failed to parse delivery status for %s: %w", messageID, err)
+	}
+	return s, nil
+}
+
+// Record sets the state of recipient in memory; deliveryErr, if any, is kept
+// as the reason. Save writes it out.
+func (s *DeliveryStatus) Record(recipient, state string, deliveryErr error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := RecipientStatus{State: state, Updated: time.Now().UTC()}
+	if deliveryErr != nil {
+		status.Error = deliveryErr.Error()
+	}
+	s.Recipients[recipient] = status
+}
+
+// Save durably writes the recorded states.
+func (s *DeliveryStatus) Save() error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.save()
+}
+
+// RecordResult records every recipient of an outbound DeliveryResult:
+// tempfails are deferred, permfails failed.
+func (s *DeliveryStatus) RecordResult(result DeliveryResult) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	set := func(recipients []string, state string) {
+		for _, recipient := range recipients {
+			s.Recipients[recipient] = RecipientStatus{State: state, Updated: now}
+		}
+	}
+	set(result.Successful, RecipientStateDelivered)
+	set(result.Failed, RecipientStateFailed)
+	set(result.PermFailed, RecipientStateFailed)
+	set(result.TempFailed, RecipientStateDeferred)
+	return s.save()
+}
+
+// MoveTo moves the status file into dir, following its message to a new spool state.
+func (s *DeliveryStatus) MoveTo(dir string) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	target := DeliveryStatusPath(dir, s.MessageID)
+	if err := os.Rename(s.path, target); err != nil {
+		return fmt.Errorf("failed to move delivery status for %s: %w", s.MessageID, err)
+	}
+	s.path = target
+	return nil
+}
+
+// save writes the status file atomically; the caller must hold s.mu.
+func (s *DeliveryStatus) save() error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("failed to marshal delivery status: %w", err)
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write delivery status: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to commit delivery status: %w", err)
+	}
+	return nil
+}
@@ -62,6 +62,19 @@ func verifyMaildirStructure(t *testing.T, maildirBase string) {
 	}
 }
 
+// verifyMaildirTmpEmpty checks that delivery left nothing behind in tmp/
+func verifyMaildirTmpEmpty(t *testing.T, maildirBase string) {
+	t.Helper()
+
+	files, err := os.ReadDir(filepath.Join(maildirBase, "tmp"))
+	if err != nil {
+		t.Fatalf("Failed to read tmp/ directory: %v", err)
+	}
+	for _, f := range files {
+		t.Errorf("Leftover file in tmp/: %s", f.Name())
+	}
+}
+
 // verifyDeliveredMessage checks message was delivered with correct content and filename
 func verifyDeliveredMessage(t *testing.T, newDir, expectedContent, expectedMessageID string) {
 	t.Helper()
@@ -91,7 +104,21 @@ func verifyDeliveredMessage(t *testing.T, newDir, expectedContent, expectedMessa
 }
 
 // validateMaildirFilename validates format: timestamp.pid.messageID.golubsmtpd
+// with an optional Dovecot-style ",S=<size>:2,<flags>" suffix
 func validateMaildirFilename(filename, expectedMessageID string) error {
+	if base, info, ok := strings.Cut(filename, ":"); ok {
+		if !strings.HasPrefix(info, "2,") {
+			return fmt.Errorf("invalid info section %q in %q", info, filename)
+		}
+		filename = base
+	}
+	if base, size, ok := strings.Cut(filename, ",S="); ok {
+		if _, err := strconv.ParseInt(size, 10, 64); err != nil {
+			return fmt.Errorf("invalid size hint %q: %v", size, err)
+		}
+		filename = base
+	}
+
 	parts := strings.Split(filename, ".")
 	if len(parts) != 4 {
 		return fmt.Errorf("expected 4 parts, got %d in %q", len(parts), filename)
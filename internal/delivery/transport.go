@@ -0,0 +1,69 @@
+package delivery
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/pawciobiel/golubsmtpd/internal/config"
+	"github.com/pawciobiel/golubsmtpd/internal/types"
+)
+
+// TransportMap routes recipients to a delivery method other than the default
+// for their domain type, in the manner of Postfix transport_maps. Entries are
+// keyed by address or domain; an exact address takes precedence over its
+// domain. A nil TransportMap matches nothing.
+type TransportMap struct {
+	entries map[string]config.Transport // lowercased address or "@domain" -> transport
+}
+
+// NewTransportMap parses the configured transport_maps. Returns nil when the
+// map is empty.
+func NewTransportMap(maps map[string]string) (*TransportMap, error) {
+	if len(maps) == 0 {
+		return nil, nil
+	}
+
+	entries := make(map[string]config.Transport, len(maps))
+	for key, spec := range maps {
+		transport, err := config.ParseTransport(spec)
+		if err != nil {
+			return nil, fmt.Errorf("transport_maps %q: %w", key, err)
+		}
+		key = strings.ToLower(key)
+		if !strings.Contains(key, "@") {
+			key = "@" + key
+		}
+		entries[key] = transport
+	}
+	return &TransportMap{entries: entries}, nil
+}
+
+// Lookup returns the transport for recipient, trying the exact address before
+// its domain
+func (m *TransportMap) Lookup(recipient string) (config.Transport, bool) {
+	if m == nil {
+		return config.Transport{}, false
+	}
+	at := strings.LastIndex(recipient, "@")
+	if at == -1 {
+		return config.Transport{}, false
+	}
+	if transport, ok := m.entries[strings.ToLower(recipient)]; ok {
+		return transport, true
+	}
+	transport, ok := m.entries[strings.ToLower(recipient[at:])]
+	return transport, ok
+}
+
+// DeliverToTransportCommand pipes the message to a transport_maps command.
+// The command runs as the server user, as it belongs to no local account.
+func DeliverToTransportCommand(ctx context.Context, msg *types.Message, messagePath, recipient, command string, cfg *config.LocalDeliveryConfig) error {
+	headers := fmt.Sprintf("%s: %s\r\n", DeliveredToHeader, recipient)
+	if err := deliverToCommand(ctx, msg, messagePath, headers, recipient, "", command, cfg.MailboxCommandTimeout); err != nil {
+		return err
+	}
+	slog.Info("Transport command delivery successful", "recipient", recipient, "message_id", msg.ID)
+	return nil
+}
@@ -0,0 +1,43 @@
+package delivery
+
+import (
+	"testing"
+
+	"github.com/pawciobiel/golubsmtpd/internal/config"
+)
+
+func TestTransportMap_Lookup(t *testing.T) {
+	m, err := NewTransportMap(map[string]string{
+		"example.org":         "virtual:/srv/mail",
+		"@example.net":        "relay:mx.example.net",
+		"Boss@Example.org":    "local",
+		"tickets@example.com": "command:/usr/local/bin/ticket",
+	})
+	if err != nil {
+		t.Fatalf("NewTransportMap: %v", err)
+	}
+
+	tests := []struct {
+		recipient string
+		want      config.Transport
+		wantOK    bool
+	}{
+		{"alice@example.org", config.Transport{Kind: config.TransportVirtual, Arg: "/srv/mail"}, true},
+		{"boss@EXAMPLE.org", config.Transport{Kind: config.TransportLocal}, true},
+		{"bob@example.net", config.Transport{Kind: config.TransportRelay, Arg: "mx.example.net"}, true},
+		{"tickets@example.com", config.Transport{Kind: config.TransportCommand, Arg: "/usr/local/bin/ticket"}, true},
+		{"alice@example.com", config.Transport{}, false},
+		{"postmaster", config.Transport{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := m.Lookup(tt.recipient)
+		if ok != tt.wantOK || got != tt.want {
+			t.Errorf("Lookup(%q) = %+v, %v; want %+v, %v", tt.recipient, got, ok, tt.want, tt.wantOK)
+		}
+	}
+
+	var empty *TransportMap
+	if _, ok := empty.Lookup("alice@example.org"); ok {
+		t.Error("nil TransportMap should match nothing")
+	}
+}
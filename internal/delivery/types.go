@@ -20,8 +20,9 @@ type DeliveryResult struct {
 	Type       RecipientType
 	Successful []string
 	Failed     []string // generic fail — used by local/virtual delivery
-	TempFailed []string // 4xx — outbound only, schedule retry
+	TempFailed []string // 4xx or temporary DeliveryError — schedule retry
 	PermFailed []string // 5xx — outbound only, generate bounce
+	DSNPassed  []string // successful outbound recipients whose DSN request the receiving server took over
 }
 
 // DeliveryOutcome represents the result of a single delivery attempt
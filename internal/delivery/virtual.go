@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 
 	"github.com/pawciobiel/golubsmtpd/internal/auth"
+	"github.com/pawciobiel/golubsmtpd/internal/config"
 	"github.com/pawciobiel/golubsmtpd/internal/types"
 )
 
@@ -17,16 +18,17 @@ func GetVirtualMaildirPath(email string, virtualRoot string) string {
 
 // DeliverToVirtualUser handles delivery to a single virtual user
 // Note: recipient is already validated by authentication system during RCPT TO
-func DeliverToVirtualUser(ctx context.Context, msg *types.Message, messagePath, recipient, virtualRoot string) error {
+// I/O failures are temporary DeliveryErrors, so the recipient is retried.
+func DeliverToVirtualUser(ctx context.Context, msg *types.Message, messagePath, recipient string, cfg *config.VirtualDeliveryConfig) error {
 	// Extract username and domain for path calculation
 	username, domain := auth.ExtractUsernameAndDomain(recipient)
 
 	// Calculate Maildir base path for virtual user
-	maildirBase := filepath.Join(virtualRoot, domain, username, "Maildir")
+	maildirBase := filepath.Join(cfg.BaseDirPath, domain, username, "Maildir")
 
 	// Perform the actual delivery
-	if err := deliverToMaildir(ctx, msg, messagePath, maildirBase, recipient); err != nil {
-		return err
+	if err := deliverToMaildir(ctx, msg, messagePath, maildirBase, recipient, "", cfg.ExtendedFilenames, nil); err != nil {
+		return temporaryFailure(recipient, err)
 	}
 
 	slog.Info("Virtual delivery successful",
@@ -2,8 +2,14 @@ package delivery
 
 import (
 	"context"
+	"fmt"
+	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/pawciobiel/golubsmtpd/internal/config"
+	"github.com/pawciobiel/golubsmtpd/internal/types"
 )
 
 func TestDeliverToVirtualUser(t *testing.T) {
@@ -11,7 +17,7 @@ func TestDeliverToVirtualUser(t *testing.T) {
 	virtualRoot := ts.setupVirtualDelivery(t)
 	recipient := "testuser@testdomain.com"
 
-	err := DeliverToVirtualUser(context.Background(), ts.msg, ts.testMessagePath, recipient, virtualRoot)
+	err := DeliverToVirtualUser(context.Background(), ts.msg, ts.testMessagePath, recipient, &config.VirtualDeliveryConfig{BaseDirPath: virtualRoot})
 	if err != nil {
 		t.Fatalf("DeliverToVirtualUser failed: %v", err)
 	}
@@ -19,7 +25,8 @@ func TestDeliverToVirtualUser(t *testing.T) {
 	// Verify virtual-specific path structure: virtualRoot/domain/username/Maildir
 	maildirBase := filepath.Join(virtualRoot, "testdomain.com", "testuser", "Maildir")
 	verifyMaildirStructure(t, maildirBase)
-	verifyDeliveredMessage(t, filepath.Join(maildirBase, "new"), ts.testContent, ts.msg.ID)
+	verifyDeliveredMessage(t, filepath.Join(maildirBase, "new"), "X-Original-To: "+recipient+"\r\n"+ts.testContent, ts.msg.ID)
+	verifyMaildirTmpEmpty(t, maildirBase)
 }
 
 func TestDeliverToVirtualUser_MultipleDomains(t *testing.T) {
@@ -34,7 +41,7 @@ func TestDeliverToVirtualUser_MultipleDomains(t *testing.T) {
 
 	// Deliver to multiple virtual users across different domains
 	for _, recipient := range recipients {
-		err := DeliverToVirtualUser(context.Background(), ts.msg, ts.testMessagePath, recipient, virtualRoot)
+		err := DeliverToVirtualUser(context.Background(), ts.msg, ts.testMessagePath, recipient, &config.VirtualDeliveryConfig{BaseDirPath: virtualRoot})
 		if err != nil {
 			t.Fatalf("DeliverToVirtualUser failed for %s: %v", recipient, err)
 		}
@@ -50,7 +57,7 @@ func TestDeliverToVirtualUser_MultipleDomains(t *testing.T) {
 	for email, relativePath := range expectedPaths {
 		maildirBase := filepath.Join(virtualRoot, relativePath)
 		verifyMaildirStructure(t, maildirBase)
-		verifyDeliveredMessage(t, filepath.Join(maildirBase, "new"), ts.testContent, ts.msg.ID)
+		verifyDeliveredMessage(t, filepath.Join(maildirBase, "new"), "X-Original-To: "+email+"\r\n"+ts.testContent, ts.msg.ID)
 		t.Logf("Verified delivery for %s", email)
 	}
 }
@@ -86,3 +93,76 @@ func TestGetVirtualMaildirPath(t *testing.T) {
 		}
 	}
 }
+
+func TestDeliverToVirtualUser_ExtendedFilename(t *testing.T) {
+	ts := newTestSetup(t, "virtual-ext-321")
+	virtualRoot := ts.setupVirtualDelivery(t)
+	cfg := &config.VirtualDeliveryConfig{BaseDirPath: virtualRoot, ExtendedFilenames: true}
+
+	if err := DeliverToVirtualUser(context.Background(), ts.msg, ts.testMessagePath, "testuser@testdomain.com", cfg); err != nil {
+		t.Fatalf("DeliverToVirtualUser failed: %v", err)
+	}
+
+	newDir := filepath.Join(virtualRoot, "testdomain.com", "testuser", "Maildir", "new")
+	content := "X-Original-To: testuser@testdomain.com\r\n" + ts.testContent
+	verifyDeliveredMessage(t, newDir, content, ts.msg.ID)
+
+	files, err := os.ReadDir(newDir)
+	if err != nil {
+		t.Fatalf("Failed to read new/ directory: %v", err)
+	}
+	wantSuffix := fmt.Sprintf(",S=%d:2,", len(content))
+	if name := files[0].Name(); !strings.HasSuffix(name, wantSuffix) {
+		t.Errorf("Filename %q should end with %q", name, wantSuffix)
+	}
+}
+
+func TestDeliverToVirtualUser_OriginalTo(t *testing.T) {
+	ts := newTestSetup(t, "virtual-orig-to")
+	virtualRoot := ts.setupVirtualDelivery(t)
+	ts.msg.VirtualRecipients = types.NewRecipientSet("alice@company.com")
+	ts.msg.SetOriginalRecipient("alice@company.com", "sales@company.com")
+
+	if err := DeliverToVirtualUser(context.Background(), ts.msg, ts.testMessagePath, "alice@company.com", &config.VirtualDeliveryConfig{BaseDirPath: virtualRoot}); err != nil {
+		t.Fatalf("DeliverToVirtualUser failed: %v", err)
+	}
+
+	// The copy names the alias the sender used, not the expanded mailbox
+	newDir := filepath.Join(virtualRoot, "company.com", "alice", "Maildir", "new")
+	verifyDeliveredMessage(t, newDir, "X-Original-To: sales@company.com\r\n"+ts.testContent, ts.msg.ID)
+}
+
+func TestDeliverToVirtualUser_FailureCleansTmp(t *testing.T) {
+	ts := newTestSetup(t, "virtual-fail-654")
+	virtualRoot := ts.setupVirtualDelivery(t)
+	cfg := &config.VirtualDeliveryConfig{BaseDirPath: virtualRoot}
+
+	// Source disappears before delivery so the copy into tmp/ fails
+	if err := os.Remove(ts.testMessagePath); err != nil {
+		t.Fatalf("Failed to remove test message: %v", err)
+	}
+	if err := DeliverToVirtualUser(context.Background(), ts.msg, ts.testMessagePath, "testuser@testdomain.com", cfg); err == nil {
+		t.Fatal("Expected delivery to fail for missing source")
+	}
+
+	maildirBase := filepath.Join(virtualRoot, "testdomain.com", "testuser", "Maildir")
+	verifyMaildirTmpEmpty(t, maildirBase)
+	if files, _ := os.ReadDir(filepath.Join(maildirBase, "new")); len(files) != 0 {
+		t.Errorf("Expected empty new/ after failed delivery, got %d files", len(files))
+	}
+}
+
+func TestDeliverToVirtualUser_IOFailureTemporary(t *testing.T) {
+	ts := newTestSetup(t, "virtual-io-fail")
+	// A file where the domain directory should be makes every mkdir fail
+	blocked := filepath.Join(ts.tempDir, "blocked")
+	if err := os.WriteFile(blocked, nil, 0o600); err != nil {
+		t.Fatalf("Failed to create blocking file: %v", err)
+	}
+	cfg := &config.VirtualDeliveryConfig{BaseDirPath: blocked}
+
+	err := DeliverToVirtualUser(context.Background(), ts.msg, ts.testMessagePath, "testuser@testdomain.com", cfg)
+	if !IsTemporary(err) {
+		t.Errorf("Expected a temporary failure for a Maildir that cannot be created, got: %v", err)
+	}
+}
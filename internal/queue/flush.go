@@ -0,0 +1,74 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/pawciobiel/golubsmtpd/internal/delivery"
+)
+
+// Flush re-injects every deferred message — those with pending retry state —
+// regardless of its next retry time, and returns how many were queued.
+// Only recipients still awaiting delivery are retried, each routed by its
+// domain; the retry state is kept so attempts and max age carry over.
+func (q *Queue) Flush(ctx context.Context) (int, error) {
+	ids, err := ListMessageIDs(q.spool, MessageStateRetry)
+	if err != nil {
+		return 0, err
+	}
+
+	flushed := 0
+	for _, id := range ids {
+		state, err := delivery.LoadRetryState(q.spool, id)
+		if err != nil || state == nil {
+			log().Warn("Skipping unreadable retry state", "message_id", id, "error", err)
+			continue
+		}
+		pending := state.PendingRecipients()
+		if len(pending) == 0 {
+			continue
+		}
+
+		msg, err := findSpooledMessage(q.spool, MessageStateFailed, id)
+		if errors.Is(err, os.ErrNotExist) {
+			// Held or already reaped: nothing to re-deliver from
+			continue
+		}
+		if err != nil {
+			return flushed, err
+		}
+		msg.From = state.From
+		msg.BodyType = state.BodyType
+		msg.DSNRet = state.DSNRet
+		msg.DSNEnvID = state.DSNEnvID
+		msg.LocalRecipients = NewRecipientSet()
+		msg.VirtualRecipients = NewRecipientSet()
+		msg.RelayRecipients = NewRecipientSet()
+		msg.ExternalRecipients = NewRecipientSet()
+		for addr := range pending {
+			info, _ := q.recipientsFor(msg, addr).Add(addr)
+			info.DSN = state.RecipientDSN[addr]
+		}
+
+		if err := MoveMessage(q.spool, msg, MessageStateFailed, MessageStateIncoming); err != nil {
+			return flushed, err
+		}
+		if err := delivery.MoveDeliveryStatus(q.spool.MessageDir(MessageStateFailed, id), q.spool.MessageDir(MessageStateIncoming, id), id); err != nil {
+			log().Warn("Failed to carry delivery status with deferred message", "message_id", id, "error", err)
+		}
+		if err := q.PublishMessage(ctx, msg); err != nil {
+			if moveErr := MoveMessage(q.spool, msg, MessageStateIncoming, MessageStateFailed); moveErr != nil {
+				log().Error("Failed to return deferred message to failed", "message_id", id, "error", moveErr)
+			} else if moveErr := delivery.MoveDeliveryStatus(q.spool.MessageDir(MessageStateIncoming, id), q.spool.MessageDir(MessageStateFailed, id), id); moveErr != nil {
+				log().Error("Failed to return delivery status to failed", "message_id", id, "error", moveErr)
+			}
+			return flushed, fmt.Errorf("failed to publish deferred message %s: %w", id, err)
+		}
+		flushed++
+	}
+
+	log().Info("Deferred messages flushed", "count", flushed)
+	return flushed, nil
+}
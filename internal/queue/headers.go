@@ -0,0 +1,165 @@
+package queue
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// StripMessageHeaders removes the named header fields (case-insensitive, with
+// their folded continuation lines) from the header block of a spooled message.
+// The body is copied untouched. The file is only rewritten, atomically, when a
+// field was removed. Returns the resulting size and the number of fields removed.
+func StripMessageHeaders(spool Spool, path string, names ...string) (int64, int, error) {
+	removed := 0
+	size, _, err := RewriteMessageHeaders(spool, path, func(fields []string) ([]string, bool) {
+		kept := fields[:0]
+		for _, field := range fields {
+			if headerNameIn(HeaderFieldName(field), names) {
+				removed++
+				continue
+			}
+			kept = append(kept, field)
+		}
+		return kept, removed > 0
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return size, removed, nil
+}
+
+// headerNameIn reports whether name matches one of names, ignoring case
+func headerNameIn(name string, names []string) bool {
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// RewriteMessageHeaders passes the header block of a spooled message to edit
+// as a list of fields, each with its folded continuation lines and line
+// endings. The block ends at the first empty line or at the first line that is
+// not a header field. When edit reports a change the file is rewritten,
+// atomically, with the returned fields; the body is copied untouched. Returns
+// the resulting size and whether the file was rewritten.
+func RewriteMessageHeaders(spool Spool, path string, edit func(fields []string) ([]string, bool)) (int64, bool, error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to open message %s: %w", path, err)
+	}
+	defer src.Close()
+
+	reader := bufio.NewReader(src)
+	var fields []string
+	separator := "" // the empty line ending the header block, if any
+	bodyStart := "" // first body line when no empty line ended the header block
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return 0, false, fmt.Errorf("failed to read message headers: %w", err)
+		}
+		if line == "" {
+			break
+		}
+		if line == "\r\n" || line == "\n" {
+			separator = line
+			break
+		}
+		// Continuation lines belong to the preceding field
+		if len(fields) > 0 && (line[0] == ' ' || line[0] == '\t') {
+			fields[len(fields)-1] += line
+		} else if isHeaderField(line) {
+			fields = append(fields, line)
+		} else {
+			// Headerless content starts the body; it gets a separator if rewritten
+			bodyStart = line
+			separator = "\r\n"
+			break
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+
+	fields, changed := edit(fields)
+	if !changed {
+		info, err := src.Stat()
+		if err != nil {
+			return 0, false, fmt.Errorf("failed to stat message %s: %w", path, err)
+		}
+		return info.Size(), false, nil
+	}
+
+	tempFile := path + ".tmp"
+	dst, err := os.OpenFile(tempFile, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o600)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to create temporary file %s: %w", tempFile, err)
+	}
+	committed := false
+	defer func() {
+		dst.Close()
+		if !committed {
+			os.Remove(tempFile)
+		}
+	}()
+
+	writer := bufio.NewWriter(dst)
+	var size int64
+	for _, field := range append(fields, separator, bodyStart) {
+		n, err := writer.WriteString(field)
+		size += int64(n)
+		if err != nil {
+			return 0, false, fmt.Errorf("failed to write message: %w", err)
+		}
+	}
+	copied, err := io.Copy(writer, reader)
+	size += copied
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to copy message body: %w", err)
+	}
+
+	if err := writer.Flush(); err != nil {
+		return 0, false, fmt.Errorf("failed to write message: %w", err)
+	}
+	if err := dst.Sync(); err != nil {
+		return 0, false, fmt.Errorf("failed to sync message: %w", err)
+	}
+	if err := dst.Close(); err != nil {
+		return 0, false, fmt.Errorf("failed to close message: %w", err)
+	}
+	if err := os.Rename(tempFile, path); err != nil {
+		return 0, false, fmt.Errorf("failed to replace message %s: %w", path, err)
+	}
+	committed = true
+	if err := syncSpoolDir(spool, filepath.Dir(path)); err != nil {
+		return 0, false, err
+	}
+	return size, true, nil
+}
+
+// isHeaderField reports whether line starts a header field: a non-empty name
+// of printable characters other than space, followed by a colon (RFC 5322 §2.2)
+func isHeaderField(line string) bool {
+	name, _, found := strings.Cut(line, ":")
+	if !found || name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if name[i] <= ' ' || name[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// HeaderFieldName returns the name of a header field line, e.g. "Subject"
+func HeaderFieldName(field string) string {
+	name, _, _ := strings.Cut(field, ":")
+	return strings.TrimSpace(name)
+}
@@ -0,0 +1,97 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/pawciobiel/golubsmtpd/internal/delivery"
+)
+
+// ErrMessageNotHeld is returned by Requeue when no held message has the given ID
+var ErrMessageNotHeld = errors.New("message not held")
+
+// heldEnvelopePath returns the path of the envelope saved alongside a held
+// message; the spool file only holds the body, so recipients must be kept too
+func heldEnvelopePath(spool Spool, messageID string) string {
+	return filepath.Join(spool.MessageDir(MessageStateHold, messageID), messageID+".json")
+}
+
+// holdMessage quarantines a message in hold/ together with its envelope so it
+// can be reviewed and later re-injected with Requeue
+func (q *Queue) holdMessage(msg *Message, fromState MessageState) error {
+	envelope := *msg
+	envelope.RawBody = "" // body lives in the spool file
+	data, err := json.Marshal(&envelope)
+	if err != nil {
+		return fmt.Errorf("failed to marshal envelope for %s: %w", msg.ID, err)
+	}
+
+	dir, err := ensureMessageDir(q.spool, MessageStateHold, msg.ID)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, msg.ID+".json")
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write envelope for %s: %w", msg.ID, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to commit envelope for %s: %w", msg.ID, err)
+	}
+
+	if err := MoveMessage(q.spool, msg, fromState, MessageStateHold); err != nil {
+		os.Remove(path)
+		return err
+	}
+	return nil
+}
+
+// Requeue re-injects a held message into incoming/ and publishes it for delivery.
+// Recipients already delivered before the message was held are skipped, and
+// its delivery status comes along.
+func (q *Queue) Requeue(ctx context.Context, messageID string) error {
+	path := heldEnvelopePath(q.spool, messageID)
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%w: %s", ErrMessageNotHeld, messageID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read envelope for %s: %w", messageID, err)
+	}
+
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return fmt.Errorf("failed to parse envelope for %s: %w", messageID, err)
+	}
+
+	if err := MoveMessage(q.spool, &msg, MessageStateHold, MessageStateIncoming); err != nil {
+		return err
+	}
+	holdDir := q.spool.MessageDir(MessageStateHold, messageID)
+	incomingDir := q.spool.MessageDir(MessageStateIncoming, messageID)
+	if err := delivery.MoveDeliveryStatus(holdDir, incomingDir, messageID); err != nil {
+		log().Warn("Failed to carry delivery status with requeued message", "message_id", messageID, "error", err)
+	}
+
+	if err := q.PublishMessage(ctx, &msg); err != nil {
+		// Keep it held so the operator can retry
+		if moveErr := MoveMessage(q.spool, &msg, MessageStateIncoming, MessageStateHold); moveErr != nil {
+			log().Error("Failed to return message to hold", "message_id", messageID, "error", moveErr)
+		} else if moveErr := delivery.MoveDeliveryStatus(incomingDir, holdDir, messageID); moveErr != nil {
+			log().Error("Failed to return delivery status to hold", "message_id", messageID, "error", moveErr)
+		}
+		return fmt.Errorf("failed to publish requeued message %s: %w", messageID, err)
+	}
+
+	if err := os.Remove(path); err != nil {
+		log().Warn("Failed to remove held envelope", "message_id", messageID, "error", err)
+	}
+	log().Info("Held message requeued", "message_id", messageID)
+	return nil
+}
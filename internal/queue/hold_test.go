@@ -0,0 +1,145 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pawciobiel/golubsmtpd/internal/delivery"
+)
+
+func TestQueue_HoldAndRequeue(t *testing.T) {
+	cfg := createQueueTestConfig()
+	cfg.Server.SpoolDir = t.TempDir()
+	cfg.Queue.HoldFailed = true
+	if err := InitializeSpoolDirectories(cfg.Server.SpoolDir); err != nil {
+		t.Fatalf("Failed to initialize spool: %v", err)
+	}
+
+	// A regular file as the virtual root makes every Maildir creation fail, deferring the recipient
+	brokenRoot := filepath.Join(t.TempDir(), "not-a-dir")
+	if err := os.WriteFile(brokenRoot, nil, 0o600); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	cfg.Delivery.Virtual.BaseDirPath = brokenRoot
+	cfg.Delivery.Outbound.RetryInterval = time.Minute
+	cfg.Delivery.Outbound.RetryMaxAge = time.Hour
+	queue := mustNewQueue(t, context.Background(), cfg)
+
+	msg := &Message{
+		ID:                GenerateID(),
+		Created:           time.Now().UTC(),
+		From:              "sender@example.com",
+		ClientIP:          "192.0.2.1",
+		VirtualRecipients: NewRecipientSet("alice@example.com"),
+		RawBody:           "Subject: held\r\n\r\nbody\r\n",
+	}
+	if err := WriteRawBody(NewSpool(cfg), msg); err != nil {
+		t.Fatalf("Failed to spool message: %v", err)
+	}
+
+	queue.processMessage(context.Background(), msg)
+	assertRecipientState(t, NewSpool(cfg).MessageDir(MessageStateHold, msg.ID), msg.ID,
+		"alice@example.com", delivery.RecipientStateDeferred)
+
+	if _, err := os.Stat(GetMessagePath(NewSpool(cfg), msg, MessageStateHold)); err != nil {
+		t.Fatalf("Expected message in hold/: %v", err)
+	}
+	if _, err := os.Stat(GetMessagePath(NewSpool(cfg), msg, MessageStateFailed)); !os.IsNotExist(err) {
+		t.Errorf("Held message must not also be in failed/: %v", err)
+	}
+
+	// Operator fixes the mailbox store and re-injects the message
+	cfg.Delivery.Virtual.BaseDirPath = t.TempDir()
+	if err := queue.Requeue(context.Background(), msg.ID); err != nil {
+		t.Fatalf("Requeue failed: %v", err)
+	}
+
+	if _, err := os.Stat(GetMessagePath(NewSpool(cfg), msg, MessageStateIncoming)); err != nil {
+		t.Errorf("Expected requeued message in incoming/: %v", err)
+	}
+	if _, err := os.Stat(heldEnvelopePath(NewSpool(cfg), msg.ID)); !os.IsNotExist(err) {
+		t.Errorf("Expected held envelope removed after requeue: %v", err)
+	}
+	assertRecipientState(t, NewSpool(cfg).MessageDir(MessageStateIncoming, msg.ID), msg.ID,
+		"alice@example.com", delivery.RecipientStateDeferred)
+
+	requeued := <-queue.messageQueue
+	if diff := cmp.Diff(msg.VirtualRecipients, requeued.VirtualRecipients); diff != "" {
+		t.Errorf("Recipients mismatch (-want +got):\n%s", diff)
+	}
+	if requeued.From != msg.From || requeued.ClientIP != msg.ClientIP || !requeued.Created.Equal(msg.Created) {
+		t.Errorf("Envelope not preserved: got %+v", requeued)
+	}
+
+	queue.processMessage(context.Background(), requeued)
+	if _, err := os.Stat(GetMessagePath(NewSpool(cfg), msg, MessageStateDelivered)); err != nil {
+		t.Errorf("Expected requeued message delivered: %v", err)
+	}
+	assertRecipientState(t, NewSpool(cfg).MessageDir(MessageStateDelivered, msg.ID), msg.ID,
+		"alice@example.com", delivery.RecipientStateDelivered)
+}
+
+// assertRecipientState checks the state of recipient in the status file kept in dir
+func assertRecipientState(t *testing.T, dir, messageID, recipient, want string) {
+	t.Helper()
+	status, err := delivery.LoadDeliveryStatus(dir, messageID)
+	if err != nil || status == nil {
+		t.Fatalf("LoadDeliveryStatus in %s: status=%v err=%v", dir, status, err)
+	}
+	if got := status.Recipients[recipient].State; got != want {
+		t.Errorf("Status of %s = %q, want %q", recipient, got, want)
+	}
+}
+
+func TestQueue_RequeueAddressOnlyEnvelope(t *testing.T) {
+	cfg := createQueueTestConfig()
+	cfg.Server.SpoolDir = t.TempDir()
+	if err := InitializeSpoolDirectories(cfg.Server.SpoolDir); err != nil {
+		t.Fatalf("Failed to initialize spool: %v", err)
+	}
+	queue := mustNewQueue(t, context.Background(), cfg)
+
+	// Envelopes held before recipients carried details list bare addresses
+	msg := &Message{ID: GenerateID(), RawBody: "Subject: held\r\n\r\nbody\r\n"}
+	if err := WriteRawBody(NewSpool(cfg), msg); err != nil {
+		t.Fatalf("Failed to spool message: %v", err)
+	}
+	if err := MoveMessage(NewSpool(cfg), msg, MessageStateIncoming, MessageStateHold); err != nil {
+		t.Fatalf("Failed to hold message: %v", err)
+	}
+	envelope := `{"ID":"` + msg.ID + `","From":"sender@example.com","VirtualRecipients":{"alice@example.com":{}}}`
+	if err := os.WriteFile(heldEnvelopePath(NewSpool(cfg), msg.ID), []byte(envelope), 0o600); err != nil {
+		t.Fatalf("Failed to write envelope: %v", err)
+	}
+
+	if err := queue.Requeue(context.Background(), msg.ID); err != nil {
+		t.Fatalf("Requeue failed: %v", err)
+	}
+
+	requeued := <-queue.messageQueue
+	want := NewRecipientSet("alice@example.com")
+	if diff := cmp.Diff(want, requeued.VirtualRecipients); diff != "" {
+		t.Errorf("Recipients mismatch (-want +got):\n%s", diff)
+	}
+	if n := requeued.TotalRecipients(); n != 1 {
+		t.Errorf("TotalRecipients() = %d, want 1", n)
+	}
+}
+
+func TestQueue_RequeueUnknownMessage(t *testing.T) {
+	cfg := createQueueTestConfig()
+	cfg.Server.SpoolDir = t.TempDir()
+	if err := InitializeSpoolDirectories(cfg.Server.SpoolDir); err != nil {
+		t.Fatalf("Failed to initialize spool: %v", err)
+	}
+	queue := mustNewQueue(t, context.Background(), cfg)
+
+	if err := queue.Requeue(context.Background(), "does-not-exist"); !errors.Is(err, ErrMessageNotHeld) {
+		t.Errorf("Expected ErrMessageNotHeld, got %v", err)
+	}
+}
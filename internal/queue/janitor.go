@@ -0,0 +1,154 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pawciobiel/golubsmtpd/internal/delivery"
+)
+
+// runJanitor periodically reaps expired delivered/failed spool files until
+// ctx is cancelled or the consumer exits
+func (q *Queue) runJanitor(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			q.reapExpired(time.Now())
+		case <-q.consumerDone:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// reapExpired removes delivered and failed messages past their configured
+// retention, with the retry state of reaped failed messages. Held messages
+// wait for an operator and are never reaped, nor is their retry state.
+func (q *Queue) reapExpired(now time.Time) {
+	retentions := []struct {
+		state     MessageState
+		retention time.Duration
+	}{
+		{MessageStateDelivered, q.config.Queue.DeliveredRetention},
+		{MessageStateFailed, q.config.Queue.FailedRetention},
+	}
+
+	for _, r := range retentions {
+		removed, err := reapSpoolState(q.spool.Dir, r.state, r.retention, now)
+		if r.state == MessageStateFailed {
+			q.deleteReapedRetryState(removed)
+		}
+		if err != nil {
+			log().Error("Failed to reap expired spool files", "state", r.state, "removed", len(removed), "error", err)
+			continue
+		}
+		if len(removed) > 0 {
+			log().Info("Reaped expired spool files", "state", r.state, "removed", len(removed), "retention", r.retention)
+		}
+	}
+
+	// Markers outlive only failed and held messages (delivered ones remove theirs).
+	// A held message may still be requeued, so its markers are kept for as long
+	// as it is held.
+	removed, err := reapDir(delivery.DeliveryMarkersDir(q.spool.Dir), q.config.Queue.FailedRetention, now, func(name string) bool {
+		_, err := os.Stat(heldEnvelopePath(q.spool, strings.TrimSuffix(name, filepath.Ext(name))))
+		return err == nil
+	})
+	if err != nil {
+		log().Error("Failed to reap expired delivery markers", "removed", len(removed), "error", err)
+	} else if len(removed) > 0 {
+		log().Info("Reaped expired delivery markers", "removed", len(removed), "retention", q.config.Queue.FailedRetention)
+	}
+}
+
+// deleteReapedRetryState removes the retry state of the reaped failed spool
+// files, which would otherwise outlive their messages
+func (q *Queue) deleteReapedRetryState(names []string) {
+	for _, name := range names {
+		id, _, ok := parseSpoolFilename(name)
+		if !ok {
+			continue
+		}
+		if err := delivery.DeleteRetryState(q.spool, id); err != nil {
+			log().Error("Failed to delete retry state of reaped message", "message_id", id, "error", err)
+		}
+	}
+}
+
+// reapSpoolState deletes files in a spool state directory and its shard
+// directories whose mtime is older than retention. A retention of 0 keeps
+// files forever. Returns the names of the files removed.
+func reapSpoolState(spoolDir string, state MessageState, retention time.Duration, now time.Time) ([]string, error) {
+	dir := filepath.Join(spoolDir, string(state))
+	removed, err := reapDir(dir, retention, now, nil)
+	if err != nil || retention <= 0 {
+		return removed, err
+	}
+
+	shards, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return removed, nil
+	}
+	if err != nil {
+		return removed, fmt.Errorf("failed to read spool directory %s: %w", dir, err)
+	}
+	for _, shard := range shards {
+		if !shard.IsDir() {
+			continue
+		}
+		names, err := reapDir(filepath.Join(dir, shard.Name()), retention, now, nil)
+		removed = append(removed, names...)
+		if err != nil {
+			return removed, err
+		}
+	}
+	return removed, nil
+}
+
+// reapDir deletes regular files in dir whose mtime is older than retention,
+// except those keep reports true for; keep may be nil. A missing dir has
+// nothing to reap. Returns the names of the files removed.
+func reapDir(dir string, retention time.Duration, now time.Time, keep func(name string) bool) ([]string, error) {
+	if retention <= 0 {
+		return nil, nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spool directory %s: %w", dir, err)
+	}
+
+	cutoff := now.Add(-retention)
+	var removed []string
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue // removed concurrently
+		}
+		if !info.ModTime().Before(cutoff) {
+			continue
+		}
+		if keep != nil && keep(entry.Name()) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil && !os.IsNotExist(err) {
+			return removed, fmt.Errorf("failed to remove %s: %w", entry.Name(), err)
+		}
+		removed = append(removed, entry.Name())
+	}
+	return removed, nil
+}
@@ -0,0 +1,147 @@
+package queue
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pawciobiel/golubsmtpd/internal/delivery"
+)
+
+// writeSpoolFile creates a spool file in state with the given age
+func writeSpoolFile(t *testing.T, spoolDir string, state MessageState, name string, age time.Duration) {
+	t.Helper()
+	path := filepath.Join(spoolDir, string(state), name)
+	if err := os.WriteFile(path, []byte("Subject: test\r\n\r\nbody\r\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write spool file: %v", err)
+	}
+	mtime := time.Now().Add(-age)
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatalf("Failed to set mtime: %v", err)
+	}
+}
+
+// listSpoolFiles returns the sorted file names in a spool state directory
+func listSpoolFiles(t *testing.T, spoolDir string, state MessageState) []string {
+	t.Helper()
+	entries, err := os.ReadDir(filepath.Join(spoolDir, string(state)))
+	if err != nil {
+		t.Fatalf("Failed to read spool dir: %v", err)
+	}
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestQueue_ReapExpired(t *testing.T) {
+	cfg := createQueueTestConfig()
+	cfg.Server.SpoolDir = t.TempDir()
+	cfg.Queue.DeliveredRetention = 24 * time.Hour
+	cfg.Queue.FailedRetention = 0 // keep forever
+	if err := InitializeSpoolDirectories(cfg.Server.SpoolDir); err != nil {
+		t.Fatalf("Failed to initialize spool: %v", err)
+	}
+	spoolDir := cfg.Server.SpoolDir
+
+	writeSpoolFile(t, spoolDir, MessageStateDelivered, "old.eml", 48*time.Hour)
+	writeSpoolFile(t, spoolDir, MessageStateDelivered, "new.eml", time.Hour)
+	writeSpoolFile(t, spoolDir, MessageStateFailed, "old-failed.eml", 365*24*time.Hour)
+	writeSpoolFile(t, spoolDir, MessageStateIncoming, "old-incoming.eml", 48*time.Hour)
+
+	q := mustNewQueue(t, context.Background(), cfg)
+	q.reapExpired(time.Now())
+
+	if diff := cmp.Diff([]string{"new.eml"}, listSpoolFiles(t, spoolDir, MessageStateDelivered)); diff != "" {
+		t.Errorf("delivered/ mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"old-failed.eml"}, listSpoolFiles(t, spoolDir, MessageStateFailed)); diff != "" {
+		t.Errorf("failed/ should be kept with zero retention (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"old-incoming.eml"}, listSpoolFiles(t, spoolDir, MessageStateIncoming)); diff != "" {
+		t.Errorf("incoming/ must never be reaped (-want +got):\n%s", diff)
+	}
+}
+
+func TestQueue_ReapExpiredRetryState(t *testing.T) {
+	cfg := createQueueTestConfig()
+	cfg.Server.SpoolDir = t.TempDir()
+	cfg.Queue.FailedRetention = 24 * time.Hour
+	if err := InitializeSpoolDirectories(cfg.Server.SpoolDir); err != nil {
+		t.Fatalf("Failed to initialize spool: %v", err)
+	}
+	spool := NewSpool(cfg)
+	q := mustNewQueue(t, context.Background(), cfg)
+
+	old := &Message{ID: GenerateID(), Created: time.Now().Add(-48 * time.Hour).UTC()}
+	recent := &Message{ID: GenerateID(), Created: time.Now().UTC()}
+	writeSpoolFile(t, spool.Dir, MessageStateFailed, old.Filename(), 48*time.Hour)
+	writeSpoolFile(t, spool.Dir, MessageStateFailed, recent.Filename(), time.Hour)
+	for _, msg := range []*Message{old, recent} {
+		state := delivery.NewRetryState(msg.ID, "sender@example.com", time.Minute, []string{"bob@remote.example"})
+		if err := delivery.SaveRetryState(spool, state); err != nil {
+			t.Fatalf("SaveRetryState failed: %v", err)
+		}
+	}
+
+	q.reapExpired(time.Now())
+
+	if _, err := os.Stat(delivery.RetryStatePath(spool, old.ID)); !os.IsNotExist(err) {
+		t.Errorf("Expected retry state of the reaped message removed, got %v", err)
+	}
+	if _, err := os.Stat(delivery.RetryStatePath(spool, recent.ID)); err != nil {
+		t.Errorf("Expected retry state of the kept message to remain: %v", err)
+	}
+}
+
+func TestReapSpoolState_FailedRetention(t *testing.T) {
+	spoolDir := t.TempDir()
+	if err := InitializeSpoolDirectories(spoolDir); err != nil {
+		t.Fatalf("Failed to initialize spool: %v", err)
+	}
+
+	writeSpoolFile(t, spoolDir, MessageStateFailed, "a.eml", 10*24*time.Hour)
+	writeSpoolFile(t, spoolDir, MessageStateFailed, "b.eml", 8*24*time.Hour)
+	writeSpoolFile(t, spoolDir, MessageStateFailed, "c.eml", 24*time.Hour)
+
+	removed, err := reapSpoolState(spoolDir, MessageStateFailed, 7*24*time.Hour, time.Now())
+	if err != nil {
+		t.Fatalf("reapSpoolState failed: %v", err)
+	}
+	if len(removed) != 2 {
+		t.Errorf("removed: want 2, got %d", len(removed))
+	}
+	if diff := cmp.Diff([]string{"c.eml"}, listSpoolFiles(t, spoolDir, MessageStateFailed)); diff != "" {
+		t.Errorf("failed/ mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestReapSpoolState_Shards(t *testing.T) {
+	spoolDir := t.TempDir()
+	if err := InitializeSpoolDirectories(spoolDir); err != nil {
+		t.Fatalf("Failed to initialize spool: %v", err)
+	}
+	shardDir := filepath.Join(spoolDir, string(MessageStateDelivered), "ab")
+	if err := os.Mkdir(shardDir, 0o700); err != nil {
+		t.Fatalf("Failed to create shard: %v", err)
+	}
+	writeSpoolFile(t, spoolDir, MessageStateDelivered, "ab/old.eml", 48*time.Hour)
+	writeSpoolFile(t, spoolDir, MessageStateDelivered, "ab/new.eml", time.Hour)
+
+	removed, err := reapSpoolState(spoolDir, MessageStateDelivered, 24*time.Hour, time.Now())
+	if err != nil {
+		t.Fatalf("reapSpoolState failed: %v", err)
+	}
+	if len(removed) != 1 {
+		t.Errorf("removed = %d, want 1", len(removed))
+	}
+	if _, err := os.Stat(filepath.Join(shardDir, "new.eml")); err != nil {
+		t.Errorf("recent file in shard was removed: %v", err)
+	}
+}
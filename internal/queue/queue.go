@@ -4,7 +4,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"os"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pawciobiel/golubsmtpd/internal/config"
@@ -22,15 +26,39 @@ var (
 type Queue struct {
 	messageQueue chan *Message
 	config       *config.Config
-	dkimSigner   *delivery.DKIMSigner // nil when DKIM is disabled
-	sem          chan struct{}         // Limits concurrent processors
+	spool        Spool                  // spool layout and directory syncing from server config
+	dkimSigner   *delivery.DKIMSigner   // nil when DKIM is disabled
+	transports   *delivery.TransportMap // nil when transport_maps is empty
+	sem          chan struct{}          // Limits concurrent processors
 	processorWg  sync.WaitGroup
 	consumerDone chan struct{} // Signals when consumer loop exits
 
+	// process handles one message; processMessage except in tests
+	process func(ctx context.Context, msg *Message)
+
 	// Publisher coordination
 	publisherCtx    context.Context
 	publisherCancel context.CancelFunc // Function stored as struct field
 	publisherWg     sync.WaitGroup     // Track active publishers
+
+	// Counters reported by Stats
+	published atomic.Int64
+	delivered atomic.Int64
+	failed    atomic.Int64
+
+	// Backpressure counters reported by Backpressure
+	semWaits        atomic.Int64 // consumer found every slot busy
+	semWaitNanos    atomic.Int64 // total time spent waiting for a slot
+	publishRetries  atomic.Int64 // publishes that found the queue full
+	publishRejected atomic.Int64 // publishes that gave up with ErrQueueFull
+}
+
+// BackpressureStats shows how often producers and the consumer had to wait
+type BackpressureStats struct {
+	SemaphoreWaits    int64
+	SemaphoreWaitTime time.Duration
+	PublishRetries    int64
+	PublishRejected   int64
 }
 
 func NewQueue(ctx context.Context, config *config.Config) (*Queue, error) {
@@ -39,12 +67,14 @@ func NewQueue(ctx context.Context, config *config.Config) (*Queue, error) {
 	q := &Queue{
 		messageQueue:    make(chan *Message, config.Queue.BufferSize),
 		config:          config,
+		spool:           NewSpool(config),
 		sem:             make(chan struct{}, config.Queue.MaxConsumers),
 		processorWg:     sync.WaitGroup{},
 		consumerDone:    make(chan struct{}),
 		publisherCtx:    publisherCtx,
 		publisherCancel: cancel, // Store the cancel function
 	}
+	q.process = q.processMessage
 
 	if config.Delivery.Outbound.DKIM.Enabled {
 		signer, err := delivery.NewDKIMSigner(&config.Delivery.Outbound.DKIM)
@@ -55,12 +85,63 @@ func NewQueue(ctx context.Context, config *config.Config) (*Queue, error) {
 		q.dkimSigner = signer
 	}
 
+	transports, err := delivery.NewTransportMap(config.Delivery.TransportMaps)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("queue: init transport maps: %w", err)
+	}
+	q.transports = transports
+
 	return q, nil
 }
 
+// Stats returns the number of messages waiting in the queue, the number currently
+// being processed, and cumulative published/delivered/failed message counts
+func (q *Queue) Stats() (depth int, inFlight int, published int64, delivered int64, failed int64) {
+	return len(q.messageQueue), len(q.sem), q.published.Load(), q.delivered.Load(), q.failed.Load()
+}
+
+// Backpressure returns the cumulative wait and rejection counters
+func (q *Queue) Backpressure() BackpressureStats {
+	return BackpressureStats{
+		SemaphoreWaits:    q.semWaits.Load(),
+		SemaphoreWaitTime: time.Duration(q.semWaitNanos.Load()),
+		PublishRetries:    q.publishRetries.Load(),
+		PublishRejected:   q.publishRejected.Load(),
+	}
+}
+
+// logStats periodically logs queue stats until ctx is cancelled or the consumer exits
+func (q *Queue) logStats(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			depth, inFlight, published, delivered, failed := q.Stats()
+			bp := q.Backpressure()
+			log().Info("Queue stats", "depth", depth, "in_flight", inFlight,
+				"published", published, "delivered", delivered, "failed", failed,
+				"sem_waits", bp.SemaphoreWaits, "sem_wait_time", bp.SemaphoreWaitTime,
+				"publish_retries", bp.PublishRetries, "publish_rejected", bp.PublishRejected)
+		case <-q.consumerDone:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
 // StartConsumer starts the consumer loop in a goroutine (non-blocking)
 func (q *Queue) StartConsumer(ctx context.Context) {
 	log().Debug("Starting message queue consumers")
+	if interval := q.config.Queue.StatsInterval; interval > 0 {
+		go q.logStats(ctx, interval)
+	}
+	if interval := q.config.Queue.JanitorInterval; interval > 0 {
+		go q.runJanitor(ctx, interval)
+	}
 	go func() {
 		defer close(q.consumerDone) // Signal when consumer loop exits
 		log().Debug("Consumer loop started")
@@ -69,17 +150,11 @@ func (q *Queue) StartConsumer(ctx context.Context) {
 			case msg, ok := <-q.messageQueue:
 				if !ok {
 					// Channel closed, exit consumer loop
-				log().Debug("Channel closed, exit consumer loop")
+					log().Debug("Channel closed, exit consumer loop")
 					return
 				}
 
-				log().Debug("Message received, acquiring semaphore", "message_id", msg.ID)
-				// Try to acquire semaphore - this will block if at capacity
-				q.sem <- struct{}{} // Acquire semaphore BEFORE spawning goroutine
-				q.processorWg.Go(func() {
-					defer func() { <-q.sem }() // Release semaphore
-					q.processMessage(ctx, msg)
-				})
+				q.dispatch(ctx, msg)
 
 			case <-ctx.Done():
 				// Context cancelled, exit consumer loop
@@ -90,6 +165,27 @@ func (q *Queue) StartConsumer(ctx context.Context) {
 	}()
 }
 
+// dispatch processes msg in its own consumer slot, blocking until a slot is
+// free
+func (q *Queue) dispatch(ctx context.Context, msg *Message) {
+	log().Debug("Message received, acquiring semaphore", "message_id", msg.ID)
+
+	// Acquire semaphore BEFORE spawning goroutine, counting waits as backpressure
+	select {
+	case q.sem <- struct{}{}:
+	default:
+		start := time.Now()
+		q.sem <- struct{}{}
+		q.semWaits.Add(1)
+		q.semWaitNanos.Add(int64(time.Since(start)))
+	}
+
+	q.processorWg.Go(func() {
+		defer func() { <-q.sem }() // Release semaphore
+		q.process(ctx, msg)
+	})
+}
+
 // PublishMessage tracks publishers and uses publisher context
 func (q *Queue) PublishMessage(ctx context.Context, msg *Message) error {
 	q.publisherWg.Add(1)
@@ -106,6 +202,7 @@ func (q *Queue) PublishMessage(ctx context.Context, msg *Message) error {
 	// Try immediate publish first
 	select {
 	case q.messageQueue <- msg:
+		q.published.Add(1)
 		log().Debug("Message published", "message_id", msg.ID)
 		return nil
 	case <-q.publisherCtx.Done():
@@ -130,12 +227,14 @@ func (q *Queue) PublishMessage(ctx context.Context, msg *Message) error {
 	}
 	startTime := time.Now()
 
+	q.publishRetries.Add(1)
 	for {
 		log().Warn("Queue full, retrying", "message_id", msg.ID, "retry_delay", retryDelay, "elapsed", time.Since(startTime))
 
 		// Check if we've exceeded total timeout
 		if time.Since(startTime) >= totalTimeout {
 			log().Error("Queue full timeout exceeded, rejecting message", "message_id", msg.ID, "total_wait", time.Since(startTime))
+			q.publishRejected.Add(1)
 			return ErrQueueFull
 		}
 
@@ -144,6 +243,7 @@ func (q *Queue) PublishMessage(ctx context.Context, msg *Message) error {
 		// Try to publish again
 		select {
 		case q.messageQueue <- msg:
+			q.published.Add(1)
 			log().Info("Message published after retry", "message_id", msg.ID, "total_wait", time.Since(startTime))
 			return nil
 		case <-q.publisherCtx.Done():
@@ -214,43 +314,89 @@ func (q *Queue) Stop(ctx context.Context) error {
 func (q *Queue) processMessage(ctx context.Context, msg *Message) {
 	log().Debug("Processing message", "message_id", msg.ID)
 
-	spoolDir := q.config.Server.SpoolDir
-	if err := MoveMessage(spoolDir, msg, MessageStateIncoming, MessageStateProcessing); err != nil {
+	if err := MoveMessage(q.spool, msg, MessageStateIncoming, MessageStateProcessing); err != nil {
 		log().Error("Failed to move message to processing", "message_id", msg.ID, "error", err)
+		q.failed.Add(1)
 		return
 	}
 
-	messagePath := GetMessagePath(spoolDir, msg, MessageStateProcessing)
+	messagePath := GetMessagePath(q.spool, msg, MessageStateProcessing)
+
+	// Completion markers let a re-processed message skip recipients already delivered
+	markers, err := delivery.LoadDeliveryMarkers(q.spool.Dir, msg.ID)
+	if err != nil {
+		log().Warn("Failed to load delivery markers, re-processing may duplicate deliveries",
+			"message_id", msg.ID, "error", err)
+		markers = nil
+	}
+
+	routes := q.routeRecipients(msg)
+
+	// Per-recipient status is kept beside the message and follows it to its final
+	// state; a re-injected message brings the status of earlier attempts along
+	processingDir := q.spool.MessageDir(MessageStateProcessing, msg.ID)
+	if err := delivery.MoveDeliveryStatus(q.spool.MessageDir(MessageStateIncoming, msg.ID), processingDir, msg.ID); err != nil {
+		log().Warn("Failed to carry delivery status into processing", "message_id", msg.ID, "error", err)
+	}
+	status, err := delivery.NewDeliveryStatus(processingDir, msg.ID,
+		mergeRecipients(msg.LocalRecipients, msg.VirtualRecipients, msg.RelayRecipients, msg.ExternalRecipients))
+	if err != nil {
+		log().Warn("Failed to create delivery status, per-recipient state will not be recorded",
+			"message_id", msg.ID, "error", err)
+		status = nil
+	}
 
 	// Collect one result per active delivery type
-	outboundRecipients := mergeRecipients(msg.RelayRecipients, msg.ExternalRecipients)
-	deliveryTypes := countNonEmpty(msg.LocalRecipients, msg.VirtualRecipients, outboundRecipients)
+	deliveryTypes := countNonEmpty(routes.local, routes.virtual, routes.commands)
+	if routes.hasOutbound() {
+		deliveryTypes++
+	}
 	resultChan := make(chan delivery.DeliveryResult, deliveryTypes)
 
-	if len(msg.LocalRecipients) > 0 {
+	// .forward destinations per local recipient, re-enqueued once delivery completes
+	var forwardsMu sync.Mutex
+	forwards := make(map[string][]string)
+
+	if len(routes.local) > 0 {
+		go func() {
+			maxWorkers := delivery.GetMaxWorkers(q.config.Delivery.Local.MaxWorkers, len(routes.local))
+			resultChan <- delivery.DeliverWithWorkers(ctx, routes.local, maxWorkers, delivery.RecipientLocal, markers, status,
+				func(ctx context.Context, recipient string) error {
+					dests, err := delivery.DeliverToLocalUser(ctx, msg, messagePath, recipient, &q.config.Delivery.Local)
+					if len(dests) > 0 {
+						forwardsMu.Lock()
+						forwards[recipient] = dests
+						forwardsMu.Unlock()
+					}
+					return err
+				})
+		}()
+	}
+
+	if len(routes.virtual) > 0 {
 		go func() {
-			maxWorkers := delivery.GetMaxWorkers(q.config.Delivery.Local.MaxWorkers, len(msg.LocalRecipients))
-			resultChan <- delivery.DeliverWithWorkers(ctx, msg.LocalRecipients, maxWorkers, delivery.RecipientLocal,
+			// Each virtual domain gets its own worker pool so one slow mailbox store cannot starve the rest
+			resultChan <- delivery.DeliverByDomainWithWorkers(ctx, routes.virtual, q.config.Delivery.Virtual.WorkersPerDomain(), delivery.RecipientVirtual, markers, status,
 				func(ctx context.Context, recipient string) error {
-					return delivery.DeliverToLocalUser(ctx, msg, messagePath, recipient, &q.config.Delivery.Local)
+					return delivery.DeliverToVirtualUser(ctx, msg, messagePath, recipient, q.virtualConfigFor(recipient))
 				})
 		}()
 	}
 
-	if len(msg.VirtualRecipients) > 0 {
+	if len(routes.commands) > 0 {
 		go func() {
-			maxWorkers := delivery.GetMaxWorkers(q.config.Delivery.Virtual.MaxWorkers, len(msg.VirtualRecipients))
-			resultChan <- delivery.DeliverWithWorkers(ctx, msg.VirtualRecipients, maxWorkers, delivery.RecipientVirtual,
+			maxWorkers := delivery.GetMaxWorkers(q.config.Delivery.Local.MaxWorkers, len(routes.commands))
+			resultChan <- delivery.DeliverWithWorkers(ctx, routes.commands, maxWorkers, delivery.RecipientLocal, markers, status,
 				func(ctx context.Context, recipient string) error {
-					return delivery.DeliverToVirtualUser(ctx, msg, messagePath, recipient, q.config.Delivery.Virtual.BaseDirPath)
+					transport, _ := q.transports.Lookup(recipient)
+					return delivery.DeliverToTransportCommand(ctx, msg, messagePath, recipient, transport.Arg, &q.config.Delivery.Local)
 				})
 		}()
 	}
 
-	if len(outboundRecipients) > 0 {
+	if routes.hasOutbound() {
 		go func() {
-			maxWorkers := delivery.GetMaxWorkers(q.config.Delivery.Outbound.MaxWorkers, len(outboundRecipients))
-			resultChan <- delivery.DeliverOutboundWithWorkers(ctx, outboundRecipients, maxWorkers, msg, messagePath, &q.config.Delivery.Outbound, q.dkimSigner)
+			resultChan <- q.deliverOutbound(ctx, msg, messagePath, &routes)
 		}()
 	}
 
@@ -258,6 +404,11 @@ func (q *Queue) processMessage(ctx context.Context, msg *Message) {
 	totalSuccessful := 0
 	totalFailed := 0
 	var bounces []*Message
+	// Outbound outcomes and deferred recipients of every type share one retry state
+	retry := delivery.DeliveryResult{}
+	// Successful recipients whose NOTIFY=SUCCESS falls to us: stored here, or
+	// relayed to a server that was not given the DSN parameters
+	var delivered, relayed []string
 
 	for i := 0; i < deliveryTypes; i++ {
 		result := <-resultChan
@@ -274,21 +425,65 @@ func (q *Queue) processMessage(ctx context.Context, msg *Message) {
 				"count", len(result.Failed), "recipients", result.Failed)
 		}
 
-		// Handle retry state and bounce generation for outbound results
+		if len(result.TempFailed) > 0 {
+			log().Warn("Delivery deferred", "message_id", msg.ID, "type", result.Type,
+				"count", len(result.TempFailed), "recipients", result.TempFailed)
+		}
+
 		if result.Type == delivery.RecipientExternal || result.Type == delivery.RecipientRelay {
-			generated := delivery.HandleOutboundResult(
-				result, msg, spoolDir,
-				q.config.Server.Hostname,
-				q.config.Delivery.Outbound.RetryInterval,
-				q.config.Delivery.Outbound.RetryMaxAge,
-			)
-			bounces = append(bounces, generated...)
+			if err := status.RecordResult(result); err != nil {
+				log().Error("Failed to record outbound delivery status", "message_id", msg.ID, "error", err)
+			}
+			retry.Successful = append(retry.Successful, result.Successful...)
+			retry.PermFailed = append(retry.PermFailed, result.PermFailed...)
+			for _, recipient := range result.Successful {
+				if !slices.Contains(result.DSNPassed, recipient) {
+					relayed = append(relayed, recipient)
+				}
+			}
+		} else {
+			delivered = append(delivered, result.Successful...)
+		}
+		retry.TempFailed = append(retry.TempFailed, result.TempFailed...)
+	}
+
+	// Handle retry state and bounce generation
+	bounces = append(bounces, delivery.HandleOutboundResult(
+		retry, msg, q.spool, messagePath,
+		q.config.Server.AdvertisedHostname(),
+		q.config.Delivery.Outbound.RetryInterval,
+		q.config.Delivery.Outbound.RetryMaxAge,
+	)...)
+	bounces = append(bounces, delivery.SuccessNotifications(msg, delivered, relayed,
+		q.config.Server.AdvertisedHostname(), messagePath)...)
+
+	// Re-enqueue forwarded copies, each marked with the forwarding recipient
+	for recipient, dests := range forwards {
+		forwarded, err := q.newForwardMessage(msg, messagePath, recipient, dests)
+		if err != nil {
+			log().Error("Failed to build forwarded message", "message_id", msg.ID, "recipient", recipient, "error", err)
+			totalFailed++
+			continue
+		}
+		if err := WriteRawBody(q.spool, forwarded); err != nil {
+			log().Error("Failed to write forwarded message to spool", "message_id", msg.ID, "error", err)
+			totalFailed++
+			continue
+		}
+		if err := q.PublishMessage(ctx, forwarded); err != nil {
+			log().Error("Failed to publish forwarded message", "message_id", msg.ID, "error", err)
+		} else {
+			log().Info("Forwarded message injected", "original_id", msg.ID, "forward_id", forwarded.ID,
+				"recipient", recipient, "destinations", dests)
 		}
 	}
 
-	// Inject any DSN bounces back into the queue for local delivery
+	// Inject any DSN bounces back into the queue, routed to the original
+	// sender like any other recipient: local senders get them here, remote
+	// ones through outbound delivery
 	for _, bounce := range bounces {
-		if err := WriteRawBody(spoolDir, bounce); err != nil {
+		q.recipientsFor(bounce, msg.From).Add(msg.From)
+		if err := WriteRawBody(q.spool, bounce); err != nil {
 			log().Error("Failed to write DSN to spool", "original_id", msg.ID, "error", err)
 			continue
 		}
@@ -302,24 +497,86 @@ func (q *Queue) processMessage(ctx context.Context, msg *Message) {
 	var finalState MessageState
 	if totalFailed == 0 {
 		finalState = MessageStateDelivered
+		q.delivered.Add(1)
+		// Failed messages keep their markers until the janitor reaps them
+		if err := markers.Remove(); err != nil {
+			log().Warn("Failed to remove delivery markers", "message_id", msg.ID, "error", err)
+		}
 		log().Info("Message delivery completed successfully", "message_id", msg.ID,
 			"successful_count", totalSuccessful)
 	} else {
 		finalState = MessageStateFailed
+		q.failed.Add(1)
 		log().Error("Message delivery failed", "message_id", msg.ID,
 			"successful_count", totalSuccessful, "failed_count", totalFailed)
 	}
 
-	if err := MoveMessage(spoolDir, msg, MessageStateProcessing, finalState); err != nil {
-		log().Error("Failed to move message to final state", "message_id", msg.ID,
-			"final_state", finalState, "error", err)
+	if finalState == MessageStateFailed && q.config.Queue.HoldFailed {
+		if err := q.holdMessage(msg, MessageStateProcessing); err != nil {
+			log().Error("Failed to hold message, moving to failed", "message_id", msg.ID, "error", err)
+		} else {
+			finalState = MessageStateHold
+			log().Info("Message held for review", "message_id", msg.ID)
+		}
+	}
+
+	if finalState != MessageStateHold {
+		if err := MoveMessage(q.spool, msg, MessageStateProcessing, finalState); err != nil {
+			log().Error("Failed to move message to final state", "message_id", msg.ID,
+				"final_state", finalState, "error", err)
+		}
+	}
+
+	if err := status.MoveTo(q.spool.MessageDir(finalState, msg.ID)); err != nil {
+		log().Error("Failed to move delivery status", "message_id", msg.ID, "final_state", finalState, "error", err)
 	}
 
 	log().Debug("Message processing completed", "message_id", msg.ID, "final_state", finalState)
 }
 
-// mergeRecipients merges multiple recipient maps into one without allocating if both empty.
-func mergeRecipients(maps ...map[string]struct{}) map[string]struct{} {
+// newForwardMessage builds a copy of msg addressed to .forward destinations,
+// prefixed with a Delivered-To header so forwarding loops can be detected
+func (q *Queue) newForwardMessage(msg *Message, messagePath, recipient string, destinations []string) (*Message, error) {
+	content, err := os.ReadFile(messagePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read message %s: %w", messagePath, err)
+	}
+
+	forwarded := &Message{
+		ID:                 GenerateID(),
+		From:               msg.From,
+		ClientIP:           msg.ClientIP,
+		LocalRecipients:    NewRecipientSet(),
+		VirtualRecipients:  NewRecipientSet(),
+		RelayRecipients:    NewRecipientSet(),
+		ExternalRecipients: NewRecipientSet(),
+		Created:            time.Now().UTC(),
+		RawBody:            fmt.Sprintf("%s: %s\r\n%s", delivery.DeliveredToHeader, recipient, content),
+	}
+	for _, dest := range destinations {
+		q.recipientsFor(forwarded, dest).Add(dest)
+	}
+	return forwarded, nil
+}
+
+// recipientsFor returns the recipient set of msg matching the domain of addr
+func (q *Queue) recipientsFor(msg *Message, addr string) RecipientSet {
+	_, domain, _ := strings.Cut(addr, "@")
+	matches := func(d string) bool { return config.DomainMatches(d, domain) }
+	switch {
+	case slices.ContainsFunc(q.config.Server.LocalDomains, matches), strings.EqualFold(domain, "localhost"):
+		return msg.LocalRecipients
+	case slices.ContainsFunc(q.config.Server.VirtualDomains, matches):
+		return msg.VirtualRecipients
+	case slices.ContainsFunc(q.config.Server.RelayDomains, matches):
+		return msg.RelayRecipients
+	default:
+		return msg.ExternalRecipients
+	}
+}
+
+// mergeRecipients merges the addresses of recipient sets into one without allocating if all are empty.
+func mergeRecipients(maps ...RecipientSet) map[string]struct{} {
 	total := 0
 	for _, m := range maps {
 		total += len(m)
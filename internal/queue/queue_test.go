@@ -2,14 +2,19 @@ package queue
 
 import (
 	"context"
+	"errors"
 	"os"
+	"os/user"
+	"path/filepath"
 	"sync"
 	"testing"
 	"testing/synctest"
 	"time"
 
 	"github.com/pawciobiel/golubsmtpd/internal/config"
+	"github.com/pawciobiel/golubsmtpd/internal/delivery"
 	"github.com/pawciobiel/golubsmtpd/internal/logging"
+	"github.com/pawciobiel/golubsmtpd/internal/types"
 )
 
 func TestMain(m *testing.M) {
@@ -42,13 +47,11 @@ func mustNewQueue(t *testing.T, ctx context.Context, cfg *config.Config) *Queue
 
 func createTestMessage() *Message {
 	return &Message{
-		ID:      GenerateID(),
-		Created: time.Now().UTC(),
-		From:    "test@example.com",
-		LocalRecipients: map[string]struct{}{
-			"user@localhost": {},
-		},
-		TotalSize: 100,
+		ID:              GenerateID(),
+		Created:         time.Now().UTC(),
+		From:            "test@example.com",
+		LocalRecipients: NewRecipientSet("user@localhost"),
+		TotalSize:       100,
 	}
 }
 
@@ -196,27 +199,46 @@ func TestQueue_SemaphoreLimit(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	// Queue with buffer=10 but only 1 consumer (semaphore limit)
-	cfg := &config.Config{Queue: config.QueueConfig{BufferSize: 10, MaxConsumers: 1}}
+	// Queue with buffer=10 but only 2 consumers (semaphore limit)
+	cfg := &config.Config{Queue: config.QueueConfig{BufferSize: 10, MaxConsumers: 2}}
 	queue := mustNewQueue(t, ctx, cfg)
 
-	queue.StartConsumer(ctx)
-	defer queue.Stop(ctx)
-
-	time.Sleep(10 * time.Millisecond)
+	var mu sync.Mutex
+	running, maxRunning, processed := 0, 0, 0
+	queue.process = func(ctx context.Context, msg *Message) {
+		mu.Lock()
+		running++
+		maxRunning = max(maxRunning, running)
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		processed++
+		mu.Unlock()
+	}
 
-	// Publish multiple messages quickly
-	for i := 0; i < 5; i++ {
-		msg := createTestMessage()
-		err := queue.PublishMessage(ctx, msg)
-		if err != nil {
-			t.Errorf("Failed to publish message %d: %v", i, err)
+	for i := 0; i < 8; i++ {
+		if err := queue.PublishMessage(ctx, createTestMessage()); err != nil {
+			t.Fatalf("Failed to publish message %d: %v", i, err)
 		}
 	}
 
-	// With semaphore=1, only 1 message should be processed at a time
-	// This is hard to test deterministically, but we can verify no errors occur
-	time.Sleep(100 * time.Millisecond)
+	queue.StartConsumer(ctx)
+	if err := queue.Stop(ctx); err != nil {
+		t.Fatalf("Queue stop failed: %v", err)
+	}
+
+	if processed != 8 {
+		t.Errorf("Expected 8 processed messages, got %d", processed)
+	}
+	if maxRunning != 2 {
+		t.Errorf("Expected at most MaxConsumers=2 concurrent processMessage calls, got %d", maxRunning)
+	}
+	if bp := queue.Backpressure(); bp.SemaphoreWaits == 0 {
+		t.Errorf("Expected semaphore waits to be counted, got %+v", bp)
+	}
 }
 
 func TestQueue_GracefulShutdown(t *testing.T) {
@@ -295,3 +317,502 @@ func TestNewQueue(t *testing.T) {
 		t.Errorf("Semaphore size wrong. Expected: 3, Got: %d", cap(queue.sem))
 	}
 }
+
+func TestQueue_Stats(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cfg := createQueueTestConfig()
+	cfg.Server.SpoolDir = t.TempDir()
+	if err := InitializeSpoolDirectories(cfg.Server.SpoolDir); err != nil {
+		t.Fatalf("Failed to initialize spool: %v", err)
+	}
+	queue := mustNewQueue(t, ctx, cfg)
+
+	// Two spooled messages without recipients complete successfully;
+	// one message missing from the spool fails to move to processing
+	var msgs []*Message
+	for i := 0; i < 2; i++ {
+		msg := &Message{ID: GenerateID(), Created: time.Now().UTC(), RawBody: "Subject: test\r\n\r\nbody\r\n"}
+		if err := WriteRawBody(NewSpool(cfg), msg); err != nil {
+			t.Fatalf("Failed to spool message: %v", err)
+		}
+		msgs = append(msgs, msg)
+	}
+	msgs = append(msgs, createTestMessage())
+
+	for _, msg := range msgs {
+		if err := queue.PublishMessage(ctx, msg); err != nil {
+			t.Fatalf("Failed to publish message: %v", err)
+		}
+	}
+
+	depth, inFlight, published, delivered, failed := queue.Stats()
+	if depth != 3 || inFlight != 0 || published != 3 || delivered != 0 || failed != 0 {
+		t.Errorf("Before consuming: got depth=%d in_flight=%d published=%d delivered=%d failed=%d",
+			depth, inFlight, published, delivered, failed)
+	}
+
+	queue.StartConsumer(ctx)
+	if err := queue.Stop(ctx); err != nil {
+		t.Fatalf("Queue stop failed: %v", err)
+	}
+
+	depth, inFlight, published, delivered, failed = queue.Stats()
+	if depth != 0 || inFlight != 0 || published != 3 || delivered != 2 || failed != 1 {
+		t.Errorf("After consuming: got depth=%d in_flight=%d published=%d delivered=%d failed=%d",
+			depth, inFlight, published, delivered, failed)
+	}
+}
+
+// TestQueue_RelayRecipientNotLost verifies that relay recipients are dispatched to
+// outbound delivery and, when undeliverable, fail the message rather than vanish
+func TestQueue_RelayRecipientNotLost(t *testing.T) {
+	cfg := createQueueTestConfig()
+	cfg.Server.SpoolDir = t.TempDir()
+	cfg.Server.Hostname = "mx.example.com"
+	cfg.Delivery.Outbound.RetryInterval = time.Minute
+	cfg.Delivery.Outbound.RetryMaxAge = time.Hour
+	if err := InitializeSpoolDirectories(cfg.Server.SpoolDir); err != nil {
+		t.Fatalf("Failed to initialize spool: %v", err)
+	}
+	queue := mustNewQueue(t, context.Background(), cfg)
+
+	msg := &Message{
+		ID:              GenerateID(),
+		Created:         time.Now().UTC(),
+		From:            "sender@example.com",
+		RelayRecipients: NewRecipientSet("user@relay.invalid"),
+		RawBody:         "Subject: relay\r\n\r\nbody\r\n",
+	}
+	if err := WriteRawBody(NewSpool(cfg), msg); err != nil {
+		t.Fatalf("Failed to spool message: %v", err)
+	}
+
+	// Short deadline: MX lookup for .invalid must fail (tempfail) without real network delivery
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	queue.processMessage(ctx, msg)
+
+	if _, err := os.Stat(GetMessagePath(NewSpool(cfg), msg, MessageStateFailed)); err != nil {
+		t.Errorf("Expected message in failed state: %v", err)
+	}
+	if _, _, _, delivered, failed := queue.Stats(); delivered != 0 || failed != 1 {
+		t.Errorf("Expected delivered=0 failed=1, got delivered=%d failed=%d", delivered, failed)
+	}
+
+	state, err := delivery.LoadRetryState(NewSpool(cfg), msg.ID)
+	if err != nil {
+		t.Fatalf("Failed to load retry state: %v", err)
+	}
+	if state == nil {
+		t.Fatal("Expected retry state for tempfailed relay recipient, got none")
+	}
+}
+
+// TestQueue_ReprocessSkipsDeliveredRecipients simulates a crash after one
+// recipient of a message was delivered and verifies re-processing the message
+// does not drop a second copy into that recipient's Maildir
+func TestQueue_ReprocessSkipsDeliveredRecipients(t *testing.T) {
+	cfg := createQueueTestConfig()
+	cfg.Server.SpoolDir = t.TempDir()
+	cfg.Delivery.Virtual.BaseDirPath = t.TempDir()
+	if err := InitializeSpoolDirectories(cfg.Server.SpoolDir); err != nil {
+		t.Fatalf("Failed to initialize spool: %v", err)
+	}
+	queue := mustNewQueue(t, context.Background(), cfg)
+
+	msg := &Message{
+		ID:      GenerateID(),
+		Created: time.Now().UTC(),
+		From:    "sender@example.com",
+		VirtualRecipients: NewRecipientSet("alice@example.com", "bob@example.com"),
+		RawBody: "Subject: dedup\r\n\r\nbody\r\n",
+	}
+	if err := WriteRawBody(NewSpool(cfg), msg); err != nil {
+		t.Fatalf("Failed to spool message: %v", err)
+	}
+
+	// First attempt: alice's copy lands and is marked, then the process dies
+	markers, err := delivery.LoadDeliveryMarkers(cfg.Server.SpoolDir, msg.ID)
+	if err != nil {
+		t.Fatalf("LoadDeliveryMarkers failed: %v", err)
+	}
+	messagePath := GetMessagePath(NewSpool(cfg), msg, MessageStateIncoming)
+	if err := delivery.DeliverToVirtualUser(context.Background(), msg, messagePath, "alice@example.com", &cfg.Delivery.Virtual); err != nil {
+		t.Fatalf("DeliverToVirtualUser failed: %v", err)
+	}
+	if err := markers.MarkDelivered("alice@example.com"); err != nil {
+		t.Fatalf("MarkDelivered failed: %v", err)
+	}
+
+	// Recovery re-processes the message from the start
+	queue.processMessage(context.Background(), msg)
+
+	for _, user := range []string{"alice", "bob"} {
+		newDir := delivery.GetVirtualMaildirPath(user+"@example.com", cfg.Delivery.Virtual.BaseDirPath)
+		entries, err := os.ReadDir(newDir)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", newDir, err)
+		}
+		if len(entries) != 1 {
+			t.Errorf("Expected exactly 1 message for %s, got %d", user, len(entries))
+		}
+	}
+
+	if _, err := os.Stat(GetMessagePath(NewSpool(cfg), msg, MessageStateDelivered)); err != nil {
+		t.Errorf("Expected message in delivered state: %v", err)
+	}
+	if _, err := os.Stat(delivery.DeliveryMarkersPath(cfg.Server.SpoolDir, msg.ID)); !os.IsNotExist(err) {
+		t.Errorf("Expected delivery markers removed after completion, got %v", err)
+	}
+}
+
+func TestQueue_DeliveryStatusRecordsMixedOutcome(t *testing.T) {
+	cfg := createQueueTestConfig()
+	cfg.Server.SpoolDir = t.TempDir()
+	cfg.Delivery.Virtual.BaseDirPath = t.TempDir()
+	cfg.Delivery.Outbound.RetryInterval = time.Minute
+	cfg.Delivery.Outbound.RetryMaxAge = time.Hour
+	if err := InitializeSpoolDirectories(cfg.Server.SpoolDir); err != nil {
+		t.Fatalf("Failed to initialize spool: %v", err)
+	}
+	queue := mustNewQueue(t, context.Background(), cfg)
+
+	// A regular file where broken.example's mailboxes should live defers bob's delivery
+	if err := os.WriteFile(filepath.Join(cfg.Delivery.Virtual.BaseDirPath, "broken.example"), nil, 0o600); err != nil {
+		t.Fatalf("Failed to create blocking file: %v", err)
+	}
+
+	msg := &Message{
+		ID:      GenerateID(),
+		Created: time.Now().UTC(),
+		From:    "sender@example.com",
+		VirtualRecipients: NewRecipientSet("alice@example.com", "bob@broken.example"),
+		RawBody: "Subject: status\r\n\r\nbody\r\n",
+	}
+	if err := WriteRawBody(NewSpool(cfg), msg); err != nil {
+		t.Fatalf("Failed to spool message: %v", err)
+	}
+
+	queue.processMessage(context.Background(), msg)
+
+	// The status file follows the message to failed/
+	failedDir := filepath.Join(cfg.Server.SpoolDir, string(MessageStateFailed))
+	status, err := delivery.LoadDeliveryStatus(failedDir, msg.ID)
+	if err != nil || status == nil {
+		t.Fatalf("LoadDeliveryStatus: status=%v err=%v", status, err)
+	}
+	if _, err := os.Stat(delivery.DeliveryStatusPath(filepath.Join(cfg.Server.SpoolDir, string(MessageStateProcessing)), msg.ID)); !os.IsNotExist(err) {
+		t.Errorf("Expected no status file left in processing, got %v", err)
+	}
+
+	want := map[string]string{
+		"alice@example.com":  delivery.RecipientStateDelivered,
+		"bob@broken.example": delivery.RecipientStateDeferred,
+	}
+	for recipient, state := range want {
+		got, ok := status.Recipients[recipient]
+		if !ok {
+			t.Errorf("Recipient %s missing from status", recipient)
+			continue
+		}
+		if got.State != state {
+			t.Errorf("Recipient %s: state %q, want %q", recipient, got.State, state)
+		}
+		if got.Updated.IsZero() {
+			t.Errorf("Recipient %s: missing timestamp", recipient)
+		}
+	}
+	if status.Recipients["bob@broken.example"].Error == "" {
+		t.Error("Deferred recipient should record the delivery error")
+	}
+}
+
+// TestQueue_TransportMapsVirtualBasePath routes one virtual domain to its own
+// base path through transport_maps and leaves another on the default
+func TestQueue_TransportMapsVirtualBasePath(t *testing.T) {
+	cfg := createQueueTestConfig()
+	cfg.Server.SpoolDir = t.TempDir()
+	cfg.Delivery.Virtual.BaseDirPath = t.TempDir()
+	customBase := t.TempDir()
+	cfg.Delivery.TransportMaps = map[string]string{"example.org": "virtual:" + customBase}
+	if err := InitializeSpoolDirectories(cfg.Server.SpoolDir); err != nil {
+		t.Fatalf("Failed to initialize spool: %v", err)
+	}
+	queue := mustNewQueue(t, context.Background(), cfg)
+
+	msg := &Message{
+		ID:      GenerateID(),
+		Created: time.Now().UTC(),
+		From:    "sender@example.com",
+		VirtualRecipients: NewRecipientSet("alice@example.org", "bob@example.com"),
+		RawBody: "Subject: transport\r\n\r\nbody\r\n",
+	}
+	if err := WriteRawBody(NewSpool(cfg), msg); err != nil {
+		t.Fatalf("Failed to spool message: %v", err)
+	}
+
+	queue.processMessage(context.Background(), msg)
+
+	want := map[string]string{
+		"alice@example.org": customBase,
+		"bob@example.com":   cfg.Delivery.Virtual.BaseDirPath,
+	}
+	for recipient, base := range want {
+		newDir := delivery.GetVirtualMaildirPath(recipient, base)
+		entries, err := os.ReadDir(newDir)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", newDir, err)
+		}
+		if len(entries) != 1 {
+			t.Errorf("Expected exactly 1 message for %s under %s, got %d", recipient, base, len(entries))
+		}
+	}
+	if _, err := os.Stat(filepath.Join(cfg.Delivery.Virtual.BaseDirPath, "example.org")); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing for example.org under the default base path, got %v", err)
+	}
+	if _, err := os.Stat(GetMessagePath(NewSpool(cfg), msg, MessageStateDelivered)); err != nil {
+		t.Errorf("Expected message in delivered state: %v", err)
+	}
+}
+
+// TestQueue_TemporaryLocalFailureDeferred defers a command recipient that
+// exits with EX_TEMPFAIL and flushes it back as a local recipient
+func TestQueue_TemporaryLocalFailureDeferred(t *testing.T) {
+	cfg := createQueueTestConfig()
+	cfg.Server.SpoolDir = t.TempDir()
+	cfg.Delivery.Outbound.RetryInterval = time.Minute
+	cfg.Delivery.Outbound.RetryMaxAge = time.Hour
+	cfg.Delivery.TransportMaps = map[string]string{"tickets@localhost": "command:exit 75"}
+	if err := InitializeSpoolDirectories(cfg.Server.SpoolDir); err != nil {
+		t.Fatalf("Failed to initialize spool: %v", err)
+	}
+	queue := mustNewQueue(t, context.Background(), cfg)
+
+	msg := &Message{
+		ID:              GenerateID(),
+		Created:         time.Now().UTC(),
+		From:            "sender@example.com",
+		LocalRecipients: NewRecipientSet("tickets@localhost"),
+		RawBody:         "Subject: tempfail\r\n\r\nbody\r\n",
+	}
+	if err := WriteRawBody(NewSpool(cfg), msg); err != nil {
+		t.Fatalf("Failed to spool message: %v", err)
+	}
+
+	queue.processMessage(context.Background(), msg)
+
+	state, err := delivery.LoadRetryState(NewSpool(cfg), msg.ID)
+	if err != nil || state == nil {
+		t.Fatalf("LoadRetryState: state=%v err=%v", state, err)
+	}
+	if _, ok := state.PendingRecipients()["tickets@localhost"]; !ok {
+		t.Fatalf("Expected tickets@localhost pending retry, got %v", state.Recipients)
+	}
+
+	flushed, err := queue.Flush(context.Background())
+	if err != nil || flushed != 1 {
+		t.Fatalf("Flush: flushed=%d err=%v", flushed, err)
+	}
+	assertRecipientState(t, NewSpool(cfg).MessageDir(MessageStateIncoming, msg.ID), msg.ID,
+		"tickets@localhost", delivery.RecipientStateDeferred)
+	requeued := <-queue.messageQueue
+	if !requeued.LocalRecipients.Contains("tickets@localhost") || len(requeued.ExternalRecipients) != 0 {
+		t.Errorf("Expected the deferred recipient requeued as local, got local=%v external=%v",
+			requeued.LocalRecipients, requeued.ExternalRecipients)
+	}
+}
+
+func TestQueue_MailboxCommandTempFailDeferred(t *testing.T) {
+	currentUser, err := user.Current()
+	if err != nil {
+		t.Skip("Cannot get current user")
+	}
+	recipient := currentUser.Username + "@localhost"
+
+	cfg := createQueueTestConfig()
+	cfg.Server.SpoolDir = t.TempDir()
+	cfg.Delivery.Local.BaseDirPath = t.TempDir()
+	cfg.Delivery.Local.MailboxCommand = "exit 75"
+	cfg.Delivery.Outbound.RetryInterval = time.Minute
+	cfg.Delivery.Outbound.RetryMaxAge = time.Hour
+	if err := InitializeSpoolDirectories(cfg.Server.SpoolDir); err != nil {
+		t.Fatalf("Failed to initialize spool: %v", err)
+	}
+	queue := mustNewQueue(t, context.Background(), cfg)
+
+	msg := &Message{
+		ID:              GenerateID(),
+		Created:         time.Now().UTC(),
+		From:            "sender@example.com",
+		LocalRecipients: NewRecipientSet(recipient),
+		RawBody:         "Subject: tempfail\r\n\r\nbody\r\n",
+	}
+	if err := WriteRawBody(NewSpool(cfg), msg); err != nil {
+		t.Fatalf("Failed to spool message: %v", err)
+	}
+
+	queue.processMessage(context.Background(), msg)
+
+	assertRecipientState(t, NewSpool(cfg).MessageDir(MessageStateFailed, msg.ID), msg.ID,
+		recipient, delivery.RecipientStateDeferred)
+	state, err := delivery.LoadRetryState(NewSpool(cfg), msg.ID)
+	if err != nil || state == nil {
+		t.Fatalf("LoadRetryState: state=%v err=%v", state, err)
+	}
+	if _, ok := state.PendingRecipients()[recipient]; !ok {
+		t.Errorf("Expected %s pending retry, got %v", recipient, state.Recipients)
+	}
+}
+
+func TestQueue_DSNRoutedBySenderDomain(t *testing.T) {
+	tests := []struct {
+		sender       string
+		wantLocal    bool
+		wantExternal bool
+	}{
+		{"sender@example.com", false, true},
+		{"root@localhost", true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.sender, func(t *testing.T) {
+			cfg := createQueueTestConfig()
+			cfg.Server.SpoolDir = t.TempDir()
+			cfg.Delivery.Outbound.RetryInterval = time.Minute
+			cfg.Delivery.Outbound.RetryMaxAge = time.Hour
+			cfg.Delivery.TransportMaps = map[string]string{"tickets@localhost": "command:exit 75"}
+			if err := InitializeSpoolDirectories(cfg.Server.SpoolDir); err != nil {
+				t.Fatalf("Failed to initialize spool: %v", err)
+			}
+			queue := mustNewQueue(t, context.Background(), cfg)
+
+			msg := &Message{
+				ID:              GenerateID(),
+				Created:         time.Now().UTC(),
+				From:            tt.sender,
+				LocalRecipients: NewRecipientSet("tickets@localhost"),
+				RawBody:         "Subject: tempfail\r\n\r\nbody\r\n",
+			}
+			msg.LocalRecipients["tickets@localhost"].DSN = types.DSNParams{Notify: []string{"DELAY"}}
+			if err := WriteRawBody(NewSpool(cfg), msg); err != nil {
+				t.Fatalf("Failed to spool message: %v", err)
+			}
+
+			queue.processMessage(context.Background(), msg)
+
+			select {
+			case dsn := <-queue.messageQueue:
+				if got := dsn.LocalRecipients.Contains(tt.sender); got != tt.wantLocal {
+					t.Errorf("DSN local recipient = %v, want %v", got, tt.wantLocal)
+				}
+				if got := dsn.ExternalRecipients.Contains(tt.sender); got != tt.wantExternal {
+					t.Errorf("DSN external recipient = %v, want %v", got, tt.wantExternal)
+				}
+			default:
+				t.Fatal("Expected a delay DSN to be queued")
+			}
+		})
+	}
+}
+
+func TestQueue_DeliveryStatusKeptAcrossAttempts(t *testing.T) {
+	cfg := createQueueTestConfig()
+	cfg.Server.SpoolDir = t.TempDir()
+	cfg.Delivery.Virtual.BaseDirPath = t.TempDir()
+	if err := InitializeSpoolDirectories(cfg.Server.SpoolDir); err != nil {
+		t.Fatalf("Failed to initialize spool: %v", err)
+	}
+	queue := mustNewQueue(t, context.Background(), cfg)
+
+	msg := &Message{
+		ID:                GenerateID(),
+		Created:           time.Now().UTC(),
+		From:              "sender@example.com",
+		VirtualRecipients: NewRecipientSet("alice@example.com"),
+		RawBody:           "Subject: again\r\n\r\nbody\r\n",
+	}
+	if err := WriteRawBody(NewSpool(cfg), msg); err != nil {
+		t.Fatalf("Failed to spool message: %v", err)
+	}
+
+	// An earlier attempt left its status beside the re-injected message
+	incomingDir := NewSpool(cfg).MessageDir(MessageStateIncoming, msg.ID)
+	earlier, err := delivery.NewDeliveryStatus(incomingDir, msg.ID, map[string]struct{}{"bob@example.com": {}})
+	if err != nil {
+		t.Fatalf("NewDeliveryStatus failed: %v", err)
+	}
+	earlier.Record("bob@example.com", delivery.RecipientStateFailed, errors.New("mailbox full"))
+	if err := earlier.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	queue.processMessage(context.Background(), msg)
+
+	status, err := delivery.LoadDeliveryStatus(NewSpool(cfg).MessageDir(MessageStateDelivered, msg.ID), msg.ID)
+	if err != nil || status == nil {
+		t.Fatalf("LoadDeliveryStatus: status=%v err=%v", status, err)
+	}
+	if got := status.Recipients["alice@example.com"].State; got != delivery.RecipientStateDelivered {
+		t.Errorf("alice: state %q, want %q", got, delivery.RecipientStateDelivered)
+	}
+	if got := status.Recipients["bob@example.com"]; got.State != delivery.RecipientStateFailed || got.Error != "mailbox full" {
+		t.Errorf("bob: earlier outcome should be kept, got %+v", got)
+	}
+	if _, err := os.Stat(delivery.DeliveryStatusPath(incomingDir, msg.ID)); !os.IsNotExist(err) {
+		t.Errorf("Expected no status file left in incoming, got %v", err)
+	}
+}
+
+func TestQueue_ReapExpiredDeliveryMarkers(t *testing.T) {
+	cfg := createQueueTestConfig()
+	cfg.Server.SpoolDir = t.TempDir()
+	cfg.Queue.FailedRetention = time.Hour
+	queue := mustNewQueue(t, context.Background(), cfg)
+
+	markers, err := delivery.LoadDeliveryMarkers(cfg.Server.SpoolDir, "stale")
+	if err != nil {
+		t.Fatalf("LoadDeliveryMarkers failed: %v", err)
+	}
+	if err := markers.MarkDelivered("alice@example.com"); err != nil {
+		t.Fatalf("MarkDelivered failed: %v", err)
+	}
+
+	queue.reapExpired(time.Now().Add(2 * time.Hour))
+
+	if _, err := os.Stat(filepath.Join(delivery.DeliveryMarkersDir(cfg.Server.SpoolDir), "stale.delivered")); !os.IsNotExist(err) {
+		t.Errorf("Expected stale markers reaped, got %v", err)
+	}
+}
+
+func TestQueue_ReapKeepsHeldMessageMarkers(t *testing.T) {
+	cfg := createQueueTestConfig()
+	cfg.Server.SpoolDir = t.TempDir()
+	cfg.Queue.FailedRetention = time.Hour
+	if err := InitializeSpoolDirectories(cfg.Server.SpoolDir); err != nil {
+		t.Fatalf("Failed to initialize spool: %v", err)
+	}
+	queue := mustNewQueue(t, context.Background(), cfg)
+
+	msg := &Message{ID: GenerateID(), VirtualRecipients: NewRecipientSet("alice@example.com"), RawBody: "Subject: held\r\n\r\nbody\r\n"}
+	if err := WriteRawBody(NewSpool(cfg), msg); err != nil {
+		t.Fatalf("Failed to spool message: %v", err)
+	}
+	if err := queue.holdMessage(msg, MessageStateIncoming); err != nil {
+		t.Fatalf("holdMessage failed: %v", err)
+	}
+	markers, err := delivery.LoadDeliveryMarkers(cfg.Server.SpoolDir, msg.ID)
+	if err != nil {
+		t.Fatalf("LoadDeliveryMarkers failed: %v", err)
+	}
+	if err := markers.MarkDelivered("alice@example.com"); err != nil {
+		t.Fatalf("MarkDelivered failed: %v", err)
+	}
+
+	queue.reapExpired(time.Now().Add(2 * time.Hour))
+
+	if _, err := os.Stat(delivery.DeliveryMarkersPath(cfg.Server.SpoolDir, msg.ID)); err != nil {
+		t.Errorf("Markers of a held message must survive the janitor: %v", err)
+	}
+}
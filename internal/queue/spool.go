@@ -4,16 +4,87 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/pawciobiel/golubsmtpd/internal/config"
 )
 
-// InitializeSpoolDirectories creates all required spool directories with secure permissions
+// ErrDataDurationExceeded is returned when the DATA phase runs longer than max_data_duration
+var ErrDataDurationExceeded = errors.New("message transfer time limit exceeded")
+
+// DefaultDataBufferSize is the DATA read chunk size used when
+// server.data_buffer_size is not set
+const DefaultDataBufferSize = 32 * 1024
+
+// ErrMessageTooLarge is returned when the message exceeds max_message_size; the
+// rest of the DATA is consumed so the client can be answered in sync
+var ErrMessageTooLarge = errors.New("message size exceeds limit")
+
+// ErrEmptyBody is returned when headers_only is reject and the message ends
+// without a body after its header block
+var ErrEmptyBody = errors.New("message has no body")
+
+// syncDir flushes a directory's entries to disk; replaced in tests
+var syncDir = fsyncDir
+
+// fsyncDir opens dir and fsyncs it, making renames into it durable
+func fsyncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// syncSpoolDir fsyncs dir when the spool syncs directories after renames
+func syncSpoolDir(spool Spool, dir string) error {
+	if !spool.SyncDirs {
+		return nil
+	}
+	if err := syncDir(dir); err != nil {
+		return fmt.Errorf("failed to sync spool directory %s: %w", dir, err)
+	}
+	return nil
+}
+
+// NewSpool returns the spool configured by server.spool_dir,
+// server.spool_sharding and server.spool_sync_dirs. Switch layouts only with an
+// empty spool.
+func NewSpool(cfg *config.Config) Spool {
+	return Spool{Dir: cfg.Server.SpoolDir, Sharded: cfg.Server.SpoolSharding, SyncDirs: cfg.Server.SpoolSyncDirs}
+}
+
+// ensureMessageDir returns the message's directory in a spool state, creating
+// a shard directory on first use
+func ensureMessageDir(spool Spool, state MessageState, messageID string) (string, error) {
+	dir := spool.MessageDir(state, messageID)
+	if !spool.Sharded {
+		return dir, nil
+	}
+	if _, err := os.Stat(dir); err == nil {
+		return dir, nil
+	}
+	if err := os.Mkdir(dir, 0o700); err != nil && !os.IsExist(err) {
+		return "", fmt.Errorf("failed to create spool shard %s: %w", dir, err)
+	}
+	// The new shard entry must be durable for the files renamed into it to be
+	if err := syncSpoolDir(spool, filepath.Dir(dir)); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// InitializeSpoolDirectories creates all required spool directories with secure
+// permissions. Shard directories are created as messages arrive.
 func InitializeSpoolDirectories(spoolDir string) error {
 	for _, state := range GetRequiredSpoolDirectories() {
 		dir := filepath.Join(spoolDir, string(state))
@@ -40,10 +111,6 @@ func StreamEmailContent(ctx context.Context, cfg *config.Config, message *Messag
 	// Use message's standardized filename
 	filename := message.Filename()
 
-	incomingDir := filepath.Join(cfg.Server.SpoolDir, string(MessageStateIncoming))
-	tempFile := filepath.Join(incomingDir, filename+".tmp")
-	finalFile := filepath.Join(incomingDir, filename)
-
 	// Check for context cancellation before starting
 	select {
 	case <-ctx.Done():
@@ -51,6 +118,14 @@ func StreamEmailContent(ctx context.Context, cfg *config.Config, message *Messag
 	default:
 	}
 
+	spool := NewSpool(cfg)
+	incomingDir, err := ensureMessageDir(spool, MessageStateIncoming, message.ID)
+	if err != nil {
+		return 0, err
+	}
+	tempFile := filepath.Join(incomingDir, filename+".tmp")
+	finalFile := filepath.Join(incomingDir, filename)
+
 	// Create temporary file with secure permissions (0600 = rw-------)
 	file, err := os.OpenFile(tempFile, os.O_CREATE|os.O_RDWR|os.O_EXCL, 0o600)
 	if err != nil {
@@ -67,7 +142,7 @@ func StreamEmailContent(ctx context.Context, cfg *config.Config, message *Messag
 	}()
 
 	// Stream SMTP DATA with chunked reading and SMTP protocol handling
-	totalSize, err := streamSMTPData(ctx, file, reader, cfg.Server.MaxMessageSize)
+	totalSize, err := streamSMTPData(ctx, file, reader, cfg.Server.MaxMessageSize, cfg.Server.MaxDataDuration, cfg.Server.DataBufferSize)
 	if err != nil {
 		return totalSize, fmt.Errorf("failed to stream SMTP data: %w", err)
 	}
@@ -79,6 +154,16 @@ func StreamEmailContent(ctx context.Context, cfg *config.Config, message *Messag
 		return 0, fmt.Errorf("empty message file")
 	}
 
+	if cfg.Server.HeadersOnly == config.HeadersOnlyReject {
+		hasBody, err := hasMessageBody(file)
+		if err != nil {
+			return totalSize, err
+		}
+		if !hasBody {
+			return totalSize, ErrEmptyBody
+		}
+	}
+
 	// Force data to disk (critical for atomicity)
 	if err := file.Sync(); err != nil {
 		return totalSize, fmt.Errorf("failed to sync file to disk: %w", err)
@@ -101,17 +186,75 @@ func StreamEmailContent(ctx context.Context, cfg *config.Config, message *Messag
 		return totalSize, fmt.Errorf("failed to atomically rename file: %w", err)
 	}
 
+	// The rename is only durable once the directory is synced; the client has
+	// not been answered yet, so a failure here fails the message
+	if err := syncSpoolDir(spool, incomingDir); err != nil {
+		os.Remove(finalFile)
+		return totalSize, err
+	}
+
 	return totalSize, nil
 }
 
-// streamSMTPData handles SMTP DATA protocol with chunked reading
-func streamSMTPData(ctx context.Context, file *os.File, ioreader io.Reader, maxSize int) (int64, error) {
+// hasMessageBody reports whether the spooled message has content after the
+// empty line ending its header block. Headers sent without that separator, or
+// with nothing after it, make a message with no body.
+func hasMessageBody(file *os.File) (bool, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return false, fmt.Errorf("failed to rewind message file: %w", err)
+	}
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			// Only the start of a line decides whether it is the separator
+			for err == bufio.ErrBufferFull {
+				_, err = reader.ReadSlice('\n')
+			}
+			line = nil
+		}
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to read message file: %w", err)
+		}
+		if string(line) == "\r\n" || string(line) == "\n" {
+			if _, err := reader.Peek(1); err != nil {
+				if err == io.EOF {
+					return false, nil
+				}
+				return false, fmt.Errorf("failed to read message file: %w", err)
+			}
+			return true, nil
+		}
+	}
+}
+
+// streamSMTPData handles SMTP DATA protocol with chunked reading of bufSize
+// bytes (0 = DefaultDataBufferSize); the terminator is found even when it
+// spans chunks. maxDuration bounds the whole DATA phase from its start, so a
+// client dribbling bytes just fast enough to dodge the idle timeout is still
+// cut off (0 = no limit).
+func streamSMTPData(ctx context.Context, file *os.File, ioreader io.Reader, maxSize int, maxDuration time.Duration, bufSize int) (int64, error) {
 	terminator := []byte("\r\n.\r\n")
+	var deadline time.Time
+	if maxDuration > 0 {
+		deadline = time.Now().Add(maxDuration)
+	}
 	maxMessageSize := int64(maxSize)
-	tail := []byte{}
-	buf := make([]byte, 1024)
-	reader := bufio.NewReader(ioreader)
+	if bufSize <= 0 {
+		bufSize = DefaultDataBufferSize
+	}
+	// Reads land after a carry-over of at most len(terminator)-1 bytes from
+	// the previous read, so a terminator split across reads is found without
+	// copying each chunk into a fresh slice
+	maxCarry := len(terminator) - 1
+	buf := make([]byte, maxCarry+bufSize)
+	carry := 0
+	reader := bufio.NewReaderSize(ioreader, bufSize)
 	var totalWritten int64
+	tooLarge := false
 
 	for {
 		// Check for context cancellation
@@ -120,20 +263,22 @@ func streamSMTPData(ctx context.Context, file *os.File, ioreader io.Reader, maxS
 			return totalWritten, ctx.Err()
 		default:
 		}
-		n, err := reader.Read(buf)
+		n, err := reader.Read(buf[carry:])
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return totalWritten, fmt.Errorf("%w after %s", ErrDataDurationExceeded, maxDuration)
+		}
 		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-			return totalWritten, fmt.Errorf("timeout waiting for terminator")
+			return totalWritten, fmt.Errorf("timeout waiting for terminator: %w", err)
 		}
 		if n > 0 {
-			chunk := buf[:n]
-			searchBuf := append(tail, chunk...)
+			searchBuf := buf[:carry+n]
 			if idx := bytes.Index(searchBuf, terminator); idx != -1 {
 				// Found terminator \r\n.\r\n → write message data up to it
 				messageData := searchBuf[:idx]
 
 				// Check message size limit before writing final chunk
-				if maxMessageSize > 0 && totalWritten+int64(len(messageData))+2 > maxMessageSize {
-					return totalWritten, fmt.Errorf("message size exceeds limit of %d bytes", maxMessageSize)
+				if tooLarge || maxMessageSize > 0 && totalWritten+int64(len(messageData))+2 > maxMessageSize {
+					return totalWritten, fmt.Errorf("%w of %d bytes", ErrMessageTooLarge, maxMessageSize)
 				}
 
 				written, err := file.Write(messageData)
@@ -151,27 +296,32 @@ func streamSMTPData(ctx context.Context, file *os.File, ioreader io.Reader, maxS
 				totalWritten += int64(crlfWritten)
 				break
 			}
-			if len(searchBuf) > len(terminator) {
-				flushUpto := len(searchBuf) - len(terminator)
 
-				// Check message size limit before writing
+			// Keep the last bytes back: they may start a terminator
+			flushUpto := max(len(searchBuf)-maxCarry, 0)
+			if flushUpto > 0 {
+				// Check message size limit before writing; once over, keep reading
+				// (without writing) until the terminator so the reply stays in sync
 				lineData := searchBuf[:flushUpto]
 				if maxMessageSize > 0 && totalWritten+int64(len(lineData)) > maxMessageSize {
-					return totalWritten, fmt.Errorf("message size exceeds limit of %d bytes", maxMessageSize)
+					tooLarge = true
 				}
 
-				written, err := file.Write(lineData)
-				if err != nil {
-					return totalWritten, fmt.Errorf("failed to write to file: %w %s", err, file.Name())
+				if !tooLarge {
+					written, err := file.Write(lineData)
+					if err != nil {
+						return totalWritten, fmt.Errorf("failed to write to file: %w %s", err, file.Name())
+					}
+					totalWritten += int64(written)
 				}
-				totalWritten += int64(written)
-				tail = searchBuf[flushUpto:]
-			} else {
-				tail = searchBuf
 			}
+			carry = copy(buf, searchBuf[flushUpto:])
 		}
 		if err != nil {
 			if err == io.EOF {
+				if tooLarge {
+					return totalWritten, fmt.Errorf("%w of %d bytes", ErrMessageTooLarge, maxMessageSize)
+				}
 				err = nil
 				break
 			} else {
@@ -184,9 +334,12 @@ func streamSMTPData(ctx context.Context, file *os.File, ioreader io.Reader, maxS
 
 // WriteRawBody writes an in-memory message body (e.g. a DSN bounce) directly to the
 // incoming spool directory without SMTP dot-stuffing processing.
-func WriteRawBody(spoolDir string, message *Message) error {
+func WriteRawBody(spool Spool, message *Message) error {
 	filename := message.Filename()
-	incomingDir := filepath.Join(spoolDir, string(MessageStateIncoming))
+	incomingDir, err := ensureMessageDir(spool, MessageStateIncoming, message.ID)
+	if err != nil {
+		return err
+	}
 	tempFile := filepath.Join(incomingDir, filename+".tmp")
 	finalFile := filepath.Join(incomingDir, filename)
 
@@ -213,25 +366,131 @@ func WriteRawBody(spoolDir string, message *Message) error {
 	if err := os.Rename(tempFile, finalFile); err != nil {
 		return fmt.Errorf("failed to commit DSN file: %w", err)
 	}
+	if err := syncSpoolDir(spool, incomingDir); err != nil {
+		os.Remove(finalFile)
+		return err
+	}
 	return nil
 }
 
 // MoveMessage atomically moves a message between spool states using the message filename
-func MoveMessage(spoolDir string, msg *Message, fromState, toState MessageState) error {
+func MoveMessage(spool Spool, msg *Message, fromState, toState MessageState) error {
 	filename := msg.Filename()
-	sourceFile := filepath.Join(spoolDir, string(fromState), filename)
-	targetFile := filepath.Join(spoolDir, string(toState), filename)
+	targetDir, err := ensureMessageDir(spool, toState, msg.ID)
+	if err != nil {
+		return err
+	}
+	sourceDir := spool.MessageDir(fromState, msg.ID)
+	sourceFile := filepath.Join(sourceDir, filename)
+	targetFile := filepath.Join(targetDir, filename)
 
 	// Atomic move
 	if err := os.Rename(sourceFile, targetFile); err != nil {
 		return fmt.Errorf("failed to move message %s from %s to %s: %w", msg.ID, fromState, toState, err)
 	}
 
+	// The move already happened; an unsynced directory only risks the move
+	// being undone by a crash, so this is not an error. Both entries must be
+	// synced, or a crash could leave the message in neither state or in both.
+	for _, dir := range []string{targetDir, sourceDir} {
+		if err := syncSpoolDir(spool, dir); err != nil {
+			log().Warn("Spool move may not survive a crash", "message_id", msg.ID, "state", toState, "error", err)
+		}
+	}
+
 	return nil
 }
 
 // GetMessagePath returns the full file path for a message in a given state
-func GetMessagePath(spoolDir string, msg *Message, state MessageState) string {
-	filename := msg.Filename()
-	return filepath.Join(spoolDir, string(state), filename)
+func GetMessagePath(spool Spool, msg *Message, state MessageState) string {
+	return filepath.Join(spool.MessageDir(state, msg.ID), msg.Filename())
+}
+
+// parseSpoolFilename splits a spool filename produced by Message.Filename into
+// the message ID and creation time
+func parseSpoolFilename(name string) (id string, created time.Time, ok bool) {
+	base, found := strings.CutSuffix(name, ".eml")
+	if !found {
+		return "", time.Time{}, false
+	}
+	timestamp, id, found := strings.Cut(base, ".")
+	if !found || id == "" {
+		return "", time.Time{}, false
+	}
+	created, err := time.Parse("20060102T150405Z", timestamp)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	return id, created, true
+}
+
+// findSpooledMessage locates a message in a spool state by ID and returns a
+// Message carrying its ID and creation time, so it can be moved with MoveMessage
+func findSpooledMessage(spool Spool, state MessageState, messageID string) (*Message, error) {
+	matches, err := filepath.Glob(filepath.Join(spool.MessageDir(state, messageID), "*."+messageID+".eml"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range matches {
+		if id, created, ok := parseSpoolFilename(filepath.Base(path)); ok && id == messageID {
+			return &Message{ID: id, Created: created}, nil
+		}
+	}
+	return nil, fmt.Errorf("message %s not found in %s: %w", messageID, state, os.ErrNotExist)
+}
+
+// readSpoolState lists the files of a spool state directory and of its shard
+// directories
+func readSpoolState(dir string) ([]os.DirEntry, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []os.DirEntry
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			files = append(files, entry)
+			continue
+		}
+		shard, err := os.ReadDir(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range shard {
+			if !file.IsDir() {
+				files = append(files, file)
+			}
+		}
+	}
+	return files, nil
+}
+
+// ListMessageIDs returns the sorted IDs of messages spooled in a state, looking
+// into shard directories too. The retry state holds metadata files rather than
+// messages, so its IDs come from those.
+func ListMessageIDs(spool Spool, state MessageState) ([]string, error) {
+	if !slices.Contains(GetRequiredSpoolDirectories(), state) {
+		return nil, fmt.Errorf("unknown spool state %q", state)
+	}
+
+	entries, err := readSpoolState(filepath.Join(spool.Dir, string(state)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spool state %s: %w", state, err)
+	}
+
+	ids := []string{}
+	for _, entry := range entries {
+		if state == MessageStateRetry {
+			if id, ok := strings.CutSuffix(entry.Name(), ".json"); ok {
+				ids = append(ids, id)
+			}
+			continue
+		}
+		if id, _, ok := parseSpoolFilename(entry.Name()); ok {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	return ids, nil
 }
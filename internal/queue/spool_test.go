@@ -1,14 +1,20 @@
 package queue
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/pawciobiel/golubsmtpd/internal/config"
+	"github.com/pawciobiel/golubsmtpd/internal/delivery"
 )
 
 func createSpoolTestConfig(t *testing.T) (*config.Config, string) {
@@ -39,12 +45,10 @@ func createSpoolTestConfig(t *testing.T) (*config.Config, string) {
 
 func createTestSpoolMessage() *Message {
 	return &Message{
-		ID:      GenerateID(),
-		Created: time.Now().UTC(),
-		From:    "test@example.com",
-		LocalRecipients: map[string]struct{}{
-			"user@localhost": {},
-		},
+		ID:              GenerateID(),
+		Created:         time.Now().UTC(),
+		From:            "test@example.com",
+		LocalRecipients: NewRecipientSet("user@localhost"),
 	}
 }
 
@@ -194,8 +198,90 @@ func TestStreamEmailContent_MessageSizeLimit(t *testing.T) {
 		t.Fatal("Expected message size limit error, got nil")
 	}
 
-	if !strings.Contains(err.Error(), "message size exceeds limit") {
-		t.Errorf("Expected size limit error, got: %v", err)
+	if !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("Expected ErrMessageTooLarge, got: %v", err)
+	}
+}
+
+func TestStreamEmailContent_HeadersOnly(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  config.HeadersOnly
+		data    string
+		wantErr bool
+	}{
+		{"accept headers only", config.HeadersOnlyAccept, "Subject: Test\r\n.\r\n", false},
+		{"accept empty body", config.HeadersOnlyAccept, "Subject: Test\r\n\r\n.\r\n", false},
+		{"reject headers only", config.HeadersOnlyReject, "Subject: Test\r\nFrom: a@example.com\r\n.\r\n", true},
+		{"reject empty body", config.HeadersOnlyReject, "Subject: Test\r\n\r\n.\r\n", true},
+		{"reject blank message", config.HeadersOnlyReject, "\r\n.\r\n", true},
+		{"reject long header without body", config.HeadersOnlyReject, "Subject: " + strings.Repeat("x", 8192) + "\r\n.\r\n", true},
+		{"reject allows body", config.HeadersOnlyReject, "Subject: Test\r\n\r\nHello\r\n.\r\n", false},
+		{"reject allows blank body line", config.HeadersOnlyReject, "Subject: Test\r\n\r\n\r\n.\r\n", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, tempDir := createSpoolTestConfig(t)
+			defer os.RemoveAll(tempDir)
+			cfg.Server.HeadersOnly = tt.policy
+			message := createTestSpoolMessage()
+
+			_, err := StreamEmailContent(context.Background(), cfg, message, strings.NewReader(tt.data))
+			if tt.wantErr != errors.Is(err, ErrEmptyBody) {
+				t.Fatalf("StreamEmailContent error = %v, want ErrEmptyBody: %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("StreamEmailContent failed: %v", err)
+			}
+
+			_, statErr := os.Stat(filepath.Join(tempDir, "incoming", message.Filename()))
+			if spooled := statErr == nil; spooled == tt.wantErr {
+				t.Errorf("message spooled = %v, want %v", spooled, !tt.wantErr)
+			}
+		})
+	}
+}
+
+// dripReader emits one byte per tick and never sends the DATA terminator
+type dripReader struct {
+	tick time.Duration
+}
+
+func (r *dripReader) Read(p []byte) (int, error) {
+	time.Sleep(r.tick)
+	p[0] = 'A'
+	return 1, nil
+}
+
+func TestStreamEmailContent_MaxDataDuration(t *testing.T) {
+	cfg, tempDir := createSpoolTestConfig(t)
+	defer os.RemoveAll(tempDir)
+
+	cfg.Server.MaxDataDuration = 100 * time.Millisecond
+
+	ctx := context.Background()
+	message := createTestSpoolMessage()
+
+	start := time.Now()
+	_, err := StreamEmailContent(ctx, cfg, message, &dripReader{tick: 5 * time.Millisecond})
+	if err == nil {
+		t.Fatal("Expected max data duration error, got nil")
+	}
+	if !errors.Is(err, ErrDataDurationExceeded) {
+		t.Errorf("Expected ErrDataDurationExceeded, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Stream should abort soon after max duration, took %s", elapsed)
+	}
+
+	// Aborted message must not be left in the spool
+	entries, err := os.ReadDir(filepath.Join(tempDir, string(MessageStateIncoming)))
+	if err != nil {
+		t.Fatalf("Failed to read incoming dir: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Expected no files in incoming/ after abort, got %d", len(entries))
 	}
 }
 
@@ -231,3 +317,306 @@ func TestInitializeSpoolDirectories(t *testing.T) {
 		}
 	}
 }
+
+// chunkReader returns its data in reads of at most size bytes
+type chunkReader struct {
+	data []byte
+	size int
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	n := min(len(p), r.size, len(r.data))
+	copy(p, r.data[:n])
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestStreamEmailContent_BufferSizes(t *testing.T) {
+	body := strings.Repeat("Line of message text for the buffer test.\r\n", 200)
+	smtpData := "Subject: Buffers\r\n\r\n" + body + ".\r\n"
+	expected := "Subject: Buffers\r\n\r\n" + body
+
+	for _, bufSize := range []int{16, 1024, 32 * 1024} {
+		// Read sizes chosen so the terminator straddles reads and buffers
+		for _, readSize := range []int{1, 3, 7, 4096} {
+			t.Run(fmt.Sprintf("buffer %d read %d", bufSize, readSize), func(t *testing.T) {
+				cfg, tempDir := createSpoolTestConfig(t)
+				defer os.RemoveAll(tempDir)
+				cfg.Server.DataBufferSize = bufSize
+
+				message := createTestSpoolMessage()
+				reader := &chunkReader{data: []byte(smtpData), size: readSize}
+				totalSize, err := StreamEmailContent(context.Background(), cfg, message, reader)
+				if err != nil {
+					t.Fatalf("StreamEmailContent failed: %v", err)
+				}
+
+				content, err := os.ReadFile(filepath.Join(tempDir, "incoming", message.Filename()))
+				if err != nil {
+					t.Fatalf("Failed to read message file: %v", err)
+				}
+				if string(content) != expected {
+					t.Errorf("Message content mismatch: got %d bytes, want %d", len(content), len(expected))
+				}
+				if totalSize != int64(len(expected)) {
+					t.Errorf("Size mismatch. Expected: %d, Got: %d", len(expected), totalSize)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkStreamSMTPData(b *testing.B) {
+	smtpData := []byte("Subject: Bench\r\n\r\n" + strings.Repeat(strings.Repeat("x", 76)+"\r\n", 64*1024) + ".\r\n")
+
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatalf("Failed to open %s: %v", os.DevNull, err)
+	}
+	defer devNull.Close()
+
+	for _, bufSize := range []int{1024, 32 * 1024} {
+		b.Run(fmt.Sprintf("%dKB", bufSize/1024), func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(smtpData)))
+			for b.Loop() {
+				if _, err := streamSMTPData(context.Background(), devNull, bytes.NewReader(smtpData), 0, 0, bufSize); err != nil {
+					b.Fatalf("streamSMTPData failed: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// splitReader returns data in two reads split at offset
+type splitReader struct {
+	data   []byte
+	offset int
+}
+
+func (r *splitReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	n := len(r.data)
+	if r.offset > 0 {
+		n = r.offset
+		r.offset = 0
+	}
+	n = copy(p, r.data[:n])
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestStreamSMTPData_TerminatorSplitAtEveryOffset(t *testing.T) {
+	expected := "Subject: Split\r\n\r\nbody line\r\n"
+	smtpData := []byte(expected + ".\r\n")
+
+	for _, bufSize := range []int{16, 32 * 1024} {
+		for offset := 1; offset < len(smtpData); offset++ {
+			t.Run(fmt.Sprintf("buffer %d offset %d", bufSize, offset), func(t *testing.T) {
+				var out bytes.Buffer
+				file := tempOutputFile(t)
+				totalSize, err := streamSMTPData(context.Background(), file, &splitReader{data: smtpData, offset: offset}, 0, 0, bufSize)
+				if err != nil {
+					t.Fatalf("streamSMTPData failed: %v", err)
+				}
+				if _, err := file.Seek(0, io.SeekStart); err != nil {
+					t.Fatalf("Seek failed: %v", err)
+				}
+				if _, err := out.ReadFrom(file); err != nil {
+					t.Fatalf("Failed to read output: %v", err)
+				}
+				if out.String() != expected {
+					t.Errorf("content = %q, want %q", out.String(), expected)
+				}
+				if totalSize != int64(len(expected)) {
+					t.Errorf("size = %d, want %d", totalSize, len(expected))
+				}
+			})
+		}
+	}
+}
+
+func TestStreamSMTPData_AllocationsIndependentOfSize(t *testing.T) {
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("Failed to open %s: %v", os.DevNull, err)
+	}
+	defer devNull.Close()
+
+	allocs := func(lines int) float64 {
+		smtpData := []byte("Subject: Allocs\r\n\r\n" + strings.Repeat(strings.Repeat("x", 76)+"\r\n", lines) + ".\r\n")
+		return testing.AllocsPerRun(10, func() {
+			if _, err := streamSMTPData(context.Background(), devNull, bytes.NewReader(smtpData), 0, 0, 1024); err != nil {
+				t.Fatalf("streamSMTPData failed: %v", err)
+			}
+		})
+	}
+
+	small, large := allocs(1), allocs(10000)
+	if large > small {
+		t.Errorf("allocations grow with message size: %v for 1 line, %v for 10000 lines", small, large)
+	}
+}
+
+// tempOutputFile creates a spool-like output file removed at the end of the test
+func tempOutputFile(t *testing.T) *os.File {
+	t.Helper()
+	file, err := os.CreateTemp(t.TempDir(), "stream-*.eml")
+	if err != nil {
+		t.Fatalf("Failed to create output file: %v", err)
+	}
+	t.Cleanup(func() { file.Close() })
+	return file
+}
+
+func TestSpoolSharding_WriteAndMove(t *testing.T) {
+	cfg, tempDir := createSpoolTestConfig(t)
+	defer os.RemoveAll(tempDir)
+	cfg.Server.SpoolSharding = true
+	spool := NewSpool(cfg)
+
+	message := createTestSpoolMessage()
+	shard := message.ID[:2]
+	if _, err := StreamEmailContent(context.Background(), cfg, message, strings.NewReader("Subject: Shard\r\n\r\nbody\r\n.\r\n")); err != nil {
+		t.Fatalf("StreamEmailContent failed: %v", err)
+	}
+
+	incoming := filepath.Join(tempDir, string(MessageStateIncoming), shard, message.Filename())
+	if _, err := os.Stat(incoming); err != nil {
+		t.Fatalf("message not written to shard: %v", err)
+	}
+	if got := GetMessagePath(spool, message, MessageStateIncoming); got != incoming {
+		t.Errorf("GetMessagePath = %q, want %q", got, incoming)
+	}
+
+	if err := MoveMessage(spool, message, MessageStateIncoming, MessageStateProcessing); err != nil {
+		t.Fatalf("MoveMessage failed: %v", err)
+	}
+	processing := filepath.Join(tempDir, string(MessageStateProcessing), shard, message.Filename())
+	if _, err := os.Stat(processing); err != nil {
+		t.Fatalf("message not moved to processing shard: %v", err)
+	}
+	if _, err := os.Stat(incoming); !os.IsNotExist(err) {
+		t.Errorf("message still present in incoming shard: %v", err)
+	}
+
+	ids, err := ListMessageIDs(spool, MessageStateProcessing)
+	if err != nil {
+		t.Fatalf("ListMessageIDs failed: %v", err)
+	}
+	if len(ids) != 1 || ids[0] != message.ID {
+		t.Errorf("ListMessageIDs = %v, want [%s]", ids, message.ID)
+	}
+
+	found, err := findSpooledMessage(spool, MessageStateProcessing, message.ID)
+	if err != nil {
+		t.Fatalf("findSpooledMessage failed: %v", err)
+	}
+	if found.Filename() != message.Filename() {
+		t.Errorf("findSpooledMessage found %q, want %q", found.Filename(), message.Filename())
+	}
+
+	// Retry state and held envelopes live in the same shards as messages
+	if err := delivery.SaveRetryState(spool, delivery.NewRetryState(message.ID, "sender@example.com", time.Hour, []string{"bob@remote.example"})); err != nil {
+		t.Fatalf("SaveRetryState failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, string(MessageStateRetry), shard, message.ID+".json")); err != nil {
+		t.Errorf("retry state not written to shard: %v", err)
+	}
+	if ids, err := ListMessageIDs(spool, MessageStateRetry); err != nil || len(ids) != 1 || ids[0] != message.ID {
+		t.Errorf("ListMessageIDs(retry) = %v, %v, want [%s]", ids, err, message.ID)
+	}
+	if got, want := heldEnvelopePath(spool, message.ID), filepath.Join(tempDir, string(MessageStateHold), shard, message.ID+".json"); got != want {
+		t.Errorf("heldEnvelopePath = %q, want %q", got, want)
+	}
+}
+
+func TestSpoolSharding_Disabled(t *testing.T) {
+	cfg, tempDir := createSpoolTestConfig(t)
+	defer os.RemoveAll(tempDir)
+
+	message := createTestSpoolMessage()
+	if _, err := StreamEmailContent(context.Background(), cfg, message, strings.NewReader("Subject: Flat\r\n\r\nbody\r\n.\r\n")); err != nil {
+		t.Fatalf("StreamEmailContent failed: %v", err)
+	}
+	want := filepath.Join(tempDir, string(MessageStateIncoming), message.Filename())
+	if _, err := os.Stat(want); err != nil {
+		t.Fatalf("message not written to the flat incoming directory: %v", err)
+	}
+}
+
+// recordDirSyncs records the directories synced for the rest of the test
+func recordDirSyncs(t *testing.T) *[]string {
+	t.Helper()
+	var synced []string
+	syncDir = func(dir string) error {
+		synced = append(synced, dir)
+		return fsyncDir(dir)
+	}
+	t.Cleanup(func() { syncDir = fsyncDir })
+	return &synced
+}
+
+func TestSpoolDirSync(t *testing.T) {
+	cfg, tempDir := createSpoolTestConfig(t)
+	defer os.RemoveAll(tempDir)
+	cfg.Server.SpoolSyncDirs = true
+	spool := NewSpool(cfg)
+	synced := recordDirSyncs(t)
+
+	message := createTestSpoolMessage()
+	if _, err := StreamEmailContent(context.Background(), cfg, message, strings.NewReader("Subject: Sync\r\nBcc: x@example.com\r\n\r\nbody\r\n.\r\n")); err != nil {
+		t.Fatalf("StreamEmailContent failed: %v", err)
+	}
+	if _, _, err := StripMessageHeaders(spool, GetMessagePath(spool, message, MessageStateIncoming), "Bcc"); err != nil {
+		t.Fatalf("StripMessageHeaders failed: %v", err)
+	}
+	if err := MoveMessage(spool, message, MessageStateIncoming, MessageStateProcessing); err != nil {
+		t.Fatalf("MoveMessage failed: %v", err)
+	}
+
+	incoming := filepath.Join(tempDir, string(MessageStateIncoming))
+	processing := filepath.Join(tempDir, string(MessageStateProcessing))
+	want := []string{incoming, incoming, processing, incoming}
+	if !slices.Equal(*synced, want) {
+		t.Errorf("synced directories = %v, want %v", *synced, want)
+	}
+}
+
+func TestSpoolDirSync_Disabled(t *testing.T) {
+	cfg, tempDir := createSpoolTestConfig(t)
+	defer os.RemoveAll(tempDir)
+	synced := recordDirSyncs(t)
+
+	message := createTestSpoolMessage()
+	if _, err := StreamEmailContent(context.Background(), cfg, message, strings.NewReader("Subject: Sync\r\n\r\nbody\r\n.\r\n")); err != nil {
+		t.Fatalf("StreamEmailContent failed: %v", err)
+	}
+	if err := MoveMessage(NewSpool(cfg), message, MessageStateIncoming, MessageStateProcessing); err != nil {
+		t.Fatalf("MoveMessage failed: %v", err)
+	}
+	if len(*synced) != 0 {
+		t.Errorf("directories synced with spool_sync_dirs off: %v", *synced)
+	}
+}
+
+func TestSpoolDirSync_FailureRemovesMessage(t *testing.T) {
+	cfg, tempDir := createSpoolTestConfig(t)
+	defer os.RemoveAll(tempDir)
+	cfg.Server.SpoolSyncDirs = true
+	syncDir = func(string) error { return errors.New("sync failed") }
+	t.Cleanup(func() { syncDir = fsyncDir })
+
+	message := createTestSpoolMessage()
+	if _, err := StreamEmailContent(context.Background(), cfg, message, strings.NewReader("Subject: Sync\r\n\r\nbody\r\n.\r\n")); err == nil {
+		t.Fatal("StreamEmailContent should fail when the directory cannot be synced")
+	}
+	if _, err := os.Stat(GetMessagePath(NewSpool(cfg), message, MessageStateIncoming)); !os.IsNotExist(err) {
+		t.Errorf("message left in incoming after failed sync: %v", err)
+	}
+}
@@ -0,0 +1,97 @@
+package queue
+
+import (
+	"context"
+
+	"github.com/pawciobiel/golubsmtpd/internal/config"
+	"github.com/pawciobiel/golubsmtpd/internal/delivery"
+)
+
+// deliveryRoutes holds the recipients of a message split by delivery method
+// once transport_maps has been applied
+type deliveryRoutes struct {
+	local    map[string]struct{}
+	virtual  map[string]struct{}
+	outbound map[string]struct{}            // direct MX or the configured smarthost
+	relays   map[string]map[string]struct{} // transport relay host[:port] -> recipients
+	commands map[string]struct{}            // piped to their transport command
+}
+
+// hasOutbound reports whether any recipient is delivered over SMTP
+func (r *deliveryRoutes) hasOutbound() bool {
+	return len(r.outbound) > 0 || len(r.relays) > 0
+}
+
+// routeRecipients sorts the recipients of msg by delivery method. A recipient
+// without a transport_maps entry keeps the default for its domain type.
+func (q *Queue) routeRecipients(msg *Message) deliveryRoutes {
+	routes := deliveryRoutes{
+		local:    make(map[string]struct{}),
+		virtual:  make(map[string]struct{}),
+		outbound: make(map[string]struct{}),
+		relays:   make(map[string]map[string]struct{}),
+		commands: make(map[string]struct{}),
+	}
+
+	route := func(recipients RecipientSet, fallback map[string]struct{}) {
+		for recipient := range recipients {
+			transport, ok := q.transports.Lookup(recipient)
+			if !ok {
+				fallback[recipient] = struct{}{}
+				continue
+			}
+			switch transport.Kind {
+			case config.TransportLocal:
+				routes.local[recipient] = struct{}{}
+			case config.TransportVirtual:
+				routes.virtual[recipient] = struct{}{}
+			case config.TransportRelay:
+				if routes.relays[transport.Arg] == nil {
+					routes.relays[transport.Arg] = make(map[string]struct{})
+				}
+				routes.relays[transport.Arg][recipient] = struct{}{}
+			case config.TransportCommand:
+				routes.commands[recipient] = struct{}{}
+			}
+		}
+	}
+	route(msg.LocalRecipients, routes.local)
+	route(msg.VirtualRecipients, routes.virtual)
+	route(msg.RelayRecipients, routes.outbound)
+	route(msg.ExternalRecipients, routes.outbound)
+	return routes
+}
+
+// virtualConfigFor returns the virtual delivery settings for recipient, with
+// the base path of its transport_maps entry when one names a path
+func (q *Queue) virtualConfigFor(recipient string) *config.VirtualDeliveryConfig {
+	transport, ok := q.transports.Lookup(recipient)
+	if !ok || transport.Kind != config.TransportVirtual || transport.Arg == "" {
+		return &q.config.Delivery.Virtual
+	}
+	cfg := q.config.Delivery.Virtual
+	cfg.BaseDirPath = transport.Arg
+	return &cfg
+}
+
+// deliverOutbound delivers the default outbound recipients and every
+// transport_maps relay group. The results are merged so retry state and
+// bounces are handled once per message.
+func (q *Queue) deliverOutbound(ctx context.Context, msg *Message, messagePath string, routes *deliveryRoutes) delivery.DeliveryResult {
+	cfg := &q.config.Delivery.Outbound
+	maxWorkers := delivery.GetMaxWorkers(cfg.MaxWorkers, len(routes.outbound))
+	result := delivery.DeliverOutboundWithWorkers(ctx, routes.outbound, maxWorkers, msg, messagePath, cfg, q.dkimSigner)
+
+	for relay, recipients := range routes.relays {
+		host, port, _ := config.Transport{Kind: config.TransportRelay, Arg: relay}.RelayHostPort()
+		relayCfg := *cfg
+		relayCfg.Smarthost = config.SmarthostConfig{Host: host, Port: port, TLS: cfg.TLS.Policy}
+
+		relayResult := delivery.DeliverOutboundWithWorkers(ctx, recipients, 1, msg, messagePath, &relayCfg, q.dkimSigner)
+		result.Successful = append(result.Successful, relayResult.Successful...)
+		result.TempFailed = append(result.TempFailed, relayResult.TempFailed...)
+		result.PermFailed = append(result.PermFailed, relayResult.PermFailed...)
+		result.DSNPassed = append(result.DSNPassed, relayResult.DSNPassed...)
+	}
+	return result
+}
@@ -6,8 +6,11 @@ import (
 
 // Re-export types for compatibility
 type (
-	Message      = types.Message
-	MessageState = types.MessageState
+	Message       = types.Message
+	MessageState  = types.MessageState
+	RecipientSet  = types.RecipientSet
+	RecipientInfo = types.RecipientInfo
+	Spool         = types.Spool
 )
 
 const (
@@ -15,10 +18,13 @@ const (
 	MessageStateProcessing = types.MessageStateProcessing
 	MessageStateFailed     = types.MessageStateFailed
 	MessageStateDelivered  = types.MessageStateDelivered
+	MessageStateRetry      = types.MessageStateRetry
+	MessageStateHold       = types.MessageStateHold
 )
 
 // Re-export functions
 var (
 	GenerateID                  = types.GenerateID
+	NewRecipientSet             = types.NewRecipientSet
 	GetRequiredSpoolDirectories = types.GetRequiredSpoolDirectories
 )
@@ -0,0 +1,200 @@
+package security
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/pawciobiel/golubsmtpd/internal/config"
+)
+
+// ContentAction is what a matching content rule does with the message
+type ContentAction string
+
+const (
+	ContentReject  ContentAction = "REJECT"  // refuse the message with the rule's reply text
+	ContentDiscard ContentAction = "DISCARD" // accept the message but drop it
+	ContentWarn    ContentAction = "WARN"    // log the match and carry on
+)
+
+// contentRuleLine parses "/regex/flags ACTION optional text"; the greedy
+// pattern lets the regex itself contain slashes
+var contentRuleLine = regexp.MustCompile(`^/(.+)/(i?)\s+(?i:(REJECT|DISCARD|WARN))(?:\s+(.*))?$`)
+
+// contentRule is one compiled header_checks/body_checks line
+type contentRule struct {
+	pattern *regexp.Regexp
+	action  ContentAction
+	text    string
+}
+
+// ContentMatch describes a rule that matched a header or body line
+type ContentMatch struct {
+	Action  ContentAction
+	Text    string // reply text for REJECT, optional note otherwise
+	Pattern string
+	Header  bool   // matched a header rather than a body line
+	Line    string // the matching line, without line ending
+}
+
+// ContentResult is the outcome of checking one message
+type ContentResult struct {
+	Warnings []ContentMatch
+	Verdict  *ContentMatch // first REJECT or DISCARD match; nil lets the message through
+}
+
+// ContentChecker applies regex rules to the headers and body of spooled
+// messages, in the manner of Postfix header_checks and body_checks.
+//
+// Each rule file line is "/regex/ ACTION [text]", optionally "/regex/i" for a
+// case-insensitive match. Header rules see each header field unfolded onto one
+// line; body rules see each body line. Rules are tried in file order.
+type ContentChecker struct {
+	headerRules []contentRule
+	bodyRules   []contentRule
+}
+
+// NewContentChecker compiles the configured rule files. Returns nil when no
+// rule file is configured.
+func NewContentChecker(cfg *config.ContentChecksConfig) (*ContentChecker, error) {
+	if cfg.HeaderChecksFile == "" && cfg.BodyChecksFile == "" {
+		return nil, nil
+	}
+
+	checker := &ContentChecker{}
+	var err error
+	if checker.headerRules, err = loadContentRules(cfg.HeaderChecksFile); err != nil {
+		return nil, fmt.Errorf("header_checks: %w", err)
+	}
+	if checker.bodyRules, err = loadContentRules(cfg.BodyChecksFile); err != nil {
+		return nil, fmt.Errorf("body_checks: %w", err)
+	}
+	log().Info("Content checks loaded", "header_rules", len(checker.headerRules), "body_rules", len(checker.bodyRules))
+	return checker, nil
+}
+
+// loadContentRules reads and compiles one rule file; an empty path has no rules
+func loadContentRules(path string) ([]contentRule, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open rule file: %w", err)
+	}
+	defer file.Close()
+
+	var rules []contentRule
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		m := contentRuleLine.FindStringSubmatch(line)
+		if m == nil {
+			return nil, fmt.Errorf("%s:%d: expected \"/regex/ REJECT|DISCARD|WARN [text]\"", path, lineNum)
+		}
+		expr := m[1]
+		if m[2] == "i" {
+			expr = "(?i)" + expr
+		}
+		pattern, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, lineNum, err)
+		}
+		rules = append(rules, contentRule{
+			pattern: pattern,
+			action:  ContentAction(strings.ToUpper(m[3])),
+			text:    strings.TrimSpace(m[4]),
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading rule file at line %d: %w", lineNum, err)
+	}
+	return rules, nil
+}
+
+// Check scans the message at path. Scanning stops at the first REJECT or
+// DISCARD match; WARN matches before it are collected.
+func (c *ContentChecker) Check(path string) (*ContentResult, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open message %s: %w", path, err)
+	}
+	defer file.Close()
+
+	result := &ContentResult{}
+	reader := bufio.NewReader(file)
+	inHeaders := true
+	var field string // header field being unfolded
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("failed to read message: %w", err)
+		}
+		if line == "" {
+			break
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		if inHeaders {
+			// Continuation lines belong to the preceding field
+			if line != "" && (line[0] == ' ' || line[0] == '\t') {
+				field += line
+				continue
+			}
+			if field != "" && c.apply(c.headerRules, field, true, result) {
+				return result, nil
+			}
+			field = line
+			// The first empty line ends the header block
+			if line == "" {
+				inHeaders = false
+			}
+			continue
+		}
+
+		if c.apply(c.bodyRules, line, false, result) {
+			return result, nil
+		}
+	}
+
+	// A message without a body ends inside the header block
+	if inHeaders && field != "" {
+		c.apply(c.headerRules, field, true, result)
+	}
+	return result, nil
+}
+
+// apply runs rules against one line, recording matches in result. Returns
+// true once a REJECT or DISCARD verdict is reached.
+func (c *ContentChecker) apply(rules []contentRule, line string, header bool, result *ContentResult) bool {
+	for _, rule := range rules {
+		if !rule.pattern.MatchString(line) {
+			continue
+		}
+		match := ContentMatch{
+			Action:  rule.action,
+			Text:    rule.text,
+			Pattern: rule.pattern.String(),
+			Header:  header,
+			Line:    line,
+		}
+		if rule.action == ContentWarn {
+			result.Warnings = append(result.Warnings, match)
+			continue
+		}
+		result.Verdict = &match
+		return true
+	}
+	return false
+}
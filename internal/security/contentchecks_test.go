@@ -0,0 +1,109 @@
+package security
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pawciobiel/golubsmtpd/internal/config"
+)
+
+// writeTestFile writes content to a file in a temporary directory
+func writeTestFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to write %s: %v", name, err)
+	}
+	return path
+}
+
+func newTestContentChecker(t *testing.T, headerChecks, bodyChecks string) *ContentChecker {
+	t.Helper()
+	cfg := &config.ContentChecksConfig{}
+	if headerChecks != "" {
+		cfg.HeaderChecksFile = writeTestFile(t, "header_checks", headerChecks)
+	}
+	if bodyChecks != "" {
+		cfg.BodyChecksFile = writeTestFile(t, "body_checks", bodyChecks)
+	}
+	checker, err := NewContentChecker(cfg)
+	if err != nil {
+		t.Fatalf("NewContentChecker failed: %v", err)
+	}
+	return checker
+}
+
+func TestContentChecker_HeaderReject(t *testing.T) {
+	checker := newTestContentChecker(t, `# header checks
+/^Subject:.*viagra/i  REJECT No spam here
+/^X-Mailer: bulk/     WARN
+`, "")
+
+	message := writeTestFile(t, "message", "X-Mailer: bulk 1.0\r\nSubject: cheap\r\n VIAGRA today\r\n\r\nbody\r\n")
+	result, err := checker.Check(message)
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+
+	if result.Verdict == nil || result.Verdict.Action != ContentReject {
+		t.Fatalf("Verdict = %+v, want REJECT", result.Verdict)
+	}
+	if result.Verdict.Text != "No spam here" || !result.Verdict.Header {
+		t.Errorf("Verdict = %+v, want header match with reply text", result.Verdict)
+	}
+	// Folded header fields are matched unfolded
+	if result.Verdict.Line != "Subject: cheap VIAGRA today" {
+		t.Errorf("Verdict line = %q", result.Verdict.Line)
+	}
+	if len(result.Warnings) != 1 || result.Warnings[0].Action != ContentWarn {
+		t.Errorf("Warnings = %+v, want the X-Mailer WARN", result.Warnings)
+	}
+}
+
+func TestContentChecker_BodyDiscard(t *testing.T) {
+	checker := newTestContentChecker(t, "/^Subject: body/ WARN\n", "/unsubscribe\\/now/ DISCARD\n")
+
+	message := writeTestFile(t, "message", "Subject: hello\r\n\r\nline one\r\nvisit unsubscribe/now\r\n")
+	result, err := checker.Check(message)
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if result.Verdict == nil || result.Verdict.Action != ContentDiscard || result.Verdict.Header {
+		t.Fatalf("Verdict = %+v, want body DISCARD", result.Verdict)
+	}
+
+	// Body rules never see headers and header rules never see the body
+	clean := writeTestFile(t, "clean", "Subject: unsubscribe/now\r\n\r\nSubject: body\r\n")
+	result, err = checker.Check(clean)
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if result.Verdict != nil || len(result.Warnings) != 0 {
+		t.Errorf("Check(clean) = %+v, want no matches", result)
+	}
+}
+
+func TestNewContentChecker_Errors(t *testing.T) {
+	if checker, err := NewContentChecker(&config.ContentChecksConfig{}); checker != nil || err != nil {
+		t.Errorf("no rule files: got %v, %v, want nil, nil", checker, err)
+	}
+
+	tests := []struct {
+		name  string
+		rules string
+	}{
+		{"bad regex", "/([a-z/ REJECT\n"},
+		{"unknown action", "/spam/ BOUNCE\n"},
+		{"missing delimiters", "spam REJECT\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewContentChecker(&config.ContentChecksConfig{HeaderChecksFile: writeTestFile(t, "header_checks", tt.rules)})
+			if err == nil || !strings.Contains(err.Error(), "header_checks") {
+				t.Errorf("NewContentChecker error = %v, want header_checks error", err)
+			}
+		})
+	}
+}
@@ -2,10 +2,12 @@ package security
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -17,7 +19,8 @@ var log = logging.GetLogger
 
 // DNSBLChecker performs DNSBL (DNS Blacklist) checks
 type DNSBLChecker struct {
-	config *config.DNSBLConfig
+	config   *config.DNSBLConfig
+	resolver Resolver
 
 	// Lock-free counters
 	checkCount   int64
@@ -32,7 +35,7 @@ type DNSBLResult struct {
 	Listed        bool
 	Provider      string
 	ResponseCodes []string
-	Action        string // "reject" or "log"
+	Action        string // "reject", "log", "discard" or "tag"
 	Error         error
 }
 
@@ -40,6 +43,7 @@ type DNSBLResult struct {
 func NewDNSBLChecker(cfg *config.DNSBLConfig) *DNSBLChecker {
 	checker := &DNSBLChecker{
 		config:       cfg,
+		resolver:     DefaultResolver,
 		providerHits: make(map[string]*int64),
 	}
 
@@ -51,6 +55,11 @@ func NewDNSBLChecker(cfg *config.DNSBLConfig) *DNSBLChecker {
 	return checker
 }
 
+// SetResolver replaces the resolver used for DNSBL queries
+func (d *DNSBLChecker) SetResolver(resolver Resolver) {
+	d.resolver = resolver
+}
+
 // CheckIP performs DNSBL checks on an IP address
 func (d *DNSBLChecker) CheckIP(ctx context.Context, ip string) []*DNSBLResult {
 	if !d.config.Enabled || !d.config.CheckIP {
@@ -75,17 +84,9 @@ func (d *DNSBLChecker) CheckIP(ctx context.Context, ip string) []*DNSBLResult {
 		return nil
 	}
 
-	var results []*DNSBLResult
-
-	// Check each DNSBL provider
-	for _, provider := range d.config.Providers {
-		result := d.checkIPAgainstProvider(ctx, ip, provider)
-		if result != nil {
-			results = append(results, result)
-		}
-	}
-
-	return results
+	return d.checkProviders(ctx, func(ctx context.Context, provider string) *DNSBLResult {
+		return d.checkIPAgainstProvider(ctx, ip, provider)
+	})
 }
 
 // CheckDomain performs DNSBL checks on a domain
@@ -96,16 +97,48 @@ func (d *DNSBLChecker) CheckDomain(ctx context.Context, domain string) []*DNSBLR
 
 	atomic.AddInt64(&d.checkCount, 1)
 
-	var results []*DNSBLResult
+	return d.checkProviders(ctx, func(ctx context.Context, provider string) *DNSBLResult {
+		return d.checkDomainAgainstProvider(ctx, domain, provider)
+	})
+}
 
-	// Check each DNSBL provider that supports domain checking
-	for _, provider := range d.config.Providers {
-		result := d.checkDomainAgainstProvider(ctx, domain, provider)
+// checkProviders queries every provider concurrently, so a check takes as long
+// as the slowest provider rather than all of them together. Results keep the
+// configured provider order.
+//
+// With stop_on_first_hit and action reject, the first listing cancels the
+// lookups still running; providers cut short are left out of the results.
+func (d *DNSBLChecker) checkProviders(ctx context.Context, check func(ctx context.Context, provider string) *DNSBLResult) []*DNSBLResult {
+	byProvider := make([]*DNSBLResult, len(d.config.Providers))
+	stopOnHit := d.config.StopOnFirstHit && d.ShouldReject()
+
+	lookupCtx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	var wg sync.WaitGroup
+	for i, provider := range d.config.Providers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := check(lookupCtx, provider)
+			if result != nil && result.Listed && stopOnHit {
+				stop()
+			}
+			if result != nil && ctx.Err() == nil && errors.Is(result.Error, context.Canceled) {
+				log().Debug("DNSBL lookup cancelled after an earlier listing", "provider", provider)
+				return
+			}
+			byProvider[i] = result
+		}()
+	}
+	wg.Wait()
+
+	var results []*DNSBLResult
+	for _, result := range byProvider {
 		if result != nil {
 			results = append(results, result)
 		}
 	}
-
 	return results
 }
 
@@ -129,7 +162,7 @@ func (d *DNSBLChecker) checkIPAgainstProvider(ctx context.Context, ip string, pr
 	query := fmt.Sprintf("%s.%s", reversedIP, provider)
 
 	// Perform DNS lookup
-	addrs, err := net.DefaultResolver.LookupHost(ctx, query)
+	addrs, err := d.resolver.LookupHost(ctx, query)
 	if err != nil {
 		// DNS lookup failure usually means the IP is not listed
 		if isNotFoundError(err) {
@@ -191,7 +224,7 @@ func (d *DNSBLChecker) checkDomainAgainstProvider(ctx context.Context, domain st
 	query := fmt.Sprintf("%s.%s", domain, provider)
 
 	// Perform DNS lookup
-	addrs, err := net.DefaultResolver.LookupHost(ctx, query)
+	addrs, err := d.resolver.LookupHost(ctx, query)
 	if err != nil {
 		// DNS lookup failure usually means the domain is not listed
 		if isNotFoundError(err) {
@@ -0,0 +1,240 @@
+package security
+
+import (
+	"context"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pawciobiel/golubsmtpd/internal/config"
+	"github.com/pawciobiel/golubsmtpd/internal/logging"
+)
+
+func TestMain(m *testing.M) {
+	logging.InitTestLogging()
+	os.Exit(m.Run())
+}
+
+// fakeResolver answers from fixed tables; unknown names are NXDOMAIN
+type fakeResolver struct {
+	hosts map[string][]string // LookupHost name -> addresses
+	ptrs  map[string][]string // LookupAddr ip -> hostnames
+}
+
+func notFound(name string) error {
+	return &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func (f *fakeResolver) LookupMX(_ context.Context, name string) ([]*net.MX, error) {
+	return nil, notFound(name)
+}
+
+func (f *fakeResolver) LookupIPAddr(_ context.Context, host string) ([]net.IPAddr, error) {
+	return nil, notFound(host)
+}
+
+func (f *fakeResolver) LookupHost(_ context.Context, host string) ([]string, error) {
+	if addrs, ok := f.hosts[host]; ok {
+		return addrs, nil
+	}
+	return nil, notFound(host)
+}
+
+func (f *fakeResolver) LookupAddr(_ context.Context, addr string) ([]string, error) {
+	if names, ok := f.ptrs[addr]; ok {
+		return names, nil
+	}
+	return nil, notFound(addr)
+}
+
+func newTestDNSBLChecker(resolver Resolver) *DNSBLChecker {
+	checker := NewDNSBLChecker(&config.DNSBLConfig{
+		Enabled:           true,
+		CheckIP:           true,
+		CheckSenderDomain: true,
+		Providers:         []string{"zen.example.net", "dbl.example.net"},
+		Action:            "reject",
+	})
+	checker.SetResolver(resolver)
+	return checker
+}
+
+func TestDNSBLChecker_CheckIP(t *testing.T) {
+	checker := newTestDNSBLChecker(&fakeResolver{hosts: map[string][]string{
+		"4.3.2.192.zen.example.net": {"127.0.0.2"},
+	}})
+
+	results := checker.CheckIP(context.Background(), "192.2.3.4")
+	if len(results) != 2 {
+		t.Fatalf("Expected one result per provider, got %d", len(results))
+	}
+	for _, r := range results {
+		wantListed := r.Provider == "zen.example.net"
+		if r.Listed != wantListed || r.Error != nil {
+			t.Errorf("%s: listed=%v err=%v, want listed=%v", r.Provider, r.Listed, r.Error, wantListed)
+		}
+	}
+
+	if results := checker.CheckIP(context.Background(), "192.0.2.99"); results[0].Listed || results[1].Listed {
+		t.Error("Unlisted IP reported as listed")
+	}
+
+	checks, hits, providers := checker.GetStats()
+	if checks != 2 || hits != 1 || providers["zen.example.net"] != 1 {
+		t.Errorf("stats: checks=%d hits=%d providers=%v", checks, hits, providers)
+	}
+}
+
+func TestDNSBLChecker_CheckDomain(t *testing.T) {
+	checker := newTestDNSBLChecker(&fakeResolver{hosts: map[string][]string{
+		"spam.example.dbl.example.net": {"127.0.1.2"},
+	}})
+
+	listed := 0
+	for _, r := range checker.CheckDomain(context.Background(), "spam.example") {
+		if r.Listed {
+			listed++
+			if r.Provider != "dbl.example.net" || r.ResponseCodes[0] != "127.0.1.2" {
+				t.Errorf("unexpected hit %+v", r)
+			}
+		}
+	}
+	if listed != 1 {
+		t.Errorf("Expected 1 listing for spam.example, got %d", listed)
+	}
+
+	for _, r := range checker.CheckDomain(context.Background(), "clean.example") {
+		if r.Listed {
+			t.Errorf("clean.example listed by %s", r.Provider)
+		}
+	}
+}
+
+// slowResolver lists every query after a per-provider delay
+type slowResolver struct {
+	fakeResolver
+	delays map[string]time.Duration // provider zone -> latency
+}
+
+func (r *slowResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	for zone, delay := range r.delays {
+		if strings.HasSuffix(host, "."+zone) {
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+	}
+	return []string{"127.0.0.2"}, nil
+}
+
+func TestDNSBLChecker_ParallelProviders(t *testing.T) {
+	providers := []string{"slow.example.net", "fast.example.net", "medium.example.net"}
+	checker := NewDNSBLChecker(&config.DNSBLConfig{
+		Enabled:   true,
+		CheckIP:   true,
+		Providers: providers,
+		Action:    "reject",
+	})
+	checker.SetResolver(&slowResolver{delays: map[string]time.Duration{
+		"slow.example.net":   300 * time.Millisecond,
+		"fast.example.net":   10 * time.Millisecond,
+		"medium.example.net": 150 * time.Millisecond,
+	}})
+
+	start := time.Now()
+	results := checker.CheckIP(context.Background(), "192.0.2.1")
+	elapsed := time.Since(start)
+
+	// Sequential lookups would take the sum, 460ms
+	if elapsed >= 450*time.Millisecond {
+		t.Errorf("CheckIP took %v, want close to the slowest provider (300ms)", elapsed)
+	}
+	if len(results) != len(providers) {
+		t.Fatalf("Expected %d results, got %d", len(providers), len(results))
+	}
+	for i, r := range results {
+		if r.Provider != providers[i] || !r.Listed {
+			t.Errorf("result %d: provider=%s listed=%v, want %s listed", i, r.Provider, r.Listed, providers[i])
+		}
+	}
+
+	_, hits, providerHits := checker.GetStats()
+	if hits != 3 {
+		t.Errorf("hits = %d, want 3", hits)
+	}
+	for _, provider := range providers {
+		if providerHits[provider] != 1 {
+			t.Errorf("%s hits = %d, want 1", provider, providerHits[provider])
+		}
+	}
+}
+
+// cancelTrackingResolver lists queries under listed zones at once and blocks
+// the rest until their context ends, recording the cancellations
+type cancelTrackingResolver struct {
+	fakeResolver
+	listed    string
+	cancelled chan string
+}
+
+func (r *cancelTrackingResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if strings.HasSuffix(host, "."+r.listed) {
+		return []string{"127.0.0.2"}, nil
+	}
+	select {
+	case <-ctx.Done():
+		r.cancelled <- host
+		return nil, &net.DNSError{Err: ctx.Err().Error(), Name: host, UnwrapErr: ctx.Err()}
+	case <-time.After(5 * time.Second):
+		return nil, notFound(host)
+	}
+}
+
+func TestDNSBLChecker_StopOnFirstHit(t *testing.T) {
+	providers := []string{"slow1.example.net", "fast.example.net", "slow2.example.net"}
+	resolver := &cancelTrackingResolver{listed: "fast.example.net", cancelled: make(chan string, len(providers))}
+	checker := NewDNSBLChecker(&config.DNSBLConfig{
+		Enabled:        true,
+		CheckIP:        true,
+		Providers:      providers,
+		Action:         "reject",
+		StopOnFirstHit: true,
+	})
+	checker.SetResolver(resolver)
+
+	start := time.Now()
+	results := checker.CheckIP(context.Background(), "192.0.2.1")
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("CheckIP took %v, slower lookups should have been cancelled", elapsed)
+	}
+	if len(resolver.cancelled) != 2 {
+		t.Errorf("Expected 2 cancelled lookups, got %d", len(resolver.cancelled))
+	}
+	if len(results) != 1 || results[0].Provider != "fast.example.net" || !results[0].Listed {
+		t.Errorf("Expected only the listing provider in results, got %+v", results)
+	}
+}
+
+func TestDNSBLChecker_StopOnFirstHitIgnoredForLog(t *testing.T) {
+	providers := []string{"fast.example.net", "medium.example.net"}
+	checker := NewDNSBLChecker(&config.DNSBLConfig{
+		Enabled:        true,
+		CheckIP:        true,
+		Providers:      providers,
+		Action:         "log",
+		StopOnFirstHit: true,
+	})
+	checker.SetResolver(&slowResolver{delays: map[string]time.Duration{
+		"fast.example.net":   time.Millisecond,
+		"medium.example.net": 50 * time.Millisecond,
+	}})
+
+	results := checker.CheckIP(context.Background(), "192.0.2.1")
+	if len(results) != 2 || !results[0].Listed || !results[1].Listed {
+		t.Errorf("With action log every provider should be queried, got %+v", results)
+	}
+}
@@ -0,0 +1,116 @@
+package security
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pawciobiel/golubsmtpd/internal/config"
+)
+
+const globalRateKey = "global"
+
+// SubmissionLimiter enforces per-client and global message rate limits over a
+// sliding window. A nil SubmissionLimiter allows everything.
+type SubmissionLimiter struct {
+	config *config.SubmissionRateLimitConfig
+	now    func() time.Time
+
+	mu        sync.Mutex
+	events    map[string][]time.Time // key -> accepted message times, oldest first
+	lastSweep time.Time
+}
+
+// NewSubmissionLimiter creates a limiter for the configured caps
+func NewSubmissionLimiter(cfg *config.SubmissionRateLimitConfig) *SubmissionLimiter {
+	return &SubmissionLimiter{
+		config: cfg,
+		now:    time.Now,
+		events: make(map[string][]time.Time),
+	}
+}
+
+// Enabled reports whether any cap is configured
+func (l *SubmissionLimiter) Enabled() bool {
+	return l.config.PerIP > 0 || l.config.PerUser > 0 || l.config.Global > 0
+}
+
+// Allow reports whether another message from clientIP fits within the limits and,
+// if so, counts it. Authenticated senders (non-empty username) are limited by
+// per_user instead of per_ip when it is set. Rejected messages are not counted.
+func (l *SubmissionLimiter) Allow(clientIP, username string) bool {
+	if l == nil {
+		return true
+	}
+
+	type check struct {
+		key   string
+		limit int
+	}
+	checks := []check{{globalRateKey, l.config.Global}}
+	if username != "" && l.config.PerUser > 0 {
+		checks = append(checks, check{"user:" + username, l.config.PerUser})
+	} else {
+		checks = append(checks, check{"ip:" + clientIP, l.config.PerIP})
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	for _, c := range checks {
+		if c.limit > 0 && len(l.prune(c.key, now)) >= c.limit {
+			return false
+		}
+	}
+	for _, c := range checks {
+		if c.limit > 0 {
+			l.events[c.key] = append(l.events[c.key], now)
+		}
+	}
+	return true
+}
+
+// prune drops events for key that have left the window and returns the rest
+func (l *SubmissionLimiter) prune(key string, now time.Time) []time.Time {
+	events := l.events[key]
+	cutoff := now.Add(-l.config.Window)
+	i := 0
+	for i < len(events) && !events[i].After(cutoff) {
+		i++
+	}
+	if i == len(events) {
+		delete(l.events, key)
+		return nil
+	}
+	l.events[key] = events[i:]
+	return l.events[key]
+}
+
+// Sweep drops the events of every client that has left the window. Allow
+// sweeps lazily; this lets a background task reclaim memory while no mail
+// arrives.
+func (l *SubmissionLimiter) Sweep() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.lastSweep = now
+	for key := range l.events {
+		l.prune(key, now)
+	}
+}
+
+// sweep prunes every key at most once per window so idle clients don't pin memory
+func (l *SubmissionLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.config.Window {
+		return
+	}
+	l.lastSweep = now
+	for key := range l.events {
+		l.prune(key, now)
+	}
+}
@@ -0,0 +1,114 @@
+package security
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pawciobiel/golubsmtpd/internal/config"
+)
+
+// newTestLimiter returns a limiter driven by a manual clock
+func newTestLimiter(cfg config.SubmissionRateLimitConfig) (*SubmissionLimiter, *time.Time) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := NewSubmissionLimiter(&cfg)
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+func TestSubmissionLimiter_PerIP(t *testing.T) {
+	l, now := newTestLimiter(config.SubmissionRateLimitConfig{PerIP: 3, Window: time.Minute})
+
+	for i := range 3 {
+		if !l.Allow("192.0.2.1", "") {
+			t.Fatalf("Message %d should be allowed", i+1)
+		}
+	}
+	if l.Allow("192.0.2.1", "") {
+		t.Error("Fourth message within the window should be rejected")
+	}
+	if !l.Allow("192.0.2.2", "") {
+		t.Error("Other clients must not share the per-IP limit")
+	}
+
+	// Window slides: the first three expire together
+	*now = now.Add(time.Minute + time.Second)
+	if !l.Allow("192.0.2.1", "") {
+		t.Error("Message should be allowed once earlier ones leave the window")
+	}
+}
+
+func TestSubmissionLimiter_SlidingWindow(t *testing.T) {
+	l, now := newTestLimiter(config.SubmissionRateLimitConfig{PerIP: 2, Window: time.Minute})
+
+	l.Allow("192.0.2.1", "")
+	*now = now.Add(40 * time.Second)
+	l.Allow("192.0.2.1", "")
+
+	*now = now.Add(30 * time.Second) // first message now 70s old, second 30s
+	if !l.Allow("192.0.2.1", "") {
+		t.Error("Expected one slot freed by the expired message")
+	}
+	if l.Allow("192.0.2.1", "") {
+		t.Error("Expected limit reached again")
+	}
+}
+
+func TestSubmissionLimiter_PerUserReplacesPerIP(t *testing.T) {
+	l, _ := newTestLimiter(config.SubmissionRateLimitConfig{PerIP: 1, PerUser: 3, Window: time.Minute})
+
+	for i := range 3 {
+		if !l.Allow("192.0.2.1", "alice") {
+			t.Fatalf("Authenticated message %d should be allowed by per_user", i+1)
+		}
+	}
+	if l.Allow("192.0.2.1", "alice") {
+		t.Error("Fourth authenticated message should be rejected")
+	}
+	if !l.Allow("192.0.2.1", "") {
+		t.Error("Unauthenticated traffic from the same IP has its own per-IP budget")
+	}
+}
+
+func TestSubmissionLimiter_Global(t *testing.T) {
+	l, _ := newTestLimiter(config.SubmissionRateLimitConfig{PerIP: 2, Global: 3, Window: time.Minute})
+
+	l.Allow("192.0.2.1", "")
+	l.Allow("192.0.2.1", "")
+	if l.Allow("192.0.2.1", "") {
+		t.Error("Per-IP limit should reject the third message from one client")
+	}
+	if !l.Allow("192.0.2.2", "") {
+		t.Error("Rejected messages must not consume the global budget")
+	}
+	if l.Allow("192.0.2.3", "") {
+		t.Error("Global limit should reject the fourth accepted message")
+	}
+}
+
+func TestSubmissionLimiter_NilAllows(t *testing.T) {
+	var l *SubmissionLimiter
+	if !l.Allow("192.0.2.1", "") {
+		t.Error("Nil limiter must allow everything")
+	}
+}
+
+func TestSubmissionLimiter_Sweep(t *testing.T) {
+	l, now := newTestLimiter(config.SubmissionRateLimitConfig{PerIP: 5, Window: time.Minute})
+
+	for i := range 100 {
+		l.Allow(fmt.Sprintf("198.51.100.%d", i), "")
+	}
+	if len(l.events) != 100 {
+		t.Fatalf("Expected 100 tracked clients, got %d", len(l.events))
+	}
+
+	*now = now.Add(2 * time.Minute)
+	l.Sweep()
+	if len(l.events) != 0 {
+		t.Errorf("Expected sweep to drop expired clients, %d left", len(l.events))
+	}
+
+	var nilLimiter *SubmissionLimiter
+	nilLimiter.Sweep()
+}
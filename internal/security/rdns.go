@@ -2,7 +2,6 @@ package security
 
 import (
 	"context"
-	"net"
 	"sync/atomic"
 	"time"
 
@@ -11,7 +10,8 @@ import (
 
 // RDNSChecker performs reverse DNS lookups with caching
 type RDNSChecker struct {
-	config *config.ReverseDNSConfig
+	config   *config.ReverseDNSConfig
+	resolver Resolver
 
 	// Lock-free counters
 	lookupCount int64
@@ -29,10 +29,16 @@ type RDNSResult struct {
 // NewRDNSChecker creates a new reverse DNS checker
 func NewRDNSChecker(cfg *config.ReverseDNSConfig) *RDNSChecker {
 	return &RDNSChecker{
-		config: cfg,
+		config:   cfg,
+		resolver: DefaultResolver,
 	}
 }
 
+// SetResolver replaces the resolver used for reverse lookups
+func (r *RDNSChecker) SetResolver(resolver Resolver) {
+	r.resolver = resolver
+}
+
 // LookupWithTimeout performs a reverse DNS lookup with timeout
 func (r *RDNSChecker) LookupWithTimeout(ctx context.Context, ip string, timeout time.Duration) *RDNSResult {
 	if !r.config.Enabled {
@@ -51,7 +57,7 @@ func (r *RDNSChecker) LookupWithTimeout(ctx context.Context, ip string, timeout
 	result := &RDNSResult{IP: ip}
 
 	// Perform reverse DNS lookup
-	hostnames, err := net.DefaultResolver.LookupAddr(ctx, ip)
+	hostnames, err := r.resolver.LookupAddr(ctx, ip)
 	if err != nil {
 		atomic.AddInt64(&r.failCount, 1)
 		result.Error = err
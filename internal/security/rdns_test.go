@@ -0,0 +1,39 @@
+package security
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pawciobiel/golubsmtpd/internal/config"
+)
+
+func TestRDNSChecker_Lookup(t *testing.T) {
+	resolver := &fakeResolver{ptrs: map[string][]string{
+		"192.0.2.1": {"mail.example.com."},
+	}}
+
+	tests := []struct {
+		name         string
+		ip           string
+		rejectOnFail bool
+		wantHostname string
+		wantValid    bool
+	}{
+		{"resolves", "192.0.2.1", true, "mail.example.com.", true},
+		{"missing PTR tolerated", "192.0.2.2", false, "", true},
+		{"missing PTR rejected", "192.0.2.2", true, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := NewRDNSChecker(&config.ReverseDNSConfig{Enabled: true, RejectOnFail: tt.rejectOnFail})
+			checker.SetResolver(resolver)
+
+			result := checker.Lookup(context.Background(), tt.ip)
+			if result.Hostname != tt.wantHostname || result.Valid != tt.wantValid {
+				t.Errorf("Lookup(%s) = {Hostname: %q, Valid: %v}, want {%q, %v}",
+					tt.ip, result.Hostname, result.Valid, tt.wantHostname, tt.wantValid)
+			}
+		})
+	}
+}
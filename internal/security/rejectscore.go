@@ -0,0 +1,43 @@
+package security
+
+import (
+	"fmt"
+
+	"github.com/pawciobiel/golubsmtpd/internal/config"
+)
+
+// RejectScorer refuses the transaction once the spam score of its weak
+// signals, which would not justify a rejection on their own, reaches the
+// threshold. The signals are weighted as for spam_score. A nil RejectScorer
+// never rejects.
+type RejectScorer struct {
+	scorer    *SpamScorer
+	threshold float64
+}
+
+// NewRejectScorer creates a scorer for the security settings. Returns nil
+// when reject_score is disabled.
+func NewRejectScorer(cfg *config.SecurityConfig) *RejectScorer {
+	if !cfg.RejectScore.Enabled {
+		return nil
+	}
+	return &RejectScorer{scorer: newSpamScorer(cfg), threshold: cfg.RejectScore.Threshold}
+}
+
+// Check scores the signals and returns the score when it reaches the
+// threshold; nil lets the transaction through
+func (s *RejectScorer) Check(signals ScoreSignals) *SpamScore {
+	if s == nil {
+		return nil
+	}
+	score := s.scorer.Score(signals)
+	if score.Score < s.threshold {
+		return nil
+	}
+	return score
+}
+
+// Reason formats a rejecting score for the 554 reply
+func (s *RejectScorer) Reason(score *SpamScore) string {
+	return fmt.Sprintf("Rejected by policy score %.1f/%.1f: %s", score.Score, s.threshold, score.ReportText())
+}
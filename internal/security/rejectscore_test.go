@@ -0,0 +1,79 @@
+package security
+
+import (
+	"testing"
+
+	"github.com/pawciobiel/golubsmtpd/internal/config"
+)
+
+func TestRejectScorer_Check(t *testing.T) {
+	tests := []struct {
+		name       string
+		signals    ScoreSignals
+		wantReport string // empty when the transaction passes
+	}{
+		{"clean client", ScoreSignals{ReverseDNS: "mail.example.org", HeloHostname: "mail.example.org"}, ""},
+		{"two signals below threshold",
+			ScoreSignals{DNSBLListings: []string{"zen.example.net"}, ReverseDNS: "mail.example.org", HeloHostname: "desktop"}, ""},
+		{"three signals below threshold",
+			ScoreSignals{DNSBLListings: []string{"zen.example.net"}, HeloHostname: "desktop"}, ""},
+		{"four signals over threshold",
+			ScoreSignals{DNSBLListings: []string{"zen.example.net", "dbl.example.net"}, HeloHostname: "desktop"},
+			"DNSBL_zen.example.net=2.0, DNSBL_dbl.example.net=2.0, NO_RDNS=1.0, HELO_NON_FQDN=1.5"},
+		{"helo mismatch and two listings",
+			ScoreSignals{DNSBLListings: []string{"zen.example.net", "dbl.example.net"}, ReverseDNS: "host.example.org", HeloHostname: "mail.example.com"},
+			"DNSBL_zen.example.net=2.0, DNSBL_dbl.example.net=2.0, HELO_MISMATCH=1.0"},
+		{"helo matching rdns up to case and trailing dot",
+			ScoreSignals{DNSBLListings: []string{"zen.example.net", "dbl.example.net"}, ReverseDNS: "Mail.Example.org.", HeloHostname: "mail.example.org"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultConfig().Security // spam_score headers stay disabled
+			cfg.RejectScore.Enabled = true
+			cfg.ReverseDNS.Enabled = true
+
+			score := NewRejectScorer(&cfg).Check(tt.signals)
+			if tt.wantReport == "" {
+				if score != nil {
+					t.Errorf("Check rejected with %.1f: %s", score.Score, score.ReportText())
+				}
+				return
+			}
+			if score == nil {
+				t.Fatal("Check passed, want rejection")
+			}
+			if got := score.ReportText(); got != tt.wantReport {
+				t.Errorf("ReportText = %q, want %q", got, tt.wantReport)
+			}
+		})
+	}
+}
+
+func TestRejectScorer_Disabled(t *testing.T) {
+	cfg := config.DefaultConfig().Security
+	scorer := NewRejectScorer(&cfg)
+	if scorer != nil {
+		t.Fatal("NewRejectScorer should return nil when reject_score is disabled")
+	}
+	if score := scorer.Check(ScoreSignals{DNSBLListings: []string{"a", "b", "c"}}); score != nil {
+		t.Errorf("disabled scorer rejected with %+v", score)
+	}
+}
+
+func TestRejectScorer_Reason(t *testing.T) {
+	cfg := config.DefaultConfig().Security
+	cfg.RejectScore.Enabled = true
+	cfg.RejectScore.Threshold = 3.0
+	cfg.ReverseDNS.Enabled = false
+	scorer := NewRejectScorer(&cfg)
+
+	score := scorer.Check(ScoreSignals{DNSBLListings: []string{"zen.example.net"}, HeloHostname: "desktop"})
+	if score == nil {
+		t.Fatal("Expected rejection at threshold 3.0")
+	}
+	want := "Rejected by policy score 3.5/3.0: DNSBL_zen.example.net=2.0, HELO_NON_FQDN=1.5"
+	if got := scorer.Reason(score); got != want {
+		t.Errorf("Reason = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,18 @@
+package security
+
+import (
+	"context"
+	"net"
+)
+
+// Resolver is the subset of *net.Resolver used by DNS-dependent checks, so tests
+// can substitute a fake instead of hitting the network
+type Resolver interface {
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+}
+
+// DefaultResolver is the production resolver used unless one is injected
+var DefaultResolver Resolver = net.DefaultResolver
@@ -0,0 +1,93 @@
+package security
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pawciobiel/golubsmtpd/internal/config"
+)
+
+// SpamScore is the weighted sum of the signals that fired for one message
+type SpamScore struct {
+	Score  float64
+	Report []string // one "RULE=weight" entry per signal that fired, in rule order
+}
+
+// ReportText joins the report entries for a header value; "none" when no
+// signal fired
+func (s *SpamScore) ReportText() string {
+	if len(s.Report) == 0 {
+		return "none"
+	}
+	return strings.Join(s.Report, ", ")
+}
+
+// ScoreSignals is what is known about a client when it is scored
+type ScoreSignals struct {
+	DNSBLListings []string // providers listing the client IP or sender domain
+	ReverseDNS    string   // client hostname, empty if unknown
+	HeloHostname  string   // name given with HELO/EHLO, empty if not yet given
+}
+
+// SpamScorer weights DNSBL listings, missing reverse DNS and suspicious HELO
+// names into a score that downstream filters can act on. A nil SpamScorer
+// scores nothing.
+type SpamScorer struct {
+	config     *config.SpamScoreConfig
+	rdnsActive bool // reverse DNS lookups run, so an empty hostname means none was found
+}
+
+// NewSpamScorer creates a scorer for the security settings. Returns nil when
+// scoring is disabled.
+func NewSpamScorer(cfg *config.SecurityConfig) *SpamScorer {
+	if !cfg.SpamScore.Enabled {
+		return nil
+	}
+	return newSpamScorer(cfg)
+}
+
+func newSpamScorer(cfg *config.SecurityConfig) *SpamScorer {
+	return &SpamScorer{config: &cfg.SpamScore, rdnsActive: cfg.ReverseDNS.Enabled}
+}
+
+// Score computes the score for a client from its signals
+func (s *SpamScorer) Score(signals ScoreSignals) *SpamScore {
+	if s == nil {
+		return nil
+	}
+
+	score := &SpamScore{}
+	if s.config.DNSBLWeight != 0 {
+		for _, provider := range signals.DNSBLListings {
+			score.add(fmt.Sprintf("DNSBL_%s", provider), s.config.DNSBLWeight)
+		}
+	}
+	if s.config.NoRDNSWeight != 0 && s.rdnsActive && signals.ReverseDNS == "" {
+		score.add("NO_RDNS", s.config.NoRDNSWeight)
+	}
+
+	helo := strings.TrimSuffix(signals.HeloHostname, ".")
+	if helo != "" && !strings.Contains(helo, ".") {
+		if s.config.HeloNonFQDNWeight != 0 {
+			score.add("HELO_NON_FQDN", s.config.HeloNonFQDNWeight)
+		}
+	} else if s.config.HeloMismatchWeight != 0 && helo != "" && signals.ReverseDNS != "" &&
+		!strings.EqualFold(helo, strings.TrimSuffix(signals.ReverseDNS, ".")) {
+		score.add("HELO_MISMATCH", s.config.HeloMismatchWeight)
+	}
+	return score
+}
+
+// Headers formats the score and report as header fields named by the config
+func (s *SpamScorer) Headers(score *SpamScore) string {
+	if s == nil || score == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s: %.1f\r\n%s: %s\r\n",
+		s.config.ScoreHeader, score.Score, s.config.ReportHeader, score.ReportText())
+}
+
+func (s *SpamScore) add(rule string, weight float64) {
+	s.Score += weight
+	s.Report = append(s.Report, fmt.Sprintf("%s=%.1f", rule, weight))
+}
@@ -0,0 +1,68 @@
+package security
+
+import (
+	"testing"
+
+	"github.com/pawciobiel/golubsmtpd/internal/config"
+)
+
+func TestSpamScorer_Score(t *testing.T) {
+	tests := []struct {
+		name       string
+		rdns       bool
+		listings   []string
+		reverseDNS string
+		helo       string
+		wantScore  float64
+		wantReport string
+	}{
+		{"clean client", true, nil, "mail.example.org", "mail.example.org", 0, "none"},
+		{"no rdns", true, nil, "", "", 1.0, "NO_RDNS=1.0"},
+		{"rdns disabled", false, nil, "", "", 0, "none"},
+		{"one listing", true, []string{"zen.example.net"}, "mail.example.org", "", 2.0, "DNSBL_zen.example.net=2.0"},
+		{"listings and no rdns", true, []string{"zen.example.net", "dbl.example.net"}, "", "", 5.0,
+			"DNSBL_zen.example.net=2.0, DNSBL_dbl.example.net=2.0, NO_RDNS=1.0"},
+		{"helo not fully qualified", true, nil, "mail.example.org", "desktop", 1.5, "HELO_NON_FQDN=1.5"},
+		{"helo mismatch", true, nil, "host.example.org", "mail.example.com", 1.0, "HELO_MISMATCH=1.0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultConfig().Security
+			cfg.SpamScore.Enabled = true
+			cfg.ReverseDNS.Enabled = tt.rdns
+
+			score := NewSpamScorer(&cfg).Score(ScoreSignals{DNSBLListings: tt.listings, ReverseDNS: tt.reverseDNS, HeloHostname: tt.helo})
+			if score.Score != tt.wantScore {
+				t.Errorf("Score = %v, want %v", score.Score, tt.wantScore)
+			}
+			if got := score.ReportText(); got != tt.wantReport {
+				t.Errorf("ReportText = %q, want %q", got, tt.wantReport)
+			}
+		})
+	}
+}
+
+func TestSpamScorer_Disabled(t *testing.T) {
+	cfg := config.DefaultConfig().Security
+	scorer := NewSpamScorer(&cfg)
+	if scorer != nil {
+		t.Fatal("NewSpamScorer should return nil when spam_score is disabled")
+	}
+	if headers := scorer.Headers(scorer.Score(ScoreSignals{DNSBLListings: []string{"zen.example.net"}})); headers != "" {
+		t.Errorf("disabled scorer added headers %q", headers)
+	}
+}
+
+func TestSpamScorer_HeaderNames(t *testing.T) {
+	cfg := config.DefaultConfig().Security
+	cfg.SpamScore.Enabled = true
+	cfg.SpamScore.ScoreHeader = "X-Spam-Score"
+	cfg.SpamScore.ReportHeader = "X-Spam-Report"
+	scorer := NewSpamScorer(&cfg)
+
+	want := "X-Spam-Score: 1.0\r\nX-Spam-Report: NO_RDNS=1.0\r\n"
+	if got := scorer.Headers(scorer.Score(ScoreSignals{})); got != want {
+		t.Errorf("Headers = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,60 @@
+package security
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// UserSessionLimiter caps concurrent authenticated sessions per username,
+// independent of the client IP. A nil UserSessionLimiter allows everything.
+type UserSessionLimiter struct {
+	max      int
+	sessions sync.Map // map[string]*int64 - username -> active session count
+}
+
+// NewUserSessionLimiter creates a limiter allowing max concurrent sessions per
+// user; max <= 0 returns nil (unlimited)
+func NewUserSessionLimiter(max int) *UserSessionLimiter {
+	if max <= 0 {
+		return nil
+	}
+	return &UserSessionLimiter{max: max}
+}
+
+// Acquire counts a new session for username and reports whether it fits within
+// the cap. A refused session is not counted and must not be released.
+func (l *UserSessionLimiter) Acquire(username string) bool {
+	if l == nil {
+		return true
+	}
+	val, _ := l.sessions.LoadOrStore(username, new(int64))
+	if atomic.AddInt64(val.(*int64), 1) > int64(l.max) {
+		l.Release(username)
+		return false
+	}
+	return true
+}
+
+// Release ends a session previously admitted by Acquire
+func (l *UserSessionLimiter) Release(username string) {
+	if l == nil {
+		return
+	}
+	if val, ok := l.sessions.Load(username); ok {
+		// Clean up if count reaches zero
+		if atomic.AddInt64(val.(*int64), -1) <= 0 {
+			l.sessions.Delete(username)
+		}
+	}
+}
+
+// Active returns the number of sessions currently held by username
+func (l *UserSessionLimiter) Active(username string) int {
+	if l == nil {
+		return 0
+	}
+	if val, ok := l.sessions.Load(username); ok {
+		return int(atomic.LoadInt64(val.(*int64)))
+	}
+	return 0
+}
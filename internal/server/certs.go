@@ -0,0 +1,65 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+
+	"github.com/pawciobiel/golubsmtpd/internal/config"
+)
+
+// certificateStore serves the current certificate to TLS handshakes and lets
+// it be swapped while the server runs, so renewed certificates (e.g. by ACME)
+// take effect without a restart
+type certificateStore struct {
+	cfg  *config.TLSConfig
+	cert atomic.Pointer[tls.Certificate]
+}
+
+// newCertificateStore loads the configured certificate, failing if it is unusable
+func newCertificateStore(cfg *config.TLSConfig) (*certificateStore, error) {
+	store := &certificateStore{cfg: cfg}
+	if err := store.reload(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// reload re-reads the certificate, key and optional OCSP staple. On error the
+// previous certificate stays in use.
+func (s *certificateStore) reload() error {
+	cert, err := tls.LoadX509KeyPair(s.cfg.CertFile, s.cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	if s.cfg.OCSPStapleFile != "" {
+		staple, err := os.ReadFile(s.cfg.OCSPStapleFile)
+		if err != nil {
+			return fmt.Errorf("failed to read OCSP staple: %w", err)
+		}
+		cert.OCSPStaple = staple
+	}
+	s.cert.Store(&cert)
+	return nil
+}
+
+// getCertificate implements tls.Config.GetCertificate
+func (s *certificateStore) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return s.cert.Load(), nil
+}
+
+// ReloadTLS re-reads the TLS certificate and key files; new handshakes use the
+// new certificate while established sessions are unaffected
+func (srv *Server) ReloadTLS() error {
+	if srv.certs == nil {
+		return errors.New("TLS is not enabled")
+	}
+	if err := srv.certs.reload(); err != nil {
+		log().Error("TLS certificate reload failed, keeping the current certificate", "error", err)
+		return err
+	}
+	log().Info("TLS certificate reloaded", "cert_file", srv.config.TLS.CertFile)
+	return nil
+}
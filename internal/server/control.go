@@ -0,0 +1,203 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"maps"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/pawciobiel/golubsmtpd/internal/auth"
+	"github.com/pawciobiel/golubsmtpd/internal/queue"
+)
+
+// startControlListener creates the admin control socket. It speaks a line
+// protocol: each command is answered by zero or more data lines followed by a
+// final line starting with OK or ERR.
+func (srv *Server) startControlListener(ctx context.Context) error {
+	socketPath := srv.config.Server.ControlSocketPath
+	if socketPath == "" {
+		log().Debug("Control socket disabled (no control_socket_path configured)")
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(socketPath), 0o755); err != nil {
+		return fmt.Errorf("failed to create control socket directory: %w", err)
+	}
+	if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove existing control socket: %w", err)
+	}
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return fmt.Errorf("failed to create control socket at %s: %w", socketPath, err)
+	}
+
+	// Owner only: the control socket can stop the server
+	if err := os.Chmod(socketPath, 0o600); err != nil {
+		listener.Close()
+		return fmt.Errorf("failed to set control socket permissions: %w", err)
+	}
+
+	srv.controlListen = listener
+	log().Info("Control socket listener started", "socket_path", socketPath)
+
+	srv.wg.Add(1)
+	go srv.controlAcceptLoop(ctx)
+
+	return nil
+}
+
+// controlAcceptLoop accepts connections on the control socket
+func (srv *Server) controlAcceptLoop(ctx context.Context) {
+	defer srv.wg.Done()
+	defer func() {
+		srv.controlListen.Close()
+		os.Remove(srv.config.Server.ControlSocketPath)
+	}()
+
+	for {
+		conn, err := srv.controlListen.Accept()
+		if err != nil {
+			select {
+			case <-srv.shutdown:
+				return
+			default:
+				log().Error("Failed to accept control connection", "error", err)
+				continue
+			}
+		}
+
+		srv.wg.Add(1)
+		go srv.handleControlConnection(ctx, conn)
+	}
+}
+
+// handleControlConnection serves control commands until the client disconnects
+// or the server shuts down
+func (srv *Server) handleControlConnection(ctx context.Context, conn net.Conn) {
+	defer srv.wg.Done()
+	defer conn.Close()
+
+	// Unblock the read below when the server stops
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-srv.shutdown:
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.TrimSpace(line)
+		if command == "" {
+			continue
+		}
+
+		response, closeConn := srv.handleControlCommand(ctx, command)
+		if _, err := conn.Write([]byte(strings.Join(response, "\n") + "\n")); err != nil {
+			return
+		}
+		if closeConn {
+			return
+		}
+	}
+}
+
+// handleControlCommand executes one control command and returns its response
+// lines and whether the connection should be closed afterwards
+func (srv *Server) handleControlCommand(ctx context.Context, command string) ([]string, bool) {
+	verb, arg, _ := strings.Cut(command, " ")
+	arg = strings.TrimSpace(arg)
+
+	switch strings.ToUpper(verb) {
+	case "STATS":
+		depth, inFlight, published, delivered, failed := srv.queue.Stats()
+		stats := fmt.Sprintf("OK depth=%d in_flight=%d published=%d delivered=%d failed=%d connections=%d",
+			depth, inFlight, published, delivered, failed, atomic.LoadInt64(&srv.totalConnections))
+		bp := srv.queue.Backpressure()
+		stats += fmt.Sprintf(" sem_waits=%d sem_wait_ms=%d publish_retries=%d publish_rejected=%d",
+			bp.SemaphoreWaits, bp.SemaphoreWaitTime.Milliseconds(), bp.PublishRetries, bp.PublishRejected)
+		if srv.smtpDeps != nil && srv.smtpDeps.RcptValidator != nil {
+			cache := srv.smtpDeps.RcptValidator.CacheStats()
+			stats += fmt.Sprintf(" system_cache=%d/%d system_hit_rate=%.2f virtual_cache=%d/%d virtual_hit_rate=%.2f",
+				cache.System.Size, cache.System.Capacity, cache.System.HitRate,
+				cache.Virtual.Size, cache.Virtual.Capacity, cache.Virtual.HitRate)
+		}
+		if srv.smtpDeps != nil && srv.smtpDeps.DNSCache != nil {
+			dns := srv.smtpDeps.DNSCache.Snapshot()
+			stats += fmt.Sprintf(" dns_cache=%d/%d dns_hit_rate=%.2f", dns.Size, dns.Capacity, dns.HitRate)
+		}
+		if chain, ok := srv.authenticator.(*auth.AuthChain); ok {
+			pluginStats := chain.PerPluginStats()
+			for _, name := range slices.Sorted(maps.Keys(pluginStats)) {
+				stats += fmt.Sprintf(" auth_%s_attempts=%d auth_%s_successes=%d",
+					name, pluginStats[name].Attempts, name, pluginStats[name].Successes)
+			}
+		}
+		return []string{stats}, false
+
+	case "LIST":
+		if arg == "" {
+			return []string{"ERR usage: LIST <state>"}, false
+		}
+		ids, err := queue.ListMessageIDs(queue.NewSpool(srv.config), queue.MessageState(strings.ToLower(arg)))
+		if err != nil {
+			return []string{"ERR " + err.Error()}, false
+		}
+		return append(ids, fmt.Sprintf("OK %d", len(ids))), false
+
+	case "FLUSH":
+		flushed, err := srv.queue.Flush(ctx)
+		if err != nil {
+			return []string{fmt.Sprintf("ERR %d flushed: %v", flushed, err)}, false
+		}
+		return []string{fmt.Sprintf("OK %d flushed", flushed)}, false
+
+	case "RELOAD":
+		switch strings.ToLower(arg) {
+		case "tls":
+			if err := srv.ReloadTLS(); err != nil {
+				return []string{"ERR " + err.Error()}, false
+			}
+			return []string{"OK tls reloaded"}, false
+		case "access":
+			if err := srv.ReloadAccessMaps(ctx); err != nil {
+				return []string{"ERR " + err.Error()}, false
+			}
+			return []string{"OK access maps reloaded"}, false
+		case "auth":
+			if err := srv.ReloadAuth(ctx); err != nil {
+				return []string{"ERR " + err.Error()}, false
+			}
+			return []string{"OK auth reloaded"}, false
+		}
+		return []string{"ERR usage: RELOAD tls|access|auth"}, false
+
+	case "SHUTDOWN":
+		log().Info("Shutdown requested via control socket")
+		srv.shutdownOnce.Do(func() { close(srv.shutdownRequested) })
+		return []string{"OK shutting down"}, true
+
+	default:
+		return []string{"ERR unknown command"}, false
+	}
+}
+
+// ShutdownRequested is closed when a graceful stop is requested over the
+// control socket; the caller is expected to call Stop
+func (srv *Server) ShutdownRequested() <-chan struct{} {
+	return srv.shutdownRequested
+}
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pawciobiel/golubsmtpd/internal/auth"
+	"github.com/pawciobiel/golubsmtpd/internal/config"
+	"github.com/pawciobiel/golubsmtpd/internal/delivery"
+	"github.com/pawciobiel/golubsmtpd/internal/queue"
+)
+
+// newControlTestServer starts a control socket backed by an unconsumed queue
+func newControlTestServer(t *testing.T) *Server {
+	t.Helper()
+
+	cfg := config.DefaultConfig()
+	cfg.Server.SpoolDir = t.TempDir()
+	cfg.Server.ControlSocketPath = filepath.Join(t.TempDir(), "control.sock")
+	if err := queue.InitializeSpoolDirectories(cfg.Server.SpoolDir); err != nil {
+		t.Fatalf("Failed to initialize spool: %v", err)
+	}
+
+	q, err := queue.NewQueue(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("NewQueue failed: %v", err)
+	}
+
+	srv := &Server{
+		config:            cfg,
+		shutdown:          make(chan struct{}),
+		shutdownRequested: make(chan struct{}),
+		queue:             q,
+	}
+	if err := srv.startControlListener(context.Background()); err != nil {
+		t.Fatalf("startControlListener failed: %v", err)
+	}
+	t.Cleanup(func() {
+		close(srv.shutdown)
+		srv.controlListen.Close()
+		srv.wg.Wait()
+	})
+	return srv
+}
+
+// controlClient sends one command per call and reads the response up to the OK/ERR line
+type controlClient struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dialControl(t *testing.T, srv *Server) *controlClient {
+	t.Helper()
+	conn, err := net.Dial("unix", srv.config.Server.ControlSocketPath)
+	if err != nil {
+		t.Fatalf("Failed to dial control socket: %v", err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	t.Cleanup(func() { conn.Close() })
+	return &controlClient{t: t, conn: conn, reader: bufio.NewReader(conn)}
+}
+
+func (c *controlClient) do(command string) []string {
+	c.t.Helper()
+	if _, err := c.conn.Write([]byte(command + "\r\n")); err != nil {
+		c.t.Fatalf("Failed to send %q: %v", command, err)
+	}
+	var lines []string
+	for {
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			c.t.Fatalf("Failed to read response to %q: %v", command, err)
+		}
+		line = strings.TrimRight(line, "\n")
+		lines = append(lines, line)
+		if strings.HasPrefix(line, "OK") || strings.HasPrefix(line, "ERR") {
+			return lines
+		}
+	}
+}
+
+func TestControlSocket_Protocol(t *testing.T) {
+	srv := newControlTestServer(t)
+	spool := queue.NewSpool(srv.config)
+
+	// A deferred outbound message: spooled in failed/ with pending retry state
+	deferred := &queue.Message{ID: queue.GenerateID(), Created: time.Now().UTC(), RawBody: "Subject: x\r\n\r\nbody\r\n"}
+	if err := queue.WriteRawBody(spool, deferred); err != nil {
+		t.Fatalf("Failed to spool message: %v", err)
+	}
+	if err := queue.MoveMessage(spool, deferred, queue.MessageStateIncoming, queue.MessageStateFailed); err != nil {
+		t.Fatalf("Failed to move message: %v", err)
+	}
+	state := delivery.NewRetryState(deferred.ID, "sender@example.com", time.Hour, []string{"bob@remote.example"})
+	if err := delivery.SaveRetryState(spool, state); err != nil {
+		t.Fatalf("Failed to save retry state: %v", err)
+	}
+
+	client := dialControl(t, srv)
+
+	tests := []struct {
+		command string
+		want    []string
+	}{
+		{"STATS", []string{"OK depth=0 in_flight=0 published=0 delivered=0 failed=0 connections=0 sem_waits=0 sem_wait_ms=0 publish_retries=0 publish_rejected=0"}},
+		{"LIST failed", []string{deferred.ID, "OK 1"}},
+		{"list RETRY", []string{deferred.ID, "OK 1"}},
+		{"LIST delivered", []string{"OK 0"}},
+		{"LIST", []string{"ERR usage: LIST <state>"}},
+		{"LIST bogus", []string{`ERR unknown spool state "bogus"`}},
+		{"FLUSH", []string{"OK 1 flushed"}},
+		{"STATS", []string{"OK depth=1 in_flight=0 published=1 delivered=0 failed=0 connections=0 sem_waits=0 sem_wait_ms=0 publish_retries=0 publish_rejected=0"}},
+		{"LIST incoming", []string{deferred.ID, "OK 1"}},
+		{"LIST failed", []string{"OK 0"}},
+		{"NOOP", []string{"ERR unknown command"}},
+	}
+
+	for _, tt := range tests {
+		got := client.do(tt.command)
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("%s: got %q, want %q", tt.command, got, tt.want)
+		}
+	}
+}
+
+func TestControlSocket_StatsAuthPlugins(t *testing.T) {
+	srv := newControlTestServer(t)
+	chain, err := auth.NewAuthChainFromConfig(context.Background(), &config.AuthConfig{
+		PluginChain: []string{"memory"},
+		Plugins: map[string]map[string]interface{}{
+			"memory": {
+				"users": []interface{}{
+					map[string]interface{}{"username": "alice", "password": "secret"},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create auth chain: %v", err)
+	}
+	defer chain.Close()
+	chain.Authenticate(context.Background(), "alice", "secret")
+	chain.Authenticate(context.Background(), "alice", "wrong")
+	srv.authenticator = chain
+
+	got := dialControl(t, srv).do("STATS")
+	if len(got) != 1 || !strings.HasSuffix(got[0], " auth_memory_attempts=2 auth_memory_successes=1") {
+		t.Errorf("STATS = %q, want per-plugin auth counters", got)
+	}
+}
+
+func TestControlSocket_Shutdown(t *testing.T) {
+	srv := newControlTestServer(t)
+
+	info, err := os.Stat(srv.config.Server.ControlSocketPath)
+	if err != nil {
+		t.Fatalf("Control socket missing: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("Control socket permissions = %o, want 600", perm)
+	}
+
+	client := dialControl(t, srv)
+	if got := client.do("SHUTDOWN"); len(got) != 1 || got[0] != "OK shutting down" {
+		t.Fatalf("SHUTDOWN: got %q", got)
+	}
+
+	select {
+	case <-srv.ShutdownRequested():
+	case <-time.After(5 * time.Second):
+		t.Fatal("ShutdownRequested not signalled")
+	}
+
+	// The connection is closed after SHUTDOWN
+	if _, err := client.reader.ReadString('\n'); err == nil {
+		t.Error("Expected control connection to be closed after SHUTDOWN")
+	}
+}
@@ -3,9 +3,13 @@ package server
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"net"
 	"net/textproto"
+	"os"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -23,8 +27,25 @@ var log = logging.GetLogger
 
 const (
 	UnknownClientIP = "unknown"
+
+	// rejectReplyTimeout bounds the reply to a refused client so it cannot stall the accept loop
+	rejectReplyTimeout = time.Second
+
+	// tlsHandshakeTimeout bounds the implicit TLS handshake before the SMTP banner
+	tlsHandshakeTimeout = 10 * time.Second
 )
 
+// rdnsLookup is the subset of security.RDNSChecker used for connection checks
+type rdnsLookup interface {
+	Lookup(ctx context.Context, ip string) *security.RDNSResult
+}
+
+// dnsblCheck is the subset of security.DNSBLChecker used for connection checks
+type dnsblCheck interface {
+	CheckIP(ctx context.Context, ip string) []*security.DNSBLResult
+	ShouldReject() bool
+}
+
 type Server struct {
 	config       *config.Config
 	listeners    []net.Listener // one per configured listener (TCP)
@@ -32,17 +53,27 @@ type Server struct {
 	wg           sync.WaitGroup
 	shutdown     chan struct{}
 
+	// Admin control socket (nil if disabled)
+	controlListen     net.Listener
+	shutdownRequested chan struct{} // closed by the SHUTDOWN control command
+	shutdownOnce      sync.Once
+
 	// TLS configuration (nil if TLS disabled)
 	tlsConfig *tls.Config
+	certs     *certificateStore // reloadable certificate behind tlsConfig
 
 	// Security checkers
-	rdnsChecker  *security.RDNSChecker
-	dnsblChecker *security.DNSBLChecker
+	rdnsChecker  rdnsLookup
+	dnsblChecker dnsblCheck
+	allowlist    []*net.IPNet // parsed Security.Allowlist, exempt from rDNS/DNSBL
+	blocklist    []*net.IPNet // parsed Security.Blocklist, rejected on accept
 
 	// Authentication
 	authenticator auth.Authenticator
 
 	localAliasesMaps *aliases.LocalAliasesMaps
+	senderAccess     *aliases.AccessMaps
+	recipientAccess  *aliases.AccessMaps
 
 	// Message queue
 	queue *queue.Queue
@@ -55,47 +86,168 @@ type Server struct {
 	ipConnections    sync.Map // map[string]*int64 - IP -> connection count
 }
 
-func New(cfg *config.Config, authenticator auth.Authenticator, localAliasesMaps *aliases.LocalAliasesMaps) *Server {
+func New(cfg *config.Config, authenticator auth.Authenticator, localAliasesMaps *aliases.LocalAliasesMaps, canonicalMaps *aliases.CanonicalMaps) *Server {
+	dnsblChecker := security.NewDNSBLChecker(&cfg.Security.DNSBL)
+	senderAccess := aliases.NewAccessMaps("sender_access", cfg.Server.SenderAccessFilePath)
+	recipientAccess := aliases.NewAccessMaps("recipient_access", cfg.Server.RecipientAccessFilePath)
 	smtpDeps := &smtp.Dependencies{
 		Authenticator:    authenticator,
 		LocalAliasesMaps: localAliasesMaps,
+		DNSBLChecker:     dnsblChecker,
+		CanonicalMaps:    canonicalMaps,
+		SenderAccess:     senderAccess,
+		RecipientAccess:  recipientAccess,
+		SpamScorer:       security.NewSpamScorer(&cfg.Security),
+		RejectScorer:     security.NewRejectScorer(&cfg.Security),
+	}
+	if cfg.Auth.OAuth2.IntrospectionURL != "" {
+		smtpDeps.TokenValidator = auth.NewIntrospectionValidator(&cfg.Auth.OAuth2)
 	}
+	if limiter := security.NewSubmissionLimiter(&cfg.Security.SubmissionRateLimit); limiter.Enabled() {
+		smtpDeps.SubmissionLimit = limiter
+	}
+	smtpDeps.UserSessions = security.NewUserSessionLimiter(cfg.Server.MaxSessionsPerUser)
+	smtpDeps.RcptValidator = smtp.NewRcptValidator(cfg, authenticator, localAliasesMaps)
+	smtpDeps.DNSCache = smtp.NewDNSCache(&cfg.Cache.DNS)
 
 	return &Server{
-		config:           cfg,
-		shutdown:         make(chan struct{}),
-		rdnsChecker:      security.NewRDNSChecker(&cfg.Security.ReverseDNS),
-		dnsblChecker:     security.NewDNSBLChecker(&cfg.Security.DNSBL),
-		authenticator:    authenticator,
-		localAliasesMaps: localAliasesMaps,
-		smtpDeps:         smtpDeps,
+		config:            cfg,
+		shutdown:          make(chan struct{}),
+		shutdownRequested: make(chan struct{}),
+		rdnsChecker:       security.NewRDNSChecker(&cfg.Security.ReverseDNS),
+		dnsblChecker:      dnsblChecker,
+		authenticator:     authenticator,
+		localAliasesMaps:  localAliasesMaps,
+		senderAccess:      senderAccess,
+		recipientAccess:   recipientAccess,
+		smtpDeps:          smtpDeps,
 	}
 }
 
-// loadTLSConfig loads the TLS configuration from cert/key files
-func loadTLSConfig(cfg *config.TLSConfig) (*tls.Config, error) {
-	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+// loadTLSConfig builds the TLS configuration; certificates are served from the
+// returned store so they can be reloaded at runtime
+func loadTLSConfig(cfg *config.TLSConfig) (*tls.Config, *certificateStore, error) {
+	certs, err := newCertificateStore(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	minVersion, err := cfg.TLSMinVersion()
+	if err != nil {
+		return nil, nil, err
+	}
+	cipherSuites, err := cfg.CipherSuiteIDs()
 	if err != nil {
-		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+		return nil, nil, err
+	}
+	tlsCfg := &tls.Config{
+		GetCertificate: certs.getCertificate,
+		MinVersion:     minVersion,
+		CipherSuites:   cipherSuites,
+	}
+
+	// Ask for (but don't require) client certificates for AUTH EXTERNAL
+	if cfg.ClientCAFile != "" {
+		caPEM, err := os.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read TLS client CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, nil, fmt.Errorf("no certificates found in TLS client CA file %s", cfg.ClientCAFile)
+		}
+		tlsCfg.ClientCAs = pool
+		tlsCfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return tlsCfg, certs, nil
+}
+
+// CheckTLSConfig loads the certificates and client CA file as Start does,
+// without serving; used by -check
+func CheckTLSConfig(cfg *config.TLSConfig) error {
+	_, _, err := loadTLSConfig(cfg)
+	return err
+}
+
+// ReloadAccessMaps re-reads both access map files; a map that fails to load
+// keeps its previous entries without holding back the other
+func (srv *Server) ReloadAccessMaps(ctx context.Context) error {
+	if srv.senderAccess == nil || srv.recipientAccess == nil {
+		return errors.New("access maps are not configured")
+	}
+	var errs []error
+	for _, am := range []*aliases.AccessMaps{srv.senderAccess, srv.recipientAccess} {
+		if err := am.LoadAccessMaps(ctx); err != nil {
+			log().Error("Access map reload failed, keeping the current entries", "error", err)
+			errs = append(errs, err)
+		}
 	}
-	return &tls.Config{
-		Certificates: []tls.Certificate{cert},
-		MinVersion:   tls.VersionTLS12,
-	}, nil
+	return errors.Join(errs...)
+}
+
+// ReloadAuth refreshes authenticators that cache their users, such as the
+// preloaded file plugin; on error the previous users stay in use
+func (srv *Server) ReloadAuth(ctx context.Context) error {
+	reloader, ok := srv.authenticator.(auth.Reloader)
+	if !ok {
+		return errors.New("authenticator does not support reloading")
+	}
+	if err := reloader.Reload(ctx); err != nil {
+		log().Error("Auth reload failed, keeping the current users", "error", err)
+		return err
+	}
+	return nil
 }
 
 func (srv *Server) Start(ctx context.Context) error {
+	allowlist, err := security.ParseCIDRs(srv.config.Security.Allowlist)
+	if err != nil {
+		return fmt.Errorf("invalid security allowlist: %w", err)
+	}
+	srv.allowlist = allowlist
+
+	blocklist, err := security.ParseCIDRs(srv.config.Security.Blocklist)
+	if err != nil {
+		return fmt.Errorf("invalid security blocklist: %w", err)
+	}
+	srv.blocklist = blocklist
+
+	trusted, err := security.ParseCIDRs(srv.config.Security.TrustedNetworks)
+	if err != nil {
+		return fmt.Errorf("invalid security trusted_networks: %w", err)
+	}
+	srv.smtpDeps.TrustedNetworks = trusted
+
+	expnNetworks, err := security.ParseCIDRs(srv.config.Server.ExpnNetworks)
+	if err != nil {
+		return fmt.Errorf("invalid expn_networks: %w", err)
+	}
+	srv.smtpDeps.ExpnNetworks = expnNetworks
+
+	// A deny list that failed to load must not silently let mail through
+	if err := srv.senderAccess.LoadAccessMaps(ctx); err != nil {
+		return err
+	}
+	if err := srv.recipientAccess.LoadAccessMaps(ctx); err != nil {
+		return err
+	}
+	contentChecker, err := security.NewContentChecker(&srv.config.Security.ContentChecks)
+	if err != nil {
+		return err
+	}
+	srv.smtpDeps.ContentChecker = contentChecker
+
 	// Load TLS config if enabled
 	if srv.config.TLS.Enabled {
-		tlsCfg, err := loadTLSConfig(&srv.config.TLS)
+		tlsCfg, certs, err := loadTLSConfig(&srv.config.TLS)
 		if err != nil {
 			return err
 		}
 		srv.tlsConfig = tlsCfg
+		srv.certs = certs
 	}
 
 	// Initialize and start message queue
-	var err error
 	srv.queue, err = queue.NewQueue(ctx, srv.config)
 	if err != nil {
 		return err
@@ -103,29 +255,30 @@ func (srv *Server) Start(ctx context.Context) error {
 	srv.queue.StartConsumer(ctx)
 	srv.smtpDeps.Queue = srv.queue
 
+	if warm := srv.config.Cache.SystemUsers.Warm; len(warm) > 0 {
+		srv.smtpDeps.RcptValidator.Warm(ctx, warm)
+		log().Info("Recipient cache warmed", "addresses", len(warm), "cache", srv.smtpDeps.RcptValidator.CacheStats())
+	}
+
 	// Start one TCP listener per configured listener
 	for _, lcfg := range srv.config.Server.Listeners {
-		addr := fmt.Sprintf("%s:%d", srv.config.Server.Bind, lcfg.Port)
-
-		var ln net.Listener
-		var err error
+		addr := srv.config.Server.ListenAddress(lcfg)
 
-		if lcfg.Mode == config.ListenerModeTLS {
-			if srv.tlsConfig == nil {
-				return fmt.Errorf("TLS listener on port %d requires tls config", lcfg.Port)
-			}
-			ln, err = tls.Listen("tcp", addr, srv.tlsConfig)
-		} else {
-			ln, err = net.Listen("tcp", addr)
+		// Implicit TLS listeners accept plain TCP; handleConnection performs the
+		// handshake so failures are logged and never stall the accept loop
+		if lcfg.Mode == config.ListenerModeTLS && srv.tlsConfig == nil {
+			srv.closeAllListeners()
+			return fmt.Errorf("TLS listener on port %d requires tls config", lcfg.Port)
 		}
 
+		ln, err := net.Listen("tcp", addr)
 		if err != nil {
 			srv.closeAllListeners()
 			return fmt.Errorf("failed to listen on %s: %w", addr, err)
 		}
 
 		srv.listeners = append(srv.listeners, ln)
-		log().Info("SMTP listener started", "address", addr, "mode", lcfg.Mode)
+		log().Info("SMTP listener started", "address", ln.Addr().String(), "mode", lcfg.Mode, "role", lcfg.Role)
 
 		srv.wg.Add(1)
 		go srv.acceptLoop(ctx, ln, lcfg)
@@ -137,6 +290,17 @@ func (srv *Server) Start(ctx context.Context) error {
 		return fmt.Errorf("failed to start Unix domain socket listener: %w", err)
 	}
 
+	if err := srv.startControlListener(ctx); err != nil {
+		srv.closeAllListeners()
+		if srv.socketListen != nil {
+			srv.socketListen.Close()
+		}
+		return fmt.Errorf("failed to start control socket listener: %w", err)
+	}
+
+	srv.wg.Add(1)
+	go srv.runIPSweeper()
+
 	return nil
 }
 
@@ -156,6 +320,10 @@ func (srv *Server) Stop(ctx context.Context) error {
 		srv.socketListen.Close()
 	}
 
+	if srv.controlListen != nil {
+		srv.controlListen.Close()
+	}
+
 	// Stop message queue
 	if srv.queue != nil {
 		if err := srv.queue.Stop(ctx); err != nil {
@@ -169,6 +337,13 @@ func (srv *Server) Stop(ctx context.Context) error {
 		close(done)
 	}()
 
+	if srv.smtpDeps != nil && srv.smtpDeps.RcptValidator != nil {
+		srv.smtpDeps.RcptValidator.Close()
+	}
+	if srv.smtpDeps != nil && srv.smtpDeps.DNSCache != nil {
+		srv.smtpDeps.DNSCache.Close()
+	}
+
 	select {
 	case <-done:
 		log().Info("SMTP server stopped gracefully")
@@ -202,7 +377,16 @@ func (srv *Server) acceptLoop(ctx context.Context, ln net.Listener, lcfg config.
 
 		clientIP := getClientIP(conn)
 
-		if !srv.canAcceptConnection(clientIP) {
+		// Blocklisted IPs are turned away before any tracking or DNS work
+		if security.ContainsIP(srv.blocklist, clientIP) {
+			log().Warn("Connection rejected: client IP blocklisted", "client_ip", clientIP)
+			srv.writeRejectReply(conn, lcfg, &srv.config.Security.ConnectionRejects.Blocklist)
+			conn.Close()
+			continue
+		}
+
+		if reply, ok := srv.canAcceptConnection(clientIP); !ok {
+			srv.writeRejectReply(conn, lcfg, reply)
 			conn.Close()
 			continue
 		}
@@ -214,11 +398,25 @@ func (srv *Server) acceptLoop(ctx context.Context, ln net.Listener, lcfg config.
 	}
 }
 
-func (srv *Server) canAcceptConnection(clientIP string) bool {
+// writeRejectReply tells a client refused before its SMTP session why, so it
+// sees a diagnostic rather than a reset. Nothing is written for a nil reply or
+// on implicit TLS listeners, where a plaintext reply is meaningless before the
+// handshake.
+func (srv *Server) writeRejectReply(conn net.Conn, lcfg config.ListenerConfig, reply *config.RejectReply) {
+	if reply == nil || lcfg.Mode == config.ListenerModeTLS {
+		return
+	}
+	conn.SetWriteDeadline(time.Now().Add(rejectReplyTimeout)) //nolint:errcheck
+	fmt.Fprintf(conn, "%d %s %s\r\n", reply.Code, srv.config.Server.AdvertisedHostname(), reply.Message)
+}
+
+// canAcceptConnection checks the connection limits. A refused client gets the
+// returned reply, or none when its address is unknown.
+func (srv *Server) canAcceptConnection(clientIP string) (*config.RejectReply, bool) {
 	// Reject connections with invalid IP addresses
 	if clientIP == UnknownClientIP {
 		log().Warn("Connection rejected: unable to determine client IP")
-		return false
+		return nil, false
 	}
 
 	// Check total connection limit (atomic read)
@@ -226,7 +424,7 @@ func (srv *Server) canAcceptConnection(clientIP string) bool {
 	if totalConns >= int64(srv.config.Server.MaxConnections) {
 		log().Warn("Connection rejected: max connections reached",
 			"current", totalConns, "max", srv.config.Server.MaxConnections)
-		return false
+		return &srv.config.Security.ConnectionRejects.MaxConnections, false
 	}
 
 	// Check per-IP connection limit (sync.Map)
@@ -234,10 +432,10 @@ func (srv *Server) canAcceptConnection(clientIP string) bool {
 	if ipConns >= srv.config.Server.MaxConnectionsPerIP {
 		log().Warn("Connection rejected: max connections per IP reached",
 			"ip", clientIP, "current", ipConns, "max", srv.config.Server.MaxConnectionsPerIP)
-		return false
+		return &srv.config.Security.ConnectionRejects.PerIPLimit, false
 	}
 
-	return true
+	return nil, true
 }
 
 func (srv *Server) trackConnection(clientIP string) {
@@ -252,77 +450,178 @@ func (srv *Server) untrackConnection(clientIP string) {
 
 func (srv *Server) getIPConnectionCount(ip string) int {
 	if val, ok := srv.ipConnections.Load(ip); ok {
-		return int(atomic.LoadInt64(val.(*int64)))
+		return int(max(atomic.LoadInt64(val.(*int64)), 0))
 	}
 	return 0
 }
 
+// ipCounterRemoved marks a per-IP counter that is being deleted from
+// ipConnections; it must not be incremented again
+const ipCounterRemoved = -1
+
 func (srv *Server) incrementIPConnection(ip string) {
-	// Load or create counter for this IP
-	val, _ := srv.ipConnections.LoadOrStore(ip, new(int64))
-	atomic.AddInt64(val.(*int64), 1)
+	for {
+		// Load or create counter for this IP
+		val, _ := srv.ipConnections.LoadOrStore(ip, new(int64))
+		counter := val.(*int64)
+		for {
+			n := atomic.LoadInt64(counter)
+			if n == ipCounterRemoved {
+				break
+			}
+			if atomic.CompareAndSwapInt64(counter, n, n+1) {
+				return
+			}
+		}
+		// The counter was removed under us: finish its delete and retry
+		// with a fresh one
+		srv.ipConnections.CompareAndDelete(ip, val)
+	}
+}
+
+// removeIPCounter deletes an idle per-IP counter; it fails if a connection
+// claimed the counter first
+func (srv *Server) removeIPCounter(ip, val any) bool {
+	if !atomic.CompareAndSwapInt64(val.(*int64), 0, ipCounterRemoved) {
+		return false
+	}
+	srv.ipConnections.CompareAndDelete(ip, val)
+	return true
+}
+
+// ipSweepInterval is how often per-IP tracking state is swept
+const ipSweepInterval = time.Minute
+
+// runIPSweeper periodically drops per-IP state that outlived its connections,
+// so a flood from many (possibly spoofed) addresses cannot grow memory without
+// bound
+func (srv *Server) runIPSweeper() {
+	defer srv.wg.Done()
+
+	ticker := time.NewTicker(ipSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			srv.sweepIPState()
+		case <-srv.shutdown:
+			return
+		}
+	}
+}
+
+// sweepIPState removes idle per-IP connection counts and submission rate
+// entries that have left their window
+func (srv *Server) sweepIPState() {
+	removed := 0
+	srv.ipConnections.Range(func(ip, val any) bool {
+		if srv.removeIPCounter(ip, val) {
+			removed++
+		}
+		return true
+	})
+	if removed > 0 {
+		log().Debug("Swept idle per-IP connection counters", "removed", removed)
+	}
+
+	if srv.smtpDeps != nil {
+		srv.smtpDeps.SubmissionLimit.Sweep()
+	}
 }
 
 func (srv *Server) decrementIPConnection(ip string) {
 	if val, ok := srv.ipConnections.Load(ip); ok {
-		newCount := atomic.AddInt64(val.(*int64), -1)
 		// Clean up if count reaches zero
-		if newCount <= 0 {
-			srv.ipConnections.Delete(ip)
+		if atomic.AddInt64(val.(*int64), -1) == 0 {
+			srv.removeIPCounter(ip, val)
 		}
 	}
 }
 
-func (srv *Server) performSecurityChecks(ctx context.Context, clientIP string) bool {
+// performSecurityChecks runs rDNS and DNSBL checks and returns the client's
+// reverse DNS hostname (empty if unknown), the DNSBL providers listing it when
+// the action lets it in anyway, and the reply refusing it; a nil reply lets
+// the connection proceed
+func (srv *Server) performSecurityChecks(ctx context.Context, clientIP string) (string, []string, *config.RejectReply) {
+	if security.ContainsIP(srv.allowlist, clientIP) {
+		log().Debug("Client IP allowlisted, skipping rDNS and DNSBL checks", "client_ip", clientIP)
+		return "", nil, nil
+	}
+
 	rdnsResult := srv.rdnsChecker.Lookup(ctx, clientIP)
 	if !rdnsResult.Valid {
 		log().Warn("rDNS check failed",
 			"client_ip", clientIP,
 			"hostname", rdnsResult.Hostname,
 			"error", rdnsResult.Error)
-		return false
+		return "", nil, &srv.config.Security.ConnectionRejects.ReverseDNS
 	}
+	reverseDNS := strings.TrimSuffix(rdnsResult.Hostname, ".")
 
+	var listings []string
 	dnsblResults := srv.dnsblChecker.CheckIP(ctx, clientIP)
 	for _, result := range dnsblResults {
-		if result.Listed && srv.dnsblChecker.ShouldReject() {
+		if !result.Listed {
+			continue
+		}
+		if srv.dnsblChecker.ShouldReject() {
 			log().Warn("IP listed in DNSBL, rejecting connection",
 				"client_ip", clientIP,
 				"provider", result.Provider,
 				"response_codes", result.ResponseCodes)
-			return false
+			return "", nil, &srv.config.Security.ConnectionRejects.DNSBL
 		}
+		listings = append(listings, result.Provider)
 	}
 
-	return true
+	return reverseDNS, listings, nil
 }
 
 func (srv *Server) handleConnection(ctx context.Context, conn net.Conn, clientIP string, lcfg config.ListenerConfig) {
 	defer srv.wg.Done()
 	defer srv.untrackConnection(clientIP)
-	defer conn.Close()
+	defer func() { conn.Close() }() // conn may be replaced by its TLS wrapper
 
 	log().Info("New connection accepted", "client_ip", clientIP, "port", lcfg.Port, "mode", lcfg.Mode)
 
-	if !srv.performSecurityChecks(ctx, clientIP) {
+	reverseDNS, dnsblListings, reject := srv.performSecurityChecks(ctx, clientIP)
+	if reject != nil {
 		log().Warn("Connection rejected due to security checks", "client_ip", clientIP)
+		srv.writeRejectReply(conn, lcfg, reject)
 		return
 	}
 
-	if srv.config.Server.ReadTimeout > 0 {
-		conn.SetReadDeadline(time.Now().Add(srv.config.Server.ReadTimeout))
+	// Implicit TLS (SMTPS): the handshake completes before any SMTP I/O
+	if lcfg.Mode == config.ListenerModeTLS {
+		tlsConn, err := srv.implicitTLSHandshake(ctx, conn)
+		if err != nil {
+			log().Warn("TLS handshake failed", "client_ip", clientIP, "port", lcfg.Port, "error", err)
+			return
+		}
+		conn = tlsConn
 	}
-	if srv.config.Server.WriteTimeout > 0 {
-		conn.SetWriteDeadline(time.Now().Add(srv.config.Server.WriteTimeout))
+
+	// Read and write deadlines are refreshed per operation by the SMTP session
+	// using command_timeout, data_timeout and write_timeout.
+
+	connType := smtp.ConnectionTypeTCP
+	if lcfg.Mode == config.ListenerModeLMTP {
+		connType = smtp.ConnectionTypeLMTP
 	}
 
 	connCtx := smtp.ConnectionContext{
-		Type:      smtp.ConnectionTypeTCP,
-		Port:      lcfg.Port,
-		Mode:      smtp.ListenerMode(lcfg.Mode),
-		TLS:       lcfg.Mode == config.ListenerModeTLS, // implicit TLS already active
-		ClientIP:  clientIP,
-		TLSConfig: srv.tlsConfig,
+		Type:           connType,
+		Port:           lcfg.Port,
+		Mode:           smtp.ListenerMode(lcfg.Mode),
+		Role:           smtp.ListenerRole(lcfg.Role),
+		AuthMechanisms: lcfg.AuthMechanisms,
+		TLS:            lcfg.Mode == config.ListenerModeTLS, // implicit TLS already active
+		ClientIP:       clientIP,
+		ReverseDNS:     reverseDNS,
+		Allowlisted:    security.ContainsIP(srv.allowlist, clientIP),
+		DNSBL:          dnsblListings,
+		TLSConfig:      srv.tlsConfig,
 	}
 
 	textprotoConn := textproto.NewConn(conn)
@@ -335,6 +634,23 @@ func (srv *Server) handleConnection(ctx context.Context, conn net.Conn, clientIP
 	}
 }
 
+// implicitTLSHandshake wraps conn in a server-side TLS connection and completes
+// the handshake within tlsHandshakeTimeout
+func (srv *Server) implicitTLSHandshake(ctx context.Context, conn net.Conn) (*tls.Conn, error) {
+	tlsConn := tls.Server(conn, srv.tlsConfig)
+
+	handshakeCtx, cancel := context.WithTimeout(ctx, tlsHandshakeTimeout)
+	defer cancel()
+	if err := tlsConn.HandshakeContext(handshakeCtx); err != nil {
+		return nil, err
+	}
+
+	state := tlsConn.ConnectionState()
+	log().Debug("TLS handshake completed", "version", tls.VersionName(state.Version),
+		"cipher_suite", tls.CipherSuiteName(state.CipherSuite))
+	return tlsConn, nil
+}
+
 func getClientIP(conn net.Conn) string {
 	if addr := conn.RemoteAddr(); addr != nil {
 		if tcpAddr, ok := addr.(*net.TCPAddr); ok {
@@ -0,0 +1,822 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pawciobiel/golubsmtpd/internal/aliases"
+	"github.com/pawciobiel/golubsmtpd/internal/auth"
+	"github.com/pawciobiel/golubsmtpd/internal/config"
+	"github.com/pawciobiel/golubsmtpd/internal/logging"
+	"github.com/pawciobiel/golubsmtpd/internal/queue"
+	"github.com/pawciobiel/golubsmtpd/internal/security"
+)
+
+func TestMain(m *testing.M) {
+	logging.InitTestLogging()
+	os.Exit(m.Run())
+}
+
+// fakeRDNS returns a fixed hostname and counts lookups
+type fakeRDNS struct {
+	lookups int
+}
+
+func (f *fakeRDNS) Lookup(_ context.Context, ip string) *security.RDNSResult {
+	f.lookups++
+	return &security.RDNSResult{IP: ip, Hostname: "host.example.org.", Valid: true}
+}
+
+// fakeDNSBL lists every IP with action (empty = reject)
+type fakeDNSBL struct {
+	checks int
+	action string
+}
+
+func (f *fakeDNSBL) CheckIP(_ context.Context, ip string) []*security.DNSBLResult {
+	f.checks++
+	return []*security.DNSBLResult{{IP: ip, Listed: true, Provider: "dnsbl.example.org", Action: f.action}}
+}
+
+func (f *fakeDNSBL) ShouldReject() bool { return f.action == "" || f.action == "reject" }
+
+func TestPerformSecurityChecks_Allowlist(t *testing.T) {
+	allowlist, err := security.ParseCIDRs([]string{"192.0.2.0/24", "2001:db8::1"})
+	if err != nil {
+		t.Fatalf("ParseCIDRs failed: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		clientIP   string
+		wantOK     bool
+		wantChecks int
+	}{
+		{"allowlisted IPv4 range bypasses DNSBL", "192.0.2.77", true, 0},
+		{"allowlisted IPv6 host bypasses DNSBL", "2001:db8::1", true, 0},
+		{"non-allowlisted IP is still checked", "198.51.100.7", false, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rdns, dnsbl := &fakeRDNS{}, &fakeDNSBL{}
+			srv := &Server{
+				config:       config.DefaultConfig(),
+				rdnsChecker:  rdns,
+				dnsblChecker: dnsbl,
+				allowlist:    allowlist,
+			}
+
+			_, _, reject := srv.performSecurityChecks(context.Background(), tt.clientIP)
+			if ok := reject == nil; ok != tt.wantOK {
+				t.Errorf("performSecurityChecks(%s) = %v, want %v", tt.clientIP, ok, tt.wantOK)
+			}
+			if rdns.lookups != tt.wantChecks || dnsbl.checks != tt.wantChecks {
+				t.Errorf("expected %d rDNS/DNSBL checks, got rdns=%d dnsbl=%d",
+					tt.wantChecks, rdns.lookups, dnsbl.checks)
+			}
+		})
+	}
+}
+
+func TestPerformSecurityChecks_DNSBLAction(t *testing.T) {
+	tests := []struct {
+		action       string
+		wantOK       bool
+		wantListings []string
+	}{
+		{"reject", false, nil},
+		{"log", true, []string{"dnsbl.example.org"}},
+		{"discard", true, []string{"dnsbl.example.org"}},
+		{"tag", true, []string{"dnsbl.example.org"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.action, func(t *testing.T) {
+			srv := &Server{
+				config:       config.DefaultConfig(),
+				rdnsChecker:  &fakeRDNS{},
+				dnsblChecker: &fakeDNSBL{action: tt.action},
+			}
+
+			_, listings, reject := srv.performSecurityChecks(context.Background(), "198.51.100.7")
+			if ok := reject == nil; ok != tt.wantOK {
+				t.Errorf("performSecurityChecks ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !slices.Equal(listings, tt.wantListings) {
+				t.Errorf("listings = %v, want %v", listings, tt.wantListings)
+			}
+		})
+	}
+}
+
+// ipConnectionEntries counts the per-IP connection counters held by srv
+func ipConnectionEntries(srv *Server) int {
+	n := 0
+	srv.ipConnections.Range(func(_, _ any) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+func TestIPConnections_ChurnReturnsToBaseline(t *testing.T) {
+	srv := &Server{config: config.DefaultConfig()}
+
+	var wg sync.WaitGroup
+	for i := range 2000 {
+		ip := fmt.Sprintf("10.%d.%d.%d", i>>16&0xff, i>>8&0xff, i&0xff)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			srv.trackConnection(ip)
+			srv.trackConnection(ip)
+			srv.untrackConnection(ip)
+			srv.untrackConnection(ip)
+		}()
+	}
+	wg.Wait()
+
+	if n := ipConnectionEntries(srv); n != 0 {
+		t.Errorf("Expected no per-IP entries after all connections closed, got %d", n)
+	}
+	if total := atomic.LoadInt64(&srv.totalConnections); total != 0 {
+		t.Errorf("Expected no connections, got %d", total)
+	}
+}
+
+func TestSweepIPState(t *testing.T) {
+	srv := &Server{config: config.DefaultConfig()}
+
+	// Zero counters left behind by a connection racing the delete
+	for i := range 50 {
+		srv.ipConnections.Store(fmt.Sprintf("192.0.2.%d", i), new(int64))
+	}
+	srv.trackConnection("198.51.100.1")
+
+	srv.sweepIPState()
+
+	if n := ipConnectionEntries(srv); n != 1 {
+		t.Errorf("Expected only the active client to remain, got %d entries", n)
+	}
+	if n := srv.getIPConnectionCount("198.51.100.1"); n != 1 {
+		t.Errorf("Active client count = %d, want 1", n)
+	}
+}
+
+func TestSweepIPState_ConcurrentConnections(t *testing.T) {
+	srv := &Server{config: config.DefaultConfig()}
+
+	stop := make(chan struct{})
+	swept := make(chan struct{})
+	go func() {
+		defer close(swept)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				srv.sweepIPState()
+			}
+		}
+	}()
+
+	// Several connections per client so counters churn through zero
+	const clients, perClient = 8, 16
+	var wg sync.WaitGroup
+	for i := range clients * perClient {
+		ip := fmt.Sprintf("10.0.0.%d", i%clients)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 500 {
+				srv.trackConnection(ip)
+				srv.untrackConnection(ip)
+			}
+			srv.trackConnection(ip)
+		}()
+	}
+	wg.Wait()
+	close(stop)
+	<-swept
+
+	for i := range clients {
+		ip := fmt.Sprintf("10.0.0.%d", i)
+		if n := srv.getIPConnectionCount(ip); n != perClient {
+			t.Errorf("Count for %s = %d after sweeping, want %d", ip, n, perClient)
+		}
+	}
+}
+
+func TestReloadAccessMaps_ReloadsBothMaps(t *testing.T) {
+	dir := t.TempDir()
+	senderPath := filepath.Join(dir, "sender_access")
+	recipientPath := filepath.Join(dir, "recipient_access")
+	for _, path := range []string{senderPath, recipientPath} {
+		if err := os.WriteFile(path, nil, 0o600); err != nil {
+			t.Fatalf("Failed to write access map: %v", err)
+		}
+	}
+	cfg := config.DefaultConfig()
+	cfg.Server.SenderAccessFilePath = senderPath
+	cfg.Server.RecipientAccessFilePath = recipientPath
+	srv := New(cfg, nil, nil, nil)
+
+	// A sender map that cannot be read must not hold back the recipient map
+	if err := os.Remove(senderPath); err != nil {
+		t.Fatalf("Failed to remove sender map: %v", err)
+	}
+	if err := os.WriteFile(recipientPath, []byte("blocked@localhost reject\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write recipient map: %v", err)
+	}
+
+	if err := srv.ReloadAccessMaps(context.Background()); err == nil || !strings.Contains(err.Error(), "sender_access") {
+		t.Errorf("ReloadAccessMaps: want sender_access error, got %v", err)
+	}
+	if got := srv.recipientAccess.Lookup("blocked@localhost"); got != aliases.AccessReject {
+		t.Errorf("recipient map not reloaded: Lookup = %v, want reject", got)
+	}
+}
+
+func TestBlocklist(t *testing.T) {
+	blocklist, err := security.ParseCIDRs([]string{"203.0.113.0/24"})
+	if err != nil {
+		t.Fatalf("ParseCIDRs failed: %v", err)
+	}
+
+	tests := []struct {
+		clientIP string
+		blocked  bool
+	}{
+		{"203.0.113.0", true},
+		{"203.0.113.42", true},
+		{"203.0.113.255", true},
+		{"203.0.112.255", false}, // adjacent below
+		{"203.0.114.0", false},   // adjacent above
+		{UnknownClientIP, false},
+	}
+
+	for _, tt := range tests {
+		if got := security.ContainsIP(blocklist, tt.clientIP); got != tt.blocked {
+			t.Errorf("ContainsIP(%s) = %v, want %v", tt.clientIP, got, tt.blocked)
+		}
+	}
+}
+
+// readRejectReply reads what a refused client is sent and checks the
+// connection is closed after it
+func readRejectReply(t *testing.T, conn net.Conn) string {
+	t.Helper()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+	reply, err := reader.ReadString('\n')
+	if err != nil && reply != "" {
+		t.Fatalf("Failed to read reply: %v", err)
+	}
+	if _, err := reader.ReadByte(); err == nil {
+		t.Error("Expected connection to be closed after the reply")
+	}
+	return reply
+}
+
+func TestConnectionRejects_AcceptLoop(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(cfg *config.Config, srv *Server)
+		mode      config.ListenerMode
+		want      string
+	}{
+		{"blocklist", func(cfg *config.Config, srv *Server) {
+			srv.blocklist, _ = security.ParseCIDRs([]string{"127.0.0.0/8"})
+		}, config.ListenerModePlain, "554 mx.example.com Access denied\r\n"},
+		{"max connections", func(cfg *config.Config, srv *Server) {
+			cfg.Server.MaxConnections = 0
+		}, config.ListenerModePlain, "421 mx.example.com Too many connections, try again later\r\n"},
+		{"per-IP limit", func(cfg *config.Config, srv *Server) {
+			cfg.Server.MaxConnectionsPerIP = 0
+			cfg.Security.ConnectionRejects.PerIPLimit = config.RejectReply{Code: 421, Message: "Slow down"}
+		}, config.ListenerModePlain, "421 mx.example.com Slow down\r\n"},
+		{"implicit TLS closes silently", func(cfg *config.Config, srv *Server) {
+			cfg.Server.MaxConnections = 0
+		}, config.ListenerModeTLS, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.Server.Hostname = "mx.example.com"
+			srv := &Server{config: cfg, shutdown: make(chan struct{})}
+			tt.configure(cfg, srv)
+
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("Listen failed: %v", err)
+			}
+			srv.wg.Add(1)
+			go srv.acceptLoop(context.Background(), ln, config.ListenerConfig{Mode: tt.mode})
+			defer func() {
+				close(srv.shutdown)
+				ln.Close()
+				srv.wg.Wait()
+			}()
+
+			conn, err := net.Dial("tcp", ln.Addr().String())
+			if err != nil {
+				t.Fatalf("Dial failed: %v", err)
+			}
+			defer conn.Close()
+
+			if reply := readRejectReply(t, conn); reply != tt.want {
+				t.Errorf("reply = %q, want %q", reply, tt.want)
+			}
+		})
+	}
+}
+
+// failingRDNS reports every client as lacking valid reverse DNS
+type failingRDNS struct{}
+
+func (failingRDNS) Lookup(_ context.Context, ip string) *security.RDNSResult {
+	return &security.RDNSResult{IP: ip, Valid: false}
+}
+
+func TestConnectionRejects_SecurityChecks(t *testing.T) {
+	tests := []struct {
+		name  string
+		rdns  rdnsLookup
+		dnsbl dnsblCheck
+		want  string
+	}{
+		{"reverse DNS", failingRDNS{}, &fakeDNSBL{}, "554 mx.example.com Reverse DNS lookup failed\r\n"},
+		{"DNSBL", &fakeRDNS{}, &fakeDNSBL{action: "reject"}, "554 mx.example.com Client host blocked using DNSBL\r\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.Server.Hostname = "mx.example.com"
+			srv := &Server{config: cfg, rdnsChecker: tt.rdns, dnsblChecker: tt.dnsbl}
+
+			serverConn, clientConn := net.Pipe()
+			defer clientConn.Close()
+
+			srv.trackConnection("198.51.100.7")
+			srv.wg.Add(1)
+			go srv.handleConnection(context.Background(), serverConn, "198.51.100.7", config.ListenerConfig{Mode: config.ListenerModePlain})
+
+			if reply := readRejectReply(t, clientConn); reply != tt.want {
+				t.Errorf("reply = %q, want %q", reply, tt.want)
+			}
+			srv.wg.Wait()
+		})
+	}
+}
+
+func TestStart_ListenerRoles(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Server.SpoolDir = t.TempDir()
+	cfg.Server.SocketPath = ""
+	cfg.Security.Allowlist = []string{"127.0.0.0/8"} // skip rDNS/DNSBL for loopback clients
+	cfg.Server.Listeners = []config.ListenerConfig{
+		{Address: "127.0.0.1", Port: 0, Mode: config.ListenerModePlain, Role: config.ListenerRoleRelay},
+		{Address: "127.0.0.1", Port: 0, Mode: config.ListenerModePlain, Role: config.ListenerRoleSubmission},
+	}
+
+	srv := New(cfg, nil, nil, nil)
+	if err := srv.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Stop(ctx)
+	}()
+
+	if len(srv.listeners) != 2 {
+		t.Fatalf("Expected 2 listeners, got %d", len(srv.listeners))
+	}
+
+	// Relay accepts any sender; submission requires AUTH before MAIL FROM
+	want := []string{"250", "530"}
+	for i, ln := range srv.listeners {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatalf("Failed to dial listener %d: %v", i, err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		reader := bufio.NewReader(conn)
+
+		readReply := func() string {
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					t.Fatalf("listener %d: failed to read reply: %v", i, err)
+				}
+				if len(line) < 4 || line[3] != '-' {
+					return line
+				}
+			}
+		}
+
+		readReply() // banner
+		conn.Write([]byte("EHLO client.example.com\r\n"))
+		readReply()
+		conn.Write([]byte("MAIL FROM:<sender@example.com>\r\n"))
+		if reply := readReply(); reply[:3] != want[i] {
+			t.Errorf("listener %d (%s): MAIL FROM reply %q, want %s", i, cfg.Server.Listeners[i].Role, reply, want[i])
+		}
+		conn.Close()
+	}
+}
+
+func TestStart_ListenerAuthMechanisms(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Server.SpoolDir = t.TempDir()
+	cfg.Server.SocketPath = ""
+	cfg.Security.Allowlist = []string{"127.0.0.0/8"}
+	cfg.Server.Listeners = []config.ListenerConfig{
+		{Address: "127.0.0.1", Port: 0, Mode: config.ListenerModePlain, AuthMechanisms: []string{"PLAIN"}},
+		{Address: "127.0.0.1", Port: 0, Mode: config.ListenerModePlain, AuthMechanisms: []string{"PLAIN", "LOGIN"}},
+	}
+
+	srv := New(cfg, nil, nil, nil)
+	if err := srv.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Stop(ctx)
+	}()
+
+	// LOGIN is refused where the listener leaves it out and starts its
+	// exchange where the listener offers it
+	tests := []struct {
+		wantAuth  string
+		wantLogin string
+	}{
+		{"250-AUTH PLAIN\r\n", "501"},
+		{"250-AUTH PLAIN LOGIN\r\n", "334"},
+	}
+	for i, ln := range srv.listeners {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatalf("Failed to dial listener %d: %v", i, err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		reader := bufio.NewReader(conn)
+
+		readReply := func() string {
+			var reply strings.Builder
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					t.Fatalf("listener %d: failed to read reply: %v", i, err)
+				}
+				reply.WriteString(line)
+				if len(line) < 4 || line[3] != '-' {
+					return reply.String()
+				}
+			}
+		}
+
+		readReply() // banner
+		conn.Write([]byte("EHLO client.example.com\r\n"))
+		if ehlo := readReply(); !strings.Contains(ehlo, tests[i].wantAuth) {
+			t.Errorf("listener %d: EHLO missing %q:\n%s", i, tests[i].wantAuth, ehlo)
+		}
+		conn.Write([]byte("AUTH LOGIN\r\n"))
+		if reply := readReply(); reply[:3] != tests[i].wantLogin {
+			t.Errorf("listener %d: AUTH LOGIN reply %q, want %s", i, reply, tests[i].wantLogin)
+		}
+		conn.Close()
+	}
+}
+
+// writeTestCertificate writes a self-signed certificate for localhost and
+// returns the cert/key paths and a pool trusting it
+func writeTestCertificate(t *testing.T) (string, string, *x509.CertPool) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("Failed to write certificate: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %v", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return certFile, keyFile, pool
+}
+
+func TestStart_ImplicitTLS(t *testing.T) {
+	certFile, keyFile, pool := writeTestCertificate(t)
+
+	cfg := config.DefaultConfig()
+	cfg.Server.SpoolDir = t.TempDir()
+	cfg.Server.SocketPath = ""
+	cfg.Security.Allowlist = []string{"127.0.0.0/8"}
+	cfg.Delivery.Virtual.BaseDirPath = t.TempDir()
+	cfg.TLS = config.TLSConfig{Enabled: true, CertFile: certFile, KeyFile: keyFile}
+	cfg.Server.Listeners = []config.ListenerConfig{
+		{Address: "127.0.0.1", Port: 0, Mode: config.ListenerModeTLS, Role: config.ListenerRoleSubmission},
+	}
+	if err := queue.InitializeSpoolDirectories(cfg.Server.SpoolDir); err != nil {
+		t.Fatalf("Failed to initialize spool: %v", err)
+	}
+
+	authenticator, err := auth.NewMemoryAuthenticator(context.Background(),
+		[]config.UserConfig{{Username: "alice@mail.localhost", Password: "secret"}})
+	if err != nil {
+		t.Fatalf("Failed to create authenticator: %v", err)
+	}
+
+	srv := New(cfg, authenticator, nil, nil)
+	if err := srv.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Stop(ctx)
+	}()
+
+	conn, err := tls.Dial("tcp", srv.listeners[0].Addr().String(), &tls.Config{RootCAs: pool, ServerName: "localhost"})
+	if err != nil {
+		t.Fatalf("TLS handshake failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+
+	// readReply returns all lines of a (possibly multiline) reply
+	readReply := func() []string {
+		var lines []string
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatalf("Failed to read reply: %v", err)
+			}
+			lines = append(lines, strings.TrimRight(line, "\r\n"))
+			if len(line) < 4 || line[3] != '-' {
+				return lines
+			}
+		}
+	}
+	send := func(command, wantCode string) []string {
+		t.Helper()
+		if _, err := conn.Write([]byte(command + "\r\n")); err != nil {
+			t.Fatalf("Failed to send %q: %v", command, err)
+		}
+		reply := readReply()
+		if last := reply[len(reply)-1]; !strings.HasPrefix(last, wantCode) {
+			t.Fatalf("%q: got %q, want %s", command, reply, wantCode)
+		}
+		return reply
+	}
+
+	if banner := readReply(); !strings.HasPrefix(banner[0], "220") {
+		t.Fatalf("Unexpected banner %q", banner)
+	}
+
+	ehlo := strings.Join(send("EHLO client.example.com", "250"), "\n")
+	if strings.Contains(ehlo, "STARTTLS") {
+		t.Errorf("STARTTLS must not be advertised on implicit TLS: %q", ehlo)
+	}
+	if !strings.Contains(ehlo, "AUTH") {
+		t.Errorf("AUTH should be advertised once TLS is active: %q", ehlo)
+	}
+
+	send("AUTH PLAIN "+auth.EncodeBase64("\x00alice@mail.localhost\x00secret"), "235")
+	send("MAIL FROM:<alice@mail.localhost>", "250")
+	send("RCPT TO:<alice@mail.localhost>", "250")
+	send("DATA", "354")
+	send("Subject: over SMTPS\r\n\r\nhello\r\n.", "250")
+	send("QUIT", "221")
+}
+
+func TestStart_ImplicitTLSHandshakeFailure(t *testing.T) {
+	certFile, keyFile, _ := writeTestCertificate(t)
+
+	cfg := config.DefaultConfig()
+	cfg.Server.SpoolDir = t.TempDir()
+	cfg.Server.SocketPath = ""
+	cfg.Security.Allowlist = []string{"127.0.0.0/8"}
+	cfg.TLS = config.TLSConfig{Enabled: true, CertFile: certFile, KeyFile: keyFile}
+	cfg.Server.Listeners = []config.ListenerConfig{
+		{Address: "127.0.0.1", Port: 0, Mode: config.ListenerModeTLS, Role: config.ListenerRoleSubmission},
+	}
+
+	srv := New(cfg, nil, nil, nil)
+	if err := srv.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Stop(ctx)
+	}()
+
+	// A plaintext client gets no SMTP banner; the connection is dropped
+	conn, err := net.Dial("tcp", srv.listeners[0].Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	conn.Write([]byte("EHLO client.example.com\r\n"))
+	data, _ := bufio.NewReader(conn).ReadString('\n')
+	if strings.HasPrefix(data, "220") {
+		t.Errorf("Plaintext client must not receive an SMTP banner, got %q", data)
+	}
+}
+
+func TestLoadTLSConfig_VersionAndCiphers(t *testing.T) {
+	certFile, keyFile, _ := writeTestCertificate(t)
+
+	tests := []struct {
+		name        string
+		minVersion  string
+		ciphers     []string
+		wantVersion uint16
+		wantCiphers []uint16
+	}{
+		{"default", "", nil, tls.VersionTLS12, nil},
+		{"tls 1.3", "1.3", nil, tls.VersionTLS13, nil},
+		{"cipher allowlist", "1.2", []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}, tls.VersionTLS12,
+			[]uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tlsCfg, _, err := loadTLSConfig(&config.TLSConfig{
+				Enabled: true, CertFile: certFile, KeyFile: keyFile,
+				MinVersion: tt.minVersion, CipherSuites: tt.ciphers,
+			})
+			if err != nil {
+				t.Fatalf("loadTLSConfig: %v", err)
+			}
+			if tlsCfg.MinVersion != tt.wantVersion {
+				t.Errorf("MinVersion: want %#x, got %#x", tt.wantVersion, tlsCfg.MinVersion)
+			}
+			if len(tlsCfg.CipherSuites) != len(tt.wantCiphers) ||
+				(len(tt.wantCiphers) > 0 && tlsCfg.CipherSuites[0] != tt.wantCiphers[0]) {
+				t.Errorf("CipherSuites: want %v, got %v", tt.wantCiphers, tlsCfg.CipherSuites)
+			}
+		})
+	}
+}
+
+func TestCheckTLSConfig_ClientCA(t *testing.T) {
+	certFile, keyFile, _ := writeTestCertificate(t)
+	notPEM := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatalf("Failed to write CA file: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		caFile  string
+		wantErr string
+	}{
+		{"no client CA", "", ""},
+		{"client CA is the certificate", certFile, ""},
+		{"missing client CA", filepath.Join(t.TempDir(), "missing.pem"), "failed to read TLS client CA file"},
+		{"client CA without certificates", notPEM, "no certificates found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckTLSConfig(&config.TLSConfig{Enabled: true, CertFile: certFile, KeyFile: keyFile, ClientCAFile: tt.caFile})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("CheckTLSConfig: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("CheckTLSConfig: want error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestReloadTLS(t *testing.T) {
+	certFile, keyFile, _ := writeTestCertificate(t)
+	tlsCfg := &config.TLSConfig{Enabled: true, CertFile: certFile, KeyFile: keyFile}
+
+	serverTLS, certs, err := loadTLSConfig(tlsCfg)
+	if err != nil {
+		t.Fatalf("loadTLSConfig: %v", err)
+	}
+	srv := &Server{config: &config.Config{TLS: *tlsCfg}, certs: certs}
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", serverTLS)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake() //nolint:errcheck
+			conn.Close()
+		}
+	}()
+
+	// presented returns the certificate a fresh handshake receives
+	presented := func() []byte {
+		t.Helper()
+		conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+		if err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].Raw
+	}
+
+	before := presented()
+
+	// Renew: overwrite the configured files with a new certificate and key
+	newCert, newKey, _ := writeTestCertificate(t)
+	for src, dst := range map[string]string{newCert: certFile, newKey: keyFile} {
+		data, err := os.ReadFile(src)
+		if err != nil {
+			t.Fatalf("ReadFile: %v", err)
+		}
+		if err := os.WriteFile(dst, data, 0o600); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+	if err := srv.ReloadTLS(); err != nil {
+		t.Fatalf("ReloadTLS: %v", err)
+	}
+
+	after := presented()
+	if string(before) == string(after) {
+		t.Fatal("handshake after reload still presents the old certificate")
+	}
+
+	// A broken file keeps the current certificate in service
+	if err := os.WriteFile(keyFile, []byte("garbage"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if err := srv.ReloadTLS(); err == nil {
+		t.Error("ReloadTLS should fail with an unreadable key")
+	}
+	if string(presented()) != string(after) {
+		t.Error("failed reload should keep the previous certificate")
+	}
+}
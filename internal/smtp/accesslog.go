@@ -0,0 +1,73 @@
+package smtp
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Transaction dispositions recorded in the access log
+const (
+	dispositionAccepted  = "accepted"
+	dispositionRejected  = "rejected"
+	dispositionDiscarded = "discarded" // accepted but dropped by a content check
+)
+
+// logTransaction emits the single per-transaction audit record once a message
+// is accepted or the transaction is rejected. It reads the current message, so
+// call it before resetSession.
+func (sess *Session) logTransaction(disposition, reply string) {
+	var mailFrom, messageID string
+	var rcptCount int
+	var size int64
+	if msg := sess.currentMessage; msg != nil {
+		mailFrom = msg.From
+		messageID = msg.ID
+		rcptCount = msg.TotalRecipients()
+		size = msg.TotalSize
+	}
+	sess.emitTransaction(disposition, reply, mailFrom, messageID, rcptCount, size)
+}
+
+// logRejectedSender records a transaction refused at MAIL FROM, before any
+// message exists
+func (sess *Session) logRejectedSender(sender, reply string) {
+	sess.emitTransaction(dispositionRejected, reply, sender, "", 0, 0)
+}
+
+func (sess *Session) emitTransaction(disposition, reply, mailFrom, messageID string, rcptCount int, size int64) {
+	var duration time.Duration
+	if !sess.txStart.IsZero() {
+		duration = time.Since(sess.txStart)
+	}
+
+	code, _, _ := strings.Cut(reply, " ")
+	sess.logger.LogAttrs(context.Background(), slog.LevelInfo, "SMTP transaction",
+		slog.String("session_id", sess.id),
+		slog.String("client_ip", sess.clientIP),
+		slog.String("helo", sess.clientHelloHostname),
+		slog.String("auth_user", sess.transactionUser()),
+		slog.String("mail_from", mailFrom),
+		slog.Int("rcpt_count", rcptCount),
+		slog.String("message_id", messageID),
+		slog.Int64("size", size),
+		slog.Any("dnsbl", slices.Concat(sess.dnsblResults, sess.senderDNSBL)),
+		slog.String("disposition", disposition),
+		slog.String("reply_code", code),
+		slog.Duration("duration", duration),
+	)
+}
+
+// transactionUser returns the authenticated identity: the SASL username or,
+// for socket sessions, the local user behind the connection
+func (sess *Session) transactionUser() string {
+	if sess.authenticated {
+		return sess.username
+	}
+	if sess.senderValidator != nil && sess.senderValidator.IsAuthenticated() {
+		return sess.senderValidator.GetUsername()
+	}
+	return ""
+}
@@ -0,0 +1,106 @@
+package smtp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/pawciobiel/golubsmtpd/internal/config"
+	"github.com/pawciobiel/golubsmtpd/internal/queue"
+)
+
+// transactionRecords decodes the "SMTP transaction" events from JSON log output
+func transactionRecords(t *testing.T, out *bytes.Buffer) []map[string]any {
+	t.Helper()
+
+	var records []map[string]any
+	for line := range strings.SplitSeq(strings.TrimSpace(out.String()), "\n") {
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("Invalid JSON log line %q: %v", line, err)
+		}
+		if record["msg"] == "SMTP transaction" {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+func TestSession_TransactionAccessLog(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Server.RelayDomains = []string{"relay.example.com"}
+	cfg.Relay.Enabled = true
+	cfg.Server.SpoolDir = t.TempDir()
+	cfg.Server.MaxMessageSize = 1000
+	if err := queue.InitializeSpoolDirectories(cfg.Server.SpoolDir); err != nil {
+		t.Fatalf("Failed to initialize spool: %v", err)
+	}
+	q, err := queue.NewQueue(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("NewQueue failed: %v", err)
+	}
+
+	sess, conn := newTestTCPSession(t, cfg)
+	sess.queue = q
+	sess.clientHelloHostname = "client.example.org"
+	var logs bytes.Buffer
+	sess.logger = slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	ctx := context.Background()
+
+	send := func(body string) {
+		t.Helper()
+		for _, cmd := range []string{"MAIL FROM:<sender@example.org>", "RCPT TO:<a@relay.example.com>", "RCPT TO:<b@relay.example.com>"} {
+			if err := sess.processCommand(ctx, cmd); err != nil {
+				t.Fatalf("%s failed: %v", cmd, err)
+			}
+		}
+		conn.in = strings.NewReader(body)
+		if err := sess.processCommand(ctx, "DATA"); err != nil {
+			t.Fatalf("DATA failed: %v", err)
+		}
+	}
+
+	send("Subject: hi\r\n\r\nbody\r\n.\r\n")
+	send("Subject: big\r\n\r\n" + strings.Repeat("A", 2000) + "\r\n.\r\n")
+
+	records := transactionRecords(t, &logs)
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 transaction records, got %d:\n%s", len(records), logs.String())
+	}
+
+	accepted := records[0]
+	for _, key := range []string{"session_id", "client_ip", "helo", "auth_user", "mail_from", "rcpt_count",
+		"message_id", "size", "dnsbl", "disposition", "reply_code", "duration"} {
+		if _, ok := accepted[key]; !ok {
+			t.Errorf("Transaction record missing key %q: %v", key, accepted)
+		}
+	}
+
+	want := map[string]any{
+		"client_ip":   "192.0.2.1",
+		"helo":        "client.example.org",
+		"mail_from":   "sender@example.org",
+		"rcpt_count":  float64(2),
+		"disposition": "accepted",
+		"reply_code":  "250",
+	}
+	for key, value := range want {
+		if accepted[key] != value {
+			t.Errorf("accepted[%q] = %v, want %v", key, accepted[key], value)
+		}
+	}
+	if accepted["message_id"] == "" || accepted["size"] == float64(0) {
+		t.Errorf("Accepted record should carry message_id and size: %v", accepted)
+	}
+
+	rejected := records[1]
+	if rejected["disposition"] != "rejected" || rejected["reply_code"] != "552" {
+		t.Errorf("Oversized message record: got disposition=%v reply_code=%v", rejected["disposition"], rejected["reply_code"])
+	}
+	if rejected["session_id"] != accepted["session_id"] {
+		t.Errorf("Both transactions should share the session ID")
+	}
+}
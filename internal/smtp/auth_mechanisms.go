@@ -0,0 +1,95 @@
+package smtp
+
+import (
+	"context"
+	"slices"
+	"strings"
+)
+
+// AuthMechanismHandler implements one SASL mechanism for the AUTH command
+type AuthMechanismHandler struct {
+	// Handle runs the exchange; args are the AUTH arguments after the mechanism name
+	Handle func(ctx context.Context, sess *Session, args []string) error
+
+	// Available reports whether the mechanism can be used on this session
+	// (e.g. EXTERNAL needs a verified client certificate). Nil means always.
+	Available func(sess *Session) bool
+}
+
+var (
+	authMechanisms     = map[string]AuthMechanismHandler{}
+	authMechanismOrder []string // registration order, used for the EHLO AUTH line
+)
+
+// RegisterAuthMechanism makes a SASL mechanism available to AUTH and EHLO.
+// Registering an existing name replaces its handler.
+func RegisterAuthMechanism(name string, handler AuthMechanismHandler) {
+	name = strings.ToUpper(name)
+	if _, exists := authMechanisms[name]; !exists {
+		authMechanismOrder = append(authMechanismOrder, name)
+	}
+	authMechanisms[name] = handler
+}
+
+func init() {
+	RegisterAuthMechanism("PLAIN", AuthMechanismHandler{
+		Handle: func(ctx context.Context, sess *Session, args []string) error {
+			return sess.handleAuthPlain(ctx, args)
+		},
+	})
+	RegisterAuthMechanism("LOGIN", AuthMechanismHandler{
+		Handle: func(ctx context.Context, sess *Session, args []string) error {
+			return sess.handleAuthLogin(ctx, args)
+		},
+	})
+	RegisterAuthMechanism("EXTERNAL", AuthMechanismHandler{
+		Handle: func(ctx context.Context, sess *Session, args []string) error {
+			return sess.handleAuthExternal(ctx, args)
+		},
+		Available: func(sess *Session) bool {
+			return sess.clientCertUsername() != ""
+		},
+	})
+	RegisterAuthMechanism("XOAUTH2", AuthMechanismHandler{
+		Handle: func(ctx context.Context, sess *Session, args []string) error {
+			return sess.handleAuthXOAuth2(ctx, args)
+		},
+		Available: func(sess *Session) bool {
+			return sess.tokenValidator != nil
+		},
+	})
+}
+
+// authMechanism returns the handler for name if it is registered, enabled in
+// config and on the session's listener, and available on this session
+func (sess *Session) authMechanism(name string) (AuthMechanismHandler, bool) {
+	name = strings.ToUpper(name)
+	handler, ok := authMechanisms[name]
+	if !ok || !sess.config.Auth.MechanismEnabled(name) || !sess.listenerAllowsMechanism(name) {
+		return AuthMechanismHandler{}, false
+	}
+	if handler.Available != nil && !handler.Available(sess) {
+		return AuthMechanismHandler{}, false
+	}
+	return handler, true
+}
+
+// listenerAllowsMechanism reports whether the listener the session came in on
+// offers the named mechanism
+func (sess *Session) listenerAllowsMechanism(name string) bool {
+	allowed := sess.connCtx.AuthMechanisms
+	return len(allowed) == 0 || slices.ContainsFunc(allowed, func(m string) bool {
+		return strings.EqualFold(m, name)
+	})
+}
+
+// authMechanismNames lists the mechanisms to advertise in EHLO, in registration order
+func (sess *Session) authMechanismNames() []string {
+	var names []string
+	for _, name := range authMechanismOrder {
+		if _, ok := sess.authMechanism(name); ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
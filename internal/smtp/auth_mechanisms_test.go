@@ -0,0 +1,89 @@
+package smtp
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/pawciobiel/golubsmtpd/internal/config"
+)
+
+// registerTestMechanism registers a fake mechanism for the duration of the test
+func registerTestMechanism(t *testing.T, name string, handler AuthMechanismHandler) {
+	t.Helper()
+
+	RegisterAuthMechanism(name, handler)
+	t.Cleanup(func() {
+		delete(authMechanisms, name)
+		authMechanismOrder = slices.DeleteFunc(authMechanismOrder, func(n string) bool { return n == name })
+	})
+}
+
+func TestAuthMechanismRegistry_FakeMechanism(t *testing.T) {
+	var gotArgs []string
+	registerTestMechanism(t, "XTEST", AuthMechanismHandler{
+		Handle: func(ctx context.Context, sess *Session, args []string) error {
+			gotArgs = args
+			return sess.writeResponse(Response(StatusAuthSuccess, "XTEST ok"))
+		},
+	})
+
+	sess, conn := newTestTCPSession(t, config.DefaultConfig())
+	sess.connCtx.Mode = config.ListenerModePlain
+	ctx := context.Background()
+
+	if err := sess.processCommand(ctx, "EHLO client.example.com"); err != nil {
+		t.Fatalf("EHLO failed: %v", err)
+	}
+	if !strings.Contains(conn.out.String(), "250-AUTH PLAIN LOGIN XTEST\r\n") {
+		t.Errorf("Expected XTEST in EHLO AUTH line, got:\n%s", conn.out.String())
+	}
+
+	if err := sess.processCommand(ctx, "AUTH xtest abc"); err != nil {
+		t.Fatalf("AUTH XTEST failed: %v", err)
+	}
+	if resp := conn.lastResponse(); resp != "235 XTEST ok" {
+		t.Errorf("Expected fake mechanism response, got %q", resp)
+	}
+	if diff := cmp.Diff([]string{"abc"}, gotArgs); diff != "" {
+		t.Errorf("Handler args mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestAuthMechanismRegistry_ConfigEnablesSubset(t *testing.T) {
+	dispatched := false
+	registerTestMechanism(t, "XTEST", AuthMechanismHandler{
+		Handle: func(ctx context.Context, sess *Session, args []string) error {
+			dispatched = true
+			return sess.writeResponse(Response(StatusAuthSuccess, "XTEST ok"))
+		},
+	})
+
+	cfg := config.DefaultConfig()
+	cfg.Auth.Mechanisms = []string{"plain"}
+	sess, conn := newTestTCPSession(t, cfg)
+	sess.connCtx.Mode = config.ListenerModePlain
+	ctx := context.Background()
+
+	if err := sess.processCommand(ctx, "EHLO client.example.com"); err != nil {
+		t.Fatalf("EHLO failed: %v", err)
+	}
+	if !strings.Contains(conn.out.String(), "250-AUTH PLAIN\r\n") {
+		t.Errorf("Expected only PLAIN advertised, got:\n%s", conn.out.String())
+	}
+
+	for _, mechanism := range []string{"LOGIN", "XTEST"} {
+		if err := sess.processCommand(ctx, "AUTH "+mechanism); err != nil {
+			t.Fatalf("AUTH %s failed: %v", mechanism, err)
+		}
+		if resp := conn.lastResponse(); !strings.HasPrefix(resp, "501") {
+			t.Errorf("AUTH %s: expected 501 for disabled mechanism, got %q", mechanism, resp)
+		}
+	}
+	if dispatched {
+		t.Error("Disabled mechanism was dispatched")
+	}
+}
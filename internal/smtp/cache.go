@@ -2,23 +2,27 @@ package smtp
 
 import (
 	"container/list"
+	"log/slog"
 	"sync"
 	"time"
 )
 
 // CacheEntry represents a cached value with TTL tracking
 type CacheEntry struct {
-	key       string
-	value     bool // cached boolean result
-	timestamp time.Time
-	element   *list.Element // for LRU tracking
+	key     string
+	value   bool          // cached boolean result
+	expires time.Time     // set from the TTL matching value
+	element *list.Element // for LRU tracking
 }
 
-// LRUCache is a thread-safe LRU cache with TTL and automatic cleanup
+// LRUCache is a thread-safe LRU cache with TTL and automatic cleanup.
+// True and false results have separate TTLs so a negative answer can be
+// forgotten sooner than a positive one.
 type LRUCache struct {
-	mutex    sync.RWMutex
-	capacity int
-	ttl      time.Duration
+	mutex       sync.RWMutex
+	capacity    int
+	ttl         time.Duration // TTL of true values
+	negativeTTL time.Duration // TTL of false values
 
 	// LRU tracking
 	items   map[string]*CacheEntry
@@ -34,14 +38,19 @@ type LRUCache struct {
 	misses int64
 }
 
-// NewLRUCache creates a new LRU cache with TTL and background cleanup
-func NewLRUCache(capacity int, ttl time.Duration) *LRUCache {
+// NewLRUCache creates a new LRU cache with TTL and background cleanup.
+// negativeTTL applies to false values; 0 uses ttl for both.
+func NewLRUCache(capacity int, ttl, negativeTTL time.Duration) *LRUCache {
+	if negativeTTL <= 0 {
+		negativeTTL = ttl
+	}
 	cache := &LRUCache{
 		capacity:        capacity,
 		ttl:             ttl,
+		negativeTTL:     negativeTTL,
 		items:           make(map[string]*CacheEntry, capacity),
 		lruList:         list.New(),
-		cleanupInterval: ttl / 4, // Clean 4x more frequently than TTL
+		cleanupInterval: min(ttl, negativeTTL) / 4, // Clean 4x more frequently than TTL
 		stopCleanup:     make(chan struct{}),
 	}
 
@@ -64,7 +73,7 @@ func (c *LRUCache) Get(key string) (bool, bool) {
 	}
 
 	// Check TTL
-	if time.Since(entry.timestamp) > c.ttl {
+	if time.Now().After(entry.expires) {
 		c.removeLocked(key)
 		c.misses++
 		return false, false
@@ -85,16 +94,16 @@ func (c *LRUCache) Put(key string, value bool) {
 	if entry, exists := c.items[key]; exists {
 		// Update existing entry
 		entry.value = value
-		entry.timestamp = time.Now()
+		entry.expires = c.expiry(value)
 		c.lruList.MoveToFront(entry.element)
 		return
 	}
 
 	// Create new entry
 	entry := &CacheEntry{
-		key:       key,
-		value:     value,
-		timestamp: time.Now(),
+		key:     key,
+		value:   value,
+		expires: c.expiry(value),
 	}
 
 	// Add to front of LRU list
@@ -107,6 +116,14 @@ func (c *LRUCache) Put(key string, value bool) {
 	}
 }
 
+// expiry returns when an entry stored now with value expires
+func (c *LRUCache) expiry(value bool) time.Time {
+	if value {
+		return time.Now().Add(c.ttl)
+	}
+	return time.Now().Add(c.negativeTTL)
+}
+
 // evictLRU removes the least recently used entry
 func (c *LRUCache) evictLRU() {
 	if oldest := c.lruList.Back(); oldest != nil {
@@ -150,7 +167,7 @@ func (c *LRUCache) cleanup() {
 
 	// Find expired entries
 	for key, entry := range c.items {
-		if now.Sub(entry.timestamp) > c.ttl {
+		if now.After(entry.expires) {
 			keysToRemove = append(keysToRemove, key)
 		}
 	}
@@ -180,6 +197,36 @@ func (c *LRUCache) Stats() (size, capacity int, hitRate float64) {
 	return len(c.items), c.capacity, hitRate
 }
 
+// CacheStats is a snapshot of an LRUCache's occupancy and effectiveness
+type CacheStats struct {
+	Size     int
+	Capacity int
+	Hits     int64
+	Misses   int64
+	HitRate  float64
+}
+
+// LogValue renders the stats as a log group
+func (s CacheStats) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.Int("size", s.Size),
+		slog.Int("capacity", s.Capacity),
+		slog.Int64("hits", s.Hits),
+		slog.Int64("misses", s.Misses),
+		slog.Float64("hit_rate", s.HitRate),
+	)
+}
+
+// Snapshot returns the cache statistics including raw hit and miss counts
+func (c *LRUCache) Snapshot() CacheStats {
+	size, capacity, hitRate := c.Stats()
+
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return CacheStats{Size: size, Capacity: capacity, Hits: c.hits, Misses: c.misses, HitRate: hitRate}
+}
+
 // Clear removes all entries from the cache
 func (c *LRUCache) Clear() {
 	c.mutex.Lock()
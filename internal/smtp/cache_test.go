@@ -0,0 +1,58 @@
+package smtp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLRUCache_NegativeTTL(t *testing.T) {
+	cache := NewLRUCache(10, time.Minute, 20*time.Millisecond)
+	defer cache.Close()
+
+	cache.Put("alice", true)
+	cache.Put("nobody", false)
+
+	if value, found := cache.Get("nobody"); !found || value {
+		t.Fatalf("Get(nobody) = %v, %v before expiry, want false, true", value, found)
+	}
+
+	time.Sleep(40 * time.Millisecond)
+
+	if _, found := cache.Get("nobody"); found {
+		t.Errorf("negative entry should have expired after its negative TTL")
+	}
+	if value, found := cache.Get("alice"); !found || !value {
+		t.Errorf("Get(alice) = %v, %v, positive entry should outlive the negative TTL", value, found)
+	}
+}
+
+func TestLRUCache_NegativeTTLDefaultsToTTL(t *testing.T) {
+	cache := NewLRUCache(10, 20*time.Millisecond, 0)
+	defer cache.Close()
+
+	cache.Put("nobody", false)
+	if _, found := cache.Get("nobody"); !found {
+		t.Fatalf("negative entry should be cached")
+	}
+
+	time.Sleep(40 * time.Millisecond)
+
+	if _, found := cache.Get("nobody"); found {
+		t.Errorf("negative entry should expire after the shared TTL")
+	}
+}
+
+func TestLRUCache_PutUpdatesExpiry(t *testing.T) {
+	cache := NewLRUCache(10, time.Minute, 20*time.Millisecond)
+	defer cache.Close()
+
+	// A user created after a negative lookup gets the positive TTL once stored
+	cache.Put("bob", false)
+	cache.Put("bob", true)
+
+	time.Sleep(40 * time.Millisecond)
+
+	if value, found := cache.Get("bob"); !found || !value {
+		t.Errorf("Get(bob) = %v, %v, want true, true", value, found)
+	}
+}
@@ -1,13 +1,38 @@
 package smtp
 
 import (
+	"context"
+	"net"
+
 	"github.com/pawciobiel/golubsmtpd/internal/aliases"
 	"github.com/pawciobiel/golubsmtpd/internal/auth"
 	"github.com/pawciobiel/golubsmtpd/internal/queue"
+	"github.com/pawciobiel/golubsmtpd/internal/security"
 )
 
+// SenderDomainChecker checks MAIL FROM domains against DNSBL providers
+// (implemented by security.DNSBLChecker)
+type SenderDomainChecker interface {
+	CheckDomain(ctx context.Context, domain string) []*security.DNSBLResult
+	ShouldReject() bool
+}
+
 type Dependencies struct {
 	Authenticator    auth.Authenticator
 	Queue            *queue.Queue
 	LocalAliasesMaps *aliases.LocalAliasesMaps
-}
\ No newline at end of file
+	DNSBLChecker     SenderDomainChecker          // nil disables sender-domain DNSBL checks
+	CanonicalMaps    *aliases.CanonicalMaps       // nil disables address rewriting
+	SenderAccess     *aliases.AccessMaps          // nil disables sender access checks
+	RecipientAccess  *aliases.AccessMaps          // nil disables recipient access checks
+	ContentChecker   *security.ContentChecker     // nil disables header/body checks
+	SpamScorer       *security.SpamScorer         // nil disables the score and report headers
+	RejectScorer     *security.RejectScorer       // nil disables rejecting on the combined score
+	TokenValidator   auth.TokenValidator          // nil disables AUTH XOAUTH2
+	SubmissionLimit  *security.SubmissionLimiter  // nil disables message rate limiting
+	UserSessions     *security.UserSessionLimiter // nil disables the per-user session cap
+	RcptValidator    *RcptValidator               // shared recipient lookup caches; nil gives each session its own
+	DNSCache         *LRUCache                    // shared dns_mx/dns_a validation results; nil disables caching
+	TrustedNetworks  []*net.IPNet                 // parsed security.trusted_networks
+	ExpnNetworks     []*net.IPNet                 // parsed server.expn_networks
+}
@@ -0,0 +1,76 @@
+package smtp
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pawciobiel/golubsmtpd/internal/types"
+)
+
+const (
+	maxEnvIDLength = 100 // RFC 3461 §4.4
+	maxORcptLength = 500 // RFC 3461 §4.2
+)
+
+// dsnAddrTypeRe matches the addr-type atom of an ORCPT value
+var dsnAddrTypeRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9\-]*$`)
+
+// parseDSNRet validates a MAIL FROM RET= value (RFC 3461 §4.3)
+func parseDSNRet(value string) (string, error) {
+	switch ret := strings.ToUpper(value); ret {
+	case "FULL", "HDRS":
+		return ret, nil
+	default:
+		return "", fmt.Errorf("invalid RET parameter %q", value)
+	}
+}
+
+// parseDSNEnvID decodes a MAIL FROM ENVID= value (RFC 3461 §4.4)
+func parseDSNEnvID(value string) (string, error) {
+	envID, err := decodeXtext(value)
+	if err != nil || envID == "" || len(envID) > maxEnvIDLength {
+		return "", fmt.Errorf("invalid ENVID parameter %q", value)
+	}
+	return envID, nil
+}
+
+// parseRcptDSNParams validates the NOTIFY= and ORCPT= RCPT TO parameters
+// (RFC 3461 §4.1, §4.2)
+func parseRcptDSNParams(params map[string]string) (types.DSNParams, error) {
+	var dsn types.DSNParams
+
+	if value, ok := params["NOTIFY"]; ok {
+		seen := make(map[string]bool)
+		for _, n := range strings.Split(strings.ToUpper(value), ",") {
+			switch n {
+			case "NEVER", "SUCCESS", "FAILURE", "DELAY":
+			default:
+				return dsn, fmt.Errorf("invalid NOTIFY parameter %q", value)
+			}
+			if seen[n] {
+				return dsn, fmt.Errorf("invalid NOTIFY parameter %q", value)
+			}
+			seen[n] = true
+			dsn.Notify = append(dsn.Notify, n)
+		}
+		// NEVER cannot be combined with any other keyword
+		if seen["NEVER"] && len(dsn.Notify) > 1 {
+			return dsn, fmt.Errorf("invalid NOTIFY parameter %q", value)
+		}
+	}
+
+	if value, ok := params["ORCPT"]; ok {
+		addrType, address, found := strings.Cut(value, ";")
+		if !found || !dsnAddrTypeRe.MatchString(addrType) {
+			return dsn, fmt.Errorf("invalid ORCPT parameter %q", value)
+		}
+		decoded, err := decodeXtext(address)
+		if err != nil || decoded == "" || len(value) > maxORcptLength {
+			return dsn, fmt.Errorf("invalid ORCPT parameter %q", value)
+		}
+		dsn.ORcpt = strings.ToLower(addrType) + ";" + decoded
+	}
+
+	return dsn, nil
+}
@@ -2,14 +2,17 @@ package smtp
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"net/mail"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/pawciobiel/golubsmtpd/internal/config"
+	"github.com/pawciobiel/golubsmtpd/internal/security"
 )
 
 const (
@@ -41,14 +44,68 @@ type EmailAddress struct {
 	Full   string // complete address
 }
 
+// dnsResolver is the default resolver for DNS validations; it bounds the dial as
+// well as the overall lookup with DNSTimeout
+var dnsResolver security.Resolver = &net.Resolver{
+	PreferGo: true,
+	Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+		d := net.Dialer{
+			Timeout: DNSTimeout,
+		}
+		return d.DialContext(ctx, network, address)
+	},
+}
+
 // EmailValidator handles email address validation with configurable validation pipeline
 type EmailValidator struct {
-	config *config.Config
+	config   *config.Config
+	resolver security.Resolver
+	dnsCache *LRUCache // MX and A/AAAA lookup results; nil disables caching
 }
 
 // NewEmailValidator creates a new email validator with configuration
 func NewEmailValidator(cfg *config.Config) *EmailValidator {
-	return &EmailValidator{config: cfg}
+	return &EmailValidator{config: cfg, resolver: dnsResolver}
+}
+
+// SetResolver replaces the resolver used by the dns_mx and dns_a validations
+func (v *EmailValidator) SetResolver(resolver security.Resolver) {
+	v.resolver = resolver
+}
+
+// SetDNSCache shares a cache of dns_mx and dns_a lookup results; nil disables caching
+func (v *EmailValidator) SetDNSCache(cache *LRUCache) {
+	v.dnsCache = cache
+}
+
+// NewDNSCache creates the cache shared by the dns_mx and dns_a validations.
+// Returns nil when it is disabled.
+func NewDNSCache(cfg *config.DNSCacheConfig) *LRUCache {
+	if cfg.Capacity <= 0 || cfg.TTL <= 0 {
+		return nil
+	}
+	return NewLRUCache(cfg.Capacity, cfg.TTL, cfg.NegativeTTL)
+}
+
+// cachedDNSResult returns a remembered lookup result for key
+func (v *EmailValidator) cachedDNSResult(key string) (found, ok bool) {
+	if v.dnsCache == nil {
+		return false, false
+	}
+	return v.dnsCache.Get(key)
+}
+
+// rememberDNSResult caches whether key has records. Lookup errors other than
+// "no such host" are transient and never cached.
+func (v *EmailValidator) rememberDNSResult(key string, found bool, err error) {
+	if v.dnsCache == nil {
+		return
+	}
+	var dnsErr *net.DNSError
+	if err != nil && !(errors.As(err, &dnsErr) && dnsErr.IsNotFound) {
+		return
+	}
+	v.dnsCache.Put(key, found)
 }
 
 // hasValidationType checks if a validation type is enabled in the configuration
@@ -164,21 +221,19 @@ func (v *EmailValidator) extendedValidation(email, domain string) error {
 
 // validateMXRecord checks if the domain has valid MX records
 func (v *EmailValidator) validateMXRecord(domain string) error {
+	key := "mx:" + strings.ToLower(domain)
+	if found, ok := v.cachedDNSResult(key); ok {
+		if !found {
+			return fmt.Errorf("no MX records found for domain %s", domain)
+		}
+		return nil
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), DNSTimeout)
 	defer cancel()
 
-	// Create a custom resolver with timeout
-	resolver := &net.Resolver{
-		PreferGo: true,
-		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
-			d := net.Dialer{
-				Timeout: DNSTimeout,
-			}
-			return d.DialContext(ctx, network, address)
-		},
-	}
-
-	mxRecords, err := resolver.LookupMX(ctx, domain)
+	mxRecords, err := v.resolver.LookupMX(ctx, domain)
+	v.rememberDNSResult(key, len(mxRecords) > 0, err)
 	if err != nil {
 		return fmt.Errorf("MX lookup failed for domain %s: %w", domain, err)
 	}
@@ -192,21 +247,19 @@ func (v *EmailValidator) validateMXRecord(domain string) error {
 
 // validateARecord checks if the domain has valid A/AAAA records
 func (v *EmailValidator) validateARecord(domain string) error {
+	key := "a:" + strings.ToLower(domain)
+	if found, ok := v.cachedDNSResult(key); ok {
+		if !found {
+			return fmt.Errorf("no A/AAAA records found for domain %s", domain)
+		}
+		return nil
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), DNSTimeout)
 	defer cancel()
 
-	// Create a custom resolver with timeout
-	resolver := &net.Resolver{
-		PreferGo: true,
-		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
-			d := net.Dialer{
-				Timeout: DNSTimeout,
-			}
-			return d.DialContext(ctx, network, address)
-		},
-	}
-
-	ips, err := resolver.LookupIPAddr(ctx, domain)
+	ips, err := v.resolver.LookupIPAddr(ctx, domain)
+	v.rememberDNSResult(key, len(ips) > 0, err)
 	if err != nil {
 		return fmt.Errorf("A/AAAA lookup failed for domain %s: %w", domain, err)
 	}
@@ -224,8 +277,9 @@ func (v *EmailValidator) ParseMailFromCommand(args []string) (*EmailAddress, err
 		return nil, fmt.Errorf("MAIL FROM requires an email address")
 	}
 
-	// Join all args in case there are spaces
-	fullArg := strings.Join(args, " ")
+	// Join all path args in case there are spaces; ESMTP parameters are parsed separately
+	path, _ := splitMailArgs(args)
+	fullArg := strings.Join(path, " ")
 
 	// Remove "FROM:" prefix if present
 	if strings.HasPrefix(strings.ToUpper(fullArg), "FROM:") {
@@ -238,7 +292,79 @@ func (v *EmailValidator) ParseMailFromCommand(args []string) (*EmailAddress, err
 		return nil, fmt.Errorf("MAIL FROM requires an email address")
 	}
 
-	return v.ParseEmailAddress(fullArg)
+	// Null reverse-path (RFC 5321 §4.5.5): bounces and other notifications.
+	// Whether it is acceptable is left to the sender validator.
+	if fullArg == "<>" {
+		return &EmailAddress{}, nil
+	}
+
+	return v.ParseEmailAddress(v.qualifyAddress(fullArg))
+}
+
+// qualifyAddress appends the configured default domain to a bare username such
+// as <root>; addresses that already contain @ (and the null path) are left alone
+func (v *EmailValidator) qualifyAddress(path string) string {
+	domain := v.config.Server.AppendDefaultDomain
+	address := strings.TrimSpace(strings.Trim(path, "<>"))
+	if domain == "" || address == "" || strings.Contains(address, "@") {
+		return path
+	}
+	return address + "@" + domain
+}
+
+// splitMailArgs separates the bracketed path from trailing ESMTP parameters.
+// Without angle brackets all args are treated as the path.
+func splitMailArgs(args []string) (path []string, params []string) {
+	for i, arg := range args {
+		if strings.Contains(arg, ">") {
+			return args[:i+1], args[i+1:]
+		}
+	}
+	return args, nil
+}
+
+// ParseMailParams parses ESMTP parameters following the MAIL FROM or RCPT TO
+// path (RFC 5321 §4.1.2) into a map keyed by upper-cased keyword
+func ParseMailParams(args []string) (map[string]string, error) {
+	_, rawParams := splitMailArgs(args)
+	params := make(map[string]string, len(rawParams))
+	for _, p := range rawParams {
+		keyword, value, _ := strings.Cut(p, "=")
+		keyword = strings.ToUpper(keyword)
+		if keyword == "" {
+			return nil, fmt.Errorf("invalid parameter %q", p)
+		}
+		if _, dup := params[keyword]; dup {
+			return nil, fmt.Errorf("duplicate parameter %s", keyword)
+		}
+		params[keyword] = value
+	}
+	return params, nil
+}
+
+// decodeXtext decodes an RFC 3461 §4 xtext value ("+XX" hex escapes)
+func decodeXtext(s string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '+' {
+			if c < '!' || c > '~' || c == '=' {
+				return "", fmt.Errorf("invalid xtext character %q", c)
+			}
+			b.WriteByte(c)
+			continue
+		}
+		if i+2 >= len(s) {
+			return "", fmt.Errorf("truncated xtext escape")
+		}
+		n, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+		if err != nil {
+			return "", fmt.Errorf("invalid xtext escape %q", s[i:i+3])
+		}
+		b.WriteByte(byte(n))
+		i += 2
+	}
+	return b.String(), nil
 }
 
 // ParseRcptToCommand parses a RCPT TO command and extracts the email address
@@ -247,8 +373,9 @@ func (v *EmailValidator) ParseRcptToCommand(args []string) (*EmailAddress, error
 		return nil, fmt.Errorf("RCPT TO requires an email address")
 	}
 
-	// Join all args in case there are spaces
-	fullArg := strings.Join(args, " ")
+	// Join all path args in case there are spaces; ESMTP parameters are parsed separately
+	path, _ := splitMailArgs(args)
+	fullArg := strings.Join(path, " ")
 
 	// Remove "TO:" prefix if present
 	if strings.HasPrefix(strings.ToUpper(fullArg), "TO:") {
@@ -261,9 +388,13 @@ func (v *EmailValidator) ParseRcptToCommand(args []string) (*EmailAddress, error
 		return nil, fmt.Errorf("RCPT TO requires an email address")
 	}
 
-	return v.ParseEmailAddress(fullArg)
+	return v.ParseEmailAddress(v.qualifyAddress(fullArg))
 }
 
+// helloHostnameRe matches dot-separated labels of letters, digits and inner
+// hyphens, which leaves out spaces, control characters and other bytes
+var helloHostnameRe = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9\-]{0,61}[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9\-]{0,61}[a-zA-Z0-9])?)*$`)
+
 // ValidateHelloHostname validates a hostname from HELO/EHLO command
 func ValidateHelloHostname(hostname string) error {
 	if hostname == "" {
@@ -274,10 +405,7 @@ func ValidateHelloHostname(hostname string) error {
 		return fmt.Errorf("hostname too long")
 	}
 
-	// Basic hostname validation regex
-	hostnameRegex := regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9\-]{0,61}[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9\-]{0,61}[a-zA-Z0-9])?)*$`)
-
-	if !hostnameRegex.MatchString(hostname) {
+	if !helloHostnameRe.MatchString(hostname) {
 		return fmt.Errorf("invalid hostname format")
 	}
 
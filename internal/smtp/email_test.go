@@ -1,9 +1,13 @@
 package smtp
 
 import (
+	"context"
+	"net"
 	"strings"
 	"testing"
 
+	"github.com/google/go-cmp/cmp"
+
 	"github.com/pawciobiel/golubsmtpd/internal/config"
 )
 
@@ -338,6 +342,18 @@ func TestMailFromCommandParsing(t *testing.T) {
 			validation: []string{ValidationBasic},
 			shouldPass: true,
 		},
+		{
+			name:       "null sender",
+			args:       []string{"FROM:<>"},
+			validation: []string{ValidationBasic, ValidationExtended},
+			shouldPass: true,
+		},
+		{
+			name:       "null sender with ESMTP parameter",
+			args:       []string{"FROM:<>", "SIZE=1024"},
+			validation: []string{ValidationBasic},
+			shouldPass: true,
+		},
 		{
 			name:        "empty args",
 			args:        []string{},
@@ -484,3 +500,254 @@ func TestRcptToCommandParsing(t *testing.T) {
 func containsSubstring(str, substr string) bool {
 	return strings.Contains(str, substr)
 }
+
+func TestParseMailParams(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    map[string]string
+		wantErr bool
+	}{
+		{"no params", []string{"FROM:<a@example.com>"}, map[string]string{}, false},
+		{"auth and size", []string{"FROM:<a@example.com>", "auth=<>", "SIZE=1000"}, map[string]string{"AUTH": "<>", "SIZE": "1000"}, false},
+		{"keyword without value", []string{"FROM:<a@example.com>", "SMTPUTF8"}, map[string]string{"SMTPUTF8": ""}, false},
+		{"path split across args", []string{"FROM:", "<a@example.com>", "AUTH=a@example.com"}, map[string]string{"AUTH": "a@example.com"}, false},
+		{"duplicate keyword", []string{"FROM:<a@example.com>", "AUTH=<>", "AUTH=<>"}, nil, true},
+		{"empty keyword", []string{"FROM:<a@example.com>", "=x"}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseMailParams(tt.args)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Expected error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("params mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDecodeXtext(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"user@example.com", "user@example.com", false},
+		{"user+2Btag@example.com", "user+tag@example.com", false},
+		{"a+3Db", "a=b", false},
+		{"bad+2", "", true},
+		{"bad+ZZ", "", true},
+		{"a=b", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := decodeXtext(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("decodeXtext(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("decodeXtext(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// fakeDNSResolver answers MX and A/AAAA lookups from fixed tables
+type fakeDNSResolver struct {
+	mx      map[string][]*net.MX
+	ip      map[string][]net.IPAddr
+	lookups int // MX and A/AAAA queries answered
+}
+
+func (f *fakeDNSResolver) LookupMX(_ context.Context, name string) ([]*net.MX, error) {
+	f.lookups++
+	if records, ok := f.mx[name]; ok {
+		return records, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func (f *fakeDNSResolver) LookupIPAddr(_ context.Context, host string) ([]net.IPAddr, error) {
+	f.lookups++
+	if ips, ok := f.ip[host]; ok {
+		return ips, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func (f *fakeDNSResolver) LookupHost(_ context.Context, host string) ([]string, error) {
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func (f *fakeDNSResolver) LookupAddr(_ context.Context, addr string) ([]string, error) {
+	return nil, &net.DNSError{Err: "no such host", Name: addr, IsNotFound: true}
+}
+
+func TestEmailValidation_DNSWithFakeResolver(t *testing.T) {
+	resolver := &fakeDNSResolver{
+		mx: map[string][]*net.MX{"mx.example.com": {{Host: "mail.mx.example.com.", Pref: 10}}},
+		ip: map[string][]net.IPAddr{"a.example.com": {{IP: net.ParseIP("192.0.2.10")}}},
+	}
+
+	tests := []struct {
+		name          string
+		email         string
+		validation    []string
+		expectedError string
+	}{
+		{"MX present", "user@mx.example.com", []string{ValidationDNS_MX}, ""},
+		{"MX missing", "user@a.example.com", []string{ValidationDNS_MX}, "MX lookup failed"},
+		{"A present", "user@a.example.com", []string{ValidationDNS_A}, ""},
+		{"A missing", "user@mx.example.com", []string{ValidationDNS_A}, "A/AAAA lookup failed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.Server.EmailValidation = tt.validation
+			validator := NewEmailValidator(cfg)
+			validator.SetResolver(resolver)
+
+			_, err := validator.ParseEmailAddress(tt.email)
+			if tt.expectedError == "" {
+				if err != nil {
+					t.Errorf("ParseEmailAddress(%q) unexpected error: %v", tt.email, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+				t.Errorf("ParseEmailAddress(%q) error = %v, want containing %q", tt.email, err, tt.expectedError)
+			}
+		})
+	}
+}
+
+func TestEmailValidation_DNSCache(t *testing.T) {
+	resolver := &fakeDNSResolver{
+		mx: map[string][]*net.MX{"mx.example.com": {{Host: "mail.mx.example.com.", Pref: 10}}},
+		ip: map[string][]net.IPAddr{"mx.example.com": {{IP: net.ParseIP("192.0.2.10")}}},
+	}
+	cfg := config.DefaultConfig()
+	cfg.Server.EmailValidation = []string{ValidationBasic, ValidationDNS_MX, ValidationDNS_A}
+	cache := NewDNSCache(&cfg.Cache.DNS)
+	defer cache.Close()
+
+	// Each session has its own validator; the cache is shared between them
+	for range 3 {
+		validator := NewEmailValidator(cfg)
+		validator.SetResolver(resolver)
+		validator.SetDNSCache(cache)
+		if _, err := validator.ParseEmailAddress("user@mx.example.com"); err != nil {
+			t.Fatalf("ParseEmailAddress: %v", err)
+		}
+		if _, err := validator.ParseEmailAddress("user@missing.example.com"); err == nil || !strings.Contains(err.Error(), "MX") {
+			t.Fatalf("ParseEmailAddress(missing domain) error = %v", err)
+		}
+	}
+
+	// One MX and one A query for the good domain, one MX query for the missing one
+	if resolver.lookups != 3 {
+		t.Errorf("resolver queried %d times, want 3", resolver.lookups)
+	}
+
+	// Without a cache every validation goes to the resolver
+	resolver.lookups = 0
+	validator := NewEmailValidator(cfg)
+	validator.SetResolver(resolver)
+	for range 2 {
+		validator.ParseEmailAddress("user@mx.example.com")
+	}
+	if resolver.lookups != 4 {
+		t.Errorf("uncached resolver queried %d times, want 4", resolver.lookups)
+	}
+}
+
+func TestEmailValidation_DNSCacheSkipsTransientErrors(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Server.EmailValidation = []string{ValidationBasic, ValidationDNS_MX}
+	cache := NewDNSCache(&cfg.Cache.DNS)
+	defer cache.Close()
+
+	validator := NewEmailValidator(cfg)
+	validator.SetDNSCache(cache)
+	validator.rememberDNSResult("mx:timeout.example.com", false, &net.DNSError{Err: "i/o timeout", IsTimeout: true})
+	if _, ok := cache.Get("mx:timeout.example.com"); ok {
+		t.Error("a timed out lookup must not be cached")
+	}
+	validator.rememberDNSResult("mx:gone.example.com", false, &net.DNSError{Err: "no such host", IsNotFound: true})
+	if found, ok := cache.Get("mx:gone.example.com"); !ok || found {
+		t.Errorf("NXDOMAIN should be cached as negative, got found=%v ok=%v", found, ok)
+	}
+}
+
+func TestAppendDefaultDomain(t *testing.T) {
+	tests := []struct {
+		name          string
+		defaultDomain string
+		parse         func(*EmailValidator, []string) (*EmailAddress, error)
+		args          []string
+		want          string
+		wantErr       bool
+	}{
+		{"bare RCPT qualified", "example.com", (*EmailValidator).ParseRcptToCommand, []string{"TO:<root>"}, "root@example.com", false},
+		{"bare MAIL qualified", "example.com", (*EmailValidator).ParseMailFromCommand, []string{"FROM:<alice>"}, "alice@example.com", false},
+		{"full RCPT untouched", "example.com", (*EmailValidator).ParseRcptToCommand, []string{"TO:<bob@other.org>"}, "bob@other.org", false},
+		{"bare RCPT rejected when disabled", "", (*EmailValidator).ParseRcptToCommand, []string{"TO:<root>"}, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.Server.AppendDefaultDomain = tt.defaultDomain
+
+			addr, err := tt.parse(NewEmailValidator(cfg), tt.args)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected error, got %q", addr.Full)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if addr.Full != tt.want {
+				t.Errorf("Full: want %q, got %q", tt.want, addr.Full)
+			}
+		})
+	}
+}
+
+func TestValidateHelloHostname(t *testing.T) {
+	tests := []struct {
+		hostname string
+		valid    bool
+	}{
+		{"mail.example.org", true},
+		{"localhost", true},
+		{"", false},
+		{"mail example.org", false},
+		{"mail\x00.example.org", false},
+		{"mail\t.example.org", false},
+		{"mail.example.org\r", false},
+		{"-mail.example.org", false},
+		{"mail..example.org", false},
+		{strings.Repeat("a", 64) + ".example.org", false},
+		{strings.Repeat("a.", 127) + "org", false},
+	}
+
+	for _, tt := range tests {
+		err := ValidateHelloHostname(tt.hostname)
+		if (err == nil) != tt.valid {
+			t.Errorf("ValidateHelloHostname(%q) error = %v, want valid=%v", tt.hostname, err, tt.valid)
+		}
+	}
+}
@@ -20,20 +20,29 @@ type ConnectionType string
 const (
 	ConnectionTypeTCP    ConnectionType = "tcp"
 	ConnectionTypeSocket ConnectionType = "socket"
+	ConnectionTypeLMTP   ConnectionType = "lmtp" // TCP listener speaking LMTP (RFC 2033)
 )
 
 // ListenerMode mirrors config.ListenerMode in the smtp package
 type ListenerMode = config.ListenerMode
 
+// ListenerRole mirrors config.ListenerRole in the smtp package
+type ListenerRole = config.ListenerRole
+
 // ConnectionContext contains information about the connection
 type ConnectionContext struct {
-	Type        ConnectionType
-	Port        int
-	Mode        ListenerMode  // plain, starttls, tls
-	TLS         bool          // true once TLS is active (implicit on 465, after STARTTLS on 587)
-	ClientIP    string
-	Credentials *SocketCredentials
-	TLSConfig   *tls.Config   // non-nil when STARTTLS upgrade is possible
+	Type           ConnectionType
+	Port           int
+	Mode           ListenerMode // plain, starttls, tls, lmtp
+	Role           ListenerRole // relay or submission; empty = inferred from Port
+	AuthMechanisms []string     // SASL mechanisms allowed on the listener; empty = all enabled in auth.mechanisms
+	TLS            bool         // true once TLS is active (implicit on 465, after STARTTLS on 587)
+	ClientIP       string
+	ReverseDNS     string   // client hostname from rDNS lookup, empty if unknown
+	Allowlisted    bool     // client IP in security.allowlist; rDNS and DNSBL were not checked
+	DNSBL          []string // DNSBL providers listing the client IP (action log, discard or tag)
+	Credentials    *SocketCredentials
+	TLSConfig      *tls.Config // non-nil when STARTTLS upgrade is possible
 }
 
 // SocketCredentials represents Unix socket peer credentials
@@ -50,18 +59,23 @@ type SMTPHandler interface {
 
 // Strategy interfaces for different session behaviors
 type HeaderGenerator interface {
+	// GenerateHeaders returns trace headers prepended to the message as it is received
 	GenerateHeaders(msg *queue.Message, connCtx ConnectionContext) string
+	// CompleteHeaders adds missing fields to, or repairs fields of, the stored
+	// header block and reports whether it changed anything
+	CompleteHeaders(fields []string, msg *queue.Message) ([]string, bool)
 }
 
 // ValidationContext carries per-call context for sender and recipient validation.
 // ClientIP and EHLOHostname are available for future SPF/network checks.
 // RecipientType is set only when calling ValidateRecipient.
 type ValidationContext struct {
-	Username      string
-	Authenticated bool
-	ClientIP      string
-	EHLOHostname  string
-	RecipientType delivery.RecipientType
+	Username       string
+	Authenticated  bool
+	ClientIP       string
+	EHLOHostname   string
+	RecipientType  delivery.RecipientType
+	TrustedNetwork bool // TCP client within security.trusted_networks
 }
 
 type SessionValidator interface {
@@ -98,6 +112,9 @@ func NewSMTPHandler(
 	case ConnectionTypeTCP:
 		logger.Debug("Creating TCP session")
 		return NewTCPSession(connCtx, cfg, rawConn, textprotoConn, validator, deps)
+	case ConnectionTypeLMTP:
+		logger.Debug("Creating LMTP session")
+		return NewLMTPSession(connCtx, cfg, rawConn, textprotoConn, validator, deps)
 	default:
 		logger.Error("Unknown connection type", "type", connCtx.Type)
 		return NewTCPSession(connCtx, cfg, rawConn, textprotoConn, validator, deps)
@@ -114,17 +131,17 @@ func createSessionValidator(
 	switch connCtx.Type {
 	case ConnectionTypeSocket:
 		return NewSocketValidator(connCtx.Credentials, cfg, logger)
-	case ConnectionTypeTCP:
-		// Validator is selected by IANA-assigned port semantics:
-		//   25  = MTA-to-MTA relay (permissive sender, RCPT TO enforces relay policy)
-		//   587 = authenticated submission (STARTTLS + AUTH required)
-		//   465 = authenticated submission (implicit TLS + AUTH required)
-		//
-		// TODO: replace port-based inference with an explicit ListenerRole field
-		// (relay vs submission) in ListenerConfig so non-standard ports work correctly.
-		// Requires config changes and validation updates.
-		switch connCtx.Port {
-		case 587, 465:
+	case ConnectionTypeTCP, ConnectionTypeLMTP:
+		// Validator is selected by the listener role:
+		//   relay      = MTA-to-MTA (permissive sender, RCPT TO enforces relay policy)
+		//   submission = authenticated submission (AUTH required)
+		// Without an explicit role, IANA port semantics apply (587/465 = submission).
+		role := connCtx.Role
+		if role == "" {
+			role = config.DefaultListenerRole(connCtx.Port)
+		}
+		switch role {
+		case config.ListenerRoleSubmission:
 			return NewSubmissionValidator(authenticator, cfg)
 		default:
 			return NewRelayValidator(cfg)
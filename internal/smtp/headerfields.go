@@ -0,0 +1,59 @@
+package smtp
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/pawciobiel/golubsmtpd/internal/queue"
+)
+
+// rfc5322Date is the RFC 5322 §3.3 date-time layout written to Date headers
+const rfc5322Date = "Mon, 02 Jan 2006 15:04:05 -0700"
+
+// lenientDateLayouts are tried, after net/mail, to recover malformed dates
+var lenientDateLayouts = []string{
+	time.RFC3339,
+	time.ANSIC,
+	time.UnixDate,
+	time.RFC850,
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04:05 -0700",
+}
+
+// findHeaderField returns the index of the first field named name, or -1
+func findHeaderField(fields []string, name string) int {
+	for i, field := range fields {
+		if strings.EqualFold(queue.HeaderFieldName(field), name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// completeDateHeader adds a Date field when the header block lacks one and
+// rewrites an existing Date that does not parse as RFC 5322. A malformed date
+// that cannot be recovered is replaced with fallback.
+func completeDateHeader(fields []string, fallback time.Time) ([]string, bool) {
+	i := findHeaderField(fields, "Date")
+	if i == -1 {
+		return append(fields, fmt.Sprintf("Date: %s\r\n", fallback.Format(rfc5322Date))), true
+	}
+
+	_, value, _ := strings.Cut(fields[i], ":")
+	value = strings.Join(strings.Fields(value), " ") // unfold
+	if _, err := mail.ParseDate(value); err == nil {
+		return fields, false
+	}
+
+	date := fallback
+	for _, layout := range lenientDateLayouts {
+		if parsed, err := time.Parse(layout, value); err == nil {
+			date = parsed
+			break
+		}
+	}
+	fields[i] = fmt.Sprintf("Date: %s\r\n", date.Format(rfc5322Date))
+	return fields, true
+}
@@ -2,6 +2,7 @@ package smtp
 
 import (
 	"context"
+	"log/slog"
 	"os/user"
 	"time"
 
@@ -26,8 +27,8 @@ func NewRcptValidator(cfg *config.Config, authenticator auth.Authenticator, loca
 	return &RcptValidator{
 		config:           cfg,
 		authenticator:    authenticator,
-		systemCache:      NewLRUCache(cfg.Cache.SystemUsers.Capacity, cfg.Cache.SystemUsers.TTL),
-		virtualCache:     NewLRUCache(cfg.Cache.VirtualUsers.Capacity, cfg.Cache.VirtualUsers.TTL),
+		systemCache:      NewLRUCache(cfg.Cache.SystemUsers.Capacity, cfg.Cache.SystemUsers.TTL, cfg.Cache.SystemUsers.NegativeTTL),
+		virtualCache:     NewLRUCache(cfg.Cache.VirtualUsers.Capacity, cfg.Cache.VirtualUsers.TTL, cfg.Cache.VirtualUsers.NegativeTTL),
 		localAliasesMaps: localAliasesMaps,
 	}
 }
@@ -51,7 +52,7 @@ func (r *RcptValidator) IsRecipientValid(ctx context.Context, recipient string,
 
 // IsSystemUserEmailValid checks if email corresponds to a valid system user
 func (r *RcptValidator) IsSystemUserEmailValid(ctx context.Context, email string) bool {
-	username := auth.ExtractUsername(email)
+	username := auth.StripAddressExtension(auth.ExtractUsername(email), r.config.Delivery.Local.RecipientDelimiter)
 
 	// Check cache first
 	if exists, found := r.systemCache.Get(username); found {
@@ -59,6 +60,12 @@ func (r *RcptValidator) IsSystemUserEmailValid(ctx context.Context, email string
 		return exists
 	}
 
+	return r.lookupSystemUser(ctx, username)
+}
+
+// lookupSystemUser resolves username against the system user database and
+// caches the result
+func (r *RcptValidator) lookupSystemUser(ctx context.Context, username string) bool {
 	// System lookup with timeout
 	lookupCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
 	defer cancel()
@@ -94,16 +101,58 @@ func (r *RcptValidator) IsVirtualUserEmailValid(ctx context.Context, email strin
 	return exists
 }
 
-// ResolveLocalAlias resolves local aliases to actual recipients
+// ResolveLocalAlias resolves local aliases to actual recipients. An alias with
+// an address extension ("staff+urgent") falls back to the bare alias.
 func (r *RcptValidator) ResolveLocalAlias(alias string) []string {
 	if r.localAliasesMaps == nil {
 		return nil
 	}
-	return r.localAliasesMaps.ResolveAlias(alias)
+	if recipients := r.localAliasesMaps.ResolveAlias(alias); len(recipients) > 0 {
+		return recipients
+	}
+	if bare := auth.StripAddressExtension(alias, r.config.Delivery.Local.RecipientDelimiter); bare != alias {
+		return r.localAliasesMaps.ResolveAlias(bare)
+	}
+	return nil
+}
+
+// Warm pre-populates the system user cache for known frequent recipients so
+// their first RCPT TO does not pay for a user database lookup
+func (r *RcptValidator) Warm(ctx context.Context, addresses []string) {
+	for _, address := range addresses {
+		if ctx.Err() != nil {
+			return
+		}
+		username := auth.StripAddressExtension(auth.ExtractUsername(address), r.config.Delivery.Local.RecipientDelimiter)
+		r.lookupSystemUser(ctx, username)
+	}
+}
+
+// RcptCacheStats holds the statistics of both recipient lookup caches
+type RcptCacheStats struct {
+	System  CacheStats
+	Virtual CacheStats
+}
+
+// LogValue renders the stats as a log group
+func (s RcptCacheStats) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.Any("system", s.System),
+		slog.Any("virtual", s.Virtual),
+	)
+}
+
+// CacheStats returns the system and virtual user cache statistics
+func (r *RcptValidator) CacheStats() RcptCacheStats {
+	return RcptCacheStats{
+		System:  r.systemCache.Snapshot(),
+		Virtual: r.virtualCache.Snapshot(),
+	}
 }
 
 // Close cleans up resources
 func (r *RcptValidator) Close() error {
+	logging.GetLogger().Debug("Recipient cache stats", "cache", r.CacheStats())
 	r.systemCache.Close()
 	r.virtualCache.Close()
 	return nil
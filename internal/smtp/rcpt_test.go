@@ -45,6 +45,10 @@ func (m *mockAuthenticator) Close() error {
 	return nil
 }
 
+func (m *mockAuthenticator) Capabilities() auth.AuthCapabilities {
+	return auth.AuthCapabilities{SupportsUserValidation: true}
+}
+
 func TestRcptValidator_ResolveLocalAlias(t *testing.T) {
 	// Get current user for valid system user
 	currentUser, err := user.Current()
@@ -166,4 +170,88 @@ func TestRcptValidator_ResolveLocalAlias_NoMaps(t *testing.T) {
 	if aliases != nil {
 		t.Errorf("Expected nil for no aliases maps, got %v", aliases)
 	}
-}
\ No newline at end of file
+}
+func TestRcptValidator_RecipientDelimiter(t *testing.T) {
+	currentUser, err := user.Current()
+	if err != nil {
+		t.Skipf("Cannot get current user for test: %v", err)
+	}
+	tagged := currentUser.Username + "+lists@localhost"
+
+	tests := []struct {
+		name      string
+		delimiter string
+		want      bool
+	}{
+		{"disabled", "", false},
+		{"plus", "+", true},
+		{"any of several", "-+", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.Delivery.Local.RecipientDelimiter = tt.delimiter
+			v := NewRcptValidator(cfg, &mockAuthenticator{}, nil)
+			defer v.Close()
+
+			if got := v.IsSystemUserEmailValid(context.Background(), tagged); got != tt.want {
+				t.Errorf("IsSystemUserEmailValid(%q) = %v, want %v", tagged, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRcptValidator_CacheStats(t *testing.T) {
+	currentUser, err := user.Current()
+	if err != nil {
+		t.Skipf("Cannot get current user for test: %v", err)
+	}
+	cfg := config.DefaultConfig()
+	v := NewRcptValidator(cfg, &mockAuthenticator{}, nil)
+	defer v.Close()
+
+	email := currentUser.Username + "@localhost"
+	for i := 0; i < 4; i++ {
+		if !v.IsSystemUserEmailValid(context.Background(), email) {
+			t.Fatalf("IsSystemUserEmailValid(%q) = false", email)
+		}
+	}
+	v.IsVirtualUserEmailValid(context.Background(), "alice@example.com")
+
+	stats := v.CacheStats()
+	want := RcptCacheStats{
+		System:  CacheStats{Size: 1, Capacity: cfg.Cache.SystemUsers.Capacity, Hits: 3, Misses: 1, HitRate: 0.75},
+		Virtual: CacheStats{Size: 1, Capacity: cfg.Cache.VirtualUsers.Capacity, Hits: 0, Misses: 1, HitRate: 0},
+	}
+	if diff := cmp.Diff(want, stats); diff != "" {
+		t.Errorf("CacheStats mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestRcptValidator_Warm(t *testing.T) {
+	currentUser, err := user.Current()
+	if err != nil {
+		t.Skipf("Cannot get current user for test: %v", err)
+	}
+	cfg := config.DefaultConfig()
+	cfg.Delivery.Local.RecipientDelimiter = "+"
+	v := NewRcptValidator(cfg, &mockAuthenticator{}, nil)
+	defer v.Close()
+
+	v.Warm(context.Background(), []string{
+		currentUser.Username + "+lists@localhost",
+		"nosuchuser-golubsmtpd-test@localhost",
+	})
+	if got := v.CacheStats().System.Size; got != 2 {
+		t.Fatalf("system cache size after warming = %d, want 2", got)
+	}
+
+	// The first lookup after warming is already a hit
+	if !v.IsSystemUserEmailValid(context.Background(), currentUser.Username+"@localhost") {
+		t.Fatalf("warmed user should be valid")
+	}
+	if stats := v.CacheStats().System; stats.Hits != 1 || stats.Misses != 0 {
+		t.Errorf("hits=%d misses=%d after warm lookup, want 1 and 0", stats.Hits, stats.Misses)
+	}
+}
@@ -19,6 +19,7 @@ const (
 	StatusMailboxBusy         = 450
 	StatusLocalError          = 451
 	StatusInsufficientStorage = 452
+	StatusTempAuthFailure     = 454
 
 	// Permanent negative completion replies (5xx)
 	StatusSyntaxError        = 500
@@ -35,7 +36,8 @@ const (
 	StatusTransactionFailed  = 554
 )
 
-// Standard SMTP response messages
+// Standard SMTP response messages. Sessions replace them per code with
+// server.response_messages, so this table itself is never modified.
 var ResponseMessages = map[int]string{
 	StatusReady:               "Service ready",
 	StatusClosing:             "Service closing transmission channel",
@@ -47,6 +49,7 @@ var ResponseMessages = map[int]string{
 	StatusMailboxBusy:         "Requested mail action not taken: mailbox unavailable",
 	StatusLocalError:          "Requested action aborted: local error in processing",
 	StatusInsufficientStorage: "Requested action not taken: insufficient system storage",
+	StatusTempAuthFailure:     "Temporary authentication failure",
 	StatusSyntaxError:         "Syntax error, command unrecognized",
 	StatusParamError:          "Syntax error in parameters or arguments",
 	StatusCommandNotImpl:      "Command not implemented",
@@ -0,0 +1,43 @@
+package smtp
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pawciobiel/golubsmtpd/internal/config"
+)
+
+func TestSessionResponse_Overrides(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Server.ResponseMessages = map[int]string{StatusOK: "Zrobione"}
+	sess, conn := newTestTCPSession(t, cfg)
+
+	if got := sess.response(StatusOK, ""); got != "250 Zrobione" {
+		t.Errorf("response(StatusOK, \"\") = %q, want %q", got, "250 Zrobione")
+	}
+	if err := sess.handleRset(context.Background(), nil); err != nil {
+		t.Fatalf("handleRset: %v", err)
+	}
+	if got := conn.lastResponse(); got != "250 Zrobione" {
+		t.Errorf("replies with specific text must use the override, got %q", got)
+	}
+	if got := sess.response(StatusBadSequence, "Bad sequence of commands"); got != "503 Bad sequence of commands" {
+		t.Errorf("codes without an override keep their text, got %q", got)
+	}
+	if got := Response(StatusOK, ""); got != "250 Requested mail action okay, completed" {
+		t.Errorf("the default table must not be modified, got %q", got)
+	}
+}
+
+func TestSessionResponse_Greeting(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Server.ResponseMessages = map[int]string{StatusReady: "Witamy"}
+	sess, conn := newTestTCPSession(t, cfg)
+
+	if err := sess.sendGreeting(); err != nil {
+		t.Fatalf("sendGreeting: %v", err)
+	}
+	if got, want := conn.lastResponse(), "220 "+sess.hostname+" ESMTP Witamy"; got != want {
+		t.Errorf("greeting = %q, want %q", got, want)
+	}
+}
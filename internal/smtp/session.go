@@ -1,21 +1,28 @@
 package smtp
 
 import (
+	"bufio"
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
-	"io"
 	"log/slog"
 	"net"
 	"net/textproto"
+	"os"
+	"slices"
 	"strings"
 	"time"
 
+	"github.com/google/uuid"
+	"github.com/pawciobiel/golubsmtpd/internal/aliases"
 	"github.com/pawciobiel/golubsmtpd/internal/auth"
 	"github.com/pawciobiel/golubsmtpd/internal/config"
 	"github.com/pawciobiel/golubsmtpd/internal/delivery"
 	"github.com/pawciobiel/golubsmtpd/internal/logging"
 	"github.com/pawciobiel/golubsmtpd/internal/queue"
+	"github.com/pawciobiel/golubsmtpd/internal/security"
+	"github.com/pawciobiel/golubsmtpd/internal/types"
 )
 
 // SessionState represents the current state of an SMTP session
@@ -36,6 +43,7 @@ type SessionHandlerFunc func(ctx context.Context, sess *Session) error
 
 // Session represents an SMTP session with a client
 type Session struct {
+	id             string // identifies the session in the transaction access log
 	config         *config.Config
 	logger         *slog.Logger
 	rawConn        net.Conn        // underlying TCP connection (needed for STARTTLS upgrade)
@@ -46,6 +54,17 @@ type Session struct {
 	emailValidator *EmailValidator
 	rcptValidator  *RcptValidator
 	queue          *queue.Queue
+	dnsblChecker   SenderDomainChecker
+	canonicalMaps  *aliases.CanonicalMaps
+	senderAccess   *aliases.AccessMaps
+	recipientAccess *aliases.AccessMaps
+	contentChecker  *security.ContentChecker
+	rejectScorer    *security.RejectScorer // refuses MAIL FROM on combined weak signals; nil disables
+	tokenValidator auth.TokenValidator
+	rateLimiter    *security.SubmissionLimiter
+	userSessions   *security.UserSessionLimiter
+	trustedNetworks []*net.IPNet // may relay without AUTH
+	expnNetworks    []*net.IPNet // may use EXPN
 
 	// Strategy interfaces for different behaviors
 	headerGenerator HeaderGenerator
@@ -59,13 +78,21 @@ type Session struct {
 	clientHelloHostname string
 	authenticated       bool
 	username            string
+	userSessionHeld     bool // a userSessions slot is held for username
+	unknownCommands     int // unrecognised commands seen, for disconnect_on_unknown
+	invalidRecipients   int // consecutive RCPTs answered User unknown, for invalid_recipients
+	transactions        int // messages accepted on this connection, for max_transactions_per_connection
+	acceptedRcpts       int // RCPTs answered 250 in the current transaction; LMTP replies to DATA once for each
+	rcptAttempts        int // RCPTs received in the current transaction, accepted or not
 
 	// Message being built during session
 	currentMessage *queue.Message
+	txStart        time.Time // when the current transaction's MAIL FROM arrived
 
 	// Security checks
 	reverseDNS   string
-	dnsblResults []string
+	dnsblResults []string // providers listing the client IP, for the whole connection
+	senderDNSBL  []string // providers listing the current transaction's sender domain
 }
 
 // NewSession creates a new SMTP session with strategies
@@ -81,30 +108,51 @@ func NewSession(
 	sessionHandler SessionHandlerFunc,
 	connCtx ConnectionContext,
 ) *Session {
+	rcptValidator := deps.RcptValidator
+	if rcptValidator == nil {
+		rcptValidator = NewRcptValidator(cfg, deps.Authenticator, deps.LocalAliasesMaps)
+	}
+	emailValidator := NewEmailValidator(cfg)
+	emailValidator.SetDNSCache(deps.DNSCache)
 	return &Session{
+		id:              queue.GenerateID(),
 		config:          cfg,
 		logger:          logging.GetLogger(),
 		rawConn:         rawConn,
 		textproto:       textprotoConn,
 		clientIP:        clientIP,
-		hostname:        cfg.Server.Hostname,
+		hostname:        cfg.Server.AdvertisedHostname(),
 		authenticator:   deps.Authenticator,
-		emailValidator:  NewEmailValidator(cfg),
-		rcptValidator:   NewRcptValidator(cfg, deps.Authenticator, deps.LocalAliasesMaps),
+		emailValidator:  emailValidator,
+		rcptValidator:   rcptValidator,
 		queue:           deps.Queue,
+		dnsblChecker:    deps.DNSBLChecker,
+		canonicalMaps:   deps.CanonicalMaps,
+		senderAccess:    deps.SenderAccess,
+		recipientAccess: deps.RecipientAccess,
+		contentChecker:  deps.ContentChecker,
+		rejectScorer:    deps.RejectScorer,
+		tokenValidator:  deps.TokenValidator,
+		rateLimiter:     deps.SubmissionLimit,
+		userSessions:    deps.UserSessions,
+		trustedNetworks: deps.TrustedNetworks,
+		expnNetworks:    deps.ExpnNetworks,
 		headerGenerator: headerGenerator,
 		senderValidator: senderValidator,
 		dataHandler:     dataHandler,
 		sessionHandler:  sessionHandler,
 		connCtx:         connCtx,
 		state:           StateConnected,
+		reverseDNS:      connCtx.ReverseDNS,
+		dnsblResults:    slices.Clone(connCtx.DNSBL),
 	}
 }
 
-// containsDomain checks if a domain exists in a slice (case-insensitive)
+// containsDomain checks if a domain matches an entry of a slice (case-insensitive,
+// ".example.com" entries also match subdomains)
 func containsDomain(domains []string, domain string) bool {
 	for _, d := range domains {
-		if strings.EqualFold(d, domain) {
+		if config.DomainMatches(d, domain) {
 			return true
 		}
 	}
@@ -131,17 +179,52 @@ func (sess *Session) Handle(ctx context.Context) error {
 	return sess.sessionHandler(ctx, sess)
 }
 
+// errEarlyTalker is returned when a client sends data before the greeting banner
+var errEarlyTalker = errors.New("client sent data before greeting")
+
 func (sess *Session) sendGreeting() error {
+	if err := sess.greetingDelay(sess.config.Security.GreetingDelay); err != nil {
+		return err
+	}
+
+	// LMTP clients must still introduce themselves with LHLO (RFC 2033 §4.1)
+	if sess.connCtx.Type == ConnectionTypeLMTP {
+		return sess.writeResponse(ResponseWithHostname(StatusReady, sess.hostname, "LMTP "+sess.replyText(StatusReady, ResponseMessages[StatusReady])))
+	}
+
 	sess.state = StateGreeted
-	greeting := ResponseWithHostname(StatusReady, sess.hostname, "ESMTP Service ready")
+	greeting := ResponseWithHostname(StatusReady, sess.hostname, "ESMTP "+sess.replyText(StatusReady, ResponseMessages[StatusReady]))
 	return sess.writeResponse(greeting)
 }
 
+// greetingDelay holds back the banner for delay (0 = disabled) and rejects with
+// 554 a client that talks first: RFC 5321 clients wait for the 220, many bots don't
+func (sess *Session) greetingDelay(delay time.Duration) error {
+	if delay <= 0 || sess.rawConn == nil {
+		return nil
+	}
+
+	sess.setReadDeadline(delay)
+	_, err := sess.textproto.R.Peek(1)
+	sess.setReadDeadline(sess.config.Server.CommandTimeout)
+
+	if err == nil {
+		sess.logger.Info("Client spoke before greeting, rejecting", "client_ip", sess.clientIP, "greeting_delay", delay)
+		sess.state = StateClosed
+		sess.writeResponse(sess.responseWithHostname(StatusTransactionFailed, "Protocol violation: data sent before greeting")) //nolint:errcheck
+		return errEarlyTalker
+	}
+	if !isTimeoutError(err) {
+		return err
+	}
+	return nil
+}
+
 func (sess *Session) processCommand(ctx context.Context, line string) error {
 	// Parse command and arguments
 	parts := strings.Fields(line)
 	if len(parts) == 0 {
-		return sess.writeResponse(Response(StatusSyntaxError, ""))
+		return sess.writeResponse(sess.response(StatusSyntaxError, ""))
 	}
 
 	command := strings.ToUpper(parts[0])
@@ -157,6 +240,25 @@ func (sess *Session) processCommand(ctx context.Context, line string) error {
 	//	line = line[1:]
 	//}
 
+	// LMTP replaces HELO/EHLO with LHLO; other sessions do not know LHLO
+	if sess.connCtx.Type == ConnectionTypeLMTP {
+		switch command {
+		case "LHLO":
+			return sess.handleEhlo(ctx, args)
+		case "HELO", "EHLO":
+			return sess.writeResponse(sess.response(StatusSyntaxError, "LHLO required"))
+		}
+	}
+
+	if !knownCommands[command] {
+		return sess.rejectUnknownCommand(command)
+	}
+	// QUIT always works so a client can leave cleanly
+	if command != "QUIT" && !sess.config.Server.CommandEnabled(command) {
+		sess.logger.Debug("Disabled command rejected", "command", command, "client_ip", sess.clientIP)
+		return sess.writeResponse(sess.response(StatusCommandNotImpl, "Command disabled"))
+	}
+
 	switch command {
 	case "HELO":
 		return sess.handleHelo(ctx, args)
@@ -176,21 +278,97 @@ func (sess *Session) processCommand(ctx context.Context, line string) error {
 		return sess.handleRset(ctx, args)
 	case "NOOP":
 		return sess.handleNoop(ctx, args)
+	case "EXPN":
+		return sess.handleExpn(ctx, args)
 	case "QUIT":
 		return sess.handleQuit(ctx, args)
 	default:
-		return sess.writeResponse(Response(StatusCommandNotImpl, "Command not implemented"))
+		return sess.writeResponse(sess.response(StatusCommandNotImpl, "Command not implemented"))
 	}
 }
 
+// errLineTooLong is returned by readCommandLine for a line over max_line_length
+var errLineTooLong = errors.New("command line too long")
+
+// readCommandLine reads one command line without its line ending. A line
+// longer than max_line_length is consumed up to its end without being kept in
+// memory, and errLineTooLong is returned.
+func (sess *Session) readCommandLine() (string, error) {
+	maxLen := sess.config.Server.MaxLineLength
+	if maxLen <= 0 {
+		return sess.textproto.ReadLine()
+	}
+
+	var line []byte
+	tooLong := false
+	for {
+		chunk, err := sess.textproto.R.ReadSlice('\n')
+		if !tooLong {
+			line = append(line, chunk...)
+			// Allow for the CRLF still to be trimmed
+			if len(line) > maxLen+2 {
+				tooLong, line = true, nil
+			}
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		break
+	}
+
+	text := strings.TrimSuffix(strings.TrimSuffix(string(line), "\n"), "\r")
+	if tooLong || len(text) > maxLen {
+		return "", errLineTooLong
+	}
+	return text, nil
+}
+
+// rejectLongLine answers 500 for a line over max_line_length; like an unknown
+// command it counts toward disconnect_on_unknown
+func (sess *Session) rejectLongLine() error {
+	sess.logger.Debug("Command line too long", "max_line_length", sess.config.Server.MaxLineLength, "client_ip", sess.clientIP)
+	return sess.rejectBadCommand("(line too long)", "Line too long")
+}
+
+// knownCommands are the SMTP verbs recognised by the server; the ones without a
+// handler (VRFY, HELP) are answered 502 rather than 500
+var knownCommands = map[string]bool{
+	"HELO": true, "EHLO": true, "STARTTLS": true, "AUTH": true,
+	"MAIL": true, "RCPT": true, "DATA": true, "RSET": true,
+	"NOOP": true, "QUIT": true, "VRFY": true, "EXPN": true, "HELP": true,
+}
+
+// rejectUnknownCommand answers 500 for an unrecognised command and closes the
+// session with 421 once disconnect_on_unknown is reached
+func (sess *Session) rejectUnknownCommand(command string) error {
+	return sess.rejectBadCommand(command, "Command unrecognized")
+}
+
+// rejectBadCommand answers 500 with text, counting the command toward
+// disconnect_on_unknown
+func (sess *Session) rejectBadCommand(command, text string) error {
+	sess.unknownCommands++
+	limit := sess.config.Server.DisconnectOnUnknown
+	if limit > 0 && sess.unknownCommands >= limit {
+		sess.logger.Info("Too many unknown commands, closing connection",
+			"client_ip", sess.clientIP, "count", sess.unknownCommands, "last_command", command)
+		sess.state = StateClosed
+		return sess.writeResponse(sess.responseWithHostname(StatusTempFailure, "Too many unknown commands, closing connection"))
+	}
+	return sess.writeResponse(sess.response(StatusSyntaxError, text))
+}
+
 func (sess *Session) handleHelo(ctx context.Context, args []string) error {
 	if len(args) == 0 {
-		return sess.writeResponse(Response(StatusParamError, "HELO requires domain"))
+		return sess.writeResponse(sess.response(StatusParamError, "HELO requires domain"))
 	}
 
 	hostname := args[0]
 	if err := ValidateHelloHostname(hostname); err != nil {
-		return sess.writeResponse(Response(StatusParamError, "Invalid hostname"))
+		return sess.writeResponse(sess.response(StatusParamError, "Invalid hostname"))
 	}
 
 	sess.clientHelloHostname = hostname
@@ -201,38 +379,42 @@ func (sess *Session) handleHelo(ctx context.Context, args []string) error {
 
 func (sess *Session) handleEhlo(ctx context.Context, args []string) error {
 	if len(args) == 0 {
-		return sess.writeResponse(Response(StatusParamError, "EHLO requires domain"))
+		return sess.writeResponse(sess.response(StatusParamError, "EHLO requires domain"))
 	}
 
 	hostname := args[0]
 	if err := ValidateHelloHostname(hostname); err != nil {
-		return sess.writeResponse(Response(StatusParamError, "Invalid hostname"))
+		return sess.writeResponse(sess.response(StatusParamError, "Invalid hostname"))
 	}
 
 	sess.clientHelloHostname = hostname
 	sess.state = StateGreeted
 
-	capabilities := []string{
-		fmt.Sprintf("250-%s Hello %s [%s]", sess.hostname, sess.clientHelloHostname, sess.clientIP),
-	}
+	var extensions []string
 
 	// Advertise STARTTLS only on starttls-mode listeners and only if TLS not yet active
-	if sess.connCtx.Mode == config.ListenerModeSTARTTLS && !sess.connCtx.TLS {
-		capabilities = append(capabilities, "250-STARTTLS")
+	if sess.connCtx.Mode == config.ListenerModeSTARTTLS && !sess.connCtx.TLS && sess.config.Server.CommandEnabled("STARTTLS") {
+		extensions = append(extensions, "STARTTLS")
 	}
 
 	// Advertise AUTH only once TLS is active (or on implicit-TLS port)
-	if sess.connCtx.TLS || sess.connCtx.Mode == config.ListenerModePlain {
-		capabilities = append(capabilities, "250-AUTH PLAIN LOGIN")
+	if (sess.connCtx.TLS || sess.connCtx.Mode == config.ListenerModePlain) && sess.config.Server.CommandEnabled("AUTH") {
+		if mechanisms := sess.authMechanismNames(); len(mechanisms) > 0 {
+			extensions = append(extensions, "AUTH "+strings.Join(mechanisms, " "))
+		}
 	}
 
-	capabilities = append(capabilities, "250 HELP")
+	// Message data is stored byte for byte, so 8-bit content passes through intact
+	extensions = append(extensions, "8BITMIME", "DSN", "HELP")
 
-	for i, resp := range capabilities {
-		if i == len(capabilities)-1 {
-			resp = strings.Replace(resp, "250-", "250 ", 1)
+	lines := append([]string{fmt.Sprintf("%s Hello %s [%s]", sess.hostname, sess.clientHelloHostname, sess.clientIP)},
+		sess.applyEhloOverrides(extensions)...)
+	for i, line := range lines {
+		sep := "-"
+		if i == len(lines)-1 {
+			sep = " "
 		}
-		if err := sess.writeResponse(resp); err != nil {
+		if err := sess.writeResponse("250" + sep + line); err != nil {
 			return err
 		}
 	}
@@ -240,29 +422,65 @@ func (sess *Session) handleEhlo(ctx context.Context, args []string) error {
 	return nil
 }
 
+// applyEhloOverrides applies server.ehlo_extensions to the computed EHLO
+// extensions: keywords forced off are dropped, keywords forced on that are
+// missing are appended in sorted order. Overrides only change what is
+// advertised; command availability is governed by enabled_commands.
+func (sess *Session) applyEhloOverrides(extensions []string) []string {
+	overrides := sess.config.Server.EhloExtensions
+	if len(overrides) == 0 {
+		return extensions
+	}
+
+	forced := make(map[string]bool, len(overrides))
+	for keyword, enabled := range overrides {
+		forced[strings.ToUpper(keyword)] = enabled
+	}
+
+	result := make([]string, 0, len(extensions)+len(forced))
+	present := make(map[string]bool)
+	for _, ext := range extensions {
+		keyword, _, _ := strings.Cut(ext, " ")
+		if enabled, ok := forced[keyword]; ok && !enabled {
+			continue
+		}
+		present[keyword] = true
+		result = append(result, ext)
+	}
+
+	var added []string
+	for keyword, enabled := range forced {
+		if !enabled || present[keyword] {
+			continue
+		}
+		if keyword == "SIZE" && sess.config.Server.MaxMessageSize > 0 {
+			added = append(added, fmt.Sprintf("SIZE %d", sess.config.Server.MaxMessageSize))
+			continue
+		}
+		added = append(added, keyword)
+	}
+	slices.Sort(added)
+	return append(result, added...)
+}
+
 func (sess *Session) handleAuth(ctx context.Context, args []string) error {
 	if len(args) == 0 {
-		return sess.writeResponse(Response(StatusParamError, "AUTH requires mechanism"))
+		return sess.writeResponse(sess.response(StatusParamError, "AUTH requires mechanism"))
 	}
 
 	if sess.state != StateGreeted {
-		return sess.writeResponse(Response(StatusBadSequence, "EHLO/HELO required before AUTH"))
+		return sess.writeResponse(sess.response(StatusBadSequence, "EHLO/HELO required before AUTH"))
 	}
 
 	if sess.authenticated {
-		return sess.writeResponse(Response(StatusBadSequence, "Already authenticated"))
+		return sess.writeResponse(sess.response(StatusBadSequence, "Already authenticated"))
 	}
 
-	mechanism := strings.ToUpper(args[0])
-
-	switch mechanism {
-	case "PLAIN":
-		return sess.handleAuthPlain(ctx, args[1:])
-	case "LOGIN":
-		return sess.handleAuthLogin(ctx, args[1:])
-	default:
-		return sess.writeResponse(Response(StatusParamError, "Authentication mechanism not supported"))
+	mechanism, ok := sess.authMechanism(args[0])
+	if !ok {
+		return sess.writeResponse(sess.response(StatusParamError, "Authentication mechanism not supported"))
 	}
+	return mechanism.Handle(ctx, sess, args[1:])
 }
 
 func (sess *Session) handleAuthPlain(ctx context.Context, args []string) error {
@@ -283,16 +501,16 @@ func (sess *Session) handleAuthPlain(ctx context.Context, args []string) error {
 	}
 
 	if credentials == "*" {
-		return sess.writeResponse(Response(StatusAuthRequired, "Authentication cancelled"))
+		return sess.writeResponse(sess.response(StatusAuthRequired, "Authentication cancelled"))
 	}
 
 	username, password, err := auth.DecodePlain(credentials)
 	if err != nil {
 		sess.logger.Debug("AUTH PLAIN decode failed", "error", err, "client_ip", sess.clientIP)
-		return sess.writeResponse(Response(StatusAuthRequired, "Authentication failed"))
+		return sess.writeResponse(sess.response(StatusAuthRequired, "Authentication failed"))
 	}
 
-	return sess.authenticateUser(ctx, username, password)
+	return sess.authenticateUser(ctx, "PLAIN", username, password)
 }
 
 func (sess *Session) handleAuthLogin(ctx context.Context, args []string) error {
@@ -306,13 +524,13 @@ func (sess *Session) handleAuthLogin(ctx context.Context, args []string) error {
 	}
 
 	if userLine == "*" {
-		return sess.writeResponse(Response(StatusAuthRequired, "Authentication cancelled"))
+		return sess.writeResponse(sess.response(StatusAuthRequired, "Authentication cancelled"))
 	}
 
 	username, err := auth.DecodeBase64(userLine)
 	if err != nil {
 		sess.logger.Debug("AUTH LOGIN username decode failed", "error", err, "client_ip", sess.clientIP)
-		return sess.writeResponse(Response(StatusAuthRequired, "Authentication failed"))
+		return sess.writeResponse(sess.response(StatusAuthRequired, "Authentication failed"))
 	}
 
 	if err := sess.writeResponse("334 " + auth.EncodeBase64("Password:")); err != nil {
@@ -325,51 +543,219 @@ func (sess *Session) handleAuthLogin(ctx context.Context, args []string) error {
 	}
 
 	if passLine == "*" {
-		return sess.writeResponse(Response(StatusAuthRequired, "Authentication cancelled"))
+		return sess.writeResponse(sess.response(StatusAuthRequired, "Authentication cancelled"))
 	}
 
 	password, err := auth.DecodeBase64(passLine)
 	if err != nil {
 		sess.logger.Debug("AUTH LOGIN password decode failed", "error", err, "client_ip", sess.clientIP)
-		return sess.writeResponse(Response(StatusAuthRequired, "Authentication failed"))
+		return sess.writeResponse(sess.response(StatusAuthRequired, "Authentication failed"))
+	}
+
+	return sess.authenticateUser(ctx, "LOGIN", username, password)
+}
+
+// handleAuthExternal authenticates the session as the user mapped from a
+// verified TLS client certificate (RFC 4422 Appendix A). The optional
+// authorization identity must match the mapped username when given.
+func (sess *Session) handleAuthExternal(ctx context.Context, args []string) error {
+	username := sess.clientCertUsername()
+	if username == "" {
+		return sess.writeResponse(sess.response(StatusParamError, "Authentication mechanism not supported"))
+	}
+
+	var response string
+	if len(args) > 0 {
+		response = args[0]
+	} else {
+		if err := sess.writeResponse("334 "); err != nil {
+			return err
+		}
+
+		line, err := sess.textproto.ReadLine()
+		if err != nil {
+			return fmt.Errorf("failed to read AUTH EXTERNAL response: %w", err)
+		}
+		response = line
+	}
+
+	if response == "*" {
+		return sess.writeResponse(sess.response(StatusAuthRequired, "Authentication cancelled"))
+	}
+
+	// "=" is an empty initial response; an empty line is an empty continuation
+	if response != "=" && response != "" {
+		authzid, err := auth.DecodeBase64(response)
+		if err != nil {
+			sess.logger.Debug("AUTH EXTERNAL decode failed", "error", err, "client_ip", sess.clientIP)
+			return sess.writeResponse(sess.response(StatusAuthRequired, "Authentication failed"))
+		}
+		if authzid != "" && authzid != username {
+			sess.logger.Warn("AUTH EXTERNAL authorization identity mismatch",
+				"username", username, "authzid", authzid, "client_ip", sess.clientIP)
+			return sess.writeResponse(sess.response(StatusAuthRequired, "Authentication failed"))
+		}
+	}
+
+	return sess.completeAuth(username, "EXTERNAL")
+}
+
+// xoauth2ErrorChallenge is the RFC 7628 §3.2.2 error sent before failing XOAUTH2
+var xoauth2ErrorChallenge = auth.EncodeBase64(`{"status":"invalid_token","schemes":"bearer"}`)
+
+// handleAuthXOAuth2 authenticates with an OAuth2 bearer token. The token's
+// owner, as reported by the TokenValidator, must match the user in the payload.
+func (sess *Session) handleAuthXOAuth2(ctx context.Context, args []string) error {
+	var payload string
+	if len(args) > 0 {
+		payload = args[0]
+	} else {
+		if err := sess.writeResponse("334 "); err != nil {
+			return err
+		}
+
+		line, err := sess.textproto.ReadLine()
+		if err != nil {
+			return fmt.Errorf("failed to read AUTH XOAUTH2 payload: %w", err)
+		}
+		payload = line
+	}
+
+	if payload == "*" {
+		return sess.writeResponse(sess.response(StatusAuthRequired, "Authentication cancelled"))
+	}
+
+	user, token, err := auth.DecodeXOAuth2(payload)
+	if err != nil {
+		sess.logger.Debug("AUTH XOAUTH2 decode failed", "error", err, "client_ip", sess.clientIP)
+		return sess.writeResponse(sess.response(StatusAuthRequired, "Authentication failed"))
 	}
 
-	return sess.authenticateUser(ctx, username, password)
+	authCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	username, err := sess.tokenValidator.ValidateToken(authCtx, token)
+	if err != nil && !errors.Is(err, auth.ErrInvalidToken) {
+		sess.logger.Error("AUTH XOAUTH2 token validation unavailable", "username", user, "client_ip", sess.clientIP, "error", err)
+		return sess.writeResponse(sess.response(StatusTempAuthFailure, "Temporary authentication failure"))
+	}
+	if err == nil && !strings.EqualFold(username, user) {
+		err = fmt.Errorf("%w: token issued to %q", auth.ErrInvalidToken, username)
+	}
+	if err != nil {
+		sess.logger.Warn("Authentication failed", "username", user, "mechanism", "XOAUTH2", "client_ip", sess.clientIP, "error", err)
+		return sess.failXOAuth2()
+	}
+
+	return sess.completeAuth(username, "XOAUTH2")
 }
 
-func (sess *Session) authenticateUser(ctx context.Context, username, password string) error {
+// failXOAuth2 sends the error challenge, waits for the client's (empty)
+// continuation as RFC 7628 requires, then rejects the exchange
+func (sess *Session) failXOAuth2() error {
+	if err := sess.writeResponse("334 " + xoauth2ErrorChallenge); err != nil {
+		return err
+	}
+	if _, err := sess.textproto.ReadLine(); err != nil {
+		return fmt.Errorf("failed to read AUTH XOAUTH2 error continuation: %w", err)
+	}
+	return sess.writeResponse(sess.response(StatusAuthRequired, "Authentication failed"))
+}
+
+// clientCertUsername returns the username mapped from the session's verified
+// TLS client certificate, checking the subject CN and then its SANs. It returns
+// "" when TLS is not active, no certificate was verified, or nothing is mapped.
+func (sess *Session) clientCertUsername() string {
+	users := sess.config.TLS.ClientCertUsers
+	if !sess.connCtx.TLS || len(users) == 0 {
+		return ""
+	}
+
+	tlsConn, ok := sess.rawConn.(*tls.Conn)
+	if !ok {
+		return ""
+	}
+	state := tlsConn.ConnectionState()
+	if len(state.VerifiedChains) == 0 || len(state.PeerCertificates) == 0 {
+		return ""
+	}
+
+	cert := state.PeerCertificates[0]
+	identities := append([]string{cert.Subject.CommonName}, cert.DNSNames...)
+	identities = append(identities, cert.EmailAddresses...)
+	for _, identity := range identities {
+		if username, ok := users[identity]; ok && identity != "" {
+			return username
+		}
+	}
+	return ""
+}
+
+// completeAuth marks the session authenticated as username and answers 235,
+// unless the user already holds max_sessions_per_user sessions: then the
+// client gets 421 and the connection is closed
+func (sess *Session) completeAuth(username, mechanism string) error {
+	if !sess.userSessions.Acquire(username) {
+		sess.logger.Warn("Too many concurrent sessions for user, closing connection",
+			"username", username, "mechanism", mechanism, "client_ip", sess.clientIP)
+		sess.state = StateClosed
+		return sess.writeResponse(sess.responseWithHostname(StatusTempFailure, "Too many concurrent sessions, closing connection"))
+	}
+	sess.userSessionHeld = true
+
+	sess.authenticated = true
+	sess.username = username
+	sess.state = StateAuthenticated
+	sess.logger.Info("Authentication successful", "username", username, "mechanism", mechanism, "client_ip", sess.clientIP)
+	return sess.writeResponse(sess.response(StatusAuthSuccess, "Authentication successful"))
+}
+
+// releaseUserSession frees the per-user session slot taken by completeAuth
+func (sess *Session) releaseUserSession() {
+	if sess.userSessionHeld {
+		sess.userSessions.Release(sess.username)
+		sess.userSessionHeld = false
+	}
+}
+
+func (sess *Session) authenticateUser(ctx context.Context, mechanism, username, password string) error {
 	authCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	result := sess.authenticator.Authenticate(authCtx, username, password)
 
 	if result.Success {
-		sess.authenticated = true
-		sess.username = result.Username
-		sess.state = StateAuthenticated
-		sess.logger.Info("Authentication successful", "username", username, "client_ip", sess.clientIP)
-		return sess.writeResponse(Response(StatusAuthSuccess, "Authentication successful"))
+		return sess.completeAuth(result.Username, mechanism)
 	}
 
 	sess.logger.Warn("Authentication failed", "username", username, "client_ip", sess.clientIP, "error", result.Error)
-	return sess.writeResponse(Response(StatusAuthRequired, "Authentication failed"))
+	return sess.writeResponse(sess.response(StatusAuthRequired, "Authentication failed"))
 }
 
 func (sess *Session) handleMail(ctx context.Context, args []string) error {
 	// Check session state
 	if sess.state != StateGreeted && sess.state != StateAuthenticated {
-		return sess.writeResponse(Response(StatusBadSequence, "EHLO/HELO required before MAIL"))
+		return sess.writeResponse(sess.response(StatusBadSequence, "EHLO/HELO required before MAIL"))
+	}
+
+	if limit := sess.config.Server.MaxTransactionsPerConnection; limit > 0 && sess.transactions >= limit {
+		sess.logger.Info("Transaction limit reached, closing connection", "transactions", sess.transactions, "client_ip", sess.clientIP)
+		sess.state = StateClosed
+		return sess.writeResponse(sess.responseWithHostname(StatusTempFailure, "Too many messages this session, closing connection"))
 	}
 
 	// Initialize new message for this mail transaction
+	sess.txStart = time.Now()
+	sess.acceptedRcpts = 0
+	sess.rcptAttempts = 0
 	sess.currentMessage = &queue.Message{
 		ID:                  queue.GenerateID(),
 		ClientIP:            sess.clientIP,
 		ClientHelloHostname: sess.clientHelloHostname,
-		LocalRecipients:     make(map[string]struct{}),
-		VirtualRecipients:   make(map[string]struct{}),
-		RelayRecipients:     make(map[string]struct{}),
-		ExternalRecipients:  make(map[string]struct{}),
+		LocalRecipients:     queue.NewRecipientSet(),
+		VirtualRecipients:   queue.NewRecipientSet(),
+		RelayRecipients:     queue.NewRecipientSet(),
+		ExternalRecipients:  queue.NewRecipientSet(),
 		Created:             time.Now().UTC(),
 	}
 
@@ -377,167 +763,436 @@ func (sess *Session) handleMail(ctx context.Context, args []string) error {
 	emailAddr, err := sess.emailValidator.ParseMailFromCommand(args)
 	if err != nil {
 		sess.logger.Debug("MAIL FROM validation failed", "error", err, "client_ip", sess.clientIP)
-		return sess.writeResponse(Response(StatusParamError, err.Error()))
+		return sess.writeResponse(sess.response(StatusParamError, err.Error()))
 	}
-
-	senderCtx := ValidationContext{
-		Username:      sess.username,
-		Authenticated: sess.authenticated,
-		ClientIP:      sess.clientIP,
-		EHLOHostname:  sess.clientHelloHostname,
+	params, err := ParseMailParams(args)
+	if err != nil {
+		return sess.writeResponse(sess.response(StatusParamError, err.Error()))
 	}
-	if err := sess.senderValidator.ValidateSender(emailAddr.Full, senderCtx); err != nil {
+
+	if err := sess.senderValidator.ValidateSender(emailAddr.Full, sess.validationContext()); err != nil {
 		sess.logger.Info("Sender rejected", "sender", emailAddr.Full, "error", err, "client_ip", sess.clientIP)
-		return sess.writeResponse(Response(StatusMailboxUnavailable, "Sender address not allowed"))
+		response := sess.senderRejectedResponse(err)
+		sess.logRejectedSender(emailAddr.Full, response)
+		return sess.writeResponse(response)
 	}
 
-	// Store the sender address in message
-	sess.currentMessage.From = emailAddr.Full
+	access := sess.senderAccess.Lookup(emailAddr.Full)
+	if access == aliases.AccessReject {
+		sess.logger.Info("Sender rejected by sender access map", "sender", emailAddr.Full, "client_ip", sess.clientIP)
+		response := sess.response(StatusTransactionFailed, "Sender denied")
+		sess.logRejectedSender(emailAddr.Full, response)
+		return sess.writeResponse(response)
+	}
+
+	// An explicit ok in the sender access map skips the sender domain DNSBL check
+	var domainListings []string
+	if access != aliases.AccessOK {
+		domainListings = sess.senderDomainListings(ctx, emailAddr.Domain)
+	}
+	sess.senderDNSBL = domainListings
+	if len(domainListings) > 0 && sess.dnsblChecker.ShouldReject() {
+		sess.logger.Info("Sender domain rejected by DNSBL", "sender", emailAddr.Full, "providers", domainListings, "client_ip", sess.clientIP)
+		response := sess.response(StatusTransactionFailed, "Sender domain is blocklisted")
+		sess.logRejectedSender(emailAddr.Full, response)
+		return sess.writeResponse(response)
+	}
+
+	if score := sess.checkRejectScore(domainListings); score != nil {
+		sess.logger.Info("Sender rejected by policy score", "sender", emailAddr.Full, "score", score.Score, "signals", score.Report, "client_ip", sess.clientIP)
+		response := sess.response(StatusTransactionFailed, sess.rejectScorer.Reason(score))
+		sess.logRejectedSender(emailAddr.Full, response)
+		return sess.writeResponse(response)
+	}
+
+	// Store the (possibly rewritten) sender address in message
+	sess.currentMessage.From = sess.rewriteAddress(emailAddr.Full)
+	sess.currentMessage.DNSBLListings = append(slices.Clone(sess.connCtx.DNSBL), domainListings...)
+	if err := sess.applyMailParams(params); err != nil {
+		return sess.writeResponse(sess.response(StatusParamError, err.Error()))
+	}
 	sess.state = StateMailFrom
 
-	sess.logger.Info("MAIL FROM accepted", "sender", sess.currentMessage.From, "client_ip", sess.clientIP)
-	return sess.writeResponse(Response(StatusOK, "Sender accepted"))
+	sess.logger.Info("MAIL FROM accepted", "sender", sess.currentMessage.From, "auth_sender", sess.currentMessage.AuthSender, "body", sess.currentMessage.BodyType, "client_ip", sess.clientIP)
+	return sess.writeResponse(sess.response(StatusOK, "Sender accepted"))
+}
+
+// rewriteAddress applies canonical maps to an envelope address
+func (sess *Session) rewriteAddress(address string) string {
+	rewritten := sess.canonicalMaps.Rewrite(address)
+	if rewritten != address {
+		sess.logger.Debug("Address rewritten by canonical maps", "from", address, "to", rewritten, "client_ip", sess.clientIP)
+	}
+	return rewritten
+}
+
+// validationContext snapshots the session's auth state for the validators, so a
+// successful AUTH is visible to sender and recipient policy without extra wiring
+func (sess *Session) validationContext() ValidationContext {
+	return ValidationContext{
+		Username:       sess.username,
+		Authenticated:  sess.authenticated,
+		ClientIP:       sess.clientIP,
+		EHLOHostname:   sess.clientHelloHostname,
+		TrustedNetwork: sess.inTrustedNetwork(),
+	}
+}
+
+// senderRejectedResponse maps a ValidateSender error to its SMTP reply
+func (sess *Session) senderRejectedResponse(err error) string {
+	if errors.Is(err, ErrAuthRequired) {
+		return sess.response(StatusNotAuthorized, "Authentication required")
+	}
+	return sess.response(StatusMailboxUnavailable, "Sender address not allowed")
+}
+
+// senderDomainListings checks the sender domain against DNSBL providers and
+// returns the providers listing it
+func (sess *Session) senderDomainListings(ctx context.Context, domain string) []string {
+	if sess.dnsblChecker == nil || domain == "" {
+		return nil
+	}
+
+	var listings []string
+	for _, result := range sess.dnsblChecker.CheckDomain(ctx, domain) {
+		if result.Listed {
+			listings = append(listings, result.Provider)
+		}
+	}
+	return listings
+}
+
+// checkRejectScore weighs the weak signals about an unauthenticated TCP client
+// outside the trusted networks and the allowlist; a non-nil score refuses the
+// transaction. Allowlisted clients skip the DNSBL and rDNS checks, so their
+// signals would be meaningless.
+func (sess *Session) checkRejectScore(domainListings []string) *security.SpamScore {
+	if sess.rejectScorer == nil || sess.connCtx.Type != ConnectionTypeTCP || sess.connCtx.Allowlisted ||
+		sess.authenticated || sess.inTrustedNetwork() {
+		return nil
+	}
+	return sess.rejectScorer.Check(security.ScoreSignals{
+		DNSBLListings: append(slices.Clone(sess.connCtx.DNSBL), domainListings...),
+		ReverseDNS:    sess.reverseDNS,
+		HeloHostname:  sess.clientHelloHostname,
+	})
+}
+
+// dnsblDiscard reports whether the current message must be dropped because the
+// client IP or sender domain is listed and the DNSBL action is "discard"
+func (sess *Session) dnsblDiscard() bool {
+	if sess.config.Security.DNSBL.Action != "discard" || len(sess.currentMessage.DNSBLListings) == 0 {
+		return false
+	}
+	sess.logger.Info("Discarding message from DNSBL-listed source",
+		"providers", sess.currentMessage.DNSBLListings, "message_id", sess.currentMessage.ID, "client_ip", sess.clientIP)
+	return true
+}
+
+// applyMailParams records supported MAIL FROM ESMTP parameters on the current message
+func (sess *Session) applyMailParams(params map[string]string) error {
+	if value, ok := params["AUTH"]; ok {
+		authSender, err := decodeXtext(value)
+		if err != nil {
+			return fmt.Errorf("invalid AUTH parameter: %w", err)
+		}
+		// RFC 4954 §5: an asserted identity is only trusted from authenticated
+		// (or kernel-verified socket) sessions; otherwise it becomes AUTH=<>
+		if !sess.authenticated {
+			authSender = "<>"
+		}
+		sess.currentMessage.AuthSender = authSender
+	}
+	if value, ok := params["BODY"]; ok {
+		// RFC 6152: the data is stored verbatim, so both body types are accepted as is
+		switch bodyType := strings.ToUpper(value); bodyType {
+		case "7BIT", "8BITMIME":
+			sess.currentMessage.BodyType = bodyType
+		default:
+			return fmt.Errorf("invalid BODY parameter %q", value)
+		}
+	}
+	if value, ok := params["RET"]; ok {
+		ret, err := parseDSNRet(value)
+		if err != nil {
+			return err
+		}
+		sess.currentMessage.DSNRet = ret
+	}
+	if value, ok := params["ENVID"]; ok {
+		envID, err := parseDSNEnvID(value)
+		if err != nil {
+			return err
+		}
+		sess.currentMessage.DSNEnvID = envID
+	}
+	return nil
 }
 
 func (sess *Session) handleRcpt(ctx context.Context, args []string) error {
 	// Check session state - MAIL FROM must be done first
 	if sess.state != StateMailFrom && sess.state != StateRcptTo {
-		return sess.writeResponse(Response(StatusBadSequence, "MAIL FROM required before RCPT TO"))
+		return sess.writeResponse(sess.response(StatusBadSequence, "MAIL FROM required before RCPT TO"))
 	}
+	sess.rcptAttempts++
 
-	// Check recipient limit
+	// Check recipient limit across all recipient types (RFC 5321 §4.5.3.1.8: 452)
 	maxRecipients := sess.config.Server.MaxRecipients
 	if maxRecipients > 0 && sess.currentMessage.TotalRecipients() >= maxRecipients {
-		return sess.writeResponse(Response(StatusExceededStorage, "Too many recipients"))
+		return sess.writeResponse(sess.response(StatusInsufficientStorage, "Too many recipients"))
 	}
 
 	// Parse and validate the RCPT TO command
 	emailAddr, err := sess.emailValidator.ParseRcptToCommand(args)
 	if err != nil {
 		sess.logger.Debug("RCPT TO validation failed", "error", err, "client_ip", sess.clientIP)
-		return sess.writeResponse(Response(StatusParamError, err.Error()))
+		return sess.writeResponse(sess.response(StatusParamError, err.Error()))
+	}
+	params, err := ParseMailParams(args)
+	if err != nil {
+		return sess.writeResponse(sess.response(StatusParamError, err.Error()))
+	}
+	dsn, err := parseRcptDSNParams(params)
+	if err != nil {
+		return sess.writeResponse(sess.response(StatusParamError, err.Error()))
+	}
+
+	if sess.config.Server.CanonicalRecipients {
+		if rewritten := sess.rewriteAddress(emailAddr.Full); rewritten != emailAddr.Full {
+			if emailAddr, err = sess.emailValidator.ParseEmailAddress(rewritten); err != nil {
+				sess.logger.Warn("Canonical rewrite produced invalid recipient", "recipient", rewritten, "error", err)
+				return sess.writeResponse(sess.response(StatusMailboxUnavailable, "User unknown"))
+			}
+		}
+	}
+
+	access := sess.recipientAccess.Lookup(emailAddr.Full)
+	if access == aliases.AccessReject {
+		sess.logger.Info("Recipient rejected by recipient access map", "recipient", emailAddr.Full, "client_ip", sess.clientIP)
+		return sess.writeResponse(sess.response(StatusMailboxUnavailable, "Recipient denied"))
 	}
 
 	// Classify domain type
 	domainType := sess.classifyDomain(emailAddr.Domain)
 
 	// Validate recipient against connection policy
-	rcptCtx := ValidationContext{
-		Username:      sess.username,
-		Authenticated: sess.authenticated,
-		ClientIP:      sess.clientIP,
-		EHLOHostname:  sess.clientHelloHostname,
-		RecipientType: domainType,
-	}
+	rcptCtx := sess.validationContext()
+	rcptCtx.RecipientType = domainType
 	if err := sess.senderValidator.ValidateRecipient(emailAddr.Full, rcptCtx); err != nil {
 		sess.logger.Info("Recipient rejected", "recipient", emailAddr.Full, "domain_type", domainType, "error", err, "client_ip", sess.clientIP)
-		return sess.writeResponse(Response(StatusTransactionFailed, "Relay not permitted"))
+		return sess.writeResponse(sess.response(StatusTransactionFailed, "Relay not permitted"))
 	}
 
 	// Handle based on domain type
 	switch domainType {
-	case delivery.RecipientLocal, delivery.RecipientVirtual:
-		if domainType == delivery.RecipientLocal {
-			// Handle local recipients with alias fallback
-			if sess.rcptValidator.IsRecipientValid(ctx, emailAddr.Full, domainType) {
-				// Direct user exists
-				if _, exists := sess.currentMessage.LocalRecipients[emailAddr.Full]; exists {
-					sess.logger.Debug("Duplicate recipient ignored", "recipient", emailAddr.Full, "domain_type", domainType, "client_ip", sess.clientIP)
-					return sess.writeResponse(Response(StatusOK, "Recipient accepted"))
-				}
-				sess.currentMessage.LocalRecipients[emailAddr.Full] = struct{}{}
-			} else {
-				// Try alias resolution
-				aliasRecipients := sess.rcptValidator.ResolveLocalAlias(emailAddr.Local)
-				if len(aliasRecipients) > 0 {
-					// Alias resolved - add all pre-validated expanded recipients
-					for _, expandedRecipient := range aliasRecipients {
-						if _, exists := sess.currentMessage.LocalRecipients[expandedRecipient]; !exists {
-							sess.currentMessage.LocalRecipients[expandedRecipient] = struct{}{}
-						}
-					}
-					sess.logger.Debug("Local alias resolved", "alias", emailAddr.Local, "recipients", aliasRecipients, "client_ip", sess.clientIP)
-				} else {
-					sess.logger.Debug("Recipient validation failed", "recipient", emailAddr.Full, "domain_type", domainType, "client_ip", sess.clientIP)
-					return sess.writeResponse(Response(StatusMailboxUnavailable, "User unknown"))
-				}
+	case delivery.RecipientLocal:
+		// Users win over aliases; a recipient access "ok" only keeps an address
+		// that is neither a user nor an alias from being rejected as unknown
+		if sess.rcptValidator.IsRecipientValid(ctx, emailAddr.Full, domainType) {
+			// Direct user exists
+			if !sess.addRecipient(sess.currentMessage.LocalRecipients, emailAddr.Full, emailAddr.Full, dsn) {
+				sess.logger.Debug("Duplicate recipient ignored", "recipient", emailAddr.Full, "domain_type", domainType, "client_ip", sess.clientIP)
+				return sess.acceptRecipient()
 			}
-		} else {
-			// Handle virtual recipients
-			if !sess.rcptValidator.IsRecipientValid(ctx, emailAddr.Full, domainType) {
-				sess.logger.Debug("Recipient validation failed", "recipient", emailAddr.Full, "domain_type", domainType, "client_ip", sess.clientIP)
-				return sess.writeResponse(Response(StatusMailboxUnavailable, "User unknown"))
+		} else if aliasRecipients := sess.rcptValidator.ResolveLocalAlias(emailAddr.Local); len(aliasRecipients) > 0 {
+			if maxRecipients > 0 && sess.currentMessage.TotalRecipients()+sess.countNewLocalRecipients(aliasRecipients) > maxRecipients {
+				sess.logger.Info("Alias expansion exceeds recipient limit", "alias", emailAddr.Local, "expanded", len(aliasRecipients), "max_recipients", maxRecipients, "client_ip", sess.clientIP)
+				return sess.writeResponse(sess.response(StatusInsufficientStorage, "Too many recipients"))
 			}
-			if _, exists := sess.currentMessage.VirtualRecipients[emailAddr.Full]; exists {
+			// Alias resolved - add all pre-validated expanded recipients
+			for _, expandedRecipient := range aliasRecipients {
+				sess.addRecipient(sess.currentMessage.LocalRecipients, expandedRecipient, emailAddr.Full, dsn)
+			}
+			sess.logger.Debug("Local alias resolved", "alias", emailAddr.Local, "recipients", aliasRecipients, "client_ip", sess.clientIP)
+		} else if access == aliases.AccessOK {
+			// Allowed by the recipient access map: skip the user-existence check
+			if !sess.addRecipient(sess.currentMessage.LocalRecipients, emailAddr.Full, emailAddr.Full, dsn) {
 				sess.logger.Debug("Duplicate recipient ignored", "recipient", emailAddr.Full, "domain_type", domainType, "client_ip", sess.clientIP)
-				return sess.writeResponse(Response(StatusOK, "Recipient accepted"))
+				return sess.acceptRecipient()
 			}
-			sess.currentMessage.VirtualRecipients[emailAddr.Full] = struct{}{}
+		} else if mailbox := sess.postmasterMailbox(emailAddr.Local); mailbox != "" {
+			sess.addPostmasterRecipient(ctx, emailAddr.Full, mailbox, dsn)
+		} else {
+			sess.logger.Debug("Recipient validation failed", "recipient", emailAddr.Full, "domain_type", domainType, "client_ip", sess.clientIP)
+			return sess.rejectUnknownRecipient(ctx, emailAddr.Full)
+		}
+
+	case delivery.RecipientVirtual:
+		// Allowed by the recipient access map: skip the user-existence check
+		if access != aliases.AccessOK && !sess.rcptValidator.IsRecipientValid(ctx, emailAddr.Full, domainType) {
+			mailbox := sess.postmasterMailbox(emailAddr.Local)
+			if mailbox == "" {
+				sess.logger.Debug("Recipient validation failed", "recipient", emailAddr.Full, "domain_type", domainType, "client_ip", sess.clientIP)
+				return sess.rejectUnknownRecipient(ctx, emailAddr.Full)
+			}
+			sess.addPostmasterRecipient(ctx, emailAddr.Full, mailbox, dsn)
+		} else if !sess.addRecipient(sess.currentMessage.VirtualRecipients, emailAddr.Full, emailAddr.Full, dsn) {
+			sess.logger.Debug("Duplicate recipient ignored", "recipient", emailAddr.Full, "domain_type", domainType, "client_ip", sess.clientIP)
+			return sess.acceptRecipient()
 		}
 
 	case delivery.RecipientRelay:
 		// Check for duplicates in relay map
-		if _, exists := sess.currentMessage.RelayRecipients[emailAddr.Full]; exists {
+		if !sess.addRecipient(sess.currentMessage.RelayRecipients, emailAddr.Full, emailAddr.Full, dsn) {
 			sess.logger.Debug("Duplicate relay recipient ignored", "recipient", emailAddr.Full, "client_ip", sess.clientIP)
-			return sess.writeResponse(Response(StatusOK, "Recipient accepted"))
+			return sess.acceptRecipient()
 		}
-		sess.currentMessage.RelayRecipients[emailAddr.Full] = struct{}{}
 
 	case delivery.RecipientExternal:
-		sess.logger.Debug("External domain not permitted", "recipient", emailAddr.Full, "domain", emailAddr.Domain, "client_ip", sess.clientIP)
-		return sess.writeResponse(Response(StatusTransactionFailed, "Relay not permitted"))
+		if !rcptCtx.TrustedNetwork {
+			sess.logger.Debug("External domain not permitted", "recipient", emailAddr.Full, "domain", emailAddr.Domain, "client_ip", sess.clientIP)
+			return sess.writeResponse(sess.response(StatusTransactionFailed, "Relay not permitted"))
+		}
+		if !sess.addRecipient(sess.currentMessage.ExternalRecipients, emailAddr.Full, emailAddr.Full, dsn) {
+			sess.logger.Debug("Duplicate external recipient ignored", "recipient", emailAddr.Full, "client_ip", sess.clientIP)
+			return sess.acceptRecipient()
+		}
 	}
 
 	sess.state = StateRcptTo
 
 	sess.logger.Info("RCPT TO accepted", "recipient", emailAddr.Full, "domain_type", domainType, "total_recipients", sess.currentMessage.TotalRecipients(), "client_ip", sess.clientIP)
-	return sess.writeResponse(Response(StatusOK, "Recipient accepted"))
+	return sess.acceptRecipient()
 }
 
-func (sess *Session) handleData(ctx context.Context, args []string) error {
-	// Check session state - must have at least one recipient
-	if sess.state != StateRcptTo {
-		return sess.writeResponse(Response(StatusBadSequence, "RCPT TO required before DATA"))
+// acceptRecipient answers 250 for a valid recipient, ending any run of unknown ones
+func (sess *Session) acceptRecipient() error {
+	sess.invalidRecipients = 0
+	sess.acceptedRcpts++
+	return sess.writeResponse(sess.response(StatusOK, "Recipient accepted"))
+}
+
+// rejectUnknownRecipient answers 550 User unknown. Past invalid_recipients
+// thresholds the reply is delayed, and finally the connection is closed, so
+// address harvesting by probing RCPTs becomes slow and noisy.
+func (sess *Session) rejectUnknownRecipient(ctx context.Context, recipient string) error {
+	sess.invalidRecipients++
+	limits := sess.config.Security.InvalidRecipients
+
+	if limits.DisconnectAfter > 0 && sess.invalidRecipients >= limits.DisconnectAfter {
+		sess.logger.Info("Too many invalid recipients, closing connection",
+			"client_ip", sess.clientIP, "count", sess.invalidRecipients, "last_recipient", recipient)
+		sess.state = StateClosed
+		return sess.writeResponse(sess.response(StatusMailboxUnavailable, "Too many invalid recipients"))
 	}
 
-	if sess.currentMessage.TotalRecipients() == 0 {
-		return sess.writeResponse(Response(StatusBadSequence, "No recipients specified"))
+	if limits.TarpitAfter > 0 && sess.invalidRecipients >= limits.TarpitAfter && limits.TarpitDelay > 0 {
+		sess.logger.Debug("Tarpitting invalid recipient", "client_ip", sess.clientIP,
+			"count", sess.invalidRecipients, "delay", limits.TarpitDelay)
+		select {
+		case <-time.After(limits.TarpitDelay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
+	return sess.writeResponse(sess.response(StatusMailboxUnavailable, "User unknown"))
+}
 
-	// Start data collection
-	sess.state = StateData
-	if err := sess.writeResponse(Response(StatusStartMailInput, "Start mail input; end with <CRLF>.<CRLF>")); err != nil {
-		return err
+// postmasterMailbox returns the fallback mailbox for role addresses that must
+// always be accepted (RFC 5321 §4.5.1 postmaster, optionally abuse), or "" when
+// local is not one of them
+func (sess *Session) postmasterMailbox(local string) string {
+	cfg := &sess.config.Server
+	if (cfg.AcceptPostmaster && strings.EqualFold(local, "postmaster")) ||
+		(cfg.AcceptAbuse && strings.EqualFold(local, "abuse")) {
+		return cfg.PostmasterMailbox
 	}
+	return ""
+}
 
-	// Generate headers for the message (overridden by session types)
-	headers := sess.generateHeaders()
+// addRecipient adds address to set with the RCPT TO address that produced it
+// and the DSN parameters given there. Returns false when address was already
+// a recipient; its first RCPT TO is kept.
+func (sess *Session) addRecipient(set queue.RecipientSet, address, rcptTo string, dsn types.DSNParams) bool {
+	info, added := set.Add(address)
+	if !added {
+		return false
+	}
+	if address != rcptTo {
+		info.Original = rcptTo
+	}
+	info.DSN = dsn
+	return true
+}
 
-	// Create a reader that combines headers and message data
-	var messageReader io.Reader
-	if headers != "" {
-		headerReader := strings.NewReader(headers)
-		messageReader = io.MultiReader(headerReader, sess.textproto.R)
-	} else {
-		messageReader = sess.textproto.R
+// addPostmasterRecipient routes an unclaimed role address to the fallback
+// mailbox, classified by its domain as RCPT TO would; a local mailbox naming
+// an alias rather than a user is expanded
+func (sess *Session) addPostmasterRecipient(ctx context.Context, recipient, mailbox string, dsn types.DSNParams) {
+	local, domain := auth.ExtractUsernameAndDomain(mailbox)
+	domainType := sess.classifyDomain(domain)
+	if domainType == delivery.RecipientLocal && !sess.rcptValidator.IsRecipientValid(ctx, mailbox, domainType) {
+		if expanded := sess.rcptValidator.ResolveLocalAlias(local); len(expanded) > 0 {
+			for _, expandedRecipient := range expanded {
+				sess.addRecipient(sess.currentMessage.LocalRecipients, expandedRecipient, recipient, dsn)
+			}
+			sess.logger.Info("Role address routed to postmaster alias", "recipient", recipient, "mailbox", mailbox, "recipients", expanded, "client_ip", sess.clientIP)
+			return
+		}
 	}
+	sess.addRecipient(sess.recipientSet(domainType), mailbox, recipient, dsn)
+	sess.logger.Info("Role address routed to postmaster mailbox", "recipient", recipient, "mailbox", mailbox, "domain_type", domainType, "client_ip", sess.clientIP)
+}
 
-	// Stream message data directly to storage
-	totalSize, err := queue.StreamEmailContent(ctx, sess.config, sess.currentMessage, messageReader)
-	if err != nil {
-		sess.logger.Error("Error storing message data", "error", err, "client_ip", sess.clientIP)
-		return sess.writeResponse(Response(StatusLocalError, "Error storing message"))
+// recipientSet returns the set of the current message holding recipients of domainType
+func (sess *Session) recipientSet(domainType delivery.RecipientType) queue.RecipientSet {
+	switch domainType {
+	case delivery.RecipientLocal:
+		return sess.currentMessage.LocalRecipients
+	case delivery.RecipientVirtual:
+		return sess.currentMessage.VirtualRecipients
+	case delivery.RecipientRelay:
+		return sess.currentMessage.RelayRecipients
+	default:
+		return sess.currentMessage.ExternalRecipients
 	}
+}
 
-	// Update message size after successful storage
+// countNewLocalRecipients returns how many of recipients are not yet in the current message
+func (sess *Session) countNewLocalRecipients(recipients []string) int {
+	n := 0
+	for _, r := range recipients {
+		if !sess.currentMessage.LocalRecipients.Contains(r) {
+			n++
+		}
+	}
+	return n
+}
+
+// completeMessage runs the steps shared by every DATA handler once the
+// message is spooled: it sanitizes and completes the stored headers, applies
+// the content checks and the DNSBL discard action, then publishes the
+// message and accepts it
+func (sess *Session) completeMessage(ctx context.Context, totalSize int64) error {
 	sess.currentMessage.TotalSize = totalSize
+	if err := sess.stripBccHeaders(); err != nil {
+		return sess.rejectStorageError(err)
+	}
+	if err := sess.stripSpoofedScoreHeaders(); err != nil {
+		return sess.rejectStorageError(err)
+	}
+	if err := sess.addMissingHeaders(); err != nil {
+		return sess.rejectStorageError(err)
+	}
+	match, err := sess.checkContent()
+	if err != nil {
+		return sess.rejectStorageError(err)
+	}
+	if match != nil {
+		return sess.applyContentVerdict(match)
+	}
+	if sess.dnsblDiscard() {
+		return sess.discardMessage()
+	}
 
 	sess.logger.Info("Message received and stored",
+		"connection_type", sess.connCtx.Type,
 		"sender", sess.currentMessage.From,
 		"total_recipients", sess.currentMessage.TotalRecipients(),
-		"size", totalSize,
+		"size", sess.currentMessage.TotalSize,
 		"message_id", sess.currentMessage.ID,
-		"client_ip", sess.clientIP)
+		"client_ip", sess.clientIP,
+		"username", sess.transactionUser())
 
 	// Publish message to queue for processing
 	if err := sess.queue.PublishMessage(ctx, sess.currentMessage); err != nil {
@@ -545,24 +1200,312 @@ func (sess *Session) handleData(ctx context.Context, args []string) error {
 		// Don't fail the SMTP transaction - message is already stored
 	}
 
-	// Reset session for next mail transaction
-	sess.resetSession()
-
-	return sess.writeResponse(Response(StatusOK, "Message accepted for delivery"))
+	return sess.acceptMessage()
 }
 
 func (sess *Session) handleRset(ctx context.Context, args []string) error {
 	sess.resetSession()
-	return sess.writeResponse(Response(StatusOK, "Reset state"))
+	return sess.writeResponse(sess.response(StatusOK, "Reset state"))
 }
 
 func (sess *Session) handleNoop(ctx context.Context, args []string) error {
-	return sess.writeResponse(Response(StatusOK, ""))
+	return sess.writeResponse(sess.response(StatusOK, ""))
+}
+
+// handleExpn expands a local alias to its members (RFC 5321 §3.5.2). Only
+// trusted connections may use it; everyone else gets 502 so list membership
+// cannot be enumerated.
+func (sess *Session) handleExpn(ctx context.Context, args []string) error {
+	if !sess.config.Server.EnableExpn || !sess.expnTrusted() {
+		return sess.writeResponse(sess.response(StatusCommandNotImpl, "Command not implemented"))
+	}
+	if len(args) == 0 {
+		return sess.writeResponse(sess.response(StatusParamError, "EXPN requires a list name"))
+	}
+
+	name := strings.Trim(strings.Join(args, " "), "<>")
+	local, domain, hasDomain := strings.Cut(name, "@")
+	if hasDomain && sess.classifyDomain(domain) != delivery.RecipientLocal {
+		return sess.writeResponse(sess.response(StatusMailboxUnavailable, "Mailing list not found"))
+	}
+
+	members := sess.rcptValidator.ResolveLocalAlias(local)
+	if len(members) == 0 {
+		return sess.writeResponse(sess.response(StatusMailboxUnavailable, "Mailing list not found"))
+	}
+
+	sess.logger.Info("EXPN", "list", local, "members", len(members), "client_ip", sess.clientIP, "username", sess.username)
+	for i, member := range members {
+		sep := "-"
+		if i == len(members)-1 {
+			sep = " "
+		}
+		if err := sess.writeResponse(fmt.Sprintf("%d%s<%s>", StatusOK, sep, member)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expnTrusted reports whether the connection may expand aliases: local socket
+// clients always may, TCP and LMTP clients only from expn_networks
+func (sess *Session) expnTrusted() bool {
+	switch sess.connCtx.Type {
+	case ConnectionTypeSocket:
+		return true
+	case ConnectionTypeTCP, ConnectionTypeLMTP:
+		return security.ContainsIP(sess.expnNetworks, sess.clientIP)
+	}
+	return false
+}
+
+// inTrustedNetwork reports whether an SMTP client connects from
+// trusted_networks and may therefore relay to external domains. LMTP clients
+// never are: LMTP only hands mail over for final delivery (RFC 2033 §1).
+func (sess *Session) inTrustedNetwork() bool {
+	if sess.connCtx.Type != ConnectionTypeTCP {
+		return false
+	}
+	return security.ContainsIP(sess.trustedNetworks, sess.clientIP)
 }
 
 func (sess *Session) handleQuit(ctx context.Context, args []string) error {
 	sess.state = StateClosed
-	return sess.writeResponse(Response(StatusClosing, ""))
+	return sess.writeResponse(sess.response(StatusClosing, ""))
+}
+
+// rejectOversizedMessage answers a DATA phase that overran max_message_size. The
+// spool has already consumed the rest of the data, so the transaction is reset
+// and the permanent 552 lets the client bounce instead of retrying.
+func (sess *Session) rejectOversizedMessage(err error) error {
+	sess.logger.Info("Message rejected: size limit exceeded", "error", err, "client_ip", sess.clientIP)
+	response := sess.response(StatusExceededStorage, "Message size exceeds fixed limit")
+	sess.logTransaction(dispositionRejected, response)
+	sess.resetSession()
+	return sess.writeDataResponse(response)
+}
+
+// rejectEmptyBody answers 554 when headers_only refuses a message without a body
+func (sess *Session) rejectEmptyBody() error {
+	sess.logger.Info("Message rejected: no body", "client_ip", sess.clientIP)
+	response := sess.response(StatusTransactionFailed, "Empty body")
+	sess.logTransaction(dispositionRejected, response)
+	sess.resetSession()
+	return sess.writeDataResponse(response)
+}
+
+// rejectStorageError answers 451 when the message could not be spooled
+func (sess *Session) rejectStorageError(err error) error {
+	sess.logger.Error("Error storing message data", "error", err, "client_ip", sess.clientIP)
+	response := sess.response(StatusLocalError, "Error storing message")
+	sess.logTransaction(dispositionRejected, response)
+	sess.resetSession()
+	return sess.writeDataResponse(response)
+}
+
+// bccHeaders are the fields naming blind recipients, removed by strip_bcc_headers
+var bccHeaders = []string{"Bcc", "Resent-Bcc"}
+
+// stripBccHeaders removes Bcc headers from the stored message when it was
+// injected by an authenticated SASL or socket user and strip_bcc_headers is set.
+// Must run before the message is published; on failure the message is discarded.
+func (sess *Session) stripBccHeaders() error {
+	if !sess.config.Server.StripBccHeaders || sess.transactionUser() == "" {
+		return nil
+	}
+
+	spool := queue.NewSpool(sess.config)
+	path := queue.GetMessagePath(spool, sess.currentMessage, queue.MessageStateIncoming)
+	size, removed, err := queue.StripMessageHeaders(spool, path, bccHeaders...)
+	if err != nil {
+		// Never let recovery deliver the unsanitised copy
+		os.Remove(path)
+		return err
+	}
+	if removed > 0 {
+		sess.currentMessage.TotalSize = size
+		sess.logger.Debug("Stripped Bcc headers", "message_id", sess.currentMessage.ID, "removed", removed)
+	}
+	return nil
+}
+
+// stripSpoofedScoreHeaders removes the spam score and report fields a client
+// put in its own message, so downstream filters only see ours. The pair the
+// TCP header generator prepended, when it scored the connection, comes first
+// and is kept; socket messages are never scored, so none are kept. Must run
+// before the message is published; on failure the message is discarded.
+func (sess *Session) stripSpoofedScoreHeaders() error {
+	scoring := sess.config.Security.SpamScore
+	if !scoring.Enabled {
+		return nil
+	}
+	own := 1
+	if sess.connCtx.Allowlisted || sess.connCtx.Type == ConnectionTypeSocket {
+		own = 0
+	}
+
+	spool := queue.NewSpool(sess.config)
+	path := queue.GetMessagePath(spool, sess.currentMessage, queue.MessageStateIncoming)
+	size, changed, err := queue.RewriteMessageHeaders(spool, path, func(fields []string) ([]string, bool) {
+		seen := make(map[string]int)
+		kept := fields[:0:0]
+		for _, field := range fields {
+			name := strings.ToLower(queue.HeaderFieldName(field))
+			if strings.EqualFold(name, scoring.ScoreHeader) || strings.EqualFold(name, scoring.ReportHeader) {
+				seen[name]++
+				if seen[name] > own {
+					continue
+				}
+			}
+			kept = append(kept, field)
+		}
+		return kept, len(kept) != len(fields)
+	})
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+	if changed {
+		sess.currentMessage.TotalSize = size
+		sess.logger.Info("Stripped client-supplied score headers", "message_id", sess.currentMessage.ID, "client_ip", sess.clientIP)
+	}
+	return nil
+}
+
+// addMissingHeaders completes the header block of a message injected by an
+// authenticated SASL or socket user through the session's header generator
+// (e.g. a missing or malformed Date) and gives it the Message-ID it lacks
+// (RFC 6409 §8.3), leaving an existing one untouched. Relayed mail is left
+// byte for byte (RFC 5321 §6.4) so its signatures still verify. Must run
+// before the message is published; on failure the message is discarded.
+func (sess *Session) addMissingHeaders() error {
+	if sess.transactionUser() == "" {
+		return nil
+	}
+	addMessageID := sess.config.Server.AddMessageID
+
+	spool := queue.NewSpool(sess.config)
+	path := queue.GetMessagePath(spool, sess.currentMessage, queue.MessageStateIncoming)
+	size, changed, err := queue.RewriteMessageHeaders(spool, path, func(fields []string) ([]string, bool) {
+		fields, changed := sess.headerGenerator.CompleteHeaders(fields, sess.currentMessage)
+		if addMessageID && findHeaderField(fields, "Message-ID") == -1 {
+			messageID := fmt.Sprintf("Message-ID: <%s@%s>\r\n", uuid.NewString(), sess.config.Server.AdvertisedHostname())
+			return append([]string{messageID}, fields...), true
+		}
+		return fields, changed
+	})
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+	if changed {
+		sess.currentMessage.TotalSize = size
+		sess.logger.Debug("Completed message headers", "message_id", sess.currentMessage.ID)
+	}
+	return nil
+}
+
+// checkContent runs the header and body checks over the stored message, logging
+// WARN matches. Returns the REJECT or DISCARD match that decides the message,
+// if any. On failure the stored message is discarded.
+func (sess *Session) checkContent() (*security.ContentMatch, error) {
+	if sess.contentChecker == nil {
+		return nil, nil
+	}
+
+	path := queue.GetMessagePath(queue.NewSpool(sess.config), sess.currentMessage, queue.MessageStateIncoming)
+	result, err := sess.contentChecker.Check(path)
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	for _, warning := range result.Warnings {
+		sess.logger.Warn("Content check matched",
+			"pattern", warning.Pattern, "header", warning.Header, "line", warning.Line, "text", warning.Text,
+			"message_id", sess.currentMessage.ID, "client_ip", sess.clientIP)
+	}
+	return result.Verdict, nil
+}
+
+// applyContentVerdict drops the stored message matched by a REJECT or DISCARD
+// rule. REJECT answers 550 with the rule's text; DISCARD answers 250 so the
+// client believes the message was delivered.
+func (sess *Session) applyContentVerdict(match *security.ContentMatch) error {
+	sess.logger.Info("Message matched content check",
+		"action", match.Action, "pattern", match.Pattern, "header", match.Header, "line", match.Line,
+		"message_id", sess.currentMessage.ID, "client_ip", sess.clientIP)
+
+	if match.Action == security.ContentDiscard {
+		return sess.discardMessage()
+	}
+
+	os.Remove(queue.GetMessagePath(queue.NewSpool(sess.config), sess.currentMessage, queue.MessageStateIncoming))
+	text := match.Text
+	if text == "" {
+		text = "Message content rejected"
+	}
+	response := sess.response(StatusMailboxUnavailable, text)
+	sess.logTransaction(dispositionRejected, response)
+	sess.resetSession()
+	return sess.writeDataResponse(response)
+}
+
+// discardMessage drops the stored message but answers 250 so the client
+// believes it was delivered
+func (sess *Session) discardMessage() error {
+	os.Remove(queue.GetMessagePath(queue.NewSpool(sess.config), sess.currentMessage, queue.MessageStateIncoming))
+	response := sess.response(StatusOK, "Message accepted for delivery")
+	sess.logTransaction(dispositionDiscarded, response)
+	sess.transactions++
+	sess.resetSession()
+	return sess.writeDataResponse(response)
+}
+
+// acceptMessage logs the accepted transaction, resets for the next one and
+// confirms delivery to the client
+func (sess *Session) acceptMessage() error {
+	response := sess.response(StatusOK, "Message accepted for delivery")
+	sess.logTransaction(dispositionAccepted, response)
+	sess.transactions++
+	sess.resetSession()
+	return sess.writeDataResponse(response)
+}
+
+// writeDataResponse answers the end of message data. LMTP replies once for
+// every recipient accepted at RCPT (RFC 2033 §4.2); SMTP replies once.
+func (sess *Session) writeDataResponse(response string) error {
+	replies := 1
+	if sess.connCtx.Type == ConnectionTypeLMTP {
+		replies = sess.acceptedRcpts
+	}
+	for i := 0; i < replies; i++ {
+		if err := sess.writeResponse(response); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rateLimited reports whether accepting another message would exceed the
+// submission rate limits. Authenticated and socket users are limited per user.
+func (sess *Session) rateLimited() bool {
+	username := sess.username
+	if !sess.authenticated && sess.senderValidator.IsAuthenticated() {
+		username = sess.senderValidator.GetUsername()
+	}
+	if sess.rateLimiter.Allow(sess.clientIP, username) {
+		return false
+	}
+	sess.logger.Warn("Message rate limit exceeded", "client_ip", sess.clientIP, "username", username)
+	return true
+}
+
+// rejectRateLimited abandons the transaction and answers DATA with 452
+func (sess *Session) rejectRateLimited() error {
+	response := sess.response(StatusInsufficientStorage, "4.3.1 Message rate limit exceeded")
+	sess.logTransaction(dispositionRejected, response)
+	sess.resetSession()
+	return sess.writeResponse(response)
 }
 
 func (sess *Session) resetSession() {
@@ -575,36 +1518,68 @@ func (sess *Session) resetSession() {
 
 	// Clear current message
 	sess.currentMessage = nil
+	sess.rcptAttempts = 0
+	sess.senderDNSBL = nil
 }
 
-func (sess *Session) writeResponse(response string) error {
-	sess.logger.Debug("Sending response", "response", response, "client_ip", sess.clientIP)
-	return sess.textproto.PrintfLine("%s", response)
+// noRecipientsResponse is the 503 for DATA without an accepted recipient. It
+// tells a client whose every RCPT was refused apart from one that sent none,
+// so the earlier RCPT replies are the place to look.
+func (sess *Session) noRecipientsResponse() string {
+	if sess.state == StateMailFrom && sess.rcptAttempts > 0 {
+		return sess.response(StatusBadSequence, "No valid recipients - all were rejected")
+	}
+	return sess.response(StatusBadSequence, "RCPT TO required before DATA")
 }
 
-// generateHeaders creates headers to be prepended to the message
-// Default implementation returns empty string (no headers added)
-// Session types override this to add appropriate headers
-func (sess *Session) generateHeaders() string {
-	sess.logger.Debug("Session.generateHeaders (default) called", "client_ip", sess.clientIP)
-	return ""
+// replyText returns the server.response_messages text configured for code,
+// or message when the code is not overridden
+func (sess *Session) replyText(code int, message string) string {
+	if text, ok := sess.config.Server.ResponseMessages[code]; ok {
+		return text
+	}
+	return message
+}
+
+// response builds a reply with the configured text for its code, if any
+func (sess *Session) response(code int, message string) string {
+	return Response(code, sess.replyText(code, message))
+}
+
+// responseWithHostname is response for replies naming this host
+func (sess *Session) responseWithHostname(code int, message string) string {
+	return ResponseWithHostname(code, sess.hostname, sess.replyText(code, message))
+}
+
+func (sess *Session) writeResponse(response string) error {
+	sess.logger.Debug("Sending response", "response", response, "client_ip", sess.clientIP)
+	sess.setWriteDeadline(sess.config.Server.WriteTimeout)
+	if err := sess.textproto.PrintfLine("%s", response); err != nil {
+		if isClientDisconnect(err) {
+			sess.logger.Debug("Client disconnected before response was sent", "error", err, "client_ip", sess.clientIP)
+		} else {
+			sess.logger.Error("Failed to write response", "error", err, "client_ip", sess.clientIP)
+		}
+		return err
+	}
+	return nil
 }
 
 func (sess *Session) handleSTARTTLS(ctx context.Context) error {
 	if sess.connCtx.Mode != config.ListenerModeSTARTTLS {
-		return sess.writeResponse(Response(StatusCommandNotImpl, "STARTTLS not available on this port"))
+		return sess.writeResponse(sess.response(StatusCommandNotImpl, "STARTTLS not available on this port"))
 	}
 	if sess.connCtx.TLS {
-		return sess.writeResponse(Response(StatusBadSequence, "TLS already active"))
+		return sess.writeResponse(sess.response(StatusBadSequence, "TLS already active"))
 	}
 	if sess.connCtx.TLSConfig == nil {
-		return sess.writeResponse(Response(StatusLocalError, "TLS not configured"))
+		return sess.writeResponse(sess.response(StatusLocalError, "TLS not configured"))
 	}
 	if sess.state < StateGreeted {
-		return sess.writeResponse(Response(StatusBadSequence, "EHLO required before STARTTLS"))
+		return sess.writeResponse(sess.response(StatusBadSequence, "EHLO required before STARTTLS"))
 	}
 
-	if err := sess.writeResponse(Response(StatusReady, "Ready to start TLS")); err != nil {
+	if err := sess.writeResponse(sess.response(StatusReady, "Ready to start TLS")); err != nil {
 		return err
 	}
 
@@ -1,7 +1,34 @@
 package smtp
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/pawciobiel/golubsmtpd/internal/aliases"
+	"github.com/pawciobiel/golubsmtpd/internal/auth"
+	"github.com/pawciobiel/golubsmtpd/internal/config"
+	"github.com/pawciobiel/golubsmtpd/internal/delivery"
+	"github.com/pawciobiel/golubsmtpd/internal/queue"
+	"github.com/pawciobiel/golubsmtpd/internal/security"
+	"github.com/pawciobiel/golubsmtpd/internal/types"
 )
 
 // Session tests removed due to deadlock issues with net.Pipe()
@@ -19,3 +46,2485 @@ func TestSessionPlaceholder(t *testing.T) {
 	// Placeholder test to ensure package compiles
 	t.Skip("Session tests removed - use functional testing with nc instead")
 }
+
+// bufferConn is an in-memory connection: reads drain in, writes collect in out.
+// Commands are driven through processCommand directly, so no pipe is needed.
+type bufferConn struct {
+	in  *strings.Reader
+	out bytes.Buffer
+}
+
+func (c *bufferConn) Read(p []byte) (int, error)  { return c.in.Read(p) }
+func (c *bufferConn) Write(p []byte) (int, error) { return c.out.Write(p) }
+func (c *bufferConn) Close() error                { return nil }
+
+// lastResponse returns the most recent response line written to the connection
+func (c *bufferConn) lastResponse() string {
+	lines := strings.Split(strings.TrimRight(c.out.String(), "\r\n"), "\r\n")
+	return lines[len(lines)-1]
+}
+
+// newTestDependencies returns session dependencies with the networks of cfg
+// parsed, as the server does at startup
+func newTestDependencies(t *testing.T, cfg *config.Config) *Dependencies {
+	t.Helper()
+	trusted, err := security.ParseCIDRs(cfg.Security.TrustedNetworks)
+	if err != nil {
+		t.Fatalf("Invalid trusted_networks: %v", err)
+	}
+	expnNetworks, err := security.ParseCIDRs(cfg.Server.ExpnNetworks)
+	if err != nil {
+		t.Fatalf("Invalid expn_networks: %v", err)
+	}
+	return &Dependencies{Authenticator: &mockAuthenticator{}, TrustedNetworks: trusted, ExpnNetworks: expnNetworks}
+}
+
+// newTestTCPSession builds a greeted port-25 session backed by a bufferConn
+func newTestTCPSession(t *testing.T, cfg *config.Config) (*Session, *bufferConn) {
+	t.Helper()
+
+	conn := &bufferConn{in: strings.NewReader("")}
+	connCtx := ConnectionContext{Type: ConnectionTypeTCP, Port: 25, ClientIP: "192.0.2.1"}
+	deps := newTestDependencies(t, cfg)
+
+	sess := NewSession(cfg, nil, textproto.NewConn(conn), connCtx.ClientIP, deps,
+		&TCPHeaderGenerator{hostname: cfg.Server.AdvertisedHostname()}, NewRelayValidator(cfg), &TCPDataHandler{}, tcpSessionHandler, connCtx)
+	sess.state = StateGreeted
+	t.Cleanup(func() { sess.rcptValidator.Close() })
+	return sess, conn
+}
+
+// newTestSocketSession builds a socket session for the current user backed by a bufferConn
+func newTestSocketSession(t *testing.T, cfg *config.Config) (*Session, *bufferConn) {
+	t.Helper()
+
+	conn := &bufferConn{in: strings.NewReader("")}
+	creds := &SocketCredentials{UID: os.Getuid()}
+	deps := newTestDependencies(t, cfg)
+
+	sess := NewSocketSession(creds, cfg, textproto.NewConn(conn),
+		NewSocketValidator(creds, cfg, newTestLogger()), deps).(*Session)
+	sess.state = StateGreeted
+	t.Cleanup(func() { sess.rcptValidator.Close() })
+	return sess, conn
+}
+
+// newTestLMTPSession builds an LMTP session, not yet greeted with LHLO, backed by a bufferConn
+func newTestLMTPSession(t *testing.T, cfg *config.Config) (*Session, *bufferConn) {
+	t.Helper()
+
+	conn := &bufferConn{in: strings.NewReader("")}
+	connCtx := ConnectionContext{Type: ConnectionTypeLMTP, Port: 24, Mode: config.ListenerModeLMTP, ClientIP: "192.0.2.1"}
+	deps := newTestDependencies(t, cfg)
+
+	sess := NewLMTPSession(connCtx, cfg, nil, textproto.NewConn(conn), NewRelayValidator(cfg), deps).(*Session)
+	t.Cleanup(func() { sess.rcptValidator.Close() })
+	return sess, conn
+}
+
+func newMaxRecipientsConfig(maxRecipients int) *config.Config {
+	cfg := config.DefaultConfig()
+	cfg.Server.MaxRecipients = maxRecipients
+	cfg.Server.RelayDomains = []string{"relay.example.com"}
+	cfg.Relay.Enabled = true
+	return cfg
+}
+
+func TestSession_MaxRecipients(t *testing.T) {
+	const maxRecipients = 3
+
+	tests := []struct {
+		name       string
+		newSession func(t *testing.T, cfg *config.Config) (*Session, *bufferConn)
+	}{
+		{"tcp", newTestTCPSession},
+		{"socket", newTestSocketSession},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sess, conn := tt.newSession(t, newMaxRecipientsConfig(maxRecipients))
+			ctx := context.Background()
+
+			sender := "sender@example.org"
+			if sess.authenticated {
+				sender = sess.username + "@localhost"
+			}
+			if err := sess.processCommand(ctx, "MAIL FROM:<"+sender+">"); err != nil {
+				t.Fatalf("MAIL FROM failed: %v", err)
+			}
+			if sess.state != StateMailFrom {
+				t.Fatalf("MAIL FROM not accepted: %s", conn.lastResponse())
+			}
+
+			for i := 0; i < maxRecipients; i++ {
+				cmd := fmt.Sprintf("RCPT TO:<user%d@relay.example.com>", i)
+				if err := sess.processCommand(ctx, cmd); err != nil {
+					t.Fatalf("RCPT TO failed: %v", err)
+				}
+				if resp := conn.lastResponse(); !strings.HasPrefix(resp, "250") {
+					t.Fatalf("recipient %d should be accepted, got %q", i, resp)
+				}
+			}
+
+			if err := sess.processCommand(ctx, "RCPT TO:<overflow@relay.example.com>"); err != nil {
+				t.Fatalf("RCPT TO failed: %v", err)
+			}
+			if resp := conn.lastResponse(); !strings.HasPrefix(resp, "452") {
+				t.Errorf("recipient over limit should get 452, got %q", resp)
+			}
+			if got := sess.currentMessage.TotalRecipients(); got != maxRecipients {
+				t.Errorf("total recipients: want %d, got %d", maxRecipients, got)
+			}
+		})
+	}
+}
+
+func TestTCPSession_CommandTimeout(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	cfg := config.DefaultConfig()
+	cfg.Server.CommandTimeout = 200 * time.Millisecond
+
+	sessionErr := make(chan error, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			sessionErr <- err
+			return
+		}
+		defer conn.Close()
+
+		connCtx := ConnectionContext{Type: ConnectionTypeTCP, Port: 25, ClientIP: "127.0.0.1"}
+		deps := &Dependencies{Authenticator: &mockAuthenticator{}}
+		sess := NewTCPSession(connCtx, cfg, conn, textproto.NewConn(conn), NewRelayValidator(cfg), deps).(*Session)
+		defer sess.rcptValidator.Close()
+		sessionErr <- sess.Handle(context.Background())
+	}()
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(client)
+
+	if greeting, err := reader.ReadString('\n'); err != nil || !strings.HasPrefix(greeting, "220") {
+		t.Fatalf("Expected 220 greeting, got %q (err: %v)", greeting, err)
+	}
+
+	// Slow but active client: each command arrives within the timeout, while the
+	// total elapsed time exceeds it, so the deadline must be refreshed per read
+	for i := 0; i < 3; i++ {
+		time.Sleep(cfg.Server.CommandTimeout * 6 / 10)
+		if _, err := client.Write([]byte("NOOP\r\n")); err != nil {
+			t.Fatalf("Failed to write: %v", err)
+		}
+		if resp, err := reader.ReadString('\n'); err != nil || !strings.HasPrefix(resp, "250") {
+			t.Fatalf("Expected 250 for NOOP %d, got %q (err: %v)", i, resp, err)
+		}
+	}
+
+	// Now stall without sending anything
+	resp, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatalf("Expected 421 before close, got error: %v", err)
+	}
+	if !strings.HasPrefix(resp, "421") {
+		t.Errorf("Expected 421 on command timeout, got %q", resp)
+	}
+
+	select {
+	case err := <-sessionErr:
+		if !isTimeoutError(err) {
+			t.Errorf("Expected session to end with timeout error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Session did not end after command timeout")
+	}
+
+	if _, err := reader.ReadString('\n'); err == nil {
+		t.Error("Expected connection to be closed after 421")
+	}
+}
+
+func TestSession_MailAuthParam(t *testing.T) {
+	tests := []struct {
+		name          string
+		authenticated bool
+		command       string
+		want          string
+	}{
+		{
+			name:          "authenticated session keeps asserted identity",
+			authenticated: true,
+			command:       "MAIL FROM:<sender@example.org> AUTH=orig+2Buser@example.org",
+			want:          "orig+user@example.org",
+		},
+		{
+			name:          "unauthenticated session gets null identity",
+			authenticated: false,
+			command:       "MAIL FROM:<sender@example.org> AUTH=orig@example.org",
+			want:          "<>",
+		},
+		{
+			name:          "no AUTH parameter",
+			authenticated: true,
+			command:       "MAIL FROM:<sender@example.org>",
+			want:          "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sess, conn := newTestTCPSession(t, config.DefaultConfig())
+			sess.authenticated = tt.authenticated
+
+			if err := sess.processCommand(context.Background(), tt.command); err != nil {
+				t.Fatalf("MAIL FROM failed: %v", err)
+			}
+			if resp := conn.lastResponse(); !strings.HasPrefix(resp, "250") {
+				t.Fatalf("MAIL FROM should be accepted, got %q", resp)
+			}
+			if sess.currentMessage.From != "sender@example.org" {
+				t.Errorf("From: want %q, got %q", "sender@example.org", sess.currentMessage.From)
+			}
+			if sess.currentMessage.AuthSender != tt.want {
+				t.Errorf("AuthSender: want %q, got %q", tt.want, sess.currentMessage.AuthSender)
+			}
+		})
+	}
+}
+
+// fakeDomainDNSBL lists the configured domains with a single provider
+type fakeDomainDNSBL struct {
+	listed map[string]bool
+	reject bool
+}
+
+func (f *fakeDomainDNSBL) CheckDomain(_ context.Context, domain string) []*security.DNSBLResult {
+	return []*security.DNSBLResult{{Domain: domain, Provider: "dbl.example.net", Listed: f.listed[domain]}}
+}
+
+func (f *fakeDomainDNSBL) ShouldReject() bool { return f.reject }
+
+func TestSession_MailSenderDomainDNSBL(t *testing.T) {
+	tests := []struct {
+		name     string
+		sender   string
+		reject   bool
+		wantCode string
+		wantHits []string
+	}{
+		{"listed domain rejected", "spammer@spam.example", true, "554", []string{"dbl.example.net"}},
+		{"listed domain logged only", "spammer@spam.example", false, "250", []string{"dbl.example.net"}},
+		{"clean domain accepted", "sender@example.org", true, "250", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sess, conn := newTestTCPSession(t, config.DefaultConfig())
+			sess.dnsblChecker = &fakeDomainDNSBL{listed: map[string]bool{"spam.example": true}, reject: tt.reject}
+
+			if err := sess.processCommand(context.Background(), "MAIL FROM:<"+tt.sender+">"); err != nil {
+				t.Fatalf("MAIL FROM failed: %v", err)
+			}
+			if resp := conn.lastResponse(); !strings.HasPrefix(resp, tt.wantCode) {
+				t.Errorf("MAIL FROM response: want %s, got %q", tt.wantCode, resp)
+			}
+			if diff := cmp.Diff(tt.wantHits, sess.senderDNSBL); diff != "" {
+				t.Errorf("dnsbl hits mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSession_SenderDomainDNSBLResetPerTransaction(t *testing.T) {
+	sess, _ := newTestTCPSession(t, config.DefaultConfig())
+	sess.dnsblChecker = &fakeDomainDNSBL{listed: map[string]bool{"spam.example": true}}
+	sess.dnsblResults = []string{"zen.example.net"} // the client IP's listings
+
+	for _, cmd := range []string{"MAIL FROM:<spammer@spam.example>", "RSET"} {
+		if err := sess.processCommand(context.Background(), cmd); err != nil {
+			t.Fatalf("%s failed: %v", cmd, err)
+		}
+	}
+	if sess.senderDNSBL != nil {
+		t.Errorf("sender domain listings after RSET = %v, want none", sess.senderDNSBL)
+	}
+
+	if err := sess.processCommand(context.Background(), "MAIL FROM:<sender@example.org>"); err != nil {
+		t.Fatalf("MAIL FROM failed: %v", err)
+	}
+	if sess.senderDNSBL != nil {
+		t.Errorf("clean sender inherited listings %v", sess.senderDNSBL)
+	}
+	if diff := cmp.Diff([]string{"zen.example.net"}, sess.dnsblResults); diff != "" {
+		t.Errorf("connection listings changed (-want +got):\n%s", diff)
+	}
+}
+
+// acceptingAuthenticator accepts any credentials and lets each user send as user@example.org
+type acceptingAuthenticator struct{ mockAuthenticator }
+
+func (a *acceptingAuthenticator) Authenticate(_ context.Context, username, _ string) *auth.AuthResult {
+	return &auth.AuthResult{Success: true, Username: username}
+}
+
+func (a *acceptingAuthenticator) GetAllowedSenders(username string) []string {
+	return []string{username + "@example.org"}
+}
+
+func TestSubmissionSession_MailRequiresAuth(t *testing.T) {
+	tests := []struct {
+		name         string
+		authenticate bool
+		wantCode     string
+	}{
+		{"unauthenticated MAIL rejected", false, "530"},
+		{"MAIL after AUTH accepted", true, "250"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			authenticator := &acceptingAuthenticator{}
+			conn := &bufferConn{in: strings.NewReader("")}
+			connCtx := ConnectionContext{Type: ConnectionTypeTCP, Port: 587, ClientIP: "192.0.2.1"}
+			deps := &Dependencies{Authenticator: authenticator}
+
+			sess := NewSession(cfg, nil, textproto.NewConn(conn), connCtx.ClientIP, deps,
+				&TCPHeaderGenerator{hostname: cfg.Server.AdvertisedHostname()},
+				createSessionValidator(connCtx, cfg, authenticator, newTestLogger()),
+				&TCPDataHandler{}, tcpSessionHandler, connCtx)
+			sess.state = StateGreeted
+			t.Cleanup(func() { sess.rcptValidator.Close() })
+
+			ctx := context.Background()
+			if tt.authenticate {
+				plain := auth.EncodeBase64("\x00alice\x00secret")
+				if err := sess.processCommand(ctx, "AUTH PLAIN "+plain); err != nil {
+					t.Fatalf("AUTH failed: %v", err)
+				}
+				if resp := conn.lastResponse(); !strings.HasPrefix(resp, "235") {
+					t.Fatalf("AUTH should succeed, got %q", resp)
+				}
+			}
+
+			if err := sess.processCommand(ctx, "MAIL FROM:<alice@example.org>"); err != nil {
+				t.Fatalf("MAIL FROM failed: %v", err)
+			}
+			if resp := conn.lastResponse(); !strings.HasPrefix(resp, tt.wantCode) {
+				t.Errorf("MAIL FROM response: want %s, got %q", tt.wantCode, resp)
+			}
+			if wantState := tt.wantCode == "250"; (sess.state == StateMailFrom) != wantState {
+				t.Errorf("state after MAIL FROM: got %v", sess.state)
+			}
+		})
+	}
+}
+
+func TestSession_NullSender(t *testing.T) {
+	ctx := context.Background()
+
+	mailFrom := func(t *testing.T, sess *Session, conn *bufferConn, wantCode string) {
+		t.Helper()
+		if err := sess.processCommand(ctx, "MAIL FROM:<> SIZE=100"); err != nil {
+			t.Fatalf("MAIL FROM failed: %v", err)
+		}
+		if resp := conn.lastResponse(); !strings.HasPrefix(resp, wantCode) {
+			t.Fatalf("MAIL FROM:<> response: want %s, got %q", wantCode, resp)
+		}
+		if wantCode == "250" && sess.currentMessage.From != "" {
+			t.Errorf("From: want null sender, got %q", sess.currentMessage.From)
+		}
+	}
+
+	t.Run("relay accepts", func(t *testing.T) {
+		cfg := config.DefaultConfig()
+		cfg.Server.RelayDomains = []string{"relay.example.com"}
+		cfg.Relay.Enabled = true
+		sess, conn := newTestTCPSession(t, cfg)
+
+		mailFrom(t, sess, conn, "250")
+		if err := sess.processCommand(ctx, "RCPT TO:<postmaster@relay.example.com>"); err != nil {
+			t.Fatalf("RCPT TO failed: %v", err)
+		}
+		if resp := conn.lastResponse(); !strings.HasPrefix(resp, "250") {
+			t.Errorf("RCPT TO after null sender should be accepted, got %q", resp)
+		}
+	})
+
+	t.Run("submission rejects", func(t *testing.T) {
+		cfg := config.DefaultConfig()
+		authenticator := &acceptingAuthenticator{}
+		sess, conn := newTestTCPSession(t, cfg)
+		sess.authenticator = authenticator
+		sess.senderValidator = NewSubmissionValidator(authenticator, cfg)
+		if err := sess.authenticateUser(ctx, "PLAIN", "alice", "secret"); err != nil {
+			t.Fatalf("authenticateUser failed: %v", err)
+		}
+
+		mailFrom(t, sess, conn, "550")
+		if sess.state == StateMailFrom {
+			t.Error("Null sender on submission must not start a transaction")
+		}
+	})
+
+	t.Run("socket trusted user accepts", func(t *testing.T) {
+		cfg := config.DefaultConfig()
+		sess, conn := newTestSocketSession(t, cfg)
+		cfg.Server.TrustedUsers = []string{sess.senderValidator.GetUsername()}
+
+		mailFrom(t, sess, conn, "250")
+	})
+
+	t.Run("socket untrusted user rejects", func(t *testing.T) {
+		cfg := config.DefaultConfig()
+		cfg.Server.TrustedUsers = nil
+		sess, conn := newTestSocketSession(t, cfg)
+
+		mailFrom(t, sess, conn, "550")
+	})
+}
+
+func TestSubmissionSession_AuthReachesSenderValidator(t *testing.T) {
+	cfg := config.DefaultConfig()
+	authenticator := &acceptingAuthenticator{}
+	validator := NewSubmissionValidator(authenticator, cfg)
+	sess, _ := newTestTCPSession(t, cfg)
+	sess.authenticator = authenticator
+	sess.senderValidator = validator
+
+	if err := validator.ValidateSender("alice@example.org", sess.validationContext()); !errors.Is(err, ErrAuthRequired) {
+		t.Fatalf("before AUTH: want ErrAuthRequired, got %v", err)
+	}
+
+	if err := sess.authenticateUser(context.Background(), "PLAIN", "alice", "secret"); err != nil {
+		t.Fatalf("authenticateUser failed: %v", err)
+	}
+
+	if err := validator.ValidateSender("alice@example.org", sess.validationContext()); err != nil {
+		t.Errorf("after AUTH: want sender accepted, got %v", err)
+	}
+}
+
+func TestSession_CanonicalMaps(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Server.RelayDomains = []string{"public.example.com"}
+	cfg.Server.CanonicalRecipients = true
+	cfg.Relay.Enabled = true
+	cfg.Server.CanonicalMapsFilePath = filepath.Join(t.TempDir(), "canonical")
+	content := "alice@internal  alice.smith@public.example.com\n@internal  @public.example.com\n"
+	if err := os.WriteFile(cfg.Server.CanonicalMapsFilePath, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to write canonical maps file: %v", err)
+	}
+	canonicalMaps := aliases.NewCanonicalMaps(cfg)
+	if err := canonicalMaps.LoadCanonicalMaps(context.Background()); err != nil {
+		t.Fatalf("LoadCanonicalMaps failed: %v", err)
+	}
+
+	sess, conn := newTestTCPSession(t, cfg)
+	sess.canonicalMaps = canonicalMaps
+	ctx := context.Background()
+
+	if err := sess.processCommand(ctx, "MAIL FROM:<alice@internal>"); err != nil {
+		t.Fatalf("MAIL FROM failed: %v", err)
+	}
+	if resp := conn.lastResponse(); !strings.HasPrefix(resp, "250") {
+		t.Fatalf("MAIL FROM should be accepted, got %q", resp)
+	}
+	if sess.currentMessage.From != "alice.smith@public.example.com" {
+		t.Errorf("From: want address-level rewrite, got %q", sess.currentMessage.From)
+	}
+
+	if err := sess.processCommand(ctx, "RCPT TO:<bob@internal>"); err != nil {
+		t.Fatalf("RCPT TO failed: %v", err)
+	}
+	if resp := conn.lastResponse(); !strings.HasPrefix(resp, "250") {
+		t.Fatalf("RCPT TO should be accepted, got %q", resp)
+	}
+	if _, ok := sess.currentMessage.RelayRecipients["bob@public.example.com"]; !ok {
+		t.Errorf("RelayRecipients: want domain-level rewrite, got %v", sess.currentMessage.RelayRecipients)
+	}
+}
+
+func TestSession_SenderAccess(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sender_access")
+	content := "spammer@example.org  reject\nspam.example  reject\nboss@spam.example  ok\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to write sender access file: %v", err)
+	}
+	senderAccess := aliases.NewAccessMaps("sender_access", path)
+	if err := senderAccess.LoadAccessMaps(context.Background()); err != nil {
+		t.Fatalf("LoadAccessMaps failed: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		sender   string
+		wantCode string
+	}{
+		{"address reject", "spammer@example.org", "554"},
+		{"domain reject", "anyone@spam.example", "554"},
+		{"address ok overrides domain reject", "boss@spam.example", "250"},
+		{"unlisted sender", "alice@example.org", "250"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sess, conn := newTestTCPSession(t, config.DefaultConfig())
+			sess.senderAccess = senderAccess
+
+			if err := sess.processCommand(context.Background(), "MAIL FROM:<"+tt.sender+">"); err != nil {
+				t.Fatalf("MAIL FROM failed: %v", err)
+			}
+			if resp := conn.lastResponse(); !strings.HasPrefix(resp, tt.wantCode) {
+				t.Errorf("MAIL FROM response: want %s, got %q", tt.wantCode, resp)
+			}
+		})
+	}
+}
+
+func TestTCPSession_DataExceedsMaxMessageSize(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Server.MaxMessageSize = 100
+	cfg.Server.RelayDomains = []string{"relay.example.com"}
+	cfg.Relay.Enabled = true
+	cfg.Server.SpoolDir = t.TempDir()
+	if err := queue.InitializeSpoolDirectories(cfg.Server.SpoolDir); err != nil {
+		t.Fatalf("Failed to initialize spool: %v", err)
+	}
+
+	sess, conn := newTestTCPSession(t, cfg)
+	ctx := context.Background()
+	for _, cmd := range []string{"MAIL FROM:<sender@example.org>", "RCPT TO:<rcpt@relay.example.com>"} {
+		if err := sess.processCommand(ctx, cmd); err != nil {
+			t.Fatalf("%s failed: %v", cmd, err)
+		}
+	}
+
+	conn.in = strings.NewReader("Subject: big\r\n\r\n" + strings.Repeat("A", 500) + "\r\n.\r\n")
+	if err := sess.processCommand(ctx, "DATA"); err != nil {
+		t.Fatalf("DATA failed: %v", err)
+	}
+
+	if resp := conn.lastResponse(); !strings.HasPrefix(resp, "552") {
+		t.Errorf("DATA response: want 552, got %q", resp)
+	}
+	if sess.state != StateGreeted || sess.currentMessage != nil {
+		t.Errorf("Transaction should be reset after 552, state %v", sess.state)
+	}
+}
+
+func TestSession_ClassifyDomainWildcard(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Server.LocalDomains = []string{"localhost", ".example.com"}
+	cfg.Server.VirtualDomains = []string{"virtual.example.org"}
+	sess, _ := newTestTCPSession(t, cfg)
+
+	tests := []struct {
+		name   string
+		domain string
+		want   delivery.RecipientType
+	}{
+		{"exact match", "localhost", delivery.RecipientLocal},
+		{"exact match is case-insensitive", "LOCALHOST", delivery.RecipientLocal},
+		{"exact entry does not match subdomains", "sub.localhost", delivery.RecipientExternal},
+		{"wildcard matches the domain itself", "example.com", delivery.RecipientLocal},
+		{"wildcard matches subdomain", "mail.example.com", delivery.RecipientLocal},
+		{"wildcard matches nested subdomain", "a.b.Example.COM", delivery.RecipientLocal},
+		{"wildcard miss on suffix without dot", "badexample.com", delivery.RecipientExternal},
+		{"exact virtual entry does not match parent", "example.org", delivery.RecipientExternal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sess.classifyDomain(tt.domain); got != tt.want {
+				t.Errorf("classifyDomain(%q) = %v, want %v", tt.domain, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTCPSession_GreetingDelay(t *testing.T) {
+	tests := []struct {
+		name      string
+		earlyData bool
+		wantCode  string
+		wantErr   error
+	}{
+		{"client waits for banner", false, "220", nil},
+		{"client talks first", true, "554", errEarlyTalker},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.Security.GreetingDelay = 50 * time.Millisecond
+
+			serverConn, clientConn := net.Pipe()
+			defer clientConn.Close()
+			connCtx := ConnectionContext{Type: ConnectionTypeTCP, Port: 25, ClientIP: "192.0.2.1"}
+			sess := NewSession(cfg, serverConn, textproto.NewConn(serverConn), connCtx.ClientIP,
+				&Dependencies{Authenticator: &mockAuthenticator{}},
+				&TCPHeaderGenerator{hostname: cfg.Server.AdvertisedHostname()}, NewRelayValidator(cfg), &TCPDataHandler{}, tcpSessionHandler, connCtx)
+			t.Cleanup(func() { sess.rcptValidator.Close() })
+
+			reply := make(chan string, 1)
+			go func() {
+				if tt.earlyData {
+					clientConn.Write([]byte("EHLO bot.example\r\n")) //nolint:errcheck
+				}
+				line, _ := bufio.NewReader(clientConn).ReadString('\n')
+				reply <- line
+			}()
+
+			if err := sess.sendGreeting(); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("sendGreeting: want %v, got %v", tt.wantErr, err)
+			}
+
+			select {
+			case line := <-reply:
+				if !strings.HasPrefix(line, tt.wantCode) {
+					t.Errorf("banner: want %s, got %q", tt.wantCode, line)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("no banner received")
+			}
+			if tt.earlyData && sess.state != StateClosed {
+				t.Errorf("session should be closed after early talker, state %v", sess.state)
+			}
+		})
+	}
+}
+
+func TestSession_PostmasterAlwaysAccepted(t *testing.T) {
+	tests := []struct {
+		name        string
+		recipient   string
+		configure   func(cfg *config.Config)
+		wantCode    string
+		wantMailbox string
+	}{
+		{"postmaster local domain", "Postmaster@localhost", nil, "250", "root@localhost"},
+		{"postmaster virtual domain", "postmaster@mail.localhost", nil, "250", "root@localhost"},
+		{"custom fallback mailbox", "postmaster@localhost", func(cfg *config.Config) {
+			cfg.Server.PostmasterMailbox = "admin@mail.localhost"
+		}, "250", "admin@mail.localhost"},
+		{"relay domain mailbox", "postmaster@localhost", func(cfg *config.Config) {
+			cfg.Server.RelayDomains = []string{"relay.example.com"}
+			cfg.Server.PostmasterMailbox = "noc@relay.example.com"
+		}, "250", "noc@relay.example.com"},
+		{"external mailbox", "postmaster@localhost", func(cfg *config.Config) {
+			cfg.Server.PostmasterMailbox = "noc@example.net"
+		}, "250", "noc@example.net"},
+		{"abuse off by default", "abuse@localhost", nil, "550", ""},
+		{"abuse enabled", "abuse@localhost", func(cfg *config.Config) {
+			cfg.Server.AcceptAbuse = true
+		}, "250", "root@localhost"},
+		{"postmaster disabled", "Postmaster@localhost", func(cfg *config.Config) {
+			cfg.Server.AcceptPostmaster = false
+		}, "550", ""},
+		{"other unknown user", "nobody-here@localhost", nil, "550", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.Relay.Enabled = true
+			if tt.configure != nil {
+				tt.configure(cfg)
+			}
+			sess, conn := newTestTCPSession(t, cfg)
+			ctx := context.Background()
+
+			if err := sess.processCommand(ctx, "MAIL FROM:<sender@example.org>"); err != nil {
+				t.Fatalf("MAIL FROM failed: %v", err)
+			}
+			if err := sess.processCommand(ctx, "RCPT TO:<"+tt.recipient+">"); err != nil {
+				t.Fatalf("RCPT TO failed: %v", err)
+			}
+			if resp := conn.lastResponse(); !strings.HasPrefix(resp, tt.wantCode) {
+				t.Fatalf("RCPT TO:<%s>: expected %s, got %q", tt.recipient, tt.wantCode, resp)
+			}
+			if tt.wantMailbox == "" {
+				return
+			}
+
+			_, domain := auth.ExtractUsernameAndDomain(tt.wantMailbox)
+			if set := sess.recipientSet(sess.classifyDomain(domain)); !set.Contains(tt.wantMailbox) {
+				t.Errorf("Expected %s routed to %s, got %v", tt.recipient, tt.wantMailbox, set)
+			}
+			if sess.currentMessage.TotalRecipients() != 1 {
+				t.Errorf("Expected exactly one recipient, got %d", sess.currentMessage.TotalRecipients())
+			}
+		})
+	}
+}
+
+func TestSession_PostmasterMailboxAlias(t *testing.T) {
+	cfg, maps := newExpnTestConfig(t) // "staff" expands to root
+	cfg.Relay.Enabled = true
+	cfg.Server.PostmasterMailbox = "staff@localhost"
+	sess, conn := newTestTCPSession(t, cfg)
+	sess.rcptValidator.Close()
+	sess.rcptValidator = NewRcptValidator(cfg, &mockAuthenticator{}, maps)
+	ctx := context.Background()
+
+	for _, cmd := range []string{"MAIL FROM:<sender@example.org>", "RCPT TO:<postmaster@mail.localhost>"} {
+		if err := sess.processCommand(ctx, cmd); err != nil {
+			t.Fatalf("%s failed: %v", cmd, err)
+		}
+	}
+	if resp := conn.lastResponse(); !strings.HasPrefix(resp, "250") {
+		t.Fatalf("RCPT TO: want 250, got %q", resp)
+	}
+	info, ok := sess.currentMessage.LocalRecipients["root@localhost"]
+	if !ok || info.Original != "postmaster@mail.localhost" || sess.currentMessage.TotalRecipients() != 1 {
+		t.Errorf("Expected postmaster expanded through staff to root@localhost, got %v", sess.currentMessage.LocalRecipients)
+	}
+}
+
+func TestTCPSession_SubmissionRateLimit(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Server.RelayDomains = []string{"relay.example.com"}
+	cfg.Relay.Enabled = true
+	cfg.Server.SpoolDir = t.TempDir()
+	cfg.Security.SubmissionRateLimit = config.SubmissionRateLimitConfig{PerIP: 2, Window: time.Minute}
+	if err := queue.InitializeSpoolDirectories(cfg.Server.SpoolDir); err != nil {
+		t.Fatalf("Failed to initialize spool: %v", err)
+	}
+	q, err := queue.NewQueue(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("NewQueue failed: %v", err)
+	}
+
+	sess, conn := newTestTCPSession(t, cfg)
+	sess.queue = q
+	sess.rateLimiter = security.NewSubmissionLimiter(&cfg.Security.SubmissionRateLimit)
+	ctx := context.Background()
+
+	sendMessage := func() string {
+		t.Helper()
+		for _, cmd := range []string{"MAIL FROM:<sender@example.org>", "RCPT TO:<rcpt@relay.example.com>"} {
+			if err := sess.processCommand(ctx, cmd); err != nil {
+				t.Fatalf("%s failed: %v", cmd, err)
+			}
+		}
+		conn.in = strings.NewReader("Subject: hi\r\n\r\nbody\r\n.\r\n")
+		if err := sess.processCommand(ctx, "DATA"); err != nil {
+			t.Fatalf("DATA failed: %v", err)
+		}
+		return conn.lastResponse()
+	}
+
+	for i := range 2 {
+		if resp := sendMessage(); !strings.HasPrefix(resp, "250") {
+			t.Fatalf("Message %d: want 250, got %q", i+1, resp)
+		}
+	}
+
+	if resp := sendMessage(); resp != "452 4.3.1 Message rate limit exceeded" {
+		t.Errorf("Message over the limit: want 452 4.3.1, got %q", resp)
+	}
+	if strings.Count(conn.out.String(), "354 ") != 2 {
+		t.Errorf("Rate-limited DATA must not be accepted, got:\n%s", conn.out.String())
+	}
+	if sess.state != StateGreeted || sess.currentMessage != nil {
+		t.Errorf("Transaction should be reset after 452, state %v", sess.state)
+	}
+}
+
+func TestSession_CommandWhitelist(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Server.EnabledCommands = []string{"HELO", "EHLO", "MAIL", "RCPT", "DATA", "RSET", "NOOP"}
+
+	tests := []struct {
+		command string
+		want    string
+	}{
+		{"NOOP", "250"},
+		{"noop", "250"},
+		{"VRFY root", "502 Command disabled"},
+		{"STARTTLS", "502 Command disabled"},
+		{"HELP", "502 Command disabled"},
+		{"XYZZY", "500 Command unrecognized"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			sess, conn := newTestTCPSession(t, cfg)
+			if err := sess.processCommand(context.Background(), tt.command); err != nil {
+				t.Fatalf("processCommand failed: %v", err)
+			}
+			if got := conn.lastResponse(); !strings.HasPrefix(got, tt.want) {
+				t.Errorf("%s: got %q, want prefix %q", tt.command, got, tt.want)
+			}
+		})
+	}
+
+	// Known but unimplemented commands stay 502 when everything is enabled
+	sess, conn := newTestTCPSession(t, config.DefaultConfig())
+	sess.processCommand(context.Background(), "EXPN staff")
+	if got := conn.lastResponse(); !strings.HasPrefix(got, "502 Command not implemented") {
+		t.Errorf("EXPN: got %q, want 502", got)
+	}
+}
+
+func TestSession_DisconnectOnUnknown(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Server.DisconnectOnUnknown = 2
+	sess, conn := newTestTCPSession(t, cfg)
+
+	sess.processCommand(context.Background(), "FOO")
+	if got := conn.lastResponse(); !strings.HasPrefix(got, "500") {
+		t.Fatalf("First unknown command: got %q, want 500", got)
+	}
+	if sess.state == StateClosed {
+		t.Fatal("Session closed before reaching disconnect_on_unknown")
+	}
+
+	// Known commands don't count toward the limit
+	sess.processCommand(context.Background(), "NOOP")
+
+	sess.processCommand(context.Background(), "BAR")
+	if got := conn.lastResponse(); !strings.HasPrefix(got, "421") {
+		t.Errorf("Second unknown command: got %q, want 421", got)
+	}
+	if sess.state != StateClosed {
+		t.Error("Expected session closed after disconnect_on_unknown")
+	}
+}
+
+func TestSession_InvalidRecipientsHarvesting(t *testing.T) {
+	const tarpitDelay = 50 * time.Millisecond
+
+	cfg := config.DefaultConfig()
+	cfg.Relay.Enabled = true
+	cfg.Security.InvalidRecipients = config.InvalidRecipientsConfig{
+		TarpitAfter:     2,
+		TarpitDelay:     tarpitDelay,
+		DisconnectAfter: 3,
+	}
+	sess, conn := newTestTCPSession(t, cfg)
+	ctx := context.Background()
+
+	if err := sess.processCommand(ctx, "MAIL FROM:<sender@example.org>"); err != nil {
+		t.Fatalf("MAIL FROM failed: %v", err)
+	}
+
+	rcpt := func(recipient, wantCode string) time.Duration {
+		t.Helper()
+		start := time.Now()
+		if err := sess.processCommand(ctx, "RCPT TO:<"+recipient+">"); err != nil {
+			t.Fatalf("RCPT TO:<%s> failed: %v", recipient, err)
+		}
+		if resp := conn.lastResponse(); !strings.HasPrefix(resp, wantCode) {
+			t.Fatalf("RCPT TO:<%s>: want %s, got %q", recipient, wantCode, resp)
+		}
+		return time.Since(start)
+	}
+
+	if elapsed := rcpt("nobody1@localhost", "550"); elapsed >= tarpitDelay {
+		t.Errorf("First invalid recipient should not be delayed, took %v", elapsed)
+	}
+	if elapsed := rcpt("nobody2@localhost", "550"); elapsed < tarpitDelay {
+		t.Errorf("Invalid recipient past tarpit_after should be delayed, took %v", elapsed)
+	}
+
+	// A valid recipient resets the run
+	rcpt("postmaster@localhost", "250")
+	rcpt("nobody3@localhost", "550")
+	rcpt("nobody4@localhost", "550")
+	if sess.state == StateClosed {
+		t.Fatal("Session closed before reaching disconnect_after")
+	}
+
+	rcpt("nobody5@localhost", "550")
+	if resp := conn.lastResponse(); !strings.Contains(resp, "Too many invalid recipients") {
+		t.Errorf("Expected harvesting disconnect reply, got %q", resp)
+	}
+	if sess.state != StateClosed {
+		t.Error("Expected session closed after disconnect_after invalid recipients")
+	}
+}
+
+// newExpnTestConfig enables EXPN with a "staff" alias for root
+func newExpnTestConfig(t *testing.T) (*config.Config, *aliases.LocalAliasesMaps) {
+	t.Helper()
+
+	aliasesFile := filepath.Join(t.TempDir(), "aliases")
+	if err := os.WriteFile(aliasesFile, []byte("staff: root, root\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write aliases file: %v", err)
+	}
+
+	cfg := config.DefaultConfig()
+	cfg.Server.LocalAliasesFilePath = aliasesFile
+	cfg.Server.EnableExpn = true
+	cfg.Server.ExpnNetworks = []string{"203.0.113.0/24"}
+
+	maps := aliases.NewLocalAliasesMaps(cfg)
+	if err := maps.LoadAliasesMaps(context.Background()); err != nil {
+		t.Fatalf("LoadAliasesMaps failed: %v", err)
+	}
+	return cfg, maps
+}
+
+func TestSession_Expn(t *testing.T) {
+	cfg, maps := newExpnTestConfig(t)
+
+	tests := []struct {
+		name       string
+		newSession func(t *testing.T, cfg *config.Config) (*Session, *bufferConn)
+		clientIP   string
+		command    string
+		want       []string
+	}{
+		{"socket expands alias", newTestSocketSession, "", "EXPN staff",
+			[]string{"250-<root@localhost>", "250 <root@localhost>"}},
+		{"trusted network expands alias", newTestTCPSession, "203.0.113.9", "EXPN <staff@localhost>",
+			[]string{"250-<root@localhost>", "250 <root@localhost>"}},
+		{"unknown alias", newTestSocketSession, "", "EXPN nobody",
+			[]string{"550 Mailing list not found"}},
+		{"non-local domain", newTestSocketSession, "", "EXPN staff@example.com",
+			[]string{"550 Mailing list not found"}},
+		{"untrusted network refused", newTestTCPSession, "192.0.2.1", "EXPN staff",
+			[]string{"502 Command not implemented"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sess, conn := tt.newSession(t, cfg)
+			sess.rcptValidator.Close()
+			sess.rcptValidator = NewRcptValidator(cfg, &mockAuthenticator{}, maps)
+			if tt.clientIP != "" {
+				sess.clientIP = tt.clientIP
+			}
+
+			if err := sess.processCommand(context.Background(), tt.command); err != nil {
+				t.Fatalf("processCommand failed: %v", err)
+			}
+			got := strings.Split(strings.TrimRight(conn.out.String(), "\r\n"), "\r\n")
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("EXPN response mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSession_ExpnDisabledByDefault(t *testing.T) {
+	cfg, maps := newExpnTestConfig(t)
+	cfg.Server.EnableExpn = false
+
+	sess, conn := newTestSocketSession(t, cfg)
+	sess.rcptValidator.Close()
+	sess.rcptValidator = NewRcptValidator(cfg, &mockAuthenticator{}, maps)
+
+	sess.processCommand(context.Background(), "EXPN staff")
+	if got := conn.lastResponse(); got != "502 Command not implemented" {
+		t.Errorf("EXPN with enable_expn=false: got %q, want 502", got)
+	}
+}
+
+func TestTCPSession_MaxSessionsPerUser(t *testing.T) {
+	const maxSessions = 2
+
+	cfg := config.DefaultConfig()
+	limiter := security.NewUserSessionLimiter(maxSessions)
+	authCmd := "AUTH PLAIN " + auth.EncodeBase64("\x00alice\x00secret")
+
+	newAuthSession := func() (*Session, *bufferConn) {
+		sess, conn := newTestTCPSession(t, cfg)
+		sess.connCtx.Mode = config.ListenerModePlain
+		sess.authenticator = &acceptingAuthenticator{}
+		sess.userSessions = limiter
+		if err := sess.processCommand(context.Background(), authCmd); err != nil {
+			t.Fatalf("AUTH failed: %v", err)
+		}
+		return sess, conn
+	}
+
+	var sessions []*Session
+	for i := range maxSessions {
+		sess, conn := newAuthSession()
+		if resp := conn.lastResponse(); !strings.HasPrefix(resp, "235") {
+			t.Fatalf("Session %d: want 235, got %q", i+1, resp)
+		}
+		sessions = append(sessions, sess)
+	}
+
+	sess, conn := newAuthSession()
+	if resp := conn.lastResponse(); !strings.HasPrefix(resp, "421") || !strings.Contains(resp, "Too many concurrent sessions") {
+		t.Errorf("Session over the cap: want 421 too many concurrent sessions, got %q", resp)
+	}
+	if sess.state != StateClosed || sess.authenticated {
+		t.Errorf("Refused session must be closed and unauthenticated, state %v", sess.state)
+	}
+	sess.releaseUserSession() // no-op: the refused session holds no slot
+	if got := limiter.Active("alice"); got != maxSessions {
+		t.Errorf("Active sessions = %d, want %d", got, maxSessions)
+	}
+
+	// Ending a session frees its slot
+	sessions[0].releaseUserSession()
+	if _, conn := newAuthSession(); !strings.HasPrefix(conn.lastResponse(), "235") {
+		t.Errorf("After a session ended: want 235, got %q", conn.lastResponse())
+	}
+}
+
+func TestTCPSession_StripBccHeaders(t *testing.T) {
+	const message = "Subject: hello\r\n" +
+		"Bcc: hidden@example.org,\r\n" +
+		"\tother-hidden@example.org\r\n" +
+		"To: postmaster@localhost\r\n" +
+		"resent-bcc: resent@example.org\r\n" +
+		"\r\n" +
+		"Bcc: in the body is kept\r\n" +
+		".\r\n"
+
+	tests := []struct {
+		name         string
+		authenticate bool
+		wantBcc      bool
+	}{
+		{"authenticated user", true, false},
+		{"unauthenticated relay client", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.Relay.Enabled = true
+			cfg.Server.StripBccHeaders = true
+			cfg.Server.SpoolDir = t.TempDir()
+			if err := queue.InitializeSpoolDirectories(cfg.Server.SpoolDir); err != nil {
+				t.Fatalf("Failed to initialize spool: %v", err)
+			}
+			q, err := queue.NewQueue(context.Background(), cfg)
+			if err != nil {
+				t.Fatalf("NewQueue failed: %v", err)
+			}
+
+			sess, conn := newTestTCPSession(t, cfg)
+			sess.queue = q
+			sess.connCtx.Mode = config.ListenerModePlain
+			authenticator := &acceptingAuthenticator{}
+			sess.authenticator = authenticator
+			ctx := context.Background()
+
+			commands := []string{"MAIL FROM:<alice@example.org>", "RCPT TO:<postmaster@localhost>"}
+			if tt.authenticate {
+				sess.senderValidator = NewSubmissionValidator(authenticator, cfg)
+				commands = append([]string{"AUTH PLAIN " + auth.EncodeBase64("\x00alice\x00secret")}, commands...)
+			}
+			for _, cmd := range commands {
+				if err := sess.processCommand(ctx, cmd); err != nil {
+					t.Fatalf("%s failed: %v", cmd, err)
+				}
+			}
+			conn.in = strings.NewReader(message)
+			if err := sess.processCommand(ctx, "DATA"); err != nil {
+				t.Fatalf("DATA failed: %v", err)
+			}
+			if resp := conn.lastResponse(); !strings.HasPrefix(resp, "250") {
+				t.Fatalf("DATA: want 250, got %q", resp)
+			}
+
+			stored, err := filepath.Glob(filepath.Join(cfg.Server.SpoolDir, string(queue.MessageStateIncoming), "*.eml"))
+			if err != nil || len(stored) != 1 {
+				t.Fatalf("Expected one stored message, got %v (err %v)", stored, err)
+			}
+			content, err := os.ReadFile(stored[0])
+			if err != nil {
+				t.Fatalf("Failed to read stored message: %v", err)
+			}
+
+			header, body, _ := strings.Cut(string(content), "\r\n\r\n")
+			hasBcc := strings.Contains(strings.ToLower(header), "bcc:") || strings.Contains(header, "hidden@example.org")
+			if hasBcc != tt.wantBcc {
+				t.Errorf("Bcc in stored headers = %v, want %v:\n%s", hasBcc, tt.wantBcc, header)
+			}
+			for _, kept := range []string{"Subject: hello", "To: postmaster@localhost"} {
+				if !strings.Contains(header, kept) {
+					t.Errorf("Header %q should be kept:\n%s", kept, header)
+				}
+			}
+			if !strings.Contains(body, "Bcc: in the body is kept") {
+				t.Errorf("Body must not be altered:\n%s", body)
+			}
+		})
+	}
+}
+
+func TestTCPSession_PublicHostname(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Server.Hostname = "mx1.internal.lan"
+	cfg.Server.PublicHostname = "mail.example.com"
+
+	conn := &bufferConn{in: strings.NewReader("")}
+	connCtx := ConnectionContext{Type: ConnectionTypeTCP, Port: 25, ClientIP: "192.0.2.1"}
+	deps := &Dependencies{Authenticator: &mockAuthenticator{}}
+	sess := NewTCPSession(connCtx, cfg, nil, textproto.NewConn(conn), NewRelayValidator(cfg), deps).(*Session)
+	t.Cleanup(func() { sess.rcptValidator.Close() })
+
+	if err := sess.sendGreeting(); err != nil {
+		t.Fatalf("sendGreeting: %v", err)
+	}
+	if want := "220 mail.example.com ESMTP Service ready"; conn.lastResponse() != want {
+		t.Errorf("banner: want %q, got %q", want, conn.lastResponse())
+	}
+
+	if err := sess.processCommand(context.Background(), "EHLO client.example.org"); err != nil {
+		t.Fatalf("EHLO: %v", err)
+	}
+	if out := conn.out.String(); !strings.Contains(out, "250-mail.example.com Hello") || strings.Contains(out, "internal.lan") {
+		t.Errorf("EHLO should greet with the public hostname only, got:\n%s", out)
+	}
+
+	msg := &queue.Message{ID: "msg-1", ClientHelloHostname: "client.example.org"}
+	headers := sess.headerGenerator.GenerateHeaders(msg, connCtx)
+	if !strings.Contains(headers, "by mail.example.com with ESMTP") || strings.Contains(headers, "internal.lan") {
+		t.Errorf("Received header should name the public hostname only, got:\n%s", headers)
+	}
+}
+
+func TestSession_AliasRecordsOriginalRecipient(t *testing.T) {
+	cfg, maps := newExpnTestConfig(t)
+	cfg.Relay.Enabled = true
+
+	sess, conn := newTestTCPSession(t, cfg)
+	sess.rcptValidator.Close()
+	sess.rcptValidator = NewRcptValidator(cfg, &mockAuthenticator{}, maps)
+	ctx := context.Background()
+
+	for _, cmd := range []string{"MAIL FROM:<sender@example.org>", "RCPT TO:<staff@localhost> NOTIFY=NEVER"} {
+		if err := sess.processCommand(ctx, cmd); err != nil {
+			t.Fatalf("%s: %v", cmd, err)
+		}
+		if resp := conn.lastResponse(); !strings.HasPrefix(resp, "250") {
+			t.Fatalf("%s: got %q", cmd, resp)
+		}
+	}
+
+	// The expanded member keeps the alias address for X-Original-To
+	if got := sess.currentMessage.OriginalRecipient("root@localhost"); got != "staff@localhost" {
+		t.Errorf("OriginalRecipient(root@localhost) = %q, want staff@localhost", got)
+	}
+	// and the DSN parameters given with it
+	if got := sess.currentMessage.RecipientDSN("root@localhost"); !got.NotifyOn("NEVER") {
+		t.Errorf("RecipientDSN(root@localhost) = %+v, want NOTIFY=NEVER", got)
+	}
+}
+
+func TestSession_RecipientAccess(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "recipient_access")
+	content := "root@localhost  reject\nnosuchuser-golubsmtpd@localhost  ok\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to write recipient access file: %v", err)
+	}
+	recipientAccess := aliases.NewAccessMaps("recipient_access", path)
+	if err := recipientAccess.LoadAccessMaps(context.Background()); err != nil {
+		t.Fatalf("LoadAccessMaps failed: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		recipient string
+		wantCode  string
+	}{
+		{"blocked existing user", "root@localhost", "550"},
+		{"allowed without user check", "nosuchuser-golubsmtpd@localhost", "250"},
+		{"unlisted unknown user", "nosuchuser-other@localhost", "550"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.Relay.Enabled = true
+			sess, conn := newTestTCPSession(t, cfg)
+			sess.recipientAccess = recipientAccess
+			ctx := context.Background()
+
+			if err := sess.processCommand(ctx, "MAIL FROM:<sender@example.org>"); err != nil {
+				t.Fatalf("MAIL FROM failed: %v", err)
+			}
+			if err := sess.processCommand(ctx, "RCPT TO:<"+tt.recipient+">"); err != nil {
+				t.Fatalf("RCPT TO failed: %v", err)
+			}
+			if resp := conn.lastResponse(); !strings.HasPrefix(resp, tt.wantCode) {
+				t.Errorf("RCPT TO response: want %s, got %q", tt.wantCode, resp)
+			}
+		})
+	}
+}
+
+func TestSession_RecipientAccessOKExpandsAlias(t *testing.T) {
+	cfg, maps := newExpnTestConfig(t) // "staff" expands to root
+	cfg.Relay.Enabled = true
+	path := filepath.Join(t.TempDir(), "recipient_access")
+	if err := os.WriteFile(path, []byte("staff@localhost  ok\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write recipient access file: %v", err)
+	}
+	recipientAccess := aliases.NewAccessMaps("recipient_access", path)
+	if err := recipientAccess.LoadAccessMaps(context.Background()); err != nil {
+		t.Fatalf("LoadAccessMaps failed: %v", err)
+	}
+
+	sess, conn := newTestTCPSession(t, cfg)
+	sess.rcptValidator.Close()
+	sess.rcptValidator = NewRcptValidator(cfg, &mockAuthenticator{}, maps)
+	sess.recipientAccess = recipientAccess
+	ctx := context.Background()
+
+	for _, cmd := range []string{"MAIL FROM:<sender@example.org>", "RCPT TO:<staff@localhost>"} {
+		if err := sess.processCommand(ctx, cmd); err != nil {
+			t.Fatalf("%s failed: %v", cmd, err)
+		}
+	}
+	if resp := conn.lastResponse(); !strings.HasPrefix(resp, "250") {
+		t.Fatalf("RCPT TO: want 250, got %q", resp)
+	}
+	if _, ok := sess.currentMessage.LocalRecipients["root@localhost"]; !ok || sess.currentMessage.TotalRecipients() != 1 {
+		t.Errorf("Expected staff expanded to root@localhost, got %v", sess.currentMessage.LocalRecipients)
+	}
+}
+
+func TestSession_EhloExtensionOverrides(t *testing.T) {
+	tests := []struct {
+		name      string
+		overrides map[string]bool
+		want      []string
+	}{
+		{"defaults", nil, []string{"250-STARTTLS", "250-8BITMIME", "250-DSN", "250 HELP"}},
+		{"force-disable advertised STARTTLS", map[string]bool{"starttls": false}, []string{"250-8BITMIME", "250-DSN", "250 HELP"}},
+		{"force-disable advertised 8BITMIME", map[string]bool{"8BITMIME": false}, []string{"250-STARTTLS", "250-DSN", "250 HELP"}},
+		{"force-disable last line", map[string]bool{"HELP": false}, []string{"250-STARTTLS", "250-8BITMIME", "250 DSN"}},
+		{"force-enable", map[string]bool{"PIPELINING": true, "SIZE": true, "DSN": true},
+			[]string{"250-STARTTLS", "250-8BITMIME", "250-DSN", "250-HELP", "250-PIPELINING", "250 SIZE 1048576"}},
+		{"force-enable already advertised", map[string]bool{"STARTTLS": true}, []string{"250-STARTTLS", "250-8BITMIME", "250-DSN", "250 HELP"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.Server.MaxMessageSize = 1048576
+			cfg.Server.EhloExtensions = tt.overrides
+			sess, conn := newTestTCPSession(t, cfg)
+			sess.connCtx.Mode = config.ListenerModeSTARTTLS // STARTTLS would normally be advertised
+
+			if err := sess.processCommand(context.Background(), "EHLO client.example.com"); err != nil {
+				t.Fatalf("EHLO failed: %v", err)
+			}
+			lines := strings.Split(strings.TrimRight(conn.out.String(), "\r\n"), "\r\n")
+			if diff := cmp.Diff(tt.want, lines[1:]); diff != "" {
+				t.Errorf("EHLO extensions mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSession_MailBodyParameter(t *testing.T) {
+	tests := []struct {
+		name     string
+		param    string
+		wantCode string
+		wantBody string
+	}{
+		{"8bitmime", " BODY=8BITMIME", "250", "8BITMIME"},
+		{"7bit", " BODY=7BIT", "250", "7BIT"},
+		{"lowercase", " body=8bitmime", "250", "8BITMIME"},
+		{"not given", "", "250", ""},
+		{"invalid value", " BODY=BINARYMIME", "501", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sess, conn := newTestTCPSession(t, config.DefaultConfig())
+
+			if err := sess.processCommand(context.Background(), "MAIL FROM:<sender@example.org>"+tt.param); err != nil {
+				t.Fatalf("MAIL FROM failed: %v", err)
+			}
+			if resp := conn.lastResponse(); !strings.HasPrefix(resp, tt.wantCode) {
+				t.Errorf("MAIL FROM response: want %s, got %q", tt.wantCode, resp)
+			}
+			if tt.wantCode == "250" && sess.currentMessage.BodyType != tt.wantBody {
+				t.Errorf("BodyType = %q, want %q", sess.currentMessage.BodyType, tt.wantBody)
+			}
+			if tt.wantCode != "250" && sess.state == StateMailFrom {
+				t.Error("transaction started despite invalid BODY parameter")
+			}
+		})
+	}
+}
+
+func TestSession_DSNMailParameters(t *testing.T) {
+	tests := []struct {
+		name      string
+		params    string
+		wantCode  string
+		wantRet   string
+		wantEnvID string
+	}{
+		{"ret full", " RET=FULL", "250", "FULL", ""},
+		{"ret hdrs lowercase", " ret=hdrs", "250", "HDRS", ""},
+		{"envid xtext", " ENVID=QQ+2B314", "250", "", "QQ+314"},
+		{"both", " RET=HDRS ENVID=abc", "250", "HDRS", "abc"},
+		{"invalid ret", " RET=BODY", "501", "", ""},
+		{"invalid envid xtext", " ENVID=bad+ZZ", "501", "", ""},
+		{"envid too long", " ENVID=" + strings.Repeat("x", 101), "501", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sess, conn := newTestTCPSession(t, config.DefaultConfig())
+
+			if err := sess.processCommand(context.Background(), "MAIL FROM:<sender@example.org>"+tt.params); err != nil {
+				t.Fatalf("MAIL FROM failed: %v", err)
+			}
+			if resp := conn.lastResponse(); !strings.HasPrefix(resp, tt.wantCode) {
+				t.Fatalf("MAIL FROM response: want %s, got %q", tt.wantCode, resp)
+			}
+			if tt.wantCode != "250" {
+				return
+			}
+			if sess.currentMessage.DSNRet != tt.wantRet || sess.currentMessage.DSNEnvID != tt.wantEnvID {
+				t.Errorf("RET=%q ENVID=%q, want RET=%q ENVID=%q",
+					sess.currentMessage.DSNRet, sess.currentMessage.DSNEnvID, tt.wantRet, tt.wantEnvID)
+			}
+		})
+	}
+}
+
+func TestSession_DSNRcptParameters(t *testing.T) {
+	tests := []struct {
+		name     string
+		params   string
+		wantCode string
+		want     types.DSNParams
+	}{
+		{"no parameters", "", "250", types.DSNParams{}},
+		{"notify never", " NOTIFY=NEVER", "250", types.DSNParams{Notify: []string{"NEVER"}}},
+		{"notify list", " NOTIFY=success,FAILURE,DELAY", "250", types.DSNParams{Notify: []string{"SUCCESS", "FAILURE", "DELAY"}}},
+		{"orcpt", " ORCPT=rfc822;Bob+40example.org", "250", types.DSNParams{ORcpt: "rfc822;Bob@example.org"}},
+		{"notify and orcpt", " NOTIFY=FAILURE ORCPT=RFC822;root@localhost", "250",
+			types.DSNParams{Notify: []string{"FAILURE"}, ORcpt: "rfc822;root@localhost"}},
+		{"invalid notify", " NOTIFY=SOMETIMES", "501", types.DSNParams{}},
+		{"never combined", " NOTIFY=NEVER,FAILURE", "501", types.DSNParams{}},
+		{"duplicate notify keyword", " NOTIFY=DELAY,DELAY", "501", types.DSNParams{}},
+		{"orcpt without addr-type", " ORCPT=root@localhost", "501", types.DSNParams{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.Relay.Enabled = true
+			sess, conn := newTestTCPSession(t, cfg)
+			ctx := context.Background()
+
+			if err := sess.processCommand(ctx, "MAIL FROM:<sender@example.org>"); err != nil {
+				t.Fatalf("MAIL FROM failed: %v", err)
+			}
+			if err := sess.processCommand(ctx, "RCPT TO:<root@localhost>"+tt.params); err != nil {
+				t.Fatalf("RCPT TO failed: %v", err)
+			}
+			if resp := conn.lastResponse(); !strings.HasPrefix(resp, tt.wantCode) {
+				t.Fatalf("RCPT TO response: want %s, got %q", tt.wantCode, resp)
+			}
+			if diff := cmp.Diff(tt.want, sess.currentMessage.RecipientDSN("root@localhost")); diff != "" {
+				t.Errorf("DSN parameters mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSession_RejectScore(t *testing.T) {
+	tests := []struct {
+		name        string
+		listings    []string
+		reverseDNS  string
+		trusted     []string
+		allowlisted bool
+		wantReply   string
+	}{
+		{"two signals below threshold", []string{"zen.example.net"}, "host.example.org", nil, false, "250 "},
+		{"signals over threshold", []string{"zen.example.net", "dbl.example.net"}, "", nil, false,
+			"554 Rejected by policy score 6.5/5.0: DNSBL_zen.example.net=2.0, DNSBL_dbl.example.net=2.0, NO_RDNS=1.0, HELO_NON_FQDN=1.5"},
+		{"trusted network not scored", []string{"zen.example.net", "dbl.example.net"}, "", []string{"192.0.2.0/24"}, false, "250 "},
+		{"allowlisted client not scored", nil, "", nil, true, "250 "},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.Security.ReverseDNS.Enabled = true
+			cfg.Security.RejectScore.Enabled = true
+			cfg.Security.TrustedNetworks = tt.trusted
+			sess, conn := newTestTCPSession(t, cfg) // client 192.0.2.1
+			sess.rejectScorer = security.NewRejectScorer(&cfg.Security)
+			sess.connCtx.DNSBL = tt.listings
+			sess.connCtx.Allowlisted = tt.allowlisted
+			sess.reverseDNS = tt.reverseDNS
+			ctx := context.Background()
+
+			if err := sess.processCommand(ctx, "EHLO desktop"); err != nil {
+				t.Fatalf("EHLO failed: %v", err)
+			}
+			if err := sess.processCommand(ctx, "MAIL FROM:<sender@example.org>"); err != nil {
+				t.Fatalf("MAIL FROM failed: %v", err)
+			}
+			if resp := conn.lastResponse(); !strings.HasPrefix(resp, tt.wantReply) {
+				t.Errorf("MAIL FROM: want %q, got %q", tt.wantReply, resp)
+			}
+		})
+	}
+}
+
+func TestSession_TrustedNetworkRelay(t *testing.T) {
+	tests := []struct {
+		name     string
+		networks []string
+		wantCode string
+	}{
+		{"trusted client relays externally", []string{"192.0.2.0/24"}, "250"},
+		{"untrusted client denied", []string{"198.51.100.0/24"}, "554"},
+		{"no trusted networks", nil, "554"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultConfig() // Relay.Enabled = false
+			cfg.Security.TrustedNetworks = tt.networks
+			sess, conn := newTestTCPSession(t, cfg) // client 192.0.2.1
+			ctx := context.Background()
+
+			if err := sess.processCommand(ctx, "MAIL FROM:<app@internal.example>"); err != nil {
+				t.Fatalf("MAIL FROM failed: %v", err)
+			}
+			if err := sess.processCommand(ctx, "RCPT TO:<bob@remote.example>"); err != nil {
+				t.Fatalf("RCPT TO failed: %v", err)
+			}
+			if resp := conn.lastResponse(); !strings.HasPrefix(resp, tt.wantCode) {
+				t.Errorf("RCPT TO response: want %s, got %q", tt.wantCode, resp)
+			}
+
+			_, accepted := sess.currentMessage.ExternalRecipients["bob@remote.example"]
+			if accepted != (tt.wantCode == "250") {
+				t.Errorf("external recipient recorded = %v, want %v", accepted, tt.wantCode == "250")
+			}
+		})
+	}
+}
+
+func TestLMTPSession_TrustedNetworkDoesNotRelay(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Security.TrustedNetworks = []string{"192.0.2.0/24"}
+	sess, conn := newTestLMTPSession(t, cfg) // client 192.0.2.1
+	ctx := context.Background()
+
+	for _, cmd := range []string{"LHLO mx.example.org", "MAIL FROM:<app@internal.example>", "RCPT TO:<bob@remote.example>"} {
+		if err := sess.processCommand(ctx, cmd); err != nil {
+			t.Fatalf("%s failed: %v", cmd, err)
+		}
+	}
+	if resp := conn.lastResponse(); !strings.HasPrefix(resp, "554") {
+		t.Errorf("RCPT TO over LMTP from a trusted network: want 554, got %q", resp)
+	}
+}
+
+func TestSession_ContentChecks(t *testing.T) {
+	dir := t.TempDir()
+	headerChecks := filepath.Join(dir, "header_checks")
+	bodyChecks := filepath.Join(dir, "body_checks")
+	if err := os.WriteFile(headerChecks, []byte("/^Subject: buy now/ REJECT Spam is not welcome\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write header checks: %v", err)
+	}
+	if err := os.WriteFile(bodyChecks, []byte("/casino/i DISCARD\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write body checks: %v", err)
+	}
+	checker, err := security.NewContentChecker(&config.ContentChecksConfig{HeaderChecksFile: headerChecks, BodyChecksFile: bodyChecks})
+	if err != nil {
+		t.Fatalf("NewContentChecker failed: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		message  string
+		wantResp string
+	}{
+		{"header reject", "Subject: buy now\r\n\r\nhello\r\n.\r\n", "550 Spam is not welcome"},
+		{"body discard", "Subject: hi\r\n\r\nBest Casino bonus\r\n.\r\n", "250 Message accepted for delivery"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.Server.RelayDomains = []string{"relay.example.com"}
+			cfg.Relay.Enabled = true
+			cfg.Server.SpoolDir = t.TempDir()
+			if err := queue.InitializeSpoolDirectories(cfg.Server.SpoolDir); err != nil {
+				t.Fatalf("Failed to initialize spool: %v", err)
+			}
+
+			sess, conn := newTestTCPSession(t, cfg)
+			sess.contentChecker = checker
+			ctx := context.Background()
+			for _, cmd := range []string{"MAIL FROM:<sender@example.org>", "RCPT TO:<rcpt@relay.example.com>"} {
+				if err := sess.processCommand(ctx, cmd); err != nil {
+					t.Fatalf("%s failed: %v", cmd, err)
+				}
+			}
+			msg := sess.currentMessage
+
+			conn.in = strings.NewReader(tt.message)
+			if err := sess.processCommand(ctx, "DATA"); err != nil {
+				t.Fatalf("DATA failed: %v", err)
+			}
+			if resp := conn.lastResponse(); resp != tt.wantResp {
+				t.Errorf("DATA response: want %q, got %q", tt.wantResp, resp)
+			}
+
+			// The message never reaches the queue
+			path := queue.GetMessagePath(queue.NewSpool(cfg), msg, queue.MessageStateIncoming)
+			if _, err := os.Stat(path); !os.IsNotExist(err) {
+				t.Errorf("stored message should be removed, stat error: %v", err)
+			}
+		})
+	}
+}
+
+// storeSubmittedMessage sends message through DATA as an authenticated
+// submission user and returns the spooled copy
+func storeSubmittedMessage(t *testing.T, cfg *config.Config, message string) string {
+	t.Helper()
+
+	cfg.Relay.Enabled = true
+	cfg.Server.SpoolDir = t.TempDir()
+	if err := queue.InitializeSpoolDirectories(cfg.Server.SpoolDir); err != nil {
+		t.Fatalf("Failed to initialize spool: %v", err)
+	}
+	q, err := queue.NewQueue(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("NewQueue failed: %v", err)
+	}
+
+	sess, conn := newTestTCPSession(t, cfg)
+	sess.queue = q
+	sess.connCtx.Mode = config.ListenerModePlain
+	authenticator := &acceptingAuthenticator{}
+	sess.authenticator = authenticator
+	sess.senderValidator = NewSubmissionValidator(authenticator, cfg)
+	ctx := context.Background()
+
+	for _, cmd := range []string{"AUTH PLAIN " + auth.EncodeBase64("\x00alice\x00secret"), "MAIL FROM:<alice@example.org>", "RCPT TO:<postmaster@localhost>"} {
+		if err := sess.processCommand(ctx, cmd); err != nil {
+			t.Fatalf("%s failed: %v", cmd, err)
+		}
+	}
+	conn.in = strings.NewReader(message)
+	if err := sess.processCommand(ctx, "DATA"); err != nil {
+		t.Fatalf("DATA failed: %v", err)
+	}
+	if resp := conn.lastResponse(); !strings.HasPrefix(resp, "250") {
+		t.Fatalf("DATA: want 250, got %q", resp)
+	}
+
+	stored, err := filepath.Glob(filepath.Join(cfg.Server.SpoolDir, string(queue.MessageStateIncoming), "*.eml"))
+	if err != nil || len(stored) != 1 {
+		t.Fatalf("Expected one stored message, got %v (err %v)", stored, err)
+	}
+	content, err := os.ReadFile(stored[0])
+	if err != nil {
+		t.Fatalf("Failed to read stored message: %v", err)
+	}
+	return string(content)
+}
+
+func TestTCPSession_HeadersOnly(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   config.HeadersOnly
+		message  string
+		wantCode string
+	}{
+		{"accepted by default", config.HeadersOnlyAccept, "Subject: hello\r\n.\r\n", "250"},
+		{"rejected without body", config.HeadersOnlyReject, "Subject: hello\r\n.\r\n", "554"},
+		{"rejected with empty body", config.HeadersOnlyReject, "Subject: hello\r\n\r\n.\r\n", "554"},
+		{"body accepted when rejecting", config.HeadersOnlyReject, "Subject: hello\r\n\r\nbody\r\n.\r\n", "250"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.Relay.Enabled = true
+			cfg.Server.HeadersOnly = tt.policy
+			cfg.Server.SpoolDir = t.TempDir()
+			if err := queue.InitializeSpoolDirectories(cfg.Server.SpoolDir); err != nil {
+				t.Fatalf("Failed to initialize spool: %v", err)
+			}
+			q, err := queue.NewQueue(context.Background(), cfg)
+			if err != nil {
+				t.Fatalf("NewQueue failed: %v", err)
+			}
+
+			sess, conn := newTestTCPSession(t, cfg)
+			sess.queue = q
+			ctx := context.Background()
+			for _, cmd := range []string{"MAIL FROM:<sender@example.org>", "RCPT TO:<root@localhost>"} {
+				if err := sess.processCommand(ctx, cmd); err != nil {
+					t.Fatalf("%s failed: %v", cmd, err)
+				}
+			}
+			conn.in = strings.NewReader(tt.message)
+			if err := sess.processCommand(ctx, "DATA"); err != nil {
+				t.Fatalf("DATA failed: %v", err)
+			}
+			if resp := conn.lastResponse(); !strings.HasPrefix(resp, tt.wantCode) {
+				t.Fatalf("DATA: want %s, got %q", tt.wantCode, resp)
+			}
+
+			stored, _ := filepath.Glob(filepath.Join(cfg.Server.SpoolDir, string(queue.MessageStateIncoming), "*.eml"))
+			if wantStored := tt.wantCode == "250"; (len(stored) == 1) != wantStored {
+				t.Errorf("stored messages = %v, want stored: %v", stored, wantStored)
+			}
+		})
+	}
+}
+
+func TestTCPSession_AddMessageID(t *testing.T) {
+	t.Run("missing Message-ID is added", func(t *testing.T) {
+		cfg := config.DefaultConfig()
+		cfg.Server.PublicHostname = "mx.example.com"
+		content := storeSubmittedMessage(t, cfg, "Subject: hello\r\n\r\nbody\r\n.\r\n")
+
+		header, body, _ := strings.Cut(content, "\r\n\r\n")
+		if !regexp.MustCompile(`(?m)^Message-ID: <[0-9a-f-]{36}@mx\.example\.com>\r$`).MatchString(header) {
+			t.Errorf("Expected a generated Message-ID in headers:\n%s", header)
+		}
+		if body != "body\r\n" {
+			t.Errorf("Body must not be altered: %q", body)
+		}
+	})
+
+	t.Run("existing Message-ID is kept", func(t *testing.T) {
+		cfg := config.DefaultConfig()
+		content := storeSubmittedMessage(t, cfg, "Subject: hello\r\nmessage-id: <original@client.example>\r\n\r\nbody\r\n.\r\n")
+
+		header, _, _ := strings.Cut(content, "\r\n\r\n")
+		if n := strings.Count(strings.ToLower(header), "\nmessage-id:"); n != 1 {
+			t.Errorf("Expected exactly one Message-ID, got %d:\n%s", n, header)
+		}
+		if !strings.Contains(header, "message-id: <original@client.example>") {
+			t.Errorf("Original Message-ID should be untouched:\n%s", header)
+		}
+	})
+}
+
+func TestTCPSession_DateHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		check   func(t *testing.T, header string)
+	}{
+		{
+			name:    "missing Date is added",
+			message: "Subject: hello\r\n\r\nbody\r\n.\r\n",
+			check: func(t *testing.T, header string) {
+				if !regexp.MustCompile(`(?m)^Date: \w{3}, \d{2} \w{3} \d{4} \d{2}:\d{2}:\d{2} [+-]\d{4}\r?$`).MatchString(header) {
+					t.Errorf("Expected an RFC 5322 Date:\n%s", header)
+				}
+			},
+		},
+		{
+			name:    "valid Date is not duplicated",
+			message: "Date: Fri, 1 Mar 2024 08:00:00 +0100\r\nSubject: hello\r\n\r\nbody\r\n.\r\n",
+			check: func(t *testing.T, header string) {
+				if n := strings.Count(header, "Date:"); n != 1 || !strings.Contains(header, "Date: Fri, 1 Mar 2024 08:00:00 +0100") {
+					t.Errorf("Expected the original Date once, got %d:\n%s", n, header)
+				}
+			},
+		},
+		{
+			name:    "malformed Date is normalized",
+			message: "Date: Fri Mar  1 08:00:00 2024\r\nSubject: hello\r\n\r\nbody\r\n.\r\n",
+			check: func(t *testing.T, header string) {
+				if n := strings.Count(header, "Date:"); n != 1 || !strings.Contains(header, "Date: Fri, 01 Mar 2024 08:00:00 +0000") {
+					t.Errorf("Expected one normalized Date, got %d:\n%s", n, header)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := storeSubmittedMessage(t, config.DefaultConfig(), tt.message)
+			header, body, _ := strings.Cut(content, "\r\n\r\n")
+			tt.check(t, header)
+			if body != "body\r\n" {
+				t.Errorf("Body must not be altered: %q", body)
+			}
+		})
+	}
+}
+
+func TestTCPSession_RelayedHeadersUntouched(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Relay.Enabled = true
+	cfg.Server.SpoolDir = t.TempDir()
+	if err := queue.InitializeSpoolDirectories(cfg.Server.SpoolDir); err != nil {
+		t.Fatalf("Failed to initialize spool: %v", err)
+	}
+	q, err := queue.NewQueue(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("NewQueue failed: %v", err)
+	}
+
+	sess, conn := newTestTCPSession(t, cfg)
+	sess.queue = q
+	ctx := context.Background()
+	for _, cmd := range []string{"MAIL FROM:<sender@example.org>", "RCPT TO:<root@localhost>"} {
+		if err := sess.processCommand(ctx, cmd); err != nil {
+			t.Fatalf("%s failed: %v", cmd, err)
+		}
+	}
+	original := "Date: Fri Mar  1 08:00:00 2024\r\nSubject: hello\r\n\r\nbody\r\n"
+	conn.in = strings.NewReader(original + ".\r\n")
+	if err := sess.processCommand(ctx, "DATA"); err != nil {
+		t.Fatalf("DATA failed: %v", err)
+	}
+	if resp := conn.lastResponse(); !strings.HasPrefix(resp, "250") {
+		t.Fatalf("DATA: want 250, got %q", resp)
+	}
+
+	stored, err := filepath.Glob(filepath.Join(cfg.Server.SpoolDir, string(queue.MessageStateIncoming), "*.eml"))
+	if err != nil || len(stored) != 1 {
+		t.Fatalf("Expected one stored message, got %v (err %v)", stored, err)
+	}
+	content, err := os.ReadFile(stored[0])
+	if err != nil {
+		t.Fatalf("Failed to read stored message: %v", err)
+	}
+	if !strings.HasSuffix(string(content), original) {
+		t.Errorf("Relayed message must be stored as received after our trace headers:\n%s", content)
+	}
+	if strings.Contains(string(content), "\nMessage-ID:") {
+		t.Errorf("No Message-ID should be added to relayed mail:\n%s", content)
+	}
+}
+
+func TestSocketSession_CompletesHeaders(t *testing.T) {
+	currentUser, err := user.Current()
+	if err != nil {
+		t.Skipf("Cannot get current user for test: %v", err)
+	}
+	sender := currentUser.Username + "@localhost"
+
+	tests := []struct {
+		name     string
+		message  string
+		wantBody string
+	}{
+		{"message with headers", "Subject: hello\r\nDate: Fri, 1 Mar 2024 08:00:00 +0100\r\n\r\nbody\r\n.\r\n", "body\r\n"},
+		{"headerless message", "just a body\r\n.\r\n", "just a body\r\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.Server.SpoolDir = t.TempDir()
+			if err := queue.InitializeSpoolDirectories(cfg.Server.SpoolDir); err != nil {
+				t.Fatalf("Failed to initialize spool: %v", err)
+			}
+			q, err := queue.NewQueue(context.Background(), cfg)
+			if err != nil {
+				t.Fatalf("NewQueue failed: %v", err)
+			}
+
+			sess, conn := newTestSocketSession(t, cfg)
+			sess.queue = q
+			ctx := context.Background()
+			for _, cmd := range []string{"MAIL FROM:<" + sender + ">", "RCPT TO:<" + sender + ">"} {
+				if err := sess.processCommand(ctx, cmd); err != nil {
+					t.Fatalf("%s failed: %v", cmd, err)
+				}
+			}
+			conn.in = strings.NewReader(tt.message)
+			if err := sess.processCommand(ctx, "DATA"); err != nil {
+				t.Fatalf("DATA failed: %v", err)
+			}
+			if resp := conn.lastResponse(); !strings.HasPrefix(resp, "250") {
+				t.Fatalf("DATA: want 250, got %q", resp)
+			}
+
+			stored, err := filepath.Glob(filepath.Join(cfg.Server.SpoolDir, string(queue.MessageStateIncoming), "*.eml"))
+			if err != nil || len(stored) != 1 {
+				t.Fatalf("Expected one stored message, got %v (err %v)", stored, err)
+			}
+			content, err := os.ReadFile(stored[0])
+			if err != nil {
+				t.Fatalf("Failed to read stored message: %v", err)
+			}
+
+			parsed, err := mail.ReadMessage(bytes.NewReader(content))
+			if err != nil {
+				t.Fatalf("Stored message is not parseable: %v\n%s", err, content)
+			}
+			for _, name := range []string{"Received", "From", "To", "Date", "Message-ID"} {
+				if len(parsed.Header[textproto.CanonicalMIMEHeaderKey(name)]) != 1 {
+					t.Errorf("Expected one %s header\n%s", name, content)
+				}
+			}
+			body, _ := io.ReadAll(parsed.Body)
+			if string(body) != tt.wantBody {
+				t.Errorf("Body = %q, want %q", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+// scriptedConn serves in to reads and accepts writesLeft writes, then fails
+// every further write with writeErr
+type scriptedConn struct {
+	in         *strings.Reader
+	writesLeft int
+	writeErr   error
+}
+
+func (c *scriptedConn) Read(p []byte) (int, error) { return c.in.Read(p) }
+func (c *scriptedConn) Write(p []byte) (int, error) {
+	if c.writesLeft == 0 {
+		return 0, c.writeErr
+	}
+	c.writesLeft--
+	return len(p), nil
+}
+func (c *scriptedConn) Close() error { return nil }
+
+// captureLogs points the session logger at a debug-level buffer
+func captureLogs(sess *Session) *bytes.Buffer {
+	var buf bytes.Buffer
+	sess.logger = slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	return &buf
+}
+
+func TestWriteResponse_ClosedPipeIsDisconnect(t *testing.T) {
+	sess, _ := newTestTCPSession(t, config.DefaultConfig())
+	client, server := net.Pipe()
+	client.Close()
+	defer server.Close()
+	sess.textproto = textproto.NewConn(server)
+	logs := captureLogs(sess)
+
+	err := sess.writeResponse(Response(StatusClosing, ""))
+	if err == nil {
+		t.Fatal("expected write to a closed pipe to fail")
+	}
+	if !isClientDisconnect(err) {
+		t.Errorf("expected closed pipe to count as a client disconnect, got %v", err)
+	}
+	if strings.Contains(logs.String(), "level=ERROR") {
+		t.Errorf("client disconnect logged as error:\n%s", logs)
+	}
+	if !strings.Contains(logs.String(), "Client disconnected before response was sent") {
+		t.Errorf("expected debug disconnect log, got:\n%s", logs)
+	}
+}
+
+func TestWriteResponse_GenuineFailureLoggedAsError(t *testing.T) {
+	sess, _ := newTestTCPSession(t, config.DefaultConfig())
+	sess.textproto = textproto.NewConn(&scriptedConn{in: strings.NewReader(""), writeErr: errors.New("device error")})
+	logs := captureLogs(sess)
+
+	if err := sess.writeResponse(Response(StatusOK, "OK")); err == nil {
+		t.Fatal("expected write failure")
+	}
+	if !strings.Contains(logs.String(), "level=ERROR") {
+		t.Errorf("expected genuine write failure logged as error, got:\n%s", logs)
+	}
+}
+
+func TestTCPSessionHandler_BrokenPipeAfterCommand(t *testing.T) {
+	for _, writeErr := range []error{syscall.EPIPE, syscall.ECONNRESET, net.ErrClosed} {
+		t.Run(writeErr.Error(), func(t *testing.T) {
+			sess, _ := newTestTCPSession(t, config.DefaultConfig())
+			// The greeting goes out, then the client hangs up before the QUIT reply
+			sess.textproto = textproto.NewConn(&scriptedConn{in: strings.NewReader("QUIT\r\n"), writesLeft: 1, writeErr: writeErr})
+			sess.state = StateConnected
+			logs := captureLogs(sess)
+
+			err := tcpSessionHandler(context.Background(), sess)
+			if !errors.Is(err, writeErr) {
+				t.Errorf("expected %v from session loop, got %v", writeErr, err)
+			}
+			if strings.Contains(logs.String(), "level=ERROR") {
+				t.Errorf("client disconnect logged as error:\n%s", logs)
+			}
+		})
+	}
+}
+
+func TestSession_MaxTransactionsPerConnection(t *testing.T) {
+	const limit = 2
+
+	cfg := config.DefaultConfig()
+	cfg.Relay.Enabled = true
+	cfg.Server.MaxTransactionsPerConnection = limit
+	cfg.Server.SpoolDir = t.TempDir()
+	if err := queue.InitializeSpoolDirectories(cfg.Server.SpoolDir); err != nil {
+		t.Fatalf("Failed to initialize spool: %v", err)
+	}
+	q, err := queue.NewQueue(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("NewQueue failed: %v", err)
+	}
+
+	sess, conn := newTestTCPSession(t, cfg)
+	sess.queue = q
+	sess.connCtx.Mode = config.ListenerModePlain
+	authenticator := &acceptingAuthenticator{}
+	sess.authenticator = authenticator
+	sess.senderValidator = NewSubmissionValidator(authenticator, cfg)
+	ctx := context.Background()
+
+	if err := sess.processCommand(ctx, "AUTH PLAIN "+auth.EncodeBase64("\x00alice\x00secret")); err != nil {
+		t.Fatalf("AUTH failed: %v", err)
+	}
+
+	for i := 0; i < limit; i++ {
+		for _, cmd := range []string{"MAIL FROM:<alice@example.org>", "RCPT TO:<postmaster@localhost>"} {
+			if err := sess.processCommand(ctx, cmd); err != nil {
+				t.Fatalf("%s failed: %v", cmd, err)
+			}
+			if resp := conn.lastResponse(); !strings.HasPrefix(resp, "250") {
+				t.Fatalf("message %d: %s: want 250, got %q", i+1, cmd, resp)
+			}
+		}
+		conn.in = strings.NewReader("Subject: hello\r\n\r\nbody\r\n.\r\n")
+		if err := sess.processCommand(ctx, "DATA"); err != nil {
+			t.Fatalf("DATA failed: %v", err)
+		}
+		if resp := conn.lastResponse(); !strings.HasPrefix(resp, "250") {
+			t.Fatalf("message %d: DATA: want 250, got %q", i+1, resp)
+		}
+	}
+
+	if err := sess.processCommand(ctx, "MAIL FROM:<alice@example.org>"); err != nil {
+		t.Fatalf("MAIL FROM failed: %v", err)
+	}
+	if resp := conn.lastResponse(); !strings.HasPrefix(resp, "421") {
+		t.Errorf("MAIL FROM over the limit: want 421, got %q", resp)
+	}
+	if sess.state != StateClosed {
+		t.Errorf("state = %v, want StateClosed", sess.state)
+	}
+	if sess.currentMessage != nil {
+		t.Error("transaction started despite the limit")
+	}
+}
+
+func TestLMTPSession_LHLORequired(t *testing.T) {
+	sess, conn := newTestLMTPSession(t, config.DefaultConfig())
+	ctx := context.Background()
+
+	if err := sess.sendGreeting(); err != nil {
+		t.Fatalf("greeting failed: %v", err)
+	}
+	if resp := conn.lastResponse(); !strings.Contains(resp, "LMTP") {
+		t.Errorf("greeting: want LMTP banner, got %q", resp)
+	}
+
+	tests := []struct {
+		cmd      string
+		wantCode string
+	}{
+		{"MAIL FROM:<sender@example.org>", "503"},
+		{"HELO client.example.org", "500"},
+		{"EHLO client.example.org", "500"},
+		{"LHLO client.example.org", "250"},
+		{"MAIL FROM:<sender@example.org>", "250"},
+	}
+	for _, tt := range tests {
+		if err := sess.processCommand(ctx, tt.cmd); err != nil {
+			t.Fatalf("%s failed: %v", tt.cmd, err)
+		}
+		if resp := conn.lastResponse(); !strings.HasPrefix(resp, tt.wantCode) {
+			t.Errorf("%s: want %s, got %q", tt.cmd, tt.wantCode, resp)
+		}
+	}
+}
+
+func TestTCPSession_LHLOUnknown(t *testing.T) {
+	sess, conn := newTestTCPSession(t, config.DefaultConfig())
+
+	if err := sess.processCommand(context.Background(), "LHLO client.example.org"); err != nil {
+		t.Fatalf("LHLO failed: %v", err)
+	}
+	if resp := conn.lastResponse(); !strings.HasPrefix(resp, "500") {
+		t.Errorf("LHLO on SMTP session: want 500, got %q", resp)
+	}
+}
+
+func TestLMTPSession_DataRepliesPerRecipient(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Relay.Enabled = true
+	cfg.Server.SpoolDir = t.TempDir()
+	if err := queue.InitializeSpoolDirectories(cfg.Server.SpoolDir); err != nil {
+		t.Fatalf("Failed to initialize spool: %v", err)
+	}
+	q, err := queue.NewQueue(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("NewQueue failed: %v", err)
+	}
+
+	sess, conn := newTestLMTPSession(t, cfg)
+	sess.queue = q
+	ctx := context.Background()
+
+	recipients := []string{"root@localhost", "postmaster@localhost"}
+	cmds := []string{"LHLO client.example.org", "MAIL FROM:<sender@example.org>"}
+	for _, rcpt := range recipients {
+		cmds = append(cmds, "RCPT TO:<"+rcpt+">")
+	}
+	for _, cmd := range cmds {
+		if err := sess.processCommand(ctx, cmd); err != nil {
+			t.Fatalf("%s failed: %v", cmd, err)
+		}
+		if resp := conn.lastResponse(); !strings.HasPrefix(resp, "250") {
+			t.Fatalf("%s: want 250, got %q", cmd, resp)
+		}
+	}
+
+	conn.out.Reset()
+	conn.in = strings.NewReader("Subject: hello\r\n\r\nbody\r\n.\r\n")
+	if err := sess.processCommand(ctx, "DATA"); err != nil {
+		t.Fatalf("DATA failed: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimRight(conn.out.String(), "\r\n"), "\r\n")
+	if len(lines) != 1+len(recipients) || !strings.HasPrefix(lines[0], "354") {
+		t.Fatalf("want 354 and %d replies, got %q", len(recipients), lines)
+	}
+	for i, line := range lines[1:] {
+		if !strings.HasPrefix(line, "250") {
+			t.Errorf("reply %d: want 250, got %q", i+1, line)
+		}
+	}
+}
+
+func TestSession_DNSBLListedClientActions(t *testing.T) {
+	tests := []struct {
+		action     string
+		wantStored bool
+		wantHeader bool
+	}{
+		{"log", true, false},
+		{"tag", true, true},
+		{"discard", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.action, func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.Relay.Enabled = true
+			cfg.Security.DNSBL.Action = tt.action
+			cfg.Server.SpoolDir = t.TempDir()
+			if err := queue.InitializeSpoolDirectories(cfg.Server.SpoolDir); err != nil {
+				t.Fatalf("Failed to initialize spool: %v", err)
+			}
+			q, err := queue.NewQueue(context.Background(), cfg)
+			if err != nil {
+				t.Fatalf("NewQueue failed: %v", err)
+			}
+
+			sess, conn := newTestTCPSession(t, cfg)
+			sess.queue = q
+			sess.connCtx.DNSBL = []string{"dnsbl.example.org"}
+			sess.headerGenerator = newTCPHeaderGenerator(cfg, security.NewSpamScorer(&cfg.Security))
+			ctx := context.Background()
+
+			for _, cmd := range []string{"MAIL FROM:<sender@example.org>", "RCPT TO:<root@localhost>"} {
+				if err := sess.processCommand(ctx, cmd); err != nil {
+					t.Fatalf("%s failed: %v", cmd, err)
+				}
+			}
+			conn.in = strings.NewReader("Subject: hello\r\n\r\nbody\r\n.\r\n")
+			if err := sess.processCommand(ctx, "DATA"); err != nil {
+				t.Fatalf("DATA failed: %v", err)
+			}
+			if resp := conn.lastResponse(); !strings.HasPrefix(resp, "250") {
+				t.Fatalf("DATA: want 250, got %q", resp)
+			}
+
+			stored, _ := filepath.Glob(filepath.Join(cfg.Server.SpoolDir, string(queue.MessageStateIncoming), "*.eml"))
+			if (len(stored) == 1) != tt.wantStored {
+				t.Fatalf("stored messages = %v, want stored %v", stored, tt.wantStored)
+			}
+			if !tt.wantStored {
+				return
+			}
+			content, err := os.ReadFile(stored[0])
+			if err != nil {
+				t.Fatalf("Failed to read stored message: %v", err)
+			}
+			if got := strings.Contains(string(content), "X-DNSBL: dnsbl.example.org\r\n"); got != tt.wantHeader {
+				t.Errorf("X-DNSBL header present = %v, want %v", got, tt.wantHeader)
+			}
+		})
+	}
+}
+
+func TestTCPHeaderGenerator_SpamScore(t *testing.T) {
+	tests := []struct {
+		name       string
+		reverseDNS string
+		dnsbl      []string
+		wantScore  string
+		wantReport string
+	}{
+		{"clean client", "mail.example.org", nil, "0.0", "none"},
+		{"listed client IP", "mail.example.org", []string{"zen.example.net"}, "2.0", "DNSBL_zen.example.net=2.0"},
+		{"listed without rdns", "", []string{"zen.example.net"}, "3.0", "DNSBL_zen.example.net=2.0, NO_RDNS=1.0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.Security.SpamScore.Enabled = true
+
+			sess, _ := newTestTCPSession(t, cfg)
+			sess.headerGenerator = newTCPHeaderGenerator(cfg, security.NewSpamScorer(&cfg.Security))
+			sess.connCtx.ReverseDNS = tt.reverseDNS
+			sess.connCtx.DNSBL = tt.dnsbl
+			if err := sess.processCommand(context.Background(), "MAIL FROM:<sender@example.org>"); err != nil {
+				t.Fatalf("MAIL FROM failed: %v", err)
+			}
+
+			headers := sess.headerGenerator.GenerateHeaders(sess.currentMessage, sess.connCtx)
+			if want := "X-GolubSMTPd-Score: " + tt.wantScore + "\r\n"; !strings.Contains(headers, want) {
+				t.Errorf("headers %q lack %q", headers, want)
+			}
+			if want := "X-GolubSMTPd-Report: " + tt.wantReport + "\r\n"; !strings.Contains(headers, want) {
+				t.Errorf("headers %q lack %q", headers, want)
+			}
+		})
+	}
+}
+
+func TestTCPHeaderGenerator_SpamScoreSkipsAllowlisted(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Security.SpamScore.Enabled = true
+	cfg.Security.ReverseDNS.Enabled = true
+
+	sess, _ := newTestTCPSession(t, cfg)
+	sess.headerGenerator = newTCPHeaderGenerator(cfg, security.NewSpamScorer(&cfg.Security))
+	sess.connCtx.Allowlisted = true
+	if err := sess.processCommand(context.Background(), "MAIL FROM:<sender@example.org>"); err != nil {
+		t.Fatalf("MAIL FROM failed: %v", err)
+	}
+
+	headers := sess.headerGenerator.GenerateHeaders(sess.currentMessage, sess.connCtx)
+	if strings.Contains(headers, "X-GolubSMTPd-Score:") {
+		t.Errorf("allowlisted client was never checked and must not be scored: %q", headers)
+	}
+}
+
+func TestTCPSession_StripsSpoofedScoreHeaders(t *testing.T) {
+	for _, allowlisted := range []bool{false, true} {
+		t.Run(fmt.Sprintf("allowlisted=%v", allowlisted), func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.Relay.Enabled = true
+			cfg.Security.SpamScore.Enabled = true
+			cfg.Server.SpoolDir = t.TempDir()
+			if err := queue.InitializeSpoolDirectories(cfg.Server.SpoolDir); err != nil {
+				t.Fatalf("Failed to initialize spool: %v", err)
+			}
+			q, err := queue.NewQueue(context.Background(), cfg)
+			if err != nil {
+				t.Fatalf("NewQueue failed: %v", err)
+			}
+
+			sess, conn := newTestTCPSession(t, cfg)
+			sess.queue = q
+			sess.headerGenerator = newTCPHeaderGenerator(cfg, security.NewSpamScorer(&cfg.Security))
+			sess.connCtx.ReverseDNS = "mail.example.org"
+			sess.connCtx.Allowlisted = allowlisted
+			ctx := context.Background()
+			for _, cmd := range []string{"MAIL FROM:<sender@example.org>", "RCPT TO:<root@localhost>"} {
+				if err := sess.processCommand(ctx, cmd); err != nil {
+					t.Fatalf("%s failed: %v", cmd, err)
+				}
+			}
+			conn.in = strings.NewReader("X-GolubSMTPd-Score: -100\r\nx-golubsmtpd-report: WHITELISTED\r\nSubject: hi\r\n\r\nbody\r\n.\r\n")
+			if err := sess.processCommand(ctx, "DATA"); err != nil {
+				t.Fatalf("DATA failed: %v", err)
+			}
+			if resp := conn.lastResponse(); !strings.HasPrefix(resp, "250") {
+				t.Fatalf("DATA: want 250, got %q", resp)
+			}
+
+			stored, _ := filepath.Glob(filepath.Join(cfg.Server.SpoolDir, string(queue.MessageStateIncoming), "*.eml"))
+			if len(stored) != 1 {
+				t.Fatalf("Expected one stored message, got %v", stored)
+			}
+			content, err := os.ReadFile(stored[0])
+			if err != nil {
+				t.Fatalf("Failed to read stored message: %v", err)
+			}
+			lower := strings.ToLower(string(content))
+			if strings.Contains(lower, "-100") || strings.Contains(lower, "whitelisted") {
+				t.Errorf("client-supplied score headers must be removed:\n%s", content)
+			}
+			wantOwn := 1
+			if allowlisted {
+				wantOwn = 0
+			}
+			if n := strings.Count(lower, "x-golubsmtpd-score:"); n != wantOwn {
+				t.Errorf("score headers = %d, want %d:\n%s", n, wantOwn, content)
+			}
+		})
+	}
+}
+
+func TestSocketSession_StripsSpoofedScoreHeaders(t *testing.T) {
+	currentUser, err := user.Current()
+	if err != nil {
+		t.Skipf("Cannot get current user for test: %v", err)
+	}
+	sender := currentUser.Username + "@localhost"
+
+	cfg := config.DefaultConfig()
+	cfg.Security.SpamScore.Enabled = true
+	cfg.Server.SpoolDir = t.TempDir()
+	if err := queue.InitializeSpoolDirectories(cfg.Server.SpoolDir); err != nil {
+		t.Fatalf("Failed to initialize spool: %v", err)
+	}
+	q, err := queue.NewQueue(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("NewQueue failed: %v", err)
+	}
+
+	sess, conn := newTestSocketSession(t, cfg)
+	sess.queue = q
+	ctx := context.Background()
+	for _, cmd := range []string{"MAIL FROM:<" + sender + ">", "RCPT TO:<" + sender + ">"} {
+		if err := sess.processCommand(ctx, cmd); err != nil {
+			t.Fatalf("%s failed: %v", cmd, err)
+		}
+	}
+	conn.in = strings.NewReader("X-GolubSMTPd-Score: -100\r\nX-GolubSMTPd-Report: WHITELISTED\r\nSubject: hi\r\n\r\nbody\r\n.\r\n")
+	if err := sess.processCommand(ctx, "DATA"); err != nil {
+		t.Fatalf("DATA failed: %v", err)
+	}
+	if resp := conn.lastResponse(); !strings.HasPrefix(resp, "250") {
+		t.Fatalf("DATA: want 250, got %q", resp)
+	}
+
+	stored, _ := filepath.Glob(filepath.Join(cfg.Server.SpoolDir, string(queue.MessageStateIncoming), "*.eml"))
+	if len(stored) != 1 {
+		t.Fatalf("Expected one stored message, got %v", stored)
+	}
+	content, err := os.ReadFile(stored[0])
+	if err != nil {
+		t.Fatalf("Failed to read stored message: %v", err)
+	}
+	if lower := strings.ToLower(string(content)); strings.Contains(lower, "x-golubsmtpd-score:") || strings.Contains(lower, "x-golubsmtpd-report:") {
+		t.Errorf("socket messages are not scored, so every score header is forged:\n%s", content)
+	}
+}
+
+func TestSession_DataWithoutRecipients(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Relay.Enabled = true
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		commands []string
+		want     string
+	}{
+		{
+			name:     "no RCPT sent",
+			commands: []string{"MAIL FROM:<root@localhost>"},
+			want:     "RCPT TO required before DATA",
+		},
+		{
+			name:     "all RCPTs rejected",
+			commands: []string{"MAIL FROM:<root@localhost>", "RCPT TO:<user@example.net>", "RCPT TO:<not-an-address>"},
+			want:     "No valid recipients - all were rejected",
+		},
+		{
+			name:     "rejected RCPTs forgotten by RSET",
+			commands: []string{"MAIL FROM:<root@localhost>", "RCPT TO:<user@example.net>", "RSET", "MAIL FROM:<root@localhost>"},
+			want:     "RCPT TO required before DATA",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sess, conn := newTestTCPSession(t, cfg)
+			for _, cmd := range tt.commands {
+				if err := sess.processCommand(ctx, cmd); err != nil {
+					t.Fatalf("%s failed: %v", cmd, err)
+				}
+				if strings.HasPrefix(cmd, "RCPT") && strings.HasPrefix(conn.lastResponse(), "250") {
+					t.Fatalf("%s: want rejection, got %q", cmd, conn.lastResponse())
+				}
+			}
+			if err := sess.processCommand(ctx, "DATA"); err != nil {
+				t.Fatalf("DATA failed: %v", err)
+			}
+			resp := conn.lastResponse()
+			if !strings.HasPrefix(resp, "503") || !strings.Contains(resp, tt.want) {
+				t.Errorf("DATA: want 503 %q, got %q", tt.want, resp)
+			}
+		})
+	}
+}
+
+func TestSession_MaxLineLength(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Server.MaxLineLength = 64
+	cfg.Server.DisconnectOnUnknown = 2
+	sess, conn := newTestTCPSession(t, cfg)
+	conn.in = strings.NewReader("NOOP " + strings.Repeat("x", 10000) + "\r\n" +
+		"HELO bad\x01name\r\n" +
+		"NOOP " + strings.Repeat("y", 59) + "\r\n" +
+		"NOOP " + strings.Repeat("z", 60) + "\r\n")
+
+	if err := tcpSessionHandler(context.Background(), sess); err != nil {
+		t.Fatalf("session failed: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimRight(conn.out.String(), "\r\n"), "\r\n")
+	want := []string{"220", "500 Line too long", "501 Invalid hostname", "250", "421"}
+	if len(lines) != len(want) {
+		t.Fatalf("responses = %q, want %d lines", lines, len(want))
+	}
+	for i, prefix := range want {
+		if !strings.HasPrefix(lines[i], prefix) {
+			t.Errorf("response %d = %q, want prefix %q", i, lines[i], prefix)
+		}
+	}
+	if sess.state != StateClosed {
+		t.Error("Expected the second over-length line to close the session via disconnect_on_unknown")
+	}
+}
+
+func TestTCPSession_MailRequiresHelo(t *testing.T) {
+	for _, role := range []string{"relay", "submission"} {
+		t.Run(role, func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			sess, conn := newTestTCPSession(t, cfg)
+			if role == "submission" {
+				sess.senderValidator = NewSubmissionValidator(&acceptingAuthenticator{}, cfg)
+			}
+			sess.state = StateConnected
+
+			if err := sess.processCommand(context.Background(), "MAIL FROM:<root@localhost>"); err != nil {
+				t.Fatalf("MAIL FROM failed: %v", err)
+			}
+			if resp := conn.lastResponse(); !strings.HasPrefix(resp, "503") || !strings.Contains(resp, "EHLO/HELO required") {
+				t.Errorf("MAIL before HELO: want 503 EHLO/HELO required, got %q", resp)
+			}
+			if sess.currentMessage != nil {
+				t.Error("MAIL before HELO must not start a transaction")
+			}
+		})
+	}
+}
+
+func TestSocketSession_SocketHelo(t *testing.T) {
+	currentUser, err := user.Current()
+	if err != nil {
+		t.Skipf("Cannot get current user for test: %v", err)
+	}
+	mailFrom := "MAIL FROM:<" + currentUser.Username + "@localhost>\r\n"
+
+	tests := []struct {
+		name      string
+		mode      config.SocketHelo
+		script    string
+		want      []string
+		wantHello string
+	}{
+		{
+			name:   "skip",
+			mode:   config.SocketHeloSkip,
+			script: mailFrom + "RSET\r\n" + mailFrom + "QUIT\r\n",
+			want:   []string{"250", "250", "250", "221"},
+		},
+		{
+			name:      "require",
+			mode:      config.SocketHeloRequire,
+			script:    mailFrom + "HELO client.example.org\r\n" + mailFrom + "QUIT\r\n",
+			want:      []string{"503 EHLO/HELO required before MAIL", "250", "250", "221"},
+			wantHello: "client.example.org",
+		},
+		{
+			name:      "synthesize",
+			mode:      config.SocketHeloSynthesize,
+			script:    mailFrom + "QUIT\r\n",
+			want:      []string{"250", "221"},
+			wantHello: "localhost",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.Server.SocketHelo = tt.mode
+			sess, conn := newTestSocketSession(t, cfg)
+			conn.in = strings.NewReader(tt.script)
+
+			if err := socketSessionHandler(context.Background(), sess); err != nil {
+				t.Fatalf("session failed: %v", err)
+			}
+
+			lines := strings.Split(strings.TrimRight(conn.out.String(), "\r\n"), "\r\n")
+			if len(lines) != len(tt.want) {
+				t.Fatalf("responses = %q, want %d lines", lines, len(tt.want))
+			}
+			for i, prefix := range tt.want {
+				if !strings.HasPrefix(lines[i], prefix) {
+					t.Errorf("response %d = %q, want prefix %q", i, lines[i], prefix)
+				}
+			}
+			if sess.clientHelloHostname != tt.wantHello {
+				t.Errorf("HELO name = %q, want %q", sess.clientHelloHostname, tt.wantHello)
+			}
+		})
+	}
+}
+
+func TestSocketClientInfo(t *testing.T) {
+	self := &SocketCredentials{UID: os.Getuid(), PID: os.Getpid()}
+	if got, want := socketClientInfo(self), fmt.Sprintf(" pid=%d uid=%d", os.Getpid(), os.Getuid()); !strings.HasPrefix(got, "comm=") || !strings.HasSuffix(got, want) {
+		t.Errorf("socketClientInfo(own process) = %q, want comm=<name>%s", got, want)
+	}
+	if got := socketClientInfo(&SocketCredentials{UID: 1000}); got != "uid=1000" {
+		t.Errorf("socketClientInfo(unknown process) = %q, want uid=1000", got)
+	}
+}
+
+func TestSocketHeaderGenerator_ReceivedFromHelo(t *testing.T) {
+	g := &SocketHeaderGenerator{}
+	headers := g.GenerateHeaders(&queue.Message{ID: "id", ClientHelloHostname: "client.example.org"}, ConnectionContext{Type: ConnectionTypeSocket})
+	if !strings.HasPrefix(headers, "Received: from client.example.org (unix socket) by localhost;") {
+		t.Errorf("Received header = %q, want the HELO name", headers)
+	}
+	g = &SocketHeaderGenerator{clientInfo: "comm=mutt pid=1234 uid=1000"}
+	headers = g.GenerateHeaders(&queue.Message{ID: "id", ClientHelloHostname: "localhost"}, ConnectionContext{Type: ConnectionTypeSocket})
+	if !strings.HasPrefix(headers, "Received: from localhost (unix socket comm=mutt pid=1234 uid=1000) by localhost;") {
+		t.Errorf("Received header = %q, want the client process in the comment", headers)
+	}
+	g = &SocketHeaderGenerator{}
+	headers = g.GenerateHeaders(&queue.Message{ID: "id"}, ConnectionContext{Type: ConnectionTypeSocket})
+	if !strings.HasPrefix(headers, "Received: from localhost (unix socket) by localhost;") {
+		t.Errorf("Received header = %q, want localhost without a HELO name", headers)
+	}
+}
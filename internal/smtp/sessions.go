@@ -2,9 +2,14 @@ package smtp
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"net/textproto"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/pawciobiel/golubsmtpd/internal/config"
 	"github.com/pawciobiel/golubsmtpd/internal/logging"
@@ -13,6 +18,7 @@ import (
 // tcpSessionHandler handles the standard TCP SMTP session flow
 func tcpSessionHandler(ctx context.Context, sess *Session) error {
 	defer sess.textproto.Close()
+	defer sess.releaseUserSession()
 
 	sess.logger.Info("Starting SMTP session", "client_ip", sess.clientIP)
 
@@ -29,16 +35,30 @@ func tcpSessionHandler(ctx context.Context, sess *Session) error {
 		default:
 		}
 
-		line, err := sess.textproto.ReadLine()
+		sess.setReadDeadline(sess.config.Server.CommandTimeout)
+		line, err := sess.readCommandLine()
+		if errors.Is(err, errLineTooLong) {
+			if err := sess.rejectLongLine(); err != nil {
+				return err
+			}
+			continue
+		}
 		if err != nil {
 			sess.logger.Debug("Error reading command", "error", err)
+			if isTimeoutError(err) {
+				sess.closeOnTimeout("command") //nolint:errcheck
+			}
 			return err
 		}
 
 		sess.logger.Debug("Received command", "command", line, "client_ip", sess.clientIP)
 
 		if err := sess.processCommand(ctx, line); err != nil {
-			sess.logger.Error("Error processing command", "error", err, "command", line)
+			if isClientDisconnect(err) {
+				sess.logger.Debug("Client disconnected", "error", err, "command", line, "client_ip", sess.clientIP)
+			} else {
+				sess.logger.Error("Error processing command", "error", err, "command", line)
+			}
 			return err
 		}
 	}
@@ -55,7 +75,24 @@ func NewTCPSession(
 	validator SessionValidator,
 	deps *Dependencies,
 ) SMTPHandler {
-	headerGenerator := &TCPHeaderGenerator{}
+	headerGenerator := newTCPHeaderGenerator(cfg, deps.SpamScorer)
+	dataHandler := &TCPDataHandler{}
+
+	return NewSession(cfg, rawConn, textproto, connCtx.ClientIP, deps,
+		headerGenerator, validator, dataHandler, tcpSessionHandler, connCtx)
+}
+
+// NewLMTPSession creates a TCP session speaking LMTP: the client must greet
+// with LHLO, and the end of DATA is answered once per accepted recipient
+func NewLMTPSession(
+	connCtx ConnectionContext,
+	cfg *config.Config,
+	rawConn net.Conn,
+	textproto *textproto.Conn,
+	validator SessionValidator,
+	deps *Dependencies,
+) SMTPHandler {
+	headerGenerator := newTCPHeaderGenerator(cfg, deps.SpamScorer)
 	dataHandler := &TCPDataHandler{}
 
 	return NewSession(cfg, rawConn, textproto, connCtx.ClientIP, deps,
@@ -68,8 +105,17 @@ func socketSessionHandler(ctx context.Context, sess *Session) error {
 
 	sess.logger.Debug("Starting socket SMTP session", "username", sess.username)
 
-	// Socket sessions skip greeting and go straight to SMTP commands
-	sess.state = StateGreeted
+	// Socket sessions get no banner; unless a HELO is required they go straight
+	// to SMTP commands
+	switch sess.config.Server.SocketHelo {
+	case config.SocketHeloRequire:
+		sess.state = StateConnected
+	case config.SocketHeloSynthesize:
+		sess.clientHelloHostname = "localhost"
+		sess.state = StateGreeted
+	default:
+		sess.state = StateGreeted
+	}
 
 	// Process commands using embedded session logic
 	for sess.state != StateClosed {
@@ -79,7 +125,13 @@ func socketSessionHandler(ctx context.Context, sess *Session) error {
 		default:
 		}
 
-		line, err := sess.textproto.ReadLine()
+		line, err := sess.readCommandLine()
+		if errors.Is(err, errLineTooLong) {
+			if err := sess.rejectLongLine(); err != nil {
+				return err
+			}
+			continue
+		}
 		if err != nil {
 			sess.logger.Debug("Error reading command", "error", err)
 			return err
@@ -89,7 +141,11 @@ func socketSessionHandler(ctx context.Context, sess *Session) error {
 
 		// Use embedded session's processCommand
 		if err := sess.processCommand(ctx, line); err != nil {
-			sess.logger.Error("Error processing socket command", "error", err, "command", line)
+			if isClientDisconnect(err) {
+				sess.logger.Debug("Socket client disconnected", "error", err, "command", line)
+			} else {
+				sess.logger.Error("Error processing socket command", "error", err, "command", line)
+			}
 			return err
 		}
 	}
@@ -97,6 +153,31 @@ func socketSessionHandler(ctx context.Context, sess *Session) error {
 	return nil
 }
 
+// socketClientInfo describes a socket client for the Received comment, e.g.
+// "comm=mutt pid=1234 uid=1000". The process name is chosen by the client, so
+// only characters that cannot end or nest the comment are kept.
+func socketClientInfo(creds *SocketCredentials) string {
+	if creds == nil {
+		return ""
+	}
+	if creds.PID <= 0 {
+		return fmt.Sprintf("uid=%d", creds.UID)
+	}
+	info := fmt.Sprintf("pid=%d uid=%d", creds.PID, creds.UID)
+	if comm, err := os.ReadFile(fmt.Sprintf("/proc/%d/comm", creds.PID)); err == nil {
+		name := strings.Map(func(r rune) rune {
+			if r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("._-+", r)) {
+				return r
+			}
+			return -1
+		}, string(comm))
+		if name != "" {
+			info = "comm=" + name + " " + info
+		}
+	}
+	return info
+}
+
 // NewSocketSession creates a new socket session with appropriate strategies
 func NewSocketSession(
 	credentials *SocketCredentials,
@@ -114,6 +195,9 @@ func NewSocketSession(
 
 	// Create socket-specific strategies
 	headerGenerator := &SocketHeaderGenerator{}
+	if cfg.Server.SocketHelo == config.SocketHeloSynthesize {
+		headerGenerator.clientInfo = socketClientInfo(credentials)
+	}
 	dataHandler := &SocketDataHandler{}
 
 	// Create connection context for socket
@@ -2,75 +2,101 @@ package smtp
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/pawciobiel/golubsmtpd/internal/queue"
 )
 
-// SocketHeaderGenerator adds all missing headers for socket connections
-type SocketHeaderGenerator struct{}
+// SocketHeaderGenerator adds trace headers and completes the header block of
+// messages injected through the Unix socket
+type SocketHeaderGenerator struct {
+	clientInfo string // identifies the submitting process in the Received comment, if set
+}
 
 func (g *SocketHeaderGenerator) GenerateHeaders(msg *queue.Message, connCtx ConnectionContext) string {
 	var headers strings.Builder
 
-	// Add Received header for socket connections
-	timestamp := time.Now().UTC().Format("Mon, 02 Jan 2006 15:04:05 UTC")
-	headers.WriteString(fmt.Sprintf("Received: from localhost (unix socket) by localhost; %s\r\n",
-		timestamp))
-
-	// Add missing basic headers for socket-delivered messages
-	headers.WriteString(fmt.Sprintf("From: %s\r\n", msg.From))
-
-	// Add To header(s) - combine all recipients
-	var recipients []string
-	for recipient := range msg.LocalRecipients {
-		recipients = append(recipients, recipient)
-	}
-	for recipient := range msg.VirtualRecipients {
-		recipients = append(recipients, recipient)
-	}
-	for recipient := range msg.RelayRecipients {
-		recipients = append(recipients, recipient)
+	// Add Received header for socket connections, naming the client by its HELO when it gave one
+	from := "localhost"
+	if msg.ClientHelloHostname != "" {
+		from = msg.ClientHelloHostname
 	}
-	for recipient := range msg.ExternalRecipients {
-		recipients = append(recipients, recipient)
+	comment := "unix socket"
+	if g.clientInfo != "" {
+		comment += " " + g.clientInfo
 	}
-
-	if len(recipients) > 0 {
-		headers.WriteString(fmt.Sprintf("To: %s\r\n", strings.Join(recipients, ", ")))
-	}
-
-	// Add Date header (using UTC)
-	headers.WriteString(fmt.Sprintf("Date: %s\r\n", msg.Created.UTC().Format("Mon, 02 Jan 2006 15:04:05 UTC")))
+	timestamp := time.Now().UTC().Format("Mon, 02 Jan 2006 15:04:05 UTC")
+	headers.WriteString(fmt.Sprintf("Received: from %s (%s) by localhost; %s\r\n",
+		from, comment, timestamp))
 
 	// Add our internal message ID for tracing
 	headers.WriteString(fmt.Sprintf("GolubSMTPd-Message-ID: %s\r\n", msg.ID))
 
-	// Add empty line to separate headers from body
-	headers.WriteString("\r\n")
-
 	return headers.String()
 }
 
+// CompleteHeaders adds the From, To and Date fields a locally submitted
+// message lacks and normalizes a malformed Date. To lists the envelope
+// recipients only when the message names none itself, so Bcc recipients are
+// not disclosed.
+func (g *SocketHeaderGenerator) CompleteHeaders(fields []string, msg *queue.Message) ([]string, bool) {
+	changed := false
+	if findHeaderField(fields, "From") == -1 {
+		fields = append(fields, fmt.Sprintf("From: %s\r\n", msg.From))
+		changed = true
+	}
+
+	if findHeaderField(fields, "To") == -1 && findHeaderField(fields, "Cc") == -1 && findHeaderField(fields, "Bcc") == -1 {
+		// Combine all recipients
+		var recipients []string
+		for recipient := range msg.LocalRecipients {
+			recipients = append(recipients, recipient)
+		}
+		for recipient := range msg.VirtualRecipients {
+			recipients = append(recipients, recipient)
+		}
+		for recipient := range msg.RelayRecipients {
+			recipients = append(recipients, recipient)
+		}
+		for recipient := range msg.ExternalRecipients {
+			recipients = append(recipients, recipient)
+		}
+		if len(recipients) > 0 {
+			sort.Strings(recipients)
+			fields = append(fields, fmt.Sprintf("To: %s\r\n", strings.Join(recipients, ", ")))
+			changed = true
+		}
+	}
+
+	fields, dateChanged := completeDateHeader(fields, msg.Created)
+	return fields, changed || dateChanged
+}
+
 // SocketDataHandler handles DATA command for Unix socket connections
 type SocketDataHandler struct{}
 
 func (h *SocketDataHandler) HandleData(ctx context.Context, args []string, sess *Session) error {
 	// Check session state - must have at least one recipient
 	if sess.state != StateRcptTo {
-		return sess.writeResponse(Response(StatusBadSequence, "RCPT TO required before DATA"))
+		return sess.writeResponse(sess.noRecipientsResponse())
 	}
 
 	if sess.currentMessage.TotalRecipients() == 0 {
-		return sess.writeResponse(Response(StatusBadSequence, "No recipients specified"))
+		return sess.writeResponse(sess.response(StatusBadSequence, "No recipients specified"))
+	}
+
+	if sess.rateLimited() {
+		return sess.rejectRateLimited()
 	}
 
 	// Start data collection
 	sess.state = StateData
-	if err := sess.writeResponse(Response(StatusStartMailInput, "Start mail input; end with <CRLF>.<CRLF>")); err != nil {
+	if err := sess.writeResponse(sess.response(StatusStartMailInput, "Start mail input; end with <CRLF>.<CRLF>")); err != nil {
 		return err
 	}
 
@@ -89,52 +115,46 @@ func (h *SocketDataHandler) HandleData(ctx context.Context, args []string, sess
 	// Stream message data directly to storage
 	totalSize, err := queue.StreamEmailContent(ctx, sess.config, sess.currentMessage, messageReader)
 	if err != nil {
-		sess.logger.Error("Error storing message data", "error", err, "client_ip", sess.clientIP)
-		return sess.writeResponse(Response(StatusLocalError, "Error storing message"))
+		if errors.Is(err, queue.ErrMessageTooLarge) {
+			return sess.rejectOversizedMessage(err)
+		}
+		if errors.Is(err, queue.ErrEmptyBody) {
+			return sess.rejectEmptyBody()
+		}
+		return sess.rejectStorageError(err)
 	}
 
-	// Update message size after successful storage
-	sess.currentMessage.TotalSize = totalSize
-
-	sess.logger.Info("Socket message received and stored",
-		"sender", sess.currentMessage.From,
-		"total_recipients", sess.currentMessage.TotalRecipients(),
-		"size", totalSize,
-		"message_id", sess.currentMessage.ID,
-		"username", sess.senderValidator.GetUsername())
-
-	// Publish message to queue for processing
-	if err := sess.queue.PublishMessage(ctx, sess.currentMessage); err != nil {
-		sess.logger.Error("Error publishing message to queue", "error", err, "message_id", sess.currentMessage.ID)
-		// Don't fail the SMTP transaction - message is already stored
-	}
-
-	// Reset session for next mail transaction
-	sess.resetSession()
-
-	return sess.writeResponse(Response(StatusOK, "Message accepted for delivery"))
+	return sess.completeMessage(ctx, totalSize)
 }
 
 // HandleAuth for socket connections - authentication not needed
 func (h *SocketDataHandler) HandleAuth(ctx context.Context, args []string, sess *Session) error {
-	return sess.writeResponse(Response(StatusBadSequence, "Authentication not required for local connections"))
+	return sess.writeResponse(sess.response(StatusBadSequence, "Authentication not required for local connections"))
 }
 
 // HandleMail for socket connections - use socket-specific sender validation
 func (h *SocketDataHandler) HandleMail(ctx context.Context, args []string, sess *Session) error {
-	if sess.state != StateGreeted {
-		return sess.writeResponse(Response(StatusBadSequence, "Bad sequence of commands"))
+	switch sess.state {
+	case StateConnected:
+		return sess.writeResponse(sess.response(StatusBadSequence, "EHLO/HELO required before MAIL"))
+	case StateGreeted, StateAuthenticated:
+	default:
+		return sess.writeResponse(sess.response(StatusBadSequence, "Bad sequence of commands"))
 	}
 
 	if len(args) == 0 {
-		return sess.writeResponse(Response(StatusSyntaxError, "MAIL command requires FROM parameter"))
+		return sess.writeResponse(sess.response(StatusSyntaxError, "MAIL command requires FROM parameter"))
 	}
+	sess.txStart = time.Now()
 
 	// Parse MAIL FROM using existing EmailValidator (RFC compliant)
-	emailValidator := NewEmailValidator(sess.config)
-	emailAddr, err := emailValidator.ParseMailFromCommand(args)
+	emailAddr, err := sess.emailValidator.ParseMailFromCommand(args)
 	if err != nil {
-		return sess.writeResponse(Response(StatusSyntaxError, err.Error()))
+		return sess.writeResponse(sess.response(StatusSyntaxError, err.Error()))
+	}
+	params, err := ParseMailParams(args)
+	if err != nil {
+		return sess.writeResponse(sess.response(StatusParamError, err.Error()))
 	}
 
 	sender := emailAddr.Full
@@ -147,22 +167,29 @@ func (h *SocketDataHandler) HandleMail(ctx context.Context, args []string, sess
 	}
 	if err := sess.senderValidator.ValidateSender(sender, senderCtx); err != nil {
 		sess.logger.Info("Sender rejected", "sender", sender, "username", sess.senderValidator.GetUsername(), "error", err)
-		return sess.writeResponse(Response(StatusMailboxUnavailable, "Sender address not allowed"))
+		response := sess.senderRejectedResponse(err)
+		sess.logRejectedSender(sender, response)
+		return sess.writeResponse(response)
 	}
 
 	// Create new message using proper Message struct
 	sess.currentMessage = &queue.Message{
-		From:               sender,
-		ClientIP:           "socket",
-		LocalRecipients:    make(map[string]struct{}),
-		VirtualRecipients:  make(map[string]struct{}),
-		RelayRecipients:    make(map[string]struct{}),
-		ExternalRecipients: make(map[string]struct{}),
-		Created:            time.Now(),
+		From:                sess.rewriteAddress(sender),
+		ClientIP:            "socket",
+		ClientHelloHostname: sess.clientHelloHostname,
+		LocalRecipients:     queue.NewRecipientSet(),
+		VirtualRecipients:   queue.NewRecipientSet(),
+		RelayRecipients:     queue.NewRecipientSet(),
+		ExternalRecipients:  queue.NewRecipientSet(),
+		Created:             time.Now(),
 	}
 	// Generate ID for the message
 	sess.currentMessage.ID = queue.GenerateID()
+	if err := sess.applyMailParams(params); err != nil {
+		sess.currentMessage = nil
+		return sess.writeResponse(sess.response(StatusParamError, err.Error()))
+	}
 
 	sess.state = StateMailFrom
-	return sess.writeResponse(Response(StatusOK, "OK"))
+	return sess.writeResponse(sess.response(StatusOK, "OK"))
 }
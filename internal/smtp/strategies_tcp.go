@@ -2,92 +2,140 @@ package smtp
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"strings"
 	"time"
 
+	"github.com/pawciobiel/golubsmtpd/internal/config"
 	"github.com/pawciobiel/golubsmtpd/internal/queue"
+	"github.com/pawciobiel/golubsmtpd/internal/security"
 )
 
 // TCPHeaderGenerator adds Received header and GolubSMTPd-Message-ID for TCP connections
-type TCPHeaderGenerator struct{}
+type TCPHeaderGenerator struct {
+	hostname   string               // our own hostname for the "by" clause
+	tagDNSBL   bool                 // add X-DNSBL naming the DNSBL listings (dnsbl action "tag")
+	spamScorer *security.SpamScorer // adds score and report headers; nil disables
+}
+
+// newTCPHeaderGenerator creates the header generator for TCP and LMTP sessions
+func newTCPHeaderGenerator(cfg *config.Config, spamScorer *security.SpamScorer) *TCPHeaderGenerator {
+	return &TCPHeaderGenerator{
+		hostname:   cfg.Server.AdvertisedHostname(),
+		tagDNSBL:   cfg.Security.DNSBL.Action == "tag",
+		spamScorer: spamScorer,
+	}
+}
 
 func (g *TCPHeaderGenerator) GenerateHeaders(msg *queue.Message, connCtx ConnectionContext) string {
 	var headers strings.Builder
 
-	// Add Received header for message tracing
-	clientInfo := connCtx.ClientIP
-	// TODO: Add client hostname from HELO/EHLO if available
+	// Add Received header for message tracing (RFC 5321 §4.4):
+	// from <helo> (<rdns> [<ip>]) by <hostname> with ESMTP id <id>; <date>
+	rdns := connCtx.ReverseDNS
+	if rdns == "" {
+		rdns = "unknown"
+	}
+	protocol := "ESMTP"
+	if connCtx.Type == ConnectionTypeLMTP {
+		protocol = "LMTP"
+	} else if connCtx.TLS {
+		protocol = "ESMTPS" // RFC 3848
+	}
 
-	timestamp := time.Now().UTC().Format("Mon, 02 Jan 2006 15:04:05 UTC")
-	headers.WriteString(fmt.Sprintf("Received: from %s by localhost; %s\r\n",
-		clientInfo, timestamp))
+	timestamp := time.Now().UTC().Format(time.RFC1123Z)
+	headers.WriteString(fmt.Sprintf("Received: from %s (%s [%s])\r\n\tby %s with %s id %s;\r\n\t%s\r\n",
+		msg.ClientHelloHostname, rdns, connCtx.ClientIP, g.hostname, protocol, msg.ID, timestamp))
 
 	// Add our internal message ID for tracing
 	headers.WriteString(fmt.Sprintf("GolubSMTPd-Message-ID: %s\r\n", msg.ID))
 
+	if g.tagDNSBL && len(msg.DNSBLListings) > 0 {
+		headers.WriteString(fmt.Sprintf("X-DNSBL: %s\r\n", strings.Join(msg.DNSBLListings, ", ")))
+	}
+	// An allowlisted client was never looked up, so its empty rDNS proves nothing
+	if !connCtx.Allowlisted {
+		headers.WriteString(g.spamScorer.Headers(g.spamScorer.Score(security.ScoreSignals{
+			DNSBLListings: msg.DNSBLListings,
+			ReverseDNS:    connCtx.ReverseDNS,
+			HeloHostname:  msg.ClientHelloHostname,
+		})))
+	}
+
 	return headers.String()
 }
 
+// CompleteHeaders adds a missing Date and normalizes a malformed one; only
+// called for authenticated submissions
+func (g *TCPHeaderGenerator) CompleteHeaders(fields []string, msg *queue.Message) ([]string, bool) {
+	return completeDateHeader(fields, msg.Created)
+}
+
 // TCPDataHandler handles DATA command for TCP connections
 type TCPDataHandler struct{}
 
 func (h *TCPDataHandler) HandleData(ctx context.Context, args []string, sess *Session) error {
 	// Check session state - must have at least one recipient
 	if sess.state != StateRcptTo {
-		return sess.writeResponse(Response(StatusBadSequence, "RCPT TO required before DATA"))
+		return sess.writeResponse(sess.noRecipientsResponse())
 	}
 
 	if sess.currentMessage.TotalRecipients() == 0 {
-		return sess.writeResponse(Response(StatusBadSequence, "No recipients specified"))
+		return sess.writeResponse(sess.response(StatusBadSequence, "No recipients specified"))
+	}
+
+	if sess.rateLimited() {
+		return sess.rejectRateLimited()
 	}
 
 	// Start data collection
 	sess.state = StateData
-	if err := sess.writeResponse(Response(StatusStartMailInput, "Start mail input; end with <CRLF>.<CRLF>")); err != nil {
+	if err := sess.writeResponse(sess.response(StatusStartMailInput, "Start mail input; end with <CRLF>.<CRLF>")); err != nil {
 		return err
 	}
 
 	// Generate headers using the strategy
 	headers := sess.headerGenerator.GenerateHeaders(sess.currentMessage, sess.connCtx)
 
-	// Create a reader that combines headers and message data
-	var messageReader io.Reader
+	// Create a reader that combines headers and message data; the read deadline
+	// is refreshed before every read so a stalled client cannot hold the session
+	var messageReader io.Reader = &deadlineReader{
+		reader:  sess.textproto.R,
+		sess:    sess,
+		timeout: sess.config.Server.DataTimeout,
+	}
 	if headers != "" {
 		headerReader := strings.NewReader(headers)
-		messageReader = io.MultiReader(headerReader, sess.textproto.R)
-	} else {
-		messageReader = sess.textproto.R
+		messageReader = io.MultiReader(headerReader, messageReader)
 	}
 
 	// Stream message data directly to storage
 	totalSize, err := queue.StreamEmailContent(ctx, sess.config, sess.currentMessage, messageReader)
 	if err != nil {
-		sess.logger.Error("Error storing message data", "error", err, "client_ip", sess.clientIP)
-		return sess.writeResponse(Response(StatusLocalError, "Error storing message"))
+		if isTimeoutError(err) {
+			sess.logTransaction(dispositionRejected, sess.response(StatusTempFailure, ""))
+			sess.closeOnTimeout("message data") //nolint:errcheck
+			return err
+		}
+		if errors.Is(err, queue.ErrDataDurationExceeded) {
+			sess.logger.Info("DATA phase exceeded time limit, closing connection", "error", err, "client_ip", sess.clientIP)
+			sess.logTransaction(dispositionRejected, sess.response(StatusTempFailure, ""))
+			sess.state = StateClosed
+			sess.writeResponse(sess.responseWithHostname(StatusTempFailure, "Message transfer time limit exceeded, closing connection")) //nolint:errcheck
+			return err
+		}
+		if errors.Is(err, queue.ErrMessageTooLarge) {
+			return sess.rejectOversizedMessage(err)
+		}
+		if errors.Is(err, queue.ErrEmptyBody) {
+			return sess.rejectEmptyBody()
+		}
+		return sess.rejectStorageError(err)
 	}
 
-	// Update message size after successful storage
-	sess.currentMessage.TotalSize = totalSize
-
-	sess.logger.Info("TCP message received and stored",
-		"sender", sess.currentMessage.From,
-		"total_recipients", sess.currentMessage.TotalRecipients(),
-		"size", totalSize,
-		"message_id", sess.currentMessage.ID,
-		"client_ip", sess.clientIP)
-
-	// Publish message to queue for processing
-	if err := sess.queue.PublishMessage(ctx, sess.currentMessage); err != nil {
-		sess.logger.Error("Error publishing message to queue", "error", err, "message_id", sess.currentMessage.ID)
-		// Don't fail the SMTP transaction - message is already stored
-	}
-
-	// Reset session for next mail transaction
-	sess.resetSession()
-
-	return sess.writeResponse(Response(StatusOK, "Message accepted for delivery"))
+	return sess.completeMessage(ctx, totalSize)
 }
 
 // HandleAuth for TCP connections - use default session logic
@@ -0,0 +1,129 @@
+package smtp
+
+import (
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pawciobiel/golubsmtpd/internal/queue"
+)
+
+func TestTCPHeaderGenerator_Received(t *testing.T) {
+	gen := &TCPHeaderGenerator{hostname: "mx.example.com"}
+	msg := &queue.Message{ID: "msg-123", ClientHelloHostname: "client.example.org"}
+
+	tests := []struct {
+		name    string
+		connCtx ConnectionContext
+		want    string
+	}{
+		{
+			name:    "with rDNS",
+			connCtx: ConnectionContext{ClientIP: "192.0.2.10", ReverseDNS: "host.example.org"},
+			want:    "from client.example.org (host.example.org [192.0.2.10]) by mx.example.com with ESMTP id msg-123;",
+		},
+		{
+			name:    "without rDNS over TLS",
+			connCtx: ConnectionContext{ClientIP: "192.0.2.10", TLS: true},
+			want:    "from client.example.org (unknown [192.0.2.10]) by mx.example.com with ESMTPS id msg-123;",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := gen.GenerateHeaders(msg, tt.connCtx)
+
+			parsed, err := mail.ReadMessage(strings.NewReader(headers + "\r\n"))
+			if err != nil {
+				t.Fatalf("Generated headers are not parseable: %v\n%s", err, headers)
+			}
+
+			// Unfold continuation lines before comparing
+			received := strings.Join(strings.Fields(parsed.Header.Get("Received")), " ")
+			if !strings.HasPrefix(received, tt.want) {
+				t.Errorf("Received header mismatch:\nwant prefix: %q\ngot:         %q", tt.want, received)
+			}
+
+			dateStr := strings.TrimSpace(received[strings.LastIndex(received, ";")+1:])
+			date, err := time.Parse(time.RFC1123Z, dateStr)
+			if err != nil {
+				t.Errorf("Received date %q is not RFC 1123Z: %v", dateStr, err)
+			} else if time.Since(date) > time.Minute {
+				t.Errorf("Received date %v is not current", date)
+			}
+		})
+	}
+}
+
+func TestTCPHeaderGenerator_CompleteHeaders(t *testing.T) {
+	gen := &TCPHeaderGenerator{hostname: "mx.example.com"}
+	created := time.Date(2024, 3, 9, 14, 30, 0, 0, time.UTC)
+	msg := &queue.Message{ID: "msg-123", Created: created}
+
+	tests := []struct {
+		name        string
+		fields      []string
+		wantFields  []string
+		wantChanged bool
+	}{
+		{
+			name:        "no Date is added",
+			fields:      []string{"Subject: hi\r\n"},
+			wantFields:  []string{"Subject: hi\r\n", "Date: Sat, 09 Mar 2024 14:30:00 +0000\r\n"},
+			wantChanged: true,
+		},
+		{
+			name:       "valid Date is untouched",
+			fields:     []string{"Date: Fri, 1 Mar 2024 08:00:00 +0100\r\n", "Subject: hi\r\n"},
+			wantFields: []string{"Date: Fri, 1 Mar 2024 08:00:00 +0100\r\n", "Subject: hi\r\n"},
+		},
+		{
+			name:        "malformed Date is normalized",
+			fields:      []string{"Subject: hi\r\n", "date: 2024-03-01T08:00:00+01:00\r\n"},
+			wantFields:  []string{"Subject: hi\r\n", "Date: Fri, 01 Mar 2024 08:00:00 +0100\r\n"},
+			wantChanged: true,
+		},
+		{
+			name:        "unparseable Date is replaced",
+			fields:      []string{"Date: yesterday\r\n"},
+			wantFields:  []string{"Date: Sat, 09 Mar 2024 14:30:00 +0000\r\n"},
+			wantChanged: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields, changed := gen.CompleteHeaders(tt.fields, msg)
+			if changed != tt.wantChanged {
+				t.Errorf("changed = %v, want %v", changed, tt.wantChanged)
+			}
+			if strings.Join(fields, "") != strings.Join(tt.wantFields, "") {
+				t.Errorf("fields mismatch:\nwant: %q\ngot:  %q", tt.wantFields, fields)
+			}
+		})
+	}
+}
+
+func TestSocketHeaderGenerator_CompleteHeaders(t *testing.T) {
+	gen := &SocketHeaderGenerator{}
+	created := time.Date(2024, 3, 9, 14, 30, 0, 0, time.UTC)
+	msg := &queue.Message{
+		From:            "alice@localhost",
+		LocalRecipients: queue.NewRecipientSet("bob@localhost"),
+		Created:         created,
+	}
+
+	fields, changed := gen.CompleteHeaders([]string{"Subject: hi\r\n"}, msg)
+	want := "Subject: hi\r\nFrom: alice@localhost\r\nTo: bob@localhost\r\nDate: Sat, 09 Mar 2024 14:30:00 +0000\r\n"
+	if !changed || strings.Join(fields, "") != want {
+		t.Errorf("CompleteHeaders = %q, %v, want %q", fields, changed, want)
+	}
+
+	// A complete header block gets no duplicates; Bcc-only messages get no To
+	complete := []string{"From: alice@localhost\r\n", "Bcc: carol@localhost\r\n", "Date: Sat, 9 Mar 2024 14:30:00 +0000\r\n"}
+	fields, changed = gen.CompleteHeaders(complete, msg)
+	if changed || strings.Join(fields, "") != strings.Join(complete, "") {
+		t.Errorf("CompleteHeaders on complete block = %q, %v, want unchanged", fields, changed)
+	}
+}
@@ -0,0 +1,64 @@
+package smtp
+
+import (
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"time"
+)
+
+// setReadDeadline refreshes the read deadline on the underlying connection.
+// Socket sessions have no rawConn and are not subject to idle timeouts.
+func (sess *Session) setReadDeadline(timeout time.Duration) {
+	if sess.rawConn == nil || timeout <= 0 {
+		return
+	}
+	sess.rawConn.SetReadDeadline(time.Now().Add(timeout)) //nolint:errcheck
+}
+
+// setWriteDeadline refreshes the write deadline on the underlying connection
+func (sess *Session) setWriteDeadline(timeout time.Duration) {
+	if sess.rawConn == nil || timeout <= 0 {
+		return
+	}
+	sess.rawConn.SetWriteDeadline(time.Now().Add(timeout)) //nolint:errcheck
+}
+
+// deadlineReader refreshes the session read deadline before every Read, so a
+// client must keep making progress rather than merely starting before a fixed deadline
+type deadlineReader struct {
+	reader  io.Reader
+	sess    *Session
+	timeout time.Duration
+}
+
+func (r *deadlineReader) Read(p []byte) (int, error) {
+	r.sess.setReadDeadline(r.timeout)
+	return r.reader.Read(p)
+}
+
+// isTimeoutError reports whether err was caused by an expired I/O deadline
+func isTimeoutError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// isClientDisconnect reports whether err means the client hung up: end of
+// stream, a closed connection, or a broken pipe or reset on write. These are
+// routine, e.g. clients that close right after QUIT.
+func isClientDisconnect(err error) bool {
+	return errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.ErrClosedPipe) ||
+		errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, syscall.ECONNRESET)
+}
+
+// closeOnTimeout sends 421 and marks the session closed after an idle timeout
+func (sess *Session) closeOnTimeout(waitingFor string) error {
+	sess.logger.Info("Client idle timeout, closing connection", "waiting_for", waitingFor, "client_ip", sess.clientIP)
+	sess.state = StateClosed
+	return sess.writeResponse(sess.responseWithHostname(StatusTempFailure, "Timeout waiting for "+waitingFor+", closing connection"))
+}
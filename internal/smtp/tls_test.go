@@ -0,0 +1,224 @@
+package smtp
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pawciobiel/golubsmtpd/internal/auth"
+	"github.com/pawciobiel/golubsmtpd/internal/config"
+)
+
+// testCA issues certificates for in-memory TLS handshakes
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate CA key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create CA certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse CA certificate: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue signs a leaf certificate for the given subject CN and SANs
+func (ca *testCA) issue(t *testing.T, cn string, dnsNames, emails []string, usage x509.ExtKeyUsage) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:   big.NewInt(time.Now().UnixNano()),
+		Subject:        pkix.Name{CommonName: cn},
+		DNSNames:       dnsNames,
+		EmailAddresses: emails,
+		NotBefore:      time.Now().Add(-time.Hour),
+		NotAfter:       time.Now().Add(time.Hour),
+		KeyUsage:       x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:    []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// newTLSSession completes an in-memory implicit-TLS handshake, presenting
+// clientCert when non-nil, and returns a greeted session on the server side
+func newTLSSession(t *testing.T, cfg *config.Config, ca *testCA, clientCert *tls.Certificate) (*Session, *bufferConn) {
+	t.Helper()
+
+	serverConn, clientConn := net.Pipe()
+	t.Cleanup(func() {
+		serverConn.Close()
+		clientConn.Close()
+	})
+
+	serverTLS := tls.Server(serverConn, &tls.Config{
+		Certificates: []tls.Certificate{ca.issue(t, "mail.example.com", []string{"mail.example.com"}, nil, x509.ExtKeyUsageServerAuth)},
+		ClientCAs:    ca.pool,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+	})
+	clientCfg := &tls.Config{RootCAs: ca.pool, ServerName: "mail.example.com"}
+	if clientCert != nil {
+		clientCfg.Certificates = []tls.Certificate{*clientCert}
+	}
+	clientTLS := tls.Client(clientConn, clientCfg)
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- clientTLS.Handshake() }()
+	if err := serverTLS.Handshake(); err != nil {
+		t.Fatalf("Server handshake failed: %v", err)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("Client handshake failed: %v", err)
+	}
+
+	// Commands are driven directly; the TLS conn only carries the peer certificate
+	sess, conn := newTestTCPSession(t, cfg)
+	sess.rawConn = serverTLS
+	sess.connCtx.Port = 465
+	sess.connCtx.Mode = config.ListenerModeTLS
+	sess.connCtx.TLS = true
+	return sess, conn
+}
+
+func newClientCertConfig() *config.Config {
+	cfg := config.DefaultConfig()
+	cfg.TLS.ClientCertUsers = map[string]string{
+		"backup-host":          "backup",
+		"app.internal.example": "app",
+		"billing@example.org":  "billing",
+	}
+	return cfg
+}
+
+func TestSession_AuthExternal(t *testing.T) {
+	ca := newTestCA(t)
+
+	tests := []struct {
+		name          string
+		cn            string
+		dnsNames      []string
+		emails        []string
+		authArg       string
+		wantAdvertise bool
+		wantCode      string
+		wantUsername  string
+	}{
+		{"mapped by CN", "backup-host", nil, nil, "=", true, "235", "backup"},
+		{"mapped by DNS SAN", "unmapped", []string{"app.internal.example"}, nil, "=", true, "235", "app"},
+		{"mapped by email SAN", "unmapped", nil, []string{"billing@example.org"}, "=", true, "235", "billing"},
+		{"matching authzid", "backup-host", nil, nil, auth.EncodeBase64("backup"), true, "235", "backup"},
+		{"mismatched authzid", "backup-host", nil, nil, auth.EncodeBase64("root"), true, "535", ""},
+		{"unmapped certificate", "stranger", nil, nil, "=", false, "501", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cert := ca.issue(t, tt.cn, tt.dnsNames, tt.emails, x509.ExtKeyUsageClientAuth)
+			sess, conn := newTLSSession(t, newClientCertConfig(), ca, &cert)
+			ctx := context.Background()
+
+			if err := sess.processCommand(ctx, "EHLO client.example.com"); err != nil {
+				t.Fatalf("EHLO failed: %v", err)
+			}
+			if got := strings.Contains(conn.out.String(), "AUTH PLAIN LOGIN EXTERNAL"); got != tt.wantAdvertise {
+				t.Errorf("EXTERNAL advertised = %v, want %v:\n%s", got, tt.wantAdvertise, conn.out.String())
+			}
+
+			if err := sess.processCommand(ctx, "AUTH EXTERNAL "+tt.authArg); err != nil {
+				t.Fatalf("AUTH EXTERNAL failed: %v", err)
+			}
+			if resp := conn.lastResponse(); !strings.HasPrefix(resp, tt.wantCode) {
+				t.Errorf("AUTH EXTERNAL: expected %s, got %q", tt.wantCode, resp)
+			}
+			if sess.username != tt.wantUsername {
+				t.Errorf("username = %q, want %q", sess.username, tt.wantUsername)
+			}
+			if sess.authenticated != (tt.wantUsername != "") {
+				t.Errorf("authenticated = %v", sess.authenticated)
+			}
+		})
+	}
+}
+
+func TestSession_AuthExternalWithoutClientCert(t *testing.T) {
+	sess, conn := newTLSSession(t, newClientCertConfig(), newTestCA(t), nil)
+	ctx := context.Background()
+
+	if err := sess.processCommand(ctx, "EHLO client.example.com"); err != nil {
+		t.Fatalf("EHLO failed: %v", err)
+	}
+	if strings.Contains(conn.out.String(), "EXTERNAL") {
+		t.Errorf("EXTERNAL advertised without a client certificate:\n%s", conn.out.String())
+	}
+
+	if err := sess.processCommand(ctx, "AUTH EXTERNAL ="); err != nil {
+		t.Fatalf("AUTH EXTERNAL failed: %v", err)
+	}
+	if resp := conn.lastResponse(); !strings.HasPrefix(resp, "501") {
+		t.Errorf("AUTH EXTERNAL without certificate: expected 501, got %q", resp)
+	}
+	if sess.authenticated {
+		t.Error("Session authenticated without a client certificate")
+	}
+}
+
+func TestSession_AuthExternalContinuation(t *testing.T) {
+	ca := newTestCA(t)
+	cert := ca.issue(t, "backup-host", nil, nil, x509.ExtKeyUsageClientAuth)
+	sess, conn := newTLSSession(t, newClientCertConfig(), ca, &cert)
+
+	// No initial response: server sends an empty challenge, client answers with an empty line
+	conn.in = strings.NewReader("\r\n")
+	if err := sess.processCommand(context.Background(), "AUTH EXTERNAL"); err != nil {
+		t.Fatalf("AUTH EXTERNAL failed: %v", err)
+	}
+	if !strings.Contains(conn.out.String(), "334 \r\n") {
+		t.Errorf("Expected empty 334 challenge, got:\n%s", conn.out.String())
+	}
+	if resp := conn.lastResponse(); !strings.HasPrefix(resp, "235") {
+		t.Errorf("Expected 235, got %q", resp)
+	}
+	if sess.username != "backup" {
+		t.Errorf("username = %q, want backup", sess.username)
+	}
+}
@@ -20,6 +20,10 @@ type ValidationError struct {
 
 func (e *ValidationError) Error() string { return e.Reason }
 
+// ErrAuthRequired is returned by ValidateSender when the listener requires AUTH
+// and the session has not authenticated yet; callers answer it with 530.
+var ErrAuthRequired = &ValidationError{Reason: "authentication required before MAIL FROM"}
+
 // SocketValidator validates senders for Unix socket connections
 type SocketValidator struct {
 	credentials *SocketCredentials
@@ -90,7 +94,8 @@ func (v *SocketValidator) isTrustedUser() bool {
 func (v *SocketValidator) getAllowedSenders() []string {
 	allowed := make([]string, 0, len(v.config.Server.LocalDomains))
 	for _, domain := range v.config.Server.LocalDomains {
-		allowed = append(allowed, v.username+"@"+domain)
+		// A ".example.com" wildcard entry still names example.com itself
+		allowed = append(allowed, v.username+"@"+strings.TrimPrefix(domain, "."))
 	}
 	return allowed
 }
@@ -112,7 +117,7 @@ func NewSubmissionValidator(authenticator auth.Authenticator, cfg *config.Config
 
 func (v *SubmissionValidator) ValidateSender(sender string, ctx ValidationContext) error {
 	if !ctx.Authenticated {
-		return &ValidationError{Reason: "authentication required before MAIL FROM"}
+		return ErrAuthRequired
 	}
 
 	if sender == "" {
@@ -130,7 +135,7 @@ func (v *SubmissionValidator) ValidateSender(sender string, ctx ValidationContex
 }
 
 func (v *SubmissionValidator) ValidateRecipient(_ string, ctx ValidationContext) error {
-	if ctx.RecipientType == delivery.RecipientExternal {
+	if ctx.RecipientType == delivery.RecipientExternal && !ctx.TrustedNetwork {
 		return &ValidationError{Reason: fmt.Sprintf("user %s may not send to external recipients", ctx.Username)}
 	}
 	return nil
@@ -145,6 +150,7 @@ func (v *SubmissionValidator) GetUsername() string {
 }
 
 // RelayValidator accepts all senders; recipient policy is enforced in handleRcpt.
+// Clients in trusted networks may relay even when relay is disabled.
 type RelayValidator struct {
 	config *config.Config
 }
@@ -161,12 +167,15 @@ func (v *RelayValidator) ValidateSender(sender string, _ ValidationContext) erro
 }
 
 func (v *RelayValidator) ValidateRecipient(_ string, ctx ValidationContext) error {
-	if !v.config.Relay.Enabled {
-		return &ValidationError{Reason: "relay disabled in config"}
-	}
 	if ctx.Authenticated {
 		return &ValidationError{Reason: "authenticated session may not use relay queue"}
 	}
+	if ctx.TrustedNetwork {
+		return nil
+	}
+	if !v.config.Relay.Enabled {
+		return &ValidationError{Reason: "relay disabled in config"}
+	}
 	return nil
 }
 
@@ -24,6 +24,9 @@ func (m *mockAuthWithSenders) Authenticate(_ context.Context, username, _ string
 func (m *mockAuthWithSenders) ValidateUser(_ context.Context, _ string) bool { return false }
 func (m *mockAuthWithSenders) Name() string                                   { return "mock" }
 func (m *mockAuthWithSenders) Close() error                                   { return nil }
+func (m *mockAuthWithSenders) Capabilities() auth.AuthCapabilities {
+	return auth.AuthCapabilities{}
+}
 func (m *mockAuthWithSenders) GetAllowedSenders(username string) []string {
 	return m.senders[username]
 }
@@ -89,6 +92,16 @@ func TestRelayValidator_ValidateRecipient_RelayEnabled_Authenticated(t *testing.
 	}
 }
 
+func TestRelayValidator_ValidateRecipient_TrustedNetwork(t *testing.T) {
+	cfg := config.DefaultConfig() // Relay.Enabled = false
+	v := NewRelayValidator(cfg)
+
+	ctx := ValidationContext{TrustedNetwork: true, RecipientType: delivery.RecipientExternal}
+	if err := v.ValidateRecipient("user@external.com", ctx); err != nil {
+		t.Errorf("trusted network should relay even with relay disabled: %v", err)
+	}
+}
+
 func TestRelayValidator_IsAuthenticated(t *testing.T) {
 	v := NewRelayValidator(config.DefaultConfig())
 	if v.IsAuthenticated() {
@@ -0,0 +1,137 @@
+package smtp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/pawciobiel/golubsmtpd/internal/auth"
+	"github.com/pawciobiel/golubsmtpd/internal/config"
+)
+
+// mockTokenValidator maps tokens to usernames; unknown tokens are expired
+type mockTokenValidator struct {
+	tokens map[string]string
+	err    error // returned for every token when set
+}
+
+func (m *mockTokenValidator) ValidateToken(ctx context.Context, token string) (string, error) {
+	if m.err != nil {
+		return "", m.err
+	}
+	if username, ok := m.tokens[token]; ok {
+		return username, nil
+	}
+	return "", fmt.Errorf("%w: token expired", auth.ErrInvalidToken)
+}
+
+func xoauth2Payload(user, token string) string {
+	return auth.EncodeBase64("user=" + user + "\x01auth=Bearer " + token + "\x01\x01")
+}
+
+func newXOAuth2Session(t *testing.T, validator auth.TokenValidator) (*Session, *bufferConn) {
+	t.Helper()
+
+	sess, conn := newTestTCPSession(t, config.DefaultConfig())
+	sess.connCtx.Mode = config.ListenerModePlain
+	sess.tokenValidator = validator
+	return sess, conn
+}
+
+func TestSession_AuthXOAuth2(t *testing.T) {
+	validator := &mockTokenValidator{tokens: map[string]string{"good-token": "alice@example.com"}}
+
+	tests := []struct {
+		name         string
+		payload      string
+		wantCode     string
+		wantUsername string
+	}{
+		{"valid token", xoauth2Payload("alice@example.com", "good-token"), "235", "alice@example.com"},
+		{"user case-insensitive", xoauth2Payload("Alice@Example.com", "good-token"), "235", "alice@example.com"},
+		{"expired token", xoauth2Payload("alice@example.com", "expired-token"), "535", ""},
+		{"token for another user", xoauth2Payload("bob@example.com", "good-token"), "535", ""},
+		{"malformed payload", auth.EncodeBase64("garbage"), "535", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sess, conn := newXOAuth2Session(t, validator)
+			conn.in = strings.NewReader("\r\n") // empty continuation after an error challenge
+
+			if err := sess.processCommand(context.Background(), "AUTH XOAUTH2 "+tt.payload); err != nil {
+				t.Fatalf("AUTH XOAUTH2 failed: %v", err)
+			}
+			if resp := conn.lastResponse(); !strings.HasPrefix(resp, tt.wantCode) {
+				t.Errorf("Expected %s, got %q", tt.wantCode, resp)
+			}
+			if sess.username != tt.wantUsername {
+				t.Errorf("username = %q, want %q", sess.username, tt.wantUsername)
+			}
+			if sess.authenticated != (tt.wantUsername != "") {
+				t.Errorf("authenticated = %v", sess.authenticated)
+			}
+		})
+	}
+}
+
+func TestSession_AuthXOAuth2ErrorChallenge(t *testing.T) {
+	sess, conn := newXOAuth2Session(t, &mockTokenValidator{})
+	conn.in = strings.NewReader("\r\n")
+
+	if err := sess.processCommand(context.Background(), "AUTH XOAUTH2 "+xoauth2Payload("alice@example.com", "expired-token")); err != nil {
+		t.Fatalf("AUTH XOAUTH2 failed: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimRight(conn.out.String(), "\r\n"), "\r\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected error challenge then final reply, got %q", lines)
+	}
+	challenge, ok := strings.CutPrefix(lines[0], "334 ")
+	if !ok {
+		t.Fatalf("Expected 334 error challenge, got %q", lines[0])
+	}
+	decoded, err := auth.DecodeBase64(challenge)
+	if err != nil {
+		t.Fatalf("Error challenge is not base64: %v", err)
+	}
+	if !strings.Contains(decoded, `"status":"invalid_token"`) {
+		t.Errorf("Unexpected error challenge JSON: %s", decoded)
+	}
+	if !strings.HasPrefix(lines[1], "535") {
+		t.Errorf("Expected 535 after continuation, got %q", lines[1])
+	}
+}
+
+func TestSession_AuthXOAuth2ValidatorUnavailable(t *testing.T) {
+	sess, conn := newXOAuth2Session(t, &mockTokenValidator{err: errors.New("connection refused")})
+
+	if err := sess.processCommand(context.Background(), "AUTH XOAUTH2 "+xoauth2Payload("alice@example.com", "good-token")); err != nil {
+		t.Fatalf("AUTH XOAUTH2 failed: %v", err)
+	}
+	if resp := conn.lastResponse(); !strings.HasPrefix(resp, "454") {
+		t.Errorf("Expected 454 temporary failure, got %q", resp)
+	}
+}
+
+func TestSession_AuthXOAuth2Advertised(t *testing.T) {
+	ctx := context.Background()
+
+	sess, conn := newXOAuth2Session(t, &mockTokenValidator{})
+	if err := sess.processCommand(ctx, "EHLO client.example.com"); err != nil {
+		t.Fatalf("EHLO failed: %v", err)
+	}
+	if !strings.Contains(conn.out.String(), "250-AUTH PLAIN LOGIN XOAUTH2\r\n") {
+		t.Errorf("Expected XOAUTH2 advertised with a token validator, got:\n%s", conn.out.String())
+	}
+
+	sess, conn = newXOAuth2Session(t, nil)
+	if err := sess.processCommand(ctx, "EHLO client.example.com"); err != nil {
+		t.Fatalf("EHLO failed: %v", err)
+	}
+	if strings.Contains(conn.out.String(), "XOAUTH2") {
+		t.Errorf("XOAUTH2 advertised without a token validator:\n%s", conn.out.String())
+	}
+}
@@ -1,6 +1,7 @@
 package types
 
 import (
+	"encoding/json"
 	"fmt"
 	"strings"
 	"time"
@@ -14,10 +15,15 @@ type Message struct {
 	From                string
 	ClientIP            string
 	ClientHelloHostname string
-	LocalRecipients     map[string]struct{}
-	VirtualRecipients   map[string]struct{}
-	RelayRecipients     map[string]struct{}
-	ExternalRecipients  map[string]struct{}
+	AuthSender          string // RFC 4954 AUTH= identity ("<>" when not trusted), empty if not given
+	BodyType            string // RFC 6152 BODY= value, "7BIT" or "8BITMIME"; empty if not given
+	DSNRet              string // RFC 3461 RET= value, "FULL" or "HDRS"; empty if not given
+	DSNEnvID            string // RFC 3461 ENVID= value (xtext decoded); empty if not given
+	LocalRecipients     RecipientSet
+	VirtualRecipients   RecipientSet
+	RelayRecipients     RecipientSet
+	ExternalRecipients  RecipientSet
+	DNSBLListings       []string // DNSBL providers listing the client IP or sender domain
 	TotalSize           int64
 	Created             time.Time
 	// RawBody is set for in-memory generated messages (e.g. DSN bounces).
@@ -25,11 +31,131 @@ type Message struct {
 	RawBody string
 }
 
+// DSNParams holds the RFC 3461 parameters given with one RCPT TO
+type DSNParams struct {
+	Notify []string `json:",omitempty"` // "NEVER" alone, or any of "SUCCESS", "FAILURE", "DELAY"; empty if not given
+	ORcpt  string   `json:",omitempty"` // original recipient as "addr-type;address" (xtext decoded); empty if not given
+}
+
+// RecipientInfo is what is known about one envelope recipient
+type RecipientInfo struct {
+	Address  string    // recipient after alias expansion and rewriting
+	Original string    `json:",omitempty"` // RCPT TO address that produced it, when it differs (alias expansion)
+	DSN      DSNParams // RFC 3461 parameters given with that RCPT TO
+}
+
+// RecipientSet holds the recipients of one domain type keyed by address
+type RecipientSet map[string]*RecipientInfo
+
+// NewRecipientSet returns a set holding addresses
+func NewRecipientSet(addresses ...string) RecipientSet {
+	s := make(RecipientSet, len(addresses))
+	for _, address := range addresses {
+		s.Add(address)
+	}
+	return s
+}
+
+// Add adds address unless present and returns its entry, reporting whether it
+// was added
+func (s RecipientSet) Add(address string) (*RecipientInfo, bool) {
+	if info, ok := s[address]; ok {
+		return info, false
+	}
+	info := &RecipientInfo{Address: address}
+	s[address] = info
+	return info, true
+}
+
+// Contains reports whether address is in the set
+func (s RecipientSet) Contains(address string) bool {
+	_, ok := s[address]
+	return ok
+}
+
+// Addresses returns the recipient addresses as a plain set
+func (s RecipientSet) Addresses() map[string]struct{} {
+	addresses := make(map[string]struct{}, len(s))
+	for address := range s {
+		addresses[address] = struct{}{}
+	}
+	return addresses
+}
+
+// UnmarshalJSON accepts the address-only form, {"addr": {}}, written before
+// recipients carried details, filling in each entry's address
+func (s *RecipientSet) UnmarshalJSON(data []byte) error {
+	var raw map[string]*RecipientInfo
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	for address, info := range raw {
+		if info == nil {
+			info = &RecipientInfo{}
+			raw[address] = info
+		}
+		info.Address = address
+	}
+	*s = raw
+	return nil
+}
+
+// NotifyOn reports whether the sender asked to be told about event ("SUCCESS",
+// "FAILURE" or "DELAY"). Without NOTIFY only failures are reported.
+func (p DSNParams) NotifyOn(event string) bool {
+	if len(p.Notify) == 0 {
+		return event == "FAILURE"
+	}
+	for _, n := range p.Notify {
+		if n == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Recipient returns the entry for recipient from whichever set holds it
+func (m *Message) Recipient(recipient string) (*RecipientInfo, bool) {
+	for _, set := range []RecipientSet{m.LocalRecipients, m.VirtualRecipients, m.RelayRecipients, m.ExternalRecipients} {
+		if info, ok := set[recipient]; ok {
+			return info, true
+		}
+	}
+	return nil, false
+}
+
+// RecipientDSN returns the DSN parameters given with the RCPT TO that
+// produced recipient
+func (m *Message) RecipientDSN(recipient string) DSNParams {
+	if info, ok := m.Recipient(recipient); ok {
+		return info.DSN
+	}
+	return DSNParams{}
+}
+
 // TotalRecipients returns the total number of recipients across all types
 func (m *Message) TotalRecipients() int {
 	return len(m.LocalRecipients) + len(m.VirtualRecipients) + len(m.RelayRecipients) + len(m.ExternalRecipients)
 }
 
+// OriginalRecipient returns the RCPT TO address that produced recipient
+func (m *Message) OriginalRecipient(recipient string) string {
+	if info, ok := m.Recipient(recipient); ok && info.Original != "" {
+		return info.Original
+	}
+	return recipient
+}
+
+// SetOriginalRecipient records that recipient was reached through the RCPT TO
+// address original; the first address recorded for a recipient is kept
+func (m *Message) SetOriginalRecipient(recipient, original string) {
+	info, ok := m.Recipient(recipient)
+	if !ok || recipient == original || info.Original != "" {
+		return
+	}
+	info.Original = original
+}
+
 // Filename generates the standardized filename for this message
 func (m *Message) Filename() string {
 	timestamp := m.Created.Format("20060102T150405Z")
@@ -1,5 +1,7 @@
 package types
 
+import "path/filepath"
+
 // MessageState represents the lifecycle state of a message in the spool system
 type MessageState string
 
@@ -9,6 +11,7 @@ const (
 	MessageStateFailed     MessageState = "failed"     // Failed delivery attempts
 	MessageStateDelivered  MessageState = "delivered"  // Successfully delivered (archive)
 	MessageStateRetry      MessageState = "retry"      // Outbound messages awaiting retry (metadata JSON files)
+	MessageStateHold       MessageState = "hold"       // Quarantined for review; re-injected on demand
 )
 
 // String returns the string representation of MessageState
@@ -24,5 +27,28 @@ func GetRequiredSpoolDirectories() []MessageState {
 		MessageStateFailed,
 		MessageStateDelivered,
 		MessageStateRetry,
+		MessageStateHold,
+	}
+}
+
+// shardPrefixLen is how many leading message ID characters name a spool shard
+const shardPrefixLen = 2
+
+// Spool locates message files under the spool directory. When Sharded, every
+// state directory keeps each message's files one level down, in a shard named
+// after the start of the message ID (server.spool_sharding). SyncDirs fsyncs
+// directories after renames so they survive a crash (server.spool_sync_dirs).
+type Spool struct {
+	Dir      string
+	Sharded  bool
+	SyncDirs bool
+}
+
+// MessageDir returns the directory holding a message's files in a spool state
+func (s Spool) MessageDir(state MessageState, messageID string) string {
+	dir := filepath.Join(s.Dir, string(state))
+	if s.Sharded && len(messageID) >= shardPrefixLen {
+		dir = filepath.Join(dir, messageID[:shardPrefixLen])
 	}
+	return dir
 }
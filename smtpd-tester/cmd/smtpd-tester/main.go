@@ -28,6 +28,9 @@ func main() {
 		verbose    = flag.Bool("verbose", false, "Enable verbose logging")
 		tests      = flag.String("tests", "", "Comma-separated list of tests to run (use -list-tests to see available tests)")
 		listTests  = flag.Bool("list-tests", false, "List available tests")
+		insecure   = flag.Bool("insecure", false, "Skip TLS certificate verification (self-signed test servers)")
+		reuse      = flag.Bool("reuse", false, "Reuse one connection per worker for multiple messages")
+		rampUp     = flag.Duration("ramp-up", 0, "Stagger worker startup linearly over this duration")
 	)
 	flag.Parse()
 
@@ -69,6 +72,8 @@ func main() {
 			Recipients: recipientList,
 			Subject:    *subject,
 			Timeout:    *timeout,
+
+			InsecureSkipVerify: *insecure,
 		}
 
 		test.ValidateConfig(config)
@@ -144,6 +149,12 @@ func main() {
 	} else {
 		fmt.Printf("Mode: Concurrent (%d workers)\n", *workers)
 	}
+	if *reuse {
+		fmt.Printf("Connections: reused per worker\n")
+	}
+	if *rampUp > 0 {
+		fmt.Printf("Ramp-up: %s\n", *rampUp)
+	}
 	fmt.Printf("Timeout: %s\n", config.Timeout)
 	fmt.Printf("\n")
 
@@ -175,10 +186,12 @@ func main() {
 	}
 
 	opts := client.SendOptions{
-		Messages:   *messages,
-		Workers:    *workers,
-		OnProgress: onProgress,
-		OnMessage:  onMessage,
+		Messages:         *messages,
+		Workers:          *workers,
+		ReuseConnections: *reuse,
+		RampUp:           *rampUp,
+		OnProgress:       onProgress,
+		OnMessage:        onMessage,
 	}
 
 	if err := smtpClient.SendMessages(ctx, opts); err != nil {
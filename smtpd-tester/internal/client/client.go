@@ -3,12 +3,19 @@ package client
 import (
 	"context"
 	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/smtp"
+	"net/textproto"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 )
 
@@ -23,6 +30,9 @@ type Config struct {
 	Recipients []string
 	Subject    string
 	Timeout    time.Duration
+
+	// InsecureSkipVerify disables TLS certificate verification (self-signed test servers)
+	InsecureSkipVerify bool
 }
 
 type Message struct {
@@ -35,10 +45,74 @@ type Message struct {
 }
 
 type Stats struct {
-	Total     int64
-	Success   int64
-	Errors    int64
-	StartTime time.Time
+	Total       int64
+	Success     int64
+	Errors      int64
+	Connections int64 // TCP connections opened
+	StartTime   time.Time
+
+	// Per-message send durations, guarded by latencyMu (workers record concurrently)
+	latencyMu sync.Mutex
+	latencies []time.Duration
+
+	// Failed sends per ErrorCategory, guarded by errorMu
+	errorMu     sync.Mutex
+	errorCounts map[ErrorCategory]int64
+}
+
+// ErrorCategory groups send failures by cause for the final report
+type ErrorCategory string
+
+const (
+	ErrorConnectionRefused ErrorCategory = "connection refused"
+	ErrorTimeout           ErrorCategory = "timeout"
+	ErrorTLS               ErrorCategory = "tls"
+	ErrorSMTP4xx           ErrorCategory = "smtp 4xx"
+	ErrorSMTP5xx           ErrorCategory = "smtp 5xx"
+	ErrorOther             ErrorCategory = "other"
+)
+
+// errorCategories is the report order of ErrorCategory values
+var errorCategories = []ErrorCategory{
+	ErrorConnectionRefused, ErrorTimeout, ErrorTLS, ErrorSMTP4xx, ErrorSMTP5xx, ErrorOther,
+}
+
+// ClassifyError maps a send error to its ErrorCategory by unwrapping it to
+// the SMTP reply, syscall or TLS error underneath
+func ClassifyError(err error) ErrorCategory {
+	var smtpErr *textproto.Error
+	if errors.As(err, &smtpErr) {
+		switch smtpErr.Code / 100 {
+		case 4:
+			return ErrorSMTP4xx
+		case 5:
+			return ErrorSMTP5xx
+		}
+	}
+
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return ErrorConnectionRefused
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout() {
+		return ErrorTimeout
+	}
+
+	var (
+		recordErr  tls.RecordHeaderError
+		alertErr   tls.AlertError
+		verifyErr  *tls.CertificateVerificationError
+		unknownCA  x509.UnknownAuthorityError
+		hostErr    x509.HostnameError
+		invalidErr x509.CertificateInvalidError
+	)
+	if errors.As(err, &recordErr) || errors.As(err, &alertErr) || errors.As(err, &verifyErr) ||
+		errors.As(err, &unknownCA) || errors.As(err, &hostErr) || errors.As(err, &invalidErr) {
+		return ErrorTLS
+	}
+
+	return ErrorOther
 }
 
 func (s *Stats) AddSuccess() {
@@ -49,6 +123,39 @@ func (s *Stats) AddError() {
 	atomic.AddInt64(&s.Errors, 1)
 }
 
+// RecordError counts a failed send both in Errors and under its category
+func (s *Stats) RecordError(err error) {
+	s.AddError()
+
+	category := ClassifyError(err)
+	s.errorMu.Lock()
+	if s.errorCounts == nil {
+		s.errorCounts = make(map[ErrorCategory]int64)
+	}
+	s.errorCounts[category]++
+	s.errorMu.Unlock()
+}
+
+// ErrorCounts returns a copy of the failed send counts per category
+func (s *Stats) ErrorCounts() map[ErrorCategory]int64 {
+	s.errorMu.Lock()
+	defer s.errorMu.Unlock()
+
+	counts := make(map[ErrorCategory]int64, len(s.errorCounts))
+	for category, n := range s.errorCounts {
+		counts[category] = n
+	}
+	return counts
+}
+
+func (s *Stats) AddConnection() {
+	atomic.AddInt64(&s.Connections, 1)
+}
+
+func (s *Stats) GetConnections() int64 {
+	return atomic.LoadInt64(&s.Connections)
+}
+
 func (s *Stats) GetSuccess() int64 {
 	return atomic.LoadInt64(&s.Success)
 }
@@ -64,9 +171,61 @@ func (s *Stats) GetProcessed() int64 {
 func (s *Stats) Reset() {
 	atomic.StoreInt64(&s.Success, 0)
 	atomic.StoreInt64(&s.Errors, 0)
+	atomic.StoreInt64(&s.Connections, 0)
+	s.latencyMu.Lock()
+	s.latencies = nil
+	s.latencyMu.Unlock()
+	s.errorMu.Lock()
+	s.errorCounts = nil
+	s.errorMu.Unlock()
 	s.StartTime = time.Now()
 }
 
+// RecordLatency stores the send duration of a single message
+func (s *Stats) RecordLatency(d time.Duration) {
+	s.latencyMu.Lock()
+	s.latencies = append(s.latencies, d)
+	s.latencyMu.Unlock()
+}
+
+// Percentile returns the p-th percentile (0-100) latency using nearest-rank.
+// Returns 0 when no latencies have been recorded.
+func (s *Stats) Percentile(p float64) time.Duration {
+	sorted := s.sortedLatencies()
+	if len(sorted) == 0 {
+		return 0
+	}
+	if p <= 0 {
+		return sorted[0]
+	}
+	if p >= 100 {
+		return sorted[len(sorted)-1]
+	}
+
+	// Nearest-rank: ceil(p/100 * N), 1-based
+	rank := int(p / 100 * float64(len(sorted)))
+	if float64(rank) < p/100*float64(len(sorted)) {
+		rank++
+	}
+	return sorted[rank-1]
+}
+
+// MaxLatency returns the slowest recorded send duration
+func (s *Stats) MaxLatency() time.Duration {
+	return s.Percentile(100)
+}
+
+// sortedLatencies returns a sorted copy of the recorded latencies
+func (s *Stats) sortedLatencies() []time.Duration {
+	s.latencyMu.Lock()
+	sorted := make([]time.Duration, len(s.latencies))
+	copy(sorted, s.latencies)
+	s.latencyMu.Unlock()
+
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted
+}
+
 type Client struct {
 	config *Config
 	logger *slog.Logger
@@ -124,9 +283,8 @@ Test complete.`, id, time.Now().Format(time.RFC3339), randBytes)
 	}
 }
 
-func (c *Client) SendMessage(ctx context.Context, msg *Message) error {
-	addr := fmt.Sprintf("%s:%d", c.config.Host, c.config.Port)
-
+// buildMessageBody renders msg as an RFC 5322 message
+func buildMessageBody(msg *Message) string {
 	// Use strings.Builder for efficient message construction
 	var builder strings.Builder
 	builder.Grow(len(msg.Subject) + len(msg.From) + len(msg.To) + len(msg.Body) + 200)
@@ -142,13 +300,20 @@ func (c *Client) SendMessage(ctx context.Context, msg *Message) error {
 	builder.WriteString("@example.com>\r\n\r\n")
 	builder.WriteString(msg.Body)
 
-	messageBody := builder.String()
+	return builder.String()
+}
 
-	// Setup authentication if provided
-	var auth smtp.Auth
+// auth returns PLAIN auth when credentials are configured, nil otherwise
+func (c *Client) auth() smtp.Auth {
 	if c.config.User != "" && c.config.Password != "" {
-		auth = smtp.PlainAuth("", c.config.User, c.config.Password, c.config.Host)
+		return smtp.PlainAuth("", c.config.User, c.config.Password, c.config.Host)
 	}
+	return nil
+}
+
+func (c *Client) SendMessage(ctx context.Context, msg *Message) error {
+	addr := fmt.Sprintf("%s:%d", c.config.Host, c.config.Port)
+	messageBody := buildMessageBody(msg)
 
 	// Use context with timeout for the entire operation
 	timeoutCtx, cancel := context.WithTimeout(ctx, c.config.Timeout)
@@ -157,10 +322,13 @@ func (c *Client) SendMessage(ctx context.Context, msg *Message) error {
 	// Channel to capture the result of smtp.SendMail
 	done := make(chan error, 1)
 
+	// smtp.SendMail always opens a fresh connection
+	c.stats.AddConnection()
+
 	go func() {
 		err := smtp.SendMail(
 			addr,
-			auth,
+			c.auth(),
 			msg.From,
 			[]string{msg.To},
 			[]byte(messageBody),
@@ -179,10 +347,87 @@ func (c *Client) SendMessage(ctx context.Context, msg *Message) error {
 	}
 }
 
+// persistentConn is a single SMTP connection reused for several messages
+type persistentConn struct {
+	conn   net.Conn
+	client *smtp.Client
+}
+
+// dial opens a connection, greets the server and authenticates if configured
+func (c *Client) dial(ctx context.Context) (*persistentConn, error) {
+	addr := fmt.Sprintf("%s:%d", c.config.Host, c.config.Port)
+
+	dialer := &net.Dialer{Timeout: c.config.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("connect to %s failed: %w", addr, err)
+	}
+	c.stats.AddConnection()
+	conn.SetDeadline(time.Now().Add(c.config.Timeout))
+
+	sc, err := smtp.NewClient(conn, c.config.Host)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("SMTP greeting failed: %w", err)
+	}
+
+	if err := sc.Hello("smtpd-tester.localhost"); err != nil {
+		sc.Close()
+		return nil, fmt.Errorf("EHLO failed: %w", err)
+	}
+
+	if auth := c.auth(); auth != nil {
+		if err := sc.Auth(auth); err != nil {
+			sc.Close()
+			return nil, fmt.Errorf("AUTH failed: %w", err)
+		}
+	}
+
+	return &persistentConn{conn: conn, client: sc}, nil
+}
+
+// send runs one MAIL/RCPT/DATA transaction followed by RSET on an open connection
+func (pc *persistentConn) send(msg *Message, timeout time.Duration) error {
+	pc.conn.SetDeadline(time.Now().Add(timeout))
+
+	if err := pc.client.Mail(msg.From); err != nil {
+		return fmt.Errorf("send message %d failed: MAIL FROM: %w", msg.ID, err)
+	}
+	if err := pc.client.Rcpt(msg.To); err != nil {
+		return fmt.Errorf("send message %d failed: RCPT TO: %w", msg.ID, err)
+	}
+	w, err := pc.client.Data()
+	if err != nil {
+		return fmt.Errorf("send message %d failed: DATA: %w", msg.ID, err)
+	}
+	if _, err := w.Write([]byte(buildMessageBody(msg))); err != nil {
+		return fmt.Errorf("send message %d failed: write: %w", msg.ID, err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("send message %d failed: %w", msg.ID, err)
+	}
+	if err := pc.client.Reset(); err != nil {
+		return fmt.Errorf("send message %d failed: RSET: %w", msg.ID, err)
+	}
+	return nil
+}
+
+// close sends QUIT and closes the connection
+func (pc *persistentConn) close() {
+	pc.client.Quit()
+	pc.client.Close()
+}
+
 type SendOptions struct {
 	Messages   int
 	Workers    int
 	CustomBody string
+	// ReuseConnections makes each worker keep one connection open and send
+	// all of its messages over it instead of reconnecting per message
+	ReuseConnections bool
+	// RampUp staggers worker startup linearly so concurrency climbs from 1
+	// to Workers over this window instead of starting all at once
+	RampUp     time.Duration
 	OnProgress func(processed, total int64, rate float64)
 	OnMessage  func(msgID int, success bool, err error, duration time.Duration)
 }
@@ -207,21 +452,19 @@ func (c *Client) SendMessages(ctx context.Context, opts SendOptions) error {
 		"messages", opts.Messages,
 		"workers", opts.Workers,
 		"mode", mode,
+		"reuse_connections", opts.ReuseConnections,
+		"ramp_up", opts.RampUp,
 		"recipients", len(c.config.Recipients),
 		"target", fmt.Sprintf("%s:%d", c.config.Host, c.config.Port),
 	)
 
-	// Semaphore channel pattern from go-idioms
-	type token struct{}
-	sem := make(chan token, opts.Workers)
-	var wg sync.WaitGroup
-
 	// Progress reporter goroutine
 	if opts.OnProgress != nil {
 		progressCtx, cancelProgress := context.WithCancel(ctx)
-		wg.Add(1)
+		var progressWg sync.WaitGroup
+		progressWg.Add(1)
 		go func() {
-			defer wg.Done()
+			defer progressWg.Done()
 			ticker := time.NewTicker(time.Second)
 			defer ticker.Stop()
 
@@ -240,9 +483,39 @@ func (c *Client) SendMessages(ctx context.Context, opts SendOptions) error {
 			}
 		}()
 
-		// Ensure progress goroutine is cleaned up
+		// Stop the reporter once all sends are done
 		defer func() {
 			cancelProgress()
+			progressWg.Wait()
+		}()
+	}
+
+	if opts.ReuseConnections {
+		c.sendReusingConnections(ctx, opts)
+		return nil
+	}
+
+	// Semaphore channel pattern from go-idioms
+	type token struct{}
+	sem := make(chan token, opts.Workers)
+	var wg sync.WaitGroup
+
+	// Ramp-up: hold all but one slot and release them one per step
+	if opts.RampUp > 0 && opts.Workers > 1 {
+		for i := 1; i < opts.Workers; i++ {
+			sem <- token{}
+		}
+		rampCtx, cancelRamp := context.WithCancel(ctx)
+		defer cancelRamp()
+		go func() {
+			for i := 1; i < opts.Workers; i++ {
+				select {
+				case <-time.After(rampStep(opts)):
+					<-sem
+				case <-rampCtx.Done():
+					return
+				}
+			}
 		}()
 	}
 
@@ -256,22 +529,9 @@ func (c *Client) SendMessages(ctx context.Context, opts SendOptions) error {
 				wg.Done()
 			}()
 
-			msg := c.generateMessage(msgID, opts.CustomBody)
-
-			start := time.Now()
-			err := c.SendMessage(ctx, msg)
-			duration := time.Since(start)
-
-			success := err == nil
-			if success {
-				c.stats.AddSuccess()
-			} else {
-				c.stats.AddError()
-			}
-
-			if opts.OnMessage != nil {
-				opts.OnMessage(msgID, success, err, duration)
-			}
+			c.sendAndRecord(msgID, opts, func(msg *Message) error {
+				return c.SendMessage(ctx, msg)
+			})
 
 			// Small delay between messages for sequential mode
 			if opts.Workers == 1 {
@@ -284,6 +544,94 @@ func (c *Client) SendMessages(ctx context.Context, opts SendOptions) error {
 	return nil
 }
 
+// sendReusingConnections runs opts.Workers workers, each holding one open
+// connection and pulling message IDs from a shared channel. A failed
+// transaction drops the connection; the worker reconnects for its next message.
+func (c *Client) sendReusingConnections(ctx context.Context, opts SendOptions) {
+	ids := make(chan int)
+	var wg sync.WaitGroup
+
+	for w := 0; w < opts.Workers; w++ {
+		wg.Add(1)
+		go func(startDelay time.Duration) {
+			defer wg.Done()
+
+			if startDelay > 0 {
+				select {
+				case <-time.After(startDelay):
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			var pc *persistentConn
+			defer func() {
+				if pc != nil {
+					pc.close()
+				}
+			}()
+
+			for msgID := range ids {
+				c.sendAndRecord(msgID, opts, func(msg *Message) error {
+					if pc == nil {
+						var err error
+						if pc, err = c.dial(ctx); err != nil {
+							return err
+						}
+					}
+					if err := pc.send(msg, c.config.Timeout); err != nil {
+						pc.client.Close()
+						pc = nil
+						return err
+					}
+					return nil
+				})
+			}
+		}(time.Duration(w) * rampStep(opts))
+	}
+
+feed:
+	for i := 1; i <= opts.Messages; i++ {
+		select {
+		case ids <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(ids)
+
+	wg.Wait()
+}
+
+// rampStep returns the delay between successive worker starts during ramp-up
+func rampStep(opts SendOptions) time.Duration {
+	if opts.RampUp <= 0 || opts.Workers <= 1 {
+		return 0
+	}
+	return opts.RampUp / time.Duration(opts.Workers)
+}
+
+// sendAndRecord generates message msgID, sends it via send and records the outcome
+func (c *Client) sendAndRecord(msgID int, opts SendOptions, send func(msg *Message) error) {
+	msg := c.generateMessage(msgID, opts.CustomBody)
+
+	start := time.Now()
+	err := send(msg)
+	duration := time.Since(start)
+	c.stats.RecordLatency(duration)
+
+	success := err == nil
+	if success {
+		c.stats.AddSuccess()
+	} else {
+		c.stats.RecordError(err)
+	}
+
+	if opts.OnMessage != nil {
+		opts.OnMessage(msgID, success, err, duration)
+	}
+}
+
 func (c *Client) PrintStats() {
 	elapsed := time.Since(c.stats.StartTime)
 	success := c.stats.GetSuccess()
@@ -295,8 +643,24 @@ func (c *Client) PrintStats() {
 	fmt.Printf("Processed: %d\n", total)
 	fmt.Printf("Success: %d (%.1f%%)\n", success, float64(success)/float64(c.stats.Total)*100)
 	fmt.Printf("Errors: %d (%.1f%%)\n", errors, float64(errors)/float64(c.stats.Total)*100)
+	fmt.Printf("Connections opened: %d\n", c.stats.GetConnections())
 	fmt.Printf("Duration: %.2f seconds\n", elapsed.Seconds())
 	if success > 0 && elapsed.Seconds() > 0 {
 		fmt.Printf("Rate: %.1f messages/second\n", float64(success)/elapsed.Seconds())
 	}
+	if total > 0 {
+		fmt.Printf("Latency p50: %s\n", c.stats.Percentile(50))
+		fmt.Printf("Latency p90: %s\n", c.stats.Percentile(90))
+		fmt.Printf("Latency p99: %s\n", c.stats.Percentile(99))
+		fmt.Printf("Latency max: %s\n", c.stats.MaxLatency())
+	}
+	if errors > 0 {
+		counts := c.stats.ErrorCounts()
+		fmt.Printf("\nErrors by category:\n")
+		for _, category := range errorCategories {
+			if n := counts[category]; n > 0 {
+				fmt.Printf("  %-20s %d\n", category, n)
+			}
+		}
+	}
 }
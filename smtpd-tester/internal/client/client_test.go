@@ -0,0 +1,336 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/textproto"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestStatsPercentiles(t *testing.T) {
+	stats := &Stats{}
+
+	// Record 1ms..100ms concurrently to exercise the locking path
+	var wg sync.WaitGroup
+	for i := 1; i <= 100; i++ {
+		wg.Add(1)
+		go func(ms int) {
+			defer wg.Done()
+			stats.RecordLatency(time.Duration(ms) * time.Millisecond)
+		}(i)
+	}
+	wg.Wait()
+
+	tests := []struct {
+		percentile float64
+		want       time.Duration
+	}{
+		{50, 50 * time.Millisecond},
+		{90, 90 * time.Millisecond},
+		{99, 99 * time.Millisecond},
+		{100, 100 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		if got := stats.Percentile(tt.percentile); got != tt.want {
+			t.Errorf("p%.0f: want %s, got %s", tt.percentile, tt.want, got)
+		}
+	}
+
+	if got := stats.MaxLatency(); got != 100*time.Millisecond {
+		t.Errorf("max: want 100ms, got %s", got)
+	}
+}
+
+func TestStatsPercentilesSmallSample(t *testing.T) {
+	stats := &Stats{}
+	for _, d := range []time.Duration{30 * time.Millisecond, 10 * time.Millisecond, 20 * time.Millisecond} {
+		stats.RecordLatency(d)
+	}
+
+	if got := stats.Percentile(50); got != 20*time.Millisecond {
+		t.Errorf("p50: want 20ms, got %s", got)
+	}
+	if got := stats.Percentile(99); got != 30*time.Millisecond {
+		t.Errorf("p99: want 30ms, got %s", got)
+	}
+}
+
+func TestStatsPercentilesEmptyAndReset(t *testing.T) {
+	stats := &Stats{}
+	if got := stats.Percentile(50); got != 0 {
+		t.Errorf("empty stats p50: want 0, got %s", got)
+	}
+
+	stats.RecordLatency(time.Second)
+	stats.Reset()
+	if got := stats.MaxLatency(); got != 0 {
+		t.Errorf("max after reset: want 0, got %s", got)
+	}
+}
+
+// mockSMTPServer is a minimal SMTP responder that counts accepted TCP connections
+type mockSMTPServer struct {
+	ln      net.Listener
+	accepts int64
+	wg      sync.WaitGroup
+
+	// dataDelay slows down each DATA transaction to keep sends in flight
+	dataDelay time.Duration
+	active    int64 // sessions currently open
+	onAccept  func(active int64)
+}
+
+func newMockSMTPServer(t *testing.T) *mockSMTPServer {
+	t.Helper()
+	return startMockSMTPServer(t, &mockSMTPServer{})
+}
+
+func startMockSMTPServer(t *testing.T, srv *mockSMTPServer) *mockSMTPServer {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	srv.ln = ln
+	srv.wg.Add(1)
+	go func() {
+		defer srv.wg.Done()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt64(&srv.accepts, 1)
+			active := atomic.AddInt64(&srv.active, 1)
+			if srv.onAccept != nil {
+				srv.onAccept(active)
+			}
+			srv.wg.Add(1)
+			go func() {
+				defer srv.wg.Done()
+				defer atomic.AddInt64(&srv.active, -1)
+				srv.serve(conn)
+			}()
+		}
+	}()
+
+	t.Cleanup(func() {
+		ln.Close()
+		srv.wg.Wait()
+	})
+	return srv
+}
+
+func (s *mockSMTPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+
+	tp.PrintfLine("220 mock ESMTP")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		switch cmd := strings.ToUpper(strings.Fields(line + " x")[0]); cmd {
+		case "EHLO", "HELO":
+			tp.PrintfLine("250-mock\r\n250 HELP")
+		case "DATA":
+			tp.PrintfLine("354 go ahead")
+			if _, err := tp.ReadDotBytes(); err != nil {
+				return
+			}
+			time.Sleep(s.dataDelay)
+			tp.PrintfLine("250 queued")
+		case "QUIT":
+			tp.PrintfLine("221 bye")
+			return
+		default:
+			tp.PrintfLine("250 OK")
+		}
+	}
+}
+
+func (s *mockSMTPServer) port() int {
+	return s.ln.Addr().(*net.TCPAddr).Port
+}
+
+func TestSendMessagesReuseConnections(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	tests := []struct {
+		name           string
+		reuse          bool
+		maxConnections int64
+		minConnections int64
+	}{
+		{"new connection per message", false, 12, 12},
+		// A worker may never receive a message, so only the upper bound is exact
+		{"reuse connection per worker", true, 3, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newMockSMTPServer(t)
+
+			c := New(&Config{
+				Host:       "127.0.0.1",
+				Port:       srv.port(),
+				From:       "sender@example.com",
+				Recipients: []string{DefaultRecipient},
+				Subject:    "Test",
+				Timeout:    5 * time.Second,
+			}, logger)
+
+			err := c.SendMessages(context.Background(), SendOptions{
+				Messages:         12,
+				Workers:          3,
+				ReuseConnections: tt.reuse,
+			})
+			if err != nil {
+				t.Fatalf("SendMessages failed: %v", err)
+			}
+
+			if got := c.Stats().GetSuccess(); got != 12 {
+				t.Errorf("successful messages: want 12, got %d (errors %d)", got, c.Stats().GetErrors())
+			}
+			// Drain QUIT handling before counting server-side accepts
+			srv.ln.Close()
+			srv.wg.Wait()
+			accepts := atomic.LoadInt64(&srv.accepts)
+			if accepts < tt.minConnections || accepts > tt.maxConnections {
+				t.Errorf("server accepts: want %d..%d, got %d", tt.minConnections, tt.maxConnections, accepts)
+			}
+			if got := c.Stats().GetConnections(); got != accepts {
+				t.Errorf("connections opened: stats report %d, server accepted %d", got, accepts)
+			}
+		})
+	}
+}
+
+func TestSendMessagesRampUp(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	const (
+		workers = 4
+		rampUp  = 400 * time.Millisecond
+	)
+
+	type sample struct {
+		at     time.Duration
+		active int64
+	}
+	var (
+		mu      sync.Mutex
+		samples []sample
+		start   time.Time
+	)
+
+	srv := startMockSMTPServer(t, &mockSMTPServer{
+		dataDelay: 20 * time.Millisecond,
+		onAccept: func(active int64) {
+			mu.Lock()
+			samples = append(samples, sample{at: time.Since(start), active: active})
+			mu.Unlock()
+		},
+	})
+
+	c := New(&Config{
+		Host:       "127.0.0.1",
+		Port:       srv.port(),
+		From:       "sender@example.com",
+		Recipients: []string{DefaultRecipient},
+		Subject:    "Test",
+		Timeout:    5 * time.Second,
+	}, logger)
+
+	mu.Lock()
+	start = time.Now()
+	mu.Unlock()
+	err := c.SendMessages(context.Background(), SendOptions{
+		Messages: 60,
+		Workers:  workers,
+		RampUp:   rampUp,
+	})
+	if err != nil {
+		t.Fatalf("SendMessages failed: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	var earlyMax, lateMax int64
+	for _, s := range samples {
+		switch {
+		case s.at < rampUp/workers:
+			earlyMax = max(earlyMax, s.active)
+		case s.at >= rampUp:
+			lateMax = max(lateMax, s.active)
+		}
+	}
+
+	if earlyMax == 0 || lateMax == 0 {
+		t.Fatalf("not enough samples: early max %d, late max %d (%d samples)", earlyMax, lateMax, len(samples))
+	}
+	if earlyMax >= lateMax {
+		t.Errorf("expected fewer in-flight sends early in ramp: early max %d, late max %d", earlyMax, lateMax)
+	}
+}
+
+func TestStatsErrorCategories(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: &os.SyscallError{Syscall: "connect", Err: syscall.ECONNREFUSED}}
+
+	injected := []error{
+		fmt.Errorf("send message 1 failed: %w", &textproto.Error{Code: 451, Msg: "try later"}),
+		fmt.Errorf("send message 2 failed: RCPT TO: %w", &textproto.Error{Code: 550, Msg: "no such user"}),
+		fmt.Errorf("send message 3 failed: %w", &textproto.Error{Code: 554, Msg: "rejected"}),
+		fmt.Errorf("connect to localhost:2525 failed: %w", refused),
+		fmt.Errorf("timeout sending message 5: %w", context.DeadlineExceeded),
+		fmt.Errorf("send message 6 failed: %w", tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"}),
+		errors.New("something else"),
+	}
+
+	stats := &Stats{}
+	for _, err := range injected {
+		stats.RecordError(err)
+	}
+
+	want := map[ErrorCategory]int64{
+		ErrorSMTP4xx:           1,
+		ErrorSMTP5xx:           2,
+		ErrorConnectionRefused: 1,
+		ErrorTimeout:           1,
+		ErrorTLS:               1,
+		ErrorOther:             1,
+	}
+	got := stats.ErrorCounts()
+	if len(got) != len(want) {
+		t.Errorf("error categories: want %v, got %v", want, got)
+	}
+	for category, n := range want {
+		if got[category] != n {
+			t.Errorf("%s: want %d, got %d", category, n, got[category])
+		}
+	}
+	if got := stats.GetErrors(); got != int64(len(injected)) {
+		t.Errorf("errors: want %d, got %d", len(injected), got)
+	}
+
+	stats.Reset()
+	if got := stats.ErrorCounts(); len(got) != 0 {
+		t.Errorf("error counts after reset: want empty, got %v", got)
+	}
+}
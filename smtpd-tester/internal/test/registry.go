@@ -28,6 +28,16 @@ var Registry = map[string]TestInfo{
 			return SimpleLoadTest(ctx, config, opts, logger)
 		},
 	},
+	"starttls": {
+		Name:        "starttls",
+		Description: "STARTTLS upgrade, AUTH advertisement after TLS, one message over TLS",
+		Func:        STARTTLSTest,
+	},
+	"relay_denied": {
+		Name:        "relay_denied",
+		Description: "RCPT TO an external domain must get a 5xx relay refusal (unauthenticated, and authenticated if -user is set)",
+		Func:        RelayDeniedTest,
+	},
 }
 
 // ListTests returns all available test names
@@ -0,0 +1,104 @@
+package test
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"time"
+
+	"github.com/pawciobiel/golubsmtpd/smtpd-tester/internal/client"
+)
+
+// externalRecipient is a domain the server under test must never treat as local or relay
+const externalRecipient = "user@unrelated-external.com"
+
+// RelayDeniedTest verifies the server refuses to relay to an external domain.
+// The unauthenticated check always runs; the authenticated check runs when
+// credentials are configured and expects the same 5xx refusal, since
+// submission users may not send to external recipients either.
+func RelayDeniedTest(ctx context.Context, config *client.Config, logger *slog.Logger) error {
+	ValidateConfig(config)
+
+	if err := checkRelayDenied(ctx, config, false, logger); err != nil {
+		return fmt.Errorf("unauthenticated: %w", err)
+	}
+
+	if config.User == "" || config.Password == "" {
+		logger.Info("Skipping authenticated relay check (no -user/-password given)")
+		return nil
+	}
+
+	if err := checkRelayDenied(ctx, config, true, logger); err != nil {
+		return fmt.Errorf("authenticated: %w", err)
+	}
+	return nil
+}
+
+// checkRelayDenied runs MAIL FROM + RCPT TO:<externalRecipient> and expects a 5xx to RCPT
+func checkRelayDenied(ctx context.Context, config *client.Config, authenticate bool, logger *slog.Logger) error {
+	addr := fmt.Sprintf("%s:%d", config.Host, config.Port)
+
+	dialer := &net.Dialer{Timeout: config.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("connect to %s failed: %w", addr, err)
+	}
+	conn.SetDeadline(time.Now().Add(config.Timeout))
+
+	c, err := smtp.NewClient(conn, config.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("SMTP greeting failed: %w", err)
+	}
+	defer c.Close()
+
+	if err := c.Hello("smtpd-tester.localhost"); err != nil {
+		return fmt.Errorf("EHLO failed: %w", err)
+	}
+
+	if authenticate {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			tlsConfig := &tls.Config{
+				ServerName:         config.Host,
+				InsecureSkipVerify: config.InsecureSkipVerify, //nolint:gosec — controlled by flag
+			}
+			if err := c.StartTLS(tlsConfig); err != nil {
+				return fmt.Errorf("STARTTLS upgrade failed: %w", err)
+			}
+		}
+		if err := c.Auth(smtp.PlainAuth("", config.User, config.Password, config.Host)); err != nil {
+			return fmt.Errorf("AUTH failed: %w", err)
+		}
+	}
+
+	if err := c.Mail(config.From); err != nil {
+		return fmt.Errorf("MAIL FROM rejected before relay check: %w", err)
+	}
+
+	err = c.Rcpt(externalRecipient)
+	if err == nil {
+		c.Reset()
+		return fmt.Errorf("OPEN RELAY: server accepted RCPT TO:<%s>", externalRecipient)
+	}
+
+	var tpErr *textproto.Error
+	if !errors.As(err, &tpErr) {
+		return fmt.Errorf("RCPT TO failed without an SMTP reply: %w", err)
+	}
+	if tpErr.Code < 500 || tpErr.Code > 599 {
+		return fmt.Errorf("expected 5xx relay refusal, got %d %s", tpErr.Code, tpErr.Msg)
+	}
+
+	logger.Info("Relay correctly denied",
+		"authenticated", authenticate,
+		"recipient", externalRecipient,
+		"code", tpErr.Code,
+		"message", tpErr.Msg)
+
+	return c.Quit()
+}
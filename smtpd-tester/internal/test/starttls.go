@@ -0,0 +1,95 @@
+package test
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/smtp"
+	"time"
+
+	"github.com/pawciobiel/golubsmtpd/smtpd-tester/internal/client"
+)
+
+// STARTTLSTest verifies STARTTLS negotiation end-to-end: STARTTLS must be
+// advertised before the upgrade, AUTH must be advertised after it, and one
+// message must be accepted over the encrypted channel.
+func STARTTLSTest(ctx context.Context, config *client.Config, logger *slog.Logger) error {
+	ValidateConfig(config)
+
+	addr := fmt.Sprintf("%s:%d", config.Host, config.Port)
+
+	dialer := &net.Dialer{Timeout: config.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("connect to %s failed: %w", addr, err)
+	}
+	conn.SetDeadline(time.Now().Add(config.Timeout))
+
+	c, err := smtp.NewClient(conn, config.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("SMTP greeting failed: %w", err)
+	}
+	defer c.Close()
+
+	if err := c.Hello("smtpd-tester.localhost"); err != nil {
+		return fmt.Errorf("EHLO failed: %w", err)
+	}
+
+	if ok, _ := c.Extension("STARTTLS"); !ok {
+		return fmt.Errorf("server at %s does not advertise STARTTLS", addr)
+	}
+	logger.Info("STARTTLS advertised", "target", addr)
+
+	tlsConfig := &tls.Config{
+		ServerName:         config.Host,
+		InsecureSkipVerify: config.InsecureSkipVerify, //nolint:gosec — controlled by flag
+	}
+	// StartTLS re-issues EHLO over the encrypted channel
+	if err := c.StartTLS(tlsConfig); err != nil {
+		return fmt.Errorf("STARTTLS upgrade failed: %w", err)
+	}
+
+	state, _ := c.TLSConnectionState()
+	logger.Info("TLS established",
+		"version", tls.VersionName(state.Version),
+		"cipher_suite", tls.CipherSuiteName(state.CipherSuite))
+
+	ok, mechanisms := c.Extension("AUTH")
+	if !ok {
+		return fmt.Errorf("server does not advertise AUTH after STARTTLS")
+	}
+	logger.Info("AUTH advertised after STARTTLS", "mechanisms", mechanisms)
+
+	if config.User != "" && config.Password != "" {
+		if err := c.Auth(smtp.PlainAuth("", config.User, config.Password, config.Host)); err != nil {
+			return fmt.Errorf("AUTH over TLS failed: %w", err)
+		}
+		logger.Info("AUTH over TLS successful", "user", config.User)
+	}
+
+	if err := c.Mail(config.From); err != nil {
+		return fmt.Errorf("MAIL FROM over TLS failed: %w", err)
+	}
+	if err := c.Rcpt(config.Recipients[0]); err != nil {
+		return fmt.Errorf("RCPT TO over TLS failed: %w", err)
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("DATA over TLS failed: %w", err)
+	}
+	body := fmt.Sprintf("Subject: %s (STARTTLS)\r\nFrom: %s\r\nTo: %s\r\n\r\nSent over STARTTLS by smtpd-tester.\r\n",
+		config.Subject, config.From, config.Recipients[0])
+	if _, err := w.Write([]byte(body)); err != nil {
+		return fmt.Errorf("writing message over TLS failed: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("message rejected over TLS: %w", err)
+	}
+
+	logger.Info("Message sent over STARTTLS", "from", config.From, "to", config.Recipients[0])
+	return c.Quit()
+}